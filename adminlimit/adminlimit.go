@@ -0,0 +1,425 @@
+// Package adminlimit serves an HTTP API for operating a limiter: listing and
+// inspecting keys, and resetting or banning them during incidents. It also
+// lets services that cannot link the library take from the limiter. Every
+// request is authenticated, with bearer tokens or client certificates, and
+// each operation is authorized separately, since resetting limits is
+// privileged:
+//
+//	h, err := adminlimit.New(store, &adminlimit.Config{
+//		Auth: adminlimit.TokenAuth(map[string]*adminlimit.Principal{
+//			os.Getenv("ADMIN_TOKEN"): {Name: "oncall", Role: adminlimit.RoleOperator},
+//		}),
+//	})
+//	mux.Handle("/admin/limiter/", http.StripPrefix("/admin/limiter", h))
+//
+// The API has the endpoints:
+//
+//	GET  /keys?pattern=P&cursor=C  a page of keys, like Redis SCAN
+//	GET  /stats?n=N                the number of keys and the N heaviest, up to 1000
+//	GET  /hotkeys?n=N              the N most active and most limited keys, from Config.HotKeys
+//	POST /take?key=K               take a token from a key, as a client would
+//	GET  /peek?key=K               the state and metadata of a key
+//	POST /reset?key=K              delete a key, so it starts over with full tokens
+//	POST /ban?key=K                take all tokens of a key until its reset
+//	GET  /policies                 the limits the store enforces, from Config.Policies
+//	GET  /openapi.json             the OpenAPI document of the API
+//
+// Responses are JSON. Operations the store does not support respond with 501
+// Not Implemented. Resets and bans can be recorded to an audit log with
+// Config.Audit.
+package adminlimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/hotkeys"
+)
+
+// Operation is an operation of the API.
+type Operation string
+
+// Operations of the API.
+const (
+	OpKeys     Operation = "keys"
+	OpStats    Operation = "stats"
+	OpHotKeys  Operation = "hotkeys"
+	OpTake     Operation = "take"
+	OpPeek     Operation = "peek"
+	OpReset    Operation = "reset"
+	OpBan      Operation = "ban"
+	OpPolicies Operation = "policies"
+)
+
+// Policy describes a limit the store enforces, for clients of the API.
+type Policy struct {
+	// Name identifies the policy, like "default" or "global".
+	Name string
+
+	// Tokens is the number of tokens allowed per interval.
+	Tokens uint64
+
+	// Interval is the interval the tokens are allowed in.
+	Interval time.Duration
+
+	// Description, if set, explains which keys the policy applies to.
+	Description string
+}
+
+// Config is used as input to New.
+type Config struct {
+	// Auth authenticates every request. It is required.
+	Auth AuthFunc
+
+	// Authorize reports whether the principal may perform the operation. The
+	// default value is DefaultAuthorize.
+	Authorize func(p *Principal, op Operation) bool
+
+	// Audit, if set, records every reset and ban, including those that were
+	// forbidden or failed. See JSONAudit.
+	Audit AuditFunc
+
+	// Policies are served at GET /policies. The store cannot report its own
+	// limits, so they are listed here by whoever configured it.
+	Policies []Policy
+
+	// HotKeys, if set, serves its report at GET /hotkeys. It should wrap the
+	// store the applications take from, so it sees their takes. Without it,
+	// the endpoint responds with 501.
+	HotKeys *hotkeys.Store
+}
+
+// handler serves the API.
+type handler struct {
+	store     limiter.Store
+	auth      AuthFunc
+	authorize func(p *Principal, op Operation) bool
+	audit     AuditFunc
+	policies  []policyResult
+	hotKeys   *hotkeys.Store
+}
+
+// New creates a handler for the API on s.
+func New(s limiter.Store, c *Config) (http.Handler, error) {
+	if s == nil {
+		return nil, fmt.Errorf("store cannot be nil")
+	}
+	if c == nil || c.Auth == nil {
+		return nil, fmt.Errorf("auth is required")
+	}
+
+	authorize := DefaultAuthorize
+	if c.Authorize != nil {
+		authorize = c.Authorize
+	}
+
+	policies := make([]policyResult, 0, len(c.Policies))
+	for _, p := range c.Policies {
+		policies = append(policies, policyResult{
+			Name:            p.Name,
+			Tokens:          p.Tokens,
+			IntervalSeconds: p.Interval.Seconds(),
+			Description:     p.Description,
+		})
+	}
+
+	return &handler{
+		store:     s,
+		auth:      c.Auth,
+		authorize: authorize,
+		audit:     c.Audit,
+		policies:  policies,
+		hotKeys:   c.HotKeys,
+	}, nil
+}
+
+// keyResult is the state of a key in responses.
+type keyResult struct {
+	Key       string    `json:"key"`
+	Limit     uint64    `json:"limit"`
+	Remaining uint64    `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
+	Metadata  string    `json:"metadata,omitempty"`
+}
+
+// takeResult is the result of a take in responses.
+type takeResult struct {
+	keyResult
+	Allowed           bool    `json:"allowed"`
+	RetryAfterSeconds float64 `json:"retry_after_seconds,omitempty"`
+}
+
+// policyResult is a policy in responses.
+type policyResult struct {
+	Name            string  `json:"name"`
+	Tokens          uint64  `json:"tokens"`
+	IntervalSeconds float64 `json:"interval_seconds"`
+	Description     string  `json:"description,omitempty"`
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	op, method := Operation(""), ""
+	switch r.URL.Path {
+	case "/openapi.json":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = io.WriteString(w, OpenAPI)
+		return
+	case "/keys":
+		op, method = OpKeys, http.MethodGet
+	case "/stats":
+		op, method = OpStats, http.MethodGet
+	case "/hotkeys":
+		op, method = OpHotKeys, http.MethodGet
+	case "/take":
+		op, method = OpTake, http.MethodPost
+	case "/peek":
+		op, method = OpPeek, http.MethodGet
+	case "/reset":
+		op, method = OpReset, http.MethodPost
+	case "/ban":
+		op, method = OpBan, http.MethodPost
+	case "/policies":
+		op, method = OpPolicies, http.MethodGet
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	p, err := h.auth(r)
+	if err != nil {
+		if errors.Is(err, ErrUnauthenticated) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="limiter"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !h.authorize(p, op) {
+		msg := fmt.Sprintf("%s may not %s", p.Name, op)
+		if err := h.record(r, p, op, nil, nil, fmt.Errorf("forbidden")); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeError(w, http.StatusForbidden, msg)
+		return
+	}
+
+	v, err := h.do(r.Context(), op, p, r)
+	if err != nil {
+		var status int
+		switch {
+		case errors.Is(err, limiter.ErrNotSupported):
+			status = http.StatusNotImplemented
+		case errors.Is(err, errBadRequest):
+			status = http.StatusBadRequest
+		default:
+			status = http.StatusInternalServerError
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+// maxTop is the most heaviest keys a stats or hotkeys request returns.
+const maxTop = 1000
+
+// errBadRequest is wrapped by errors in the request's parameters.
+var errBadRequest = errors.New("bad request")
+
+// do performs the operation and returns the response.
+func (h *handler) do(ctx context.Context, op Operation, p *Principal, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+
+	switch op {
+	case OpKeys:
+		inspector, ok := h.store.(limiter.Inspector)
+		if !ok {
+			return nil, limiter.ErrNotSupported
+		}
+		pattern := q.Get("pattern")
+		if pattern == "" {
+			pattern = "*"
+		}
+		cursor, err := uintParam(q.Get("cursor"), 0)
+		if err != nil {
+			return nil, err
+		}
+		keys, next, err := inspector.Keys(ctx, pattern, cursor)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list keys: %w", err)
+		}
+		if keys == nil {
+			keys = []string{}
+		}
+		return map[string]interface{}{"keys": keys, "cursor": next}, nil
+
+	case OpStats:
+		inspector, ok := h.store.(limiter.Inspector)
+		if !ok {
+			return nil, limiter.ErrNotSupported
+		}
+		n, err := uintParam(q.Get("n"), 10)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxTop {
+			n = maxTop
+		}
+		stats, err := inspector.Stats(ctx, int(n))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get stats: %w", err)
+		}
+		top := make([]map[string]interface{}, 0, len(stats.Top))
+		for _, k := range stats.Top {
+			top = append(top, map[string]interface{}{"key": k.Key, "remaining": k.Remaining})
+		}
+		return map[string]interface{}{"keys": stats.Keys, "top": top}, nil
+
+	case OpHotKeys:
+		if h.hotKeys == nil {
+			return nil, limiter.ErrNotSupported
+		}
+		n, err := uintParam(q.Get("n"), 10)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxTop {
+			n = maxTop
+		}
+		report := h.hotKeys.Top(int(n))
+		return map[string]interface{}{
+			"active":  newKeyCounts(report.Active),
+			"limited": newKeyCounts(report.Limited),
+		}, nil
+
+	case OpPolicies:
+		return map[string]interface{}{"policies": h.policies}, nil
+	}
+
+	key := q.Get("key")
+	if key == "" {
+		return nil, fmt.Errorf("%w: key is required", errBadRequest)
+	}
+
+	if op == OpTake {
+		res, err := h.store.Take(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to take: %w", err)
+		}
+		return &takeResult{
+			keyResult:         *newKeyResult(key, res),
+			Allowed:           res.Allowed,
+			RetryAfterSeconds: res.RetryAfter.Seconds(),
+		}, nil
+	}
+
+	peeker, ok := h.store.(limiter.Peeker)
+	if !ok {
+		return nil, limiter.ErrNotSupported
+	}
+	res, err := peeker.Peek(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to peek: %w", err)
+	}
+
+	if op == OpPeek {
+		return newKeyResult(key, res), nil
+	}
+
+	prev := newKeyResult(key, res)
+	cur, err := h.change(ctx, op, key, res.Limit)
+	if auditErr := h.record(r, p, op, prev, cur, err); auditErr != nil {
+		return nil, auditErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cur, nil
+}
+
+// change resets or bans the key, and returns its new state.
+func (h *handler) change(ctx context.Context, op Operation, key string, limit uint64) (*keyResult, error) {
+	switch op {
+	case OpReset:
+		deleter, ok := h.store.(limiter.Deleter)
+		if !ok {
+			return nil, limiter.ErrNotSupported
+		}
+		if err := deleter.Delete(ctx, key); err != nil {
+			return nil, fmt.Errorf("failed to reset: %w", err)
+		}
+	case OpBan:
+		charger, ok := h.store.(limiter.Charger)
+		if !ok {
+			return nil, limiter.ErrNotSupported
+		}
+		if err := charger.Charge(ctx, key, limit); err != nil {
+			return nil, fmt.Errorf("failed to ban: %w", err)
+		}
+	}
+
+	res, err := h.store.(limiter.Peeker).Peek(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to peek: %w", err)
+	}
+	return newKeyResult(key, res), nil
+}
+
+func newKeyCounts(counts []hotkeys.KeyCount) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(counts))
+	for _, c := range counts {
+		out = append(out, map[string]interface{}{"key": c.Key, "count": c.Count, "error": c.Error})
+	}
+	return out
+}
+
+func newKeyResult(key string, res limiter.Result) *keyResult {
+	return &keyResult{
+		Key:       key,
+		Limit:     res.Limit,
+		Remaining: res.Remaining,
+		ResetAt:   res.ResetAt,
+		Metadata:  res.Metadata,
+	}
+}
+
+// uintParam parses a numeric query parameter, or returns def if it is empty.
+func uintParam(s string, def uint64) (uint64, error) {
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid number %q", errBadRequest, s)
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
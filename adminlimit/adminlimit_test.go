@@ -0,0 +1,437 @@
+package adminlimit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/adminlimit"
+	"github.com/sethvargo/go-limiter/hotkeys"
+	"github.com/sethvargo/go-limiter/limittest"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+// opaqueStore hides the optional interfaces of the store it wraps.
+type opaqueStore struct {
+	limiter.Store
+}
+
+var testTokens = map[string]*adminlimit.Principal{
+	"read-token": {Name: "reader", Role: adminlimit.RoleReader},
+	"op-token":   {Name: "oncall", Role: adminlimit.RoleOperator},
+	"app-token":  {Name: "app", Role: adminlimit.RoleClient},
+}
+
+func newHandler(tb testing.TB, s limiter.Store) http.Handler {
+	tb.Helper()
+
+	h, err := adminlimit.New(s, &adminlimit.Config{
+		Auth: adminlimit.TokenAuth(testTokens),
+		Policies: []adminlimit.Policy{
+			{Name: "default", Tokens: 5, Interval: time.Minute},
+		},
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return h
+}
+
+func serve(h http.Handler, method, target, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	if _, err := adminlimit.New(nil, &adminlimit.Config{Auth: adminlimit.TokenAuth(nil)}); err == nil {
+		t.Error("expected error for nil store")
+	}
+	if _, err := adminlimit.New(limittest.NewStore(5, time.Minute), nil); err == nil {
+		t.Error("expected error for missing auth")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		method    string
+		target    string
+		token     string
+		opaque    bool
+		status    int
+		isKey     bool
+		remaining uint64
+	}{
+		{
+			name:   "no_token",
+			method: http.MethodGet,
+			target: "/peek?key=key",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "invalid_token",
+			method: http.MethodGet,
+			target: "/peek?key=key",
+			token:  "nope",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:      "peek",
+			method:    http.MethodGet,
+			target:    "/peek?key=key",
+			token:     "read-token",
+			status:    http.StatusOK,
+			isKey:     true,
+			remaining: 2,
+		},
+		{
+			name:      "take",
+			method:    http.MethodPost,
+			target:    "/take?key=key",
+			token:     "app-token",
+			status:    http.StatusOK,
+			isKey:     true,
+			remaining: 1,
+		},
+		{
+			name:   "take_reader",
+			method: http.MethodPost,
+			target: "/take?key=key",
+			token:  "read-token",
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "peek_client",
+			method: http.MethodGet,
+			target: "/peek?key=key",
+			token:  "app-token",
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "peek_no_key",
+			method: http.MethodGet,
+			target: "/peek",
+			token:  "read-token",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "reset_reader",
+			method: http.MethodPost,
+			target: "/reset?key=key",
+			token:  "read-token",
+			status: http.StatusForbidden,
+		},
+		{
+			name:      "reset",
+			method:    http.MethodPost,
+			target:    "/reset?key=key",
+			token:     "op-token",
+			status:    http.StatusOK,
+			isKey:     true,
+			remaining: 5,
+		},
+		{
+			name:   "reset_get",
+			method: http.MethodGet,
+			target: "/reset?key=key",
+			token:  "op-token",
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:      "ban",
+			method:    http.MethodPost,
+			target:    "/ban?key=key",
+			token:     "op-token",
+			status:    http.StatusOK,
+			isKey:     true,
+			remaining: 0,
+		},
+		{
+			name:   "keys",
+			method: http.MethodGet,
+			target: "/keys",
+			token:  "read-token",
+			status: http.StatusOK,
+		},
+		{
+			name:   "stats",
+			method: http.MethodGet,
+			target: "/stats?n=1",
+			token:  "read-token",
+			status: http.StatusOK,
+		},
+		{
+			name:   "stats_invalid",
+			method: http.MethodGet,
+			target: "/stats?n=many",
+			token:  "read-token",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "not_supported",
+			method: http.MethodGet,
+			target: "/keys",
+			token:  "read-token",
+			opaque: true,
+			status: http.StatusNotImplemented,
+		},
+		{
+			name:   "not_found",
+			method: http.MethodGet,
+			target: "/flush",
+			token:  "op-token",
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ls := limittest.NewStore(5, time.Minute)
+			if err := ls.Charge(context.Background(), "key", 3); err != nil {
+				t.Fatal(err)
+			}
+
+			var s limiter.Store = ls
+			if tc.opaque {
+				s = opaqueStore{s}
+			}
+
+			w := serve(newHandler(t, s), tc.method, tc.target, tc.token)
+			if got, want := w.Code, tc.status; got != want {
+				t.Fatalf("expected %d to be %d: %s", got, want, w.Body.String())
+			}
+			if !tc.isKey {
+				return
+			}
+
+			var body struct {
+				Key       string `json:"key"`
+				Remaining uint64 `json:"remaining"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := body.Remaining, tc.remaining; got != want {
+				t.Errorf("expected %d to be %d", got, want)
+			}
+		})
+	}
+}
+
+func TestHandler_Reset(t *testing.T) {
+	t.Parallel()
+
+	s, err := memorystore.New(&memorystore.Config{
+		Tokens:       5,
+		Interval:     time.Minute,
+		GlobalTokens: 6,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := s.Take(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := serve(newHandler(t, s), http.MethodPost, "/reset?key=key", "op-token")
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("expected %d to be %d: %s", got, want, w.Body.String())
+	}
+
+	// The key is deleted, and the tokens it took from the global bucket are
+	// not given back.
+	res, err := s.Take(ctx, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Remaining, uint64(2); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+func TestHandler_Stats(t *testing.T) {
+	t.Parallel()
+
+	s := limittest.NewStore(5, time.Minute)
+	if err := s.Charge(context.Background(), "key", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(newHandler(t, s), http.MethodGet, "/stats?n=5", "read-token")
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("expected %d to be %d", got, want)
+	}
+
+	var body struct {
+		Keys uint64 `json:"keys"`
+		Top  []struct {
+			Key       string `json:"key"`
+			Remaining uint64 `json:"remaining"`
+		} `json:"top"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body.Keys, uint64(1); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+	if len(body.Top) != 1 || body.Top[0].Key != "key" || body.Top[0].Remaining != 2 {
+		t.Errorf("unexpected top keys %+v", body.Top)
+	}
+}
+
+func TestHandler_HotKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	hk, err := hotkeys.New(limittest.NewStore(2, time.Minute), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"hot", "hot", "hot", "cold"} {
+		if _, err := hk.Take(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err := adminlimit.New(hk, &adminlimit.Config{
+		Auth:    adminlimit.TokenAuth(testTokens),
+		HotKeys: hk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(h, http.MethodGet, "/hotkeys?n=1", "read-token")
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("expected %d to be %d: %s", got, want, w.Body.String())
+	}
+
+	type keyCount struct {
+		Key   string `json:"key"`
+		Count uint64 `json:"count"`
+	}
+	var body struct {
+		Active  []keyCount `json:"active"`
+		Limited []keyCount `json:"limited"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body.Active, []keyCount{{Key: "hot", Count: 3}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v to be %v", got, want)
+	}
+	if got, want := body.Limited, []keyCount{{Key: "hot", Count: 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v to be %v", got, want)
+	}
+
+	// Without a tracker, the endpoint is not supported.
+	w = serve(newHandler(t, hk), http.MethodGet, "/hotkeys", "read-token")
+	if got, want := w.Code, http.StatusNotImplemented; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+func TestHandler_Policies(t *testing.T) {
+	t.Parallel()
+
+	h := newHandler(t, limittest.NewStore(5, time.Minute))
+
+	w := serve(h, http.MethodGet, "/policies", "app-token")
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("expected %d to be %d: %s", got, want, w.Body.String())
+	}
+
+	var body struct {
+		Policies []struct {
+			Name            string  `json:"name"`
+			Tokens          uint64  `json:"tokens"`
+			IntervalSeconds float64 `json:"interval_seconds"`
+		} `json:"policies"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(body.Policies), 1; got != want {
+		t.Fatalf("expected %d to be %d", got, want)
+	}
+	p := body.Policies[0]
+	if got, want := p.Name, "default"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if got, want := p.Tokens, uint64(5); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+	if got, want := p.IntervalSeconds, 60.0; got != want {
+		t.Errorf("expected %v to be %v", got, want)
+	}
+}
+
+func TestHandler_OpenAPI(t *testing.T) {
+	t.Parallel()
+
+	h := newHandler(t, limittest.NewStore(5, time.Minute))
+
+	// The document is served without authentication.
+	w := serve(h, http.MethodGet, "/openapi.json", "")
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("expected %d to be %d", got, want)
+	}
+
+	var doc struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI == "" {
+		t.Error("expected openapi version")
+	}
+
+	// Every endpoint is documented with the method it is served with.
+	for path, method := range map[string]string{
+		"/keys":     http.MethodGet,
+		"/stats":    http.MethodGet,
+		"/hotkeys":  http.MethodGet,
+		"/take":     http.MethodPost,
+		"/peek":     http.MethodGet,
+		"/reset":    http.MethodPost,
+		"/ban":      http.MethodPost,
+		"/policies": http.MethodGet,
+	} {
+		if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+			t.Errorf("expected %s %s to be documented", method, path)
+		}
+		if got := serve(h, method, path, "op-token").Code; got == http.StatusNotFound || got == http.StatusMethodNotAllowed {
+			t.Errorf("expected %s %s to be served, got %d", method, path, got)
+		}
+	}
+	if got, want := len(doc.Paths), 8; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
@@ -0,0 +1,107 @@
+package adminlimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AuditEvent is a record of an administrative action.
+type AuditEvent struct {
+	// Time is when the action was performed.
+	Time time.Time `json:"time"`
+
+	// Principal is who performed it, and Role their role.
+	Principal string `json:"principal"`
+	Role      string `json:"role"`
+
+	// Operation is the action, like "reset", and Key the key it was performed
+	// on.
+	Operation Operation `json:"operation"`
+	Key       string    `json:"key"`
+
+	// Previous is the state of the key before the action, and Current the state
+	// after it. Previous is nil if the action was forbidden, and Current is nil
+	// if it failed.
+	Previous *KeyState `json:"previous,omitempty"`
+	Current  *KeyState `json:"current,omitempty"`
+
+	// RemoteAddr is the network address the request came from.
+	RemoteAddr string `json:"remote_addr"`
+
+	// Error is why the action was not performed, if it was not.
+	Error string `json:"error,omitempty"`
+}
+
+// KeyState is the state of a key in an audit event.
+type KeyState struct {
+	Limit     uint64    `json:"limit"`
+	Remaining uint64    `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
+}
+
+// AuditFunc records an audit event. If it returns an error, the request fails
+// with the error, so actions are not performed silently while the audit log is
+// unavailable. Actions that were already performed are not undone.
+type AuditFunc func(e *AuditEvent) error
+
+// JSONAudit returns an AuditFunc that writes each event to w as a line of
+// JSON, like a file opened for appending. It is safe for concurrent use.
+func JSONAudit(w io.Writer) AuditFunc {
+	var lock sync.Mutex
+	return func(e *AuditEvent) error {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit event: %w", err)
+		}
+		b = append(b, '\n')
+
+		lock.Lock()
+		defer lock.Unlock()
+		if _, err := w.Write(b); err != nil {
+			return fmt.Errorf("failed to write audit event: %w", err)
+		}
+		return nil
+	}
+}
+
+// record records the action, if it changes a key and an AuditFunc is set.
+func (h *handler) record(r *http.Request, p *Principal, op Operation, prev, cur *keyResult, err error) error {
+	if h.audit == nil || (op != OpReset && op != OpBan) {
+		return nil
+	}
+
+	e := &AuditEvent{
+		Time:       time.Now().UTC(),
+		Principal:  p.Name,
+		Role:       p.Role.String(),
+		Operation:  op,
+		Key:        r.URL.Query().Get("key"),
+		Previous:   prev.state(),
+		Current:    cur.state(),
+		RemoteAddr: r.RemoteAddr,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	if err := h.audit(e); err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+// state returns the result as the state of an audit event.
+func (k *keyResult) state() *KeyState {
+	if k == nil {
+		return nil
+	}
+	return &KeyState{
+		Limit:     k.Limit,
+		Remaining: k.Remaining,
+		ResetAt:   k.ResetAt,
+	}
+}
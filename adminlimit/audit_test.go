@@ -0,0 +1,102 @@
+package adminlimit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/adminlimit"
+	"github.com/sethvargo/go-limiter/limittest"
+)
+
+func TestAudit(t *testing.T) {
+	t.Parallel()
+
+	s := limittest.NewStore(5, time.Minute)
+	if err := s.Charge(context.Background(), "key", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	h, err := adminlimit.New(s, &adminlimit.Config{
+		Auth:  adminlimit.TokenAuth(testTokens),
+		Audit: adminlimit.JSONAudit(&buf),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads are not recorded, and the forbidden reset is.
+	serve(h, http.MethodGet, "/peek?key=key", "read-token")
+	serve(h, http.MethodPost, "/reset?key=key", "read-token")
+	serve(h, http.MethodPost, "/reset?key=key", "op-token")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if got, want := len(lines), 2; got != want {
+		t.Fatalf("expected %d events to be %d: %s", got, want, buf.String())
+	}
+
+	var forbidden, reset adminlimit.AuditEvent
+	if err := json.Unmarshal([]byte(lines[0]), &forbidden); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &reset); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := forbidden.Principal, "reader"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if forbidden.Error == "" || forbidden.Previous != nil {
+		t.Errorf("expected forbidden event, got %+v", forbidden)
+	}
+
+	if got, want := reset.Principal, "oncall"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if got, want := reset.Role, "operator"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if got, want := reset.Operation, adminlimit.OpReset; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if got, want := reset.Key, "key"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if reset.Time.IsZero() {
+		t.Error("expected time")
+	}
+	if reset.Previous == nil || reset.Current == nil {
+		t.Fatalf("expected previous and current state, got %+v", reset)
+	}
+	if got, want := reset.Previous.Remaining, uint64(2); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+	if got, want := reset.Current.Remaining, uint64(5); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+func TestAudit_Failure(t *testing.T) {
+	t.Parallel()
+
+	h, err := adminlimit.New(limittest.NewStore(5, time.Minute), &adminlimit.Config{
+		Auth: adminlimit.TokenAuth(testTokens),
+		Audit: func(e *adminlimit.AuditEvent) error {
+			return fmt.Errorf("audit log is down")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(h, http.MethodPost, "/ban?key=key", "op-token")
+	if got, want := w.Code, http.StatusInternalServerError; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
@@ -0,0 +1,144 @@
+package adminlimit
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrUnauthenticated is returned by an AuthFunc when the request has no valid
+// credentials.
+var ErrUnauthenticated = errors.New("request is not authenticated")
+
+// Role is what a principal may do.
+type Role int
+
+const (
+	// RoleReader may list, inspect, and peek at keys.
+	RoleReader Role = iota + 1
+
+	// RoleOperator may also reset and ban keys, which change their limits.
+	RoleOperator
+
+	// RoleClient may take from keys, like the applications that share the
+	// limiter, and list the policies.
+	RoleClient
+)
+
+// String returns the name of the role, like "operator".
+func (r Role) String() string {
+	switch r {
+	case RoleReader:
+		return "reader"
+	case RoleOperator:
+		return "operator"
+	case RoleClient:
+		return "client"
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// Principal is the authenticated caller of an operation.
+type Principal struct {
+	// Name identifies the caller, like the name of a token or the common name of
+	// a client certificate.
+	Name string
+
+	// Role is what the caller may do.
+	Role Role
+}
+
+// AuthFunc authenticates a request. It returns ErrUnauthenticated, or an error
+// wrapping it, if the request has no valid credentials.
+type AuthFunc func(r *http.Request) (*Principal, error)
+
+// TokenAuth authenticates requests by a bearer token in the Authorization
+// header, as in "Authorization: Bearer TOKEN". tokens maps each token to its
+// principal. Tokens are compared in constant time.
+func TokenAuth(tokens map[string]*Principal) AuthFunc {
+	type entry struct {
+		sum       [sha256.Size]byte
+		principal *Principal
+	}
+
+	// Comparing hashes keeps the comparisons constant-time without leaking the
+	// tokens' lengths.
+	entries := make([]entry, 0, len(tokens))
+	for token, p := range tokens {
+		entries = append(entries, entry{sum: sha256.Sum256([]byte(token)), principal: p})
+	}
+
+	return func(r *http.Request) (*Principal, error) {
+		h := r.Header.Get("Authorization")
+		if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+			return nil, ErrUnauthenticated
+		}
+		sum := sha256.Sum256([]byte(strings.TrimSpace(h[7:])))
+
+		var found *Principal
+		for _, e := range entries {
+			if subtle.ConstantTimeCompare(sum[:], e.sum[:]) == 1 {
+				found = e.principal
+			}
+		}
+		if found == nil {
+			return nil, ErrUnauthenticated
+		}
+		return found, nil
+	}
+}
+
+// TLSAuth authenticates requests by their verified client certificate, for
+// servers that require mutual TLS. roles maps the common name of each allowed
+// certificate to its role. The server's tls.Config must verify client
+// certificates, with ClientAuth set to tls.RequireAndVerifyClientCert or
+// tls.VerifyClientCertIfGiven; unverified certificates are not trusted.
+func TLSAuth(roles map[string]Role) AuthFunc {
+	return func(r *http.Request) (*Principal, error) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			return nil, ErrUnauthenticated
+		}
+
+		name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		role, ok := roles[name]
+		if !ok {
+			return nil, ErrUnauthenticated
+		}
+		return &Principal{Name: name, Role: role}, nil
+	}
+}
+
+// AnyAuth authenticates requests with the first of fs that accepts them, so an
+// API can take both tokens and client certificates.
+func AnyAuth(fs ...AuthFunc) AuthFunc {
+	return func(r *http.Request) (*Principal, error) {
+		for _, f := range fs {
+			p, err := f(r)
+			if err == nil {
+				return p, nil
+			}
+			if !errors.Is(err, ErrUnauthenticated) {
+				return nil, err
+			}
+		}
+		return nil, ErrUnauthenticated
+	}
+}
+
+// DefaultAuthorize lets readers perform the read-only operations, clients take
+// and list the policies, and operators perform every operation.
+func DefaultAuthorize(p *Principal, op Operation) bool {
+	switch op {
+	case OpKeys, OpStats, OpHotKeys, OpPeek:
+		return p.Role == RoleReader || p.Role == RoleOperator
+	case OpTake:
+		return p.Role == RoleClient || p.Role == RoleOperator
+	case OpPolicies:
+		return p.Role == RoleReader || p.Role == RoleClient || p.Role == RoleOperator
+	default:
+		return p.Role == RoleOperator
+	}
+}
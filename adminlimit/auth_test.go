@@ -0,0 +1,169 @@
+package adminlimit_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sethvargo/go-limiter/adminlimit"
+)
+
+func withCert(r *http.Request, cn string) *http.Request {
+	r.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{
+			{{Subject: pkix.Name{CommonName: cn}}},
+		},
+	}
+	return r
+}
+
+func TestTokenAuth(t *testing.T) {
+	t.Parallel()
+
+	auth := adminlimit.TokenAuth(testTokens)
+
+	cases := []struct {
+		name   string
+		header string
+		exp    string
+	}{
+		{
+			name:   "reader",
+			header: "Bearer read-token",
+			exp:    "reader",
+		},
+		{
+			name:   "case_insensitive_scheme",
+			header: "bearer op-token",
+			exp:    "oncall",
+		},
+		{
+			name:   "basic",
+			header: "Basic b3AtdG9rZW4=",
+		},
+		{
+			name:   "unknown",
+			header: "Bearer op-token2",
+		},
+		{
+			name: "missing",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				r.Header.Set("Authorization", tc.header)
+			}
+
+			p, err := auth(r)
+			if tc.exp == "" {
+				if !errors.Is(err, adminlimit.ErrUnauthenticated) {
+					t.Fatalf("expected %v to be %v", err, adminlimit.ErrUnauthenticated)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := p.Name, tc.exp; got != want {
+				t.Errorf("expected %q to be %q", got, want)
+			}
+		})
+	}
+}
+
+func TestTLSAuth(t *testing.T) {
+	t.Parallel()
+
+	auth := adminlimit.TLSAuth(map[string]adminlimit.Role{
+		"oncall.example.com": adminlimit.RoleOperator,
+	})
+
+	p, err := auth(withCert(httptest.NewRequest(http.MethodGet, "/", nil), "oncall.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.Role, adminlimit.RoleOperator; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	if _, err := auth(withCert(httptest.NewRequest(http.MethodGet, "/", nil), "other")); !errors.Is(err, adminlimit.ErrUnauthenticated) {
+		t.Errorf("expected %v to be %v", err, adminlimit.ErrUnauthenticated)
+	}
+	if _, err := auth(httptest.NewRequest(http.MethodGet, "/", nil)); !errors.Is(err, adminlimit.ErrUnauthenticated) {
+		t.Errorf("expected %v to be %v", err, adminlimit.ErrUnauthenticated)
+	}
+}
+
+func TestAnyAuth(t *testing.T) {
+	t.Parallel()
+
+	auth := adminlimit.AnyAuth(
+		adminlimit.TLSAuth(map[string]adminlimit.Role{"ci": adminlimit.RoleReader}),
+		adminlimit.TokenAuth(testTokens),
+	)
+
+	r := withCert(httptest.NewRequest(http.MethodGet, "/", nil), "ci")
+	p, err := auth(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.Name, "ci"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer op-token")
+	if p, err = auth(r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.Name, "oncall"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
+
+func TestDefaultAuthorize(t *testing.T) {
+	t.Parallel()
+
+	reader := &adminlimit.Principal{Name: "reader", Role: adminlimit.RoleReader}
+	operator := &adminlimit.Principal{Name: "oncall", Role: adminlimit.RoleOperator}
+
+	for _, op := range []adminlimit.Operation{adminlimit.OpKeys, adminlimit.OpStats, adminlimit.OpPeek} {
+		if !adminlimit.DefaultAuthorize(reader, op) {
+			t.Errorf("expected reader to be allowed to %s", op)
+		}
+	}
+	for _, op := range []adminlimit.Operation{adminlimit.OpReset, adminlimit.OpBan} {
+		if adminlimit.DefaultAuthorize(reader, op) {
+			t.Errorf("expected reader not to be allowed to %s", op)
+		}
+		if !adminlimit.DefaultAuthorize(operator, op) {
+			t.Errorf("expected operator to be allowed to %s", op)
+		}
+	}
+
+	client := &adminlimit.Principal{Name: "app", Role: adminlimit.RoleClient}
+	for _, op := range []adminlimit.Operation{adminlimit.OpTake, adminlimit.OpPolicies} {
+		if !adminlimit.DefaultAuthorize(client, op) {
+			t.Errorf("expected client to be allowed to %s", op)
+		}
+	}
+	for _, op := range []adminlimit.Operation{adminlimit.OpKeys, adminlimit.OpPeek, adminlimit.OpReset} {
+		if adminlimit.DefaultAuthorize(client, op) {
+			t.Errorf("expected client not to be allowed to %s", op)
+		}
+	}
+	if adminlimit.DefaultAuthorize(reader, adminlimit.OpTake) {
+		t.Error("expected reader not to be allowed to take")
+	}
+}
@@ -0,0 +1,218 @@
+package adminlimit
+
+// OpenAPI is the OpenAPI 3 document describing the API, which the handler
+// serves at GET /openapi.json without authentication, so other teams can
+// generate clients for it.
+const OpenAPI = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "go-limiter admin API",
+    "description": "Takes from, lists, inspects, resets, and bans the keys of a limiter, and lists its policies. Operations the store does not support respond with 501.",
+    "version": "1"
+  },
+  "security": [{"bearer": []}],
+  "paths": {
+    "/keys": {
+      "get": {
+        "operationId": "keys",
+        "summary": "Returns a page of the keys that match a pattern, like Redis SCAN.",
+        "parameters": [
+          {"name": "pattern", "in": "query", "description": "Glob-style pattern of the keys. The default is *.", "schema": {"type": "string"}},
+          {"name": "cursor", "in": "query", "description": "Cursor returned by the previous page, or 0 for the first.", "schema": {"type": "integer", "format": "uint64", "minimum": 0}}
+        ],
+        "responses": {
+          "200": {"description": "A page of keys. The cursor is 0 after the last page.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Keys"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/stats": {
+      "get": {
+        "operationId": "stats",
+        "summary": "Returns the number of keys and the heaviest consumers.",
+        "parameters": [
+          {"name": "n", "in": "query", "description": "Number of heaviest keys, up to 1000. The default is 10.", "schema": {"type": "integer", "minimum": 0, "maximum": 1000}}
+        ],
+        "responses": {
+          "200": {"description": "The summary of the keys.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Stats"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/hotkeys": {
+      "get": {
+        "operationId": "hotkeys",
+        "summary": "Returns the most active and most limited keys over the recent window, with approximate counts.",
+        "parameters": [
+          {"name": "n", "in": "query", "description": "Number of keys in each list, up to 1000. The default is 10.", "schema": {"type": "integer", "minimum": 0, "maximum": 1000}}
+        ],
+        "responses": {
+          "200": {"description": "The hottest keys, hottest first.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/HotKeys"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/take": {
+      "post": {
+        "operationId": "take",
+        "summary": "Takes a token from a key, as a client of the limiter would. A denied take still responds with 200, with allowed false. Requires the client or operator role.",
+        "parameters": [{"$ref": "#/components/parameters/Key"}],
+        "responses": {
+          "200": {"description": "The result of the take.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Take"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/peek": {
+      "get": {
+        "operationId": "peek",
+        "summary": "Returns the state and metadata of a key without taking from it.",
+        "parameters": [{"$ref": "#/components/parameters/Key"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Key"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/reset": {
+      "post": {
+        "operationId": "reset",
+        "summary": "Deletes a key, so it starts over with full tokens, whatever it owed. Requires the operator role.",
+        "parameters": [{"$ref": "#/components/parameters/Key"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Key"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/ban": {
+      "post": {
+        "operationId": "ban",
+        "summary": "Takes all tokens of a key until its reset. Requires the operator role.",
+        "parameters": [{"$ref": "#/components/parameters/Key"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Key"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/policies": {
+      "get": {
+        "operationId": "policies",
+        "summary": "Returns the limits the limiter enforces.",
+        "responses": {
+          "200": {"description": "The policies, which are empty if none were configured.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Policies"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": {"type": "http", "scheme": "bearer", "description": "A token of the API. Client certificates may be accepted instead."}
+    },
+    "parameters": {
+      "Key": {"name": "key", "in": "query", "required": true, "description": "The key.", "schema": {"type": "string"}}
+    },
+    "responses": {
+      "Key": {"description": "The state of the key.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Key"}}}},
+      "Error": {"description": "The request failed.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    },
+    "schemas": {
+      "Keys": {
+        "type": "object",
+        "required": ["keys", "cursor"],
+        "properties": {
+          "keys": {"type": "array", "items": {"type": "string"}},
+          "cursor": {"type": "integer", "format": "uint64"}
+        }
+      },
+      "Stats": {
+        "type": "object",
+        "required": ["keys", "top"],
+        "properties": {
+          "keys": {"type": "integer", "format": "uint64"},
+          "top": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "required": ["key", "remaining"],
+              "properties": {
+                "key": {"type": "string"},
+                "remaining": {"type": "integer", "format": "uint64"}
+              }
+            }
+          }
+        }
+      },
+      "Key": {
+        "type": "object",
+        "required": ["key", "limit", "remaining", "reset_at"],
+        "properties": {
+          "key": {"type": "string"},
+          "limit": {"type": "integer", "format": "uint64"},
+          "remaining": {"type": "integer", "format": "uint64"},
+          "reset_at": {"type": "string", "format": "date-time"},
+          "metadata": {"type": "string"}
+        }
+      },
+      "HotKeys": {
+        "type": "object",
+        "required": ["active", "limited"],
+        "properties": {
+          "active": {"type": "array", "items": {"$ref": "#/components/schemas/KeyCount"}},
+          "limited": {"type": "array", "items": {"$ref": "#/components/schemas/KeyCount"}}
+        }
+      },
+      "KeyCount": {
+        "type": "object",
+        "required": ["key", "count", "error"],
+        "properties": {
+          "key": {"type": "string"},
+          "count": {"type": "integer", "format": "uint64", "description": "Estimated number of takes, which is never less than the true number."},
+          "error": {"type": "integer", "format": "uint64", "description": "Most the count may exceed the true number by."}
+        }
+      },
+      "Take": {
+        "type": "object",
+        "required": ["key", "limit", "remaining", "reset_at", "allowed"],
+        "properties": {
+          "key": {"type": "string"},
+          "limit": {"type": "integer", "format": "uint64"},
+          "remaining": {"type": "integer", "format": "uint64"},
+          "reset_at": {"type": "string", "format": "date-time"},
+          "metadata": {"type": "string"},
+          "allowed": {"type": "boolean"},
+          "retry_after_seconds": {"type": "number", "description": "How long to wait before retrying a denied take."}
+        }
+      },
+      "Policies": {
+        "type": "object",
+        "required": ["policies"],
+        "properties": {
+          "policies": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "required": ["name", "tokens", "interval_seconds"],
+              "properties": {
+                "name": {"type": "string"},
+                "tokens": {"type": "integer", "format": "uint64"},
+                "interval_seconds": {"type": "number"},
+                "description": {"type": "string"}
+              }
+            }
+          }
+        }
+      },
+      "Error": {
+        "type": "object",
+        "required": ["error"],
+        "properties": {
+          "error": {"type": "string"}
+        }
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,273 @@
+// Package anomaly holds keys whose request rate is far above the rest of the
+// population to a stricter limit for a while, which slows down scrapers and
+// runaway clients before anyone has to find them.
+//
+// The request rate of each key is an exponentially weighted average of its
+// takes per interval. At the end of each interval, a key whose rate is more
+// than Threshold standard deviations above the mean rate of the other keys,
+// its z-score, is an outlier, and for the next Duration its takes must also
+// pass a stricter store. Rates are tracked in process, so each instance of an
+// application judges its own traffic.
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+var _ limiter.Store = (*Store)(nil)
+
+// Event reports that a key became an outlier, or that its stricter limit
+// ended.
+type Event struct {
+	// Key is the key.
+	Key string
+
+	// Outlier is set when the stricter limit starts, and unset when it ends.
+	Outlier bool
+
+	// Rate is the key's request rate, in takes per interval, and Mean and
+	// StdDev those of the other keys, from which Score, the z-score, was
+	// computed. StdDev is at least 1 and the square root of Mean. They are only
+	// set when the stricter limit starts.
+	Rate, Mean, StdDev, Score float64
+
+	// Until is when the stricter limit ends. It is only set when it starts.
+	Until time.Time
+}
+
+// Config is used as input to New.
+type Config struct {
+	// Interval is the period takes are counted over. The default value is 10
+	// seconds.
+	Interval time.Duration
+
+	// Intervals is roughly how many of the most recent intervals count toward
+	// a key's rate. Larger values react more slowly to bursts. The default
+	// value is 6.
+	Intervals int
+
+	// Threshold is the z-score above which a key is an outlier. The default
+	// value is 3.
+	Threshold float64
+
+	// MinKeys is the number of other keys with a rate that must be tracked
+	// before any key can be an outlier. The default value is 10.
+	MinKeys int
+
+	// Duration is how long a key is held to the stricter limit after it was
+	// last an outlier. The default value is 5 minutes.
+	Duration time.Duration
+
+	// OnEvent, if set, is called when a key becomes an outlier and when its
+	// stricter limit ends, for logging and metrics. It is called from a take,
+	// so it should return quickly.
+	OnEvent func(e *Event)
+}
+
+// Store wraps a limiter.Store and takes from the stricter store as well for
+// outliers.
+type Store struct {
+	store  limiter.Store
+	strict limiter.Store
+
+	interval  time.Duration
+	alpha     float64
+	threshold float64
+	minKeys   int
+	duration  time.Duration
+	onEvent   func(e *Event)
+
+	// now returns the current time. Tests replace it.
+	now func() time.Time
+
+	// lock guards the interval end and the keys.
+	lock        sync.Mutex
+	intervalEnd time.Time
+	keys        map[string]*client
+}
+
+// client is the recent history of a key.
+type client struct {
+	// count is the number of takes in the current interval, and rate the
+	// average over the previous ones.
+	count uint64
+	rate  float64
+
+	// until is when the key's stricter limit ends, or zero if it has none.
+	until time.Time
+}
+
+// New wraps the store so outliers also take from the strict store, which
+// should allow fewer tokens than s, like a tenth of them.
+func New(s, strict limiter.Store, c *Config) (*Store, error) {
+	if s == nil || strict == nil {
+		return nil, fmt.Errorf("missing store")
+	}
+	if c == nil {
+		c = new(Config)
+	}
+
+	interval := 10 * time.Second
+	if c.Interval > 0 {
+		interval = c.Interval
+	}
+
+	intervals := 6
+	if c.Intervals > 0 {
+		intervals = c.Intervals
+	}
+
+	threshold := 3.0
+	if c.Threshold != 0 {
+		threshold = c.Threshold
+	}
+	if threshold <= 0 {
+		return nil, fmt.Errorf("threshold must be positive")
+	}
+
+	minKeys := 10
+	if c.MinKeys > 0 {
+		minKeys = c.MinKeys
+	}
+
+	duration := 5 * time.Minute
+	if c.Duration > 0 {
+		duration = c.Duration
+	}
+
+	return &Store{
+		store:       s,
+		strict:      strict,
+		interval:    interval,
+		alpha:       2 / float64(intervals+1),
+		threshold:   threshold,
+		minKeys:     minKeys,
+		duration:    duration,
+		onEvent:     c.OnEvent,
+		now:         time.Now,
+		intervalEnd: time.Now().Add(interval),
+		keys:        make(map[string]*client),
+	}, nil
+}
+
+// Take counts the take of the key, and takes from the underlying store and,
+// if the key is an outlier, from the strict store too. The result of an
+// outlier is that of the strict store, unless the underlying store rejected
+// the take.
+func (s *Store) Take(ctx context.Context, key string) (limiter.Result, error) {
+	now := s.now()
+
+	s.lock.Lock()
+	events := s.rotate(now)
+	c, ok := s.keys[key]
+	if !ok {
+		c = new(client)
+		s.keys[key] = c
+	}
+	c.count++
+	outlier := now.Before(c.until)
+	s.lock.Unlock()
+
+	if s.onEvent != nil {
+		for _, e := range events {
+			s.onEvent(e)
+		}
+	}
+
+	res, err := s.store.Take(ctx, key)
+	if err != nil || !res.Allowed || !outlier {
+		return res, err
+	}
+	return s.strict.Take(ctx, key)
+}
+
+// Outlier reports whether the key is held to the stricter limit.
+func (s *Store) Outlier(key string) bool {
+	now := s.now()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	c, ok := s.keys[key]
+	return ok && now.Before(c.until)
+}
+
+// Close closes the underlying store and the strict store.
+func (s *Store) Close() error {
+	err := s.store.Close()
+	if serr := s.strict.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// rotate ends the current interval if it has passed: it updates the rates,
+// ends the stricter limits that expired, finds the new outliers, and forgets
+// idle keys. It returns the events to report, and must be called with the lock
+// held.
+func (s *Store) rotate(now time.Time) []*Event {
+	if now.Before(s.intervalEnd) {
+		return nil
+	}
+
+	// Intervals that passed without takes decay the rates too.
+	skipped := int(now.Sub(s.intervalEnd) / s.interval)
+	decay := math.Pow(1-s.alpha, float64(skipped))
+	s.intervalEnd = s.intervalEnd.Add(time.Duration(skipped+1) * s.interval)
+
+	var sum, sumSquares float64
+	for _, c := range s.keys {
+		c.rate += s.alpha * (float64(c.count) - c.rate)
+		c.rate *= decay
+		c.count = 0
+		sum += c.rate
+		sumSquares += c.rate * c.rate
+	}
+
+	var events []*Event
+	others := len(s.keys) - 1
+	n := float64(others)
+	for key, c := range s.keys {
+		if !c.until.IsZero() && !now.Before(c.until) {
+			c.until = time.Time{}
+			events = append(events, &Event{Key: key})
+		}
+
+		if others >= s.minKeys {
+			// The key is compared to the others, so it cannot hide the
+			// deviation it causes. The deviation is at least the square root
+			// of the mean, the variation of random arrivals, so a steady
+			// population does not make outliers of keys that are only noisy.
+			mean := (sum - c.rate) / n
+			variance := (sumSquares-c.rate*c.rate)/n - mean*mean
+			stdDev := math.Max(math.Sqrt(math.Max(variance, 0)), math.Max(math.Sqrt(mean), 1))
+
+			if score := (c.rate - mean) / stdDev; score > s.threshold {
+				if c.until.IsZero() {
+					events = append(events, &Event{
+						Key:     key,
+						Outlier: true,
+						Rate:    c.rate,
+						Mean:    mean,
+						StdDev:  stdDev,
+						Score:   score,
+						Until:   now.Add(s.duration),
+					})
+				}
+				c.until = now.Add(s.duration)
+			}
+		}
+
+		// Keys are forgotten once their rate has decayed to almost nothing.
+		if c.until.IsZero() && c.rate < 0.01 {
+			delete(s.keys, key)
+		}
+	}
+	return events
+}
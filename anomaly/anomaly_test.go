@@ -0,0 +1,117 @@
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/limittest"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestStore_Outlier(t *testing.T) {
+	t.Parallel()
+
+	var lock sync.Mutex
+	var events []*Event
+	s, err := New(limittest.NewStore(1000, time.Hour), limittest.NewStore(1, time.Hour), &Config{
+		Interval: time.Second,
+		Duration: 3 * time.Second,
+		OnEvent: func(e *Event) {
+			lock.Lock()
+			defer lock.Unlock()
+			events = append(events, e)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	s.intervalEnd = now.Add(time.Second)
+
+	ctx := context.Background()
+	take := func(key string) limiter.Result {
+		res, err := s.Take(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	// Each of the population takes a few times in the interval, and the
+	// scraper many times more. The outliers are found when it ends.
+	for j := 0; j < 20; j++ {
+		key := fmt.Sprintf("user%d", j)
+		for k := 0; k < 3+j%3; k++ {
+			take(key)
+		}
+	}
+	for k := 0; k < 40; k++ {
+		take("scraper")
+	}
+	if s.Outlier("scraper") {
+		t.Fatal("expected scraper not to be an outlier before the interval ends")
+	}
+	now = now.Add(time.Second)
+	take("user0")
+
+	if !s.Outlier("scraper") {
+		t.Fatal("expected scraper to be an outlier")
+	}
+	if s.Outlier("user0") {
+		t.Error("expected user0 not to be an outlier")
+	}
+
+	// The scraper is held to the strict store's single token.
+	for i, want := range []bool{true, false} {
+		if got := take("scraper").Allowed; got != want {
+			t.Errorf("scraper take %d: expected %t to be %t", i, got, want)
+		}
+	}
+	if !take("user1").Allowed {
+		t.Error("expected user1 to be allowed")
+	}
+
+	// The stricter limit ends once the scraper has not been an outlier for the
+	// duration.
+	now = now.Add(10 * time.Second)
+	take("user0")
+	if s.Outlier("scraper") {
+		t.Error("expected scraper not to be an outlier")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if got, want := len(events), 2; got != want {
+		t.Fatalf("expected %d events to be %d", got, want)
+	}
+	if e := events[0]; e.Key != "scraper" || !e.Outlier || e.Score <= 3 || e.Rate <= e.Mean {
+		t.Errorf("unexpected start event %#v", e)
+	}
+	if e := events[1]; e.Key != "scraper" || e.Outlier {
+		t.Errorf("unexpected end event %#v", e)
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	ms, err := memorystore.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ms.Close()
+
+	if _, err := New(ms, ms, &Config{Threshold: -1}); err == nil {
+		t.Error("expected error for negative threshold")
+	}
+	if _, err := New(ms, nil, nil); err == nil {
+		t.Error("expected error for missing store")
+	}
+}
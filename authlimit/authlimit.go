@@ -0,0 +1,341 @@
+// Package authlimit limits attempts to authenticate, like logins, one-time
+// passwords, and password resets, with presets of escalating lockouts.
+//
+// Attempts are limited in three scopes: per account and IP address pair, which
+// locks out an attacker guessing one account's password without locking out
+// its owner elsewhere; per account, which stops guessing from many addresses;
+// and per IP address, which stops credential stuffing across many accounts
+// from one address, with limits generous enough for NATs. Each scope has
+// levels of increasing windows, so the lockout grows the longer failures go on:
+//
+//	logins, err := authlimit.New(authlimit.Login, func(tokens uint64, interval time.Duration) (limiter.Store, error) {
+//		return memorystore.New(&memorystore.Config{Tokens: tokens, Interval: interval})
+//	})
+//
+//	if d, err := logins.Check(ctx, account, ip); err != nil || d > 0 {
+//		// Respond with a 429 and a Retry-After of d.
+//	}
+//	if passwordMatches {
+//		_ = logins.Succeed(ctx, account, ip)
+//	}
+//
+// Check counts the attempt as a failure before it is verified, so concurrent
+// attempts cannot all pass the check before any of them is counted, and
+// Succeed takes it back once it succeeds.
+package authlimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// Level is a number of attempts allowed per window.
+type Level struct {
+	Attempts uint64
+	Window   time.Duration
+}
+
+// Exponential returns n levels, starting with attempts per window, where each
+// level allows twice the attempts of the previous one in four times the
+// window. Once the attempts of a level are used up, the lockout lasts until its
+// window resets, so the lockout grows fourfold with each level while the
+// sustained rate of attempts halves.
+func Exponential(attempts uint64, window time.Duration, n int) []Level {
+	levels := make([]Level, n)
+	for i := range levels {
+		levels[i] = Level{Attempts: attempts, Window: window}
+		attempts *= 2
+		window *= 4
+	}
+	return levels
+}
+
+// Policy is the levels of each scope. A scope without levels is not limited.
+type Policy struct {
+	// Pair limits the attempts per account and IP address pair.
+	Pair []Level
+
+	// Account limits the attempts per account, from any address.
+	Account []Level
+
+	// IP limits the attempts per IP address, for any account.
+	IP []Level
+}
+
+var (
+	// Login limits failed logins: 5 per pair in 15 minutes, 10 in an hour,
+	// and 20 in 4 hours; 20 per account in an hour and 40 in 4 hours; and 100
+	// per address in an hour and 200 in 4 hours.
+	Login = &Policy{
+		Pair:    Exponential(5, 15*time.Minute, 3),
+		Account: Exponential(20, time.Hour, 2),
+		IP:      Exponential(100, time.Hour, 2),
+	}
+
+	// OTP limits failed verifications of one-time passwords, whose small
+	// keyspace makes them easy to guess: 5 per account in 15 minutes, 10 in an
+	// hour, and 20 in 4 hours; and 50 per address in an hour and 100 in 4
+	// hours.
+	OTP = &Policy{
+		Account: Exponential(5, 15*time.Minute, 3),
+		IP:      Exponential(50, time.Hour, 2),
+	}
+
+	// PasswordReset limits requests for password resets, each of which sends
+	// a message to the account's owner: 3 per account in an hour and 6 in 4
+	// hours; and 20 per address in an hour and 40 in 4 hours. Check every
+	// request, and never call Succeed, so that every request counts.
+	PasswordReset = &Policy{
+		Account: Exponential(3, time.Hour, 2),
+		IP:      Exponential(20, time.Hour, 2),
+	}
+)
+
+// StoreFunc creates the store of a level. It is called once for each level of
+// each scope. When the stores share a backend, like Redis, give each its own
+// key prefix.
+type StoreFunc func(tokens uint64, interval time.Duration) (limiter.Store, error)
+
+// Limiter limits the attempts of a policy.
+type Limiter struct {
+	pair, account, ip []level
+}
+
+// level is the store of a level, the attempts it allows, and whether it is a
+// level of the pair scope.
+type level struct {
+	store    limiter.Store
+	attempts uint64
+	pair     bool
+}
+
+// New creates the stores of the policy's levels with f. The stores must
+// implement limiter.Refunder.
+func New(p *Policy, f StoreFunc) (*Limiter, error) {
+	if p == nil {
+		return nil, fmt.Errorf("policy cannot be nil")
+	}
+	if f == nil {
+		return nil, fmt.Errorf("store function cannot be nil")
+	}
+
+	l := new(Limiter)
+	for _, scope := range []struct {
+		name   string
+		levels []Level
+		stores *[]level
+	}{
+		{"pair", p.Pair, &l.pair},
+		{"account", p.Account, &l.account},
+		{"ip", p.IP, &l.ip},
+	} {
+		for i, lv := range scope.levels {
+			if lv.Attempts == 0 || lv.Window <= 0 {
+				l.Close()
+				return nil, fmt.Errorf("%s level %d: attempts and window must be positive", scope.name, i)
+			}
+			s, err := f(lv.Attempts, lv.Window)
+			if err != nil {
+				l.Close()
+				return nil, fmt.Errorf("%s level %d: failed to create store: %w", scope.name, i, err)
+			}
+			*scope.stores = append(*scope.stores, level{
+				store:    s,
+				attempts: lv.Attempts,
+				pair:     scope.stores == &l.pair,
+			})
+			if _, ok := s.(limiter.Refunder); !ok {
+				l.Close()
+				return nil, fmt.Errorf("%s level %d: store does not implement limiter.Refunder", scope.name, i)
+			}
+		}
+	}
+	return l, nil
+}
+
+// Check counts the attempt of the account from the IP address in every scope
+// and level, and returns how long it is locked out for, or 0 if it may
+// proceed. An attempt that is locked out, or that fails to be counted, is not
+// counted in any level, so it does not escalate the lockout. An empty account
+// or address skips the scopes that need it.
+func (l *Limiter) Check(ctx context.Context, account, ip string) (time.Duration, error) {
+	var lockout time.Duration
+	var taken []take
+	err := l.each(account, ip, func(lv level, key string) error {
+		res, err := lv.store.Take(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !res.Allowed {
+			d := res.RetryAfter
+			if d <= 0 {
+				d = time.Until(res.ResetAt)
+			}
+			if d <= 0 {
+				// The level reset since the take, but the attempt was still
+				// denied, so it is locked out for a moment.
+				d = time.Nanosecond
+			}
+			if d > lockout {
+				lockout = d
+			}
+			return nil
+		}
+		taken = append(taken, take{level: lv, key: key})
+		return nil
+	})
+	if err == nil && lockout == 0 {
+		return 0, nil
+	}
+
+	// The attempt does not proceed, so give back the tokens it took.
+	var errs errorList
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to check: %w", err))
+	}
+	for _, t := range taken {
+		if rerr := t.store.(limiter.Refunder).Refund(ctx, t.key, 1); rerr != nil {
+			errs = append(errs, fmt.Errorf("failed to refund: %w", rerr))
+		}
+	}
+	if len(errs) > 0 {
+		return 0, errs.err()
+	}
+	return lockout, nil
+}
+
+// Fail counts a failed attempt of the account from the IP address in every
+// scope and level. Check already counts the attempts it allows, so Fail is
+// only needed for failed attempts that were not checked. If some levels fail
+// to count it, the others still do, and the errors are returned together.
+func (l *Limiter) Fail(ctx context.Context, account, ip string) error {
+	err := l.each(account, ip, func(lv level, key string) error {
+		_, err := lv.store.Take(ctx, key)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to count attempt: %w", err)
+	}
+	return nil
+}
+
+// Succeed takes back the attempt of the account from the IP address that
+// Check counted, and forgets the failed attempts of the pair, so owners who
+// mistyped their password do not carry their failures into the next lockout.
+// The earlier failures of the account and address scopes are kept, since an
+// attacker may control a valid account. If some levels fail, the others are
+// still updated, and the errors are returned together.
+func (l *Limiter) Succeed(ctx context.Context, account, ip string) error {
+	err := l.each(account, ip, func(lv level, key string) error {
+		tokens := uint64(1)
+		if lv.pair {
+			tokens = lv.attempts
+		}
+		return lv.store.(limiter.Refunder).Refund(ctx, key, tokens)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reset: %w", err)
+	}
+	return nil
+}
+
+// Close closes the stores.
+func (l *Limiter) Close() error {
+	var err error
+	for _, levels := range [][]level{l.pair, l.account, l.ip} {
+		for _, lv := range levels {
+			if cerr := lv.store.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// take is a token taken from the key of a level.
+type take struct {
+	level
+	key string
+}
+
+// each calls f with the level and key of every level of the scopes that apply.
+// It calls f for every level even if some fail, and returns their errors
+// together.
+func (l *Limiter) each(account, ip string, f func(lv level, key string) error) error {
+	var errs errorList
+	visit := func(levels []level, key string) {
+		for _, lv := range levels {
+			if err := f(lv, key); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if account != "" && ip != "" {
+		visit(l.pair, pairKey(account, ip))
+	}
+	if account != "" {
+		visit(l.account, account)
+	}
+	if ip != "" {
+		visit(l.ip, ip)
+	}
+	return errs.err()
+}
+
+// errorList is the errors of several levels. errors.Is and errors.As match
+// any of them.
+type errorList []error
+
+// err returns the list as an error, or nil if it is empty, and the only error
+// if it has one.
+func (e errorList) err() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	}
+	return e
+}
+
+// Error joins the messages of the errors.
+func (e errorList) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the errors is target.
+func (e errorList) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors that matches target.
+func (e errorList) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// pairKey returns the key of an account and IP address pair. The length of the
+// account prefixes it, so no two pairs share a key.
+func pairKey(account, ip string) string {
+	return strconv.Itoa(len(account)) + ":" + account + ":" + ip
+}
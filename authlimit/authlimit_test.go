@@ -0,0 +1,268 @@
+package authlimit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/authlimit"
+	"github.com/sethvargo/go-limiter/limittest"
+	"github.com/sethvargo/go-limiter/noopstore"
+)
+
+func TestExponential(t *testing.T) {
+	t.Parallel()
+
+	got := authlimit.Exponential(5, 15*time.Minute, 3)
+	want := []authlimit.Level{
+		{Attempts: 5, Window: 15 * time.Minute},
+		{Attempts: 10, Window: time.Hour},
+		{Attempts: 20, Window: 4 * time.Hour},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v to be %v", got, want)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+
+	var stores []*limittest.Store
+	l, err := authlimit.New(&authlimit.Policy{
+		Pair:    authlimit.Exponential(2, time.Minute, 2),
+		Account: []authlimit.Level{{Attempts: 4, Window: time.Hour}},
+		IP:      []authlimit.Level{{Attempts: 5, Window: time.Hour}},
+	}, func(tokens uint64, interval time.Duration) (limiter.Store, error) {
+		s := limittest.NewStore(tokens, interval)
+		stores = append(stores, s)
+		return s, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx := context.Background()
+	check := func(account, ip string, locked bool) {
+		t.Helper()
+		d, err := l.Check(ctx, account, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := d > 0, locked; got != want {
+			t.Errorf("%s from %s: expected lockout %t to be %t (%s)", account, ip, got, want, d)
+		}
+	}
+	remaining := func(s *limittest.Store, key string) uint64 {
+		t.Helper()
+		res, err := s.Peek(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Remaining
+	}
+	account := stores[2]
+
+	// The pair is locked out after its attempts, but the owner can still log
+	// in from elsewhere. The locked out attempt is not counted.
+	check("alice", "192.0.2.1", false)
+	check("alice", "192.0.2.1", false)
+	check("alice", "192.0.2.1", true)
+	if got, want := remaining(account, "alice"), uint64(2); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+	check("alice", "198.51.100.1", false)
+
+	// Once the first window resets, the next level still has attempts.
+	for _, s := range stores {
+		s.Advance(time.Minute)
+	}
+	check("alice", "192.0.2.1", false)
+
+	// That used up the account's attempts, which locks it out everywhere.
+	check("alice", "203.0.113.1", true)
+	check("bob", "192.0.2.1", false)
+
+	// Without an account, only the address is limited.
+	check("", "192.0.2.1", false)
+	check("bob", "192.0.2.1", true)
+	check("bob", "203.0.113.1", false)
+}
+
+func TestLimiter_Check_concurrent(t *testing.T) {
+	t.Parallel()
+
+	l, err := authlimit.New(&authlimit.Policy{
+		Pair: []authlimit.Level{{Attempts: 2, Window: time.Minute}},
+	}, func(tokens uint64, interval time.Duration) (limiter.Store, error) {
+		return limittest.NewStore(tokens, interval), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Attempts that check at the same time are counted as they check, so only
+	// the pair's attempts proceed.
+	var allowed uint64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, err := l.Check(context.Background(), "alice", "192.0.2.1")
+			if err == nil && d == 0 {
+				atomic.AddUint64(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, want := allowed, uint64(2); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+func TestLimiter_Succeed(t *testing.T) {
+	t.Parallel()
+
+	var stores []*limittest.Store
+	l, err := authlimit.New(&authlimit.Policy{
+		Pair:    authlimit.Exponential(2, time.Minute, 2),
+		Account: []authlimit.Level{{Attempts: 10, Window: time.Hour}},
+	}, func(tokens uint64, interval time.Duration) (limiter.Store, error) {
+		s := limittest.NewStore(tokens, interval)
+		stores = append(stores, s)
+		return s, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := l.Check(ctx, "alice", "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Succeed(ctx, "alice", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pair forgets both attempts, and the account only the one that
+	// succeeded.
+	d, err := l.Check(ctx, "alice", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != 0 {
+		t.Errorf("expected no lockout, got %s", d)
+	}
+	res, err := stores[2].Peek(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Remaining, uint64(8); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+func TestLimiter_Fail_errors(t *testing.T) {
+	t.Parallel()
+
+	var stores []*limittest.Store
+	l, err := authlimit.New(&authlimit.Policy{
+		Pair:    []authlimit.Level{{Attempts: 5, Window: time.Minute}},
+		Account: []authlimit.Level{{Attempts: 5, Window: time.Minute}},
+		IP:      []authlimit.Level{{Attempts: 5, Window: time.Minute}},
+	}, func(tokens uint64, interval time.Duration) (limiter.Store, error) {
+		s := limittest.NewStore(tokens, interval)
+		stores = append(stores, s)
+		return s, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	errPair := errors.New("pair is down")
+	errIP := errors.New("ip is down")
+	stores[0].Fail(errPair, false)
+	stores[2].Fail(errIP, false)
+
+	// The account is counted even though the pair failed first, and both
+	// errors are returned.
+	err = l.Fail(context.Background(), "alice", "192.0.2.1")
+	if !errors.Is(err, errPair) || !errors.Is(err, errIP) {
+		t.Errorf("expected %v to be %v and %v", err, errPair, errIP)
+	}
+	if got, want := stores[1].Takes("alice"), uint64(1); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+type takeOnlyStore struct {
+	limiter.Store
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	newStore := func(tokens uint64, interval time.Duration) (limiter.Store, error) {
+		return limittest.NewStore(tokens, interval), nil
+	}
+
+	cases := []struct {
+		name string
+		p    *authlimit.Policy
+		f    authlimit.StoreFunc
+	}{
+		{
+			name: "nil_policy",
+			f:    newStore,
+		},
+		{
+			name: "nil_store_func",
+			p:    authlimit.Login,
+		},
+		{
+			name: "zero_attempts",
+			p:    &authlimit.Policy{IP: []authlimit.Level{{Window: time.Minute}}},
+			f:    newStore,
+		},
+		{
+			name: "store_error",
+			p:    authlimit.OTP,
+			f: func(uint64, time.Duration) (limiter.Store, error) {
+				return nil, fmt.Errorf("failed")
+			},
+		},
+		{
+			name: "not_refunder",
+			p:    authlimit.PasswordReset,
+			f: func(uint64, time.Duration) (limiter.Store, error) {
+				s, err := noopstore.New()
+				return &takeOnlyStore{s}, err
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := authlimit.New(tc.p, tc.f); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+// Package bandwidth limits the bytes per interval of each key, to throttle
+// egress in addition to the number of requests. A store enforces the limit
+// with one token per byte: create it with the bytes per interval as its
+// tokens, like 1 MiB per second:
+//
+//	store, err := memorystore.New(&memorystore.Config{
+//		Tokens:   1 << 20,
+//		Interval: time.Second,
+//	})
+//	bw, err := bandwidth.New(store, nil)
+//	handler = bw.Handle(httplimit.IPKeyFunc(), handler)
+//
+// Reads and writes wait for tokens instead of failing, so a client over its
+// bandwidth is slowed down rather than rejected.
+package bandwidth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/internal/httpwriter"
+)
+
+// Config is used as input to New.
+type Config struct {
+	// ChunkSize is the most bytes read or written at once. Larger writes are
+	// split into chunks, and each chunk waits for its tokens, so smaller chunks
+	// spread the bytes more evenly over the interval. The default value is
+	// 32 KiB.
+	ChunkSize int
+}
+
+// Limiter limits the bandwidth of keys.
+type Limiter struct {
+	store     limiter.Store
+	charger   limiter.Charger
+	chunkSize int
+}
+
+// New creates a bandwidth limiter that takes a token per byte from s. The
+// store must implement limiter.Charger, so a chunk is charged at once.
+func New(s limiter.Store, c *Config) (*Limiter, error) {
+	if s == nil {
+		return nil, fmt.Errorf("store cannot be nil")
+	}
+	charger, ok := s.(limiter.Charger)
+	if !ok {
+		return nil, fmt.Errorf("store must implement limiter.Charger")
+	}
+	if c == nil {
+		c = new(Config)
+	}
+
+	chunkSize := 32 << 10
+	if c.ChunkSize > 0 {
+		chunkSize = c.ChunkSize
+	}
+
+	return &Limiter{
+		store:     s,
+		charger:   charger,
+		chunkSize: chunkSize,
+	}, nil
+}
+
+// wait waits until the key has tokens and returns how many bytes, up to want,
+// may be transferred. The first of them is already taken; charge the rest
+// with charge once they are transferred. If the store failed open, the bytes
+// are not limited.
+func (l *Limiter) wait(ctx context.Context, key string, want int) (int, bool, error) {
+	if want > l.chunkSize {
+		want = l.chunkSize
+	}
+
+	res, err := limiter.Wait(ctx, l.store, key)
+	if err != nil {
+		if res.Allowed {
+			return want, false, nil
+		}
+		return 0, false, err
+	}
+
+	// Only transfer as many bytes as there are tokens, including the one just
+	// taken, so the key is never charged beyond its limit.
+	if available := res.Remaining + 1; uint64(want) > available {
+		want = int(available)
+	}
+	return want, true, nil
+}
+
+// charge charges the key for n transferred bytes, of which the first was
+// taken by wait.
+func (l *Limiter) charge(ctx context.Context, key string, n int) error {
+	switch {
+	case n > 1:
+		return l.charger.Charge(ctx, key, uint64(n-1))
+	case n == 0:
+		if r, ok := l.store.(limiter.Refunder); ok {
+			return r.Refund(ctx, key, 1)
+		}
+	}
+	return nil
+}
+
+// Reader returns a reader that reads from r within the key's bandwidth. Reads
+// block until tokens are available or ctx is done.
+func (l *Limiter) Reader(ctx context.Context, key string, r io.Reader) io.Reader {
+	return &reader{l: l, ctx: ctx, key: key, r: r}
+}
+
+type reader struct {
+	l   *Limiter
+	ctx context.Context
+	key string
+	r   io.Reader
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return r.r.Read(p)
+	}
+
+	n, limited, err := r.l.wait(r.ctx, r.key, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err = r.r.Read(p[:n])
+	if limited {
+		if cerr := r.l.charge(r.ctx, r.key, n); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return n, err
+}
+
+// Writer returns a writer that writes to w within the key's bandwidth. Writes
+// block until all of the bytes were written or ctx is done.
+func (l *Limiter) Writer(ctx context.Context, key string, w io.Writer) io.Writer {
+	return &writer{l: l, ctx: ctx, key: key, w: w}
+}
+
+type writer struct {
+	l   *Limiter
+	ctx context.Context
+	key string
+	w   io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n, limited, err := w.l.wait(w.ctx, w.key, len(p))
+		if err != nil {
+			return written, err
+		}
+
+		n, err = w.w.Write(p[:n])
+		written += n
+		p = p[n:]
+		if limited {
+			if cerr := w.l.charge(w.ctx, w.key, n); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Handle returns middleware that limits the bandwidth of responses, keyed by
+// f. Writes to the response block while the key is over its bandwidth, and
+// fail once the request is canceled. If f fails, it responds with a 500.
+//
+// Connections hijacked by upgrade handlers, like WebSockets, are not limited.
+// Wrap them with Reader and Writer to limit them.
+func (l *Limiter) Handle(f httplimit.KeyFunc, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := f(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		next.ServeHTTP(&responseWriter{
+			Writer: httpwriter.Writer{ResponseWriter: w},
+			w:      l.Writer(r.Context(), key, w),
+		}, r)
+	})
+}
+
+// responseWriter limits the bandwidth of the response body.
+type responseWriter struct {
+	httpwriter.Writer
+	w io.Writer
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	return w.w.Write(b)
+}
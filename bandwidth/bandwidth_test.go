@@ -0,0 +1,160 @@
+package bandwidth_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/bandwidth"
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/limittest"
+)
+
+// takeOnlyStore hides the optional interfaces of the wrapped store.
+type takeOnlyStore struct {
+	limiter.Store
+}
+
+// hijackRecorder is a recorder that can be hijacked, like the writers of
+// HTTP/1 servers.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (r *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.hijacked = true
+	return nil, nil, nil
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	if _, err := bandwidth.New(nil, nil); err == nil {
+		t.Error("expected error for nil store")
+	}
+	if _, err := bandwidth.New(takeOnlyStore{limittest.NewStore(1, time.Second)}, nil); err == nil {
+		t.Error("expected error for store without Charger")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	t.Parallel()
+
+	store := limittest.NewStore(10, time.Minute)
+	bw, err := bandwidth.New(store, &bandwidth.Config{ChunkSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Only the first 10 bytes fit in the interval.
+	w := bw.Writer(ctx, "key", &buf)
+	n, err := w.Write([]byte(strings.Repeat("x", 25)))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v to be %v", err, context.DeadlineExceeded)
+	}
+	if got, want := n, 10; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+	if got, want := buf.Len(), 10; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	// The rest is written after the refill.
+	store.Advance(time.Minute)
+	w = bw.Writer(context.Background(), "key", &buf)
+	if _, err := w.Write([]byte(strings.Repeat("x", 10))); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.Len(), 20; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+func TestReader(t *testing.T) {
+	t.Parallel()
+
+	store := limittest.NewStore(10, time.Minute)
+	bw, err := bandwidth.New(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	r := bw.Reader(ctx, "key", strings.NewReader(strings.Repeat("x", 25)))
+	b, err := ioutil.ReadAll(r)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v to be %v", err, context.DeadlineExceeded)
+	}
+	if got, want := len(b), 10; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	t.Parallel()
+
+	store := limittest.NewStore(10, time.Minute)
+	bw, err := bandwidth.New(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := bw.Handle(httplimit.IPKeyFunc(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 8)))
+		if _, err := w.Write([]byte(strings.Repeat("x", 8))); err == nil {
+			t.Error("expected write over the bandwidth to fail")
+		}
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if got, want := w.Body.Len(), 10; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+func TestHandle_hijack(t *testing.T) {
+	t.Parallel()
+
+	bw, err := bandwidth.New(limittest.NewStore(10, time.Minute), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := bw.Handle(httplimit.IPKeyFunc(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Fatal("expected response writer to implement http.Hijacker")
+		}
+		if _, _, err := hj.Hijack(); err != nil {
+			t.Fatal(err)
+		}
+	}))
+
+	rec := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !rec.hijacked {
+		t.Error("expected connection to be hijacked")
+	}
+}
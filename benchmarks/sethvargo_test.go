@@ -1,8 +1,8 @@
 package benchmarks
 
 import (
+	"context"
 	"math"
-	"net"
 	"os"
 	"testing"
 	"time"
@@ -35,6 +35,8 @@ func BenchmarkSethVargoMemory(b *testing.B) {
 		},
 	}
 
+	ctx := context.Background()
+
 	for _, tc := range cases {
 		tc := tc
 
@@ -52,7 +54,7 @@ func BenchmarkSethVargoMemory(b *testing.B) {
 				b.ResetTimer()
 
 				for i := 0; i < b.N; i++ {
-					store.Take(testSessionID(b, i))
+					store.Take(ctx, testSessionID(b, i))
 				}
 				b.StopTimer()
 				store.Close()
@@ -72,7 +74,7 @@ func BenchmarkSethVargoMemory(b *testing.B) {
 
 				b.RunParallel(func(pb *testing.PB) {
 					for i := 0; pb.Next(); i++ {
-						store.Take(testSessionID(b, i))
+						store.Take(ctx, testSessionID(b, i))
 					}
 				})
 				b.StopTimer()
@@ -107,6 +109,8 @@ func BenchmarkSethVargoRedis(b *testing.B) {
 		},
 	}
 
+	ctx := context.Background()
+
 	for _, tc := range cases {
 		tc := tc
 
@@ -119,13 +123,7 @@ func BenchmarkSethVargoRedis(b *testing.B) {
 					InitialPoolSize: 64,
 					MaxPoolSize:     128,
 					AuthPassword:    pass,
-					DialFunc: func() (net.Conn, error) {
-						conn, err := net.Dial("tcp", host+":"+port)
-						if err != nil {
-							return nil, err
-						}
-						return conn, nil
-					},
+					DialFunc:        redisstore.TCPDialFunc(host + ":" + port),
 				})
 				if err != nil {
 					b.Fatal(err)
@@ -133,7 +131,7 @@ func BenchmarkSethVargoRedis(b *testing.B) {
 				b.ResetTimer()
 
 				for i := 0; i < b.N; i++ {
-					store.Take(testSessionID(b, i))
+					store.Take(ctx, testSessionID(b, i))
 				}
 				b.StopTimer()
 				store.Close()
@@ -147,13 +145,7 @@ func BenchmarkSethVargoRedis(b *testing.B) {
 					InitialPoolSize: 64,
 					MaxPoolSize:     128,
 					AuthPassword:    pass,
-					DialFunc: func() (net.Conn, error) {
-						conn, err := net.Dial("tcp", host)
-						if err != nil {
-							return nil, err
-						}
-						return conn, nil
-					},
+					DialFunc:        redisstore.TCPDialFunc(host + ":" + port),
 				})
 				if err != nil {
 					b.Fatal(err)
@@ -162,7 +154,7 @@ func BenchmarkSethVargoRedis(b *testing.B) {
 
 				b.RunParallel(func(pb *testing.PB) {
 					for i := 0; pb.Next(); i++ {
-						store.Take(testSessionID(b, i))
+						store.Take(ctx, testSessionID(b, i))
 					}
 				})
 				b.StopTimer()
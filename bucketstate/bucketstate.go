@@ -0,0 +1,189 @@
+// Package bucketstate defines the canonical state of a token bucket and the
+// encodings it can be stored in, so different backends and migration tools
+// agree on one model.
+//
+// The state is the one kept by the Redis store's script in a hash with the
+// fields of Fields. Codecs encode the same state as bytes for backends that
+// store blobs: JSON, MessagePack, and a fixed-size binary layout.
+package bucketstate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Field names of the state when it is stored as a hash, as the Redis store
+// does.
+const (
+	FieldStart  = "s"
+	FieldTick   = "t"
+	FieldTokens = "k"
+	FieldDebt   = "d"
+
+	// FieldMetadata holds the metadata of the key, which is stored in the
+	// same hash but is not part of the state.
+	FieldMetadata = "m"
+
+	// FieldVersion holds the version of the format the bucket was written in.
+	// It is only set for versions after 1.
+	FieldVersion = "v"
+)
+
+// Version is the newest version of the state format this package and the
+// Redis store read and write. Buckets without a version field are version 1.
+//
+// So that instances of two releases can share buckets during a rolling
+// upgrade, the version is only raised by changes that earlier releases would
+// misread, and a release that raises it must keep writing the previous
+// version until every instance can read the new one. Earlier releases refuse
+// to read or update buckets of a newer version, instead of corrupting them.
+const Version = 1
+
+// ErrNewerVersion is returned for buckets written in a newer version of the
+// format than Version.
+var ErrNewerVersion = errors.New("bucket was written by a newer version")
+
+// State is the state of a bucket. Tokens are refilled each time the clock
+// ticks, once every interval from Start.
+type State struct {
+	// Start is when the bucket was created, in unix nanoseconds.
+	Start int64
+
+	// Tick is the last tick the tokens were refilled at.
+	Tick uint64
+
+	// Tokens is the number of tokens remaining as of Tick. It can be
+	// fractional for stores that refill gradually, and up to a token below
+	// zero after a take that granted a whole token from a fraction.
+	Tokens float64
+
+	// Debt is the number of tokens borrowed from future intervals. It is
+	// subtracted from the next refill.
+	Debt float64
+}
+
+// Validate returns an error if the state is not one a store could write.
+func (s State) Validate() error {
+	if s.Start < 0 {
+		return fmt.Errorf("start %d is negative", s.Start)
+	}
+	if math.IsNaN(s.Tokens) || math.IsInf(s.Tokens, 0) || s.Tokens <= -1 {
+		return fmt.Errorf("tokens %g are invalid", s.Tokens)
+	}
+	if math.IsNaN(s.Debt) || math.IsInf(s.Debt, 0) || s.Debt < 0 {
+		return fmt.Errorf("debt %g is invalid", s.Debt)
+	}
+	return nil
+}
+
+// Fields returns the state as hash fields.
+func (s State) Fields() map[string]string {
+	return map[string]string{
+		FieldStart:  strconv.FormatInt(s.Start, 10),
+		FieldTick:   strconv.FormatUint(s.Tick, 10),
+		FieldTokens: strconv.FormatFloat(s.Tokens, 'g', -1, 64),
+		FieldDebt:   strconv.FormatFloat(s.Debt, 'g', -1, 64),
+	}
+}
+
+// FromFields parses the state from hash fields. The debt field was added
+// later, so it defaults to 0. The metadata field is ignored, and a version
+// newer than Version is an error wrapping ErrNewerVersion. The other fields
+// are required, and unknown fields are an error.
+func FromFields(fields map[string]string) (State, error) {
+	// The version is checked first, since a newer format may have fields this
+	// release does not know.
+	if value, ok := fields[FieldVersion]; ok {
+		version, err := parseInt(value)
+		if err != nil {
+			return State{}, fmt.Errorf("invalid field %q: %q", FieldVersion, value)
+		}
+		if version > Version {
+			return State{}, fmt.Errorf("version %d: %w", version, ErrNewerVersion)
+		}
+	}
+
+	var s State
+	for field, value := range fields {
+		var err error
+		switch field {
+		case FieldStart:
+			s.Start, err = parseInt(value)
+		case FieldTick:
+			var tick int64
+			tick, err = parseInt(value)
+			if err == nil && tick < 0 {
+				err = fmt.Errorf("negative")
+			}
+			s.Tick = uint64(tick)
+		case FieldTokens:
+			s.Tokens, err = strconv.ParseFloat(value, 64)
+		case FieldDebt:
+			s.Debt, err = strconv.ParseFloat(value, 64)
+		case FieldMetadata, FieldVersion:
+		default:
+			return State{}, fmt.Errorf("unknown field %q", field)
+		}
+		if err != nil {
+			return State{}, fmt.Errorf("invalid field %q: %q", field, value)
+		}
+	}
+
+	for _, field := range []string{FieldStart, FieldTick, FieldTokens} {
+		if _, ok := fields[field]; !ok {
+			return State{}, fmt.Errorf("missing field %q", field)
+		}
+	}
+	return s, nil
+}
+
+// parseInt parses an integer field. The script stores numbers as Lua numbers,
+// which may be written in floating point, but integers are parsed exactly when
+// they are not, since a float64 cannot hold every nanosecond timestamp.
+func parseInt(value string) (int64, error) {
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return i, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	return int64(f), err
+}
+
+// Codec encodes states as bytes.
+type Codec interface {
+	// Name is the name of the encoding, like "json".
+	Name() string
+
+	// Marshal encodes the state.
+	Marshal(s State) ([]byte, error)
+
+	// Unmarshal decodes a state encoded by Marshal.
+	Unmarshal(b []byte) (State, error)
+}
+
+// Codecs returns the built-in codecs.
+func Codecs() []Codec {
+	return []Codec{JSON, MessagePack, Binary}
+}
+
+// CodecByName returns the built-in codec with the name, or false if there is
+// none.
+func CodecByName(name string) (Codec, bool) {
+	for _, c := range Codecs() {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Convert decodes b with one codec and encodes it with another, for migrating
+// stored states between encodings.
+func Convert(b []byte, from, to Codec) ([]byte, error) {
+	s, err := from.Unmarshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", from.Name(), err)
+	}
+	return to.Marshal(s)
+}
@@ -0,0 +1,180 @@
+package bucketstate_test
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/sethvargo/go-limiter/bucketstate"
+)
+
+func TestCodecs(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		state bucketstate.State
+	}{
+		{
+			name: "zero",
+		},
+		{
+			name: "fractional",
+			state: bucketstate.State{
+				Start:  1577836800123456789,
+				Tick:   42,
+				Tokens: -0.5,
+				Debt:   2.25,
+			},
+		},
+	}
+
+	for _, c := range bucketstate.Codecs() {
+		c := c
+
+		for _, tc := range cases {
+			tc := tc
+
+			t.Run(c.Name()+"/"+tc.name, func(t *testing.T) {
+				t.Parallel()
+
+				b, err := c.Marshal(tc.state)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := c.Unmarshal(b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := tc.state; got != want {
+					t.Errorf("expected %#v to be %#v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestCodecByName(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"json", "msgpack", "binary"} {
+		if c, ok := bucketstate.CodecByName(name); !ok || c.Name() != name {
+			t.Errorf("expected codec %q", name)
+		}
+	}
+	if _, ok := bucketstate.CodecByName("xml"); ok {
+		t.Errorf("expected no codec")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	t.Parallel()
+
+	st := bucketstate.State{Start: 10, Tick: 2, Tokens: 3}
+	b, err := bucketstate.JSON.Marshal(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = bucketstate.Convert(b, bucketstate.JSON, bucketstate.Binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := bucketstate.Binary.Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != st {
+		t.Errorf("expected %#v to be %#v", got, st)
+	}
+
+	if _, err := bucketstate.Convert([]byte("{"), bucketstate.JSON, bucketstate.Binary); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestMessagePack_Compact(t *testing.T) {
+	t.Parallel()
+
+	// {"s": 1, "t": 2, "k": 3.5 as float32, "d": 0}, as written by libraries
+	// that use the smallest encoding.
+	b := []byte{
+		0x84,
+		0xa1, 's', 0x01,
+		0xa1, 't', 0xcc, 0x02,
+		0xa1, 'k', 0xca, 0x40, 0x60, 0x00, 0x00,
+		0xa1, 'd', 0x00,
+	}
+	got, err := bucketstate.MessagePack.Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (bucketstate.State{Start: 1, Tick: 2, Tokens: 3.5}); got != want {
+		t.Errorf("expected %#v to be %#v", got, want)
+	}
+
+	for _, b := range [][]byte{
+		{0x81, 0xa1, 'x', 0x01},
+		{0x81, 0xa1, 's', 0x01},
+		{0x84, 0xa1, 's'},
+	} {
+		if _, err := bucketstate.MessagePack.Unmarshal(b); err == nil {
+			t.Errorf("%x: expected error", b)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	t.Parallel()
+
+	st := bucketstate.State{Start: 1577836800123456789, Tick: 2, Tokens: 3.5, Debt: 1}
+	fields := st.Fields()
+	if want := map[string]string{"s": "1577836800123456789", "t": "2", "k": "3.5", "d": "1"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("expected %v to be %v", fields, want)
+	}
+
+	got, err := bucketstate.FromFields(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != st {
+		t.Errorf("expected %#v to be %#v", got, st)
+	}
+
+	// Buckets written before debt was added have no debt field, and the script
+	// may write numbers in floating point.
+	got, err = bucketstate.FromFields(map[string]string{"s": "1.5e+09", "t": "2", "k": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (bucketstate.State{Start: 1500000000, Tick: 2, Tokens: 3}); got != want {
+		t.Errorf("expected %#v to be %#v", got, want)
+	}
+
+	// Buckets may have a version, but not a newer one than the package.
+	got, err = bucketstate.FromFields(map[string]string{"s": "1", "t": "2", "k": "3", "v": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (bucketstate.State{Start: 1, Tick: 2, Tokens: 3}); got != want {
+		t.Errorf("expected %#v to be %#v", got, want)
+	}
+	// A newer version is reported even if the bucket has fields this release
+	// does not know, whichever field is seen first.
+	newer := map[string]string{"s": "1", "t": "2", "k": "3", "v": strconv.Itoa(bucketstate.Version + 1), "x": "1"}
+	for i := 0; i < 20; i++ {
+		if _, err := bucketstate.FromFields(newer); !errors.Is(err, bucketstate.ErrNewerVersion) {
+			t.Fatalf("expected %v to be %v", err, bucketstate.ErrNewerVersion)
+		}
+	}
+
+	for _, fields := range []map[string]string{
+		{"s": "1", "t": "2"},
+		{"s": "1", "t": "2", "k": "x"},
+		{"s": "1", "t": "2", "k": "3", "z": "4"},
+	} {
+		if _, err := bucketstate.FromFields(fields); err == nil {
+			t.Errorf("%v: expected error", fields)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package bucketstate
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+var (
+	// JSON encodes states as JSON objects with the keys "start", "tick",
+	// "tokens", and "debt".
+	JSON Codec = jsonCodec{}
+
+	// MessagePack encodes states as MessagePack maps with the hash field names
+	// as keys, so they read like the Redis hash.
+	MessagePack Codec = msgpackCodec{}
+
+	// Binary encodes states in a fixed 33-byte layout: a version byte, then
+	// Start, Tick, Tokens, and Debt as big-endian 64-bit values. It is the
+	// smallest and fastest encoding.
+	Binary Codec = binaryCodec{}
+)
+
+type jsonState struct {
+	Start  int64   `json:"start"`
+	Tick   uint64  `json:"tick"`
+	Tokens float64 `json:"tokens"`
+	Debt   float64 `json:"debt"`
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(s State) ([]byte, error) {
+	return json.Marshal(jsonState(s))
+}
+
+func (jsonCodec) Unmarshal(b []byte) (State, error) {
+	var js jsonState
+	if err := json.Unmarshal(b, &js); err != nil {
+		return State{}, err
+	}
+	return State(js), nil
+}
+
+// binaryVersion is the version byte of the binary layout.
+const binaryVersion = 1
+
+// binarySize is the size of the binary layout.
+const binarySize = 1 + 4*8
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string {
+	return "binary"
+}
+
+func (binaryCodec) Marshal(s State) ([]byte, error) {
+	b := make([]byte, binarySize)
+	b[0] = binaryVersion
+	binary.BigEndian.PutUint64(b[1:], uint64(s.Start))
+	binary.BigEndian.PutUint64(b[9:], s.Tick)
+	binary.BigEndian.PutUint64(b[17:], math.Float64bits(s.Tokens))
+	binary.BigEndian.PutUint64(b[25:], math.Float64bits(s.Debt))
+	return b, nil
+}
+
+func (binaryCodec) Unmarshal(b []byte) (State, error) {
+	if len(b) != binarySize {
+		return State{}, fmt.Errorf("expected %d bytes, got %d", binarySize, len(b))
+	}
+	if b[0] != binaryVersion {
+		return State{}, fmt.Errorf("unknown version %d", b[0])
+	}
+	return State{
+		Start:  int64(binary.BigEndian.Uint64(b[1:])),
+		Tick:   binary.BigEndian.Uint64(b[9:]),
+		Tokens: math.Float64frombits(binary.BigEndian.Uint64(b[17:])),
+		Debt:   math.Float64frombits(binary.BigEndian.Uint64(b[25:])),
+	}, nil
+}
+
+// MessagePack type bytes used by the codec.
+const (
+	mpFixMapMask = 0x80
+	mpFixStrMask = 0xa0
+	mpFloat32    = 0xca
+	mpFloat64    = 0xcb
+	mpUint8      = 0xcc
+	mpUint16     = 0xcd
+	mpUint32     = 0xce
+	mpUint64     = 0xcf
+	mpInt8       = 0xd0
+	mpInt16      = 0xd1
+	mpInt32      = 0xd2
+	mpInt64      = 0xd3
+)
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+// Marshal always writes 64-bit values, so encoded states have a fixed size.
+func (msgpackCodec) Marshal(s State) ([]byte, error) {
+	b := make([]byte, 0, 1+4*11)
+	b = append(b, mpFixMapMask|4)
+
+	b = append(b, mpFixStrMask|1, FieldStart[0], mpInt64)
+	b = appendUint64(b, uint64(s.Start))
+	b = append(b, mpFixStrMask|1, FieldTick[0], mpUint64)
+	b = appendUint64(b, s.Tick)
+	b = append(b, mpFixStrMask|1, FieldTokens[0], mpFloat64)
+	b = appendUint64(b, math.Float64bits(s.Tokens))
+	b = append(b, mpFixStrMask|1, FieldDebt[0], mpFloat64)
+	b = appendUint64(b, math.Float64bits(s.Debt))
+	return b, nil
+}
+
+// Unmarshal accepts any integer or float encoding of the values, so it can
+// read states written by other MessagePack libraries.
+func (msgpackCodec) Unmarshal(b []byte) (State, error) {
+	d := &mpDecoder{b: b}
+
+	n, err := d.mapLen()
+	if err != nil {
+		return State{}, err
+	}
+
+	fields := make(map[string]mpNumber, n)
+	for i := 0; i < n; i++ {
+		key, err := d.str()
+		if err != nil {
+			return State{}, err
+		}
+		v, err := d.number()
+		if err != nil {
+			return State{}, fmt.Errorf("field %q: %w", key, err)
+		}
+		fields[key] = v
+	}
+	if len(d.b) > 0 {
+		return State{}, fmt.Errorf("%d trailing bytes", len(d.b))
+	}
+
+	var s State
+	for key, v := range fields {
+		switch key {
+		case FieldStart:
+			s.Start = v.int64()
+		case FieldTick:
+			if v.f < 0 {
+				return State{}, fmt.Errorf("field %q is negative", key)
+			}
+			s.Tick = uint64(v.int64())
+		case FieldTokens:
+			s.Tokens = v.f
+		case FieldDebt:
+			s.Debt = v.f
+		default:
+			return State{}, fmt.Errorf("unknown field %q", key)
+		}
+	}
+	for _, field := range []string{FieldStart, FieldTick, FieldTokens} {
+		if _, ok := fields[field]; !ok {
+			return State{}, fmt.Errorf("missing field %q", field)
+		}
+	}
+	return s, nil
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// mpDecoder reads the subset of MessagePack used by states.
+type mpDecoder struct {
+	b []byte
+}
+
+// mpNumber is a decoded number. Integers are kept exactly, since a float64
+// cannot hold every nanosecond timestamp.
+type mpNumber struct {
+	f     float64
+	i     int64
+	isInt bool
+}
+
+func (n mpNumber) int64() int64 {
+	if n.isInt {
+		return n.i
+	}
+	return int64(n.f)
+}
+
+func (d *mpDecoder) next(n int) ([]byte, error) {
+	if len(d.b) < n {
+		return nil, fmt.Errorf("unexpected end of input")
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *mpDecoder) mapLen() (int, error) {
+	t, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case t[0]&0xf0 == mpFixMapMask:
+		return int(t[0] & 0x0f), nil
+	case t[0] == 0xde:
+		v, err := d.next(2)
+		if err != nil {
+			return 0, err
+		}
+		return int(binary.BigEndian.Uint16(v)), nil
+	}
+	return 0, fmt.Errorf("expected a map, got type 0x%02x", t[0])
+}
+
+func (d *mpDecoder) str() (string, error) {
+	t, err := d.next(1)
+	if err != nil {
+		return "", err
+	}
+	var n int
+	switch {
+	case t[0]&0xe0 == mpFixStrMask:
+		n = int(t[0] & 0x1f)
+	case t[0] == 0xd9:
+		v, err := d.next(1)
+		if err != nil {
+			return "", err
+		}
+		n = int(v[0])
+	default:
+		return "", fmt.Errorf("expected a string key, got type 0x%02x", t[0])
+	}
+	v, err := d.next(n)
+	if err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+func (d *mpDecoder) number() (mpNumber, error) {
+	t, err := d.next(1)
+	if err != nil {
+		return mpNumber{}, err
+	}
+
+	var i int64
+	switch {
+	case t[0] <= 0x7f:
+		i = int64(t[0])
+	case t[0] >= 0xe0:
+		i = int64(int8(t[0]))
+	case t[0] == mpFloat32:
+		v, err := d.next(4)
+		if err != nil {
+			return mpNumber{}, err
+		}
+		return mpNumber{f: float64(math.Float32frombits(binary.BigEndian.Uint32(v)))}, nil
+	case t[0] == mpFloat64:
+		v, err := d.next(8)
+		if err != nil {
+			return mpNumber{}, err
+		}
+		return mpNumber{f: math.Float64frombits(binary.BigEndian.Uint64(v))}, nil
+	case t[0] == mpUint8, t[0] == mpInt8:
+		v, err := d.next(1)
+		if err != nil {
+			return mpNumber{}, err
+		}
+		i = int64(v[0])
+		if t[0] == mpInt8 {
+			i = int64(int8(v[0]))
+		}
+	case t[0] == mpUint16, t[0] == mpInt16:
+		v, err := d.next(2)
+		if err != nil {
+			return mpNumber{}, err
+		}
+		i = int64(binary.BigEndian.Uint16(v))
+		if t[0] == mpInt16 {
+			i = int64(int16(binary.BigEndian.Uint16(v)))
+		}
+	case t[0] == mpUint32, t[0] == mpInt32:
+		v, err := d.next(4)
+		if err != nil {
+			return mpNumber{}, err
+		}
+		i = int64(binary.BigEndian.Uint32(v))
+		if t[0] == mpInt32 {
+			i = int64(int32(binary.BigEndian.Uint32(v)))
+		}
+	case t[0] == mpUint64:
+		v, err := d.next(8)
+		if err != nil {
+			return mpNumber{}, err
+		}
+		u := binary.BigEndian.Uint64(v)
+		if u > math.MaxInt64 {
+			return mpNumber{}, fmt.Errorf("value %d is out of range", u)
+		}
+		i = int64(u)
+	case t[0] == mpInt64:
+		v, err := d.next(8)
+		if err != nil {
+			return mpNumber{}, err
+		}
+		i = int64(binary.BigEndian.Uint64(v))
+	default:
+		return mpNumber{}, fmt.Errorf("expected a number, got type 0x%02x", t[0])
+	}
+
+	return mpNumber{f: float64(i), i: i, isInt: true}, nil
+}
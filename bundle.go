@@ -0,0 +1,148 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Constraint is a limit of a Bundle.
+type Constraint int
+
+const (
+	// ConstraintNone means no limit rejected the acquire.
+	ConstraintNone Constraint = iota
+
+	// ConstraintRate is the rate limit of the Bundle's store.
+	ConstraintRate
+
+	// ConstraintConcurrency is the limit on requests in flight per key.
+	ConstraintConcurrency
+)
+
+// String returns the name of the constraint.
+func (c Constraint) String() string {
+	switch c {
+	case ConstraintNone:
+		return "none"
+	case ConstraintRate:
+		return "rate"
+	case ConstraintConcurrency:
+		return "concurrency"
+	default:
+		return fmt.Sprintf("Constraint(%d)", int(c))
+	}
+}
+
+// BundleResult is the result of Bundle.Acquire.
+type BundleResult struct {
+	// Result is the result of the take from the store. If the concurrency
+	// limit rejected the acquire, no token was taken and only Allowed is set.
+	Result
+
+	// InFlight is the number of requests in flight for the key, including this
+	// one if it was allowed, and MaxInFlight is the limit.
+	InFlight    uint64
+	MaxInFlight uint64
+
+	// Rejected is the constraint that rejected the acquire, or ConstraintNone
+	// if it was allowed.
+	Rejected Constraint
+}
+
+// Bundle enforces both the rate limit of a store and a maximum number of
+// requests in flight per key, so slow requests cannot pile up even when a key
+// is within its rate. Requests in flight are counted in memory, so that limit
+// is per process.
+type Bundle struct {
+	store       Store
+	maxInFlight uint64
+
+	lock     sync.Mutex
+	inFlight map[string]uint64
+}
+
+// NewBundle creates a bundle that takes from s and allows up to maxInFlight
+// requests in flight per key. It returns an error if the store is nil or
+// maxInFlight is 0.
+func NewBundle(s Store, maxInFlight uint64) (*Bundle, error) {
+	if s == nil {
+		return nil, fmt.Errorf("store cannot be nil")
+	}
+	if maxInFlight == 0 {
+		return nil, fmt.Errorf("max in flight must be positive")
+	}
+
+	return &Bundle{
+		store:       s,
+		maxInFlight: maxInFlight,
+		inFlight:    make(map[string]uint64),
+	}, nil
+}
+
+// Acquire checks both limits for the key in one call. The concurrency limit is
+// checked first, so a key with too many requests in flight does not spend its
+// tokens too. If the acquire is allowed, the caller must call the returned
+// release function once the request is done; it is safe to call more than
+// once, and it is a no-op if the acquire was rejected, so it can always be
+// deferred.
+//
+// Like Take, Acquire returns the result alongside any error from the store, so
+// callers can honor a store that failed open. The request holds a slot in
+// that case too.
+func (b *Bundle) Acquire(ctx context.Context, key string) (BundleResult, func(), error) {
+	br := BundleResult{MaxInFlight: b.maxInFlight}
+
+	inFlight, ok := b.acquire(key)
+	br.InFlight = inFlight
+	if !ok {
+		br.Rejected = ConstraintConcurrency
+		return br, func() {}, nil
+	}
+
+	res, err := b.store.Take(ctx, key)
+	br.Result = res
+	if !res.Allowed {
+		b.release(key)
+		br.InFlight--
+		br.Rejected = ConstraintRate
+		return br, func() {}, err
+	}
+
+	var once sync.Once
+	return br, func() { once.Do(func() { b.release(key) }) }, err
+}
+
+// InFlight returns the number of requests in flight for the key.
+func (b *Bundle) InFlight(key string) uint64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.inFlight[key]
+}
+
+// acquire takes a slot for the key, if one is free, and returns the number of
+// requests in flight.
+func (b *Bundle) acquire(key string) (uint64, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	n := b.inFlight[key]
+	if n >= b.maxInFlight {
+		return n, false
+	}
+	b.inFlight[key] = n + 1
+	return n + 1, true
+}
+
+// release returns a slot for the key.
+func (b *Bundle) release(key string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.inFlight[key] <= 1 {
+		delete(b.inFlight, key)
+		return
+	}
+	b.inFlight[key]--
+}
@@ -0,0 +1,74 @@
+package limiter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestBundle_Acquire(t *testing.T) {
+	t.Parallel()
+
+	s, err := memorystore.New(&memorystore.Config{
+		Tokens:   3,
+		Interval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	b, err := limiter.NewBundle(s, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	acquire := func(want limiter.Constraint) func() {
+		t.Helper()
+
+		res, release, err := b.Acquire(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Rejected; got != want {
+			t.Fatalf("expected %s to be %s", got, want)
+		}
+		if got, want := res.Allowed, want == limiter.ConstraintNone; got != want {
+			t.Errorf("expected %t to be %t", got, want)
+		}
+		return release
+	}
+
+	r1 := acquire(limiter.ConstraintNone)
+	r2 := acquire(limiter.ConstraintNone)
+
+	// Both slots are taken, so the rejection does not spend a token.
+	acquire(limiter.ConstraintConcurrency)
+	if got, want := b.InFlight("key"), uint64(2); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	r1()
+	r1()
+	if got, want := b.InFlight("key"), uint64(1); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	r3 := acquire(limiter.ConstraintNone)
+	r3()
+	r2()
+
+	// The tokens are spent, and the rejected acquire holds no slot.
+	acquire(limiter.ConstraintRate)()
+	if got, want := b.InFlight("key"), uint64(0); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	if _, err := limiter.NewBundle(s, 0); err == nil {
+		t.Error("expected error for zero max in flight")
+	}
+}
@@ -0,0 +1,212 @@
+// Package caddylimit is a Caddy HTTP handler module that limits requests with
+// a memory or Redis store, so reverse proxies can enforce limits shared across
+// instances without Go glue. Build it into Caddy with xcaddy:
+//
+//	xcaddy build --with github.com/sethvargo/go-limiter/caddylimit
+//
+// and configure it with the go_limiter directive:
+//
+//	go_limiter {
+//		redis_url redis://localhost:6379/0
+//		key_prefix rl:
+//		key {http.request.header.X-API-Key}
+//		tokens 100
+//		interval 1m
+//	}
+//
+// Requests are limited with httplimit, so they get the same headers and 429
+// responses.
+package caddylimit
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/memorystore"
+	"github.com/sethvargo/go-limiter/redisstore"
+)
+
+var (
+	_ caddy.Provisioner           = (*Handler)(nil)
+	_ caddy.Validator             = (*Handler)(nil)
+	_ caddy.CleanerUpper          = (*Handler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*Handler)(nil)
+	_ caddyfile.Unmarshaler       = (*Handler)(nil)
+)
+
+// defaultKey keys requests by the client IP address.
+const defaultKey = "{http.request.remote.host}"
+
+func init() {
+	caddy.RegisterModule(Handler{})
+	httpcaddyfile.RegisterHandlerDirective("go_limiter", parseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("go_limiter", httpcaddyfile.Before, "basic_auth")
+}
+
+// Handler limits requests by key. Requests over the limit are rejected with
+// 429 Too Many Requests.
+type Handler struct {
+	// RedisURL is the URL of the Redis server the limits are kept in, in the
+	// format of redisstore.NewFromURL. If empty, limits are kept in memory, so
+	// they are per Caddy instance.
+	RedisURL string `json:"redis_url,omitempty"`
+
+	// KeyPrefix is prepended to every key in Redis.
+	KeyPrefix string `json:"key_prefix,omitempty"`
+
+	// Key is the key requests are limited by, which may contain placeholders.
+	// The default is the client IP address.
+	Key string `json:"key,omitempty"`
+
+	// Tokens is the number of requests to allow per interval. The default
+	// value is 1.
+	Tokens uint64 `json:"tokens,omitempty"`
+
+	// Interval is the time interval upon which to enforce rate limiting. The
+	// default value is 1 second.
+	Interval caddy.Duration `json:"interval,omitempty"`
+
+	store      limiter.Store
+	middleware *httplimit.Middleware
+}
+
+// CaddyModule returns the Caddy module information.
+func (Handler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.go_limiter",
+		New: func() caddy.Module { return new(Handler) },
+	}
+}
+
+// Provision creates the store.
+func (h *Handler) Provision(ctx caddy.Context) error {
+	if h.Key == "" {
+		h.Key = defaultKey
+	}
+
+	var err error
+	if h.RedisURL != "" {
+		h.store, err = redisstore.NewFromURL(h.RedisURL, &redisstore.Config{
+			Tokens:    h.Tokens,
+			Interval:  time.Duration(h.Interval),
+			KeyPrefix: h.KeyPrefix,
+		})
+	} else {
+		h.store, err = memorystore.New(&memorystore.Config{
+			Tokens:   h.Tokens,
+			Interval: time.Duration(h.Interval),
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create store: %w", err)
+	}
+
+	h.middleware, err = httplimit.NewMiddleware(h.store, h.keyFunc)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Validate validates the configuration.
+func (h *Handler) Validate() error {
+	if h.Interval < 0 {
+		return fmt.Errorf("interval cannot be negative")
+	}
+	return nil
+}
+
+// Cleanup closes the store.
+func (h *Handler) Cleanup() error {
+	if h.store == nil {
+		return nil
+	}
+	return h.store.Close()
+}
+
+// ServeHTTP limits the request, and calls the next handler if it is allowed.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	var nextErr error
+	h.middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nextErr = next.ServeHTTP(w, r)
+	})).ServeHTTP(w, r)
+	return nextErr
+}
+
+// keyFunc replaces the placeholders in the key.
+func (h *Handler) keyFunc(r *http.Request) (string, error) {
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return "", fmt.Errorf("missing replacer")
+	}
+	key := repl.ReplaceAll(h.Key, "")
+	if key == "" {
+		return "", fmt.Errorf("empty key")
+	}
+	return key, nil
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens:
+//
+//	go_limiter {
+//		redis_url <url>
+//		key_prefix <prefix>
+//		key <key>
+//		tokens <n>
+//		interval <duration>
+//	}
+func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // the directive name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for d.NextBlock(0) {
+		option := d.Val()
+		var value string
+		if !d.Args(&value) {
+			return d.ArgErr()
+		}
+
+		switch option {
+		case "redis_url":
+			h.RedisURL = value
+		case "key_prefix":
+			h.KeyPrefix = value
+		case "key":
+			h.Key = value
+		case "tokens":
+			tokens, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return d.Errf("invalid tokens %q: %v", value, err)
+			}
+			h.Tokens = tokens
+		case "interval":
+			interval, err := caddy.ParseDuration(value)
+			if err != nil {
+				return d.Errf("invalid interval %q: %v", value, err)
+			}
+			h.Interval = caddy.Duration(interval)
+		default:
+			return d.Errf("unknown option %q", option)
+		}
+	}
+	return nil
+}
+
+// parseCaddyfile parses the go_limiter directive.
+func parseCaddyfile(helper httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	h := new(Handler)
+	if err := h.UnmarshalCaddyfile(helper.Dispenser); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
@@ -0,0 +1,113 @@
+package caddylimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestUnmarshalCaddyfile(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		input string
+		want  Handler
+		err   bool
+	}{
+		{
+			name: "full",
+			input: `go_limiter {
+				redis_url redis://localhost:6379/0
+				key_prefix rl:
+				key {http.request.header.X-API-Key}
+				tokens 100
+				interval 1m
+			}`,
+			want: Handler{
+				RedisURL:  "redis://localhost:6379/0",
+				KeyPrefix: "rl:",
+				Key:       "{http.request.header.X-API-Key}",
+				Tokens:    100,
+				Interval:  caddy.Duration(time.Minute),
+			},
+		},
+		{
+			name:  "unknown",
+			input: `go_limiter { burst 5 }`,
+			err:   true,
+		},
+		{
+			name:  "invalid_tokens",
+			input: `go_limiter { tokens many }`,
+			err:   true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var h Handler
+			err := h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(tc.input))
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error to be %t, got %v", tc.err, err)
+			}
+			if tc.err {
+				return
+			}
+			if got, want := h, tc.want; got != want {
+				t.Errorf("expected %#v to be %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	h := &Handler{
+		Key:      "{http.request.header.X-User}",
+		Tokens:   2,
+		Interval: caddy.Duration(time.Minute),
+	}
+	if err := h.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Cleanup()
+
+	var calls int
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		calls++
+		return nil
+	})
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-User", "alice")
+		repl := caddyhttp.NewTestReplacer(r)
+		r = r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl))
+
+		w := httptest.NewRecorder()
+		if err := h.ServeHTTP(w, r, next); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Code; got != want {
+			t.Errorf("request %d: expected %d to be %d", i, got, want)
+		}
+	}
+	if got, want := calls, 2; got != want {
+		t.Errorf("expected %d calls to be %d", got, want)
+	}
+}
@@ -0,0 +1,112 @@
+// Command limiter-migrate copies the state of a redisstore limiter to another
+// Redis, or to a limiter with different limits, so keys keep what they used
+// when the limiter moves. Both configurations must match the ones the limiters
+// are deployed with:
+//
+//	limiter-migrate -from redis://old:6379/0 -to redis://new:6379/0 -prefix rl: -tokens 100 -interval 1m
+//
+// By default, the used tokens of each key are charged to the destination, which
+// may have other limits, set with -to-tokens and -to-interval. With -exact, the
+// buckets are copied as they are instead, which requires the same limits. The
+// destination gives each key its own TTL. Migrating a key twice charges it
+// twice, so the destination should not be taken from until it is done.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sethvargo/go-limiter/bucketstate"
+	"github.com/sethvargo/go-limiter/migrate"
+	"github.com/sethvargo/go-limiter/redisstore"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "limiter-migrate: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var (
+		fromURL    = flag.String("from", "redis://localhost:6379", "Redis URL of the source")
+		toURL      = flag.String("to", "", "Redis URL of the destination")
+		prefix     = flag.String("prefix", "", "key prefix of the source")
+		toPrefix   = flag.String("to-prefix", "", "key prefix of the destination (default -prefix)")
+		tokens     = flag.Uint64("tokens", 1, "tokens per interval of the source")
+		interval   = flag.Duration("interval", time.Second, "interval of the source")
+		toTokens   = flag.Uint64("to-tokens", 0, "tokens per interval of the destination (default -tokens)")
+		toInterval = flag.Duration("to-interval", 0, "interval of the destination (default -interval)")
+		ttl        = flag.Uint64("ttl", 0, "ttl in seconds of the migrated keys (default 10 x interval)")
+		pattern    = flag.String("pattern", "*", "pattern of the keys to migrate")
+		exact      = flag.Bool("exact", false, "copy the buckets as they are, for limiters with the same limits")
+		verbose    = flag.Bool("v", false, "print each key")
+	)
+	flag.Parse()
+
+	if *toURL == "" {
+		return fmt.Errorf("missing -to")
+	}
+	if *toPrefix == "" {
+		*toPrefix = *prefix
+	}
+	if *toTokens == 0 {
+		*toTokens = *tokens
+	}
+	if *toInterval == 0 {
+		*toInterval = *interval
+	}
+	if *exact && (*toTokens != *tokens || *toInterval != *interval) {
+		return fmt.Errorf("-exact requires the same limits")
+	}
+
+	from, err := redisstore.NewFromURL(*fromURL, &redisstore.Config{
+		Tokens:    *tokens,
+		Interval:  *interval,
+		KeyPrefix: *prefix,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect to source: %w", err)
+	}
+	defer from.Close()
+
+	to, err := redisstore.NewFromURL(*toURL, &redisstore.Config{
+		Tokens:    *toTokens,
+		Interval:  *toInterval,
+		KeyPrefix: *toPrefix,
+		TTL:       *ttl,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect to destination: %w", err)
+	}
+	defer to.Close()
+
+	c := &migrate.Config{
+		Pattern: *pattern,
+	}
+	if *exact {
+		c.ReadState = func(ctx context.Context, key string) (bucketstate.State, bool, error) {
+			return redisstore.ReadState(ctx, from, key)
+		}
+		c.WriteState = func(ctx context.Context, key string, st bucketstate.State) error {
+			return redisstore.WriteState(ctx, to, key, st)
+		}
+	}
+	if *verbose {
+		c.Progress = func(key string, migrated bool) {
+			status := "skipped"
+			if migrated {
+				status = "migrated"
+			}
+			fmt.Printf("%s\t%q\n", status, key)
+		}
+	}
+
+	report, err := migrate.Migrate(context.Background(), from, to, c)
+	fmt.Printf("migrated %d of %d keys\n", report.Migrated, report.Keys)
+	return err
+}
@@ -0,0 +1,81 @@
+// Command limiter-verify checks that the buckets stored in Redis by redisstore
+// are consistent with a configuration, for example after upgrading the limiter
+// or changing its limits. It prints each anomaly found, and exits non-zero if
+// any are left unfixed.
+//
+// The configuration must match the one the limiter is deployed with:
+//
+//	limiter-verify -url redis://localhost:6379/0 -prefix rl: -tokens 100 -interval 1m
+//
+// With -fix, keys that are not valid buckets are deleted, so they start again
+// with full buckets, and keys without a TTL are given one.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sethvargo/go-limiter/redisstore"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "limiter-verify: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var (
+		rawurl       = flag.String("url", "redis://localhost:6379", "Redis URL")
+		prefix       = flag.String("prefix", "", "key prefix of the limiter")
+		tokens       = flag.Uint64("tokens", 1, "tokens per interval")
+		interval     = flag.Duration("interval", time.Second, "interval")
+		globalTokens = flag.Uint64("global-tokens", 0, "tokens per interval of the global bucket, if enabled")
+		globalKey    = flag.String("global-key", "", "key of the global bucket, if not the default")
+		debtLimit    = flag.Uint64("debt-limit", 0, "tokens a key may borrow")
+		ttl          = flag.Uint64("ttl", 0, "ttl in seconds to give keys without one (default 10 x interval)")
+		fix          = flag.Bool("fix", false, "delete invalid buckets and set missing ttls")
+	)
+	flag.Parse()
+
+	s, err := redisstore.NewFromURL(*rawurl, &redisstore.Config{
+		Tokens:       *tokens,
+		Interval:     *interval,
+		KeyPrefix:    *prefix,
+		GlobalTokens: *globalTokens,
+		GlobalKey:    *globalKey,
+		DebtLimit:    *debtLimit,
+		TTL:          *ttl,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer s.Close()
+
+	report, err := redisstore.Verify(context.Background(), s, *fix)
+	if err != nil {
+		return err
+	}
+
+	var unfixed int
+	for _, a := range report.Anomalies {
+		status := "found"
+		if a.Fixed {
+			status = "fixed"
+		} else {
+			unfixed++
+		}
+		fmt.Printf("%s\t%q\t%s\n", status, a.Key, a.Problem)
+	}
+	fmt.Printf("checked %d keys, %d anomalies, %d unfixed\n",
+		report.Keys, len(report.Anomalies), unfixed)
+
+	if unfixed > 0 {
+		return fmt.Errorf("%d anomalies left unfixed", unfixed)
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+// Command limiterctl takes, inspects, and resets keys of a redisstore limiter,
+// and load tests it, so operators can poke the limiter from a shell.
+//
+//	limiterctl [flags] take KEY [N]
+//	limiterctl [flags] peek KEY
+//	limiterctl [flags] reset KEY
+//	limiterctl [flags] keys [PATTERN]
+//	limiterctl [flags] hot [N]
+//	limiterctl [flags] loadtest [-duration D] [-workers N] [-keys N]
+//
+// The flags describe the limiter, and must match the configuration it is
+// deployed with, since a store with different limits takes from the same keys
+// differently:
+//
+//	limiterctl -url redis://localhost:6379/0 -prefix rl: -tokens 100 -interval 1m peek user:42
+//
+// Note that take and loadtest take real tokens from the keys they use.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/hotkeys"
+	"github.com/sethvargo/go-limiter/redisstore"
+)
+
+// errUsage is returned for invalid arguments, after the usage is printed.
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		if err != errUsage {
+			fmt.Fprintf(os.Stderr, "limiterctl: %s\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("limiterctl", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: limiterctl [flags] take|peek|reset|keys|hot|loadtest [args]\n\n")
+		fs.PrintDefaults()
+	}
+
+	var (
+		rawurl       = fs.String("url", "redis://localhost:6379", "Redis URL")
+		prefix       = fs.String("prefix", "", "key prefix of the limiter")
+		tokens       = fs.Uint64("tokens", 1, "tokens per interval")
+		interval     = fs.Duration("interval", time.Second, "interval")
+		globalTokens = fs.Uint64("global-tokens", 0, "tokens per interval of the global bucket, if enabled")
+		globalKey    = fs.String("global-key", "", "key of the global bucket, if not the default")
+		debtLimit    = fs.Uint64("debt-limit", 0, "tokens a key may borrow")
+		timeout      = fs.Duration("timeout", 5*time.Second, "timeout of each command, except loadtest")
+	)
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	s, err := redisstore.NewFromURL(*rawurl, &redisstore.Config{
+		Tokens:       *tokens,
+		Interval:     *interval,
+		KeyPrefix:    *prefix,
+		GlobalTokens: *globalTokens,
+		GlobalKey:    *globalKey,
+		DebtLimit:    *debtLimit,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer s.Close()
+
+	cmd, cmdArgs := fs.Arg(0), fs.Args()[1:]
+	if cmd == "loadtest" {
+		return loadTest(s, cmdArgs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	switch cmd {
+	case "take":
+		return take(ctx, s, cmdArgs)
+	case "peek":
+		return peek(ctx, s, cmdArgs)
+	case "reset":
+		return reset(ctx, s, cmdArgs)
+	case "keys":
+		return keys(ctx, s, cmdArgs)
+	case "hot":
+		return hot(ctx, s, cmdArgs)
+	default:
+		fs.Usage()
+		return errUsage
+	}
+}
+
+// take takes N tokens from the key, one at a time, and prints each result.
+func take(ctx context.Context, s limiter.Store, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: take KEY [N]")
+	}
+	n, err := count(args[1:], 1)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ALLOWED\tLIMIT\tREMAINING\tRESET")
+	for i := 0; i < n; i++ {
+		res, err := s.Take(ctx, args[0])
+		if err != nil {
+			w.Flush()
+			return fmt.Errorf("failed to take: %w", err)
+		}
+		fmt.Fprintf(w, "%t\t%d\t%d\t%s\n", res.Allowed, res.Limit, res.Remaining,
+			time.Until(res.ResetAt).Round(time.Millisecond))
+	}
+	return w.Flush()
+}
+
+// peek prints the tokens remaining for the key without taking any.
+func peek(ctx context.Context, s limiter.Store, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: peek KEY")
+	}
+
+	p, ok := s.(limiter.Peeker)
+	if !ok {
+		return fmt.Errorf("store cannot peek keys: %w", limiter.ErrNotSupported)
+	}
+
+	res, err := p.Peek(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to peek: %w", err)
+	}
+	fmt.Println(res.Remaining)
+	return nil
+}
+
+// reset deletes the key, so its next take starts with full tokens, whatever
+// it owed.
+func reset(ctx context.Context, s limiter.Store, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: reset KEY")
+	}
+
+	d, ok := s.(limiter.Deleter)
+	if !ok {
+		return fmt.Errorf("store cannot reset keys: %w", limiter.ErrNotSupported)
+	}
+	if err := d.Delete(ctx, args[0]); err != nil {
+		return fmt.Errorf("failed to reset: %w", err)
+	}
+	return nil
+}
+
+// keys prints the keys that match the pattern.
+func keys(ctx context.Context, s limiter.Store, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: keys [PATTERN]")
+	}
+	pattern := "*"
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+
+	inspector, ok := s.(limiter.Inspector)
+	if !ok {
+		return fmt.Errorf("store cannot list keys: %w", limiter.ErrNotSupported)
+	}
+
+	var cursor uint64
+	for {
+		keys, next, err := inspector.Keys(ctx, pattern, cursor)
+		if err != nil {
+			return fmt.Errorf("failed to list keys: %w", err)
+		}
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// hot prints the N keys with the fewest tokens remaining.
+func hot(ctx context.Context, s limiter.Store, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: hot [N]")
+	}
+	n, err := count(args, 10)
+	if err != nil {
+		return err
+	}
+
+	inspector, ok := s.(limiter.Inspector)
+	if !ok {
+		return fmt.Errorf("store cannot list keys: %w", limiter.ErrNotSupported)
+	}
+
+	stats, err := inspector.Stats(ctx, n)
+	if err != nil {
+		return fmt.Errorf("failed to get stats: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%d keys\n\n", stats.Keys)
+	fmt.Fprintln(w, "KEY\tREMAINING")
+	for _, k := range stats.Top {
+		fmt.Fprintf(w, "%s\t%d\n", k.Key, k.Remaining)
+	}
+	return w.Flush()
+}
+
+// loadTest takes from random keys as fast as the workers can, and prints the
+// throughput, outcomes, latencies, and hottest keys.
+func loadTest(s limiter.Store, args []string) error {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	var (
+		duration = fs.Duration("duration", 10*time.Second, "how long to run")
+		workers  = fs.Int("workers", 10, "number of concurrent workers")
+		numKeys  = fs.Int("keys", 100, "number of distinct keys to take from")
+		keyFmt   = fs.String("key-format", "limiterctl:%d", "format of the keys, given the key number")
+	)
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if *workers <= 0 || *numKeys <= 0 {
+		return fmt.Errorf("workers and keys must be positive")
+	}
+
+	hk, err := hotkeys.New(s, &hotkeys.Config{Window: *duration})
+	if err != nil {
+		return fmt.Errorf("failed to track hot keys: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+
+	var allowed, denied, failed uint64
+	latencies := make([][]time.Duration, *workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
+			for ctx.Err() == nil {
+				key := fmt.Sprintf(*keyFmt, r.Intn(*numKeys))
+
+				start := time.Now()
+				res, err := hk.Take(ctx, key)
+				if ctx.Err() != nil {
+					return
+				}
+				latencies[i] = append(latencies[i], time.Since(start))
+
+				switch {
+				case err != nil:
+					atomic.AddUint64(&failed, 1)
+				case res.Allowed:
+					atomic.AddUint64(&allowed, 1)
+				default:
+					atomic.AddUint64(&denied, 1)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+
+	total := allowed + denied + failed
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "takes\t%d\t(%.0f/s)\n", total, float64(total)/duration.Seconds())
+	fmt.Fprintf(w, "allowed\t%d\n", allowed)
+	fmt.Fprintf(w, "denied\t%d\n", denied)
+	fmt.Fprintf(w, "failed\t%d\n", failed)
+	for _, q := range []float64{0.5, 0.9, 0.99, 1} {
+		fmt.Fprintf(w, "p%g\t%s\n", q*100, quantile(all, q))
+	}
+
+	report := hk.Top(5)
+	fmt.Fprintln(w, "\nMOST ACTIVE\tTAKES")
+	for _, k := range report.Active {
+		fmt.Fprintf(w, "%s\t%d\n", k.Key, k.Count)
+	}
+	fmt.Fprintln(w, "\nMOST LIMITED\tDENIALS")
+	for _, k := range report.Limited {
+		fmt.Fprintf(w, "%s\t%d\n", k.Key, k.Count)
+	}
+	return w.Flush()
+}
+
+// quantile returns the q quantile of the sorted durations.
+func quantile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(q * float64(len(sorted)-1))
+	return sorted[i]
+}
+
+// count parses the optional count argument, or returns def if there is none.
+func count(args []string, def int) (int, error) {
+	if len(args) == 0 {
+		return def, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid count %q", args[0])
+	}
+	return n, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/adminlimit"
+	"github.com/sethvargo/go-limiter/hotkeys"
+)
+
+// adminConfig is the configuration of the admin API from the flags.
+type adminConfig struct {
+	addr        string
+	tokensFile  string
+	certFile    string
+	keyFile     string
+	clientCA    string
+	clientRoles string
+	auditFile   string
+	policies    []adminlimit.Policy
+	hotKeys     *hotkeys.Store
+}
+
+// newAdminServer creates the server of the admin API. Requests are
+// authenticated by the tokens in the tokens file, one "TOKEN NAME ROLE" per
+// line, and, with a client CA, by client certificates whose common names are
+// listed in the client roles as "CN=ROLE,...". Roles are "reader", "client",
+// or "operator". With an audit file, resets and bans are appended to it as JSON
+// lines; the returned closer closes it.
+func newAdminServer(s limiter.Store, c *adminConfig) (*http.Server, io.Closer, error) {
+	var auths []adminlimit.AuthFunc
+
+	if c.tokensFile != "" {
+		tokens, err := readTokens(c.tokensFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		auths = append(auths, adminlimit.TokenAuth(tokens))
+	}
+
+	var tlsConfig *tls.Config
+	if c.certFile != "" || c.keyFile != "" {
+		if c.certFile == "" || c.keyFile == "" {
+			return nil, nil, fmt.Errorf("admin cert and key are required together")
+		}
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if c.clientCA != "" {
+		if tlsConfig == nil {
+			return nil, nil, fmt.Errorf("admin client CA requires an admin cert and key")
+		}
+		pem, err := ioutil.ReadFile(c.clientCA)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates in client CA %q", c.clientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+
+		roles := make(map[string]adminlimit.Role)
+		for _, entry := range strings.Split(c.clientRoles, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			i := strings.LastIndexByte(entry, '=')
+			if i < 0 {
+				return nil, nil, fmt.Errorf("invalid client role %q", entry)
+			}
+			role, err := parseRole(entry[i+1:])
+			if err != nil {
+				return nil, nil, err
+			}
+			roles[entry[:i]] = role
+		}
+		auths = append(auths, adminlimit.TLSAuth(roles))
+	}
+
+	if len(auths) == 0 {
+		return nil, nil, fmt.Errorf("admin API requires a tokens file or a client CA")
+	}
+
+	config := &adminlimit.Config{
+		Auth:     adminlimit.AnyAuth(auths...),
+		Policies: c.policies,
+		HotKeys:  c.hotKeys,
+	}
+
+	closer := ioutil.NopCloser(nil)
+	if c.auditFile != "" {
+		f, err := os.OpenFile(c.auditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open audit file: %w", err)
+		}
+		config.Audit = adminlimit.JSONAudit(f)
+		closer = f
+	}
+
+	h, err := adminlimit.New(s, config)
+	if err != nil {
+		closer.Close()
+		return nil, nil, err
+	}
+
+	return &http.Server{
+		Addr:              c.addr,
+		Handler:           h,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}, closer, nil
+}
+
+// readTokens reads the tokens file. Blank lines and lines starting with "#"
+// are ignored.
+func readTokens(path string) (map[string]*adminlimit.Principal, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tokens file: %w", err)
+	}
+	defer f.Close()
+
+	tokens := make(map[string]*adminlimit.Principal)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("tokens file line %d: expected TOKEN NAME ROLE", line)
+		}
+		role, err := parseRole(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("tokens file line %d: %w", line, err)
+		}
+		tokens[fields[0]] = &adminlimit.Principal{Name: fields[1], Role: role}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tokens file: %w", err)
+	}
+	return tokens, nil
+}
+
+func parseRole(s string) (adminlimit.Role, error) {
+	switch s {
+	case "reader":
+		return adminlimit.RoleReader, nil
+	case "operator":
+		return adminlimit.RoleOperator, nil
+	case "client":
+		return adminlimit.RoleClient, nil
+	}
+	return 0, fmt.Errorf("unknown role %q", s)
+}
@@ -0,0 +1,251 @@
+// Command limiterd is a sidecar that owns the connections to Redis for the
+// processes of a pod, and serves a redisstore limiter to them on a Unix
+// socket, so each application process needs a single local connection instead
+// of its own Redis pool.
+//
+//	limiterd -socket /run/limiter/limiter.sock -url redis://redis:6379/0 -tokens 100 -interval 1m
+//
+// Applications take from it with a remotestore, which implements
+// limiter.Store with retries and failure modes like redisstore:
+//
+//	store, err := remotestore.New(&remotestore.Config{
+//		Path:        "/run/limiter/limiter.sock",
+//		FailureMode: remotestore.FailOpen,
+//	})
+//
+// With -admin-addr, limiterd also serves the adminlimit API for listing,
+// resetting, and banning keys. Callers must authenticate with a token from
+// -admin-tokens, or with a client certificate signed by -admin-client-ca whose
+// common name has a role in -admin-client-roles. Readers may only inspect
+// keys; clients may only take from them and list the policies of the flags;
+// operators may do all of these, and also reset and ban keys. With -admin-audit, every reset
+// and ban is appended to an audit log with the caller and the key's previous
+// state. With -hotkeys-window, the takes are tracked so the admin API reports
+// the most active and most limited keys over that window.
+//
+// Access to the socket is controlled by its permissions, set with
+// -socket-mode. Any process that can connect may take, and also refund or
+// charge any key, so by default every container the socket's directory is
+// mounted into is trusted alike. To tell them apart, -socket-tokens requires
+// each connection to authenticate with a token from the file, which allows it
+// only the operations listed with the token, and each application sets its
+// token in remotestore.Config.Token.
+//
+// For reverse proxies, -auth-addr serves the proxylimit endpoint for NGINX
+// auth_request, keyed by the -auth-key-header of the original request, and
+// -spoe-addr serves the proxylimit agent for HAProxy. Neither is
+// authenticated, so they should only be reachable by the proxies.
+//
+// Mount the socket's directory into the application containers, as a shared
+// emptyDir volume for example. limiterd stops on SIGINT or SIGTERM, after the
+// requests in flight are answered.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/sethvargo/go-limiter/adminlimit"
+	"github.com/sethvargo/go-limiter/hotkeys"
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/proxylimit"
+	"github.com/sethvargo/go-limiter/redisstore"
+	"github.com/sethvargo/go-limiter/unixstore"
+)
+
+// errUsage is returned for invalid arguments, after the usage is printed.
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		if err != errUsage {
+			fmt.Fprintf(os.Stderr, "limiterd: %s\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("limiterd", flag.ContinueOnError)
+
+	var (
+		socket       = fs.String("socket", "/run/limiter/limiter.sock", "path of the Unix socket to serve on")
+		socketMode   = fs.String("socket-mode", "0660", "permissions of the socket, in octal")
+		socketTokens = fs.String("socket-tokens", "", `file of socket tokens, one "TOKEN OPS" per line, if authenticating`)
+		rawurl       = fs.String("url", "redis://localhost:6379", "Redis URL")
+		prefix       = fs.String("prefix", "", "key prefix of the limiter")
+		tokens       = fs.Uint64("tokens", 1, "tokens per interval")
+		interval     = fs.Duration("interval", time.Second, "interval")
+		globalTokens = fs.Uint64("global-tokens", 0, "tokens per interval of the global bucket, if enabled")
+		globalKey    = fs.String("global-key", "", "key of the global bucket, if not the default")
+		debtLimit    = fs.Uint64("debt-limit", 0, "tokens a key may borrow")
+		localBatch   = fs.Uint64("local-batch", 0, "tokens taken from Redis at a time for each key, if batching")
+		coalesce     = fs.Bool("coalesce", false, "batch concurrent takes on the same key into one call")
+		hotKeysWin   = fs.Duration("hotkeys-window", 0, "window to track the hottest keys over for the admin API, if any")
+
+		authAddr      = fs.String("auth-addr", "", "address to serve the NGINX auth_request endpoint on, if any")
+		authKeyHeader = fs.String("auth-key-header", "X-Real-IP", "header of auth_request subrequests with the key")
+		spoeAddr      = fs.String("spoe-addr", "", "address to serve the HAProxy SPOE agent on, if any")
+
+		admin adminConfig
+	)
+	fs.StringVar(&admin.addr, "admin-addr", "", "address to serve the admin API on, if any")
+	fs.StringVar(&admin.tokensFile, "admin-tokens", "", `file of admin API tokens, one "TOKEN NAME ROLE" per line`)
+	fs.StringVar(&admin.certFile, "admin-cert", "", "TLS certificate of the admin API")
+	fs.StringVar(&admin.keyFile, "admin-key", "", "TLS key of the admin API")
+	fs.StringVar(&admin.clientCA, "admin-client-ca", "", "CA of admin API client certificates, to authenticate them")
+	fs.StringVar(&admin.clientRoles, "admin-client-roles", "", `roles of admin API client certificates, as "CN=ROLE,..."`)
+	fs.StringVar(&admin.auditFile, "admin-audit", "", "file to append an audit log of resets and bans to, as JSON lines")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid socket mode %q", *socketMode)
+	}
+
+	s, err := redisstore.NewFromURL(*rawurl, &redisstore.Config{
+		Tokens:       *tokens,
+		Interval:     *interval,
+		KeyPrefix:    *prefix,
+		GlobalTokens: *globalTokens,
+		GlobalKey:    *globalKey,
+		DebtLimit:    *debtLimit,
+		LocalBatch:   *localBatch,
+		Coalesce:     *coalesce,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer s.Close()
+
+	if *hotKeysWin > 0 {
+		hk, err := hotkeys.New(s, &hotkeys.Config{Window: *hotKeysWin})
+		if err != nil {
+			return err
+		}
+		admin.hotKeys = hk
+		s = hk
+	}
+
+	l, err := unixstore.Listen(*socket)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(*socket, os.FileMode(mode)); err != nil {
+		l.Close()
+		return fmt.Errorf("failed to set socket mode: %w", err)
+	}
+
+	srv := unixstore.NewServer(s)
+	if *socketTokens != "" {
+		if srv.Tokens, err = readSocketTokens(*socketTokens); err != nil {
+			l.Close()
+			return err
+		}
+	}
+
+	var adminSrv *http.Server
+	if admin.addr != "" {
+		admin.policies = []adminlimit.Policy{
+			{Name: "default", Tokens: *tokens, Interval: *interval, Description: "Every key."},
+		}
+		if *globalTokens > 0 {
+			admin.policies = append(admin.policies, adminlimit.Policy{
+				Name:        "global",
+				Tokens:      *globalTokens,
+				Interval:    *interval,
+				Description: "All keys together.",
+			})
+		}
+		var auditLog io.Closer
+		if adminSrv, auditLog, err = newAdminServer(s, &admin); err != nil {
+			l.Close()
+			return err
+		}
+		defer auditLog.Close()
+		go func() {
+			var err error
+			if adminSrv.TLSConfig != nil {
+				err = adminSrv.ListenAndServeTLS(admin.certFile, admin.keyFile)
+			} else {
+				err = adminSrv.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				fmt.Fprintf(os.Stderr, "limiterd: admin API: %s\n", err)
+				srv.Close()
+			}
+		}()
+	}
+
+	var authSrv *http.Server
+	if *authAddr != "" {
+		h, err := proxylimit.AuthHandler(s, httplimit.IPKeyFunc(*authKeyHeader))
+		if err != nil {
+			l.Close()
+			return err
+		}
+		authSrv = &http.Server{Addr: *authAddr, Handler: h}
+		go func() {
+			if err := authSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Fprintf(os.Stderr, "limiterd: auth endpoint: %s\n", err)
+				srv.Close()
+			}
+		}()
+	}
+
+	if *spoeAddr != "" {
+		agent, err := proxylimit.NewAgent(s)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		spoeListener, err := net.Listen("tcp", *spoeAddr)
+		if err != nil {
+			l.Close()
+			return fmt.Errorf("failed to listen for spoe: %w", err)
+		}
+		defer agent.Close()
+		go func() {
+			if err := agent.Serve(spoeListener); err != nil {
+				fmt.Fprintf(os.Stderr, "limiterd: spoe agent: %s\n", err)
+				srv.Close()
+			}
+		}()
+	}
+
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signalCh
+		srv.Close()
+	}()
+
+	if err := srv.Serve(l); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if adminSrv != nil {
+		adminSrv.Shutdown(ctx)
+	}
+	if authSrv != nil {
+		authSrv.Shutdown(ctx)
+	}
+	return srv.Close()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sethvargo/go-limiter/unixstore"
+)
+
+// readSocketTokens reads the socket tokens file, one "TOKEN OPS" per line,
+// where OPS is "all" or a comma-separated list of "take", "refund", "charge",
+// and "peek". Blank lines and lines starting with "#" are ignored.
+func readSocketTokens(path string) (map[string]unixstore.Ops, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open socket tokens file: %w", err)
+	}
+	defer f.Close()
+
+	tokens := make(map[string]unixstore.Ops)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("socket tokens file line %d: expected TOKEN OPS", line)
+		}
+		ops, err := parseOps(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("socket tokens file line %d: %w", line, err)
+		}
+		tokens[fields[0]] = ops
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read socket tokens file: %w", err)
+	}
+	return tokens, nil
+}
+
+func parseOps(s string) (unixstore.Ops, error) {
+	var ops unixstore.Ops
+	for _, name := range strings.Split(s, ",") {
+		switch name {
+		case "all":
+			ops |= unixstore.AllOps
+		case "take":
+			ops |= unixstore.OpTake
+		case "refund":
+			ops |= unixstore.OpRefund
+		case "charge":
+			ops |= unixstore.OpCharge
+		case "peek":
+			ops |= unixstore.OpPeek
+		default:
+			return 0, fmt.Errorf("unknown operation %q", name)
+		}
+	}
+	return ops, nil
+}
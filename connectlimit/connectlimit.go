@@ -0,0 +1,165 @@
+// Package connectlimit provides interceptors for rate limiting Connect
+// (connectrpc.com) handlers.
+//
+// Services that also serve HTTP, directly or through grpc-gateway, can enforce
+// the same limits at both edges by sharing the store and keying requests the
+// same way. The grpc-gateway mux is an http.Handler, so it is wrapped with
+// httplimit like any other handler, and HTTPKeyFunc adapts the same
+// httplimit.KeyFunc for the interceptor:
+//
+//	keyFunc := httplimit.IPKeyFunc("X-Forwarded-For")
+//
+//	middleware, err := httplimit.NewMiddleware(store, keyFunc)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	mux.Handle("/v1/", middleware.Handle(gatewayMux))
+//
+//	interceptor, err := connectlimit.NewInterceptor(store, connectlimit.HTTPKeyFunc(keyFunc))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	mux.Handle(greetv1connect.NewGreetServiceHandler(greeter,
+//		connect.WithInterceptors(interceptor)))
+//
+// If the gateway calls the Connect handlers, limit only one edge, or the
+// gateway's requests are counted twice.
+package connectlimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/httplimit"
+)
+
+var _ connect.Interceptor = (*Interceptor)(nil)
+
+// KeyFunc returns the key that identifies a request for the purpose of rate
+// limiting. If it returns an error, the request fails with CodeInternal and
+// does NOT take from the store.
+type KeyFunc func(ctx context.Context, spec connect.Spec, peer connect.Peer, header http.Header) (string, error)
+
+// HTTPKeyFunc adapts an httplimit.KeyFunc, so HTTP and Connect requests are
+// keyed the same way. The function is given a request with the Connect
+// request's headers, the procedure as the path, and the peer's address as the
+// RemoteAddr.
+func HTTPKeyFunc(f httplimit.KeyFunc) KeyFunc {
+	return func(ctx context.Context, spec connect.Spec, peer connect.Peer, header http.Header) (string, error) {
+		r, err := http.NewRequest(http.MethodPost, spec.Procedure, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to build request: %w", err)
+		}
+		r = r.WithContext(ctx)
+		r.Header = header
+		r.RemoteAddr = peer.Addr
+		return f(r)
+	}
+}
+
+// IPKeyFunc keys requests by the peer's IP address, or the first of the headers
+// that is set, like httplimit.IPKeyFunc.
+func IPKeyFunc(headers ...string) KeyFunc {
+	return HTTPKeyFunc(httplimit.IPKeyFunc(headers...))
+}
+
+// Interceptor limits the unary and streaming Connect handlers it is installed
+// on. Each call takes a token; streams take one when they open. Client calls
+// are not limited.
+type Interceptor struct {
+	store   limiter.Store
+	keyFunc KeyFunc
+}
+
+// NewInterceptor creates an interceptor that takes from the store with the
+// key from f. It returns an error if either is nil.
+func NewInterceptor(s limiter.Store, f KeyFunc) (*Interceptor, error) {
+	if s == nil {
+		return nil, fmt.Errorf("store cannot be nil")
+	}
+
+	if f == nil {
+		return nil, fmt.Errorf("key function cannot be nil")
+	}
+
+	return &Interceptor{
+		store:   s,
+		keyFunc: f,
+	}, nil
+}
+
+// WrapUnary implements connect.Interceptor. Rejected calls fail with
+// CodeResourceExhausted, and the rate limit headers of httplimit are set on
+// the response or error.
+func (i *Interceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return next(ctx, req)
+		}
+
+		res, limited, err := i.take(ctx, req.Spec(), req.Peer(), req.Header())
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := next(ctx, req)
+		if limited {
+			if resp != nil {
+				httplimit.SetHeaders(resp.Header(), res, nil)
+			} else if cerr, ok := err.(*connect.Error); ok {
+				httplimit.SetHeaders(cerr.Meta(), res, nil)
+			}
+		}
+		return resp, err
+	}
+}
+
+// WrapStreamingClient implements connect.Interceptor. Client streams are not
+// limited.
+func (i *Interceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+// WrapStreamingHandler implements connect.Interceptor. Streams that are
+// rejected fail with CodeResourceExhausted before the handler is called.
+func (i *Interceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		res, limited, err := i.take(ctx, conn.Spec(), conn.Peer(), conn.RequestHeader())
+		if err != nil {
+			return err
+		}
+		if limited {
+			httplimit.SetHeaders(conn.ResponseHeader(), res, nil)
+		}
+		return next(ctx, conn)
+	}
+}
+
+// take takes a token for the call. It returns an error to fail the call with
+// if it is rejected, and whether the result has limit metadata.
+func (i *Interceptor) take(ctx context.Context, spec connect.Spec, peer connect.Peer, header http.Header) (limiter.Result, bool, error) {
+	key, err := i.keyFunc(ctx, spec, peer, header)
+	if err != nil {
+		return limiter.Result{}, false, connect.NewError(connect.CodeInternal, fmt.Errorf("failed to key request: %w", err))
+	}
+
+	// If the store failed closed, it's an internal error. If it failed open,
+	// the call is permitted, and there is no limit metadata to report.
+	res, err := i.store.Take(ctx, key)
+	if err != nil {
+		if !res.Allowed {
+			return limiter.Result{}, false, connect.NewError(connect.CodeInternal, fmt.Errorf("failed to take: %w", err))
+		}
+		return limiter.Result{}, false, nil
+	}
+
+	if !res.Allowed {
+		cerr := connect.NewError(connect.CodeResourceExhausted, fmt.Errorf("rate limit exceeded"))
+		httplimit.SetHeaders(cerr.Meta(), res, nil)
+		return limiter.Result{}, false, cerr
+	}
+	return res, true, nil
+}
@@ -0,0 +1,162 @@
+package connectlimit_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/sethvargo/go-limiter/connectlimit"
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/limittest"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const procedure = "/test.v1.TestService/Ping"
+
+// newServer serves a unary procedure limited by the store.
+func newServer(t *testing.T, store *limittest.Store, f connectlimit.KeyFunc) *connect.Client[emptypb.Empty, emptypb.Empty] {
+	t.Helper()
+
+	interceptor, err := connectlimit.NewInterceptor(store, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(procedure, connect.NewUnaryHandler(procedure,
+		func(ctx context.Context, req *connect.Request[emptypb.Empty]) (*connect.Response[emptypb.Empty], error) {
+			return connect.NewResponse(&emptypb.Empty{}), nil
+		},
+		connect.WithInterceptors(interceptor)))
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return connect.NewClient[emptypb.Empty, emptypb.Empty](srv.Client(), srv.URL+procedure)
+}
+
+func TestInterceptor(t *testing.T) {
+	t.Parallel()
+
+	store := limittest.NewStore(2, time.Minute)
+	client := newServer(t, store, connectlimit.IPKeyFunc())
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.CallUnary(ctx, connect.NewRequest(&emptypb.Empty{}))
+		if err != nil {
+			t.Fatalf("call %d: %s", i, err)
+		}
+		if got, want := resp.Header().Get(httplimit.HeaderRateLimitRemaining), []string{"1", "0"}[i]; got != want {
+			t.Errorf("call %d: remaining: expected %q to be %q", i, got, want)
+		}
+	}
+
+	_, err := client.CallUnary(ctx, connect.NewRequest(&emptypb.Empty{}))
+	var cerr *connect.Error
+	if !errors.As(err, &cerr) {
+		t.Fatalf("expected connect error, got %v", err)
+	}
+	if got, want := cerr.Code(), connect.CodeResourceExhausted; got != want {
+		t.Errorf("expected %s to be %s", got, want)
+	}
+	if got, want := cerr.Meta().Get(httplimit.HeaderRetryAfter), "60"; got != want {
+		t.Errorf("retry after: expected %q to be %q", got, want)
+	}
+
+	if got, want := store.Takes("127.0.0.1"), uint64(3); got != want {
+		t.Errorf("takes: expected %d to be %d", got, want)
+	}
+}
+
+func TestInterceptor_Errors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		keyErr   error
+		storeErr error
+		failOpen bool
+		code     connect.Code
+	}{
+		{
+			name:   "key_error",
+			keyErr: errors.New("no key"),
+			code:   connect.CodeInternal,
+		},
+		{
+			name:     "fail_closed",
+			storeErr: errors.New("down"),
+			code:     connect.CodeInternal,
+		},
+		{
+			name:     "fail_open",
+			storeErr: errors.New("down"),
+			failOpen: true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := limittest.NewStore(1, time.Minute)
+			if tc.storeErr != nil {
+				store.Fail(tc.storeErr, tc.failOpen)
+			}
+			client := newServer(t, store, func(context.Context, connect.Spec, connect.Peer, http.Header) (string, error) {
+				return "key", tc.keyErr
+			})
+
+			_, err := client.CallUnary(context.Background(), connect.NewRequest(&emptypb.Empty{}))
+			if tc.code == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if got, want := connect.CodeOf(err), tc.code; got != want {
+				t.Errorf("expected %s to be %s", got, want)
+			}
+		})
+	}
+}
+
+func TestHTTPKeyFunc(t *testing.T) {
+	t.Parallel()
+
+	f := connectlimit.HTTPKeyFunc(httplimit.FirstKeyFunc(
+		httplimit.HeaderKeyFunc("X-API-Key"),
+		httplimit.IPKeyFunc(),
+	))
+
+	header := http.Header{"X-Api-Key": {"s3cret"}}
+	got, err := f(context.Background(), connect.Spec{Procedure: procedure}, connect.Peer{Addr: "192.0.2.1:1234"}, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-API-Key", "s3cret")
+	want, err := httplimit.HeaderKeyFunc("X-API-Key")(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+
+	got, err = f(context.Background(), connect.Spec{Procedure: procedure}, connect.Peer{Addr: "192.0.2.1:1234"}, http.Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "192.0.2.1"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
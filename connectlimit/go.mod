@@ -0,0 +1,12 @@
+module github.com/sethvargo/go-limiter/connectlimit
+
+go 1.20
+
+require (
+	connectrpc.com/connect v1.16.2
+	github.com/sethvargo/go-limiter v0.1.0
+)
+
+require google.golang.org/protobuf v1.33.0
+
+replace github.com/sethvargo/go-limiter => ../
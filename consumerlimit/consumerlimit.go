@@ -0,0 +1,201 @@
+// Package consumerlimit paces the processing of messages from queues and
+// streams, like Kafka topics or NATS subjects, per partition or per tenant,
+// so event-driven services can share the stores and keys of their HTTP edge.
+//
+// It does not depend on a client library. Adapters convert the library's
+// messages to a Message, and optionally pause fetching from a partition while
+// its messages wait, so the client does not buffer more of them:
+//
+//	consumer, err := consumerlimit.New(store, &consumerlimit.Config{
+//		KeyFunc: consumerlimit.HeaderKey("tenant"),
+//		Pauser:  pauser,
+//	})
+//
+//	handle := consumer.Handle(func(ctx context.Context, m *consumerlimit.Message) error {
+//		return process(m.Value.(*kafka.Message))
+//	})
+//
+// Messages over the limit are not dropped or rejected: Handle waits for a
+// token of their key before processing them, since a message that is not
+// processed would only be redelivered.
+package consumerlimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// Message is a consumed message.
+type Message struct {
+	// Topic is the topic, stream, or subject the message was consumed from.
+	Topic string
+
+	// Partition is the partition of the topic, or 0 for systems without them.
+	Partition int32
+
+	// Key is the key of the message, if any.
+	Key []byte
+
+	// Headers are the headers of the message, if any.
+	Headers map[string]string
+
+	// Value is the client library's message, for the handler.
+	Value interface{}
+}
+
+// Handler processes a message.
+type Handler func(ctx context.Context, m *Message) error
+
+// KeyFunc returns the key a message is paced by, or "" if it is not paced.
+type KeyFunc func(m *Message) string
+
+// PartitionKey paces each partition of each topic separately.
+func PartitionKey(m *Message) string {
+	return m.Topic + "/" + strconv.FormatInt(int64(m.Partition), 10)
+}
+
+// TopicKey paces each topic, across its partitions.
+func TopicKey(m *Message) string {
+	return m.Topic
+}
+
+// HeaderKey paces messages by the value of the header, like a tenant ID, so
+// they share the tenant's limit with its other traffic. Messages without the
+// header are not paced.
+func HeaderKey(name string) KeyFunc {
+	return func(m *Message) string {
+		return m.Headers[name]
+	}
+}
+
+// Pauser pauses and resumes fetching from a partition, like the Pause and
+// Resume methods of Kafka consumers. It is called with the message that is
+// waiting, while a lock is held, so it must not block.
+type Pauser interface {
+	Pause(m *Message)
+	Resume(m *Message)
+}
+
+// Config is used as input to New.
+type Config struct {
+	// KeyFunc returns the key of each message. The default is PartitionKey.
+	KeyFunc KeyFunc
+
+	// Pauser, if set, pauses the partition of a message while it waits for a
+	// token, and resumes it once no message of the partition waits.
+	Pauser Pauser
+}
+
+// Consumer paces messages with a store.
+type Consumer struct {
+	store   limiter.Store
+	keyFunc KeyFunc
+	pauser  Pauser
+
+	// lock guards waiting, the number of waiting messages per partition.
+	lock    sync.Mutex
+	waiting map[string]int
+}
+
+// New creates a consumer. It returns an error if the store is nil.
+func New(s limiter.Store, c *Config) (*Consumer, error) {
+	if s == nil {
+		return nil, fmt.Errorf("store cannot be nil")
+	}
+	if c == nil {
+		c = new(Config)
+	}
+
+	keyFunc := PartitionKey
+	if c.KeyFunc != nil {
+		keyFunc = c.KeyFunc
+	}
+
+	return &Consumer{
+		store:   s,
+		keyFunc: keyFunc,
+		pauser:  c.Pauser,
+		waiting: make(map[string]int),
+	}, nil
+}
+
+// Handle returns a handler that waits for a token of each message's key, and
+// passes the message to next. If the wait fails, the message is not processed
+// and the error is returned, so the adapter does not commit it.
+func (c *Consumer) Handle(next Handler) Handler {
+	return func(ctx context.Context, m *Message) error {
+		if err := c.Wait(ctx, m); err != nil {
+			return err
+		}
+		return next(ctx, m)
+	}
+}
+
+// Wait blocks until the message's key has a token, or the context is done.
+// While it waits, the message's partition is paused. If the store fails
+// closed, Wait returns its error; if it fails open, the message proceeds.
+func (c *Consumer) Wait(ctx context.Context, m *Message) error {
+	key := c.keyFunc(m)
+	if key == "" {
+		return nil
+	}
+
+	res, err := c.store.Take(ctx, key)
+	if err != nil && !res.Allowed {
+		return fmt.Errorf("failed to take: %w", err)
+	}
+	if res.Allowed {
+		return nil
+	}
+
+	c.pause(m)
+	defer c.resume(m)
+
+	if res, err := limiter.Wait(ctx, c.store, key); err != nil && !res.Allowed {
+		return fmt.Errorf("failed to take: %w", err)
+	}
+	return nil
+}
+
+// Waiting returns the number of messages of the partition that wait for a
+// token.
+func (c *Consumer) Waiting(topic string, partition int32) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.waiting[PartitionKey(&Message{Topic: topic, Partition: partition})]
+}
+
+// pause pauses the partition of the message when its first message waits.
+func (c *Consumer) pause(m *Message) {
+	partition := PartitionKey(m)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.waiting[partition]++
+	if c.waiting[partition] == 1 && c.pauser != nil {
+		c.pauser.Pause(m)
+	}
+}
+
+// resume resumes the partition of the message when its last message is done
+// waiting.
+func (c *Consumer) resume(m *Message) {
+	partition := PartitionKey(m)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.waiting[partition]--
+	if c.waiting[partition] > 0 {
+		return
+	}
+	delete(c.waiting, partition)
+	if c.pauser != nil {
+		c.pauser.Resume(m)
+	}
+}
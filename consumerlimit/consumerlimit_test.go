@@ -0,0 +1,198 @@
+package consumerlimit_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/consumerlimit"
+	"github.com/sethvargo/go-limiter/limittest"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+// pauser records the pauses and resumes of partitions.
+type pauser struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (p *pauser) Pause(m *consumerlimit.Message) {
+	p.record("pause " + consumerlimit.PartitionKey(m))
+}
+
+func (p *pauser) Resume(m *consumerlimit.Message) {
+	p.record("resume " + consumerlimit.PartitionKey(m))
+}
+
+func (p *pauser) record(event string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.events = append(p.events, event)
+}
+
+func (p *pauser) Events() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]string(nil), p.events...)
+}
+
+func TestConsumer_Handle(t *testing.T) {
+	t.Parallel()
+
+	store, err := memorystore.New(&memorystore.Config{
+		Tokens:   1,
+		Interval: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	p := new(pauser)
+	consumer, err := consumerlimit.New(store, &consumerlimit.Config{Pauser: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handled int
+	handle := consumer.Handle(func(ctx context.Context, m *consumerlimit.Message) error {
+		handled++
+		return nil
+	})
+
+	// The second message of the partition waits for the next interval, with
+	// the partition paused, and other partitions are not held up.
+	start := time.Now()
+	for _, m := range []*consumerlimit.Message{
+		{Topic: "orders", Partition: 0},
+		{Topic: "orders", Partition: 1},
+		{Topic: "orders", Partition: 0},
+	} {
+		if err := handle(context.Background(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("expected to wait for the next interval, got %s", d)
+	}
+
+	if got, want := handled, 3; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+	events := p.Events()
+	if got, want := len(events), 2; got != want {
+		t.Fatalf("expected %d events to be %d: %q", got, want, events)
+	}
+	if got, want := events[0], "pause orders/0"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if got, want := events[1], "resume orders/0"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if got, want := consumer.Waiting("orders", 0), 0; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+func TestConsumer_Wait(t *testing.T) {
+	t.Parallel()
+
+	down := errors.New("down")
+
+	cases := []struct {
+		name     string
+		keyFunc  consumerlimit.KeyFunc
+		headers  []map[string]string
+		fail     error
+		failOpen bool
+		err      error
+	}{
+		{
+			name:    "tenant_limited",
+			keyFunc: consumerlimit.HeaderKey("tenant"),
+			headers: []map[string]string{{"tenant": "acme"}, {"tenant": "acme"}},
+			err:     context.DeadlineExceeded,
+		},
+		{
+			name:    "tenants_separate",
+			keyFunc: consumerlimit.HeaderKey("tenant"),
+			headers: []map[string]string{{"tenant": "acme"}, {"tenant": "globex"}},
+		},
+		{
+			name:    "no_key",
+			keyFunc: consumerlimit.HeaderKey("tenant"),
+			headers: []map[string]string{nil, nil},
+		},
+		{
+			name:    "topic",
+			keyFunc: consumerlimit.TopicKey,
+			headers: []map[string]string{nil, nil},
+			err:     context.DeadlineExceeded,
+		},
+		{
+			name:    "fail_closed",
+			headers: []map[string]string{nil},
+			fail:    down,
+			err:     down,
+		},
+		{
+			name:     "fail_open",
+			headers:  []map[string]string{nil, nil},
+			fail:     down,
+			failOpen: true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := limittest.NewStore(1, time.Hour)
+			if tc.fail != nil {
+				store.Fail(tc.fail, tc.failOpen)
+			}
+
+			p := new(pauser)
+			consumer, err := consumerlimit.New(store, &consumerlimit.Config{
+				KeyFunc: tc.keyFunc,
+				Pauser:  p,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The key has no tokens until its reset in an hour, past the
+			// deadline.
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			for i, headers := range tc.headers {
+				err = consumer.Wait(ctx, &consumerlimit.Message{
+					Topic:     "events",
+					Partition: int32(i),
+					Headers:   headers,
+				})
+				if err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tc.err) {
+				t.Errorf("expected %v to be %v", err, tc.err)
+			}
+
+			// Partitions are resumed even if the wait failed.
+			events := p.Events()
+			if len(events)%2 != 0 {
+				t.Errorf("expected partitions to be resumed: %q", events)
+			}
+		})
+	}
+
+	if _, err := consumerlimit.New(nil, nil); err == nil {
+		t.Error("expected error for nil store")
+	}
+}
@@ -0,0 +1,212 @@
+// Package crawl paces crawlers and scrapers per target host, so they stay
+// within the limits of each site and honor the Crawl-delay of its robots.txt:
+//
+//	c, err := crawl.New(&crawl.Config{
+//		Store: store,
+//		CrawlDelay: func(ctx context.Context, host string) (time.Duration, error) {
+//			return robots.CrawlDelay(ctx, host)
+//		},
+//	})
+//
+//	for _, u := range urls {
+//		if err := c.Wait(ctx, u); err != nil {
+//			return err
+//		}
+//		// Fetch u.
+//	}
+//
+// This package does not fetch or parse robots.txt itself; CrawlDelay is called
+// with each host the first time it is crawled, and its result is cached.
+package crawl
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// DelayFunc returns the Crawl-delay of the host, or 0 if it has none.
+type DelayFunc func(ctx context.Context, host string) (time.Duration, error)
+
+// Config is used as input to New.
+type Config struct {
+	// Store limits the requests to each host, keyed by Host. It is required.
+	Store limiter.Store
+
+	// CrawlDelay, if set, returns the Crawl-delay of a host, like from its
+	// robots.txt. Requests to the host are then spaced at least that far
+	// apart, on top of the store's limit.
+	CrawlDelay DelayFunc
+
+	// DelayTTL is how long the Crawl-delay of a host is cached before
+	// CrawlDelay is called again. The default value is 24 hours.
+	DelayTTL time.Duration
+}
+
+// Crawler paces requests per host. Spacing for the Crawl-delay is tracked per
+// Crawler, so share one Crawler between the workers of a crawl.
+type Crawler struct {
+	store      limiter.Store
+	crawlDelay DelayFunc
+	delayTTL   time.Duration
+
+	// lock guards the hosts, and swept is their number after the last sweep.
+	lock  sync.Mutex
+	hosts map[string]*host
+	swept int
+}
+
+// host is the state of a host.
+type host struct {
+	delay   time.Duration
+	expires time.Time
+	next    time.Time
+}
+
+// New creates a crawler. It returns an error if the store is nil.
+func New(c *Config) (*Crawler, error) {
+	if c == nil || c.Store == nil {
+		return nil, fmt.Errorf("store cannot be nil")
+	}
+
+	delayTTL := 24 * time.Hour
+	if c.DelayTTL > 0 {
+		delayTTL = c.DelayTTL
+	}
+
+	return &Crawler{
+		store:      c.Store,
+		crawlDelay: c.CrawlDelay,
+		delayTTL:   delayTTL,
+		hosts:      make(map[string]*host),
+	}, nil
+}
+
+// Host returns the host of a URL, which requests are paced by. It includes
+// the port, since robots.txt applies to each port separately.
+func Host(u *url.URL) string {
+	return strings.ToLower(u.Host)
+}
+
+// Wait blocks until a request to the URL may be sent: until the host has a
+// token in the store, and until its Crawl-delay has passed since the last
+// request to it. It returns an error if the context is done first, or if the
+// store or CrawlDelay fail. If the context has a deadline that would pass
+// before the request may be sent, Wait returns context.DeadlineExceeded
+// immediately instead of sleeping.
+func (c *Crawler) Wait(ctx context.Context, u *url.URL) error {
+	key := Host(u)
+
+	delay, err := c.delay(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to get crawl delay of %s: %w", key, err)
+	}
+
+	if _, err := limiter.Wait(ctx, c.store, key); err != nil {
+		return fmt.Errorf("failed to take: %w", err)
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	// Reserve the next slot of the host, a Crawl-delay after the last one.
+	now := time.Now()
+	c.lock.Lock()
+	h, ok := c.hosts[key]
+	if !ok {
+		h = &host{delay: delay}
+		c.hosts[key] = h
+	}
+	at := h.next
+	if at.Before(now) {
+		at = now
+	}
+	h.next = at.Add(delay)
+	c.lock.Unlock()
+
+	d := at.Sub(now)
+	if d <= 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(at) {
+		c.release(key, at, delay)
+		return context.DeadlineExceeded
+	}
+
+	timer := time.NewTimer(d)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		c.release(key, at, delay)
+		return ctx.Err()
+	case <-timer.C:
+	}
+	return nil
+}
+
+// delay returns the Crawl-delay of the host, calling CrawlDelay if it is not
+// cached.
+func (c *Crawler) delay(ctx context.Context, key string) (time.Duration, error) {
+	if c.crawlDelay == nil {
+		return 0, nil
+	}
+
+	now := time.Now()
+	c.lock.Lock()
+	if h, ok := c.hosts[key]; ok && now.Before(h.expires) {
+		c.lock.Unlock()
+		return h.delay, nil
+	}
+	c.lock.Unlock()
+
+	// The lock is not held while CrawlDelay runs, since it likely fetches
+	// robots.txt.
+	delay, err := c.crawlDelay(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	h, ok := c.hosts[key]
+	if !ok {
+		c.sweep(now)
+		h = new(host)
+		c.hosts[key] = h
+	}
+	h.delay = delay
+	h.expires = now.Add(c.delayTTL)
+	return delay, nil
+}
+
+// release gives up the slot at the time, if no later one was reserved, so a
+// canceled request does not delay the next.
+func (c *Crawler) release(key string, at time.Time, delay time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if h, ok := c.hosts[key]; ok && h.next.Equal(at.Add(delay)) {
+		h.next = at
+	}
+}
+
+// sweep removes the hosts whose Crawl-delay expired and whose slots passed,
+// once their number doubled since the last sweep, so a long crawl of many
+// hosts does not keep all of them. It must be called with the lock held.
+func (c *Crawler) sweep(now time.Time) {
+	if len(c.hosts) < 2*c.swept+64 {
+		return
+	}
+	for key, h := range c.hosts {
+		if !now.Before(h.expires) && !now.Before(h.next) {
+			delete(c.hosts, key)
+		}
+	}
+	c.swept = len(c.hosts)
+}
@@ -0,0 +1,182 @@
+package crawl_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/crawl"
+	"github.com/sethvargo/go-limiter/limittest"
+)
+
+func mustParse(tb testing.TB, s string) *url.URL {
+	tb.Helper()
+
+	u, err := url.Parse(s)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return u
+}
+
+func TestCrawler_Wait(t *testing.T) {
+	t.Parallel()
+
+	var lock sync.Mutex
+	calls := make(map[string]int)
+
+	c, err := crawl.New(&crawl.Config{
+		Store: limittest.NewStore(100, time.Hour),
+		CrawlDelay: func(ctx context.Context, host string) (time.Duration, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			calls[host]++
+
+			if host == "slow.example.com" {
+				return 50 * time.Millisecond, nil
+			}
+			return 0, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wait := func(s string) time.Duration {
+		start := time.Now()
+		if err := c.Wait(context.Background(), mustParse(t, s)); err != nil {
+			t.Fatal(err)
+		}
+		return time.Since(start)
+	}
+
+	// Requests to a host with a Crawl-delay are spaced by it, and other hosts
+	// are not held up.
+	var total time.Duration
+	for _, path := range []string{"/a", "/b", "/c"} {
+		total += wait("https://SLOW.example.com" + path)
+		if d := wait("https://fast.example.com" + path); d > 20*time.Millisecond {
+			t.Errorf("expected no wait for fast host, got %s", d)
+		}
+	}
+	if total < 90*time.Millisecond {
+		t.Errorf("expected waits of about 100ms for slow host, got %s", total)
+	}
+
+	// The Crawl-delay of each host is cached.
+	lock.Lock()
+	defer lock.Unlock()
+	for _, host := range []string{"slow.example.com", "fast.example.com"} {
+		if got, want := calls[host], 1; got != want {
+			t.Errorf("expected %d calls for %s to be %d", got, host, want)
+		}
+	}
+}
+
+func TestCrawler_Wait_errors(t *testing.T) {
+	t.Parallel()
+
+	t.Run("store_exhausted", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := crawl.New(&crawl.Config{Store: limittest.NewStore(1, time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u := mustParse(t, "https://example.com/")
+		if err := c.Wait(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+
+		// The host has no tokens until its reset in an hour.
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := c.Wait(ctx, u); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected %v to be %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("crawl_delay_deadline", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := crawl.New(&crawl.Config{
+			Store: limittest.NewStore(10, time.Hour),
+			CrawlDelay: func(ctx context.Context, host string) (time.Duration, error) {
+				return time.Hour, nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u := mustParse(t, "https://example.com/")
+		if err := c.Wait(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := c.Wait(ctx, u); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected %v to be %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("crawl_delay_error", func(t *testing.T) {
+		t.Parallel()
+
+		want := errors.New("robots.txt unavailable")
+		c, err := crawl.New(&crawl.Config{
+			Store: limittest.NewStore(10, time.Hour),
+			CrawlDelay: func(ctx context.Context, host string) (time.Duration, error) {
+				return 0, want
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Wait(context.Background(), mustParse(t, "https://example.com/")); !errors.Is(err, want) {
+			t.Errorf("expected %v to be %v", err, want)
+		}
+	})
+}
+
+func TestHost(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		url  string
+		exp  string
+	}{
+		{name: "lowercase", url: "https://Example.COM/path?q=1", exp: "example.com"},
+		{name: "port", url: "http://example.com:8080/", exp: "example.com:8080"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got, want := crawl.Host(mustParse(t, tc.url)), tc.exp; got != want {
+				t.Errorf("expected %q to be %q", got, want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	if _, err := crawl.New(nil); err == nil {
+		t.Error("expected error for nil config")
+	}
+	if _, err := crawl.New(&crawl.Config{}); err == nil {
+		t.Error("expected error for nil store")
+	}
+}
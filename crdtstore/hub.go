@@ -0,0 +1,131 @@
+package crdtstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+var _ Transport = (*Hub)(nil)
+var _ Transport = (*HTTPTransport)(nil)
+
+// maxSnapshotSize is the largest request or response body read by the hub and
+// the HTTP transport.
+const maxSnapshotSize = 32 << 20
+
+// Hub collects the latest snapshot of each region and hands them to the other
+// regions. Stores in the same process can use it directly as their Transport,
+// and stores elsewhere can reach it over HTTP with an HTTPTransport, since it
+// is also an http.Handler.
+type Hub struct {
+	lock      sync.Mutex
+	snapshots map[string]*Snapshot
+}
+
+// NewHub creates an empty hub.
+func NewHub() *Hub {
+	return &Hub{
+		snapshots: make(map[string]*Snapshot),
+	}
+}
+
+// Exchange stores the local snapshot, unless the hub already has one for a
+// later window, and returns the other regions' snapshots.
+func (h *Hub) Exchange(_ context.Context, local *Snapshot) ([]*Snapshot, error) {
+	if local == nil || local.Region == "" {
+		return nil, fmt.Errorf("missing region")
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if prev, ok := h.snapshots[local.Region]; !ok || prev.Window <= local.Window {
+		h.snapshots[local.Region] = local
+	}
+
+	remote := make([]*Snapshot, 0, len(h.snapshots))
+	for region, snap := range h.snapshots {
+		if region != local.Region {
+			remote = append(remote, snap)
+		}
+	}
+	return remote, nil
+}
+
+// ServeHTTP exchanges the snapshot in the JSON request body and responds with
+// the other regions' snapshots as a JSON array.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var local Snapshot
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSnapshotSize)).Decode(&local); err != nil {
+		http.Error(w, fmt.Sprintf("invalid snapshot: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	remote, err := h.Exchange(r.Context(), &local)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(remote)
+}
+
+// HTTPTransport exchanges snapshots with a Hub served over HTTP.
+type HTTPTransport struct {
+	// URL is the URL the hub is served at.
+	URL string
+
+	// Client is the HTTP client used for requests. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Exchange posts the local snapshot to the hub and returns the other regions'
+// snapshots.
+func (t *HTTPTransport) Exchange(ctx context.Context, local *Snapshot) ([]*Snapshot, error) {
+	body, err := json.Marshal(local)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach hub: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("hub returned %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	var remote []*Snapshot
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxSnapshotSize)).Decode(&remote); err != nil {
+		return nil, fmt.Errorf("invalid hub response: %w", err)
+	}
+	return remote, nil
+}
@@ -0,0 +1,292 @@
+// Package crdtstore defines an experimental storage system for limiting across
+// several regions that are all active at once.
+//
+// Each region decides takes locally, without a cross-region round trip, using
+// its own count of takes plus the latest counts it has seen from the other
+// regions. The counts are grow-only counters, one per region, per key, per
+// fixed window of Interval, so merging them is a per-region maximum and is
+// safe to repeat or reorder. Regions exchange their counts every SyncInterval
+// through a Transport.
+//
+// The limit is approximately global: between exchanges, each region only knows
+// the other regions' counts as of the last one, so the regions together may
+// allow up to the tokens left at that point once per region. Windows are
+// aligned to the wall clock, so the regions' clocks should be synchronized.
+//
+// Deployments on Redis Enterprise Active-Active (CRDB) databases can instead
+// keep each region's counts in its local database and let the database
+// replicate them, by implementing a Transport that reads and writes them.
+package crdtstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+var _ limiter.Store = (*store)(nil)
+
+type store struct {
+	tokens       uint64
+	interval     time.Duration
+	region       string
+	transport    Transport
+	syncInterval time.Duration
+	onError      func(error)
+
+	// lock guards the window and counts. counts holds the count of each key by
+	// region for the current window.
+	lock   sync.Mutex
+	window int64
+	counts map[string]map[string]uint64
+
+	stopped uint32
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+// Config is used as input to New. It defines the behavior of the storage
+// system.
+type Config struct {
+	// Tokens is the number of tokens to allow per interval across all regions.
+	// The default value is 1.
+	Tokens uint64
+
+	// Interval is the length of each window. The default value is 1 second.
+	Interval time.Duration
+
+	// Region is the unique name of this region. It is required.
+	Region string
+
+	// Transport exchanges counts with the other regions. It is required.
+	Transport Transport
+
+	// SyncInterval is how often counts are exchanged. Shorter intervals keep
+	// the limit closer to global, at the cost of more traffic between regions.
+	// The default value is 1 second.
+	SyncInterval time.Duration
+
+	// OnError, if set, is called with each error from the Transport. Takes are
+	// still decided from the last counts seen from other regions.
+	OnError func(error)
+}
+
+// Snapshot is the counts of a single region for a window.
+type Snapshot struct {
+	// Region is the region that made the takes.
+	Region string `json:"region"`
+
+	// Window is the number of intervals since the unix epoch.
+	Window int64 `json:"window"`
+
+	// Counts is the number of takes of each key in the window.
+	Counts map[string]uint64 `json:"counts"`
+}
+
+// Transport exchanges snapshots between regions.
+type Transport interface {
+	// Exchange sends the local region's snapshot and returns the latest
+	// snapshots of the other regions. Snapshots for other windows are ignored.
+	Exchange(ctx context.Context, local *Snapshot) ([]*Snapshot, error)
+}
+
+// New creates a store for the region. It exchanges counts with the other
+// regions in the background until it is closed.
+func New(c *Config) (limiter.Store, error) {
+	if c == nil {
+		c = new(Config)
+	}
+
+	tokens := uint64(1)
+	if c.Tokens > 0 {
+		tokens = c.Tokens
+	}
+
+	interval := 1 * time.Second
+	if c.Interval > 0 {
+		interval = c.Interval
+	}
+
+	syncInterval := 1 * time.Second
+	if c.SyncInterval > 0 {
+		syncInterval = c.SyncInterval
+	}
+
+	if c.Region == "" {
+		return nil, fmt.Errorf("missing region")
+	}
+	if c.Transport == nil {
+		return nil, fmt.Errorf("missing transport")
+	}
+
+	s := &store{
+		tokens:       tokens,
+		interval:     interval,
+		region:       c.Region,
+		transport:    c.Transport,
+		syncInterval: syncInterval,
+		onError:      c.OnError,
+
+		counts: make(map[string]map[string]uint64),
+
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Take attempts to remove a token from the named key. The take is allowed if
+// the known counts of all regions for the key's current window are below the
+// limit.
+func (s *store) Take(_ context.Context, key string) (limiter.Result, error) {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.Result{}, limiter.ErrStopped
+	}
+
+	now := time.Now()
+	window := now.UnixNano() / int64(s.interval)
+	resetAt := time.Unix(0, (window+1)*int64(s.interval))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.roll(window)
+
+	regions, ok := s.counts[key]
+	if !ok {
+		regions = make(map[string]uint64)
+		s.counts[key] = regions
+	}
+
+	var total uint64
+	for _, n := range regions {
+		total += n
+	}
+
+	if total >= s.tokens {
+		return limiter.Result{
+			Limit:      s.tokens,
+			ResetAt:    resetAt,
+			RetryAfter: resetAt.Sub(now),
+		}, nil
+	}
+
+	regions[s.region]++
+	return limiter.Result{
+		Limit:     s.tokens,
+		Remaining: s.tokens - total - 1,
+		ResetAt:   resetAt,
+		Allowed:   true,
+	}, nil
+}
+
+// Close stops exchanging counts and waits for any exchange in progress to
+// finish. The counts of this region since the last exchange are not sent.
+func (s *store) Close() error {
+	if !atomic.CompareAndSwapUint32(&s.stopped, 0, 1) {
+		return nil
+	}
+
+	close(s.stopCh)
+	<-s.doneCh
+	return nil
+}
+
+// run exchanges counts every sync interval until the store is stopped.
+func (s *store) run() {
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			if err := s.sync(); err != nil && s.onError != nil {
+				s.onError(err)
+			}
+		}
+	}
+}
+
+// sync sends this region's counts and merges the other regions' counts.
+func (s *store) sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.syncInterval)
+	defer cancel()
+
+	local := s.snapshot(time.Now())
+	remote, err := s.transport.Exchange(ctx, local)
+	if err != nil {
+		return fmt.Errorf("failed to exchange counts: %w", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, snap := range remote {
+		s.merge(snap)
+	}
+	return nil
+}
+
+// snapshot returns this region's counts for the current window.
+func (s *store) snapshot(now time.Time) *Snapshot {
+	window := now.UnixNano() / int64(s.interval)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.roll(window)
+
+	counts := make(map[string]uint64, len(s.counts))
+	for key, regions := range s.counts {
+		if n := regions[s.region]; n > 0 {
+			counts[key] = n
+		}
+	}
+	return &Snapshot{
+		Region: s.region,
+		Window: window,
+		Counts: counts,
+	}
+}
+
+// merge merges another region's snapshot, keeping the highest count of each
+// key. Snapshots of this region or other windows are ignored. It must be
+// called with the lock held.
+func (s *store) merge(snap *Snapshot) {
+	if snap == nil || snap.Region == s.region {
+		return
+	}
+	if snap.Window > s.window {
+		s.roll(snap.Window)
+	}
+	if snap.Window != s.window {
+		return
+	}
+
+	for key, n := range snap.Counts {
+		regions, ok := s.counts[key]
+		if !ok {
+			regions = make(map[string]uint64)
+			s.counts[key] = regions
+		}
+		if n > regions[snap.Region] {
+			regions[snap.Region] = n
+		}
+	}
+}
+
+// roll starts a new window if the given one is later than the current one,
+// dropping all counts. It must be called with the lock held.
+func (s *store) roll(window int64) {
+	if window <= s.window {
+		return
+	}
+	s.window = window
+	s.counts = make(map[string]map[string]uint64)
+}
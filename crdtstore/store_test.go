@@ -0,0 +1,144 @@
+package crdtstore
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// newStore creates a store for the region that only exchanges counts when
+// sync is called.
+func newStore(tb testing.TB, region string, t Transport) *store {
+	tb.Helper()
+
+	s, err := New(&Config{
+		Tokens:       10,
+		Interval:     time.Hour,
+		Region:       region,
+		Transport:    t,
+		SyncInterval: time.Hour,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { s.Close() })
+	return s.(*store)
+}
+
+// takeN takes n times and returns the number of allowed takes.
+func takeN(tb testing.TB, s limiter.Store, key string, n int) int {
+	tb.Helper()
+
+	var allowed int
+	for i := 0; i < n; i++ {
+		res, err := s.Take(context.Background(), key)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if res.Allowed {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestStore_Take(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		transport func(tb testing.TB) Transport
+	}{
+		{
+			name: "hub",
+			transport: func(tb testing.TB) Transport {
+				return NewHub()
+			},
+		},
+		{
+			name: "http",
+			transport: func(tb testing.TB) Transport {
+				srv := httptest.NewServer(NewHub())
+				tb.Cleanup(srv.Close)
+				return &HTTPTransport{URL: srv.URL}
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			transport := tc.transport(t)
+			a := newStore(t, "a", transport)
+			b := newStore(t, "b", transport)
+
+			if got, want := takeN(t, a, "key", 6), 6; got != want {
+				t.Errorf("a: expected %d to be %d", got, want)
+			}
+
+			for _, s := range []*store{a, b} {
+				if err := s.sync(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// b now knows about a's takes, so only the rest of the limit is left.
+			if got, want := takeN(t, b, "key", 6), 4; got != want {
+				t.Errorf("b: expected %d to be %d", got, want)
+			}
+
+			// Other keys are unaffected.
+			if got, want := takeN(t, b, "other", 1), 1; got != want {
+				t.Errorf("b: other: expected %d to be %d", got, want)
+			}
+
+			for _, s := range []*store{b, a} {
+				if err := s.sync(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got, want := takeN(t, a, "key", 1), 0; got != want {
+				t.Errorf("a: expected %d to be %d", got, want)
+			}
+		})
+	}
+}
+
+func TestStore_merge(t *testing.T) {
+	t.Parallel()
+
+	s := newStore(t, "a", NewHub())
+	s.snapshot(time.Now())
+
+	snap := &Snapshot{Region: "b", Window: s.window, Counts: map[string]uint64{"key": 3}}
+
+	// Merging is idempotent and keeps the highest count.
+	s.lock.Lock()
+	s.merge(snap)
+	s.merge(snap)
+	s.merge(&Snapshot{Region: "b", Window: s.window, Counts: map[string]uint64{"key": 2}})
+	s.merge(&Snapshot{Region: "c", Window: s.window - 1, Counts: map[string]uint64{"key": 5}})
+	s.merge(&Snapshot{Region: "a", Window: s.window, Counts: map[string]uint64{"key": 5}})
+	s.lock.Unlock()
+
+	if got, want := takeN(t, s, "key", 10), 7; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+func TestNew_validates(t *testing.T) {
+	t.Parallel()
+
+	if _, err := New(&Config{Transport: NewHub()}); err == nil {
+		t.Errorf("expected an error without a region")
+	}
+	if _, err := New(&Config{Region: "a"}); err == nil {
+		t.Errorf("expected an error without a transport")
+	}
+}
@@ -0,0 +1,12 @@
+package limiter
+
+// Debugger is implemented by stores that report internal counters, like the
+// number of takes and denials, for quick inspection in production. It is an
+// optional interface; use a type assertion to check whether a store supports
+// it.
+type Debugger interface {
+	// DebugVars returns a snapshot of the store's counters by name. The values
+	// must be encodable as JSON. The names and values are for humans and may
+	// change between releases.
+	DebugVars() map[string]interface{}
+}
@@ -0,0 +1,55 @@
+// Package debuglimit publishes the internal counters of limiter stores, like
+// the number of takes, denials, and failures, for quick inspection in
+// production without a full metrics stack.
+//
+// Stores report their counters by implementing limiter.Debugger, which all of
+// the built-in stores do.
+package debuglimit
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// Publish publishes the store's counters as the expvar variable with the given
+// name, so they are served on /debug/vars alongside the runtime's. The
+// counters are read each time the variable is. It returns
+// limiter.ErrNotSupported if the store does not implement limiter.Debugger.
+// Like expvar.Publish, it panics if the name is already in use.
+func Publish(name string, s limiter.Store) error {
+	d, ok := s.(limiter.Debugger)
+	if !ok {
+		return limiter.ErrNotSupported
+	}
+
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return d.DebugVars()
+	}))
+	return nil
+}
+
+// Handler returns a handler that serves the counters of the named stores as a
+// JSON object, which is typically mounted at /debug/limiter. Stores that do
+// not implement limiter.Debugger are reported as null.
+func Handler(stores map[string]limiter.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := make(map[string]map[string]interface{}, len(stores))
+		for name, s := range stores {
+			var v map[string]interface{}
+			if d, ok := s.(limiter.Debugger); ok {
+				v = d.DebugVars()
+			}
+			vars[name] = v
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
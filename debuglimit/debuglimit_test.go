@@ -0,0 +1,83 @@
+package debuglimit_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/debuglimit"
+	"github.com/sethvargo/go-limiter/limittest"
+)
+
+// opaqueStore hides the optional interfaces of the store it wraps.
+type opaqueStore struct {
+	limiter.Store
+}
+
+func TestPublish(t *testing.T) {
+	t.Parallel()
+
+	s := limittest.NewStore(1, time.Minute)
+	for i := 0; i < 3; i++ {
+		if _, err := s.Take(context.Background(), "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := debuglimit.Publish("debuglimit_test", s); err != nil {
+		t.Fatal(err)
+	}
+
+	var vars map[string]interface{}
+	if err := json.Unmarshal([]byte(expvar.Get("debuglimit_test").String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := vars["takes"], float64(3); got != want {
+		t.Errorf("takes: expected %v to be %v", got, want)
+	}
+	if got, want := vars["denials"], float64(2); got != want {
+		t.Errorf("denials: expected %v to be %v", got, want)
+	}
+
+	if err := debuglimit.Publish("debuglimit_test_opaque", &opaqueStore{s}); !errors.Is(err, limiter.ErrNotSupported) {
+		t.Errorf("expected %v to be %v", err, limiter.ErrNotSupported)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	s := limittest.NewStore(1, time.Minute)
+	for i := 0; i < 3; i++ {
+		if _, err := s.Take(context.Background(), "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := debuglimit.Handler(map[string]limiter.Store{
+		"api":    s,
+		"opaque": &opaqueStore{s},
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/limiter", nil))
+
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("expected %d to be %d", got, want)
+	}
+
+	var vars map[string]map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := vars["api"]["takes"], float64(3); got != want {
+		t.Errorf("takes: expected %v to be %v", got, want)
+	}
+	if v, ok := vars["opaque"]; !ok || v != nil {
+		t.Errorf("expected opaque store to be null, got %v", v)
+	}
+}
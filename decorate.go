@@ -0,0 +1,106 @@
+package limiter
+
+import "context"
+
+//go:generate go run gen_preserve.go
+
+// Decorator is a store that wraps another store and implements every optional
+// capability, usually by forwarding to the store it wraps. Pass it to Preserve
+// before returning it, so callers can still detect which capabilities the
+// wrapped store supports.
+type Decorator interface {
+	Store
+	Refunder
+	Inspector
+	Debugger
+	Peeker
+	Charger
+	Annotator
+	Deleter
+}
+
+// Decorated is embedded by decorators to forward Take, Close, and the optional
+// capabilities to the store they wrap. Decorators override the methods they
+// change. The capability methods return ErrNotSupported, or no debug vars, if
+// the store does not implement them.
+type Decorated struct {
+	Store
+}
+
+// Refund forwards to the store if it implements Refunder.
+func (d Decorated) Refund(ctx context.Context, key string, tokens uint64) error {
+	r, ok := d.Store.(Refunder)
+	if !ok {
+		return ErrNotSupported
+	}
+	return r.Refund(ctx, key, tokens)
+}
+
+// Keys forwards to the store if it implements Inspector.
+func (d Decorated) Keys(ctx context.Context, pattern string, cursor uint64) ([]string, uint64, error) {
+	in, ok := d.Store.(Inspector)
+	if !ok {
+		return nil, 0, ErrNotSupported
+	}
+	return in.Keys(ctx, pattern, cursor)
+}
+
+// Stats forwards to the store if it implements Inspector.
+func (d Decorated) Stats(ctx context.Context, n int) (Stats, error) {
+	in, ok := d.Store.(Inspector)
+	if !ok {
+		return Stats{}, ErrNotSupported
+	}
+	return in.Stats(ctx, n)
+}
+
+// DebugVars forwards to the store if it implements Debugger.
+func (d Decorated) DebugVars() map[string]interface{} {
+	dbg, ok := d.Store.(Debugger)
+	if !ok {
+		return nil
+	}
+	return dbg.DebugVars()
+}
+
+// Charge forwards to the store if it implements Charger.
+func (d Decorated) Charge(ctx context.Context, key string, tokens uint64) error {
+	c, ok := d.Store.(Charger)
+	if !ok {
+		return ErrNotSupported
+	}
+	return c.Charge(ctx, key, tokens)
+}
+
+// Peek forwards to the store if it implements Peeker.
+func (d Decorated) Peek(ctx context.Context, key string) (Result, error) {
+	p, ok := d.Store.(Peeker)
+	if !ok {
+		return Result{}, ErrNotSupported
+	}
+	return p.Peek(ctx, key)
+}
+
+// SetMetadata forwards to the store if it implements Annotator.
+func (d Decorated) SetMetadata(ctx context.Context, key, metadata string) error {
+	a, ok := d.Store.(Annotator)
+	if !ok {
+		return ErrNotSupported
+	}
+	return a.SetMetadata(ctx, key, metadata)
+}
+
+// Delete forwards to the store if it implements Deleter.
+func (d Decorated) Delete(ctx context.Context, key string) error {
+	dl, ok := d.Store.(Deleter)
+	if !ok {
+		return ErrNotSupported
+	}
+	return dl.Delete(ctx, key)
+}
+
+// Preserve returns d with only the optional capabilities that s implements, so
+// a type assertion on the result succeeds exactly when it would on s.
+func Preserve(s Store, d Decorator) Store {
+	return preserved(capabilities(s), d)
+}
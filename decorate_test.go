@@ -0,0 +1,61 @@
+package limiter_test
+
+import (
+	"testing"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestPreserve(t *testing.T) {
+	t.Parallel()
+
+	ms, err := memorystore.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ms.Close()
+
+	cases := []struct {
+		name  string
+		store limiter.Store
+		want  bool
+	}{
+		{
+			name:  "all",
+			store: ms,
+			want:  true,
+		},
+		{
+			name:  "none",
+			store: takeOnlyStore{ms},
+			want:  false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := limiter.Preserve(tc.store, limiter.Decorated{Store: tc.store})
+
+			if _, got := s.(limiter.Refunder); got != tc.want {
+				t.Errorf("refunder: expected %t to be %t", got, tc.want)
+			}
+			if _, got := s.(limiter.Inspector); got != tc.want {
+				t.Errorf("inspector: expected %t to be %t", got, tc.want)
+			}
+			if _, got := s.(limiter.Debugger); got != tc.want {
+				t.Errorf("debugger: expected %t to be %t", got, tc.want)
+			}
+			if _, got := s.(limiter.Peeker); got != tc.want {
+				t.Errorf("peeker: expected %t to be %t", got, tc.want)
+			}
+			if _, got := s.(limiter.Charger); got != tc.want {
+				t.Errorf("charger: expected %t to be %t", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+//go:build ignore
+// +build ignore
+
+// gen_preserve generates preserve.go, which has a store type for each subset
+// of the optional capabilities, for Preserve. Add a capability here and to
+// Decorator, then run go generate.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// capabilities are the optional interfaces of a Decorator, in the order of
+// their bits.
+var capabilities = []string{
+	"Refunder",
+	"Inspector",
+	"Debugger",
+	"Peeker",
+	"Charger",
+	"Annotator",
+	"Deleter",
+}
+
+func main() {
+	var b bytes.Buffer
+	b.WriteString("// Code generated by \"go run gen_preserve.go\"; DO NOT EDIT.\n\n")
+	b.WriteString("package limiter\n\n")
+
+	b.WriteString("// The bits of the optional capabilities of a store, for preserved.\n")
+	b.WriteString("const (\n")
+	for i, c := range capabilities {
+		if i == 0 {
+			fmt.Fprintf(&b, "\tcap%s uint = 1 << iota\n", c)
+		} else {
+			fmt.Fprintf(&b, "\tcap%s\n", c)
+		}
+	}
+	fmt.Fprintf(&b, "\n\t// numCapabilities is the number of optional capabilities.\n")
+	fmt.Fprintf(&b, "\tnumCapabilities = %d\n", len(capabilities))
+	b.WriteString(")\n\n")
+
+	b.WriteString("// capabilities returns the bits of the optional capabilities s implements.\n")
+	b.WriteString("func capabilities(s Store) uint {\n")
+	b.WriteString("\tvar caps uint\n")
+	for _, c := range capabilities {
+		fmt.Fprintf(&b, "\tif _, ok := s.(%s); ok {\n\t\tcaps |= cap%s\n\t}\n", c, c)
+	}
+	b.WriteString("\treturn caps\n}\n\n")
+
+	b.WriteString("// preserved returns d as a store that implements only the optional\n")
+	b.WriteString("// capabilities in caps.\n")
+	b.WriteString("func preserved(caps uint, d Decorator) Store {\n")
+	b.WriteString("\tswitch caps {\n")
+	for caps := 0; caps < 1<<len(capabilities); caps++ {
+		fields := []string{"Store"}
+		for i, c := range capabilities {
+			if caps&(1<<i) != 0 {
+				fields = append(fields, c)
+			}
+		}
+		values := strings.TrimSuffix(strings.Repeat("d, ", len(fields)), ", ")
+
+		fmt.Fprintf(&b, "\tcase %s:\n", caseLabel(caps))
+		fmt.Fprintf(&b, "\t\treturn struct {\n\t\t\t%s\n\t\t}{%s}\n", strings.Join(fields, "\n\t\t\t"), values)
+	}
+	b.WriteString("\tdefault:\n")
+	b.WriteString("\t\tpanic(\"limiter: unknown capabilities\")\n")
+	b.WriteString("\t}\n}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatalf("failed to format: %s\n%s", err, b.Bytes())
+	}
+	if err := ioutil.WriteFile("preserve.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// caseLabel returns the constant expression for the bits in caps.
+func caseLabel(caps int) string {
+	var names []string
+	for i, c := range capabilities {
+		if caps&(1<<i) != 0 {
+			names = append(names, "cap"+c)
+		}
+	}
+	if len(names) == 0 {
+		return "0"
+	}
+	return strings.Join(names, " | ")
+}
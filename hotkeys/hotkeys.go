@@ -0,0 +1,362 @@
+// Package hotkeys tracks the most active and most limited keys of a
+// limiter.Store over a rolling window.
+//
+// Counts are approximate. Each window keeps a fixed number of counters using
+// the space-saving algorithm, so memory stays bounded no matter how many
+// distinct keys are seen, and each take costs constant time. The counters are
+// split into shards by key, each with its own lock, so takes of different keys
+// rarely contend. A key taken more often than 1/Capacity of all takes in the
+// window is tracked as long as keys spread evenly across the shards, which
+// they do unless there are only a few of them. Tracking happens in process, so
+// each instance of an application reports its own traffic.
+package hotkeys
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+var _ limiter.Store = (*Store)(nil)
+
+// maxShards is the most shards the counters are split into.
+const maxShards = 16
+
+// Store wraps a limiter.Store and records every take. Its other capabilities,
+// like limiter.Refunder, are forwarded to the underlying store.
+type Store struct {
+	limiter.Decorated
+
+	shards []*shard
+}
+
+// shard holds the windows of the keys that hash to it.
+type shard struct {
+	window time.Duration
+
+	// lock guards the sketches and the window end.
+	lock      sync.Mutex
+	windowEnd time.Time
+	active    *window
+	limited   *window
+}
+
+// window holds the sketches of the current and previous windows.
+type window struct {
+	curr, prev *sketch
+}
+
+// Config is used as input to New.
+type Config struct {
+	// Capacity is the number of keys tracked per window. Larger values track
+	// more keys more accurately, at the cost of memory. The default value is
+	// 100.
+	Capacity int
+
+	// Window is the length of the rolling window. Reports cover the previous
+	// complete window and the current one, so they span between one and two
+	// windows. The default value is 1 minute.
+	Window time.Duration
+}
+
+// KeyCount is the approximate number of takes for a key.
+type KeyCount struct {
+	// Key is the key.
+	Key string
+
+	// Count is the estimated number of takes. It never underestimates the
+	// true count.
+	Count uint64
+
+	// Error is the most Count may overestimate the true count by.
+	Error uint64
+}
+
+// Report is a snapshot of the hottest keys.
+type Report struct {
+	// Active are the keys with the most takes.
+	Active []KeyCount
+
+	// Limited are the keys with the most rejected takes.
+	Limited []KeyCount
+}
+
+// New wraps the store to track its hottest keys.
+func New(s limiter.Store, c *Config) (*Store, error) {
+	if s == nil {
+		return nil, fmt.Errorf("missing store")
+	}
+	if c == nil {
+		c = new(Config)
+	}
+
+	capacity := 100
+	if c.Capacity > 0 {
+		capacity = c.Capacity
+	}
+
+	w := 1 * time.Minute
+	if c.Window > 0 {
+		w = c.Window
+	}
+
+	// Each shard gets an equal part of the capacity, and at least one
+	// counter.
+	n := maxShards
+	if capacity < n {
+		n = capacity
+	}
+	perShard := (capacity + n - 1) / n
+
+	windowEnd := time.Now().Add(w)
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = &shard{
+			window:    w,
+			windowEnd: windowEnd,
+			active:    &window{curr: newSketch(perShard), prev: newSketch(perShard)},
+			limited:   &window{curr: newSketch(perShard), prev: newSketch(perShard)},
+		}
+	}
+
+	return &Store{
+		Decorated: limiter.Decorated{Store: s},
+		shards:    shards,
+	}, nil
+}
+
+// Take takes from the underlying store and records the result. Takes that
+// return an error are counted as active, but not as limited.
+func (s *Store) Take(ctx context.Context, key string) (limiter.Result, error) {
+	res, err := s.Store.Take(ctx, key)
+
+	sh := s.shards[shardIndex(key, len(s.shards))]
+	sh.lock.Lock()
+	sh.rotate(time.Now())
+	sh.active.curr.add(key)
+	if err == nil && !res.Allowed {
+		sh.limited.curr.add(key)
+	}
+	sh.lock.Unlock()
+
+	return res, err
+}
+
+// Top returns up to n of the hottest keys in each category, hottest first, or
+// none if n is zero or negative.
+func (s *Store) Top(n int) Report {
+	var active, limited []KeyCount
+
+	now := time.Now()
+	for _, sh := range s.shards {
+		sh.lock.Lock()
+		sh.rotate(now)
+		active = sh.active.appendCounts(active)
+		limited = sh.limited.appendCounts(limited)
+		sh.lock.Unlock()
+	}
+
+	return Report{
+		Active:  top(active, n),
+		Limited: top(limited, n),
+	}
+}
+
+// rotate starts a new window if the current one has ended. If more than a
+// whole window has passed, the previous window is empty too. It must be called
+// with the lock held.
+func (s *shard) rotate(now time.Time) {
+	if now.Before(s.windowEnd) {
+		return
+	}
+
+	skipped := now.Sub(s.windowEnd) >= s.window
+	for _, w := range []*window{s.active, s.limited} {
+		w.prev, w.curr = w.curr, w.prev
+		w.curr.reset()
+		if skipped {
+			w.prev.reset()
+		}
+	}
+
+	s.windowEnd = now.Add(s.window - now.Sub(s.windowEnd)%s.window)
+}
+
+// shardIndex returns the shard of the key, by its 32-bit FNV-1a hash.
+func shardIndex(key string, n int) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % uint32(n))
+}
+
+// appendCounts merges the counts of the current and previous windows and
+// appends them to counts. Keys of a shard are in no other shard, so the counts
+// of shards can be appended without merging them.
+func (w *window) appendCounts(counts []KeyCount) []KeyCount {
+	merged := make(map[string]KeyCount, len(w.curr.counters)+len(w.prev.counters))
+	for _, sk := range []*sketch{w.prev, w.curr} {
+		for key, c := range sk.counters {
+			m := merged[key]
+			m.Key = key
+			m.Count += c.count()
+			m.Error += c.err
+			merged[key] = m
+		}
+	}
+
+	for _, c := range merged {
+		counts = append(counts, c)
+	}
+	return counts
+}
+
+// top sorts the counts and returns the n highest.
+func top(counts []KeyCount, n int) []KeyCount {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Key < counts[j].Key
+	})
+
+	if n < 0 {
+		n = 0
+	}
+	if n < len(counts) {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// sketch is a space-saving sketch with a fixed number of counters. The
+// counters are kept in a stream summary: a list of buckets in increasing order
+// of count, each with the list of counters that have its count, so counting a
+// key and finding the lowest count both take constant time.
+type sketch struct {
+	capacity int
+	counters map[string]*counter
+
+	// min is the bucket with the lowest count, or nil if there are no
+	// counters.
+	min *bucket
+}
+
+// bucket holds the counters with the same count.
+type bucket struct {
+	count      uint64
+	head       *counter
+	prev, next *bucket
+}
+
+// counter is the count of a key, which is the count of its bucket.
+type counter struct {
+	key        string
+	err        uint64
+	bucket     *bucket
+	prev, next *counter
+}
+
+// count returns the count of the counter's key.
+func (c *counter) count() uint64 {
+	return c.bucket.count
+}
+
+func newSketch(capacity int) *sketch {
+	return &sketch{
+		capacity: capacity,
+		counters: make(map[string]*counter, capacity),
+	}
+}
+
+// add counts an occurrence of key. If the key is not tracked and the sketch is
+// full, it replaces a key with the lowest count and inherits its count as the
+// error.
+func (s *sketch) add(key string) {
+	if c, ok := s.counters[key]; ok {
+		s.increment(c)
+		return
+	}
+
+	if len(s.counters) < s.capacity {
+		c := &counter{key: key}
+		if s.min == nil || s.min.count != 1 {
+			s.min = &bucket{count: 1, next: s.min}
+			if s.min.next != nil {
+				s.min.next.prev = s.min
+			}
+		}
+		s.min.push(c)
+		s.counters[key] = c
+		return
+	}
+
+	c := s.min.head
+	delete(s.counters, c.key)
+	c.key, c.err = key, c.count()
+	s.counters[key] = c
+	s.increment(c)
+}
+
+// increment moves the counter to the bucket of the next count, creating it if
+// needed and removing its old bucket if it is left empty.
+func (s *sketch) increment(c *counter) {
+	b := c.bucket
+	next := b.next
+	if next == nil || next.count != b.count+1 {
+		next = &bucket{count: b.count + 1, prev: b, next: b.next}
+		if b.next != nil {
+			b.next.prev = next
+		}
+		b.next = next
+	}
+
+	b.remove(c)
+	next.push(c)
+
+	if b.head == nil {
+		if b.prev != nil {
+			b.prev.next = b.next
+		} else {
+			s.min = b.next
+		}
+		b.next.prev = b.prev
+	}
+}
+
+// push adds the counter to the bucket.
+func (b *bucket) push(c *counter) {
+	c.bucket = b
+	c.prev, c.next = nil, b.head
+	if b.head != nil {
+		b.head.prev = c
+	}
+	b.head = c
+}
+
+// remove removes the counter from the bucket.
+func (b *bucket) remove(c *counter) {
+	if c.prev != nil {
+		c.prev.next = c.next
+	} else {
+		b.head = c.next
+	}
+	if c.next != nil {
+		c.next.prev = c.prev
+	}
+	c.prev, c.next, c.bucket = nil, nil, nil
+}
+
+// reset removes all counters.
+func (s *sketch) reset() {
+	for k := range s.counters {
+		delete(s.counters, k)
+	}
+	s.min = nil
+}
@@ -0,0 +1,203 @@
+package hotkeys
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/limittest"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestStore_Top(t *testing.T) {
+	t.Parallel()
+
+	ms, err := memorystore.New(&memorystore.Config{
+		Tokens:   2,
+		Interval: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(ms, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	for key, n := range map[string]int{"a": 5, "b": 3, "c": 1} {
+		for i := 0; i < n; i++ {
+			if _, err := s.Take(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	r := s.Top(2)
+	if got, want := fmt.Sprint(r.Active), "[{a 5 0} {b 3 0}]"; got != want {
+		t.Errorf("active: expected %s to be %s", got, want)
+	}
+	if got, want := fmt.Sprint(r.Limited), "[{a 3 0} {b 1 0}]"; got != want {
+		t.Errorf("limited: expected %s to be %s", got, want)
+	}
+
+	// A negative n asks for no keys, like zero.
+	r = s.Top(-1)
+	if got, want := len(r.Active)+len(r.Limited), 0; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+func TestStore_Window(t *testing.T) {
+	t.Parallel()
+
+	ms, err := memorystore.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(ms, &Config{Window: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.Take(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key is reported for the rest of its window and the next one.
+	time.Sleep(120 * time.Millisecond)
+	if got, want := len(s.Top(10).Active), 1; got != want {
+		t.Errorf("after one window: expected %d to be %d", got, want)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if got, want := len(s.Top(10).Active), 0; got != want {
+		t.Errorf("after two windows: expected %d to be %d", got, want)
+	}
+}
+
+func TestSketch_add(t *testing.T) {
+	t.Parallel()
+
+	sk := newSketch(2)
+	for _, key := range []string{"a", "a", "a", "b", "c", "a", "d"} {
+		sk.add(key)
+	}
+
+	// "a" is frequent enough that it is never evicted, and its count is exact.
+	a := sk.counters["a"]
+	if got, want := [2]uint64{a.count(), a.err}, [2]uint64{4, 0}; got != want {
+		t.Errorf("expected %v to be %v", got, want)
+	}
+
+	// "d" replaced "c", which replaced "b", inheriting their counts.
+	d := sk.counters["d"]
+	if got, want := [2]uint64{d.count(), d.err}, [2]uint64{3, 2}; got != want {
+		t.Errorf("expected %v to be %v", got, want)
+	}
+	if got, want := len(sk.counters), 2; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+func TestSketch_summary(t *testing.T) {
+	t.Parallel()
+
+	// The buckets stay in increasing order of count, each holding exactly the
+	// counters with its count, through adds, evictions, and resets.
+	check := func(tb testing.TB, sk *sketch) {
+		tb.Helper()
+
+		var n int
+		var prev *bucket
+		for b := sk.min; b != nil; b = b.next {
+			if b.prev != prev {
+				tb.Fatalf("bucket %d: expected prev %p to be %p", b.count, b.prev, prev)
+			}
+			if prev != nil && b.count <= prev.count {
+				tb.Fatalf("expected %d to be more than %d", b.count, prev.count)
+			}
+			if b.head == nil {
+				tb.Fatalf("bucket %d: expected counters", b.count)
+			}
+			for c := b.head; c != nil; c = c.next {
+				if c.bucket != b || sk.counters[c.key] != c {
+					tb.Fatalf("bucket %d: counter %q is not tracked", b.count, c.key)
+				}
+				n++
+			}
+			prev = b
+		}
+		if got, want := n, len(sk.counters); got != want {
+			tb.Fatalf("expected %d counters to be %d", got, want)
+		}
+	}
+
+	sk := newSketch(8)
+	for i := 0; i < 2000; i++ {
+		sk.add(strconv.Itoa(i * i % 23))
+		check(t, sk)
+
+		if i == 1000 {
+			sk.reset()
+			check(t, sk)
+		}
+	}
+
+	// The counts never underestimate, and the error bounds the overestimate.
+	truth := make(map[string]uint64)
+	for i := 1001; i < 2000; i++ {
+		truth[strconv.Itoa(i*i%23)]++
+	}
+	for key, c := range sk.counters {
+		if c.count() < truth[key] || c.count()-c.err > truth[key] {
+			t.Errorf("%q: expected %d-%d to bound %d", key, c.count(), c.err, truth[key])
+		}
+	}
+}
+
+func TestStore_shards(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(limittest.NewStore(1, time.Minute), &Config{Capacity: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Keys are counted in their own shard, and reports merge the shards.
+	ctx := context.Background()
+	for i := 0; i < 32; i++ {
+		key := "key" + strconv.Itoa(i)
+		for j := 0; j <= i; j++ {
+			if _, err := s.Take(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	r := s.Top(3)
+	if got, want := fmt.Sprint(r.Active), "[{key31 32 0} {key30 31 0} {key29 30 0}]"; got != want {
+		t.Errorf("active: expected %s to be %s", got, want)
+	}
+	if got, want := fmt.Sprint(r.Limited), "[{key31 31 0} {key30 30 0} {key29 29 0}]"; got != want {
+		t.Errorf("limited: expected %s to be %s", got, want)
+	}
+	if got, want := len(s.Top(100).Active), 32; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	// Other capabilities are forwarded to the store.
+	if err := s.Refund(ctx, "key0", 1); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := s.Take(ctx, "key0"); !res.Allowed || err != nil {
+		t.Errorf("expected take after refund to succeed, got %t, %v", res.Allowed, err)
+	}
+}
@@ -0,0 +1,151 @@
+package httplimit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaderBypass is the header requests carry a bypass token in.
+const HeaderBypass = "X-RateLimit-Bypass"
+
+var (
+	// ErrInvalidBypassToken is returned for bypass tokens that are malformed or
+	// not signed by any of the keys.
+	ErrInvalidBypassToken = errors.New("invalid bypass token")
+
+	// ErrExpiredBypassToken is returned for bypass tokens past their expiry.
+	ErrExpiredBypassToken = errors.New("bypass token is expired")
+)
+
+// Bypass issues and verifies signed, expiring tokens that exempt requests from
+// rate limiting, like those of health checks or internal crawlers, without
+// maintaining an allowlist of their addresses.
+//
+// A token is the subject it was issued to, its expiry, and an HMAC-SHA256 of
+// both. Anyone with a key can issue tokens, so keep the keys secret, and keep
+// the expiry short for tokens that leave the organization.
+type Bypass struct {
+	keys [][]byte
+
+	// purpose, if set, is signed into the tokens after the expiry, so tokens
+	// issued for another purpose with the same keys, like challenge tokens,
+	// are not accepted. Bypass tokens have none.
+	purpose string
+}
+
+// NewBypass creates a Bypass that signs tokens with the first key and accepts
+// tokens signed with any of them, so keys can be rotated by adding the new key
+// first and removing the old one once its tokens expire. Keys should be at
+// least 32 random bytes.
+func NewBypass(keys ...[]byte) (*Bypass, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one key is required")
+	}
+	for i, key := range keys {
+		if len(key) == 0 {
+			return nil, fmt.Errorf("key %d is empty", i)
+		}
+	}
+	return &Bypass{keys: keys}, nil
+}
+
+// Token issues a token for the subject that expires after ttl.
+func (b *Bypass) Token(subject string, ttl time.Duration) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(subject)) + "." +
+		strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	if b.purpose != "" {
+		payload += "." + b.purpose
+	}
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign(b.keys[0], payload))
+}
+
+// Verify returns the subject of the token if it is signed with one of the keys
+// and has not expired. Challenge tokens signed with the same keys are invalid.
+func (b *Bypass) Verify(token string) (string, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return "", ErrInvalidBypassToken
+	}
+	payload := token[:i]
+	mac, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil {
+		return "", ErrInvalidBypassToken
+	}
+
+	var valid bool
+	for _, key := range b.keys {
+		if hmac.Equal(mac, sign(key, payload)) {
+			valid = true
+		}
+	}
+	if !valid {
+		return "", ErrInvalidBypassToken
+	}
+
+	// Tokens of another purpose are signed with the same keys, so the purpose
+	// must match too.
+	parts := strings.Split(payload, ".")
+	switch {
+	case b.purpose == "" && len(parts) != 2:
+		return "", ErrInvalidBypassToken
+	case b.purpose != "" && (len(parts) != 3 || parts[2] != b.purpose):
+		return "", ErrInvalidBypassToken
+	}
+	subject, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidBypassToken
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", ErrInvalidBypassToken
+	}
+	if time.Now().Unix() >= expiry {
+		return "", ErrExpiredBypassToken
+	}
+	return string(subject), nil
+}
+
+// Handle returns a handler that serves requests with a valid token in the
+// HeaderBypass header with next, and all others with limited, which is usually
+// next wrapped by a rate limiting middleware:
+//
+//	mux.Handle("/", bypass.Handle(middleware.Handle(app), app))
+//
+// The subject of the token is available to next with BypassFromContext.
+// Requests with an invalid or expired token are limited like any other.
+func (b *Bypass) Handle(limited, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.Header.Get(HeaderBypass); token != "" {
+			if subject, err := b.Verify(token); err == nil {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bypassKey{}, subject)))
+				return
+			}
+		}
+		limited.ServeHTTP(w, r)
+	})
+}
+
+// bypassKey is the context key for the subject of a bypass token.
+type bypassKey struct{}
+
+// BypassFromContext returns the subject of the bypass token that exempted the
+// request whose context is ctx, if one did.
+func BypassFromContext(ctx context.Context) (string, bool) {
+	subject, ok := ctx.Value(bypassKey{}).(string)
+	return subject, ok
+}
+
+// sign returns the HMAC-SHA256 of the payload.
+func sign(key []byte, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
@@ -0,0 +1,147 @@
+package httplimit_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestBypass_Verify(t *testing.T) {
+	t.Parallel()
+
+	oldKey, newKey := []byte("old-secret-key"), []byte("new-secret-key")
+
+	issuer, err := httplimit.NewBypass(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := httplimit.NewBypass(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := httplimit.NewBypass([]byte("other-secret-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := issuer.Token("health.checker", time.Minute)
+
+	cases := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{
+			name:  "valid",
+			token: valid,
+		},
+		{
+			name:  "expired",
+			token: issuer.Token("health.checker", -time.Second),
+			err:   httplimit.ErrExpiredBypassToken,
+		},
+		{
+			name:  "other_key",
+			token: other.Token("health.checker", time.Minute),
+			err:   httplimit.ErrInvalidBypassToken,
+		},
+		{
+			name:  "tampered",
+			token: "Y3Jhd2xlcg" + valid[len("aGVhbHRoLmNoZWNrZXI"):],
+			err:   httplimit.ErrInvalidBypassToken,
+		},
+		{
+			name:  "malformed",
+			token: "nope",
+			err:   httplimit.ErrInvalidBypassToken,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Tokens of the old key are still accepted after rotation.
+			subject, err := rotated.Verify(tc.token)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected %v to be %v", err, tc.err)
+			}
+			if tc.err != nil {
+				return
+			}
+			if got, want := subject, "health.checker"; got != want {
+				t.Errorf("expected %q to be %q", got, want)
+			}
+		})
+	}
+
+	if _, err := httplimit.NewBypass(); err == nil {
+		t.Error("expected error for no keys")
+	}
+}
+
+func TestBypass_Handle(t *testing.T) {
+	t.Parallel()
+
+	store, err := memorystore.New(&memorystore.Config{
+		Tokens:   1,
+		Interval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	middleware, err := httplimit.NewMiddleware(store, func(r *http.Request) (string, error) {
+		return "key", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bypass, err := httplimit.NewBypass([]byte("secret-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var subject string
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject, _ = httplimit.BypassFromContext(r.Context())
+	})
+	h := bypass.Handle(middleware.Handle(app), app)
+
+	serve := func(token string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			r.Header.Set(httplimit.HeaderBypass, token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// The only token is taken, so further requests are limited.
+	if got, want := serve(""), http.StatusOK; got != want {
+		t.Fatalf("expected %d to be %d", got, want)
+	}
+	if got, want := serve(""), http.StatusTooManyRequests; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+	if got, want := serve("invalid"), http.StatusTooManyRequests; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	if got, want := serve(bypass.Token("crawler", time.Minute)), http.StatusOK; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+	if got, want := subject, "crawler"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
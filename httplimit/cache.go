@@ -0,0 +1,104 @@
+package httplimit
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sethvargo/go-limiter/internal/httpwriter"
+)
+
+const (
+	// HeaderCacheControl and HeaderSurrogateControl are set on rejected
+	// requests by CacheRejections. Surrogate-Control is read by CDNs, which
+	// remove it before the response reaches the client.
+	HeaderCacheControl     = "Cache-Control"
+	HeaderSurrogateControl = "Surrogate-Control"
+)
+
+// CacheConfig is used as input to CacheRejections.
+type CacheConfig struct {
+	// Vary lists the request headers that identify the client, like
+	// "Authorization" or the header of the client's IP address the CDN sets.
+	// They are added to the Vary header of rejections, so a CDN only serves a
+	// cached rejection to the client it was for. It is required, since a
+	// rejection cached for every client of a URL would throttle all of them.
+	Vary []string
+
+	// MaxAge is the longest a rejection may be cached, however far away the
+	// reset is. The default value is 1 minute.
+	MaxAge time.Duration
+}
+
+// CacheRejections wraps a rate limiting handler, like the one returned by
+// Middleware.Handle, so its 429 responses tell caches to keep them until the
+// client may retry, as given by their Retry-After header. A CDN in front of the
+// handler then answers the retries of a throttled client at the edge, instead
+// of forwarding each one to the limiter. Other responses are not changed.
+func CacheRejections(next http.Handler, c *CacheConfig) (http.Handler, error) {
+	if next == nil {
+		return nil, fmt.Errorf("handler cannot be nil")
+	}
+	if c == nil || len(c.Vary) == 0 {
+		return nil, fmt.Errorf("vary headers are required")
+	}
+
+	maxAge := time.Minute
+	if c.MaxAge > 0 {
+		maxAge = c.MaxAge
+	}
+	vary := strings.Join(c.Vary, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&cacheWriter{Writer: httpwriter.Writer{ResponseWriter: w}, maxAge: maxAge, vary: vary}, r)
+	}), nil
+}
+
+// cacheWriter adds the cache headers to a rejection before it is written.
+type cacheWriter struct {
+	httpwriter.Writer
+	maxAge time.Duration
+	vary   string
+
+	wroteHeader bool
+}
+
+func (w *cacheWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if status == http.StatusTooManyRequests {
+			w.addHints()
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// addHints sets the cache headers from the Retry-After header, if it is set.
+func (w *cacheWriter) addHints() {
+	h := w.Header()
+	d, ok := parseDelay(h.Get(HeaderRetryAfter), time.Now())
+	if !ok {
+		return
+	}
+
+	// Retry-After is rounded up to whole seconds, so the rejection is cached
+	// for a second less, and never outlives the reset.
+	d -= time.Second
+	if d > w.maxAge {
+		d = w.maxAge
+	}
+	if d < time.Second {
+		return
+	}
+	maxAge := "max-age=" + strconv.FormatInt(int64(d/time.Second), 10)
+	h.Set(HeaderCacheControl, maxAge)
+	h.Set(HeaderSurrogateControl, maxAge)
+	h.Add("Vary", w.vary)
+}
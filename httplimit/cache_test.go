@@ -0,0 +1,155 @@
+package httplimit_test
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestCacheRejections(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		interval time.Duration
+		maxAge   time.Duration
+		exp      string
+	}{
+		{
+			name:     "reset",
+			interval: 30 * time.Second,
+			exp:      "max-age=29",
+		},
+		{
+			name:     "max_age",
+			interval: time.Hour,
+			maxAge:   10 * time.Second,
+			exp:      "max-age=10",
+		},
+		{
+			name:     "imminent_reset",
+			interval: time.Second,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			store, err := memorystore.New(&memorystore.Config{
+				Tokens:   1,
+				Interval: tc.interval,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			middleware, err := httplimit.NewMiddleware(store, func(r *http.Request) (string, error) {
+				return r.Header.Get("X-API-Key"), nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			h, err := httplimit.CacheRejections(middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})),
+				&httplimit.CacheConfig{Vary: []string{"X-API-Key"}, MaxAge: tc.maxAge})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			serve := func() *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.Header.Set("X-API-Key", "key")
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				return w
+			}
+
+			// Allowed responses are not cached.
+			w := serve()
+			if got, want := w.Code, http.StatusOK; got != want {
+				t.Fatalf("expected %d to be %d", got, want)
+			}
+			if got := w.Header().Get(httplimit.HeaderCacheControl); got != "" {
+				t.Errorf("expected no cache control, got %q", got)
+			}
+
+			w = serve()
+			if got, want := w.Code, http.StatusTooManyRequests; got != want {
+				t.Fatalf("expected %d to be %d", got, want)
+			}
+			if got, want := w.Header().Get(httplimit.HeaderCacheControl), tc.exp; got != want {
+				t.Errorf("expected %q to be %q", got, want)
+			}
+			if got, want := w.Header().Get(httplimit.HeaderSurrogateControl), tc.exp; got != want {
+				t.Errorf("expected %q to be %q", got, want)
+			}
+			if tc.exp == "" {
+				return
+			}
+			if got, want := w.Header().Get("Vary"), "X-API-Key"; got != want {
+				t.Errorf("expected %q to be %q", got, want)
+			}
+		})
+	}
+
+	if _, err := httplimit.CacheRejections(http.NotFoundHandler(), nil); err == nil {
+		t.Error("expected error for missing vary headers")
+	}
+}
+
+// hijackRecorder is a recorder that can be hijacked, like the writers of
+// HTTP/1 servers.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (r *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.hijacked = true
+	return nil, nil, nil
+}
+
+// serveHijack serves a request with an upgrade handler wrapped by wrap, and
+// reports whether the handler could hijack the connection.
+func serveHijack(tb testing.TB, wrap func(next http.Handler) http.Handler) bool {
+	tb.Helper()
+
+	h := wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "cannot hijack", http.StatusInternalServerError)
+			return
+		}
+		if _, _, err := hj.Hijack(); err != nil {
+			tb.Error(err)
+		}
+	}))
+
+	rec := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec.hijacked
+}
+
+func TestCacheRejections_hijack(t *testing.T) {
+	t.Parallel()
+
+	hijacked := serveHijack(t, func(next http.Handler) http.Handler {
+		h, err := httplimit.CacheRejections(next, &httplimit.CacheConfig{Vary: []string{"X-API-Key"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	})
+	if !hijacked {
+		t.Error("expected connection to be hijacked")
+	}
+}
@@ -0,0 +1,78 @@
+package httplimit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// KeyStrategy is a way to identify requests, with the limit that applies to
+// the requests it identifies.
+type KeyStrategy struct {
+	// Name identifies the strategy, like "user" or "ip". Keys are prefixed with
+	// it, so different strategies never share a key. It is required and cannot
+	// contain ":".
+	Name string
+
+	// KeyFunc keys the requests of the strategy. It returns ErrNoKey for
+	// requests that do not carry its identity, like unauthenticated requests.
+	KeyFunc KeyFunc
+
+	// Limit is the limit of the requests the strategy keys. 0 tokens means the
+	// requests are not limited.
+	Limit Limit
+}
+
+// NewCascadingMiddleware creates a middleware that keys each request with the
+// first strategy that does not return ErrNoKey, and limits it with the limit of
+// that strategy, using a store created by s for that limit. For example, with
+// strategies for the user ID, the API key, and the IP address, in that order,
+// authenticated traffic is limited per account while unauthenticated traffic
+// is limited per IP address. Close the middleware to close the stores it
+// created.
+func NewCascadingMiddleware(s StoreFunc, strategies ...KeyStrategy) (*Middleware, error) {
+	if len(strategies) == 0 {
+		return nil, fmt.Errorf("at least one strategy is required")
+	}
+
+	limits := make(map[string]Limit, len(strategies))
+	for i, st := range strategies {
+		if st.Name == "" || strings.Contains(st.Name, ":") {
+			return nil, fmt.Errorf("strategy %d: invalid name %q", i, st.Name)
+		}
+		if _, ok := limits[st.Name]; ok {
+			return nil, fmt.Errorf("strategy %d: duplicate name %q", i, st.Name)
+		}
+		if st.KeyFunc == nil {
+			return nil, fmt.Errorf("strategy %q: key function cannot be nil", st.Name)
+		}
+		limits[st.Name] = st.Limit
+	}
+
+	keyFunc := func(r *http.Request) (string, error) {
+		for _, st := range strategies {
+			key, err := st.KeyFunc(r)
+			if errors.Is(err, ErrNoKey) {
+				continue
+			}
+			if err != nil {
+				return "", fmt.Errorf("strategy %q: %w", st.Name, err)
+			}
+			return st.Name + ":" + key, nil
+		}
+		return "", ErrNoKey
+	}
+
+	limitFunc := func(key string, r *http.Request) (uint64, time.Duration) {
+		name := key
+		if i := strings.IndexByte(key, ':'); i >= 0 {
+			name = key[:i]
+		}
+		l := limits[name]
+		return l.Tokens, l.Interval
+	}
+
+	return NewTieredMiddleware(keyFunc, limitFunc, s)
+}
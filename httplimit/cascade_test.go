@@ -0,0 +1,126 @@
+package httplimit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/limittest"
+)
+
+func TestNewCascadingMiddleware(t *testing.T) {
+	t.Parallel()
+
+	// Stores are created per limit, so give the strategies the same limit as
+	// well to check their keys do not collide.
+	middleware, err := httplimit.NewCascadingMiddleware(
+		func(tokens uint64, interval time.Duration) (limiter.Store, error) {
+			return limittest.NewStore(tokens, interval), nil
+		},
+		httplimit.KeyStrategy{
+			Name:    "user",
+			KeyFunc: httplimit.ContextKeyFunc(userKey{}),
+			Limit:   httplimit.Limit{Tokens: 5, Interval: time.Minute},
+		},
+		httplimit.KeyStrategy{
+			Name:    "apikey",
+			KeyFunc: httplimit.HeaderKeyFunc("X-API-Key"),
+			Limit:   httplimit.Limit{Tokens: 3, Interval: time.Minute},
+		},
+		httplimit.KeyStrategy{
+			Name:    "ip",
+			KeyFunc: httplimit.IPKeyFunc(),
+			Limit:   httplimit.Limit{Tokens: 3, Interval: time.Minute},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer middleware.Close()
+
+	h := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	cases := []struct {
+		name  string
+		setup func(r *http.Request) *http.Request
+		n     int
+	}{
+		{
+			name: "user",
+			setup: func(r *http.Request) *http.Request {
+				return r.WithContext(context.WithValue(r.Context(), userKey{}, "alice"))
+			},
+			n: 5,
+		},
+		{
+			name: "apikey",
+			setup: func(r *http.Request) *http.Request {
+				r.Header.Set("X-API-Key", "s3cret")
+				return r
+			},
+			n: 3,
+		},
+		{
+			name:  "ip",
+			setup: func(r *http.Request) *http.Request { return r },
+			n:     3,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		limittest.AssertLimitedFunc(t, h, tc.n, func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			return tc.setup(r)
+		})
+	}
+}
+
+func TestNewCascadingMiddleware_Validate(t *testing.T) {
+	t.Parallel()
+
+	storeFunc := func(tokens uint64, interval time.Duration) (limiter.Store, error) {
+		return limittest.NewStore(tokens, interval), nil
+	}
+	ip := httplimit.IPKeyFunc()
+
+	cases := []struct {
+		name       string
+		strategies []httplimit.KeyStrategy
+	}{
+		{name: "empty"},
+		{
+			name:       "no_name",
+			strategies: []httplimit.KeyStrategy{{KeyFunc: ip}},
+		},
+		{
+			name:       "colon",
+			strategies: []httplimit.KeyStrategy{{Name: "a:b", KeyFunc: ip}},
+		},
+		{
+			name:       "duplicate",
+			strategies: []httplimit.KeyStrategy{{Name: "ip", KeyFunc: ip}, {Name: "ip", KeyFunc: ip}},
+		},
+		{
+			name:       "no_key_func",
+			strategies: []httplimit.KeyStrategy{{Name: "ip"}},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := httplimit.NewCascadingMiddleware(storeFunc, tc.strategies...); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
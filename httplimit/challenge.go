@@ -0,0 +1,222 @@
+package httplimit
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/internal/httpwriter"
+)
+
+const (
+	// HeaderChallenge is set on challenge responses by the default
+	// ChallengeFunc, to "required".
+	HeaderChallenge = "X-RateLimit-Challenge"
+
+	// HeaderChallengeToken is the header requests carry a challenge token in.
+	// Browsers can send it as the ChallengeCookie instead.
+	HeaderChallengeToken = "X-RateLimit-Challenge-Token"
+
+	// ChallengeCookie is the name of the cookie browsers carry a challenge
+	// token in.
+	ChallengeCookie = "ratelimit_challenge"
+)
+
+// ChallengeFunc responds to a request of a client that was denied too often
+// with a challenge, like a redirect to a CAPTCHA page. The rate limit headers
+// of the denial are already set.
+type ChallengeFunc func(w http.ResponseWriter, r *http.Request)
+
+// RedirectChallenge returns a ChallengeFunc that redirects clients to the page
+// of the challenge, like a CAPTCHA, with a 303.
+func RedirectChallenge(url string) ChallengeFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, url, http.StatusSeeOther)
+	}
+}
+
+// ChallengeConfig is used as input to NewChallenge.
+type ChallengeConfig struct {
+	// KeyFunc keys requests like the limiting middleware does, so challenge
+	// tokens only restore the limit of the client they were issued to. It is
+	// required.
+	KeyFunc KeyFunc
+
+	// Denials counts the denials of each key. Each denial takes a token of the
+	// key, and once it has none left, the key's denied requests are challenged,
+	// so a store with 5 tokens per 10 minutes challenges clients denied more
+	// than 5 times in 10 minutes. It is required.
+	Denials limiter.Store
+
+	// Keys sign the challenge tokens, like the keys of NewBypass, and at least
+	// one is required. Challenge tokens carry their purpose in the signed
+	// payload, so they are not accepted as bypass tokens even if the same keys
+	// sign both, but separate keys are still recommended.
+	Keys [][]byte
+
+	// TTL is how long a challenge token restores the higher limit. The default
+	// value is 1 hour.
+	TTL time.Duration
+
+	// Challenge responds with the challenge. The default responds with a 429
+	// with the HeaderChallenge header.
+	Challenge ChallengeFunc
+}
+
+// Challenge escalates clients that keep being denied to a challenge, like a
+// CAPTCHA, instead of further 429s, and restores a higher limit for clients
+// that solved it. The application verifies the solution itself and then
+// issues a token with Token, which the client sends with its requests.
+type Challenge struct {
+	keyFunc   KeyFunc
+	denials   limiter.Store
+	tokens    *Bypass
+	ttl       time.Duration
+	challenge ChallengeFunc
+}
+
+// NewChallenge creates a challenge flow. It returns an error if the KeyFunc,
+// the Denials store, or the keys are missing.
+func NewChallenge(c *ChallengeConfig) (*Challenge, error) {
+	if c == nil {
+		c = new(ChallengeConfig)
+	}
+
+	if c.KeyFunc == nil {
+		return nil, fmt.Errorf("key function cannot be nil")
+	}
+
+	if c.Denials == nil {
+		return nil, fmt.Errorf("denials store cannot be nil")
+	}
+
+	tokens, err := NewBypass(c.Keys...)
+	if err != nil {
+		return nil, err
+	}
+	tokens.purpose = "challenge"
+
+	ttl := time.Hour
+	if c.TTL > 0 {
+		ttl = c.TTL
+	}
+
+	challenge := c.Challenge
+	if challenge == nil {
+		challenge = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(HeaderChallenge, "required")
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		}
+	}
+
+	return &Challenge{
+		keyFunc:   c.KeyFunc,
+		denials:   c.Denials,
+		tokens:    tokens,
+		ttl:       ttl,
+		challenge: challenge,
+	}, nil
+}
+
+// Token issues a challenge token for the client of the request, after the
+// application verified its solution of the challenge.
+func (c *Challenge) Token(r *http.Request) (string, error) {
+	key, err := c.keyFunc(r)
+	if err != nil {
+		return "", err
+	}
+	return c.tokens.Token(key, c.ttl), nil
+}
+
+// Handle returns a handler that serves requests with a valid challenge token
+// for their key with restored, which is usually next wrapped by a middleware
+// with a higher limit, and all others with limited, which is usually next
+// wrapped by the usual middleware:
+//
+//	mux.Handle("/", challenge.Handle(middleware.Handle(app), higher.Handle(app)))
+//
+// The denials of the limiting middleware in limited count as denials of the
+// key, and once it was denied too often, they are replaced with the challenge.
+// Other 429s, like those of the application, are not counted or replaced.
+func (c *Challenge) Handle(limited, restored http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := c.keyFunc(r)
+		if err != nil {
+			limited.ServeHTTP(w, r)
+			return
+		}
+
+		if token := challengeToken(r); token != "" {
+			if subject, err := c.tokens.Verify(token); err == nil && subject == key {
+				restored.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		cw := &challengeWriter{Writer: httpwriter.Writer{ResponseWriter: w}, c: c, key: key}
+		cw.r = r.WithContext(withDenied(r.Context(), &cw.denied))
+		limited.ServeHTTP(cw, cw.r)
+	})
+}
+
+// challengeToken returns the challenge token of the request, from the header
+// or the cookie.
+func challengeToken(r *http.Request) string {
+	if token := r.Header.Get(HeaderChallengeToken); token != "" {
+		return token
+	}
+	if cookie, err := r.Cookie(ChallengeCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// challengeWriter counts a 429 of the limiting middleware as a denial of the
+// key, and replaces it with the challenge once the key was denied too often.
+type challengeWriter struct {
+	httpwriter.Writer
+	c   *Challenge
+	r   *http.Request
+	key string
+
+	// denied is set by the middleware when it denies the request.
+	denied      bool
+	wroteHeader bool
+	challenged  bool
+}
+
+func (w *challengeWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	if status == http.StatusTooManyRequests && w.denied {
+		res, err := w.c.denials.Take(w.r.Context(), w.key)
+		if err == nil && !res.Allowed {
+			w.challenged = true
+			w.c.challenge(w.ResponseWriter, w.r)
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *challengeWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.challenged {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush flushes the response, unless it was replaced with the challenge.
+func (w *challengeWriter) Flush() {
+	if w.challenged {
+		return
+	}
+	w.Writer.Flush()
+}
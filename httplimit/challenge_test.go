@@ -0,0 +1,243 @@
+package httplimit_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/limittest"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestChallenge_Handle(t *testing.T) {
+	t.Parallel()
+
+	keyFunc := func(r *http.Request) (string, error) {
+		return r.Header.Get("X-User"), nil
+	}
+	newMiddleware := func(tb testing.TB, tokens uint64) *httplimit.Middleware {
+		m, err := httplimit.NewMiddleware(limittest.NewStore(tokens, time.Hour), keyFunc)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return m
+	}
+
+	cases := []struct {
+		name      string
+		challenge httplimit.ChallengeFunc
+		check     func(tb testing.TB, w *httptest.ResponseRecorder)
+	}{
+		{
+			name: "default",
+			check: func(tb testing.TB, w *httptest.ResponseRecorder) {
+				if got, want := w.Code, http.StatusTooManyRequests; got != want {
+					tb.Errorf("expected %d to be %d", got, want)
+				}
+				if got, want := w.Header().Get(httplimit.HeaderChallenge), "required"; got != want {
+					tb.Errorf("expected %q to be %q", got, want)
+				}
+			},
+		},
+		{
+			name:      "redirect",
+			challenge: httplimit.RedirectChallenge("/captcha"),
+			check: func(tb testing.TB, w *httptest.ResponseRecorder) {
+				if got, want := w.Code, http.StatusSeeOther; got != want {
+					tb.Errorf("expected %d to be %d", got, want)
+				}
+				if got, want := w.Header().Get("Location"), "/captcha"; got != want {
+					tb.Errorf("expected %q to be %q", got, want)
+				}
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			challenge, err := httplimit.NewChallenge(&httplimit.ChallengeConfig{
+				KeyFunc:   keyFunc,
+				Denials:   limittest.NewStore(2, time.Hour),
+				Keys:      [][]byte{[]byte("secret-key")},
+				Challenge: tc.challenge,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			h := challenge.Handle(newMiddleware(t, 1).Handle(app), newMiddleware(t, 100).Handle(app))
+
+			serve := func(user string, f func(r *http.Request)) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.Header.Set("X-User", user)
+				if f != nil {
+					f(r)
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				return w
+			}
+
+			// The first denials are the usual 429s, and then the client is
+			// challenged.
+			for i, want := range []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+				w := serve("alice", nil)
+				if got := w.Code; got != want {
+					t.Fatalf("request %d: expected %d to be %d", i, got, want)
+				}
+				if w.Header().Get(httplimit.HeaderChallenge) != "" {
+					t.Errorf("request %d: expected no challenge", i)
+				}
+			}
+			tc.check(t, serve("alice", nil))
+
+			// After solving the challenge, the client's token restores the
+			// higher limit.
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-User", "alice")
+			token, err := challenge.Token(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := serve("alice", func(r *http.Request) {
+				r.Header.Set(httplimit.HeaderChallengeToken, token)
+			})
+			if got, want := w.Code, http.StatusOK; got != want {
+				t.Errorf("expected %d to be %d", got, want)
+			}
+			w = serve("alice", func(r *http.Request) {
+				r.AddCookie(&http.Cookie{Name: httplimit.ChallengeCookie, Value: token})
+			})
+			if got, want := w.Code, http.StatusOK; got != want {
+				t.Errorf("expected %d to be %d", got, want)
+			}
+
+			// The token does not restore the limit of other clients.
+			serve("mallory", nil)
+			w = serve("mallory", func(r *http.Request) {
+				r.Header.Set(httplimit.HeaderChallengeToken, token)
+			})
+			if got, want := w.Code, http.StatusTooManyRequests; got != want {
+				t.Errorf("expected %d to be %d", got, want)
+			}
+		})
+	}
+}
+
+func TestChallenge_Handle_applicationDenials(t *testing.T) {
+	t.Parallel()
+
+	challenge, err := httplimit.NewChallenge(&httplimit.ChallengeConfig{
+		KeyFunc: httplimit.IPKeyFunc(),
+		Denials: limittest.NewStore(1, time.Hour),
+		Keys:    [][]byte{[]byte("secret-key")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The application's own 429s are not denials of the limiter, so they are
+	// never replaced with the challenge.
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+	})
+	h := challenge.Handle(app, app)
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if got, want := w.Code, http.StatusTooManyRequests; got != want {
+			t.Fatalf("request %d: expected %d to be %d", i, got, want)
+		}
+		if w.Header().Get(httplimit.HeaderChallenge) != "" {
+			t.Errorf("request %d: expected no challenge", i)
+		}
+	}
+}
+
+func TestChallenge_Token_bypass(t *testing.T) {
+	t.Parallel()
+
+	keys := [][]byte{[]byte("secret-key")}
+	challenge, err := httplimit.NewChallenge(&httplimit.ChallengeConfig{
+		KeyFunc: httplimit.IPKeyFunc(),
+		Denials: limittest.NewStore(1, time.Hour),
+		Keys:    keys,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bypass, err := httplimit.NewBypass(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	token, err := challenge.Token(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A challenge token does not bypass the limiter, even with the same keys.
+	if _, err := bypass.Verify(token); !errors.Is(err, httplimit.ErrInvalidBypassToken) {
+		t.Errorf("expected %v to be %v", err, httplimit.ErrInvalidBypassToken)
+	}
+
+	// And a bypass token does not restore the limit of a challenge.
+	key, err := httplimit.IPKeyFunc()(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored bool
+	h := challenge.Handle(http.NotFoundHandler(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		restored = true
+	}))
+	r.Header.Set(httplimit.HeaderChallengeToken, bypass.Token(key, time.Hour))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if restored {
+		t.Error("expected bypass token to not restore the limit")
+	}
+}
+
+func TestNewChallenge(t *testing.T) {
+	t.Parallel()
+
+	store, err := memorystore.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	keyFunc := httplimit.IPKeyFunc()
+	keys := [][]byte{[]byte("secret-key")}
+
+	cases := []struct {
+		name string
+		c    *httplimit.ChallengeConfig
+	}{
+		{"nil", nil},
+		{"key_func", &httplimit.ChallengeConfig{Denials: store, Keys: keys}},
+		{"denials", &httplimit.ChallengeConfig{KeyFunc: keyFunc, Keys: keys}},
+		{"keys", &httplimit.ChallengeConfig{KeyFunc: keyFunc, Denials: store}},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := httplimit.NewChallenge(tc.c); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package httplimit
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HeaderConnectionLimit is the header used to report the maximum number of
+// concurrent long-lived connections per key.
+const HeaderConnectionLimit = "X-ConnectionLimit-Limit"
+
+// ConnectionMiddleware limits the number of concurrent long-lived connections
+// per key, like WebSocket upgrades and server-sent event streams, which a rate
+// limit does not bound since each is only a single request. A connection holds
+// its slot until the handler returns, so handlers must return when the
+// connection closes, as the usual WebSocket read loops and SSE writers do.
+//
+// Connections are counted in memory, so the limit is per process.
+type ConnectionMiddleware struct {
+	keyFunc KeyFunc
+	max     uint64
+
+	lock   sync.Mutex
+	active map[string]uint64
+}
+
+// NewConnectionMiddleware creates a middleware that allows up to max
+// concurrent long-lived connections per key. Other requests are passed
+// through without being counted. It returns an error if the KeyFunc is nil or
+// max is 0.
+func NewConnectionMiddleware(f KeyFunc, max uint64) (*ConnectionMiddleware, error) {
+	if f == nil {
+		return nil, fmt.Errorf("key function cannot be nil")
+	}
+
+	if max == 0 {
+		return nil, fmt.Errorf("max connections must be positive")
+	}
+
+	return &ConnectionMiddleware{
+		keyFunc: f,
+		max:     max,
+		active:  make(map[string]uint64),
+	}, nil
+}
+
+// Handle returns the HTTP handler as a middleware. Long-lived connections over
+// the limit are rejected with a 429 before the next handler is called.
+func (m *ConnectionMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsLongLived(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := m.keyFunc(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(HeaderConnectionLimit, strconv.FormatUint(m.max, 10))
+		if !m.acquire(key) {
+			markDenied(r)
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		defer m.release(key)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Active returns the number of open long-lived connections for the key.
+func (m *ConnectionMiddleware) Active(key string) uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.active[key]
+}
+
+// acquire takes a connection slot for the key, if one is free.
+func (m *ConnectionMiddleware) acquire(key string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.active[key] >= m.max {
+		return false
+	}
+	m.active[key]++
+	return true
+}
+
+// release returns a connection slot for the key.
+func (m *ConnectionMiddleware) release(key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.active[key] <= 1 {
+		delete(m.active, key)
+		return
+	}
+	m.active[key]--
+}
+
+// IsLongLived reports whether the request opens a long-lived connection: a
+// WebSocket upgrade, or a request for a server-sent event stream.
+func IsLongLived(r *http.Request) bool {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return true
+	}
+	for _, accept := range r.Header.Values("Accept") {
+		for _, t := range strings.Split(accept, ",") {
+			if i := strings.IndexByte(t, ';'); i >= 0 {
+				t = t[:i]
+			}
+			if strings.EqualFold(strings.TrimSpace(t), "text/event-stream") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package httplimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/sethvargo/go-limiter/httplimit"
+)
+
+func TestConnectionMiddleware(t *testing.T) {
+	t.Parallel()
+
+	middleware, err := httplimit.NewConnectionMiddleware(httplimit.IPKeyFunc(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The handler holds each connection open until release is closed.
+	release := make(chan struct{})
+	var opened sync.WaitGroup
+	h := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if httplimit.IsLongLived(r) {
+			opened.Done()
+			<-release
+		}
+	}))
+
+	stream := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/events", nil)
+		r.Header.Set("Accept", "text/event-stream")
+		return r
+	}
+
+	var done sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		opened.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			h.ServeHTTP(httptest.NewRecorder(), stream())
+		}()
+	}
+	opened.Wait()
+
+	if got, want := middleware.Active("192.0.2.1"), uint64(2); got != want {
+		t.Errorf("active: expected %d to be %d", got, want)
+	}
+
+	// The limit is reached, so another stream is rejected, but plain requests
+	// are not counted.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, stream())
+	if got, want := w.Code, http.StatusTooManyRequests; got != want {
+		t.Errorf("stream: expected %d to be %d", got, want)
+	}
+	if got, want := w.Header().Get(httplimit.HeaderConnectionLimit), strconv.Itoa(2); got != want {
+		t.Errorf("stream: limit: expected %q to be %q", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("plain: expected %d to be %d", got, want)
+	}
+
+	close(release)
+	done.Wait()
+
+	if got, want := middleware.Active("192.0.2.1"), uint64(0); got != want {
+		t.Errorf("active after close: expected %d to be %d", got, want)
+	}
+
+	// Closed connections release their slots.
+	w = httptest.NewRecorder()
+	opened.Add(1)
+	h.ServeHTTP(w, stream())
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("after close: expected %d to be %d", got, want)
+	}
+}
+
+func TestIsLongLived(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		header http.Header
+		want   bool
+	}{
+		{
+			name:   "websocket",
+			header: http.Header{"Connection": {"Upgrade"}, "Upgrade": {"WebSocket"}},
+			want:   true,
+		},
+		{
+			name:   "sse",
+			header: http.Header{"Accept": {"text/html, text/event-stream;q=0.9"}},
+			want:   true,
+		},
+		{
+			name:   "html",
+			header: http.Header{"Accept": {"text/html"}},
+			want:   false,
+		},
+		{
+			name:   "h2c",
+			header: http.Header{"Upgrade": {"h2c"}},
+			want:   false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header = tc.header
+			if got, want := httplimit.IsLongLived(r), tc.want; got != want {
+				t.Errorf("expected %t to be %t", got, want)
+			}
+		})
+	}
+}
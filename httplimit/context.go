@@ -0,0 +1,45 @@
+package httplimit
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// resultKey is the context key for the result of the take.
+type resultKey struct{}
+
+// withResult returns a copy of ctx that carries the result of the take.
+func withResult(ctx context.Context, res limiter.Result) context.Context {
+	return context.WithValue(ctx, resultKey{}, res)
+}
+
+// ResultFromContext returns the result of the take that allowed the request,
+// which the middleware stores in the request context before calling the next
+// handler. Handlers can use it to log the remaining quota or include it in
+// responses. It returns false if there is none, for example because the store
+// failed open.
+func ResultFromContext(ctx context.Context) (limiter.Result, bool) {
+	res, ok := ctx.Value(resultKey{}).(limiter.Result)
+	return res, ok
+}
+
+// deniedKey is the context key for the flag that records whether the limiting
+// middleware denied the request.
+type deniedKey struct{}
+
+// withDenied returns a copy of ctx in which the middleware's denials of the
+// request set denied.
+func withDenied(ctx context.Context, denied *bool) context.Context {
+	return context.WithValue(ctx, deniedKey{}, denied)
+}
+
+// markDenied records that the middleware denied the request, if its context
+// has a flag for it, so a 429 of the middleware can be told apart from one of
+// the application.
+func markDenied(r *http.Request) {
+	if denied, ok := r.Context().Value(deniedKey{}).(*bool); ok {
+		*denied = true
+	}
+}
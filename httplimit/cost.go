@@ -0,0 +1,162 @@
+package httplimit
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/internal/httpwriter"
+)
+
+// Usage describes how a request was served, for a CostFunc.
+type Usage struct {
+	// Status is the status code of the response.
+	Status int
+
+	// Bytes is the number of bytes of the response body. Bytes written to a
+	// hijacked connection, like the messages of a WebSocket, are not counted.
+	Bytes int64
+
+	// Duration is how long the handler took.
+	Duration time.Duration
+
+	// Reported is the cost the handler reported with AddCost, like the time
+	// it spent in the database.
+	Reported uint64
+}
+
+// CostFunc returns the actual cost of a request in tokens, after the handler
+// served it.
+type CostFunc func(r *http.Request, u Usage) uint64
+
+// BytesCost returns a function that charges a token for every bytesPerToken
+// bytes served, and at least 1 token.
+func BytesCost(bytesPerToken int64) CostFunc {
+	return func(_ *http.Request, u Usage) uint64 {
+		return minCost(ceilDiv(u.Bytes, bytesPerToken))
+	}
+}
+
+// DurationCost returns a function that charges a token for every perToken the
+// handler took, and at least 1 token.
+func DurationCost(perToken time.Duration) CostFunc {
+	return func(_ *http.Request, u Usage) uint64 {
+		return minCost(ceilDiv(int64(u.Duration), int64(perToken)))
+	}
+}
+
+// ReportedCost returns a function that charges the cost the handler reported
+// with AddCost. Requests that reported none are free.
+func ReportedCost() CostFunc {
+	return func(_ *http.Request, u Usage) uint64 {
+		return u.Reported
+	}
+}
+
+// costKey is the context key for the cost reported by the handler.
+type costKey struct{}
+
+// AddCost adds tokens to the cost of the request whose context is ctx, for a
+// CostFunc to read as Usage.Reported. It is safe to call from several
+// goroutines, and does nothing outside of HandleWithCost.
+func AddCost(ctx context.Context, tokens uint64) {
+	if reported, ok := ctx.Value(costKey{}).(*uint64); ok {
+		atomic.AddUint64(reported, tokens)
+	}
+}
+
+// HandleWithCost returns the HTTP handler as a middleware like Handle, but
+// charges a provisional token up front and adjusts the charge to the cost
+// returned by f after the next handler returns: a cost of 0 refunds the token,
+// and a higher cost charges the rest. This limits requests by the work they
+// cause, like the bytes they are served, instead of their number. The
+// provisional token means requests are still denied once the key is empty.
+//
+// Each adjustment is a single atomic Refund or Charge on the store, which must
+// implement limiter.Refunder and limiter.Charger; otherwise the charge stays
+// at 1 token. The response was already sent, so errors adjusting the charge
+// are ignored and the headers report the remaining tokens before it.
+func (m *Middleware) HandleWithCost(f CostFunc, next http.Handler) http.Handler {
+	return m.handle(next, f, nil)
+}
+
+// serveWithCost serves the request and adjusts the charge of the key.
+func serveWithCost(w http.ResponseWriter, r *http.Request, next http.Handler, f CostFunc, store limiter.Store, key string) {
+	var reported uint64
+	r = r.WithContext(context.WithValue(r.Context(), costKey{}, &reported))
+
+	cw := &costWriter{Writer: httpwriter.Writer{ResponseWriter: w}}
+	start := time.Now()
+	next.ServeHTTP(cw, r)
+
+	status := cw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	cost := f(r, Usage{
+		Status:   status,
+		Bytes:    cw.bytes,
+		Duration: time.Since(start),
+		Reported: atomic.LoadUint64(&reported),
+	})
+
+	// The request may have been canceled, but the work was still done.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	switch {
+	case cost == 0:
+		if rf, ok := store.(limiter.Refunder); ok {
+			_ = rf.Refund(ctx, key, 1)
+		}
+	case cost > 1:
+		if c, ok := store.(limiter.Charger); ok {
+			_ = c.Charge(ctx, key, cost-1)
+		}
+	}
+}
+
+// costWriter records the status and size of a response.
+type costWriter struct {
+	httpwriter.Writer
+	status int
+	bytes  int64
+}
+
+func (w *costWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *costWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// ceilDiv returns n divided by d, rounded up. A non-positive d counts every
+// unit as a token.
+func ceilDiv(n, d int64) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	if d <= 0 {
+		return uint64(n)
+	}
+	return uint64((n + d - 1) / d)
+}
+
+// minCost returns the cost, but at least 1 token.
+func minCost(cost uint64) uint64 {
+	if cost < 1 {
+		return 1
+	}
+	return cost
+}
@@ -0,0 +1,120 @@
+package httplimit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/limittest"
+)
+
+func TestMiddleware_HandleWithCost(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		cost    httplimit.CostFunc
+		handler http.HandlerFunc
+		want    uint64
+	}{
+		{
+			name: "bytes",
+			cost: httplimit.BytesCost(10),
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(strings.Repeat("x", 35)))
+			},
+			want: 6,
+		},
+		{
+			name:    "bytes_empty",
+			cost:    httplimit.BytesCost(10),
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			want:    9,
+		},
+		{
+			name: "reported",
+			cost: httplimit.ReportedCost(),
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				httplimit.AddCost(r.Context(), 2)
+				httplimit.AddCost(r.Context(), 3)
+			},
+			want: 5,
+		},
+		{
+			name:    "free",
+			cost:    httplimit.ReportedCost(),
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			want:    10,
+		},
+		{
+			name: "status",
+			cost: func(r *http.Request, u httplimit.Usage) uint64 {
+				if u.Status >= 500 {
+					return 0
+				}
+				return 1
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			want: 10,
+		},
+		{
+			name:    "overcharge",
+			cost:    httplimit.DurationCost(time.Nanosecond),
+			handler: func(w http.ResponseWriter, r *http.Request) { time.Sleep(time.Millisecond) },
+			want:    0,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := limittest.NewStore(10, time.Minute)
+			middleware, err := httplimit.NewMiddleware(store, httplimit.IPKeyFunc())
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := middleware.HandleWithCost(tc.cost, tc.handler)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			key, err := httplimit.IPKeyFunc()(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := store.Peek(context.Background(), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := res.Remaining, tc.want; got != want {
+				t.Errorf("expected %d to be %d", got, want)
+			}
+		})
+	}
+}
+
+func TestMiddleware_HandleWithCost_hijack(t *testing.T) {
+	t.Parallel()
+
+	middleware, err := httplimit.NewMiddleware(limittest.NewStore(10, time.Minute), httplimit.IPKeyFunc())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hijacked := serveHijack(t, func(next http.Handler) http.Handler {
+		return middleware.HandleWithCost(httplimit.BytesCost(1), next)
+	})
+	if !hijacked {
+		t.Error("expected connection to be hijacked")
+	}
+}
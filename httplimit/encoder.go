@@ -0,0 +1,203 @@
+package httplimit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// Encoder writes the bodies of the responses the middleware denies, so
+// clients can read the state of their limit from the body as well as the
+// headers. Implement it for other formats, like MessagePack.
+type Encoder interface {
+	// ContentType returns the media type of the bodies.
+	ContentType() string
+
+	// Encode writes the body for the denied request and the result of its take.
+	Encode(w io.Writer, r *http.Request, res limiter.Result) error
+}
+
+// Problem is the body ProblemJSON, ProblemXML, and ProblemProtobuf encode: the
+// problem details of RFC 7807, with the state of the limit as extension
+// members.
+type Problem struct {
+	XMLName xml.Name `json:"-" xml:"urn:ietf:rfc:7807 problem"`
+
+	// Type is a URI that identifies the problem type. It is omitted if empty,
+	// which means "about:blank".
+	Type string `json:"type,omitempty" xml:"type,omitempty"`
+
+	// Title, Status, and Detail describe the problem to people.
+	Title  string `json:"title" xml:"title"`
+	Status int    `json:"status" xml:"status"`
+	Detail string `json:"detail" xml:"detail"`
+
+	// Limit, Remaining, and Reset are the state of the limit, and RetryAfter
+	// the number of seconds until the next request is allowed.
+	Limit      uint64    `json:"limit" xml:"limit"`
+	Remaining  uint64    `json:"remaining" xml:"remaining"`
+	Reset      time.Time `json:"reset" xml:"reset"`
+	RetryAfter int64     `json:"retry_after" xml:"retry_after"`
+}
+
+// newProblem returns the problem of a denied take.
+func newProblem(typ string, res limiter.Result) *Problem {
+	retryAfter := RetryAfterSeconds(res.RetryAfter)
+	return &Problem{
+		Type:       typ,
+		Title:      http.StatusText(http.StatusTooManyRequests),
+		Status:     http.StatusTooManyRequests,
+		Detail:     fmt.Sprintf("The rate limit of %d requests was exceeded; retry in %d seconds.", res.Limit, retryAfter),
+		Limit:      res.Limit,
+		Remaining:  res.Remaining,
+		Reset:      res.ResetAt.UTC(),
+		RetryAfter: retryAfter,
+	}
+}
+
+// ProblemJSON encodes bodies as application/problem+json.
+type ProblemJSON struct {
+	// Type is the URI of the problem type, like a page documenting the API's
+	// limits. The default value omits it.
+	Type string
+}
+
+// ContentType implements Encoder.
+func (e *ProblemJSON) ContentType() string {
+	return "application/problem+json"
+}
+
+// Encode implements Encoder.
+func (e *ProblemJSON) Encode(w io.Writer, _ *http.Request, res limiter.Result) error {
+	return json.NewEncoder(w).Encode(newProblem(e.Type, res))
+}
+
+// ProblemXML encodes bodies as application/problem+xml.
+type ProblemXML struct {
+	// Type is the URI of the problem type, like a page documenting the API's
+	// limits. The default value omits it.
+	Type string
+}
+
+// ContentType implements Encoder.
+func (e *ProblemXML) ContentType() string {
+	return "application/problem+xml"
+}
+
+// Encode implements Encoder.
+func (e *ProblemXML) Encode(w io.Writer, _ *http.Request, res limiter.Result) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(newProblem(e.Type, res))
+}
+
+// ProblemProtobuf encodes bodies as application/x-protobuf, in the wire format
+// of this message, so clients can decode them with code generated from it:
+//
+//	syntax = "proto3";
+//
+//	import "google/protobuf/timestamp.proto";
+//
+//	message Problem {
+//	  string type = 1;
+//	  string title = 2;
+//	  int32 status = 3;
+//	  string detail = 4;
+//	  uint64 limit = 5;
+//	  uint64 remaining = 6;
+//	  google.protobuf.Timestamp reset = 7;
+//	  int64 retry_after = 8;
+//	}
+//
+// The message is encoded by hand, so the package does not depend on a
+// protobuf runtime.
+type ProblemProtobuf struct {
+	// Type is the URI of the problem type, like a page documenting the API's
+	// limits. The default value omits it.
+	Type string
+}
+
+// ContentType implements Encoder.
+func (e *ProblemProtobuf) ContentType() string {
+	return "application/x-protobuf"
+}
+
+// Encode implements Encoder.
+func (e *ProblemProtobuf) Encode(w io.Writer, _ *http.Request, res limiter.Result) error {
+	p := newProblem(e.Type, res)
+
+	var b []byte
+	b = appendProtoString(b, 1, p.Type)
+	b = appendProtoString(b, 2, p.Title)
+	b = appendProtoVarint(b, 3, uint64(p.Status))
+	b = appendProtoString(b, 4, p.Detail)
+	b = appendProtoVarint(b, 5, p.Limit)
+	b = appendProtoVarint(b, 6, p.Remaining)
+	if !p.Reset.IsZero() {
+		var ts []byte
+		ts = appendProtoVarint(ts, 1, uint64(p.Reset.Unix()))
+		ts = appendProtoVarint(ts, 2, uint64(p.Reset.Nanosecond()))
+		b = appendProtoBytes(b, 7, ts)
+	}
+	b = appendProtoVarint(b, 8, uint64(p.RetryAfter))
+
+	_, err := w.Write(b)
+	return err
+}
+
+// appendProtoVarint appends a varint field, unless it has the default value
+// of 0, which proto3 omits. Negative signed values are encoded as their two's
+// complement, like int32 and int64 fields.
+func appendProtoVarint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendVarint(b, uint64(field)<<3)
+	return appendVarint(b, v)
+}
+
+// appendProtoString appends a string field, unless it is empty.
+func appendProtoString(b []byte, field int, v string) []byte {
+	return appendProtoBytes(b, field, []byte(v))
+}
+
+// appendProtoBytes appends a length-delimited field, unless it is empty.
+func appendProtoBytes(b []byte, field int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = appendVarint(b, uint64(field)<<3|2)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendVarint appends v as a base 128 varint.
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// writeDenied writes the 429 response of a denied request, with the body of
+// the encoder if it is set. If the encoder fails, the body is the status text.
+func writeDenied(w http.ResponseWriter, r *http.Request, e Encoder, res limiter.Result) {
+	if e != nil {
+		var b bytes.Buffer
+		if err := e.Encode(&b, r, res); err == nil {
+			w.Header().Set("Content-Type", e.ContentType())
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write(b.Bytes())
+			return
+		}
+	}
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
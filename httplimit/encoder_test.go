@@ -0,0 +1,203 @@
+package httplimit_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+type failingEncoder struct{}
+
+func (failingEncoder) ContentType() string { return "application/msgpack" }
+
+func (failingEncoder) Encode(_ io.Writer, _ *http.Request, _ limiter.Result) error {
+	return fmt.Errorf("failed")
+}
+
+// decodeProblemProtobuf decodes the Problem message ProblemProtobuf encodes.
+func decodeProblemProtobuf(b []byte, p *httplimit.Problem) error {
+	var seconds, nanos int64
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("invalid tag")
+		}
+		b = b[n:]
+
+		if tag&7 == 2 {
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return fmt.Errorf("invalid length")
+			}
+			v := b[n : n+int(size)]
+			b = b[n+int(size):]
+
+			switch tag >> 3 {
+			case 1:
+				p.Type = string(v)
+			case 2:
+				p.Title = string(v)
+			case 4:
+				p.Detail = string(v)
+			case 7:
+				for len(v) > 0 {
+					tag, n := binary.Uvarint(v)
+					if n <= 0 {
+						return fmt.Errorf("invalid timestamp tag")
+					}
+					x, m := binary.Uvarint(v[n:])
+					if m <= 0 {
+						return fmt.Errorf("invalid timestamp value")
+					}
+					v = v[n+m:]
+					if tag>>3 == 1 {
+						seconds = int64(x)
+					} else {
+						nanos = int64(x)
+					}
+				}
+			}
+			continue
+		}
+
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("invalid varint")
+		}
+		b = b[n:]
+
+		switch tag >> 3 {
+		case 3:
+			p.Status = int(v)
+		case 5:
+			p.Limit = v
+		case 6:
+			p.Remaining = v
+		case 8:
+			p.RetryAfter = int64(v)
+		}
+	}
+	p.Reset = time.Unix(seconds, nanos).UTC()
+	return nil
+}
+
+func TestMiddleware_Encoder(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name        string
+		encoder     httplimit.Encoder
+		contentType string
+		decode      func(b []byte, p *httplimit.Problem) error
+	}{
+		{
+			name:        "none",
+			contentType: "text/plain; charset=utf-8",
+		},
+		{
+			name:        "problem_json",
+			encoder:     &httplimit.ProblemJSON{Type: "https://example.com/rate-limits"},
+			contentType: "application/problem+json",
+			decode: func(b []byte, p *httplimit.Problem) error {
+				return json.Unmarshal(b, p)
+			},
+		},
+		{
+			name:        "problem_xml",
+			encoder:     &httplimit.ProblemXML{Type: "https://example.com/rate-limits"},
+			contentType: "application/problem+xml",
+			decode: func(b []byte, p *httplimit.Problem) error {
+				return xml.Unmarshal(b, p)
+			},
+		},
+		{
+			name:        "problem_protobuf",
+			encoder:     &httplimit.ProblemProtobuf{Type: "https://example.com/rate-limits"},
+			contentType: "application/x-protobuf",
+			decode:      decodeProblemProtobuf,
+		},
+		{
+			name:        "failing",
+			encoder:     failingEncoder{},
+			contentType: "text/plain; charset=utf-8",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			store, err := memorystore.New(&memorystore.Config{
+				Tokens:   1,
+				Interval: time.Hour,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			middleware, err := httplimit.NewMiddleware(store, func(r *http.Request) (string, error) {
+				return "key", nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			middleware.Encoder = tc.encoder
+			h := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			var w *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				w = httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			}
+
+			if got, want := w.Code, http.StatusTooManyRequests; got != want {
+				t.Fatalf("expected %d to be %d", got, want)
+			}
+			if got, want := w.Header().Get("Content-Type"), tc.contentType; got != want {
+				t.Errorf("expected %q to be %q", got, want)
+			}
+			if w.Header().Get(httplimit.HeaderRetryAfter) == "" {
+				t.Errorf("expected %s header", httplimit.HeaderRetryAfter)
+			}
+			if tc.decode == nil {
+				return
+			}
+
+			var p httplimit.Problem
+			if err := tc.decode(w.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := p.Type, "https://example.com/rate-limits"; got != want {
+				t.Errorf("expected %q to be %q", got, want)
+			}
+			if got, want := p.Status, http.StatusTooManyRequests; got != want {
+				t.Errorf("expected %d to be %d", got, want)
+			}
+			if got, want := p.Limit, uint64(1); got != want {
+				t.Errorf("expected %d to be %d", got, want)
+			}
+			if got, want := p.Remaining, uint64(0); got != want {
+				t.Errorf("expected %d to be %d", got, want)
+			}
+			if p.RetryAfter <= 0 || p.RetryAfter > 3600 {
+				t.Errorf("expected retry after within the interval, got %d", p.RetryAfter)
+			}
+			if p.Reset.IsZero() {
+				t.Error("expected reset")
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package httplimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrNoKey is returned by the identity KeyFuncs when the request does not
+// carry their identity, so KeyFuncs can fall back to the next with
+// FirstKeyFunc.
+var ErrNoKey = errors.New("request has no key")
+
+// BearerKeyFunc returns a function that keys requests by the bearer token in
+// the Authorization header. The token is a secret, so the key is a hash of it.
+func BearerKeyFunc() KeyFunc {
+	return func(r *http.Request) (string, error) {
+		auth := r.Header.Get("Authorization")
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+			return "", ErrNoKey
+		}
+		token := strings.TrimSpace(auth[7:])
+		if token == "" {
+			return "", ErrNoKey
+		}
+		return hashKey("bearer", token), nil
+	}
+}
+
+// HeaderKeyFunc returns a function that keys requests by the value of the
+// header, like an API key in "X-API-Key". The value may be a secret, so the key
+// is a hash of it.
+func HeaderKeyFunc(header string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		v := r.Header.Get(header)
+		if v == "" {
+			return "", ErrNoKey
+		}
+		return hashKey("header:"+http.CanonicalHeaderKey(header), v), nil
+	}
+}
+
+// CookieKeyFunc returns a function that keys requests by the value of the
+// named cookie, like a session cookie. The value is a secret, so the key is a
+// hash of it.
+func CookieKeyFunc(name string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		c, err := r.Cookie(name)
+		if err != nil || c.Value == "" {
+			return "", ErrNoKey
+		}
+		return hashKey("cookie:"+name, c.Value), nil
+	}
+}
+
+// ContextKeyFunc returns a function that keys requests by the value stored in
+// the request context under ctxKey, like the ID of the user set by
+// authentication middleware that runs first. The value must be a string or a
+// fmt.Stringer.
+func ContextKeyFunc(ctxKey interface{}) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		var id string
+		switch v := r.Context().Value(ctxKey).(type) {
+		case string:
+			id = v
+		case fmt.Stringer:
+			id = v.String()
+		case nil:
+		default:
+			return "", fmt.Errorf("unsupported context value type %T", v)
+		}
+		if id == "" {
+			return "", ErrNoKey
+		}
+		return "user:" + id, nil
+	}
+}
+
+// FirstKeyFunc returns a function that tries each function in order, and keys
+// the request with the first that does not return ErrNoKey. Other errors are
+// returned immediately. If every function returns ErrNoKey, so does the
+// result, which the middleware treats like any other KeyFunc error; end the
+// chain with IPKeyFunc to always have a key.
+func FirstKeyFunc(fs ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		for _, f := range fs {
+			key, err := f(r)
+			if errors.Is(err, ErrNoKey) {
+				continue
+			}
+			return key, err
+		}
+		return "", ErrNoKey
+	}
+}
+
+// hashKey returns the key for the secret value from the source. The source is
+// included so values from different sources do not share a key.
+func hashKey(source, value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return source + ":" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,138 @@
+package httplimit_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sethvargo/go-limiter/httplimit"
+)
+
+type userKey struct{}
+
+func TestKeyFuncs(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		f      httplimit.KeyFunc
+		setup  func(r *http.Request) *http.Request
+		prefix string
+		err    error
+	}{
+		{
+			name: "bearer",
+			f:    httplimit.BearerKeyFunc(),
+			setup: func(r *http.Request) *http.Request {
+				r.Header.Set("Authorization", "bearer s3cret")
+				return r
+			},
+			prefix: "bearer:",
+		},
+		{
+			name: "bearer_basic",
+			f:    httplimit.BearerKeyFunc(),
+			setup: func(r *http.Request) *http.Request {
+				r.SetBasicAuth("user", "pass")
+				return r
+			},
+			err: httplimit.ErrNoKey,
+		},
+		{
+			name: "header",
+			f:    httplimit.HeaderKeyFunc("x-api-key"),
+			setup: func(r *http.Request) *http.Request {
+				r.Header.Set("X-API-Key", "s3cret")
+				return r
+			},
+			prefix: "header:X-Api-Key:",
+		},
+		{
+			name:  "header_missing",
+			f:     httplimit.HeaderKeyFunc("X-API-Key"),
+			setup: func(r *http.Request) *http.Request { return r },
+			err:   httplimit.ErrNoKey,
+		},
+		{
+			name: "cookie",
+			f:    httplimit.CookieKeyFunc("session"),
+			setup: func(r *http.Request) *http.Request {
+				r.AddCookie(&http.Cookie{Name: "session", Value: "s3cret"})
+				return r
+			},
+			prefix: "cookie:session:",
+		},
+		{
+			name:  "cookie_missing",
+			f:     httplimit.CookieKeyFunc("session"),
+			setup: func(r *http.Request) *http.Request { return r },
+			err:   httplimit.ErrNoKey,
+		},
+		{
+			name: "context",
+			f:    httplimit.ContextKeyFunc(userKey{}),
+			setup: func(r *http.Request) *http.Request {
+				return r.WithContext(context.WithValue(r.Context(), userKey{}, "alice"))
+			},
+			prefix: "user:alice",
+		},
+		{
+			name:  "context_missing",
+			f:     httplimit.ContextKeyFunc(userKey{}),
+			setup: func(r *http.Request) *http.Request { return r },
+			err:   httplimit.ErrNoKey,
+		},
+		{
+			name: "first_fallback",
+			f: httplimit.FirstKeyFunc(
+				httplimit.ContextKeyFunc(userKey{}),
+				httplimit.HeaderKeyFunc("X-API-Key"),
+				httplimit.IPKeyFunc(),
+			),
+			setup:  func(r *http.Request) *http.Request { return r },
+			prefix: "192.0.2.1",
+		},
+		{
+			name: "first_match",
+			f: httplimit.FirstKeyFunc(
+				httplimit.ContextKeyFunc(userKey{}),
+				httplimit.HeaderKeyFunc("X-API-Key"),
+				httplimit.IPKeyFunc(),
+			),
+			setup: func(r *http.Request) *http.Request {
+				r.Header.Set("X-API-Key", "s3cret")
+				return r
+			},
+			prefix: "header:X-Api-Key:",
+		},
+		{
+			name:  "first_none",
+			f:     httplimit.FirstKeyFunc(httplimit.BearerKeyFunc()),
+			setup: func(r *http.Request) *http.Request { return r },
+			err:   httplimit.ErrNoKey,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := tc.setup(httptest.NewRequest(http.MethodGet, "/", nil))
+			key, err := tc.f(r)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected %v to be %v", err, tc.err)
+			}
+			if !strings.HasPrefix(key, tc.prefix) {
+				t.Errorf("expected %q to start with %q", key, tc.prefix)
+			}
+			if strings.Contains(key, "s3cret") {
+				t.Errorf("expected %q to not contain the secret", key)
+			}
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"net"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/sethvargo/go-limiter"
@@ -24,8 +25,14 @@ const (
 	HeaderRateLimitReset     = "X-RateLimit-Reset"
 
 	// HeaderRetryAfter is the header used to indicate when a client should retry
-	// requests (when the rate limit expires), in UTC time.
+	// requests (when the rate limit expires), in seconds.
 	HeaderRetryAfter = "Retry-After"
+
+	// HeaderRateLimitPolicy is set on allowed requests whose result has
+	// Warning set by limiter.SoftLimit, to the limit with a "warning"
+	// parameter, like "100;warning". The value follows the IETF RateLimit-Policy
+	// draft, whose clients ignore parameters they do not know.
+	HeaderRateLimitPolicy = "RateLimit-Policy"
 )
 
 // KeyFunc is a function that accepts an http request and returns a string key
@@ -62,8 +69,22 @@ func IPKeyFunc(headers ...string) KeyFunc {
 // rate limiting. It can rate limit based on an arbitrary KeyFunc, and supports
 // anything that implements limiter.Store.
 type Middleware struct {
+	// Encoder, if set, writes the bodies of denied requests, instead of the
+	// status text. Set it before the middleware serves requests.
+	Encoder Encoder
+
 	store   limiter.Store
 	keyFunc KeyFunc
+
+	// limitFunc and storeFunc are set by NewTieredMiddleware, which leaves
+	// store nil. stores holds the store for each limit. If the first store
+	// created implements limiter.Overrider, it is the overrider, and the
+	// stores take from it with their limits instead.
+	limitFunc LimitFunc
+	storeFunc StoreFunc
+	lock      sync.RWMutex
+	stores    map[limit]limiter.Store
+	overrider limiter.Store
 }
 
 // NewMiddleware creates a new middleware suitable for use as an HTTP handler.
@@ -85,10 +106,18 @@ func NewMiddleware(s limiter.Store, f KeyFunc) (*Middleware, error) {
 
 // Handle returns the HTTP handler as a middleware. This handler calls Take() on
 // the store and sets the common rate limiting headers. If the take is
-// successful, the remaining middleware is called. If take is unsuccessful, the
-// middleware chain is halted and the function renders a 429 to the caller with
-// metadata about when it's safe to retry.
+// successful, the remaining middleware is called, and can get the result with
+// ResultFromContext. If take is unsuccessful, the middleware chain is halted
+// and the function renders a 429 to the caller with metadata about when it's
+// safe to retry.
 func (m *Middleware) Handle(next http.Handler) http.Handler {
+	return m.handle(next, nil, nil)
+}
+
+// handle returns the middleware, adjusting the charge of each allowed request
+// with cost if it is set, and queuing requests without a token in q if it is
+// set.
+func (m *Middleware) handle(next http.Handler, cost CostFunc, q *queue) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Call the key function - if this fails, it's an internal server error.
 		key, err := m.keyFunc(r)
@@ -97,24 +126,113 @@ func (m *Middleware) Handle(next http.Handler) http.Handler {
 			return
 		}
 
-		// Take from the store.
-		limit, remaining, reset, ok := m.store.Take(key)
-		resetTime := time.Unix(0, int64(reset)).UTC().Format(time.RFC1123)
+		// Find the store for the request's limit. If there is none, the request
+		// is not limited.
+		store, err := m.storeFor(key, r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if store == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Stores that record takes read the route from the context. Routers can
+		// set a pattern with limiter.WithRoute before the middleware runs,
+		// otherwise it is the method and path.
+		ctx := r.Context()
+		if _, ok := limiter.RouteFromContext(ctx); !ok {
+			ctx = limiter.WithRoute(ctx, r.Method+" "+r.URL.Path)
+		}
+
+		// Take from the store. If the store failed closed, it's an internal
+		// server error. If it failed open, the request is permitted.
+		var res limiter.Result
+		if q == nil {
+			res, err = store.Take(ctx, key)
+		} else {
+			res, err = q.take(ctx, store, key)
+		}
+		if err == errQueued {
+			// The queue denied the request, and there is no state of the
+			// limit to report.
+			markDenied(r)
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		if err != nil {
+			if !res.Allowed {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			// The store failed open, so there is no limit metadata to report.
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
 
 		// Set headers (we do this regardless of whether the request is permitted).
-		w.Header().Set(HeaderRateLimitLimit, strconv.FormatUint(limit, 10))
-		w.Header().Set(HeaderRateLimitRemaining, strconv.FormatUint(remaining, 10))
-		w.Header().Set(HeaderRateLimitReset, resetTime)
+		SetHeaders(w.Header(), res, nil)
 
 		// Fail if there were no tokens remaining.
-		if !ok {
-			w.Header().Set(HeaderRetryAfter, resetTime)
-			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		if !res.Allowed {
+			markDenied(r)
+			writeDenied(w, r, m.Encoder, res)
 			return
 		}
 
+		// Warn clients that are close to the limit.
+		if res.Warning {
+			w.Header().Set(HeaderRateLimitPolicy, policyWarning(res.Limit))
+		}
+
+		// The queue adapts to the latency of the next handler.
+		if q != nil {
+			defer q.served(time.Now())
+		}
+
 		// If we got this far, we're allowed to continue, so call the next middleware
-		// in the stack to continue processing.
-		next.ServeHTTP(w, r)
+		// in the stack to continue processing, with the result in the context.
+		if cost == nil {
+			next.ServeHTTP(w, r.WithContext(withResult(ctx, res)))
+			return
+		}
+		serveWithCost(w, r.WithContext(withResult(ctx, res)), next, cost, store, key)
 	})
 }
+
+// policyWarning formats the RateLimit-Policy value for a soft limit warning.
+func policyWarning(limit uint64) string {
+	return strconv.FormatUint(limit, 10) + ";warning"
+}
+
+// SetHeaders sets the rate limit headers of a take on h, and Retry-After if the
+// take was denied. If the take failed, the store either failed closed, which
+// callers answer with an error, or failed open, which leaves no limit metadata
+// to report, so no headers are set. Adapters for other protocols use it so that
+// their responses carry the same headers as the middleware.
+func SetHeaders(h http.Header, res limiter.Result, err error) {
+	if err != nil {
+		return
+	}
+
+	h.Set(HeaderRateLimitLimit, strconv.FormatUint(res.Limit, 10))
+	h.Set(HeaderRateLimitRemaining, strconv.FormatUint(res.Remaining, 10))
+	h.Set(HeaderRateLimitReset, res.ResetAt.UTC().Format(time.RFC1123))
+	if !res.Allowed {
+		h.Set(HeaderRetryAfter, strconv.FormatInt(RetryAfterSeconds(res.RetryAfter), 10))
+	}
+}
+
+// RetryAfterSeconds returns the duration as the delta-seconds of a Retry-After
+// header, rounding up so clients never retry early. Using a delay instead of an
+// HTTP date means the value is correct even if the client's clock is skewed.
+// Adapters for other protocols use it so they report the same delay.
+func RetryAfterSeconds(d time.Duration) int64 {
+	secs := int64(d / time.Second)
+	if d%time.Second > 0 {
+		secs++
+	}
+	return secs
+}
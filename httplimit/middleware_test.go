@@ -1,6 +1,7 @@
 package httplimit_test
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -8,7 +9,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/sethvargo/go-limiter"
 	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/limittest"
 	"github.com/sethvargo/go-limiter/memorystore"
 )
 
@@ -124,6 +127,258 @@ func TestNewMiddleware(t *testing.T) {
 			if got, want := remaining, uint64(0); got != want {
 				t.Errorf("remaining: expected %d to be %d", got, want)
 			}
+
+			retryAfter, err := strconv.ParseInt(resp.Header.Get(httplimit.HeaderRetryAfter), 10, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := time.Duration(retryAfter)*time.Second, tc.interval; got < time.Second || got > want+time.Second {
+				t.Errorf("retry after: expected %s to be in [1s, %s]", got, want+time.Second)
+			}
 		})
 	}
 }
+
+// errorStore is a store that always returns an error.
+type errorStore struct {
+	ok bool
+}
+
+func (s *errorStore) Take(_ context.Context, _ string) (limiter.Result, error) {
+	return limiter.Result{Allowed: s.ok}, fmt.Errorf("backend unavailable")
+}
+
+func (s *errorStore) Close() error {
+	return nil
+}
+
+func TestMiddleware_StoreError(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		ok   bool
+		code int
+	}{
+		{
+			name: "fail_closed",
+			ok:   false,
+			code: http.StatusInternalServerError,
+		},
+		{
+			name: "fail_open",
+			ok:   true,
+			code: http.StatusOK,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			middleware, err := httplimit.NewMiddleware(&errorStore{ok: tc.ok}, httplimit.IPKeyFunc())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			doWork := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			w := httptest.NewRecorder()
+			middleware.Handle(doWork).ServeHTTP(w, r)
+
+			if got, want := w.Code, tc.code; got != want {
+				t.Errorf("expected %d to be %d", got, want)
+			}
+		})
+	}
+}
+
+func TestMiddleware_ResultFromContext(t *testing.T) {
+	t.Parallel()
+
+	store, err := memorystore.New(&memorystore.Config{
+		Tokens:   3,
+		Interval: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	middleware, err := httplimit.NewMiddleware(store, httplimit.IPKeyFunc())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doWork := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, ok := httplimit.ResultFromContext(r.Context())
+		if !ok {
+			t.Errorf("expected result in context")
+			return
+		}
+		fmt.Fprintf(w, "%d", res.Remaining)
+	})
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		middleware.Handle(doWork).ServeHTTP(w, r)
+
+		if got, want := w.Body.String(), strconv.Itoa(2-i); got != want {
+			t.Errorf("request %d: expected %q to be %q", i, got, want)
+		}
+	}
+
+	if _, ok := httplimit.ResultFromContext(context.Background()); ok {
+		t.Errorf("expected no result in empty context")
+	}
+}
+
+func TestMiddleware_SoftLimit(t *testing.T) {
+	t.Parallel()
+
+	store := limiter.SoftLimit(0.5, nil)(limittest.NewStore(4, time.Minute))
+	middleware, err := httplimit.NewMiddleware(store, httplimit.IPKeyFunc())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i, want := range []string{"", "4;warning", "4;warning", "4;warning", ""} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if got := w.Header().Get(httplimit.HeaderRateLimitPolicy); got != want {
+			t.Errorf("request %d: expected %q to be %q", i, got, want)
+		}
+	}
+}
+
+// routeStore records the route of each take.
+type routeStore struct {
+	limiter.Store
+	routes []string
+}
+
+func (s *routeStore) Take(ctx context.Context, key string) (limiter.Result, error) {
+	route, _ := limiter.RouteFromContext(ctx)
+	s.routes = append(s.routes, route)
+	return s.Store.Take(ctx, key)
+}
+
+func TestMiddleware_Route(t *testing.T) {
+	t.Parallel()
+
+	store := &routeStore{Store: limittest.NewStore(10, time.Minute)}
+	middleware, err := httplimit.NewMiddleware(store, httplimit.IPKeyFunc())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The next handler gets the context the store was called with, with the
+	// result.
+	var served []string
+	h := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, _ := limiter.RouteFromContext(r.Context())
+		if _, ok := httplimit.ResultFromContext(r.Context()); ok {
+			served = append(served, route)
+		}
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+
+	r := httptest.NewRequest(http.MethodGet, "/users/2", nil)
+	r = r.WithContext(limiter.WithRoute(r.Context(), "GET /users/{id}"))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if got, want := fmt.Sprint(store.routes), "[GET /users/1 GET /users/{id}]"; got != want {
+		t.Errorf("expected %s to be %s", got, want)
+	}
+	if got, want := fmt.Sprint(served), fmt.Sprint(store.routes); got != want {
+		t.Errorf("served: expected %s to be %s", got, want)
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	t.Parallel()
+
+	reset := time.Date(2020, time.January, 1, 0, 1, 0, 0, time.UTC)
+
+	cases := []struct {
+		name string
+		res  limiter.Result
+		err  error
+		want map[string]string
+	}{
+		{
+			name: "allowed",
+			res:  limiter.Result{Limit: 5, Remaining: 4, ResetAt: reset, Allowed: true},
+			want: map[string]string{
+				httplimit.HeaderRateLimitLimit:     "5",
+				httplimit.HeaderRateLimitRemaining: "4",
+				httplimit.HeaderRateLimitReset:     "Wed, 01 Jan 2020 00:01:00 UTC",
+			},
+		},
+		{
+			name: "denied",
+			res:  limiter.Result{Limit: 5, ResetAt: reset, RetryAfter: 1500 * time.Millisecond},
+			want: map[string]string{
+				httplimit.HeaderRateLimitLimit:     "5",
+				httplimit.HeaderRateLimitRemaining: "0",
+				httplimit.HeaderRateLimitReset:     "Wed, 01 Jan 2020 00:01:00 UTC",
+				httplimit.HeaderRetryAfter:         "2",
+			},
+		},
+		{
+			name: "failed_open",
+			res:  limiter.Result{Allowed: true},
+			err:  fmt.Errorf("store is down"),
+			want: map[string]string{},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := make(http.Header)
+			httplimit.SetHeaders(h, tc.res, tc.err)
+
+			if got, want := len(h), len(tc.want); got != want {
+				t.Errorf("expected %d to be %d: %v", got, want, h)
+			}
+			for k, want := range tc.want {
+				if got := h.Get(k); got != want {
+					t.Errorf("%s: expected %q to be %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		d    time.Duration
+		want int64
+	}{
+		{d: 0, want: 0},
+		{d: time.Nanosecond, want: 1},
+		{d: time.Second, want: 1},
+		{d: 1500 * time.Millisecond, want: 2},
+		{d: time.Minute, want: 60},
+	}
+
+	for _, tc := range cases {
+		if got, want := httplimit.RetryAfterSeconds(tc.d), tc.want; got != want {
+			t.Errorf("%s: expected %d to be %d", tc.d, got, want)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package httplimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// QueueDiscipline is the order in which queued requests get a token.
+type QueueDiscipline int
+
+const (
+	// QueueFIFO is the default discipline. Requests get a token in the order
+	// they arrived, so every request waits about as long.
+	QueueFIFO QueueDiscipline = iota
+
+	// QueueLIFO serves the latest request first. When the queue is long, the
+	// oldest requests wait until they time out, but the others are served with
+	// little delay, which suits interactive traffic whose users give up on
+	// slow requests anyway.
+	QueueLIFO
+
+	// QueueDeadline serves the request with the earliest deadline first, the
+	// timeout or the deadline of its context, so requests with a short
+	// deadline are not stuck behind those that can wait.
+	QueueDeadline
+)
+
+// QueueConfig is used as input to HandleQueued.
+type QueueConfig struct {
+	// Size is the most requests that wait per key. Requests that arrive when
+	// the queue of their key is full are denied at once. The default value is
+	// 100.
+	Size int
+
+	// Timeout is the longest a request waits for a token, from when it arrives.
+	// Requests that would wait longer are denied. The default value is 10
+	// seconds.
+	Timeout time.Duration
+
+	// Discipline is the order in which queued requests get a token. The
+	// default value is QueueFIFO.
+	Discipline QueueDiscipline
+
+	// TargetLatency, if set, adapts the size of the queues to the latency of
+	// the next handler: each response slower than the target shrinks them by
+	// one request, and each faster one grows them by one, between 1 and Size.
+	// This stops the queues from piling up more work on a handler that is
+	// already overloaded.
+	TargetLatency time.Duration
+}
+
+// HandleQueued returns the HTTP handler as a middleware like Handle, but
+// requests without a token wait for one in a queue of their key, instead of
+// being denied, which turns the bursts of a client into a steady load on the
+// next handler. Only requests whose queue is full, or that would wait longer
+// than the timeout or their context's deadline, are denied with a 429.
+//
+// If the store is a limiter.Peeker, requests that cannot get a token before
+// their deadline are denied as they arrive, rather than after waiting for it,
+// at the cost of a peek for each request that queues behind another. Otherwise
+// requests denied before they took get a 429 without rate limit headers.
+//
+// Requests are queued in memory, so the order is per process, and each waiting
+// request holds its connection and goroutine; keep Size and Timeout small
+// enough for the server to hold every queue at once.
+func (m *Middleware) HandleQueued(c *QueueConfig, next http.Handler) http.Handler {
+	if c == nil {
+		c = new(QueueConfig)
+	}
+
+	q := &queue{
+		size:          100,
+		timeout:       10 * time.Second,
+		discipline:    c.Discipline,
+		targetLatency: c.TargetLatency,
+		keys:          make(map[string]*keyQueue),
+	}
+	if c.Size > 0 {
+		q.size = c.Size
+	}
+	if c.Timeout > 0 {
+		q.timeout = c.Timeout
+	}
+	q.limit = q.size
+	return m.handle(next, nil, q)
+}
+
+// errQueued is returned by queue.take for requests denied before they took,
+// because the queue was full or they waited too long for their turn, if the
+// store cannot report the state of the limit instead.
+var errQueued = errors.New("request was denied by the queue")
+
+// queue holds the requests waiting for a token, per key.
+type queue struct {
+	size          int
+	timeout       time.Duration
+	discipline    QueueDiscipline
+	targetLatency time.Duration
+
+	lock sync.Mutex
+	keys map[string]*keyQueue
+
+	// limit is the size of the queues, which TargetLatency adapts.
+	limit int
+}
+
+// keyQueue holds the requests of a key. The request that holds the turn
+// waits on the store, and the others for the turn, which it passes on to the
+// next request of the discipline when it leaves.
+type keyQueue struct {
+	holder  *waiter
+	waiting []*waiter
+}
+
+// waiter is a request in a keyQueue.
+type waiter struct {
+	turn     chan struct{}
+	deadline time.Time
+}
+
+// take takes a token of the key from the store, after the requests ahead of it
+// and waiting for a token for up to the timeout. Requests that wait too long
+// get the denied result. Requests that were denied before they took, because
+// the queue was full, they could not get a token before their deadline, or
+// they never got a turn, get the state of the key from peekDenied. Other errors
+// are the store's.
+func (q *queue) take(ctx context.Context, s limiter.Store, key string) (limiter.Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+
+	deadline, _ := ctx.Deadline()
+	w := &waiter{turn: make(chan struct{}), deadline: deadline}
+	ahead, ok := q.enter(key, w)
+	if !ok {
+		return peekDenied(s, key)
+	}
+	defer q.leave(key, w)
+
+	// A request with at least as many requests ahead of it as tokens left waits
+	// until the reset, at least, so if its deadline is sooner, it is denied at
+	// once instead of holding a place in the queue for nothing.
+	if ahead > 0 {
+		if res, err := peekDenied(s, key); err == nil && res.Remaining <= uint64(ahead) {
+			if deadline.Before(res.ResetAt) {
+				return res, nil
+			}
+		}
+	}
+
+	select {
+	case <-w.turn:
+	case <-ctx.Done():
+		return peekDenied(s, key)
+	}
+
+	// Wait gives up before the timeout if the key resets after it.
+	res, err := limiter.Wait(ctx, s, key)
+	if err != nil && !res.Allowed && (errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)) {
+		if res.Limit == 0 {
+			// The take itself was interrupted.
+			return peekDenied(s, key)
+		}
+		return res, nil
+	}
+	return res, err
+}
+
+// enter adds a request to the queue of the key, and returns the number of
+// requests ahead of it when it arrives, including the one that holds the turn.
+// The turn of the waiter is closed when it is the request's. It returns false
+// if the queue is full.
+func (q *queue) enter(key string, w *waiter) (int, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	kq, ok := q.keys[key]
+	if !ok {
+		kq = new(keyQueue)
+		q.keys[key] = kq
+	}
+	if kq.holder == nil {
+		kq.holder = w
+		close(w.turn)
+		return 0, true
+	}
+	if len(kq.waiting)+1 >= q.limit {
+		return len(kq.waiting) + 1, false
+	}
+
+	ahead := 1
+	switch q.discipline {
+	case QueueFIFO:
+		ahead += len(kq.waiting)
+	case QueueDeadline:
+		for _, o := range kq.waiting {
+			if !w.deadline.Before(o.deadline) {
+				ahead++
+			}
+		}
+	}
+	kq.waiting = append(kq.waiting, w)
+	return ahead, true
+}
+
+// leave removes a request from the queue of the key, and passes the turn to
+// the next request if the request held it.
+func (q *queue) leave(key string, w *waiter) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	kq := q.keys[key]
+	if kq.holder != w {
+		for i, o := range kq.waiting {
+			if o == w {
+				kq.waiting = append(kq.waiting[:i], kq.waiting[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+
+	if len(kq.waiting) == 0 {
+		delete(q.keys, key)
+		return
+	}
+
+	next := 0
+	switch q.discipline {
+	case QueueLIFO:
+		next = len(kq.waiting) - 1
+	case QueueDeadline:
+		for i, o := range kq.waiting {
+			if o.deadline.Before(kq.waiting[next].deadline) {
+				next = i
+			}
+		}
+	}
+	kq.holder = kq.waiting[next]
+	kq.waiting = append(kq.waiting[:next], kq.waiting[next+1:]...)
+	close(kq.holder.turn)
+}
+
+// served adapts the size of the queues to the latency of the next handler for
+// a request that started at start, if TargetLatency is set.
+func (q *queue) served(start time.Time) {
+	if q.targetLatency <= 0 {
+		return
+	}
+	slow := time.Since(start) > q.targetLatency
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	switch {
+	case slow && q.limit > 1:
+		q.limit--
+	case !slow && q.limit < q.size:
+		q.limit++
+	}
+}
+
+// peekDenied returns the state of the key as a denied result, so the response
+// reports when to retry, or errQueued if the store is not a Peeker. The
+// request's context may be done already, so it peeks with a context of its
+// own.
+func peekDenied(s limiter.Store, key string) (limiter.Result, error) {
+	p, ok := s.(limiter.Peeker)
+	if !ok {
+		return limiter.Result{}, errQueued
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := p.Peek(ctx, key)
+	if err != nil {
+		return limiter.Result{}, errQueued
+	}
+	res.Allowed = false
+	if res.RetryAfter <= 0 {
+		res.RetryAfter = time.Until(res.ResetAt)
+	}
+	return res, nil
+}
@@ -0,0 +1,257 @@
+package httplimit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestMiddleware_HandleQueued(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(tb testing.TB, interval time.Duration, c *httplimit.QueueConfig, next http.Handler) http.Handler {
+		store, err := memorystore.New(&memorystore.Config{
+			Tokens:   1,
+			Interval: interval,
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { store.Close() })
+
+		middleware, err := httplimit.NewMiddleware(store, func(r *http.Request) (string, error) {
+			return "key", nil
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return middleware.HandleQueued(c, next)
+	}
+
+	serve := func(h http.Handler, id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?id="+id, nil))
+		return w
+	}
+
+	t.Run("disciplines", func(t *testing.T) {
+		t.Parallel()
+
+		cases := []struct {
+			name       string
+			discipline httplimit.QueueDiscipline
+			served     []string
+		}{
+			{
+				name:       "fifo",
+				discipline: httplimit.QueueFIFO,
+				served:     []string{"a", "b", "c", "d", "e"},
+			},
+			{
+				name:       "lifo",
+				discipline: httplimit.QueueLIFO,
+				served:     []string{"a", "b", "e", "d", "c"},
+			},
+			{
+				name:       "deadline",
+				discipline: httplimit.QueueDeadline,
+				served:     []string{"a", "b", "d", "e", "c"},
+			},
+		}
+
+		// The requests arrive in order, with these deadlines.
+		deadlines := map[string]time.Duration{"c": 5 * time.Second, "d": 3 * time.Second, "e": 4 * time.Second}
+
+		for _, tc := range cases {
+			tc := tc
+
+			t.Run(tc.name, func(t *testing.T) {
+				t.Parallel()
+
+				var lock sync.Mutex
+				var served []string
+				h := newHandler(t, 100*time.Millisecond, &httplimit.QueueConfig{Timeout: 10 * time.Second, Discipline: tc.discipline},
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						lock.Lock()
+						defer lock.Unlock()
+						served = append(served, r.URL.Query().Get("id"))
+					}))
+
+				// a takes the token, and b waits on the store for the next, while
+				// the others queue behind it.
+				var wg sync.WaitGroup
+				for _, id := range []string{"a", "b", "c", "d", "e"} {
+					id := id
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+
+						ctx := context.Background()
+						if d, ok := deadlines[id]; ok {
+							var cancel func()
+							ctx, cancel = context.WithTimeout(ctx, d)
+							defer cancel()
+						}
+						w := httptest.NewRecorder()
+						h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?id="+id, nil).WithContext(ctx))
+						if got, want := w.Code, http.StatusOK; got != want {
+							t.Errorf("expected %d to be %d", got, want)
+						}
+					}()
+					time.Sleep(10 * time.Millisecond)
+				}
+				wg.Wait()
+
+				lock.Lock()
+				defer lock.Unlock()
+				if got, want := served, tc.served; !reflect.DeepEqual(got, want) {
+					t.Errorf("expected %q to be %q", got, want)
+				}
+			})
+		}
+	})
+
+	t.Run("target_latency", func(t *testing.T) {
+		t.Parallel()
+
+		store, err := memorystore.New(&memorystore.Config{
+			Tokens:   3,
+			Interval: 500 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		middleware, err := httplimit.NewMiddleware(store, func(r *http.Request) (string, error) {
+			return "key", nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := middleware.HandleQueued(&httplimit.QueueConfig{Size: 3, Timeout: 5 * time.Second, TargetLatency: time.Millisecond},
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(5 * time.Millisecond)
+			}))
+
+		// The slow responses shrink the queue to the request that waits on the
+		// store.
+		for i := 0; i < 3; i++ {
+			if got, want := serve(h, "a").Code, http.StatusOK; got != want {
+				t.Fatalf("expected %d to be %d", got, want)
+			}
+		}
+
+		done := make(chan int)
+		go func() {
+			done <- serve(h, "b").Code
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		if got, want := serve(h, "c").Code, http.StatusTooManyRequests; got != want {
+			t.Errorf("expected %d to be %d", got, want)
+		}
+		if got, want := <-done, http.StatusOK; got != want {
+			t.Errorf("expected %d to be %d", got, want)
+		}
+	})
+
+	t.Run("full", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHandler(t, 300*time.Millisecond, &httplimit.QueueConfig{Size: 1, Timeout: 5 * time.Second},
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		if got, want := serve(h, "a").Code, http.StatusOK; got != want {
+			t.Fatalf("expected %d to be %d", got, want)
+		}
+
+		done := make(chan int)
+		go func() {
+			done <- serve(h, "b").Code
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		// The queue holds b, so c is denied at once.
+		w := serve(h, "c")
+		if got, want := w.Code, http.StatusTooManyRequests; got != want {
+			t.Errorf("expected %d to be %d", got, want)
+		}
+		if w.Header().Get(httplimit.HeaderRetryAfter) == "" {
+			t.Errorf("expected %s header", httplimit.HeaderRetryAfter)
+		}
+
+		if got, want := <-done, http.StatusOK; got != want {
+			t.Errorf("expected %d to be %d", got, want)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHandler(t, 300*time.Millisecond, &httplimit.QueueConfig{Timeout: 5 * time.Second},
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		if got, want := serve(h, "a").Code, http.StatusOK; got != want {
+			t.Fatalf("expected %d to be %d", got, want)
+		}
+
+		done := make(chan int)
+		go func() {
+			done <- serve(h, "b").Code
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		// c is behind b, so it cannot get a token before the reset, which is
+		// after its deadline.
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?id=c", nil).WithContext(ctx))
+		if got, want := w.Code, http.StatusTooManyRequests; got != want {
+			t.Errorf("expected %d to be %d", got, want)
+		}
+		if d := time.Since(start); d > 50*time.Millisecond {
+			t.Errorf("expected denial without waiting, took %s", d)
+		}
+		if w.Header().Get(httplimit.HeaderRetryAfter) == "" {
+			t.Errorf("expected %s header", httplimit.HeaderRetryAfter)
+		}
+
+		if got, want := <-done, http.StatusOK; got != want {
+			t.Errorf("expected %d to be %d", got, want)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHandler(t, time.Hour, &httplimit.QueueConfig{Timeout: 50 * time.Millisecond},
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		if got, want := serve(h, "a").Code, http.StatusOK; got != want {
+			t.Fatalf("expected %d to be %d", got, want)
+		}
+
+		// The key resets after the timeout, so b is denied without waiting.
+		start := time.Now()
+		w := serve(h, "b")
+		if got, want := w.Code, http.StatusTooManyRequests; got != want {
+			t.Errorf("expected %d to be %d", got, want)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("expected denial without waiting, took %s", d)
+		}
+		if w.Header().Get(httplimit.HeaderRetryAfter) == "" {
+			t.Errorf("expected %s header", httplimit.HeaderRetryAfter)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package httplimit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// Quota is the body of a QuotaHandler response.
+type Quota struct {
+	// Unlimited is set if the client's requests are not limited, in which case
+	// the other fields are empty.
+	Unlimited bool `json:"unlimited,omitempty"`
+
+	// Limit is the number of tokens per interval, and Remaining the number of
+	// tokens left in the current one.
+	Limit     uint64 `json:"limit"`
+	Remaining uint64 `json:"remaining"`
+
+	// Reset is when the tokens are next refilled.
+	Reset time.Time `json:"reset"`
+
+	// RetryAfter is the number of seconds until the next request is allowed, if
+	// there are no tokens left.
+	RetryAfter int64 `json:"retry_after,omitempty"`
+
+	// Warning is set if the client is over the store's soft limit; see
+	// limiter.SoftLimit.
+	Warning bool `json:"warning,omitempty"`
+}
+
+// QuotaHandler returns a handler that reports the caller's remaining quota as
+// JSON, with the same rate limit headers Handle sets, without taking any
+// tokens. Mount it on a route that is not limited by the middleware, so
+// clients can check their quota instead of probing for a 429.
+//
+// The caller is keyed with the middleware's KeyFunc. If it returns ErrNoKey,
+// the handler responds with 401, so it should be used with KeyFuncs that
+// identify clients, like BearerKeyFunc. The store must implement
+// limiter.Peeker, otherwise the handler responds with 501.
+func (m *Middleware) QuotaHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := m.keyFunc(r)
+		if errors.Is(err, ErrNoKey) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		store, err := m.storeFor(key, r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if store == nil {
+			writeQuota(w, &Quota{Unlimited: true})
+			return
+		}
+
+		p, ok := store.(limiter.Peeker)
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+			return
+		}
+
+		res, err := p.Peek(r.Context(), key)
+		if errors.Is(err, limiter.ErrNotSupported) {
+			http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		SetHeaders(w.Header(), res, nil)
+
+		q := &Quota{
+			Limit:     res.Limit,
+			Remaining: res.Remaining,
+			Reset:     res.ResetAt.UTC(),
+			Warning:   res.Warning,
+		}
+		if res.Warning {
+			w.Header().Set(HeaderRateLimitPolicy, policyWarning(res.Limit))
+		}
+		if !res.Allowed {
+			q.RetryAfter = RetryAfterSeconds(res.RetryAfter)
+		}
+		writeQuota(w, q)
+	})
+}
+
+// writeQuota writes the quota as the JSON response. Quotas change with every
+// request, so they must not be cached.
+func writeQuota(w http.ResponseWriter, q *Quota) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(q)
+}
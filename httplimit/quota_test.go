@@ -0,0 +1,95 @@
+package httplimit_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/limittest"
+)
+
+func TestMiddleware_QuotaHandler(t *testing.T) {
+	t.Parallel()
+
+	store := limittest.NewStore(2, time.Minute)
+	middleware, err := httplimit.NewMiddleware(store, httplimit.BearerKeyFunc())
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	quota := middleware.QuotaHandler()
+
+	request := func(token string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return r
+	}
+
+	check := func(name string, want httplimit.Quota) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		quota.ServeHTTP(w, request("secret"))
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Fatalf("%s: expected %d to be %d", name, got, want)
+		}
+		if got, want := w.Header().Get("Cache-Control"), "no-store"; got != want {
+			t.Errorf("%s: cache control: expected %q to be %q", name, got, want)
+		}
+
+		var got httplimit.Quota
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if !got.Reset.Equal(want.Reset) {
+			t.Errorf("%s: reset: expected %s to be %s", name, got.Reset, want.Reset)
+		}
+		got.Reset, want.Reset = time.Time{}, time.Time{}
+		if got != want {
+			t.Errorf("%s: expected %#v to be %#v", name, got, want)
+		}
+	}
+
+	reset := store.Now().Add(time.Minute)
+	check("unused", httplimit.Quota{Limit: 2, Remaining: 2, Reset: reset})
+
+	// Checking the quota does not take from it.
+	limited.ServeHTTP(httptest.NewRecorder(), request("secret"))
+	check("after take", httplimit.Quota{Limit: 2, Remaining: 1, Reset: reset})
+	check("again", httplimit.Quota{Limit: 2, Remaining: 1, Reset: reset})
+
+	limited.ServeHTTP(httptest.NewRecorder(), request("secret"))
+	check("exhausted", httplimit.Quota{Limit: 2, Reset: reset, RetryAfter: 60})
+
+	if got, want := store.Takes("bearer:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"), uint64(2); got != want {
+		t.Errorf("takes: expected %d to be %d", got, want)
+	}
+
+	w := httptest.NewRecorder()
+	quota.ServeHTTP(w, request(""))
+	if got, want := w.Code, http.StatusUnauthorized; got != want {
+		t.Errorf("no key: expected %d to be %d", got, want)
+	}
+}
+
+func TestMiddleware_QuotaHandler_NotPeeker(t *testing.T) {
+	t.Parallel()
+
+	store := struct{ limiter.Store }{limittest.NewStore(1, time.Minute)}
+	middleware, err := httplimit.NewMiddleware(store, httplimit.IPKeyFunc())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	middleware.QuotaHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got, want := w.Code, http.StatusNotImplemented; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
@@ -0,0 +1,71 @@
+package httplimit
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RegionFunc returns the region a request comes from, like an ISO 3166 country
+// code. It returns an empty region if it cannot tell.
+type RegionFunc func(r *http.Request) string
+
+// HeaderRegionFunc returns a function that reads the region from a header set
+// by a CDN or load balancer, like "CF-IPCountry" or
+// "CloudFront-Viewer-Country". Only trust headers that clients cannot set.
+func HeaderRegionFunc(header string) RegionFunc {
+	return func(r *http.Request) string {
+		return strings.TrimSpace(r.Header.Get(header))
+	}
+}
+
+// GeoIPRegionFunc returns a function that looks up the region of the request's
+// IP address with lookup, which is usually backed by a GeoIP database. The IP
+// address is found like IPKeyFunc does, from the first of the headers that is
+// set, or the RemoteAddr. If lookup fails, the region is empty.
+func GeoIPRegionFunc(lookup func(ip net.IP) (string, error), headers ...string) RegionFunc {
+	ipKeyFunc := IPKeyFunc(headers...)
+	return func(r *http.Request) string {
+		addr, err := ipKeyFunc(r)
+		if err != nil {
+			return ""
+		}
+		ip := net.ParseIP(strings.TrimSpace(strings.Split(addr, ",")[0]))
+		if ip == nil {
+			return ""
+		}
+		region, err := lookup(ip)
+		if err != nil {
+			return ""
+		}
+		return region
+	}
+}
+
+// Limit is a number of tokens per interval.
+type Limit struct {
+	Tokens   uint64
+	Interval time.Duration
+}
+
+// RegionLimitFunc returns a LimitFunc that limits requests from each region in
+// limits with its limit, and other requests, including those of an unknown
+// region, with base. Regions are compared case-insensitively. Use it with
+// NewTieredMiddleware to have different limits for different markets in one
+// middleware.
+func RegionLimitFunc(f RegionFunc, limits map[string]Limit, base LimitFunc) LimitFunc {
+	byRegion := make(map[string]Limit, len(limits))
+	for region, l := range limits {
+		byRegion[strings.ToUpper(region)] = l
+	}
+
+	return func(key string, r *http.Request) (uint64, time.Duration) {
+		if region := f(r); region != "" {
+			if l, ok := byRegion[strings.ToUpper(region)]; ok {
+				return l.Tokens, l.Interval
+			}
+		}
+		return base(key, r)
+	}
+}
@@ -0,0 +1,97 @@
+package httplimit_test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/httplimit"
+)
+
+func TestRegionLimitFunc(t *testing.T) {
+	t.Parallel()
+
+	lookup := func(ip net.IP) (string, error) {
+		if ip.Equal(net.ParseIP("192.0.2.1")) {
+			return "de", nil
+		}
+		return "", fmt.Errorf("not found")
+	}
+
+	base := func(string, *http.Request) (uint64, time.Duration) {
+		return 10, time.Minute
+	}
+	limits := map[string]httplimit.Limit{
+		"DE": {Tokens: 20, Interval: time.Minute},
+		"us": {Tokens: 30, Interval: time.Second},
+	}
+
+	cases := []struct {
+		name   string
+		f      httplimit.RegionFunc
+		header map[string]string
+		remote string
+		want   uint64
+	}{
+		{
+			name:   "header",
+			f:      httplimit.HeaderRegionFunc("CF-IPCountry"),
+			header: map[string]string{"CF-IPCountry": "US"},
+			want:   30,
+		},
+		{
+			name: "header_missing",
+			f:    httplimit.HeaderRegionFunc("CF-IPCountry"),
+			want: 10,
+		},
+		{
+			name:   "header_unknown",
+			f:      httplimit.HeaderRegionFunc("CF-IPCountry"),
+			header: map[string]string{"CF-IPCountry": "FR"},
+			want:   10,
+		},
+		{
+			name:   "geoip",
+			f:      httplimit.GeoIPRegionFunc(lookup),
+			remote: "192.0.2.1:1234",
+			want:   20,
+		},
+		{
+			name:   "geoip_forwarded",
+			f:      httplimit.GeoIPRegionFunc(lookup, "X-Forwarded-For"),
+			header: map[string]string{"X-Forwarded-For": "192.0.2.1, 198.51.100.1"},
+			remote: "198.51.100.1:1234",
+			want:   20,
+		},
+		{
+			name:   "geoip_not_found",
+			f:      httplimit.GeoIPRegionFunc(lookup),
+			remote: "198.51.100.1:1234",
+			want:   10,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tc.header {
+				r.Header.Set(k, v)
+			}
+			if tc.remote != "" {
+				r.RemoteAddr = tc.remote
+			}
+
+			tokens, _ := httplimit.RegionLimitFunc(tc.f, limits, base)("key", r)
+			if got, want := tokens, tc.want; got != want {
+				t.Errorf("expected %d to be %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package httplimit
+
+import (
+	"math"
+	"net/http"
+	"time"
+)
+
+// ScoreFunc returns the factor an external abuse or fraud system scales the
+// limit of a request by, like 0.1 for a suspected bot, 1 for the usual limit,
+// or 2 for a trusted partner. If the system cannot score a request, it should
+// return 1.
+//
+// ScoreFuncs are called on each request, so like KeyFuncs they should be fast,
+// for example by reading a score a WAF set in a header, or by caching the
+// scores of a remote service.
+type ScoreFunc func(key string, r *http.Request) float64
+
+// ScoredLimitFunc returns a LimitFunc that scales the tokens of the limit
+// resolved by base with the factor f returns, so a WAF or fraud system can
+// tighten or loosen the limits of clients without enforcing limits itself. The
+// result is rounded down, but is at least 1 token, so a small factor never
+// makes requests unlimited. Factors that are not positive numbers leave the
+// limit unchanged, as does a base limit of 0 tokens, which means unlimited.
+//
+// NewTieredMiddleware creates a store for each distinct limit, so f should
+// return a few distinct factors, like 0.1, 0.5, and 1, rather than a
+// continuous score.
+func ScoredLimitFunc(f ScoreFunc, base LimitFunc) LimitFunc {
+	return func(key string, r *http.Request) (uint64, time.Duration) {
+		tokens, interval := base(key, r)
+		if tokens == 0 {
+			return 0, interval
+		}
+
+		factor := f(key, r)
+		if !(factor > 0) || math.IsInf(factor, 1) {
+			return tokens, interval
+		}
+		scaled := math.Max(math.Floor(float64(tokens)*factor), 1)
+		if scaled >= math.MaxUint64 {
+			return math.MaxUint64, interval
+		}
+		return uint64(scaled), interval
+	}
+}
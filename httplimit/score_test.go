@@ -0,0 +1,97 @@
+package httplimit_test
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/httplimit"
+)
+
+func TestScoredLimitFunc(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		tokens uint64
+		factor float64
+		want   uint64
+	}{
+		{
+			name:   "bot",
+			tokens: 100,
+			factor: 0.1,
+			want:   10,
+		},
+		{
+			name:   "trusted",
+			tokens: 100,
+			factor: 2,
+			want:   200,
+		},
+		{
+			name:   "at_least_one",
+			tokens: 100,
+			factor: 0.001,
+			want:   1,
+		},
+		{
+			name:   "overflow",
+			tokens: math.MaxUint64,
+			factor: 2,
+			want:   math.MaxUint64,
+		},
+		{
+			name:   "unlimited",
+			tokens: 0,
+			factor: 0.1,
+			want:   0,
+		},
+		{
+			name:   "zero",
+			tokens: 100,
+			factor: 0,
+			want:   100,
+		},
+		{
+			name:   "nan",
+			tokens: 100,
+			factor: math.NaN(),
+			want:   100,
+		},
+		{
+			name:   "inf",
+			tokens: 100,
+			factor: math.Inf(1),
+			want:   100,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			base := func(string, *http.Request) (uint64, time.Duration) {
+				return tc.tokens, time.Minute
+			}
+			score := func(key string, r *http.Request) float64 {
+				if key != "key" {
+					t.Errorf("expected %q to be %q", key, "key")
+				}
+				return tc.factor
+			}
+
+			tokens, interval := httplimit.ScoredLimitFunc(score, base)("key", httptest.NewRequest(http.MethodGet, "/", nil))
+			if got, want := tokens, tc.want; got != want {
+				t.Errorf("expected %d to be %d", got, want)
+			}
+			if got, want := interval, time.Minute; got != want {
+				t.Errorf("expected %s to be %s", got, want)
+			}
+		})
+	}
+}
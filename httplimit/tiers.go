@@ -0,0 +1,190 @@
+package httplimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// LimitFunc resolves the limit that applies to a request, for example from the
+// plan of the authenticated user, after the KeyFunc has keyed it. If it
+// returns 0 tokens, the request is not limited.
+//
+// LimitFuncs are called on each request, so like KeyFuncs they should be fast.
+type LimitFunc func(key string, r *http.Request) (tokens uint64, interval time.Duration)
+
+// StoreFunc creates the store that enforces a limit. It is called once for
+// each distinct limit returned by a LimitFunc, unless the store it returns
+// implements limiter.Overrider, like the Redis store: then that one store
+// enforces every limit with TakeWith, and StoreFunc is not called again.
+// Otherwise, stores for different limits must not share keys; with a shared
+// backend, give each its own key prefix.
+type StoreFunc func(tokens uint64, interval time.Duration) (limiter.Store, error)
+
+// limit is a limit returned by a LimitFunc.
+type limit struct {
+	tokens   uint64
+	interval time.Duration
+}
+
+// NewTieredMiddleware creates a middleware that limits each request with the
+// limit resolved by l, using a store created by s for that limit. This allows
+// different limits per user, like free and paid plans, in one middleware.
+// With a store that implements limiter.Overrider, a key whose limit changes,
+// like a user who upgrades, keeps its bucket. Close the middleware to close
+// the stores it created.
+func NewTieredMiddleware(f KeyFunc, l LimitFunc, s StoreFunc) (*Middleware, error) {
+	if f == nil {
+		return nil, fmt.Errorf("key function cannot be nil")
+	}
+
+	if l == nil {
+		return nil, fmt.Errorf("limit function cannot be nil")
+	}
+
+	if s == nil {
+		return nil, fmt.Errorf("store function cannot be nil")
+	}
+
+	return &Middleware{
+		keyFunc:   f,
+		limitFunc: l,
+		storeFunc: s,
+		stores:    make(map[limit]limiter.Store),
+	}, nil
+}
+
+// storeFor returns the store that limits the request, or nil if the request is
+// not limited.
+func (m *Middleware) storeFor(key string, r *http.Request) (limiter.Store, error) {
+	if m.limitFunc == nil {
+		return m.store, nil
+	}
+
+	tokens, interval := m.limitFunc(key, r)
+	if tokens == 0 {
+		return nil, nil
+	}
+	l := limit{tokens: tokens, interval: interval}
+
+	m.lock.RLock()
+	s, ok := m.stores[l]
+	shared := m.overrider
+	m.lock.RUnlock()
+	if ok {
+		return s, nil
+	}
+
+	if shared == nil {
+		// Create the store without the lock, since it may dial a backend, so
+		// requests for other limits are not blocked. If another request
+		// created one for the limit meanwhile, use that one and close this
+		// one.
+		s, err := m.storeFunc(tokens, interval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create store for %d tokens per %s: %w", tokens, interval, err)
+		}
+		if _, ok := s.(limiter.Overrider); !ok {
+			return m.addStore(l, s), nil
+		}
+
+		m.lock.Lock()
+		if m.overrider == nil {
+			m.overrider = s
+		}
+		shared = m.overrider
+		m.lock.Unlock()
+		if shared != s {
+			s.Close()
+		}
+	}
+	return m.addStore(l, newOverrideStore(shared, tokens, interval)), nil
+}
+
+// addStore adds the store for the limit, and returns it, or the store another
+// request added for the limit meanwhile, in which case s is closed.
+func (m *Middleware) addStore(l limit, s limiter.Store) limiter.Store {
+	m.lock.Lock()
+	existing, ok := m.stores[l]
+	if !ok {
+		m.stores[l] = s
+	}
+	m.lock.Unlock()
+
+	if ok {
+		// The duplicate was never used, so a failure to close it does not
+		// affect the request.
+		s.Close()
+		return existing
+	}
+	return s
+}
+
+// overrideStore takes from a store that implements limiter.Overrider with a
+// limit of its own, for one limit of a tiered middleware. The other
+// capabilities are those of the shared store, except that Peek reports the
+// limit.
+type overrideStore struct {
+	limiter.Decorated
+
+	tokens   uint64
+	interval time.Duration
+}
+
+func newOverrideStore(s limiter.Store, tokens uint64, interval time.Duration) limiter.Store {
+	return limiter.Preserve(s, &overrideStore{
+		Decorated: limiter.Decorated{Store: s},
+		tokens:    tokens,
+		interval:  interval,
+	})
+}
+
+// Take takes from the key with the store's limit.
+func (s *overrideStore) Take(ctx context.Context, key string) (limiter.Result, error) {
+	return s.Store.(limiter.Overrider).TakeWith(ctx, key, s.tokens, s.interval)
+}
+
+// Peek reports the store's limit, and no more remaining tokens than it allows.
+// A key that was never taken from reports the shared store's full bucket, so
+// it may report fewer tokens than a limit above the shared store's.
+func (s *overrideStore) Peek(ctx context.Context, key string) (limiter.Result, error) {
+	res, err := s.Decorated.Peek(ctx, key)
+	if err != nil {
+		return res, err
+	}
+	res.Limit = s.tokens
+	if res.Remaining > s.tokens {
+		res.Remaining = s.tokens
+	}
+	return res, nil
+}
+
+// Close does nothing, since the shared store is closed with the middleware.
+func (s *overrideStore) Close() error {
+	return nil
+}
+
+// Close closes the stores created by NewTieredMiddleware. The store given to
+// NewMiddleware is not closed, since the caller created it.
+func (m *Middleware) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var firstErr error
+	for l, s := range m.stores {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(m.stores, l)
+	}
+	if m.overrider != nil {
+		if err := m.overrider.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		m.overrider = nil
+	}
+	return firstErr
+}
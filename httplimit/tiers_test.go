@@ -0,0 +1,208 @@
+package httplimit_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/limittest"
+)
+
+func TestNewTieredMiddleware(t *testing.T) {
+	t.Parallel()
+
+	// takeOnly hides the limiter.Overrider of the store it wraps.
+	type takeOnly struct{ limiter.Store }
+
+	plans := map[string]uint64{
+		"free":       2,
+		"pro":        5,
+		"enterprise": 0,
+	}
+
+	cases := []struct {
+		name     string
+		override bool
+		created  int
+	}{
+		{
+			name:    "store_per_limit",
+			created: 2,
+		},
+		{
+			name:     "override",
+			override: true,
+			created:  1,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var created []uint64
+			middleware, err := httplimit.NewTieredMiddleware(
+				httplimit.IPKeyFunc("X-User"),
+				func(key string, r *http.Request) (uint64, time.Duration) {
+					return plans[r.Header.Get("X-Plan")], time.Minute
+				},
+				func(tokens uint64, interval time.Duration) (limiter.Store, error) {
+					created = append(created, tokens)
+					s := limittest.NewStore(tokens, interval)
+					if !tc.override {
+						return takeOnly{s}, nil
+					}
+					return s, nil
+				},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer middleware.Close()
+
+			h := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			for _, c := range []struct {
+				user string
+				plan string
+				n    int
+			}{
+				{user: "alice", plan: "free", n: 2},
+				{user: "bob", plan: "pro", n: 5},
+				{user: "carol", plan: "free", n: 2},
+			} {
+				c := c
+				limittest.AssertLimitedFunc(t, h, c.n, func() *http.Request {
+					r := httptest.NewRequest(http.MethodGet, "/", nil)
+					r.Header.Set("X-User", c.user)
+					r.Header.Set("X-Plan", c.plan)
+					return r
+				})
+
+				if !tc.override {
+					continue
+				}
+
+				// The quota reports the user's own limit from the shared
+				// store.
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.Header.Set("X-User", c.user)
+				r.Header.Set("X-Plan", c.plan)
+				w := httptest.NewRecorder()
+				middleware.QuotaHandler().ServeHTTP(w, r)
+				var q httplimit.Quota
+				if err := json.NewDecoder(w.Body).Decode(&q); err != nil {
+					t.Fatal(err)
+				}
+				if got, want := q.Limit, plans[c.plan]; got != want {
+					t.Errorf("%s: expected %d to be %d", c.user, got, want)
+				}
+			}
+
+			// Enterprise requests are not limited.
+			for i := 0; i < 10; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.Header.Set("X-User", "dave")
+				r.Header.Set("X-Plan", "enterprise")
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if got, want := w.Code, http.StatusOK; got != want {
+					t.Fatalf("enterprise: expected %d to be %d", got, want)
+				}
+			}
+
+			if got, want := len(created), tc.created; got != want {
+				t.Errorf("expected %d stores to be created, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestNewTieredMiddleware_StoreError(t *testing.T) {
+	t.Parallel()
+
+	middleware, err := httplimit.NewTieredMiddleware(
+		httplimit.IPKeyFunc(),
+		func(key string, r *http.Request) (uint64, time.Duration) {
+			return 1, time.Minute
+		},
+		func(tokens uint64, interval time.Duration) (limiter.Store, error) {
+			return nil, errors.New("no backend")
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	middleware.Handle(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got, want := w.Code, http.StatusInternalServerError; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	if _, err := httplimit.NewTieredMiddleware(httplimit.IPKeyFunc(), nil, nil); err == nil {
+		t.Errorf("expected error for missing limit function")
+	}
+}
+
+func TestNewTieredMiddleware_concurrent(t *testing.T) {
+	t.Parallel()
+
+	var lock sync.Mutex
+	var stores []*limittest.Store
+	middleware, err := httplimit.NewTieredMiddleware(
+		httplimit.IPKeyFunc(),
+		func(key string, r *http.Request) (uint64, time.Duration) {
+			return 100, time.Minute
+		},
+		func(tokens uint64, interval time.Duration) (limiter.Store, error) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			s := limittest.NewStore(tokens, interval)
+			stores = append(stores, s)
+			return s, nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer middleware.Close()
+
+	h := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+	wg.Wait()
+
+	// Every request took from the one store that was kept, and the stores
+	// created concurrently with it were closed.
+	var takes, open int
+	for _, s := range stores {
+		if _, err := s.Peek(context.Background(), "x"); err == nil {
+			open++
+		}
+		takes += int(s.Takes("192.0.2.1"))
+	}
+	if got, want := open, 1; got != want {
+		t.Errorf("open stores: expected %d to be %d", got, want)
+	}
+	if got, want := takes, 10; got != want {
+		t.Errorf("takes: expected %d to be %d", got, want)
+	}
+}
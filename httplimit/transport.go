@@ -0,0 +1,302 @@
+package httplimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// TransportConfig is used as input to NewTransport.
+type TransportConfig struct {
+	// Base sends the requests. The default value is http.DefaultTransport.
+	Base http.RoundTripper
+
+	// KeyFunc returns the key an outgoing request is paced by. The default is
+	// the host of the request's URL, so each upstream is paced separately.
+	KeyFunc func(r *http.Request) string
+
+	// MinBackoff, if set, pauses keys whose upstream throttled a request
+	// without saying for how long, with a 429 or 503 without a Retry-After or
+	// RateLimit reset. The first such response pauses the key for MinBackoff,
+	// and each consecutive one for twice as long as the last, up to
+	// MaxBackoff, until upstream serves a request again.
+	MinBackoff time.Duration
+
+	// MaxBackoff is the longest pause of MinBackoff. The default value is 5
+	// minutes.
+	MaxBackoff time.Duration
+}
+
+// Transport is an http.RoundTripper for clients of rate limited APIs. Each
+// request waits for a token of its key before it is sent, and when upstream
+// responds that the client is over its limit, the key is paused until
+// upstream says to retry, so the client adjusts to the provider's real limits
+// instead of the configured ones.
+type Transport struct {
+	store      limiter.Store
+	base       http.RoundTripper
+	keyFunc    func(r *http.Request) string
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// lock guards the pauses and the backoffs, which are the last pause of
+	// each key that upstream throttled without a delay.
+	lock     sync.Mutex
+	paused   map[string]time.Time
+	backoffs map[string]time.Duration
+}
+
+// NewTransport creates a transport that paces requests with s.
+func NewTransport(s limiter.Store, c *TransportConfig) (*Transport, error) {
+	if s == nil {
+		return nil, fmt.Errorf("store cannot be nil")
+	}
+	if c == nil {
+		c = new(TransportConfig)
+	}
+
+	base := http.DefaultTransport
+	if c.Base != nil {
+		base = c.Base
+	}
+
+	keyFunc := func(r *http.Request) string {
+		return r.URL.Host
+	}
+	if c.KeyFunc != nil {
+		keyFunc = c.KeyFunc
+	}
+
+	maxBackoff := 5 * time.Minute
+	if c.MaxBackoff > 0 {
+		maxBackoff = c.MaxBackoff
+	}
+
+	return &Transport{
+		store:      s,
+		base:       base,
+		keyFunc:    keyFunc,
+		minBackoff: c.MinBackoff,
+		maxBackoff: maxBackoff,
+		paused:     make(map[string]time.Time),
+		backoffs:   make(map[string]time.Duration),
+	}, nil
+}
+
+// RoundTrip waits until the request's key is not paused and has a token, and
+// sends the request. If the response is a 429 or 503 with a Retry-After or
+// RateLimit reset, or any response reports that no requests remain, the key
+// is paused until the reset, and its tokens are taken from the store, if it
+// implements limiter.Charger, so other clients sharing the store slow down
+// too. With MinBackoff, throttling responses without a delay pause the key
+// for its next backoff, without taking its tokens. The response is returned
+// as is; the request is not retried.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	key := t.keyFunc(r)
+
+	if err := t.waitPaused(ctx, key); err != nil {
+		return nil, err
+	}
+	res, err := limiter.Wait(ctx, t.store, key)
+	if err != nil && !res.Allowed {
+		return nil, fmt.Errorf("failed to take: %w", err)
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := upstreamDelay(resp, time.Now())
+	if t.minBackoff > 0 {
+		// Backoffs only pause this client, since upstream did not say how
+		// long its limit lasts.
+		if b := t.backoff(key, resp.StatusCode, d); d == 0 && b > 0 {
+			t.pause(key, b)
+		}
+	}
+	if d > 0 {
+		t.pause(key, d)
+		if charger, ok := t.store.(limiter.Charger); ok && res.Limit > 0 {
+			_ = charger.Charge(ctx, key, res.Limit)
+		}
+	}
+	return resp, nil
+}
+
+// Paused returns the time until which the key is paused, or the zero time if
+// it is not.
+func (t *Transport) Paused(key string) time.Time {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	until, ok := t.paused[key]
+	if !ok || !time.Now().Before(until) {
+		return time.Time{}
+	}
+	return until
+}
+
+// pause pauses the key for d, unless it is paused for longer already.
+func (t *Transport) pause(key string, d time.Duration) {
+	until := time.Now().Add(d)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if until.After(t.paused[key]) {
+		t.paused[key] = until
+	}
+}
+
+// backoff returns the backoff of the key after a response with the status,
+// given the delay upstream asked for. Throttling responses without a delay get
+// the next backoff of the key, and other responses reset it.
+func (t *Transport) backoff(key string, status int, d time.Duration) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if status != http.StatusTooManyRequests && status != http.StatusServiceUnavailable {
+		delete(t.backoffs, key)
+		return d
+	}
+	if d > 0 {
+		return d
+	}
+
+	b := t.minBackoff
+	if last, ok := t.backoffs[key]; ok {
+		b = 2 * last
+	}
+	if b > t.maxBackoff {
+		b = t.maxBackoff
+	}
+	t.backoffs[key] = b
+	return b
+}
+
+// waitPaused waits until the key is not paused, or the context is done.
+func (t *Transport) waitPaused(ctx context.Context, key string) error {
+	for {
+		t.lock.Lock()
+		until, ok := t.paused[key]
+		now := time.Now()
+		if ok && !now.Before(until) {
+			delete(t.paused, key)
+			ok = false
+		}
+		t.lock.Unlock()
+		if !ok {
+			return nil
+		}
+
+		d := until.Sub(now)
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(until) {
+			return context.DeadlineExceeded
+		}
+
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// upstreamDelay returns how long upstream asked the client to wait, or 0 if it
+// did not. Throttling responses are read from Retry-After, then the reset of
+// the RateLimit headers; other responses only pause the client if the
+// RateLimit headers report no requests remaining.
+func upstreamDelay(resp *http.Response, now time.Time) time.Duration {
+	h := resp.Header
+
+	throttled := resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusServiceUnavailable
+	if throttled {
+		if d, ok := parseDelay(h.Get(HeaderRetryAfter), now); ok {
+			return d
+		}
+	}
+
+	// The RateLimit header of the IETF draft, like `"default";r=0;t=30`, and
+	// the separate headers of its earlier versions and of this package.
+	remaining, reset := parseRateLimit(h.Get("RateLimit"))
+	if remaining == "" {
+		remaining = firstHeader(h, "RateLimit-Remaining", HeaderRateLimitRemaining)
+	}
+	if reset == "" {
+		reset = firstHeader(h, "RateLimit-Reset", HeaderRateLimitReset)
+	}
+
+	if !throttled && strings.TrimSpace(remaining) != "0" {
+		return 0
+	}
+	if d, ok := parseDelay(reset, now); ok {
+		return d
+	}
+	return 0
+}
+
+// parseRateLimit returns the remaining and reset parameters of the first item
+// of a structured RateLimit header.
+func parseRateLimit(v string) (remaining, reset string) {
+	if i := strings.IndexByte(v, ','); i >= 0 {
+		v = v[:i]
+	}
+	for _, param := range strings.Split(v, ";") {
+		param = strings.TrimSpace(param)
+		switch {
+		case strings.HasPrefix(param, "r="):
+			remaining = param[2:]
+		case strings.HasPrefix(param, "t="):
+			reset = param[2:]
+		}
+	}
+	return remaining, reset
+}
+
+// parseDelay parses a delay in seconds, a unix timestamp in seconds, or an
+// HTTP date, like the reset headers of different APIs use, as the time from
+// now. Dates in the past are a delay of 0, which is not ok.
+func parseDelay(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+
+	var until time.Time
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		// Values too large to be a delay are timestamps.
+		if n < 1e9 {
+			return time.Duration(n) * time.Second, n > 0
+		}
+		until = time.Unix(n, 0)
+	} else if t, err := http.ParseTime(v); err == nil {
+		until = t
+	} else if t, err := time.Parse(time.RFC1123, v); err == nil {
+		until = t
+	} else {
+		return 0, false
+	}
+
+	d := until.Sub(now)
+	return d, d > 0
+}
+
+// firstHeader returns the value of the first of the headers that is set.
+func firstHeader(h http.Header, names ...string) string {
+	for _, name := range names {
+		if v := h.Get(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,258 @@
+package httplimit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestTransport_Pause(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	cases := []struct {
+		name   string
+		status int
+		header http.Header
+		exp    time.Duration
+	}{
+		{
+			name:   "ok",
+			status: http.StatusOK,
+			header: http.Header{"X-Ratelimit-Remaining": {"3"}, "X-Ratelimit-Reset": {"60"}},
+		},
+		{
+			name:   "retry_after_seconds",
+			status: http.StatusTooManyRequests,
+			header: http.Header{"Retry-After": {"120"}},
+			exp:    120 * time.Second,
+		},
+		{
+			name:   "retry_after_date",
+			status: http.StatusServiceUnavailable,
+			header: http.Header{"Retry-After": {now.Add(90 * time.Second).UTC().Format(http.TimeFormat)}},
+			exp:    90 * time.Second,
+		},
+		{
+			name:   "ratelimit_structured",
+			status: http.StatusTooManyRequests,
+			header: http.Header{"Ratelimit": {`"default";r=0;t=45`}},
+			exp:    45 * time.Second,
+		},
+		{
+			name:   "ratelimit_reset",
+			status: http.StatusTooManyRequests,
+			header: http.Header{"Ratelimit-Reset": {"30"}},
+			exp:    30 * time.Second,
+		},
+		{
+			name:   "httplimit_headers",
+			status: http.StatusTooManyRequests,
+			header: http.Header{"X-Ratelimit-Reset": {now.Add(75 * time.Second).UTC().Format(time.RFC1123)}},
+			exp:    75 * time.Second,
+		},
+		{
+			name:   "unix_reset",
+			status: http.StatusTooManyRequests,
+			header: http.Header{"X-Ratelimit-Reset": {"4102444800"}},
+			exp:    time.Unix(4102444800, 0).Sub(now),
+		},
+		{
+			name:   "exhausted",
+			status: http.StatusOK,
+			header: http.Header{"Ratelimit-Remaining": {"0"}, "Ratelimit-Reset": {"20"}},
+			exp:    20 * time.Second,
+		},
+		{
+			name:   "throttled_without_delay",
+			status: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tc.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			store, err := memorystore.New(&memorystore.Config{
+				Tokens:   10,
+				Interval: time.Hour,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			transport, err := httplimit.NewTransport(store, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: transport}
+
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got, want := resp.StatusCode, tc.status; got != want {
+				t.Errorf("expected %d to be %d", got, want)
+			}
+
+			u, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paused := transport.Paused(u.Host)
+
+			res, err := store.(limiter.Peeker).Peek(context.Background(), u.Host)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.exp == 0 {
+				if !paused.IsZero() {
+					t.Errorf("expected no pause, got %s", time.Until(paused))
+				}
+				if got, want := res.Remaining, uint64(9); got != want {
+					t.Errorf("expected %d to be %d", got, want)
+				}
+				return
+			}
+
+			if d := paused.Sub(now) - tc.exp; d < -time.Second || d > 2*time.Second {
+				t.Errorf("expected pause of %s, got %s", tc.exp, paused.Sub(now))
+			}
+			// The tokens of the key were taken, so others sharing the store wait.
+			if got, want := res.Remaining, uint64(0); got != want {
+				t.Errorf("expected %d to be %d", got, want)
+			}
+		})
+	}
+}
+
+func TestTransport_Wait(t *testing.T) {
+	t.Parallel()
+
+	var calls uint64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint64(&calls, 1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	store, err := memorystore.New(&memorystore.Config{
+		Tokens:   10,
+		Interval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	transport, err := httplimit.NewTransport(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The key is paused past the deadline, so the request is not sent.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(r); err == nil {
+		t.Fatal("expected error")
+	}
+	if got, want := atomic.LoadUint64(&calls), uint64(1); got != want {
+		t.Errorf("expected %d calls to be %d", got, want)
+	}
+}
+
+func TestTransport_Backoff(t *testing.T) {
+	t.Parallel()
+
+	var status int32 = http.StatusTooManyRequests
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+
+	store, err := memorystore.New(&memorystore.Config{
+		Tokens:   100,
+		Interval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	transport, err := httplimit.NewTransport(store, &httplimit.TransportConfig{
+		MinBackoff: 20 * time.Millisecond,
+		MaxBackoff: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each throttling response without a delay doubles the pause, up to the
+	// maximum, and a served request resets it.
+	for i, want := range []time.Duration{20, 40, 50, 50, 0, 20} {
+		if i == 4 {
+			atomic.StoreInt32(&status, http.StatusOK)
+		}
+		if i == 5 {
+			atomic.StoreInt32(&status, http.StatusTooManyRequests)
+		}
+
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		want *= time.Millisecond
+		paused := transport.Paused(u.Host)
+		if want == 0 {
+			if !paused.IsZero() {
+				t.Errorf("request %d: expected no pause, got %s", i, time.Until(paused))
+			}
+			continue
+		}
+		if d := time.Until(paused); d > want || d < want-15*time.Millisecond {
+			t.Errorf("request %d: expected pause of %s, got %s", i, want, d)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+// Package hybridstore defines a store that decides takes from local memory
+// and periodically reconciles its counts with a shared store, like
+// redisstore, trading exactness for far fewer calls to it.
+//
+// Each process serves a key from the remaining tokens the shared store
+// reported at the last reconcile, and counts the tokens it grants. Every
+// SyncInterval, the counts are charged to the shared store, which is then
+// peeked for the tokens left by all processes. Between reconciles, each
+// process may grant up to MaxDivergence tokens per key that the others do not
+// see, so across N processes a key can exceed its limit by up to N times
+// MaxDivergence. A hot key then costs two calls to the shared store per
+// SyncInterval, instead of one per take.
+//
+// To guarantee a bound on the over-admission instead, like never exceeding a
+// limit by more than 5%, set MaxOverAdmission and the number of Instances.
+// Each key's divergence is then capped at its share of the bound, and keys
+// whose limit is too small for a share of a whole token are taken from the
+// shared store directly, which is exact.
+package hybridstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+var _ limiter.Store = (*store)(nil)
+var _ limiter.Refunder = (*store)(nil)
+var _ limiter.Peeker = (*store)(nil)
+
+// Config is used as input to New.
+type Config struct {
+	// SyncInterval is how often the counts are reconciled with the shared
+	// store. The default value is 1 second.
+	SyncInterval time.Duration
+
+	// MaxDivergence is the most tokens the store grants for a key between
+	// reconciles. A take that reaches it reconciles the key before it returns.
+	// The default value is 10.
+	MaxDivergence uint64
+
+	// MaxOverAdmission, if set, bounds how far all instances together may
+	// exceed a key's limit between reconciles, as a fraction of the limit, like
+	// 0.05 for 5%. It must be in [0, 1), and requires Instances.
+	MaxOverAdmission float64
+
+	// Instances is the number of processes that share the store, which the
+	// over-admission bound is split between. Set it to at least the number of
+	// instances, or the bound does not hold.
+	Instances int
+
+	// ErrorFunc is called with the errors of background reconciles. Counts
+	// that fail to be charged are retried at the next one. The default
+	// discards them.
+	ErrorFunc func(err error)
+}
+
+// Divergence returns the most tokens each instance grants for a key with the
+// limit between reconciles. Across all instances, the key exceeds its limit by
+// at most Instances times as many. It is 0 if the key is taken from the shared
+// store directly, because the bound leaves less than a token per instance.
+func (c *Config) Divergence(limit uint64) uint64 {
+	d := uint64(10)
+	if c.MaxDivergence > 0 {
+		d = c.MaxDivergence
+	}
+	if c.MaxOverAdmission > 0 && c.Instances > 0 {
+		share := uint64(float64(limit) * c.MaxOverAdmission / float64(c.Instances))
+		if share < d {
+			d = share
+		}
+	}
+	return d
+}
+
+type store struct {
+	remote  limiter.Store
+	charger limiter.Charger
+	peeker  limiter.Peeker
+
+	syncInterval time.Duration
+	divergence   func(limit uint64) uint64
+	errorFunc    func(err error)
+
+	lock    sync.Mutex
+	entries map[string]*entry
+
+	stopped uint32
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+// entry is the local view of a key.
+type entry struct {
+	lock sync.Mutex
+
+	// remaining is the tokens left at the last reconcile, less those granted
+	// since. pending is the tokens granted since, which are not charged yet.
+	remaining uint64
+	pending   uint64
+	limit     uint64
+	resetAt   time.Time
+
+	// divergence is the most tokens granted between reconciles for the
+	// key's limit. If it is 0, takes go to the shared store directly.
+	divergence uint64
+
+	// synced is set once the entry was reconciled. deleted is set when the
+	// entry is forgotten, so callers that found it before get a new one.
+	synced  bool
+	deleted bool
+}
+
+// New creates a store that serves takes from memory and reconciles them with
+// remote, which must implement limiter.Charger and limiter.Peeker.
+func New(remote limiter.Store, c *Config) (limiter.Store, error) {
+	if remote == nil {
+		return nil, fmt.Errorf("remote store cannot be nil")
+	}
+	charger, ok := remote.(limiter.Charger)
+	if !ok {
+		return nil, fmt.Errorf("remote store must implement limiter.Charger")
+	}
+	peeker, ok := remote.(limiter.Peeker)
+	if !ok {
+		return nil, fmt.Errorf("remote store must implement limiter.Peeker")
+	}
+	if c == nil {
+		c = new(Config)
+	}
+
+	syncInterval := 1 * time.Second
+	if c.SyncInterval > 0 {
+		syncInterval = c.SyncInterval
+	}
+
+	if c.MaxOverAdmission < 0 || c.MaxOverAdmission >= 1 {
+		return nil, fmt.Errorf("max over-admission must be in [0, 1)")
+	}
+	if c.MaxOverAdmission > 0 && c.Instances <= 0 {
+		return nil, fmt.Errorf("instances is required with a max over-admission")
+	}
+	config := *c
+
+	errorFunc := func(error) {}
+	if c.ErrorFunc != nil {
+		errorFunc = c.ErrorFunc
+	}
+
+	s := &store{
+		remote:       remote,
+		charger:      charger,
+		peeker:       peeker,
+		syncInterval: syncInterval,
+		divergence:   config.Divergence,
+		errorFunc:    errorFunc,
+		entries:      make(map[string]*entry),
+		stopCh:       make(chan struct{}),
+		doneCh:       make(chan struct{}),
+	}
+	go s.syncLoop()
+	return s, nil
+}
+
+// Take takes a token from the key's local view. The first take on a key, the
+// first after its reset time, and a take that reaches the key's divergence
+// reconcile the key first, and return the shared store's error if that fails.
+// Keys without divergence are taken from the shared store.
+func (s *store) Take(ctx context.Context, key string) (limiter.Result, error) {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.Result{}, limiter.ErrStopped
+	}
+
+	e := s.lockedEntry(key)
+	defer e.lock.Unlock()
+
+	now := time.Now()
+	if !e.synced || !now.Before(e.resetAt) || e.pending >= e.divergence {
+		if err := s.reconcile(ctx, key, e); err != nil {
+			return limiter.Result{}, err
+		}
+	}
+
+	// The bound cannot be guaranteed locally, so take synchronously.
+	if e.divergence == 0 {
+		res, err := s.remote.Take(ctx, key)
+		if err == nil {
+			e.remaining, e.limit, e.resetAt = res.Remaining, res.Limit, res.ResetAt
+		}
+		return res, err
+	}
+
+	res := limiter.Result{
+		Limit:   e.limit,
+		ResetAt: e.resetAt,
+	}
+	if e.remaining == 0 {
+		res.RetryAfter = e.resetAt.Sub(now)
+		return res, nil
+	}
+
+	e.remaining--
+	e.pending++
+	res.Remaining = e.remaining
+	res.Allowed = true
+	return res, nil
+}
+
+// Peek returns the key's local view. Keys without one are peeked in the
+// shared store.
+func (s *store) Peek(ctx context.Context, key string) (limiter.Result, error) {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.Result{}, limiter.ErrStopped
+	}
+
+	s.lock.Lock()
+	e, ok := s.entries[key]
+	s.lock.Unlock()
+	if !ok {
+		return s.peeker.Peek(ctx, key)
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	now := time.Now()
+	if !e.synced || !now.Before(e.resetAt) {
+		return s.peeker.Peek(ctx, key)
+	}
+
+	res := limiter.Result{
+		Limit:     e.limit,
+		Remaining: e.remaining,
+		ResetAt:   e.resetAt,
+		Allowed:   e.remaining > 0,
+	}
+	if !res.Allowed {
+		res.RetryAfter = e.resetAt.Sub(now)
+	}
+	return res, nil
+}
+
+// Refund returns tokens to the key. Tokens granted since the last reconcile
+// are returned locally, and the rest are refunded in the shared store, which
+// must implement limiter.Refunder for them.
+func (s *store) Refund(ctx context.Context, key string, tokens uint64) error {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.ErrStopped
+	}
+
+	s.lock.Lock()
+	e, ok := s.entries[key]
+	s.lock.Unlock()
+
+	if ok {
+		e.lock.Lock()
+		local := tokens
+		if local > e.pending {
+			local = e.pending
+		}
+		e.pending -= local
+		e.remaining += local
+		tokens -= local
+		e.lock.Unlock()
+	}
+	if tokens == 0 {
+		return nil
+	}
+
+	r, ok := s.remote.(limiter.Refunder)
+	if !ok {
+		return limiter.ErrNotSupported
+	}
+	return r.Refund(ctx, key, tokens)
+}
+
+// Close reconciles the keys one last time and closes the shared store.
+func (s *store) Close() error {
+	if !atomic.CompareAndSwapUint32(&s.stopped, 0, 1) {
+		return nil
+	}
+	close(s.stopCh)
+	<-s.doneCh
+
+	err := s.sync(context.Background())
+	if cerr := s.remote.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// lockedEntry returns the key's entry with its lock held, creating it if
+// needed.
+func (s *store) lockedEntry(key string) *entry {
+	for {
+		s.lock.Lock()
+		e, ok := s.entries[key]
+		if !ok {
+			e = new(entry)
+			s.entries[key] = e
+		}
+		s.lock.Unlock()
+
+		e.lock.Lock()
+		if !e.deleted {
+			return e
+		}
+		e.lock.Unlock()
+	}
+}
+
+// reconcile charges the tokens granted since the last reconcile to the shared
+// store, and refreshes the entry from it. Tokens granted in an interval that
+// has since reset are dropped, since charging them would take from the new
+// one. It must be called with the entry's lock held.
+func (s *store) reconcile(ctx context.Context, key string, e *entry) error {
+	if !time.Now().Before(e.resetAt) {
+		e.pending = 0
+	}
+	if e.pending > 0 {
+		if err := s.charger.Charge(ctx, key, e.pending); err != nil {
+			return fmt.Errorf("failed to charge %q: %w", key, err)
+		}
+		e.pending = 0
+	}
+
+	res, err := s.peeker.Peek(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to peek %q: %w", key, err)
+	}
+	e.remaining = res.Remaining
+	e.limit = res.Limit
+	e.resetAt = res.ResetAt
+	e.divergence = s.divergence(res.Limit)
+	e.synced = true
+	return nil
+}
+
+// sync reconciles every key with pending tokens, and forgets the keys that
+// have none and are past their reset time. It returns the first error.
+func (s *store) sync(ctx context.Context) error {
+	s.lock.Lock()
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	s.lock.Unlock()
+
+	var firstErr error
+	now := time.Now()
+	for _, key := range keys {
+		s.lock.Lock()
+		e, ok := s.entries[key]
+		s.lock.Unlock()
+		if !ok {
+			continue
+		}
+
+		e.lock.Lock()
+		switch {
+		case e.pending > 0:
+			if err := s.reconcile(ctx, key, e); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		case !now.Before(e.resetAt):
+			e.deleted = true
+			s.lock.Lock()
+			delete(s.entries, key)
+			s.lock.Unlock()
+		}
+		e.lock.Unlock()
+	}
+	return firstErr
+}
+
+// syncLoop reconciles on the interval until the store is closed.
+func (s *store) syncLoop() {
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			if err := s.sync(context.Background()); err != nil {
+				s.errorFunc(err)
+			}
+		}
+	}
+}
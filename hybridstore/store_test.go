@@ -0,0 +1,282 @@
+package hybridstore_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/hybridstore"
+	"github.com/sethvargo/go-limiter/memorystore"
+	"github.com/sethvargo/go-limiter/storetest"
+)
+
+// countingStore counts the calls to the shared store.
+type countingStore struct {
+	limiter.Store
+	calls uint64
+}
+
+func (s *countingStore) Charge(ctx context.Context, key string, tokens uint64) error {
+	atomic.AddUint64(&s.calls, 1)
+	return s.Store.(limiter.Charger).Charge(ctx, key, tokens)
+}
+
+func (s *countingStore) Peek(ctx context.Context, key string) (limiter.Result, error) {
+	atomic.AddUint64(&s.calls, 1)
+	return s.Store.(limiter.Peeker).Peek(ctx, key)
+}
+
+func (s *countingStore) Refund(ctx context.Context, key string, tokens uint64) error {
+	atomic.AddUint64(&s.calls, 1)
+	return s.Store.(limiter.Refunder).Refund(ctx, key, tokens)
+}
+
+// sharedStore does not close the shared store, so several stores can use it.
+type sharedStore struct {
+	*countingStore
+}
+
+func (s sharedStore) Close() error {
+	return nil
+}
+
+func newRemote(tb testing.TB, tokens uint64, interval time.Duration) *countingStore {
+	tb.Helper()
+
+	s, err := memorystore.New(&memorystore.Config{
+		Tokens:   tokens,
+		Interval: interval,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &countingStore{Store: s}
+}
+
+func TestStore_Conformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.TestStore(t, func(tb testing.TB, c *storetest.Config) limiter.Store {
+		s, err := hybridstore.New(newRemote(tb, c.Tokens, c.Interval), nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	if _, err := hybridstore.New(nil, nil); err == nil {
+		t.Error("expected error for nil store")
+	}
+
+	type takeOnly struct{ limiter.Store }
+	remote := newRemote(t, 1, time.Second)
+	defer remote.Close()
+	if _, err := hybridstore.New(takeOnly{remote}, nil); err == nil {
+		t.Error("expected error for store without Charger and Peeker")
+	}
+
+	if _, err := hybridstore.New(remote, &hybridstore.Config{MaxOverAdmission: 1}); err == nil {
+		t.Error("expected error for over-admission of 100%")
+	}
+	if _, err := hybridstore.New(remote, &hybridstore.Config{MaxOverAdmission: 0.05}); err == nil {
+		t.Error("expected error for over-admission without instances")
+	}
+}
+
+func TestConfig_Divergence(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		config *hybridstore.Config
+		limit  uint64
+		exp    uint64
+	}{
+		{
+			name:   "default",
+			config: &hybridstore.Config{},
+			limit:  100,
+			exp:    10,
+		},
+		{
+			name:   "max_divergence",
+			config: &hybridstore.Config{MaxDivergence: 3},
+			limit:  100,
+			exp:    3,
+		},
+		{
+			name:   "share",
+			config: &hybridstore.Config{MaxOverAdmission: 0.05, Instances: 4},
+			limit:  1000,
+			exp:    10,
+		},
+		{
+			name:   "share_below_max",
+			config: &hybridstore.Config{MaxOverAdmission: 0.05, Instances: 4, MaxDivergence: 100},
+			limit:  1000,
+			exp:    12,
+		},
+		{
+			name:   "synchronous",
+			config: &hybridstore.Config{MaxOverAdmission: 0.05, Instances: 4},
+			limit:  50,
+			exp:    0,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got, want := tc.config.Divergence(tc.limit), tc.exp; got != want {
+				t.Errorf("expected %d to be %d", got, want)
+			}
+		})
+	}
+}
+
+func TestStore_OverAdmission(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	remote := newRemote(t, 10, time.Minute)
+	defer remote.Close()
+
+	// 5% of 10 tokens is less than a token per process, so takes are
+	// synchronous and exact.
+	newStore := func() limiter.Store {
+		s, err := hybridstore.New(sharedStore{remote}, &hybridstore.Config{
+			SyncInterval:     time.Hour,
+			MaxOverAdmission: 0.05,
+			Instances:        2,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	a, b := newStore(), newStore()
+	defer a.Close()
+	defer b.Close()
+
+	var allowed int
+	for i := 0; i < 20; i++ {
+		for _, s := range []limiter.Store{a, b} {
+			res, err := s.Take(ctx, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Allowed {
+				allowed++
+			}
+		}
+	}
+
+	if got, want := allowed, 10; got != want {
+		t.Errorf("expected %d allowed takes to be %d", got, want)
+	}
+}
+
+func TestStore_Divergence(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	remote := newRemote(t, 20, time.Minute)
+	defer remote.Close()
+
+	newStore := func() limiter.Store {
+		s, err := hybridstore.New(sharedStore{remote}, &hybridstore.Config{
+			SyncInterval:  time.Hour,
+			MaxDivergence: 5,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	// Two processes share the remote store.
+	a, b := newStore(), newStore()
+	defer a.Close()
+
+	var allowed int
+	for i := 0; i < 20; i++ {
+		for _, s := range []limiter.Store{a, b} {
+			res, err := s.Take(ctx, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Allowed {
+				allowed++
+			}
+		}
+	}
+
+	// Each process may grant up to MaxDivergence tokens the other does not
+	// see.
+	if allowed < 20 || allowed > 20+2*5 {
+		t.Errorf("expected %d allowed takes to be within the divergence", allowed)
+	}
+
+	// Far fewer calls than takes reach the remote store.
+	if got := atomic.LoadUint64(&remote.calls); got > 40/2 {
+		t.Errorf("expected fewer remote calls, got %d", got)
+	}
+
+	// Closing reconciles the remaining counts.
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	res, err := remote.Store.(limiter.Peeker).Peek(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Remaining, uint64(0); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+func TestStore_Refund(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	remote := newRemote(t, 5, time.Minute)
+
+	s, err := hybridstore.New(remote, &hybridstore.Config{SyncInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := s.Take(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The tokens were not reconciled yet, so the refund is local.
+	calls := atomic.LoadUint64(&remote.calls)
+	if err := s.(limiter.Refunder).Refund(ctx, "key", 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := atomic.LoadUint64(&remote.calls), calls; got != want {
+		t.Errorf("expected %d remote calls to be %d", got, want)
+	}
+
+	res, err := s.Take(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Remaining, uint64(1); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
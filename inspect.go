@@ -0,0 +1,40 @@
+package limiter
+
+import "context"
+
+// Inspector is implemented by stores that can list their keys and summarize
+// them, for admin tools. Stores that support it can be detected with a type
+// assertion.
+//
+// Both methods walk the keys of a live store, so keys created or removed
+// during the walk may or may not be seen.
+type Inspector interface {
+	// Keys returns a page of the keys that match the glob-style pattern, in the
+	// style of Redis SCAN. The first call should pass a cursor of 0, and each
+	// following call the returned cursor, until it is 0 again. A page may hold
+	// few or no keys before the end, and a key may be returned more than once.
+	Keys(ctx context.Context, pattern string, cursor uint64) (keys []string, next uint64, err error)
+
+	// Stats summarizes all keys, including up to n of the keys that have the
+	// fewest tokens remaining, or none if n is zero or negative.
+	Stats(ctx context.Context, n int) (Stats, error)
+}
+
+// Stats is a summary of the keys in a store.
+type Stats struct {
+	// Keys is the number of keys.
+	Keys uint64
+
+	// Top are the keys with the fewest tokens remaining, which are the heaviest
+	// consumers, in ascending order of remaining tokens. Ties are ordered by key.
+	Top []KeyStats
+}
+
+// KeyStats is the state of a single key.
+type KeyStats struct {
+	// Key is the key.
+	Key string
+
+	// Remaining is the number of tokens remaining in the current interval.
+	Remaining uint64
+}
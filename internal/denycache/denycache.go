@@ -0,0 +1,104 @@
+// Package denycache remembers denied keys until shortly before their reset
+// time, so stores can deny further takes on them without asking the backend.
+package denycache
+
+import (
+	"sync"
+	"time"
+)
+
+// Cache is a bounded cache of denials. It is safe for concurrent use.
+type Cache struct {
+	margin time.Duration
+	size   int
+
+	lock    sync.Mutex
+	entries map[entryKey]denial
+}
+
+// entryKey identifies the denials of a key at a priority.
+type entryKey struct {
+	key string
+	low bool
+}
+
+// denial is a cached denial. It is valid until expires, which is the margin
+// before resetAt.
+type denial struct {
+	resetAt time.Time
+	expires time.Time
+}
+
+// New creates a cache that holds denials of up to size keys, each until the
+// margin before its reset time.
+func New(margin time.Duration, size int) *Cache {
+	return &Cache{
+		margin:  margin,
+		size:    size,
+		entries: make(map[entryKey]denial),
+	}
+}
+
+// Get returns the reset time of a cached denial for the key at the priority. A
+// denial of a high-priority take also applies to low-priority takes, since
+// they leave a reserve.
+func (c *Cache) Get(key string, low bool, now time.Time) (time.Time, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if resetAt, ok := c.lookup(entryKey{key: key, low: low}, now); ok {
+		return resetAt, true
+	}
+	if low {
+		return c.lookup(entryKey{key: key}, now)
+	}
+	return time.Time{}, false
+}
+
+// lookup returns the reset time of an unexpired denial, removing it if it has
+// expired. It must be called with the lock held.
+func (c *Cache) lookup(ek entryKey, now time.Time) (time.Time, bool) {
+	d, ok := c.entries[ek]
+	if !ok {
+		return time.Time{}, false
+	}
+	if !now.Before(d.expires) {
+		delete(c.entries, ek)
+		return time.Time{}, false
+	}
+	return d.resetAt, true
+}
+
+// Add caches a denial of the key at the priority until the margin before
+// resetAt. If the cache is full, expired denials are removed first, and the
+// denial is not cached if there is still no room.
+func (c *Cache) Add(key string, low bool, now, resetAt time.Time) {
+	expires := resetAt.Add(-c.margin)
+	if !expires.After(now) {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ek := entryKey{key: key, low: low}
+	if _, ok := c.entries[ek]; !ok && len(c.entries) >= c.size {
+		for k, d := range c.entries {
+			if !now.Before(d.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.size {
+			return
+		}
+	}
+	c.entries[ek] = denial{resetAt: resetAt, expires: expires}
+}
+
+// Remove removes any cached denials for the key, at all priorities.
+func (c *Cache) Remove(key string) {
+	c.lock.Lock()
+	delete(c.entries, entryKey{key: key})
+	delete(c.entries, entryKey{key: key, low: true})
+	c.lock.Unlock()
+}
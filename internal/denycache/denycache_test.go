@@ -0,0 +1,65 @@
+package denycache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	t.Run("priority", func(t *testing.T) {
+		t.Parallel()
+
+		c := New(time.Second, 10)
+		c.Add("a", true, now, now.Add(time.Minute))
+		if _, ok := c.Get("a", false, now); ok {
+			t.Errorf("expected a low-priority denial not to apply to high-priority takes")
+		}
+
+		c.Add("a", false, now, now.Add(time.Minute))
+		c.Remove("a")
+		c.Add("a", false, now, now.Add(time.Minute))
+		if _, ok := c.Get("a", true, now); !ok {
+			t.Errorf("expected a high-priority denial to apply to low-priority takes")
+		}
+	})
+
+	t.Run("margin", func(t *testing.T) {
+		t.Parallel()
+
+		c := New(time.Second, 10)
+		c.Add("a", false, now, now.Add(500*time.Millisecond))
+		if _, ok := c.Get("a", false, now); ok {
+			t.Errorf("expected a denial within the margin not to be cached")
+		}
+
+		c.Add("a", false, now, now.Add(2*time.Second))
+		if _, ok := c.Get("a", false, now.Add(500*time.Millisecond)); !ok {
+			t.Errorf("expected the denial to be cached")
+		}
+		if _, ok := c.Get("a", false, now.Add(time.Second)); ok {
+			t.Errorf("expected the denial to expire at the margin")
+		}
+	})
+
+	t.Run("size", func(t *testing.T) {
+		t.Parallel()
+
+		c := New(0, 1)
+		c.Add("a", false, now, now.Add(time.Second))
+		c.Add("b", false, now, now.Add(time.Minute))
+		if _, ok := c.Get("b", false, now); ok {
+			t.Errorf("expected the denial not to be cached when full")
+		}
+
+		// Expired denials make room.
+		later := now.Add(2 * time.Second)
+		c.Add("b", false, later, later.Add(time.Minute))
+		if _, ok := c.Get("b", false, later); !ok {
+			t.Errorf("expected the denial to be cached")
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package glob
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		pattern string
+		s       string
+		exp     bool
+	}{
+		{name: "exact", pattern: "abc", s: "abc", exp: true},
+		{name: "exact_mismatch", pattern: "abc", s: "abd", exp: false},
+		{name: "star", pattern: "a*", s: "a/b:c", exp: true},
+		{name: "star_empty", pattern: "a*", s: "a", exp: true},
+		{name: "star_middle", pattern: "a*c", s: "abbbc", exp: true},
+		{name: "star_middle_mismatch", pattern: "a*c", s: "abbbd", exp: false},
+		{name: "question", pattern: "a?c", s: "abc", exp: true},
+		{name: "question_empty", pattern: "a?", s: "a", exp: false},
+		{name: "class", pattern: "[ab]c", s: "bc", exp: true},
+		{name: "class_mismatch", pattern: "[ab]c", s: "cc", exp: false},
+		{name: "range", pattern: "[a-c]", s: "b", exp: true},
+		{name: "negated", pattern: "[^a]", s: "a", exp: false},
+		{name: "negated_match", pattern: "[^a]", s: "b", exp: true},
+		{name: "escape", pattern: `a\*`, s: "a*", exp: true},
+		{name: "escape_mismatch", pattern: `a\*`, s: "ab", exp: false},
+		{name: "trailing", pattern: "ab", s: "abc", exp: false},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got, want := Match(tc.pattern, tc.s), tc.exp; got != want {
+				t.Errorf("expected %t to be %t", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+// Package httpwriter wraps http.ResponseWriters for middleware that observe or
+// change responses, while keeping the optional interfaces that streaming and
+// upgrade handlers need.
+package httpwriter
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// Writer is embedded by response writer wrappers, which override the methods
+// they change. It forwards Flush and Hijack to the wrapped writer. Flush does
+// nothing, and Hijack returns http.ErrNotSupported, if the wrapped writer does
+// not implement http.Flusher or http.Hijacker.
+type Writer struct {
+	http.ResponseWriter
+}
+
+// Flush flushes the wrapped writer.
+func (w Writer) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hijacks the connection of the wrapped writer.
+func (w Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w Writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,58 @@
+package httpwriter
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// hijackRecorder is a recorder that can be hijacked.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (r *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.hijacked = true
+	return nil, nil, nil
+}
+
+func TestWriter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("forwards", func(t *testing.T) {
+		t.Parallel()
+
+		rec := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+		w := Writer{rec}
+
+		w.Flush()
+		if !rec.Flushed {
+			t.Error("expected flush to be forwarded")
+		}
+		if _, _, err := w.Hijack(); err != nil {
+			t.Fatal(err)
+		}
+		if !rec.hijacked {
+			t.Error("expected hijack to be forwarded")
+		}
+		if got, want := w.Unwrap(), http.ResponseWriter(rec); got != want {
+			t.Errorf("expected %v to be %v", got, want)
+		}
+	})
+
+	t.Run("not_supported", func(t *testing.T) {
+		t.Parallel()
+
+		// The embedded struct hides the optional interfaces of the recorder.
+		w := Writer{struct{ http.ResponseWriter }{httptest.NewRecorder()}}
+
+		w.Flush()
+		if _, _, err := w.Hijack(); !errors.Is(err, http.ErrNotSupported) {
+			t.Errorf("expected %v to be %v", err, http.ErrNotSupported)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+// Package phase staggers the intervals of keys by a hash of the key, so keys
+// that are first seen together do not all reset together.
+package phase
+
+import (
+	"time"
+)
+
+const (
+	offset64 = 14695981039346656037
+	prime64  = 1099511628211
+)
+
+// Offset returns the offset of the key's intervals from the unix epoch, in
+// [0, interval). It is the same for the key in every process.
+func Offset(key string, interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+
+	// FNV-1a, inlined to avoid allocating a hash.
+	h := uint64(offset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= prime64
+	}
+	return time.Duration(h % uint64(interval))
+}
+
+// Start returns the start of the key's interval that contains now, both in
+// nanoseconds since the unix epoch.
+func Start(key string, now uint64, interval time.Duration) uint64 {
+	if interval <= 0 {
+		return now
+	}
+	off := uint64(Offset(key, interval))
+	return now - (now+uint64(interval)-off)%uint64(interval)
+}
@@ -0,0 +1,40 @@
+package phase
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStart(t *testing.T) {
+	t.Parallel()
+
+	interval := time.Minute
+	now := uint64(time.Now().UnixNano())
+
+	starts := make(map[uint64]struct{})
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+
+		start := Start(key, now, interval)
+		if start > now || now-start >= uint64(interval) {
+			t.Fatalf("expected %d to be within an interval before %d", start, now)
+		}
+		if got, want := (start-uint64(Offset(key, interval)))%uint64(interval), uint64(0); got != want {
+			t.Errorf("expected %d to be %d", got, want)
+		}
+
+		// Later times in the same interval have the same start.
+		if got, want := Start(key, start+uint64(interval)-1, interval), start; got != want {
+			t.Errorf("expected %d to be %d", got, want)
+		}
+		if got, want := Start(key, start+uint64(interval), interval), start+uint64(interval); got != want {
+			t.Errorf("expected %d to be %d", got, want)
+		}
+		starts[start] = struct{}{}
+	}
+
+	if len(starts) < 90 {
+		t.Errorf("expected keys to start at different times, got %d distinct starts", len(starts))
+	}
+}
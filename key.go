@@ -0,0 +1,137 @@
+package limiter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+const (
+	// MaxKeyLength is the longest key a KeyBuilder produces. Longer keys are
+	// replaced by a hash of the key.
+	MaxKeyLength = 256
+
+	// maxKeyValueLength is the longest escaped value a KeyBuilder keeps. Longer
+	// values are replaced by a hash of the value.
+	maxKeyValueLength = 64
+)
+
+// keyValueEscaper escapes the characters with a meaning in KeyBuilder keys.
+var keyValueEscaper = strings.NewReplacer("%", "%25", ":", "%3A", "=", "%3D", "#", "%23")
+
+// Standard dimensions, in the order they appear in keys.
+var keyDimensionOrder = map[string]int{
+	"t": 0,
+	"u": 1,
+	"r": 2,
+}
+
+// KeyBuilder builds keys from dimensions like the tenant, user, and route.
+// Dimensions always appear in the same order, tenant, user, route, and then
+// other dimensions by name, no matter the order they are set in, and their
+// values are escaped, so user-controlled values cannot collide with other
+// keys by containing separators. Keys are at most MaxKeyLength long.
+//
+// A KeyBuilder is a value, so a partial key can be reused as the base of
+// several keys:
+//
+//	base := limiter.Key().Tenant(tenant)
+//	store.Take(ctx, base.User(user).Route(route).String())
+type KeyBuilder struct {
+	dims []keyDimension
+}
+
+type keyDimension struct {
+	name, value string
+}
+
+var _ KeyEncoder = KeyBuilder{}
+
+// Key returns an empty key builder.
+func Key() KeyBuilder {
+	return KeyBuilder{}
+}
+
+// Tenant returns a copy of the builder with the tenant set.
+func (b KeyBuilder) Tenant(tenant string) KeyBuilder {
+	return b.with("t", tenant)
+}
+
+// User returns a copy of the builder with the user set.
+func (b KeyBuilder) User(user string) KeyBuilder {
+	return b.with("u", user)
+}
+
+// Route returns a copy of the builder with the route set.
+func (b KeyBuilder) Route(route string) KeyBuilder {
+	return b.with("r", route)
+}
+
+// Dimension returns a copy of the builder with another dimension set, like
+// "region" or "plan". These come after the standard dimensions, ordered by
+// name.
+func (b KeyBuilder) Dimension(name, value string) KeyBuilder {
+	return b.with("x."+name, value)
+}
+
+// String returns the key, like "t=acme:u=42:r=GET /users".
+func (b KeyBuilder) String() string {
+	dims := make([]keyDimension, len(b.dims))
+	copy(dims, b.dims)
+	sort.Slice(dims, func(i, j int) bool {
+		oi, iStd := keyDimensionOrder[dims[i].name]
+		oj, jStd := keyDimensionOrder[dims[j].name]
+		switch {
+		case iStd && jStd:
+			return oi < oj
+		case iStd != jStd:
+			return iStd
+		default:
+			return dims[i].name < dims[j].name
+		}
+	})
+
+	parts := make([]string, len(dims))
+	for i, d := range dims {
+		parts[i] = escapeKeyValue(d.name) + "=" + escapeKeyValue(d.value)
+	}
+
+	key := strings.Join(parts, ":")
+	if len(key) > MaxKeyLength {
+		key = hashKeyValue(key)
+	}
+	return key
+}
+
+// EncodeKey implements KeyEncoder.
+func (b KeyBuilder) EncodeKey() string {
+	return b.String()
+}
+
+// with returns a copy of the builder with the dimension set.
+func (b KeyBuilder) with(name, value string) KeyBuilder {
+	dims := make([]keyDimension, 0, len(b.dims)+1)
+	for _, d := range b.dims {
+		if d.name != name {
+			dims = append(dims, d)
+		}
+	}
+	return KeyBuilder{dims: append(dims, keyDimension{name: name, value: value})}
+}
+
+// escapeKeyValue escapes the value, replacing it with its hash if it is too
+// long. Escaped values never contain "#", so hashes cannot collide with them.
+func escapeKeyValue(v string) string {
+	v = keyValueEscaper.Replace(v)
+	if len(v) > maxKeyValueLength {
+		return hashKeyValue(v)
+	}
+	return v
+}
+
+// hashKeyValue returns "#" and the first 128 bits of the value's SHA-256.
+func hashKeyValue(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return "#" + hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,77 @@
+package limiter_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+func TestKeyBuilder(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("a", 100)
+
+	cases := []struct {
+		name string
+		key  limiter.KeyBuilder
+		want string
+	}{
+		{
+			name: "empty",
+			key:  limiter.Key(),
+			want: "",
+		},
+		{
+			name: "ordered",
+			key:  limiter.Key().Route("GET /users").Dimension("region", "eu").User("42").Tenant("acme").Dimension("plan", "free"),
+			want: "t=acme:u=42:r=GET /users:x.plan=free:x.region=eu",
+		},
+		{
+			name: "replaced",
+			key:  limiter.Key().User("1").User("2"),
+			want: "u=2",
+		},
+		{
+			name: "escaped",
+			key:  limiter.Key().Tenant("acme:u=1").User("#2%"),
+			want: "t=acme%3Au%3D1:u=%232%25",
+		},
+		{
+			name: "long_value",
+			key:  limiter.Key().User(long),
+			want: "u=#2816597888e4a0d3a36b82b83316ab32",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got, want := tc.key.String(), tc.want; got != want {
+				t.Errorf("expected %q to be %q", got, want)
+			}
+		})
+	}
+}
+
+func TestKeyBuilder_Bounded(t *testing.T) {
+	t.Parallel()
+
+	key := limiter.Key()
+	for i := 0; i < 20; i++ {
+		key = key.Dimension(strings.Repeat("d", i+1), strings.Repeat("v", 60))
+	}
+	if got := key.String(); len(got) > limiter.MaxKeyLength || !strings.HasPrefix(got, "#") {
+		t.Errorf("expected %q to be a hash of at most %d bytes", got, limiter.MaxKeyLength)
+	}
+
+	// Setting a dimension on a copy does not change the original.
+	base := limiter.Key().Tenant("acme")
+	_ = base.User("1")
+	if got, want := base.String(), "t=acme"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+package limiter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/limittest"
+)
+
+type routeKey struct {
+	Tenant, User, Route string
+}
+
+func (k routeKey) EncodeKey() string {
+	return limiter.JoinKey("route", k.Tenant, k.User, k.Route)
+}
+
+func TestJoinKey(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		parts []string
+		want  string
+	}{
+		{
+			name:  "plain",
+			parts: []string{"route", "acme", "1", "/users"},
+			want:  "route:acme:1:/users",
+		},
+		{
+			name:  "separator",
+			parts: []string{"route", "acme:1", "/users"},
+			want:  "route:acme%3A1:/users",
+		},
+		{
+			name:  "escape",
+			parts: []string{"route", "100%3A"},
+			want:  "route:100%253A",
+		},
+		{
+			name: "empty",
+			want: "",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got, want := limiter.JoinKey(tc.parts...), tc.want; got != want {
+				t.Errorf("expected %q to be %q", got, want)
+			}
+		})
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	t.Parallel()
+
+	rs := &recordingStore{Decorated: limiter.Decorated{Store: limittest.NewStore(1, time.Minute)}}
+	k := limiter.NewKeyed(rs)
+
+	ctx := context.Background()
+	key := routeKey{Tenant: "acme", User: "1", Route: "/users"}
+	if res, err := k.Take(ctx, key); err != nil || !res.Allowed {
+		t.Fatalf("expected take to be allowed, got %t, %v", res.Allowed, err)
+	}
+	if got, want := rs.keys[0], "route:acme:1:/users"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+
+	res, err := k.Peek(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed {
+		t.Errorf("expected peek of exhausted key to not be allowed")
+	}
+
+	if err := k.Refund(ctx, key, 1); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := k.Take(ctx, key); err != nil || !res.Allowed {
+		t.Errorf("expected take after refund to be allowed, got %t, %v", res.Allowed, err)
+	}
+
+	// Stores without the capabilities report them as not supported.
+	k = limiter.NewKeyed(struct{ limiter.Store }{limittest.NewStore(1, time.Minute)})
+	if err := k.Refund(ctx, key, 1); !errors.Is(err, limiter.ErrNotSupported) {
+		t.Errorf("refund: expected %v to be %v", err, limiter.ErrNotSupported)
+	}
+	if _, err := k.Peek(ctx, key); !errors.Is(err, limiter.ErrNotSupported) {
+		t.Errorf("peek: expected %v to be %v", err, limiter.ErrNotSupported)
+	}
+}
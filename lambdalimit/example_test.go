@@ -0,0 +1,76 @@
+package lambdalimit_test
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/sethvargo/go-limiter/lambdalimit"
+	"github.com/sethvargo/go-limiter/redisstore"
+)
+
+func ExampleWrap() {
+	// Create the store once, outside the handler, so its connections are
+	// reused across invocations.
+	store, err := redisstore.NewFromURL("redis://localhost:6379", &redisstore.Config{
+		Tokens:   100,
+		Interval: time.Minute,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	handler := lambdalimit.Wrap(store, lambdalimit.SourceIPKeyFunc(), 50*time.Millisecond,
+		func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+			return events.APIGatewayProxyResponse{StatusCode: 200, Body: "hello"}, nil
+		})
+
+	// lambda.Start(handler)
+	_ = handler
+}
+
+func ExampleTake_authorizer() {
+	store, err := redisstore.NewFromURL("redis://localhost:6379", &redisstore.Config{
+		Tokens:   100,
+		Interval: time.Minute,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// A REQUEST authorizer that denies callers over their limit. Authorizers
+	// cannot return 429, so the request is denied with 403 instead. Caching of
+	// authorizer results must be disabled, or limits apply per cache entry.
+	authorizer := func(ctx context.Context, req events.APIGatewayCustomAuthorizerRequestTypeRequest) (events.APIGatewayCustomAuthorizerResponse, error) {
+		apiKey := req.Headers["x-api-key"]
+		if apiKey == "" {
+			return events.APIGatewayCustomAuthorizerResponse{}, errors.New("Unauthorized")
+		}
+
+		effect := "Allow"
+		res, err := lambdalimit.Take(ctx, store, apiKey, 50*time.Millisecond)
+		if !res.Allowed {
+			effect = "Deny"
+		}
+		if err != nil {
+			log.Printf("failed to take: %s", err)
+		}
+
+		return events.APIGatewayCustomAuthorizerResponse{
+			PrincipalID: apiKey,
+			PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
+				Version: "2012-10-17",
+				Statement: []events.IAMPolicyStatement{{
+					Action:   []string{"execute-api:Invoke"},
+					Effect:   effect,
+					Resource: []string{req.MethodArn},
+				}},
+			},
+		}, nil
+	}
+
+	// lambda.Start(authorizer)
+	_ = authorizer
+}
@@ -0,0 +1,9 @@
+module github.com/sethvargo/go-limiter/lambdalimit
+
+go 1.20
+
+require github.com/sethvargo/go-limiter v0.1.0
+
+require github.com/aws/aws-lambda-go v1.47.0
+
+replace github.com/sethvargo/go-limiter => ../
@@ -0,0 +1,143 @@
+// Package lambdalimit provides helpers for rate limiting AWS Lambda functions
+// behind API Gateway.
+//
+// Lambda functions are billed while they wait, and API Gateway gives up on
+// slow integrations, so takes run with a short timeout. Wrap wraps a proxy
+// integration handler, and responds to rejected requests with the same 429
+// response shape API Gateway uses for its own throttling. Custom authorizers
+// can call Take directly; see the example.
+//
+// Any store works, but memory stores only limit each Lambda instance, so use
+// a shared store like redisstore, created once outside the handler so
+// connections are reused across invocations.
+package lambdalimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/httplimit"
+)
+
+// DefaultTimeout is the timeout of each take if none is given.
+const DefaultTimeout = 100 * time.Millisecond
+
+// Handler is an API Gateway proxy integration handler.
+type Handler func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// KeyFunc returns the key that identifies a request for the purpose of rate
+// limiting.
+type KeyFunc func(req events.APIGatewayProxyRequest) (string, error)
+
+// SourceIPKeyFunc keys requests by the client IP address API Gateway reports.
+func SourceIPKeyFunc() KeyFunc {
+	return func(req events.APIGatewayProxyRequest) (string, error) {
+		ip := req.RequestContext.Identity.SourceIP
+		if ip == "" {
+			return "", fmt.Errorf("missing source ip")
+		}
+		return ip, nil
+	}
+}
+
+// Take takes from the key, giving up after timeout, or DefaultTimeout if it is
+// 0. Like limiter.Store.Take, a store that fails open may return an error
+// alongside an allowed result.
+func Take(ctx context.Context, s limiter.Store, key string, timeout time.Duration) (limiter.Result, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return s.Take(ctx, key)
+}
+
+// Wrap returns a handler that takes from the store for each request before
+// calling next. Rejected requests get ThrottledResponse. If the key function
+// fails, or the store fails closed, the request gets a 500 response. The
+// httplimit headers are set on the responses of allowed requests.
+func Wrap(s limiter.Store, f KeyFunc, timeout time.Duration, next Handler) Handler {
+	return func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		key, err := f(req)
+		if err != nil {
+			return errorResponse(), nil
+		}
+
+		res, err := Take(ctx, s, key, timeout)
+		if err != nil && !res.Allowed {
+			return errorResponse(), nil
+		}
+		if !res.Allowed {
+			return ThrottledResponse(res), nil
+		}
+		h := headers(res, err)
+
+		resp, err := next(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		if resp.Headers == nil && len(h) > 0 {
+			resp.Headers = make(map[string]string)
+		}
+		for k, v := range h {
+			resp.Headers[k] = v
+		}
+		return resp, nil
+	}
+}
+
+// ThrottledResponse returns the 429 response API Gateway sends when it
+// throttles a request, with the httplimit headers and Retry-After.
+func ThrottledResponse(res limiter.Result) events.APIGatewayProxyResponse {
+	headers := Headers(res)
+	headers["Content-Type"] = "application/json"
+
+	body, _ := json.Marshal(map[string]string{"message": "Too Many Requests"})
+	return events.APIGatewayProxyResponse{
+		StatusCode: 429,
+		Headers:    headers,
+		Body:       string(body),
+	}
+}
+
+// Headers returns the headers httplimit.SetHeaders sets for the result, by
+// their httplimit names.
+func Headers(res limiter.Result) map[string]string {
+	return headers(res, nil)
+}
+
+// headers returns the headers httplimit.SetHeaders sets for a take, which are
+// none if it failed.
+func headers(res limiter.Result, err error) map[string]string {
+	h := make(http.Header)
+	httplimit.SetHeaders(h, res, err)
+
+	headers := make(map[string]string, len(h))
+	for _, name := range []string{
+		httplimit.HeaderRateLimitLimit,
+		httplimit.HeaderRateLimitRemaining,
+		httplimit.HeaderRateLimitReset,
+		httplimit.HeaderRetryAfter,
+	} {
+		if v := h.Get(name); v != "" {
+			headers[name] = v
+		}
+	}
+	return headers
+}
+
+// errorResponse is the response when the request cannot be limited.
+func errorResponse() events.APIGatewayProxyResponse {
+	body, _ := json.Marshal(map[string]string{"message": "Internal Server Error"})
+	return events.APIGatewayProxyResponse{
+		StatusCode: 500,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}
+}
@@ -0,0 +1,96 @@
+package lambdalimit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/lambdalimit"
+	"github.com/sethvargo/go-limiter/limittest"
+)
+
+func TestWrap(t *testing.T) {
+	t.Parallel()
+
+	ok := func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+	}
+
+	request := events.APIGatewayProxyRequest{
+		RequestContext: events.APIGatewayProxyRequestContext{
+			Identity: events.APIGatewayRequestIdentity{SourceIP: "192.0.2.1"},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		request  events.APIGatewayProxyRequest
+		fail     error
+		failOpen bool
+		codes    []int
+	}{
+		{
+			name:    "limited",
+			request: request,
+			codes:   []int{200, 200, 429},
+		},
+		{
+			name:  "missing_key",
+			codes: []int{500},
+		},
+		{
+			name:    "fail_closed",
+			request: request,
+			fail:    errors.New("down"),
+			codes:   []int{500},
+		},
+		{
+			name:     "fail_open",
+			request:  request,
+			fail:     errors.New("down"),
+			failOpen: true,
+			codes:    []int{200, 200, 200},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := limittest.NewStore(2, time.Minute)
+			if tc.fail != nil {
+				store.Fail(tc.fail, tc.failOpen)
+			}
+			h := lambdalimit.Wrap(store, lambdalimit.SourceIPKeyFunc(), 0, ok)
+
+			for i, want := range tc.codes {
+				resp, err := h(context.Background(), tc.request)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := resp.StatusCode; got != want {
+					t.Errorf("request %d: expected %d to be %d", i, got, want)
+				}
+
+				switch resp.StatusCode {
+				case 429:
+					if got, want := resp.Body, `{"message":"Too Many Requests"}`; got != want {
+						t.Errorf("body: expected %q to be %q", got, want)
+					}
+					if got, want := resp.Headers[httplimit.HeaderRetryAfter], "60"; got != want {
+						t.Errorf("retry after: expected %q to be %q", got, want)
+					}
+				case 200:
+					if tc.fail == nil && resp.Headers[httplimit.HeaderRateLimitRemaining] == "" {
+						t.Errorf("expected rate limit headers")
+					}
+				}
+			}
+		})
+	}
+}
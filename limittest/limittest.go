@@ -0,0 +1,80 @@
+// Package limittest provides helpers for testing code that uses the limiter,
+// without Redis or sleeping.
+//
+// Store is a store with a manual clock, and AssertLimited checks that an HTTP
+// handler, such as one wrapped with httplimit, allows a number of requests and
+// then rejects the next:
+//
+//	func TestHandler_Limited(t *testing.T) {
+//		store := limittest.NewStore(5, time.Minute)
+//		mw, err := httplimit.NewMiddleware(store, httplimit.IPKeyFunc())
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		h := mw.Handle(myHandler)
+//
+//		limittest.AssertLimited(t, h, 5)
+//
+//		store.Advance(time.Minute)
+//		limittest.AssertLimited(t, h, 5)
+//	}
+package limittest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sethvargo/go-limiter/httplimit"
+)
+
+// AssertLimited asserts that the handler allows n GET requests to "/" and
+// rejects the next with 429 Too Many Requests and a Retry-After header. The
+// requests come from the httptest default remote address, so they share a key
+// under httplimit.IPKeyFunc.
+func AssertLimited(tb testing.TB, h http.Handler, n int) {
+	tb.Helper()
+
+	AssertLimitedFunc(tb, h, n, func() *http.Request {
+		return httptest.NewRequest(http.MethodGet, "/", nil)
+	})
+}
+
+// AssertLimitedFunc is like AssertLimited, but makes requests with newRequest,
+// for handlers that are keyed by something other than the remote address.
+func AssertLimitedFunc(tb testing.TB, h http.Handler, n int, newRequest func() *http.Request) {
+	tb.Helper()
+
+	for i := 0; i < n; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newRequest())
+		if w.Code == http.StatusTooManyRequests {
+			tb.Errorf("request %d: expected to be allowed, got %d", i+1, w.Code)
+			return
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newRequest())
+	if got, want := w.Code, http.StatusTooManyRequests; got != want {
+		tb.Errorf("request %d: expected %d to be %d", n+1, got, want)
+		return
+	}
+	if w.Header().Get(httplimit.HeaderRetryAfter) == "" {
+		tb.Errorf("request %d: expected a %s header", n+1, httplimit.HeaderRetryAfter)
+	}
+}
+
+// AssertNotLimited asserts that the handler allows n GET requests to "/".
+func AssertNotLimited(tb testing.TB, h http.Handler, n int) {
+	tb.Helper()
+
+	for i := 0; i < n; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code == http.StatusTooManyRequests {
+			tb.Errorf("request %d: expected to be allowed, got %d", i+1, w.Code)
+			return
+		}
+	}
+}
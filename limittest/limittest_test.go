@@ -0,0 +1,102 @@
+package limittest_test
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/limittest"
+)
+
+// recorder is a testing.TB that records errors instead of failing.
+type recorder struct {
+	testing.TB
+
+	lock   sync.Mutex
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertLimited(t *testing.T) {
+	t.Parallel()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cases := []struct {
+		name   string
+		tokens uint64
+		n      int
+		fail   bool
+	}{
+		{
+			name:   "limited",
+			tokens: 3,
+			n:      3,
+		},
+		{
+			name:   "too_few",
+			tokens: 2,
+			n:      3,
+			fail:   true,
+		},
+		{
+			name:   "too_many",
+			tokens: 4,
+			n:      3,
+			fail:   true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := limittest.NewStore(tc.tokens, time.Minute)
+			mw, err := httplimit.NewMiddleware(store, httplimit.IPKeyFunc())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &recorder{TB: t}
+			limittest.AssertLimited(r, mw.Handle(ok), tc.n)
+			if got, want := len(r.errors) > 0, tc.fail; got != want {
+				t.Errorf("expected failure to be %t, got %q", want, r.errors)
+			}
+		})
+	}
+}
+
+func TestAssertLimited_Refill(t *testing.T) {
+	t.Parallel()
+
+	store := limittest.NewStore(2, time.Minute)
+	mw, err := httplimit.NewMiddleware(store, httplimit.IPKeyFunc("X-User"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := mw.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	limittest.AssertLimited(t, h, 2)
+	store.Advance(time.Minute)
+	limittest.AssertNotLimited(t, h, 2)
+
+	limittest.AssertLimitedFunc(t, h, 2, func() *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-User", "alice")
+		return r
+	})
+}
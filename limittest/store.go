@@ -0,0 +1,360 @@
+package limittest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/internal/glob"
+)
+
+var _ limiter.Store = (*Store)(nil)
+var _ limiter.Refunder = (*Store)(nil)
+var _ limiter.Peeker = (*Store)(nil)
+var _ limiter.Charger = (*Store)(nil)
+var _ limiter.Inspector = (*Store)(nil)
+var _ limiter.Annotator = (*Store)(nil)
+var _ limiter.Debugger = (*Store)(nil)
+var _ limiter.Deleter = (*Store)(nil)
+var _ limiter.Overrider = (*Store)(nil)
+
+// Store is an in-memory store for tests with a manual clock. Each key gets
+// Tokens per fixed interval, starting from its first take, and time only
+// passes when Advance is called, so tests can exhaust and refill keys without
+// sleeping. It is safe for concurrent use.
+type Store struct {
+	lock     sync.Mutex
+	tokens   uint64
+	interval time.Duration
+	now      time.Time
+	buckets  map[string]*bucket
+	takes    map[string]uint64
+	denials  uint64
+	err      error
+	allowErr bool
+	stopped  bool
+}
+
+type bucket struct {
+	start     time.Time
+	remaining uint64
+	metadata  string
+}
+
+// NewStore creates a store that allows tokens per interval. If interval is 0,
+// the default is 1 second. The clock starts at an arbitrary fixed time.
+func NewStore(tokens uint64, interval time.Duration) *Store {
+	if interval <= 0 {
+		interval = 1 * time.Second
+	}
+
+	return &Store{
+		tokens:   tokens,
+		interval: interval,
+		now:      time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		buckets:  make(map[string]*bucket),
+		takes:    make(map[string]uint64),
+	}
+}
+
+// Take takes a token from the key, or returns the error set by Fail.
+func (s *Store) Take(_ context.Context, key string) (limiter.Result, error) {
+	return s.take(key, s.tokens, s.interval)
+}
+
+// TakeWith takes a token from the key like Take, but allows tokens per
+// interval instead of the store's limit. If interval is 0, the default is 1
+// second.
+func (s *Store) TakeWith(_ context.Context, key string, tokens uint64, interval time.Duration) (limiter.Result, error) {
+	if interval <= 0 {
+		interval = 1 * time.Second
+	}
+	return s.take(key, tokens, interval)
+}
+
+// take takes a token from the key with the given limit.
+func (s *Store) take(key string, tokens uint64, interval time.Duration) (limiter.Result, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopped {
+		return limiter.Result{}, limiter.ErrStopped
+	}
+	s.takes[key]++
+	if s.err != nil {
+		return limiter.Result{Allowed: s.allowErr}, s.err
+	}
+
+	b := s.bucketWith(key, tokens, interval)
+	resetAt := b.start.Add(interval)
+	if b.remaining == 0 {
+		s.denials++
+		return limiter.Result{
+			Limit:      tokens,
+			ResetAt:    resetAt,
+			RetryAfter: resetAt.Sub(s.now),
+		}, nil
+	}
+
+	b.remaining--
+	return limiter.Result{
+		Limit:     tokens,
+		Remaining: b.remaining,
+		ResetAt:   resetAt,
+		Allowed:   true,
+	}, nil
+}
+
+// Peek returns the key's bucket as its next take would see it, without taking
+// or counting a take. It returns the error set by Fail, like Take.
+func (s *Store) Peek(_ context.Context, key string) (limiter.Result, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopped {
+		return limiter.Result{}, limiter.ErrStopped
+	}
+	if s.err != nil {
+		return limiter.Result{Allowed: s.allowErr}, s.err
+	}
+
+	remaining, start := s.tokens, s.now
+	var metadata string
+	if b, ok := s.buckets[key]; ok {
+		remaining, start = s.peek(b)
+		metadata = b.metadata
+	}
+
+	resetAt := start.Add(s.interval)
+	res := limiter.Result{
+		Limit:     s.tokens,
+		Remaining: remaining,
+		ResetAt:   resetAt,
+		Allowed:   remaining > 0,
+		Metadata:  metadata,
+	}
+	if !res.Allowed {
+		res.RetryAfter = resetAt.Sub(s.now)
+	}
+	return res, nil
+}
+
+// Refund returns tokens to the key, up to the limit. Refunding a key that has
+// never been taken from is a no-op.
+func (s *Store) Refund(_ context.Context, key string, tokens uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopped {
+		return limiter.ErrStopped
+	}
+	if _, ok := s.buckets[key]; !ok {
+		return nil
+	}
+
+	b := s.bucket(key)
+	if tokens > s.tokens-b.remaining {
+		tokens = s.tokens - b.remaining
+	}
+	b.remaining += tokens
+	return nil
+}
+
+// Charge takes tokens from the key, down to zero, without counting a take.
+func (s *Store) Charge(_ context.Context, key string, tokens uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopped {
+		return limiter.ErrStopped
+	}
+
+	b := s.bucket(key)
+	if tokens > b.remaining {
+		tokens = b.remaining
+	}
+	b.remaining -= tokens
+	return nil
+}
+
+// SetMetadata stores the metadata with the key, creating it if it does not
+// exist. Peek returns it.
+func (s *Store) SetMetadata(_ context.Context, key, metadata string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopped {
+		return limiter.ErrStopped
+	}
+
+	s.bucket(key).metadata = metadata
+	return nil
+}
+
+// Delete deletes the key, so its next take starts with full tokens. Unlike
+// Reset, it returns limiter.ErrStopped after the store is closed.
+func (s *Store) Delete(_ context.Context, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopped {
+		return limiter.ErrStopped
+	}
+
+	delete(s.buckets, key)
+	return nil
+}
+
+// Keys returns all the keys that match the glob-style pattern, which uses the
+// same syntax as Redis, in one page.
+func (s *Store) Keys(_ context.Context, pattern string, _ uint64) ([]string, uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopped {
+		return nil, 0, limiter.ErrStopped
+	}
+
+	var keys []string
+	for k := range s.buckets {
+		if glob.Match(pattern, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, 0, nil
+}
+
+// Stats summarizes all keys, with their tokens remaining as Peek reports them.
+func (s *Store) Stats(_ context.Context, n int) (limiter.Stats, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopped {
+		return limiter.Stats{}, limiter.ErrStopped
+	}
+
+	all := make([]limiter.KeyStats, 0, len(s.buckets))
+	for k, b := range s.buckets {
+		remaining, _ := s.peek(b)
+		all = append(all, limiter.KeyStats{Key: k, Remaining: remaining})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Remaining != all[j].Remaining {
+			return all[i].Remaining < all[j].Remaining
+		}
+		return all[i].Key < all[j].Key
+	})
+
+	stats := limiter.Stats{Keys: uint64(len(all))}
+	if n < 0 {
+		n = 0
+	}
+	if n < len(all) {
+		all = all[:n]
+	}
+	stats.Top = all
+	return stats, nil
+}
+
+// Close stops the store. Later takes return limiter.ErrStopped.
+func (s *Store) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.stopped = true
+	return nil
+}
+
+// Advance moves the store's clock forward, refilling the keys whose interval
+// has passed.
+func (s *Store) Advance(d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.now = s.now.Add(d)
+}
+
+// Now returns the store's clock.
+func (s *Store) Now() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.now
+}
+
+// Reset forgets the key, so its next take starts with full tokens.
+func (s *Store) Reset(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.buckets, key)
+}
+
+// Fail makes every take return err, allowed if allowed is set, like a store
+// that fails open or closed. Pass a nil error to make takes succeed again.
+func (s *Store) Fail(err error, allowed bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.err = err
+	s.allowErr = allowed
+}
+
+// Takes returns the number of takes on the key, including failed and denied
+// ones.
+func (s *Store) Takes(key string) uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.takes[key]
+}
+
+// DebugVars returns the number of takes, denials and keys.
+func (s *Store) DebugVars() map[string]interface{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var takes uint64
+	for _, n := range s.takes {
+		takes += n
+	}
+	return map[string]interface{}{
+		"takes":   takes,
+		"denials": s.denials,
+		"keys":    len(s.buckets),
+	}
+}
+
+// peek returns the tokens remaining in the bucket and the start of its
+// interval, as of now, without changing it. It must be called with the lock
+// held.
+func (s *Store) peek(b *bucket) (uint64, time.Time) {
+	if elapsed := s.now.Sub(b.start); elapsed >= s.interval {
+		return s.tokens, b.start.Add(elapsed / s.interval * s.interval)
+	}
+	return b.remaining, b.start
+}
+
+// bucket returns the key's bucket, starting a new interval if the current one
+// has passed. It must be called with the lock held.
+func (s *Store) bucket(key string) *bucket {
+	return s.bucketWith(key, s.tokens, s.interval)
+}
+
+// bucketWith returns the key's bucket like bucket, refilling it with the given
+// limit. It must be called with the lock held.
+func (s *Store) bucketWith(key string, tokens uint64, interval time.Duration) *bucket {
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{start: s.now, remaining: tokens}
+		s.buckets[key] = b
+	}
+	if elapsed := s.now.Sub(b.start); elapsed >= interval {
+		b.start = b.start.Add(elapsed / interval * interval)
+		b.remaining = tokens
+	}
+	return b
+}
@@ -0,0 +1,189 @@
+package limittest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := NewStore(2, time.Minute)
+
+	take := func(key string) limiter.Result {
+		t.Helper()
+		res, err := s.Take(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for i := 0; i < 2; i++ {
+		if res := take("a"); !res.Allowed {
+			t.Fatalf("take %d: expected to be allowed", i)
+		}
+	}
+	res := take("a")
+	if res.Allowed {
+		t.Fatal("expected exhausted key to be denied")
+	}
+	if got, want := res.RetryAfter, time.Minute; got != want {
+		t.Errorf("retry after: expected %s to be %s", got, want)
+	}
+	if res := take("b"); !res.Allowed {
+		t.Errorf("expected other keys to be allowed")
+	}
+
+	s.Advance(59 * time.Second)
+	if res := take("a"); res.Allowed {
+		t.Errorf("expected key to be denied before the interval passes")
+	}
+	s.Advance(time.Second)
+	if res := take("a"); !res.Allowed || res.Remaining != 1 {
+		t.Errorf("expected key to be refilled, got %#v", res)
+	}
+
+	if err := s.Refund(ctx, "a", 5); err != nil {
+		t.Fatal(err)
+	}
+	if res := take("a"); res.Remaining != 1 {
+		t.Errorf("refund: expected %d to be %d", res.Remaining, 1)
+	}
+
+	take("a")
+	s.Reset("a")
+	if res := take("a"); !res.Allowed {
+		t.Errorf("expected reset key to be allowed")
+	}
+
+	if got, want := s.Takes("a"), uint64(8); got != want {
+		t.Errorf("takes: expected %d to be %d", got, want)
+	}
+
+	failure := errors.New("down")
+	s.Fail(failure, true)
+	res, err := s.Take(ctx, "a")
+	if got, want := err, failure; got != want {
+		t.Errorf("fail: expected %v to be %v", got, want)
+	}
+	if !res.Allowed {
+		t.Errorf("fail: expected to fail open")
+	}
+	s.Fail(nil, false)
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Take(ctx, "a"); !errors.Is(err, limiter.ErrStopped) {
+		t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+}
+
+func TestStore_Inspect(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := NewStore(3, time.Minute)
+
+	for key, n := range map[string]int{"user:a": 3, "user:b": 1, "other": 2} {
+		for i := 0; i < n; i++ {
+			if _, err := s.Take(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := s.SetMetadata(ctx, "user:c", "plan=free"); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, next, err := s.Keys(ctx, "user:*", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(keys, next), "[user:a user:b user:c] 0"; got != want {
+		t.Errorf("keys: expected %s to be %s", got, want)
+	}
+
+	stats, err := s.Stats(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(stats), "{4 [{user:a 0} {other 1}]}"; got != want {
+		t.Errorf("stats: expected %s to be %s", got, want)
+	}
+
+	res, err := s.Peek(ctx, "user:c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Metadata, "plan=free"; got != want {
+		t.Errorf("metadata: expected %q to be %q", got, want)
+	}
+	if got, want := res.Remaining, uint64(3); got != want {
+		t.Errorf("remaining: expected %d to be %d", got, want)
+	}
+
+	// Refilled keys are summarized with full tokens.
+	s.Advance(time.Minute)
+	if stats, err = s.Stats(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stats.Top[0].Remaining, uint64(3); got != want {
+		t.Errorf("stats after refill: expected %d to be %d", got, want)
+	}
+}
+
+func TestStore_DebugVars(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := NewStore(1, time.Minute)
+
+	for _, key := range []string{"a", "a", "a", "b"} {
+		if _, err := s.Take(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	vars := s.DebugVars()
+	if got, want := fmt.Sprint(vars["takes"], vars["denials"], vars["keys"]), "4 2 2"; got != want {
+		t.Errorf("expected %s to be %s", got, want)
+	}
+}
+
+func TestStore_TakeWith(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := NewStore(1, time.Minute)
+
+	for i, want := range []bool{true, true, true, false} {
+		res, err := s.TakeWith(ctx, "a", 3, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Allowed; got != want {
+			t.Errorf("take %d: expected %t to be %t", i, got, want)
+		}
+		if got, want := res.Limit, uint64(3); got != want {
+			t.Errorf("take %d: expected %d to be %d", i, got, want)
+		}
+	}
+
+	// The key refills with the overridden interval.
+	s.Advance(time.Minute)
+	if res, _ := s.TakeWith(ctx, "a", 3, time.Hour); res.Allowed {
+		t.Error("expected key to be denied before the hour passes")
+	}
+	s.Advance(time.Hour)
+	if res, _ := s.TakeWith(ctx, "a", 3, time.Hour); !res.Allowed {
+		t.Error("expected key to be refilled")
+	}
+}
@@ -0,0 +1,48 @@
+package memorystore
+
+import (
+	"sync/atomic"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+var _ limiter.Debugger = (*store)(nil)
+
+// counters are the store's internal counters. They are allocated separately
+// from the store so that they are 64-bit aligned for atomic access.
+type counters struct {
+	takes   uint64
+	denials uint64
+
+	sweeps          uint64
+	purged          uint64
+	lastSweepNanos  uint64
+	totalSweepNanos uint64
+}
+
+// record counts the result of a take and returns it.
+func (s *store) record(res limiter.Result) limiter.Result {
+	atomic.AddUint64(&s.counters.takes, 1)
+	if !res.Allowed {
+		atomic.AddUint64(&s.counters.denials, 1)
+	}
+	return res
+}
+
+// DebugVars returns the number of takes and denials, the number of keys, and
+// statistics about the sweeps that purge stale keys.
+func (s *store) DebugVars() map[string]interface{} {
+	s.dataLock.RLock()
+	keys := len(s.data)
+	s.dataLock.RUnlock()
+
+	return map[string]interface{}{
+		"takes":             atomic.LoadUint64(&s.counters.takes),
+		"denials":           atomic.LoadUint64(&s.counters.denials),
+		"keys":              keys,
+		"sweeps":            atomic.LoadUint64(&s.counters.sweeps),
+		"purged":            atomic.LoadUint64(&s.counters.purged),
+		"last_sweep_nanos":  atomic.LoadUint64(&s.counters.lastSweepNanos),
+		"total_sweep_nanos": atomic.LoadUint64(&s.counters.totalSweepNanos),
+	}
+}
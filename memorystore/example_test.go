@@ -1,6 +1,7 @@
 package memorystore_test
 
 import (
+	"context"
 	"log"
 	"time"
 
@@ -17,6 +18,10 @@ func ExampleNew() {
 	}
 	defer store.Close()
 
-	limit, remaining, reset, ok := store.Take("my-key")
-	_, _, _, _ = limit, remaining, reset, ok
+	ctx := context.Background()
+	res, err := store.Take(ctx, "my-key")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, _, _, _ = res.Limit, res.Remaining, res.ResetAt, res.Allowed
 }
@@ -0,0 +1,149 @@
+package memorystore
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/internal/fasttime"
+	"github.com/sethvargo/go-limiter/internal/glob"
+	"github.com/sethvargo/go-limiter/internal/phase"
+)
+
+var _ limiter.Inspector = (*store)(nil)
+var _ limiter.Peeker = (*store)(nil)
+
+// keysPageSize is the number of keys examined per call to Keys.
+const keysPageSize = 100
+
+// Keys returns a page of the keys that match the glob-style pattern, which
+// uses the same syntax as Redis. The cursor is an offset into the sorted keys,
+// so keys may be skipped or repeated if others are added or purged between
+// calls.
+func (s *store) Keys(_ context.Context, pattern string, cursor uint64) ([]string, uint64, error) {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return nil, 0, limiter.ErrStopped
+	}
+
+	s.dataLock.RLock()
+	all := make([]string, 0, len(s.data))
+	for k := range s.data {
+		all = append(all, k)
+	}
+	s.dataLock.RUnlock()
+	sort.Strings(all)
+
+	if cursor >= uint64(len(all)) {
+		return nil, 0, nil
+	}
+
+	end := cursor + keysPageSize
+	if end > uint64(len(all)) {
+		end = uint64(len(all))
+	}
+
+	var keys []string
+	for _, k := range all[cursor:end] {
+		if glob.Match(pattern, k) {
+			keys = append(keys, k)
+		}
+	}
+
+	next := end
+	if next == uint64(len(all)) {
+		next = 0
+	}
+	return keys, next, nil
+}
+
+// Stats summarizes all keys. Remaining tokens are computed as if each key were
+// taken from now, without changing them.
+func (s *store) Stats(_ context.Context, n int) (limiter.Stats, error) {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.Stats{}, limiter.ErrStopped
+	}
+
+	s.dataLock.RLock()
+	all := make([]limiter.KeyStats, 0, len(s.data))
+	for k, b := range s.data {
+		all = append(all, limiter.KeyStats{
+			Key:       k,
+			Remaining: b.remaining(),
+		})
+	}
+	s.dataLock.RUnlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Remaining != all[j].Remaining {
+			return all[i].Remaining < all[j].Remaining
+		}
+		return all[i].Key < all[j].Key
+	})
+
+	stats := limiter.Stats{Keys: uint64(len(all))}
+	if n < 0 {
+		n = 0
+	}
+	if n < len(all) {
+		all = all[:n]
+	}
+	stats.Top = all
+	return stats, nil
+}
+
+// Peek returns the key's bucket as the next take would see it, without
+// changing it or creating it.
+func (s *store) Peek(_ context.Context, key string) (limiter.Result, error) {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.Result{}, limiter.ErrStopped
+	}
+
+	s.dataLock.RLock()
+	b, ok := s.data[key]
+	s.dataLock.RUnlock()
+
+	now := fasttime.Now()
+	if !ok {
+		start := now
+		if s.stagger {
+			start = phase.Start(key, now, s.interval)
+		}
+		return limiter.Result{
+			Limit:     s.tokens,
+			Remaining: s.tokens,
+			ResetAt:   time.Unix(0, int64(start)+int64(s.interval)),
+			Allowed:   true,
+		}, nil
+	}
+
+	currTick := tick(b.startTime, now, b.interval)
+	next := b.startTime + ((currTick + 1) * uint64(b.interval))
+	res := limiter.Result{
+		Limit:     b.maxTokens,
+		Remaining: b.remaining(),
+		ResetAt:   time.Unix(0, int64(next)),
+	}
+	if md := (*string)(atomic.LoadPointer(&b.metadata)); md != nil {
+		res.Metadata = *md
+	}
+	res.Allowed = res.Remaining > 0
+	if !res.Allowed {
+		res.RetryAfter = time.Duration(next - now)
+	}
+	return res, nil
+}
+
+// remaining returns the number of tokens that are available now, applying any
+// refill that is due without storing it.
+func (b *bucket) remaining() uint64 {
+	currTick := tick(b.startTime, fasttime.Now(), b.interval)
+
+	state := (*bucketState)(atomic.LoadPointer(&b.bucketState))
+	if state.lastTick < currTick {
+		tokens, _ := b.refill(state.lastTick, currTick, state.debt)
+		return tokens
+	}
+	return state.availableTokens
+}
@@ -0,0 +1,288 @@
+package memorystore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/sethvargo/go-limiter/bucketstate"
+)
+
+// Journal record flags.
+const (
+	journalKey    = 0
+	journalGlobal = 1
+)
+
+// maxJournalRecord is the largest valid record. Anything larger is garbage,
+// like a torn length.
+const maxJournalRecord = 1 << 20
+
+// journal is an append-only file of bucket states. Each record is the state of
+// one bucket after a change, so replaying the file in order leaves the latest
+// state of each bucket. A record is its uvarint length, then a flag byte, the
+// uvarint key length, the key, the state in bucketstate.Binary, and a CRC-32
+// of everything after the length.
+type journal struct {
+	path string
+
+	lock   sync.Mutex
+	f      *os.File
+	w      *bufio.Writer
+	err    error
+	closed bool
+}
+
+// journalEntry is a replayed bucket state.
+type journalEntry struct {
+	key    string
+	global bool
+	state  bucketstate.State
+}
+
+// openJournal replays the journal at path, creating it if it does not exist.
+// A record that is incomplete or corrupt, like one torn by a crash, ends the
+// replay.
+func openJournal(path string) (*journal, []journalEntry, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	entries, err := replayJournal(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("failed to replay journal: %w", err)
+	}
+
+	return &journal{
+		path: path,
+		f:    f,
+		w:    bufio.NewWriter(f),
+	}, entries, nil
+}
+
+// replayJournal reads the records of the journal until the end or the first
+// bad record.
+func replayJournal(r *bufio.Reader) ([]journalEntry, error) {
+	var entries []journalEntry
+	for {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return entries, nil
+			}
+			return nil, err
+		}
+
+		if n > maxJournalRecord {
+			return entries, nil
+		}
+
+		rec := make([]byte, n)
+		if _, err := io.ReadFull(r, rec); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return entries, nil
+			}
+			return nil, err
+		}
+
+		e, ok := decodeJournalRecord(rec)
+		if !ok {
+			return entries, nil
+		}
+		entries = append(entries, e)
+	}
+}
+
+func encodeJournalRecord(key string, global bool, st bucketstate.State) []byte {
+	state, _ := bucketstate.Binary.Marshal(st)
+
+	rec := make([]byte, 0, 1+binary.MaxVarintLen64+len(key)+len(state)+4)
+	flag := byte(journalKey)
+	if global {
+		flag = journalGlobal
+	}
+	rec = append(rec, flag)
+	rec = appendUvarint(rec, uint64(len(key)))
+	rec = append(rec, key...)
+	rec = append(rec, state...)
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(rec))
+	rec = append(rec, sum[:]...)
+
+	return append(appendUvarint(nil, uint64(len(rec))), rec...)
+}
+
+func decodeJournalRecord(rec []byte) (journalEntry, bool) {
+	if len(rec) < 5 {
+		return journalEntry{}, false
+	}
+	body, sum := rec[:len(rec)-4], rec[len(rec)-4:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(sum) {
+		return journalEntry{}, false
+	}
+
+	flag := body[0]
+	keyLen, n := binary.Uvarint(body[1:])
+	if n <= 0 || uint64(len(body)-1-n) < keyLen {
+		return journalEntry{}, false
+	}
+	key := string(body[1+n : 1+n+int(keyLen)])
+
+	st, err := bucketstate.Binary.Unmarshal(body[1+n+int(keyLen):])
+	if err != nil {
+		return journalEntry{}, false
+	}
+	return journalEntry{key: key, global: flag == journalGlobal, state: st}, true
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// record appends the state of the bucket. Write errors are kept and returned
+// by sync and close.
+func (j *journal) record(key string, global bool, b *bucket) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	// The state is read with the lock held, so records are in the order of
+	// the changes and the last one for a bucket is its latest state.
+	if j.err != nil || j.closed {
+		return
+	}
+	if _, err := j.w.Write(encodeJournalRecord(key, global, b.state())); err != nil {
+		j.err = fmt.Errorf("failed to write journal: %w", err)
+	}
+}
+
+// sync flushes the buffered records and syncs the file to disk.
+func (j *journal) sync() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	return j.syncLocked()
+}
+
+func (j *journal) syncLocked() error {
+	if j.closed {
+		return nil
+	}
+	if j.err != nil {
+		return j.err
+	}
+	if err := j.w.Flush(); err != nil {
+		j.err = fmt.Errorf("failed to flush journal: %w", err)
+		return j.err
+	}
+	if err := j.f.Sync(); err != nil {
+		j.err = fmt.Errorf("failed to sync journal: %w", err)
+		return j.err
+	}
+	return nil
+}
+
+// compact replaces the journal with a record for each of the given buckets,
+// which are read while records are blocked, so no change is lost. The new
+// journal is written next to the old one and renamed over it.
+func (j *journal) compact(each func(fn func(key string, global bool, b *bucket))) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.closed {
+		return nil
+	}
+	if j.err != nil {
+		return j.err
+	}
+
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create journal: %w", err)
+	}
+
+	w := bufio.NewWriter(f)
+	each(func(key string, global bool, b *bucket) {
+		if err == nil {
+			_, err = w.Write(encodeJournalRecord(key, global, b.state()))
+		}
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, j.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+
+	// Records go to the new file from now on. Anything buffered for the old
+	// one was a change to a bucket that was just rewritten.
+	j.f.Close()
+	j.f, j.w = f, w
+	return nil
+}
+
+// close syncs and closes the journal. Later records are dropped.
+func (j *journal) close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.closed {
+		return nil
+	}
+	err := j.syncLocked()
+	if cerr := j.f.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	j.closed = true
+	return err
+}
+
+// state returns the state of the bucket.
+func (b *bucket) state() bucketstate.State {
+	st := (*bucketState)(atomic.LoadPointer(&b.bucketState))
+	return bucketstate.State{
+		Start:  int64(b.startTime),
+		Tick:   st.lastTick,
+		Tokens: float64(st.availableTokens),
+		Debt:   float64(st.debt),
+	}
+}
+
+// restore sets the state of the bucket from a replayed state, capping the
+// tokens at the bucket's maximum in case the limit was lowered.
+func (b *bucket) restore(st bucketstate.State) {
+	tokens := uint64(0)
+	if st.Tokens > 0 {
+		tokens = uint64(st.Tokens)
+	}
+	if tokens > b.maxTokens {
+		tokens = b.maxTokens
+	}
+
+	b.startTime = uint64(st.Start)
+	atomic.StorePointer(&b.bucketState, unsafe.Pointer(&bucketState{
+		availableTokens: tokens,
+		lastTick:        st.Tick,
+		debt:            uint64(st.Debt),
+	}))
+}
@@ -0,0 +1,125 @@
+package memorystore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore_Journal(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "memorystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	ctx := context.Background()
+	open := func(tb testing.TB) *store {
+		s, err := New(&Config{
+			Tokens:       5,
+			Interval:     time.Hour,
+			GlobalTokens: 20,
+			Journal:      path,
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return s.(*store)
+	}
+
+	take := func(tb testing.TB, s *store, key string) uint64 {
+		res, err := s.Take(ctx, key)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return res.Remaining
+	}
+
+	s := open(t)
+	for i := 0; i < 3; i++ {
+		take(t, s, "login:alice")
+	}
+	take(t, s, "login:bob")
+	if err := s.Charge(ctx, "login:carol", 4); err != nil {
+		t.Fatal(err)
+	}
+	take(t, s, "login:dave")
+	if err := s.Delete(ctx, "login:dave"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The buckets survive a restart.
+	s = open(t)
+	if got, want := take(t, s, "login:alice"), uint64(1); got != want {
+		t.Errorf("alice: expected %d to be %d", got, want)
+	}
+	if got, want := take(t, s, "login:bob"), uint64(3); got != want {
+		t.Errorf("bob: expected %d to be %d", got, want)
+	}
+	if got, want := take(t, s, "login:carol"), uint64(0); got != want {
+		t.Errorf("carol: expected %d to be %d", got, want)
+	}
+	if got, want := take(t, s, "login:dave"), uint64(4); got != want {
+		t.Errorf("dave: expected %d to be %d", got, want)
+	}
+	global := (*bucketState)(s.global.bucketState).availableTokens
+	if got, want := global, uint64(20-8-5); got != want {
+		t.Errorf("global: expected %d to be %d", got, want)
+	}
+
+	// A crash loses nothing that was synced, and a torn record at the end is
+	// ignored.
+	if err := s.journal.sync(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{40, 0, 5, 'l', 'o'}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s2 := open(t)
+	defer s2.Close()
+	if got, want := take(t, s2, "login:alice"), uint64(0); got != want {
+		t.Errorf("alice after crash: expected %d to be %d", got, want)
+	}
+	s.Close()
+}
+
+func TestJournalRecord(t *testing.T) {
+	t.Parallel()
+
+	b := newBucket(5, time.Minute, float64(time.Minute)/5)
+	b.take(0, 0)
+
+	rec := encodeJournalRecord("key", false, b.state())
+
+	// Skip the length prefix.
+	e, ok := decodeJournalRecord(rec[1:])
+	if !ok {
+		t.Fatal("expected record to decode")
+	}
+	if got, want := e.key, "key"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if got, want := e.state, b.state(); got != want {
+		t.Errorf("expected %#v to be %#v", got, want)
+	}
+
+	rec[len(rec)-1] ^= 0xff
+	if _, ok := decodeJournalRecord(rec[1:]); ok {
+		t.Error("expected corrupt record to fail")
+	}
+}
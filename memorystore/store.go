@@ -2,6 +2,8 @@
 package memorystore
 
 import (
+	"context"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -9,21 +11,52 @@ import (
 
 	"github.com/sethvargo/go-limiter"
 	"github.com/sethvargo/go-limiter/internal/fasttime"
+	"github.com/sethvargo/go-limiter/internal/phase"
 )
 
 var _ limiter.Store = (*store)(nil)
+var _ limiter.Refunder = (*store)(nil)
+var _ limiter.Charger = (*store)(nil)
+var _ limiter.Annotator = (*store)(nil)
+var _ limiter.Deleter = (*store)(nil)
 
 type store struct {
 	tokens   uint64
 	interval time.Duration
 	rate     float64
 
+	// stagger offsets the start of each key's intervals by a hash of the key.
+	stagger bool
+
 	sweepInterval time.Duration
 	sweepMinTTL   uint64
+	fixedTTL      bool
+
+	// onExpire is called with each purged key, if set.
+	onExpire func(key string)
 
 	data     map[string]*bucket
 	dataLock sync.RWMutex
 
+	// global is the bucket shared by all keys, or nil if it is disabled.
+	// globalLock serializes takes that involve it.
+	global     *bucket
+	globalLock sync.Mutex
+
+	// reserve and globalReserve are the number of tokens in the per-key and
+	// global buckets that low-priority takes cannot use.
+	reserve       uint64
+	globalReserve uint64
+
+	// debtLimit is the number of tokens a per-key bucket may borrow.
+	debtLimit uint64
+
+	counters *counters
+
+	// journal records the changes to the buckets, or is nil if it is
+	// disabled.
+	journal *journal
+
 	stopped uint32
 	stopCh  chan struct{}
 }
@@ -39,6 +72,37 @@ type Config struct {
 	// default value is 1 second.
 	Interval time.Duration
 
+	// GlobalTokens, if set, enables a global bucket shared by all keys, like an
+	// overall service capacity. Each take also takes a token from the global
+	// bucket, atomically with the per-key bucket, and is rejected if either is
+	// exhausted. Takes are serialized while the global bucket is enabled.
+	// GlobalInterval is the global bucket's interval. The default is Interval.
+	GlobalTokens   uint64
+	GlobalInterval time.Duration
+
+	// ReservedFraction is the fraction of each bucket's tokens reserved for
+	// high-priority takes. Low-priority takes (see limiter.WithPriority) are
+	// rejected once the remaining tokens fall to the reserve, while
+	// high-priority takes may use all of them. It must be in [0, 1). The
+	// default value is 0, which treats all priorities the same.
+	ReservedFraction float64
+
+	// DebtLimit is the number of tokens a key may borrow once its bucket is
+	// empty. Borrowed takes are allowed, and later refills pay down the debt
+	// before new tokens become available, which smooths enforcement for
+	// clients that slightly exceed their rate. Low-priority takes and the
+	// global bucket never borrow. The default value is 0, which disables
+	// borrowing.
+	DebtLimit uint64
+
+	// StaggerResets, if true, aligns the intervals of each key to an offset
+	// derived from a hash of the key, instead of starting them when the key is
+	// first seen, so keys created together, like after a restart or a burst
+	// of new clients, do not all refill at the same instant. The first
+	// interval of a key is shorter than Interval, and the key refills at the
+	// same times in every store with the same Interval.
+	StaggerResets bool
+
 	// SweepInterval is the rate at which to run the garabage collection on stale
 	// entries. Setting this to a low value will optimize memory consumption, but
 	// will likely reduce performance and increase lock contention. Setting this
@@ -53,6 +117,30 @@ type Config struct {
 	// before they limit is applied. The default value is 12 hours.
 	SweepMinTTL time.Duration
 
+	// TTLMode controls whether SweepMinTTL is measured from a key's last take
+	// or from its first. With limiter.TTLFixed, a key is purged on the first
+	// sweep after SweepMinTTL has passed since it was created, even if it is
+	// still in use. The default is limiter.TTLSliding.
+	TTLMode limiter.TTLMode
+
+	// OnExpire, if set, is called with each key the sweeper purges, so
+	// applications can clean up state correlated with the key. It is called
+	// from the sweeper goroutine after the sweep, and should not block.
+	OnExpire func(key string)
+
+	// Journal, if set, is the path of an append-only file that records every
+	// change to a bucket, so single-node deployments keep security-sensitive
+	// counters, like failed login attempts, across restarts and crashes. New
+	// replays the journal, and it is compacted to the current buckets then and
+	// after each sweep. Tokens are capped at the configured limit when they are
+	// replayed.
+	Journal string
+
+	// JournalSyncInterval is how often the journal is written and synced to
+	// disk. Changes since the last sync are lost in a crash. The default value
+	// is 1 second.
+	JournalSyncInterval time.Duration
+
 	// InitialAlloc is the size to use for the in-memory map. Go will
 	// automatically expand the buffer, but choosing higher number can trade
 	// memory consumption for performance as it limits the number of times the map
@@ -88,6 +176,10 @@ func New(c *Config) (limiter.Store, error) {
 		sweepMinTTL = c.SweepMinTTL
 	}
 
+	if c.TTLMode != limiter.TTLSliding && c.TTLMode != limiter.TTLFixed {
+		return nil, fmt.Errorf("unknown ttl mode %d", c.TTLMode)
+	}
+
 	initialAlloc := 4096
 	if c.InitialAlloc > 0 {
 		initialAlloc = c.InitialAlloc
@@ -97,32 +189,138 @@ func New(c *Config) (limiter.Store, error) {
 		tokens:   tokens,
 		interval: interval,
 		rate:     float64(interval) / float64(tokens),
+		stagger:  c.StaggerResets,
 
 		sweepInterval: sweepInterval,
 		sweepMinTTL:   uint64(sweepMinTTL),
+		fixedTTL:      c.TTLMode == limiter.TTLFixed,
+		onExpire:      c.OnExpire,
 
-		data:   make(map[string]*bucket, initialAlloc),
-		stopCh: make(chan struct{}),
+		data:     make(map[string]*bucket, initialAlloc),
+		counters: new(counters),
+		stopCh:   make(chan struct{}),
+	}
+	if c.ReservedFraction < 0 || c.ReservedFraction >= 1 {
+		return nil, fmt.Errorf("reserved fraction must be in [0, 1)")
 	}
+	s.reserve = uint64(float64(tokens) * c.ReservedFraction)
+	s.globalReserve = uint64(float64(c.GlobalTokens) * c.ReservedFraction)
+	s.debtLimit = c.DebtLimit
+
+	if c.GlobalTokens > 0 {
+		globalInterval := interval
+		if c.GlobalInterval > 0 {
+			globalInterval = c.GlobalInterval
+		}
+		s.global = newBucket(c.GlobalTokens, globalInterval,
+			float64(globalInterval)/float64(c.GlobalTokens))
+	}
+
+	if c.Journal != "" {
+		syncInterval := 1 * time.Second
+		if c.JournalSyncInterval > 0 {
+			syncInterval = c.JournalSyncInterval
+		}
+		if err := s.openJournal(c.Journal); err != nil {
+			return nil, err
+		}
+		go s.syncJournal(syncInterval)
+	}
+
 	go s.purge()
 	return s, nil
 }
 
+// openJournal replays the journal into the buckets and compacts it.
+func (s *store) openJournal(path string) error {
+	j, entries, err := openJournal(path)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.global {
+			if s.global != nil {
+				s.global.restore(e.state)
+			}
+			continue
+		}
+
+		b, ok := s.data[e.key]
+		if !ok {
+			b = s.newBucket(e.key)
+			s.data[e.key] = b
+		}
+		b.restore(e.state)
+	}
+
+	s.journal = j
+	if err := s.compactJournal(); err != nil {
+		j.close()
+		return err
+	}
+	return nil
+}
+
+// compactJournal rewrites the journal with the current buckets.
+func (s *store) compactJournal() error {
+	return s.journal.compact(func(fn func(key string, global bool, b *bucket)) {
+		s.dataLock.RLock()
+		defer s.dataLock.RUnlock()
+
+		for k, b := range s.data {
+			fn(k, false, b)
+		}
+		if s.global != nil {
+			fn("", true, s.global)
+		}
+	})
+}
+
+// syncJournal syncs the journal on the interval until the store is closed.
+func (s *store) syncJournal(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+		}
+		_ = s.journal.sync()
+	}
+}
+
+// log records the bucket, and the global bucket if enabled, in the journal.
+func (s *store) log(key string, b *bucket) {
+	if s.journal == nil {
+		return
+	}
+	s.journal.record(key, false, b)
+	if s.global != nil {
+		s.journal.record("", true, s.global)
+	}
+}
+
 // Take attempts to remove a token from the named key. If the take is
 // successful, it returns true, otherwise false. It also returns the configured
-// limit, remaining tokens, and reset time.
-func (s *store) Take(key string) (uint64, uint64, uint64, bool) {
+// limit, remaining tokens, and reset time. The only error it returns is
+// limiter.ErrStopped after the store is closed.
+func (s *store) Take(ctx context.Context, key string) (limiter.Result, error) {
 	// If the store is stopped, all requests are rejected.
 	if atomic.LoadUint32(&s.stopped) == 1 {
-		return 0, 0, 0, false
+		return limiter.Result{}, limiter.ErrStopped
 	}
 
+	low := limiter.PriorityFromContext(ctx) == limiter.PriorityLow
+
 	// Acquire a read lock first - this allows other to concurrently check limits
 	// without taking a full lock.
 	s.dataLock.RLock()
 	if b, ok := s.data[key]; ok {
 		s.dataLock.RUnlock()
-		return b.take()
+		return s.record(s.take(key, b, low)), nil
 	}
 	s.dataLock.RUnlock()
 
@@ -132,22 +330,179 @@ func (s *store) Take(key string) (uint64, uint64, uint64, bool) {
 	s.dataLock.Lock()
 	if b, ok := s.data[key]; ok {
 		s.dataLock.Unlock()
-		return b.take()
+		return s.record(s.take(key, b, low)), nil
 	}
 
 	// This is the first time we've seen this entry (or it's been garbage
 	// collected), so create the bucket and take an initial request.
-	b := newBucket(s.tokens, s.interval, s.rate)
+	b := s.newBucket(key)
 
 	// Add it to the map and take.
 	s.data[key] = b
 	s.dataLock.Unlock()
-	return b.take()
+	return s.record(s.take(key, b, low)), nil
+}
+
+// newBucket creates a full bucket for the key, starting its intervals at the
+// key's offset if resets are staggered.
+func (s *store) newBucket(key string) *bucket {
+	b := newBucket(s.tokens, s.interval, s.rate)
+	if s.stagger {
+		b.startTime = phase.Start(key, b.startTime, s.interval)
+	}
+	return b
+}
+
+// take takes a token from the bucket and, if enabled, the global bucket. If
+// the bucket is exhausted, the result is its own. Otherwise, if the global
+// bucket is exhausted, the result has the global bucket's reset time.
+// Low-priority takes leave the reserved tokens in both buckets and never
+// borrow.
+func (s *store) take(key string, b *bucket, low bool) limiter.Result {
+	defer s.log(key, b)
+
+	reserve, globalReserve, debtLimit := uint64(0), uint64(0), s.debtLimit
+	if low {
+		reserve, globalReserve, debtLimit = s.reserve, s.globalReserve, 0
+	}
+
+	if s.global == nil {
+		return b.take(reserve, debtLimit)
+	}
+
+	// Hold the lock across both takes, so no other caller can observe a token
+	// that was taken from the bucket only to be refunded.
+	s.globalLock.Lock()
+	defer s.globalLock.Unlock()
+
+	res := b.take(reserve, debtLimit)
+	if !res.Allowed {
+		return res
+	}
+
+	g := s.global.take(globalReserve, 0)
+	if !g.Allowed {
+		b.refund(1)
+		return limiter.Result{
+			Limit:      res.Limit,
+			ResetAt:    g.ResetAt,
+			RetryAfter: g.RetryAfter,
+		}
+	}
+
+	if g.Remaining < res.Remaining {
+		res.Remaining = g.Remaining
+	}
+	return res
+}
+
+// Refund returns tokens to the named key, and the global bucket if enabled, up
+// to the configured limits. Tokens are only returned to the current interval.
+// Refunding a key that does not exist is a no-op. The only error it returns is
+// limiter.ErrStopped after the store is closed.
+func (s *store) Refund(_ context.Context, key string, tokens uint64) error {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.ErrStopped
+	}
+
+	s.dataLock.RLock()
+	b, ok := s.data[key]
+	s.dataLock.RUnlock()
+	if ok {
+		b.refund(tokens)
+	}
+	if s.global != nil {
+		s.global.refund(tokens)
+	}
+	if ok {
+		s.log(key, b)
+	} else if s.journal != nil && s.global != nil {
+		s.journal.record("", true, s.global)
+	}
+	return nil
+}
+
+// Charge takes tokens from the named key, and the global bucket if enabled,
+// down to zero, without checking the limits. Charging a key that does not
+// exist creates it. The only error it returns is limiter.ErrStopped after the
+// store is closed.
+func (s *store) Charge(_ context.Context, key string, tokens uint64) error {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.ErrStopped
+	}
+
+	s.dataLock.RLock()
+	b, ok := s.data[key]
+	s.dataLock.RUnlock()
+	if !ok {
+		s.dataLock.Lock()
+		if b, ok = s.data[key]; !ok {
+			b = s.newBucket(key)
+			s.data[key] = b
+		}
+		s.dataLock.Unlock()
+	}
+
+	b.charge(tokens)
+	if s.global != nil {
+		s.global.charge(tokens)
+	}
+	s.log(key, b)
+	return nil
+}
+
+// Delete deletes the named key, so its next take starts with full tokens. The
+// global bucket is not reset. If the journal is enabled, it is compacted, so the
+// key is not restored when the journal is replayed. It returns
+// limiter.ErrStopped after the store is closed, and any error compacting the
+// journal.
+func (s *store) Delete(_ context.Context, key string) error {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.ErrStopped
+	}
+
+	s.dataLock.Lock()
+	_, ok := s.data[key]
+	delete(s.data, key)
+	s.dataLock.Unlock()
+
+	if ok && s.journal != nil {
+		if err := s.compactJournal(); err != nil {
+			return fmt.Errorf("failed to compact journal: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetMetadata stores the metadata with the key, creating it if it does not
+// exist. The metadata is purged with the key, and is not recorded in the
+// journal. The only error it returns is limiter.ErrStopped after the store is
+// closed.
+func (s *store) SetMetadata(_ context.Context, key, metadata string) error {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.ErrStopped
+	}
+
+	s.dataLock.RLock()
+	b, ok := s.data[key]
+	s.dataLock.RUnlock()
+	if !ok {
+		s.dataLock.Lock()
+		if b, ok = s.data[key]; !ok {
+			b = s.newBucket(key)
+			s.data[key] = b
+		}
+		s.dataLock.Unlock()
+	}
+
+	atomic.StorePointer(&b.metadata, unsafe.Pointer(&metadata))
+	return nil
 }
 
 // Close stops the memory limiter and cleans up any outstanding sessions. You
 // should absolutely always call Close() as it releases the memory consumed by
-// the map AND releases the tickers.
+// the map AND releases the tickers. If the journal is enabled, it is synced
+// and closed first, and any error writing it is returned.
 func (s *store) Close() error {
 	if !atomic.CompareAndSwapUint32(&s.stopped, 0, 1) {
 		return nil
@@ -156,13 +511,19 @@ func (s *store) Close() error {
 	// Close the channel to prevent future purging.
 	close(s.stopCh)
 
+	// Close the journal before deleting the buckets, so it keeps them.
+	var err error
+	if s.journal != nil {
+		err = s.journal.close()
+	}
+
 	// Delete all the things.
 	s.dataLock.Lock()
 	for k := range s.data {
 		delete(s.data, k)
 	}
 	s.dataLock.Unlock()
-	return nil
+	return err
 }
 
 // purge continually iterates over the map and purges old values on the provided
@@ -181,17 +542,44 @@ func (s *store) purge() {
 		case <-ticker.C:
 		}
 
+		var expired []string
+		var purged uint64
+
+		start := time.Now()
 		s.dataLock.Lock()
 		now := fasttime.Now()
 		for k, b := range s.data {
-			lastTick := (*bucketState)(atomic.LoadPointer(&b.bucketState)).lastTick
-			lastTime := b.startTime + (lastTick * uint64(b.interval))
+			lastTime := b.startTime
+			if !s.fixedTTL {
+				lastTick := (*bucketState)(atomic.LoadPointer(&b.bucketState)).lastTick
+				lastTime += lastTick * uint64(b.interval)
+			}
 
 			if now-lastTime > s.sweepMinTTL {
 				delete(s.data, k)
+				purged++
+				if s.onExpire != nil {
+					expired = append(expired, k)
+				}
 			}
 		}
 		s.dataLock.Unlock()
+
+		d := uint64(time.Since(start))
+		atomic.AddUint64(&s.counters.sweeps, 1)
+		atomic.AddUint64(&s.counters.purged, purged)
+		atomic.StoreUint64(&s.counters.lastSweepNanos, d)
+		atomic.AddUint64(&s.counters.totalSweepNanos, d)
+
+		// The journal drops the purged keys.
+		if s.journal != nil {
+			_ = s.compactJournal()
+		}
+
+		// Callbacks run without the lock, so they may use the store.
+		for _, k := range expired {
+			s.onExpire(k)
+		}
 	}
 }
 
@@ -216,6 +604,10 @@ type bucket struct {
 	// fillRate is the number of tokens to add per nanosecond. It is calculated
 	// based on the provided maxTokens and interval.
 	fillRate float64
+
+	// metadata is the *string set with SetMetadata, or nil. It should always
+	// be loaded with atomic.
+	metadata unsafe.Pointer
 }
 
 // bucketState represents the internal bucket state.
@@ -227,6 +619,10 @@ type bucketState struct {
 	// lastTick is the last clock tick, used to re-calculate the number of tokens
 	// on the bucket.
 	lastTick uint64
+
+	// debt is the number of tokens borrowed since the bucket was last empty.
+	// Refills pay it down before new tokens become available.
+	debt uint64
 }
 
 // newBucket creates a new bucket from the given tokens and interval.
@@ -246,9 +642,12 @@ func newBucket(tokens uint64, interval time.Duration, rate float64) *bucket {
 
 // take attempts to remove a token from the bucket. If there are no tokens
 // available and the clock has ticked forward, it recalculates the number of
-// tokens and retries. It returns the limit, remaining tokens, time until
-// refresh, and whether the take was successful.
-func (b *bucket) take() (uint64, uint64, uint64, bool) {
+// tokens and retries. It returns the limit, remaining tokens, time of the next
+// refresh, and whether the take was successful. The time until the refresh is
+// computed from the same clock reading used to make the decision. The take
+// fails if it would leave fewer than reserve tokens. Once the bucket is empty,
+// the take borrows against future refills until the debt reaches debtLimit.
+func (b *bucket) take(reserve, debtLimit uint64) limiter.Result {
 	// Capture the current request time, current tick, and amount of time until
 	// the bucket resets.
 	now := fasttime.Now()
@@ -260,36 +659,166 @@ func (b *bucket) take() (uint64, uint64, uint64, bool) {
 		currState := (*bucketState)(curr)
 		lastTick := currState.lastTick
 		tokens := currState.availableTokens
+		debt := currState.debt
 
 		if lastTick < currTick {
-			tokens = availableTokens(currState.lastTick, currTick, b.maxTokens, b.fillRate)
+			tokens, debt = b.refill(currState.lastTick, currTick, debt)
 			lastTick = currTick
 
 			if !atomic.CompareAndSwapPointer(&b.bucketState, curr, unsafe.Pointer(&bucketState{
 				availableTokens: tokens,
 				lastTick:        lastTick,
+				debt:            debt,
 			})) {
 				// Someone else modified the value
 				continue
 			}
 		}
 
-		if tokens > 0 {
+		if tokens > reserve {
 			tokens--
 			if !atomic.CompareAndSwapPointer(&b.bucketState, curr, unsafe.Pointer(&bucketState{
 				availableTokens: tokens,
 				lastTick:        lastTick,
+				debt:            debt,
 			})) {
 				// There were tokens left, but someone took them :(
 				continue
 			}
 
-			return b.maxTokens, tokens, next, true
+			return limiter.Result{
+				Limit:     b.maxTokens,
+				Remaining: tokens,
+				ResetAt:   time.Unix(0, int64(next)),
+				Allowed:   true,
+			}
+		}
+
+		// The bucket is empty, but it may borrow against future refills.
+		if debt < debtLimit {
+			if !atomic.CompareAndSwapPointer(&b.bucketState, curr, unsafe.Pointer(&bucketState{
+				availableTokens: tokens,
+				lastTick:        lastTick,
+				debt:            debt + 1,
+			})) {
+				continue
+			}
+
+			return limiter.Result{
+				Limit:     b.maxTokens,
+				Remaining: 0,
+				ResetAt:   time.Unix(0, int64(next)),
+				Allowed:   true,
+			}
 		}
 
 		// Returning the TTL until next tick.
-		return b.maxTokens, 0, next, false
+		return limiter.Result{
+			Limit:      b.maxTokens,
+			Remaining:  0,
+			ResetAt:    time.Unix(0, int64(next)),
+			Allowed:    false,
+			RetryAfter: time.Duration(next - now),
+		}
+	}
+}
+
+// refund adds tokens back to the bucket, up to the maximum. Any debt is paid
+// down first. If the clock has ticked forward since the last take, the bucket
+// is refilled first, so tokens taken in an earlier interval are not returned
+// on top of the refill.
+func (b *bucket) refund(n uint64) {
+	now := fasttime.Now()
+	currTick := tick(b.startTime, now, b.interval)
+
+	for {
+		curr := atomic.LoadPointer(&b.bucketState)
+		currState := (*bucketState)(curr)
+		lastTick := currState.lastTick
+		tokens := currState.availableTokens
+		debt := currState.debt
+
+		if lastTick < currTick {
+			tokens, debt = b.refill(lastTick, currTick, debt)
+			lastTick = currTick
+		}
+
+		if n <= debt {
+			debt -= n
+		} else {
+			n -= debt
+			debt = 0
+
+			if n > b.maxTokens-tokens {
+				tokens = b.maxTokens
+			} else {
+				tokens += n
+			}
+		}
+
+		if atomic.CompareAndSwapPointer(&b.bucketState, curr, unsafe.Pointer(&bucketState{
+			availableTokens: tokens,
+			lastTick:        lastTick,
+			debt:            debt,
+		})) {
+			return
+		}
+	}
+}
+
+// charge removes up to n tokens from the bucket, refilling it first if the
+// clock has ticked forward since the last take. Debt is left alone, since the
+// tokens are taken, not borrowed.
+func (b *bucket) charge(n uint64) {
+	now := fasttime.Now()
+	currTick := tick(b.startTime, now, b.interval)
+
+	for {
+		curr := atomic.LoadPointer(&b.bucketState)
+		currState := (*bucketState)(curr)
+		lastTick := currState.lastTick
+		tokens := currState.availableTokens
+		debt := currState.debt
+
+		if lastTick < currTick {
+			tokens, debt = b.refill(lastTick, currTick, debt)
+			lastTick = currTick
+		}
+
+		if n >= tokens {
+			tokens = 0
+		} else {
+			tokens -= n
+		}
+
+		if atomic.CompareAndSwapPointer(&b.bucketState, curr, unsafe.Pointer(&bucketState{
+			availableTokens: tokens,
+			lastTick:        lastTick,
+			debt:            debt,
+		})) {
+			return
+		}
+	}
+}
+
+// refill returns the number of available tokens and the remaining debt after
+// the clock ticked from last to curr. Debt is paid down first, by up to
+// maxTokens per elapsed interval, before tokens become available again.
+func (b *bucket) refill(last, curr, debt uint64) (uint64, uint64) {
+	if debt == 0 {
+		return availableTokens(last, curr, b.maxTokens, b.fillRate), 0
+	}
+
+	budget := float64(curr-last) * float64(b.maxTokens)
+	if budget <= float64(debt) {
+		return 0, debt - uint64(budget)
+	}
+
+	tokens := budget - float64(debt)
+	if tokens > float64(b.maxTokens) {
+		tokens = float64(b.maxTokens)
 	}
+	return uint64(tokens), 0
 }
 
 // availableTokens returns the number of available tokens, up to max, between
@@ -1,6 +1,7 @@
 package memorystore
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
@@ -8,7 +9,8 @@ import (
 	"testing"
 	"time"
 
-	"github.com/sethvargo/go-limiter/internal/fasttime"
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/storetest"
 )
 
 func testKey(tb testing.TB) string {
@@ -25,6 +27,8 @@ func testKey(tb testing.TB) string {
 func TestStore_Take(t *testing.T) {
 	t.Parallel()
 
+	ctx := context.Background()
+
 	cases := []struct {
 		name     string
 		tokens   uint64
@@ -71,8 +75,11 @@ func TestStore_Take(t *testing.T) {
 			takeCh := make(chan *result, 2*tc.tokens)
 			for i := uint64(1); i <= 2*tc.tokens; i++ {
 				go func() {
-					limit, remaining, reset, ok := s.Take(key)
-					takeCh <- &result{limit, remaining, time.Duration(fasttime.Now() - reset), ok}
+					res, err := s.Take(ctx, key)
+					if err != nil {
+						t.Error(err)
+					}
+					takeCh <- &result{res.Limit, res.Remaining, time.Until(res.ResetAt), res.Allowed}
 				}()
 			}
 
@@ -123,13 +130,381 @@ func TestStore_Take(t *testing.T) {
 			time.Sleep(tc.interval)
 
 			// Verify we can take once more.
-			if _, _, _, ok := s.Take(key); !ok {
-				t.Errorf("expected %t to be %t", ok, true)
+			if res, _ := s.Take(ctx, key); !res.Allowed {
+				t.Errorf("expected %t to be %t", res.Allowed, true)
+			}
+		})
+	}
+}
+
+func testStoreFactory(tb testing.TB, c *storetest.Config) limiter.Store {
+	tb.Helper()
+
+	s, err := New(&Config{
+		Tokens:        c.Tokens,
+		Interval:      c.Interval,
+		SweepInterval: c.TTL / 4,
+		SweepMinTTL:   c.TTL,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+func TestStore_Conformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.TestStore(t, testStoreFactory)
+}
+
+func BenchmarkStore(b *testing.B) {
+	storetest.BenchmarkStore(b, testStoreFactory)
+}
+
+func TestStore_Global(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(&Config{
+		Tokens:       3,
+		Interval:     time.Minute,
+		GlobalTokens: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	key1, key2 := testKey(t), testKey(t)
+
+	cases := []struct {
+		key       string
+		allowed   bool
+		remaining uint64
+	}{
+		{key: key1, allowed: true, remaining: 2},
+		{key: key1, allowed: true, remaining: 1},
+		{key: key1, allowed: true, remaining: 0},
+		{key: key2, allowed: true, remaining: 0},
+		{key: key2, allowed: false, remaining: 0},
+		{key: key1, allowed: false, remaining: 0},
+	}
+
+	for i, tc := range cases {
+		res, err := s.Take(ctx, tc.key)
+		if err != nil {
+			t.Fatalf("take %d: %v", i, err)
+		}
+		if got, want := res.Allowed, tc.allowed; got != want {
+			t.Errorf("take %d: allowed: expected %t to be %t", i, got, want)
+		}
+		if got, want := res.Remaining, tc.remaining; got != want {
+			t.Errorf("take %d: remaining: expected %d to be %d", i, got, want)
+		}
+		if got, want := res.Limit, uint64(3); got != want {
+			t.Errorf("take %d: limit: expected %d to be %d", i, got, want)
+		}
+	}
+
+	// Rejecting the take on the global bucket must not spend the key's token.
+	if err := s.(limiter.Refunder).Refund(ctx, key1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := s.Take(ctx, key2); !res.Allowed || err != nil {
+		t.Errorf("expected take after global refund to succeed, got %t, %v", res.Allowed, err)
+	}
+	if res, err := s.Take(ctx, key2); res.Allowed || err != nil {
+		t.Errorf("expected take to be denied by global bucket, got %t, %v", res.Allowed, err)
+	}
+}
+
+func TestStore_Priority(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(&Config{
+		Tokens:           10,
+		Interval:         time.Minute,
+		ReservedFraction: 0.3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	low := limiter.WithPriority(ctx, limiter.PriorityLow)
+	key := testKey(t)
+
+	for i := 0; i < 7; i++ {
+		if res, err := s.Take(low, key); !res.Allowed || err != nil {
+			t.Fatalf("low take %d: expected to succeed, got %t, %v", i, res.Allowed, err)
+		}
+	}
+	if res, err := s.Take(low, key); res.Allowed || err != nil {
+		t.Fatalf("expected low take to be rejected at the reserve, got %t, %v", res.Allowed, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if res, err := s.Take(ctx, key); !res.Allowed || err != nil {
+			t.Fatalf("high take %d: expected to succeed, got %t, %v", i, res.Allowed, err)
+		}
+	}
+	if res, err := s.Take(ctx, key); res.Allowed || err != nil {
+		t.Fatalf("expected high take to be rejected when empty, got %t, %v", res.Allowed, err)
+	}
+}
+
+func TestStore_StaggerResets(t *testing.T) {
+	t.Parallel()
+
+	newStore := func(tb testing.TB) limiter.Store {
+		s, err := New(&Config{
+			Tokens:        10,
+			Interval:      time.Hour,
+			StaggerResets: true,
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { s.Close() })
+		return s
+	}
+	s1, s2 := newStore(t), newStore(t)
+
+	ctx := context.Background()
+	resets := make(map[time.Time]struct{})
+	for i := 0; i < 10; i++ {
+		key := testKey(t)
+
+		peek, err := s1.(limiter.Peeker).Peek(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := s1.Take(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Until(res.ResetAt); d <= 0 || d > time.Hour {
+			t.Errorf("expected reset within an interval, got %s", d)
+		}
+		if got, want := peek.ResetAt, res.ResetAt; !got.Equal(want) {
+			t.Errorf("expected peek %s to be %s", got, want)
+		}
+
+		// The key resets at the same time in every store.
+		other, err := s2.Take(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := other.ResetAt, res.ResetAt; !got.Equal(want) {
+			t.Errorf("expected %s to be %s", got, want)
+		}
+		resets[res.ResetAt] = struct{}{}
+	}
+
+	if len(resets) < 2 {
+		t.Errorf("expected keys to reset at different times")
+	}
+}
+
+func TestStore_SetMetadata(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(&Config{
+		Tokens:   10,
+		Interval: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	key := testKey(t)
+
+	// Setting the metadata creates the key with full tokens.
+	if err := s.(limiter.Annotator).SetMetadata(ctx, key, "plan=free"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Take(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.(limiter.Peeker).Peek(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Metadata, "plan=free"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if got, want := res.Remaining, uint64(9); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	s.Close()
+	if err := s.(limiter.Annotator).SetMetadata(ctx, key, "plan=paid"); err != limiter.ErrStopped {
+		t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+}
+
+func TestStore_Debt(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(&Config{
+		Tokens:    2,
+		Interval:  time.Minute,
+		DebtLimit: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	key := testKey(t)
+
+	for i := 0; i < 4; i++ {
+		res, err := s.Take(ctx, key)
+		if !res.Allowed || err != nil {
+			t.Fatalf("take %d: expected to succeed, got %t, %v", i, res.Allowed, err)
+		}
+		if i >= 2 {
+			if got, want := res.Remaining, uint64(0); got != want {
+				t.Errorf("take %d: remaining: expected %d to be %d", i, got, want)
+			}
+		}
+	}
+	if res, err := s.Take(ctx, key); res.Allowed || err != nil {
+		t.Fatalf("expected take to be rejected at the debt limit, got %t, %v", res.Allowed, err)
+	}
+
+	// Refunds pay down the debt before returning tokens.
+	if err := s.(limiter.Refunder).Refund(ctx, key, 1); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := s.Take(ctx, key); !res.Allowed || err != nil {
+		t.Fatalf("expected take to borrow after refund, got %t, %v", res.Allowed, err)
+	}
+	if res, err := s.Take(ctx, key); res.Allowed || err != nil {
+		t.Fatalf("expected take to be rejected at the debt limit, got %t, %v", res.Allowed, err)
+	}
+
+	// Low-priority takes never borrow.
+	low := limiter.WithPriority(ctx, limiter.PriorityLow)
+	lowKey := testKey(t) + "-low"
+	for i := 0; i < 2; i++ {
+		if res, err := s.Take(low, lowKey); !res.Allowed || err != nil {
+			t.Fatalf("low take %d: expected to succeed, got %t, %v", i, res.Allowed, err)
+		}
+	}
+	if res, err := s.Take(low, lowKey); res.Allowed || err != nil {
+		t.Fatalf("expected low take to be rejected when empty, got %t, %v", res.Allowed, err)
+	}
+}
+
+func TestStore_TTLMode(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		mode   limiter.TTLMode
+		purged bool
+	}{
+		{
+			name:   "sliding",
+			mode:   limiter.TTLSliding,
+			purged: false,
+		},
+		{
+			name:   "fixed",
+			mode:   limiter.TTLFixed,
+			purged: true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ls, err := New(&Config{
+				Tokens:        10,
+				Interval:      20 * time.Millisecond,
+				SweepInterval: 10 * time.Millisecond,
+				SweepMinTTL:   100 * time.Millisecond,
+				TTLMode:       tc.mode,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ls.Close()
+			s := ls.(*store)
+
+			ctx := context.Background()
+			key := testKey(t)
+
+			if _, err := s.Take(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+			s.dataLock.RLock()
+			first := s.data[key]
+			s.dataLock.RUnlock()
+
+			// Keep the key in use for longer than the TTL.
+			var purged bool
+			for i := 0; i < 15; i++ {
+				time.Sleep(20 * time.Millisecond)
+				if _, err := s.Take(ctx, key); err != nil {
+					t.Fatal(err)
+				}
+
+				s.dataLock.RLock()
+				purged = purged || s.data[key] != first
+				s.dataLock.RUnlock()
+			}
+
+			if got, want := purged, tc.purged; got != want {
+				t.Errorf("purged: expected %t to be %t", got, want)
 			}
 		})
 	}
 }
 
+func TestStore_OnExpire(t *testing.T) {
+	t.Parallel()
+
+	expired := make(chan string, 1)
+	s, err := New(&Config{
+		Tokens:        10,
+		Interval:      10 * time.Millisecond,
+		SweepInterval: 10 * time.Millisecond,
+		SweepMinTTL:   50 * time.Millisecond,
+		OnExpire: func(key string) {
+			expired <- key
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	key := testKey(t)
+	if _, err := s.Take(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-expired:
+		if want := key; got != want {
+			t.Errorf("expected %q to be %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected key to expire")
+	}
+}
+
 func TestBucketedLimiter_tick(t *testing.T) {
 	t.Parallel()
 
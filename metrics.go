@@ -0,0 +1,68 @@
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// MetricsSink receives limiter telemetry. Implementations must be safe for
+// concurrent use and should not block, since they are called on every take.
+// Tags are of the form "name:value", as used by StatsD extensions like
+// DogStatsD.
+type MetricsSink interface {
+	// Count adds value to the named counter.
+	Count(name string, value int64, tags []string)
+
+	// Timing records a duration for the named timer.
+	Timing(name string, d time.Duration, tags []string)
+}
+
+// Metric names reported by the store returned by WithMetrics.
+const (
+	// MetricTake counts takes, tagged with "result:allowed", "result:denied",
+	// or "result:error".
+	MetricTake = "limiter.take"
+
+	// MetricTakeDuration is the time each take took, tagged like MetricTake.
+	MetricTakeDuration = "limiter.take.duration"
+)
+
+// WithMetrics returns a store that reports each take on s to the sink. Tags
+// are added to every metric, for example to tell apart several limiters in the
+// same process.
+func WithMetrics(s Store, sink MetricsSink, tags ...string) Store {
+	return Preserve(s, &metricsStore{
+		Decorated: Decorated{s},
+		sink:      sink,
+		tags:      tags,
+	})
+}
+
+type metricsStore struct {
+	Decorated
+	sink MetricsSink
+	tags []string
+}
+
+// Take takes from the underlying store and reports the result and duration.
+func (s *metricsStore) Take(ctx context.Context, key string) (Result, error) {
+	start := time.Now()
+	res, err := s.Store.Take(ctx, key)
+	d := time.Since(start)
+
+	result := "result:allowed"
+	switch {
+	case err != nil:
+		result = "result:error"
+	case !res.Allowed:
+		result = "result:denied"
+	}
+
+	tags := make([]string, 0, len(s.tags)+1)
+	tags = append(tags, s.tags...)
+	tags = append(tags, result)
+
+	s.sink.Count(MetricTake, 1, tags)
+	s.sink.Timing(MetricTakeDuration, d, tags)
+	return res, err
+}
@@ -0,0 +1,74 @@
+package limiter_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+// recordingSink records the metrics it receives as "name|tags".
+type recordingSink struct {
+	lock    sync.Mutex
+	metrics []string
+}
+
+func (s *recordingSink) Count(name string, value int64, tags []string) {
+	s.record(name, tags)
+}
+
+func (s *recordingSink) Timing(name string, d time.Duration, tags []string) {
+	s.record(name, tags)
+}
+
+func (s *recordingSink) record(name string, tags []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.metrics = append(s.metrics, name+"|"+strings.Join(tags, ","))
+}
+
+func TestWithMetrics(t *testing.T) {
+	t.Parallel()
+
+	ms, err := memorystore.New(&memorystore.Config{
+		Tokens:   1,
+		Interval: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := new(recordingSink)
+	s := limiter.WithMetrics(ms, sink, "limiter:api")
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := s.Take(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Take(ctx, "key"); !errors.Is(err, limiter.ErrStopped) {
+		t.Fatalf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+
+	exp := []string{
+		"limiter.take|limiter:api,result:allowed",
+		"limiter.take.duration|limiter:api,result:allowed",
+		"limiter.take|limiter:api,result:denied",
+		"limiter.take.duration|limiter:api,result:denied",
+		"limiter.take|limiter:api,result:error",
+		"limiter.take.duration|limiter:api,result:error",
+	}
+	if got, want := strings.Join(sink.metrics, "\n"), strings.Join(exp, "\n"); got != want {
+		t.Errorf("expected\n%s\n\nto be\n%s", got, want)
+	}
+}
@@ -0,0 +1,397 @@
+package limiter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sethvargo/go-limiter/internal/denycache"
+)
+
+// StoreMiddleware wraps a store to change or observe its behavior, like HTTP
+// middleware wraps a handler. The standard middleware preserves the optional
+// capabilities of the store it wraps; see Preserve.
+type StoreMiddleware func(Store) Store
+
+// Chain wraps s with each middleware. The first middleware is the outermost,
+// so it sees each take first:
+//
+//	store = limiter.Chain(store,
+//	  limiter.Metrics(sink),
+//	  limiter.HashKeys(secret),
+//	)
+//
+// Here, metrics are reported for the takes as they are made, and the store
+// only sees the hashed keys.
+func Chain(s Store, mw ...StoreMiddleware) Store {
+	for i := len(mw) - 1; i >= 0; i-- {
+		s = mw[i](s)
+	}
+	return s
+}
+
+// Metrics returns middleware that reports each take to the sink, like
+// WithMetrics.
+func Metrics(sink MetricsSink, tags ...string) StoreMiddleware {
+	return func(s Store) Store {
+		return WithMetrics(s, sink, tags...)
+	}
+}
+
+// Logging returns middleware that logs takes that are denied or fail with
+// logf, which has the signature of log.Printf. The keys are logged as given,
+// so add it after any middleware that hashes them.
+func Logging(logf func(format string, v ...interface{})) StoreMiddleware {
+	return func(s Store) Store {
+		return Preserve(s, &loggingStore{Decorated: Decorated{s}, logf: logf})
+	}
+}
+
+type loggingStore struct {
+	Decorated
+	logf func(format string, v ...interface{})
+}
+
+// Take takes from the underlying store and logs denials and errors.
+func (s *loggingStore) Take(ctx context.Context, key string) (Result, error) {
+	res, err := s.Store.Take(ctx, key)
+	switch {
+	case err != nil:
+		s.logf("limiter: take %q failed (allowed %t): %v", key, res.Allowed, err)
+	case !res.Allowed:
+		s.logf("limiter: take %q denied, retry after %s", key, res.RetryAfter)
+	}
+	return res, err
+}
+
+// SoftLimit returns middleware that sets Warning on allowed takes once the key
+// has used at least threshold of its limit, like 0.8 for 80%, so callers can
+// warn clients before they are denied. Peeks are flagged the same way.
+//
+// If notify is not nil, it is called with the result of the take that crosses
+// the threshold, which is usually once per key per interval, for example to
+// email the customer. It is called synchronously, so it should hand slow work
+// off to a goroutine.
+func SoftLimit(threshold float64, notify func(ctx context.Context, key string, res Result)) StoreMiddleware {
+	return func(s Store) Store {
+		return Preserve(s, &softLimitStore{Decorated: Decorated{s}, threshold: threshold, notify: notify})
+	}
+}
+
+type softLimitStore struct {
+	Decorated
+	threshold float64
+	notify    func(ctx context.Context, key string, res Result)
+}
+
+// Take takes from the underlying store and flags takes over the threshold.
+func (s *softLimitStore) Take(ctx context.Context, key string) (Result, error) {
+	res, err := s.Store.Take(ctx, key)
+	if err != nil {
+		return res, err
+	}
+
+	used, warnAt, ok := s.usage(res)
+	res.Warning = ok && used >= warnAt
+	if res.Warning && used == warnAt && s.notify != nil {
+		s.notify(ctx, key, res)
+	}
+	return res, nil
+}
+
+// Peek peeks at the underlying store and flags results over the threshold.
+func (s *softLimitStore) Peek(ctx context.Context, key string) (Result, error) {
+	res, err := s.Decorated.Peek(ctx, key)
+	if err != nil {
+		return res, err
+	}
+
+	used, warnAt, ok := s.usage(res)
+	res.Warning = ok && used >= warnAt
+	return res, nil
+}
+
+// usage returns the tokens the result has used and the number at which it
+// crosses the threshold. It returns false for results that are not allowed or
+// have no limit.
+func (s *softLimitStore) usage(res Result) (uint64, uint64, bool) {
+	if !res.Allowed || res.Limit == 0 || res.Remaining > res.Limit {
+		return 0, 0, false
+	}
+
+	// The epsilon keeps thresholds like 0.8 of 10 from rounding up to 9.
+	warnAt := math.Ceil(s.threshold*float64(res.Limit) - 1e-9)
+	if warnAt < 0 {
+		warnAt = 0
+	}
+	return res.Limit - res.Remaining, uint64(warnAt), true
+}
+
+// Prefix returns middleware that prepends prefix to every key, so several
+// limiters can share a store. Keys lists only the keys with the prefix, and
+// both Keys and Stats return keys without it. Stats still summarizes every
+// key in the store.
+func Prefix(prefix string) StoreMiddleware {
+	return func(s Store) Store {
+		return Preserve(s, &prefixStore{Decorated: Decorated{s}, prefix: prefix})
+	}
+}
+
+type prefixStore struct {
+	Decorated
+	prefix string
+}
+
+// Take takes from the prefixed key.
+func (s *prefixStore) Take(ctx context.Context, key string) (Result, error) {
+	return s.Store.Take(ctx, s.prefix+key)
+}
+
+// Refund refunds the prefixed key.
+func (s *prefixStore) Refund(ctx context.Context, key string, tokens uint64) error {
+	return s.Decorated.Refund(ctx, s.prefix+key, tokens)
+}
+
+// Charge charges the prefixed key.
+func (s *prefixStore) Charge(ctx context.Context, key string, tokens uint64) error {
+	return s.Decorated.Charge(ctx, s.prefix+key, tokens)
+}
+
+// Peek peeks at the prefixed key.
+func (s *prefixStore) Peek(ctx context.Context, key string) (Result, error) {
+	return s.Decorated.Peek(ctx, s.prefix+key)
+}
+
+// Delete deletes the prefixed key.
+func (s *prefixStore) Delete(ctx context.Context, key string) error {
+	return s.Decorated.Delete(ctx, s.prefix+key)
+}
+
+// SetMetadata sets the metadata of the prefixed key.
+func (s *prefixStore) SetMetadata(ctx context.Context, key, metadata string) error {
+	return s.Decorated.SetMetadata(ctx, s.prefix+key, metadata)
+}
+
+// Keys lists the keys with the prefix that match the pattern.
+func (s *prefixStore) Keys(ctx context.Context, pattern string, cursor uint64) ([]string, uint64, error) {
+	keys, next, err := s.Decorated.Keys(ctx, escapePattern(s.prefix)+pattern, cursor)
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, s.prefix)
+	}
+	return keys, next, err
+}
+
+// Stats summarizes the store, removing the prefix from the keys that have it.
+func (s *prefixStore) Stats(ctx context.Context, n int) (Stats, error) {
+	stats, err := s.Decorated.Stats(ctx, n)
+	for i := range stats.Top {
+		stats.Top[i].Key = strings.TrimPrefix(stats.Top[i].Key, s.prefix)
+	}
+	return stats, err
+}
+
+// NormalizeKeys returns middleware that passes every key through each of the
+// normalizers in order before it reaches the store, so keys that identify the
+// same client share one bucket. For example, with TrimKeys and LowerKeys,
+// " User@Example.com" and "user@example.com" are the same key. To also treat
+// equivalent Unicode forms as the same key, add norm.NFC.String from
+// golang.org/x/text/unicode/norm; this module has no dependencies, so it does
+// not provide one. Keys and Stats are not affected.
+func NormalizeKeys(normalizers ...func(key string) string) StoreMiddleware {
+	return func(s Store) Store {
+		return Preserve(s, &normalizeStore{Decorated: Decorated{s}, normalizers: normalizers})
+	}
+}
+
+var (
+	// LowerKeys lowercases keys, for case-insensitive identities like email
+	// addresses.
+	LowerKeys = strings.ToLower
+
+	// TrimKeys removes leading and trailing white space from keys.
+	TrimKeys = strings.TrimSpace
+)
+
+type normalizeStore struct {
+	Decorated
+	normalizers []func(key string) string
+}
+
+// Take takes from the normalized key.
+func (s *normalizeStore) Take(ctx context.Context, key string) (Result, error) {
+	return s.Store.Take(ctx, s.normalize(key))
+}
+
+// Refund refunds the normalized key.
+func (s *normalizeStore) Refund(ctx context.Context, key string, tokens uint64) error {
+	return s.Decorated.Refund(ctx, s.normalize(key), tokens)
+}
+
+// Charge charges the normalized key.
+func (s *normalizeStore) Charge(ctx context.Context, key string, tokens uint64) error {
+	return s.Decorated.Charge(ctx, s.normalize(key), tokens)
+}
+
+// Peek peeks at the normalized key.
+func (s *normalizeStore) Peek(ctx context.Context, key string) (Result, error) {
+	return s.Decorated.Peek(ctx, s.normalize(key))
+}
+
+// Delete deletes the normalized key.
+func (s *normalizeStore) Delete(ctx context.Context, key string) error {
+	return s.Decorated.Delete(ctx, s.normalize(key))
+}
+
+// SetMetadata sets the metadata of the normalized key.
+func (s *normalizeStore) SetMetadata(ctx context.Context, key, metadata string) error {
+	return s.Decorated.SetMetadata(ctx, s.normalize(key), metadata)
+}
+
+func (s *normalizeStore) normalize(key string) string {
+	for _, f := range s.normalizers {
+		key = f(key)
+	}
+	return key
+}
+
+// HashKeys returns middleware that replaces every key with its hex-encoded
+// HMAC-SHA256 under secret, or its plain SHA-256 if secret is empty, so keys
+// like IP addresses are not stored in plaintext. Keys and Stats return the
+// hashed keys, and patterns passed to Keys match the hashed keys.
+func HashKeys(secret []byte) StoreMiddleware {
+	newHash := sha256.New
+	if len(secret) > 0 {
+		newHash = func() hash.Hash {
+			return hmac.New(sha256.New, secret)
+		}
+	}
+
+	return func(s Store) Store {
+		return Preserve(s, &hashStore{Decorated: Decorated{s}, newHash: newHash})
+	}
+}
+
+type hashStore struct {
+	Decorated
+	newHash func() hash.Hash
+}
+
+// Take takes from the hashed key.
+func (s *hashStore) Take(ctx context.Context, key string) (Result, error) {
+	return s.Store.Take(ctx, s.hash(key))
+}
+
+// Refund refunds the hashed key.
+func (s *hashStore) Refund(ctx context.Context, key string, tokens uint64) error {
+	return s.Decorated.Refund(ctx, s.hash(key), tokens)
+}
+
+// Charge charges the hashed key.
+func (s *hashStore) Charge(ctx context.Context, key string, tokens uint64) error {
+	return s.Decorated.Charge(ctx, s.hash(key), tokens)
+}
+
+// Peek peeks at the hashed key.
+func (s *hashStore) Peek(ctx context.Context, key string) (Result, error) {
+	return s.Decorated.Peek(ctx, s.hash(key))
+}
+
+// Delete deletes the hashed key.
+func (s *hashStore) Delete(ctx context.Context, key string) error {
+	return s.Decorated.Delete(ctx, s.hash(key))
+}
+
+// SetMetadata sets the metadata of the hashed key.
+func (s *hashStore) SetMetadata(ctx context.Context, key, metadata string) error {
+	return s.Decorated.SetMetadata(ctx, s.hash(key), metadata)
+}
+
+func (s *hashStore) hash(key string) string {
+	h := s.newHash()
+	h.Write([]byte(key))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// CacheDenials returns middleware that remembers denied keys until the margin
+// before their reset time, and denies further takes on them without calling
+// the store. This keeps traffic to a denied key from reaching a remote store,
+// but tokens refunded by other processes are not seen until the denial
+// expires. Refunds through the returned store clear the key's denials. Up to
+// size keys are cached. Cached denials report the Limit of the most recent
+// denial from the store.
+func CacheDenials(margin time.Duration, size int) StoreMiddleware {
+	return func(s Store) Store {
+		return Preserve(s, &denyCacheStore{
+			Decorated: Decorated{s},
+			cache:     denycache.New(margin, size),
+		})
+	}
+}
+
+type denyCacheStore struct {
+	// limit is first so it is 64-bit aligned for atomic access.
+	limit uint64
+
+	Decorated
+	cache *denycache.Cache
+}
+
+// Take denies the take if the key has a cached denial, otherwise takes from
+// the underlying store and caches a denial.
+func (s *denyCacheStore) Take(ctx context.Context, key string) (Result, error) {
+	low := PriorityFromContext(ctx) == PriorityLow
+
+	now := time.Now()
+	if resetAt, ok := s.cache.Get(key, low, now); ok {
+		return Result{
+			Limit:      atomic.LoadUint64(&s.limit),
+			ResetAt:    resetAt,
+			RetryAfter: resetAt.Sub(now),
+		}, nil
+	}
+
+	res, err := s.Store.Take(ctx, key)
+	if err == nil && !res.Allowed {
+		atomic.StoreUint64(&s.limit, res.Limit)
+		s.cache.Add(key, low, time.Now(), res.ResetAt)
+	}
+	return res, err
+}
+
+// Refund refunds the key and clears its cached denials.
+func (s *denyCacheStore) Refund(ctx context.Context, key string, tokens uint64) error {
+	if err := s.Decorated.Refund(ctx, key, tokens); err != nil {
+		return err
+	}
+	s.cache.Remove(key)
+	return nil
+}
+
+// Delete deletes the key and clears its cached denials.
+func (s *denyCacheStore) Delete(ctx context.Context, key string) error {
+	if err := s.Decorated.Delete(ctx, key); err != nil {
+		return err
+	}
+	s.cache.Remove(key)
+	return nil
+}
+
+// escapePattern escapes the glob characters in s, so it only matches itself in
+// a pattern given to Inspector.Keys.
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
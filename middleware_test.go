@@ -0,0 +1,327 @@
+package limiter_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/limittest"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+// recordingStore records the keys it is given to take.
+type recordingStore struct {
+	limiter.Decorated
+	keys []string
+}
+
+func (s *recordingStore) Take(ctx context.Context, key string) (limiter.Result, error) {
+	s.keys = append(s.keys, key)
+	return s.Store.Take(ctx, key)
+}
+
+func TestChain(t *testing.T) {
+	t.Parallel()
+
+	rs := &recordingStore{Decorated: limiter.Decorated{Store: limittest.NewStore(1, time.Minute)}}
+	s := limiter.Chain(rs, limiter.Prefix("a:"), limiter.Prefix("b:"))
+
+	if _, err := s.Take(context.Background(), "key"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(rs.keys, ","), "b:a:key"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
+
+func TestChain_deleter(t *testing.T) {
+	t.Parallel()
+
+	ls := limittest.NewStore(1, time.Minute)
+	s := limiter.Chain(ls,
+		limiter.HashKeys([]byte("secret")),
+		limiter.Prefix("a:"),
+		limiter.NormalizeKeys(limiter.LowerKeys),
+	)
+
+	ctx := context.Background()
+	for i, want := range []bool{true, false} {
+		res, err := s.Take(ctx, "KEY")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Allowed; got != want {
+			t.Errorf("take %d: expected %t to be %t", i, got, want)
+		}
+	}
+
+	// The delete reaches the same key as the takes.
+	if err := s.(limiter.Deleter).Delete(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Take(ctx, "Key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed {
+		t.Error("expected take after delete to be allowed")
+	}
+}
+
+func TestChain_annotator(t *testing.T) {
+	t.Parallel()
+
+	ms := limittest.NewStore(1, time.Minute)
+	s := limiter.Chain(ms,
+		limiter.Logging(func(string, ...interface{}) {}),
+		limiter.SoftLimit(0.8, nil),
+		limiter.Prefix("a:"),
+		limiter.NormalizeKeys(limiter.LowerKeys),
+	)
+
+	ctx := context.Background()
+	annotator, ok := s.(limiter.Annotator)
+	if !ok {
+		t.Fatal("expected chain to implement Annotator")
+	}
+	if err := annotator.SetMetadata(ctx, "KEY", "plan=pro"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The metadata is stored with the prefixed, normalized key.
+	res, err := ms.Peek(ctx, "a:key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Metadata, "plan=pro"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+
+	if _, ok := limiter.Chain(takeOnlyStore{ms}, limiter.Prefix("a:")).(limiter.Annotator); ok {
+		t.Error("expected chain of a store without metadata to not implement Annotator")
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	t.Parallel()
+
+	ms := limittest.NewStore(1, time.Minute)
+	s := limiter.Prefix("app*:")(ms)
+
+	ctx := context.Background()
+	for _, key := range []string{"a", "b"} {
+		if _, err := s.Take(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ms.Take(ctx, "app-other"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the prefixed keys are listed, without the prefix, even though the
+	// prefix has a glob character.
+	keys, _, err := s.(limiter.Inspector).Keys(ctx, "*", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(keys, ","), "a,b"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+
+	if err := s.(limiter.Refunder).Refund(ctx, "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := s.Take(ctx, "a"); !res.Allowed || err != nil {
+		t.Errorf("expected take after refund to succeed, got %t, %v", res.Allowed, err)
+	}
+}
+
+func TestHashKeys(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		secret []byte
+		want   string
+	}{
+		{
+			name: "sha256",
+			want: "37fcff24bf62035b2b08020afc08b4fecd4fcffce57ab23518e3561ff0fe76b9",
+		},
+		{
+			name:   "hmac",
+			secret: []byte("secret"),
+			want:   "84edc40821674d125954d6546b5fe4758305af0d3533f8840740d68863b727d2",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rs := &recordingStore{Decorated: limiter.Decorated{Store: limittest.NewStore(1, time.Minute)}}
+			s := limiter.HashKeys(tc.secret)(rs)
+
+			for i := 0; i < 2; i++ {
+				if _, err := s.Take(context.Background(), "192.0.2.1"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got, want := len(rs.keys), 2; got != want {
+				t.Fatalf("expected %d to be %d", got, want)
+			}
+			for _, got := range rs.keys {
+				if want := tc.want; got != want {
+					t.Errorf("expected %q to be %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheDenials(t *testing.T) {
+	t.Parallel()
+
+	// Denials are cached against the wall clock, so this needs a real store
+	// rather than a limittest one.
+	ms, err := memorystore.New(&memorystore.Config{
+		Tokens:   1,
+		Interval: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ms.Close()
+
+	rs := &recordingStore{Decorated: limiter.Decorated{Store: ms}}
+	s := limiter.CacheDenials(time.Second, 10)(rs)
+
+	ctx := context.Background()
+	for i, want := range []bool{true, false, false, false} {
+		res, err := s.Take(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Allowed; got != want {
+			t.Errorf("take %d: expected %t to be %t", i, got, want)
+		}
+		if got, want := res.Limit, uint64(1); got != want {
+			t.Errorf("take %d: limit: expected %d to be %d", i, got, want)
+		}
+	}
+
+	// Only the first denial reached the store.
+	if got, want := len(rs.keys), 2; got != want {
+		t.Errorf("takes: expected %d to be %d", got, want)
+	}
+
+	// A refund clears the cached denial.
+	if err := s.(limiter.Refunder).Refund(ctx, "key", 1); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := s.Take(ctx, "key"); !res.Allowed || err != nil {
+		t.Errorf("expected take after refund to succeed, got %t, %v", res.Allowed, err)
+	}
+
+	// So does a delete.
+	if res, err := s.Take(ctx, "key"); res.Allowed || err != nil {
+		t.Fatalf("expected take to be denied, got %t, %v", res.Allowed, err)
+	}
+	if err := s.(limiter.Deleter).Delete(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := s.Take(ctx, "key"); !res.Allowed || err != nil {
+		t.Errorf("expected take after delete to succeed, got %t, %v", res.Allowed, err)
+	}
+}
+
+func TestLogging(t *testing.T) {
+	t.Parallel()
+
+	var lines []string
+	s := limiter.Logging(func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	})(limittest.NewStore(1, time.Minute))
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.Take(context.Background(), "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := len(lines), 1; got != want {
+		t.Fatalf("expected %d to be %d", got, want)
+	}
+	if got, want := lines[0], `limiter: take "key" denied`; !strings.HasPrefix(got, want) {
+		t.Errorf("expected %q to start with %q", got, want)
+	}
+}
+
+func TestSoftLimit(t *testing.T) {
+	t.Parallel()
+
+	var notified []uint64
+	s := limiter.SoftLimit(0.8, func(_ context.Context, _ string, res limiter.Result) {
+		notified = append(notified, res.Remaining)
+	})(limittest.NewStore(10, time.Minute))
+
+	ctx := context.Background()
+	for i := 0; i < 11; i++ {
+		res, err := s.Take(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The 8th through 10th takes use 80% or more, and the 11th is denied.
+		if got, want := res.Warning, i >= 7 && i < 10; got != want {
+			t.Errorf("take %d: expected %t to be %t", i, got, want)
+		}
+	}
+
+	if got, want := fmt.Sprint(notified), "[2]"; got != want {
+		t.Errorf("notified: expected %s to be %s", got, want)
+	}
+
+	res, err := s.(limiter.Peeker).Peek(ctx, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Warning {
+		t.Errorf("expected unused key to not be warned")
+	}
+}
+
+func TestNormalizeKeys(t *testing.T) {
+	t.Parallel()
+
+	rs := &recordingStore{Decorated: limiter.Decorated{Store: limittest.NewStore(1, time.Minute)}}
+	s := limiter.NormalizeKeys(limiter.TrimKeys, limiter.LowerKeys)(rs)
+
+	ctx := context.Background()
+	if res, err := s.Take(ctx, " User@Example.COM\n"); err != nil || !res.Allowed {
+		t.Fatalf("expected take to be allowed, got %t, %v", res.Allowed, err)
+	}
+	if res, err := s.Take(ctx, "user@example.com"); err != nil || res.Allowed {
+		t.Errorf("expected normalized key to share the bucket, got %t, %v", res.Allowed, err)
+	}
+	if got, want := strings.Join(rs.keys, ","), "user@example.com,user@example.com"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+
+	// Unicode case mappings are applied too.
+	if got, want := limiter.LowerKeys("ÄNGSTRÖM"), "ängström"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+
+	if err := s.(limiter.Refunder).Refund(ctx, "USER@example.com", 1); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := s.(limiter.Peeker).Peek(ctx, "USER@EXAMPLE.COM"); err != nil || !res.Allowed {
+		t.Errorf("expected refund to the normalized key, got %t, %v", res.Allowed, err)
+	}
+}
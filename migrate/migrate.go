@@ -0,0 +1,178 @@
+// Package migrate copies limiter state from one store to another, like between
+// Redis instances or to a store with a different algorithm, so keys keep what
+// they used when the backend changes.
+//
+// By default, each key's used tokens are read from the source with Peek and
+// charged to the destination with Charge, so the destination keeps the state
+// in its own format, whatever it is. Keys that used no tokens are skipped, and
+// keys that expired in the source are not seen. The destination gives each key
+// its own TTL and interval, so a migrated key may reset later than it would
+// have in the source.
+//
+// For stores with the same limits, Config.ReadState and Config.WriteState copy
+// the exact state of each bucket instead, like redisstore.ReadState and
+// redisstore.WriteState do.
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/bucketstate"
+)
+
+// Config is used as input to Migrate.
+type Config struct {
+	// Pattern is the glob-style pattern of the keys to migrate. The default
+	// value is "*", which migrates all keys.
+	Pattern string
+
+	// ReadState and WriteState, if both set, copy the state of each key
+	// exactly, instead of charging its used tokens. ReadState returns false
+	// for keys that no longer exist, which are skipped.
+	ReadState  func(ctx context.Context, key string) (bucketstate.State, bool, error)
+	WriteState func(ctx context.Context, key string, st bucketstate.State) error
+
+	// Progress, if set, is called after each key is migrated or skipped.
+	Progress func(key string, migrated bool)
+}
+
+// Report is the result of a migration.
+type Report struct {
+	// Keys is the number of keys of the source that matched the pattern.
+	Keys uint64
+
+	// Migrated is the number of keys copied to the destination. The others
+	// used no tokens, or expired during the migration.
+	Migrated uint64
+}
+
+// Migrate copies the state of the keys that match the pattern from one store
+// to the other. The source must implement limiter.Inspector and, unless the
+// state is copied exactly, limiter.Peeker, and the destination
+// limiter.Charger. Metadata is copied too if the destination implements
+// limiter.Annotator.
+//
+// Keys are walked while the source is live, and charging is not idempotent,
+// so a key taken from during the migration may be copied without the last
+// takes, and migrating a key twice charges it twice. On error, Migrate stops
+// and returns the keys migrated so far.
+func Migrate(ctx context.Context, from, to limiter.Store, c *Config) (Report, error) {
+	if from == nil || to == nil {
+		return Report{}, fmt.Errorf("missing store")
+	}
+	if c == nil {
+		c = new(Config)
+	}
+
+	inspector, ok := from.(limiter.Inspector)
+	if !ok {
+		return Report{}, fmt.Errorf("source store must implement limiter.Inspector")
+	}
+
+	var copyKey func(ctx context.Context, key string) (bool, error)
+	if c.ReadState != nil && c.WriteState != nil {
+		copyKey = func(ctx context.Context, key string) (bool, error) {
+			return copyState(ctx, c, key)
+		}
+	} else {
+		peeker, ok := from.(limiter.Peeker)
+		if !ok {
+			return Report{}, fmt.Errorf("source store must implement limiter.Peeker")
+		}
+		charger, ok := to.(limiter.Charger)
+		if !ok {
+			return Report{}, fmt.Errorf("destination store must implement limiter.Charger")
+		}
+		annotator, _ := to.(limiter.Annotator)
+		copyKey = func(ctx context.Context, key string) (bool, error) {
+			return copyUsage(ctx, peeker, charger, annotator, key)
+		}
+	}
+
+	pattern := "*"
+	if c.Pattern != "" {
+		pattern = c.Pattern
+	}
+
+	// Keys may be returned more than once, and must only be charged once.
+	seen := make(map[string]struct{})
+
+	var report Report
+	var cursor uint64
+	for {
+		keys, next, err := inspector.Keys(ctx, pattern, cursor)
+		if err != nil {
+			return report, fmt.Errorf("failed to list keys: %w", err)
+		}
+
+		for _, key := range keys {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			report.Keys++
+
+			migrated, err := copyKey(ctx, key)
+			if err != nil {
+				return report, fmt.Errorf("failed to migrate %q: %w", key, err)
+			}
+			if migrated {
+				report.Migrated++
+			}
+			if c.Progress != nil {
+				c.Progress(key, migrated)
+			}
+		}
+
+		if next == 0 {
+			return report, nil
+		}
+		cursor = next
+	}
+}
+
+// copyState copies the exact state of the key.
+func copyState(ctx context.Context, c *Config, key string) (bool, error) {
+	st, ok, err := c.ReadState(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read state: %w", err)
+	}
+	if !ok {
+		return false, nil
+	}
+	if err := c.WriteState(ctx, key, st); err != nil {
+		return false, fmt.Errorf("failed to write state: %w", err)
+	}
+	return true, nil
+}
+
+// copyUsage charges the tokens the key used in the source to the destination.
+func copyUsage(ctx context.Context, from limiter.Peeker, to limiter.Charger, annotator limiter.Annotator, key string) (bool, error) {
+	res, err := from.Peek(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to peek: %w", err)
+	}
+
+	var used uint64
+	if res.Remaining < res.Limit {
+		used = res.Limit - res.Remaining
+	}
+	metadata := res.Metadata != "" && annotator != nil
+	if used == 0 && !metadata {
+		return false, nil
+	}
+
+	if used > 0 {
+		if err := to.Charge(ctx, key, used); err != nil {
+			return false, fmt.Errorf("failed to charge: %w", err)
+		}
+	}
+	if metadata {
+		if err := annotator.SetMetadata(ctx, key, res.Metadata); err != nil {
+			return false, fmt.Errorf("failed to set metadata: %w", err)
+		}
+	}
+	return true, nil
+}
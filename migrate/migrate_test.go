@@ -0,0 +1,135 @@
+package migrate_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/bucketstate"
+	"github.com/sethvargo/go-limiter/limittest"
+	"github.com/sethvargo/go-limiter/migrate"
+)
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	from, to := limittest.NewStore(10, time.Hour), limittest.NewStore(20, time.Hour)
+	for i := 0; i < 5; i++ {
+		for j := 0; j < i; j++ {
+			if _, err := from.Take(ctx, fmt.Sprintf("user:%d", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := from.SetMetadata(ctx, "user:0", "plan=free"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := from.Take(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress int
+	report, err := migrate.Migrate(ctx, from, to, &migrate.Config{
+		Pattern:  "user:*",
+		Progress: func(string, bool) { progress++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := report, (migrate.Report{Keys: 5, Migrated: 5}); got != want {
+		t.Errorf("expected %#v to be %#v", got, want)
+	}
+	if got, want := progress, 5; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	// Each key keeps the tokens it used, out of the destination's limit.
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("user:%d", i)
+		res, err := to.Peek(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := res.Remaining, uint64(20-i); got != want {
+			t.Errorf("%s: expected %d to be %d", key, got, want)
+		}
+	}
+
+	res, err := to.Peek(ctx, "user:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Metadata, "plan=free"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+
+	keys, _, err := to.Keys(ctx, "other", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("expected no keys to be migrated, got %q", keys)
+	}
+}
+
+func TestMigrate_State(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	from := limittest.NewStore(10, time.Hour)
+	for _, key := range []string{"a", "b"} {
+		if _, err := from.Take(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	states := map[string]bucketstate.State{
+		"a": {Start: 1, Tick: 2, Tokens: 3},
+	}
+	written := make(map[string]bucketstate.State)
+
+	report, err := migrate.Migrate(ctx, from, limittest.NewStore(10, time.Hour), &migrate.Config{
+		ReadState: func(_ context.Context, key string) (bucketstate.State, bool, error) {
+			st, ok := states[key]
+			return st, ok, nil
+		},
+		WriteState: func(_ context.Context, key string, st bucketstate.State) error {
+			written[key] = st
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := report, (migrate.Report{Keys: 2, Migrated: 1}); got != want {
+		t.Errorf("expected %#v to be %#v", got, want)
+	}
+	if got, want := written["a"], states["a"]; got != want {
+		t.Errorf("expected %#v to be %#v", got, want)
+	}
+	if _, ok := written["b"]; ok {
+		t.Error("expected missing state to be skipped")
+	}
+}
+
+func TestMigrate_Unsupported(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := limittest.NewStore(10, time.Hour)
+
+	if _, err := migrate.Migrate(ctx, nil, s, nil); err == nil {
+		t.Error("expected error for missing store")
+	}
+	if _, err := migrate.Migrate(ctx, struct{ limiter.Store }{s}, s, nil); err == nil {
+		t.Error("expected error for source without Keys")
+	}
+	if _, err := migrate.Migrate(ctx, s, struct{ limiter.Store }{s}, nil); err == nil {
+		t.Error("expected error for destination without Charge")
+	}
+}
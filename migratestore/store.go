@@ -0,0 +1,134 @@
+// Package migratestore defines a store for moving keys from one backend to
+// another without downtime, like from Redis to DynamoDB.
+//
+// During the migration, takes are decided by the new store, and the old store
+// is only consulted with Peek, so instances that still run against the old
+// store keep counting there, and an instance on the new store does not admit
+// a key the old one has used up. A take is only allowed if both stores permit
+// it. Once every key's interval passed since the migration started, the new
+// store has seen all of the traffic and can be used on its own.
+package migratestore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+var _ limiter.Store = (*store)(nil)
+var _ limiter.Refunder = (*store)(nil)
+var _ limiter.Peeker = (*store)(nil)
+
+type store struct {
+	from limiter.Peeker
+	to   limiter.Store
+
+	closeFrom func() error
+}
+
+// New creates a store that takes from to and consults from read-only, which
+// must implement limiter.Peeker.
+func New(from, to limiter.Store) (limiter.Store, error) {
+	if from == nil || to == nil {
+		return nil, fmt.Errorf("missing store")
+	}
+	peeker, ok := from.(limiter.Peeker)
+	if !ok {
+		return nil, fmt.Errorf("old store must implement limiter.Peeker")
+	}
+
+	return &store{
+		from:      peeker,
+		to:        to,
+		closeFrom: from.Close,
+	}, nil
+}
+
+// Take takes a token from the new store and, if it was allowed, peeks at the
+// key in the old store. If the old store has no tokens left for the key, the
+// take is denied, and the token is refunded to the new store if it implements
+// limiter.Refunder. The remaining tokens are the fewer of the two stores, and a
+// take denied by the old store resets when it does. If the old store returns
+// an error, the take is denied.
+func (s *store) Take(ctx context.Context, key string) (limiter.Result, error) {
+	res, err := s.to.Take(ctx, key)
+	if err != nil || !res.Allowed {
+		return res, err
+	}
+
+	old, err := s.from.Peek(ctx, key)
+	if err != nil {
+		s.refund(ctx, key)
+		return limiter.Result{}, fmt.Errorf("failed to peek old store: %w", err)
+	}
+	return merge(res, old, func() { s.refund(ctx, key) }), nil
+}
+
+// Peek returns the key's state as the next take would see it, the fewer
+// remaining tokens of the two stores. It returns limiter.ErrNotSupported if
+// the new store does not implement limiter.Peeker.
+func (s *store) Peek(ctx context.Context, key string) (limiter.Result, error) {
+	p, ok := s.to.(limiter.Peeker)
+	if !ok {
+		return limiter.Result{}, limiter.ErrNotSupported
+	}
+
+	res, err := p.Peek(ctx, key)
+	if err != nil || !res.Allowed {
+		return res, err
+	}
+
+	old, err := s.from.Peek(ctx, key)
+	if err != nil {
+		return limiter.Result{}, fmt.Errorf("failed to peek old store: %w", err)
+	}
+	return merge(res, old, nil), nil
+}
+
+// Refund returns the tokens to the key in the new store; the old store is
+// never written to. It returns limiter.ErrNotSupported if the new store does
+// not implement limiter.Refunder.
+func (s *store) Refund(ctx context.Context, key string, tokens uint64) error {
+	r, ok := s.to.(limiter.Refunder)
+	if !ok {
+		return limiter.ErrNotSupported
+	}
+	return r.Refund(ctx, key, tokens)
+}
+
+// Close closes the new store and the old store.
+func (s *store) Close() error {
+	err := s.to.Close()
+	if ferr := s.closeFrom(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// refund returns the token of a take the old store denied, so it does not
+// count against the key in the new store.
+func (s *store) refund(ctx context.Context, key string) {
+	if r, ok := s.to.(limiter.Refunder); ok {
+		_ = r.Refund(ctx, key, 1)
+	}
+}
+
+// merge combines the allowed result of the new store with the state of the key
+// in the old store, calling denied if the old store has no tokens left.
+func merge(res, old limiter.Result, denied func()) limiter.Result {
+	if old.Remaining < res.Remaining {
+		res.Remaining = old.Remaining
+	}
+	if old.Allowed {
+		return res
+	}
+
+	if denied != nil {
+		denied()
+	}
+	res.Allowed = false
+	res.ResetAt = old.ResetAt
+	res.RetryAfter = old.RetryAfter
+	return res
+}
@@ -0,0 +1,126 @@
+package migratestore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/memorystore"
+	"github.com/sethvargo/go-limiter/migratestore"
+	"github.com/sethvargo/go-limiter/storetest"
+)
+
+func newMemory(tb testing.TB, tokens uint64, interval time.Duration) limiter.Store {
+	s, err := memorystore.New(&memorystore.Config{
+		Tokens:   tokens,
+		Interval: interval,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	storetest.TestStore(t, func(tb testing.TB, c *storetest.Config) limiter.Store {
+		s, err := migratestore.New(newMemory(tb, c.Tokens, c.Interval), newMemory(tb, c.Tokens, c.Interval))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	s := newMemory(t, 1, time.Hour)
+	defer s.Close()
+
+	if _, err := migratestore.New(nil, s); err == nil {
+		t.Error("expected error for missing old store")
+	}
+	if _, err := migratestore.New(s, nil); err == nil {
+		t.Error("expected error for missing new store")
+	}
+	if _, err := migratestore.New(struct{ limiter.Store }{s}, s); err == nil {
+		t.Error("expected error for old store without Peek")
+	}
+}
+
+func TestStore_Take(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	old := newMemory(t, 3, time.Hour)
+	s, err := migratestore.New(old, newMemory(t, 5, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Instances that were not migrated yet keep taking from the old store.
+	for i := 0; i < 2; i++ {
+		if _, err := old.Take(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := s.Take(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed {
+		t.Fatal("expected take to be allowed")
+	}
+	if got, want := res.Remaining, uint64(1); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	// The old store has no tokens left for the take, so it is denied, and
+	// returned to the new store.
+	if _, err := old.Take(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	res, err = s.Take(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed {
+		t.Error("expected take to be denied")
+	}
+	if res.RetryAfter <= 0 {
+		t.Errorf("expected retry after, got %s", res.RetryAfter)
+	}
+
+	peek, err := s.(limiter.Peeker).Peek(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := peek.Remaining, uint64(0); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	// The old store is never written to.
+	oldPeek, err := old.(limiter.Peeker).Peek(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := oldPeek.Remaining, uint64(0); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+	if err := s.(limiter.Refunder).Refund(ctx, "key", 1); err != nil {
+		t.Fatal(err)
+	}
+	oldPeek, err = old.(limiter.Peeker).Peek(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := oldPeek.Remaining, uint64(0); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
@@ -1,6 +1,7 @@
 package noopstore_test
 
 import (
+	"context"
 	"log"
 
 	"github.com/sethvargo/go-limiter/noopstore"
@@ -13,6 +14,10 @@ func ExampleNew() {
 	}
 	defer store.Close()
 
-	limit, remaining, reset, ok := store.Take("my-key")
-	_, _, _, _ = limit, remaining, reset, ok
+	ctx := context.Background()
+	res, err := store.Take(ctx, "my-key")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, _, _, _ = res.Limit, res.Remaining, res.ResetAt, res.Allowed
 }
@@ -2,9 +2,17 @@
 // requests. It's an empty store useful for testing or development.
 package noopstore
 
-import "github.com/sethvargo/go-limiter"
+import (
+	"context"
+
+	"github.com/sethvargo/go-limiter"
+)
 
 var _ limiter.Store = (*store)(nil)
+var _ limiter.Refunder = (*store)(nil)
+var _ limiter.Inspector = (*store)(nil)
+var _ limiter.Peeker = (*store)(nil)
+var _ limiter.Charger = (*store)(nil)
 
 type store struct{}
 
@@ -13,8 +21,33 @@ func New() (limiter.Store, error) {
 }
 
 // Take always allows the request.
-func (s *store) Take(_ string) (uint64, uint64, uint64, bool) {
-	return 0, 0, 0, true
+func (s *store) Take(_ context.Context, _ string) (limiter.Result, error) {
+	return limiter.Result{Allowed: true}, nil
+}
+
+// Refund does nothing.
+func (s *store) Refund(_ context.Context, _ string, _ uint64) error {
+	return nil
+}
+
+// Charge does nothing.
+func (s *store) Charge(_ context.Context, _ string, _ uint64) error {
+	return nil
+}
+
+// Peek always reports that the next request is allowed.
+func (s *store) Peek(_ context.Context, _ string) (limiter.Result, error) {
+	return limiter.Result{Allowed: true}, nil
+}
+
+// Keys always returns no keys.
+func (s *store) Keys(_ context.Context, _ string, _ uint64) ([]string, uint64, error) {
+	return nil, 0, nil
+}
+
+// Stats always returns empty stats.
+func (s *store) Stats(_ context.Context, _ int) (limiter.Stats, error) {
+	return limiter.Stats{}, nil
 }
 
 // Close does nothing.
@@ -0,0 +1,226 @@
+// Package notifylimit throttles outbound notifications, like emails and text
+// messages, per recipient and template per calendar day, week, or month, so a
+// bug or an abusive user cannot flood someone's inbox:
+//
+//	l, err := notifylimit.New(func(tokens uint64, interval time.Duration) (limiter.Store, error) {
+//		return memorystore.New(&memorystore.Config{Tokens: tokens, Interval: interval})
+//	}, &notifylimit.Config{
+//		Limit:     3,
+//		Templates: map[string]uint64{"password-reset": 5},
+//	})
+//
+//	if ok, err := l.Allow(ctx, "alice@example.com", "weekly-digest"); err == nil && ok {
+//		// Send the notification.
+//	}
+//
+// Periods follow the calendar in the configured time zone, rather than
+// starting with the first notification, so recipients get a fresh allowance
+// at midnight. Each period of each recipient and template is its own key, so
+// any store works.
+package notifylimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// Period is the calendar period notifications are counted over.
+type Period int
+
+const (
+	// PeriodDay is the default period, a calendar day.
+	PeriodDay Period = iota
+
+	// PeriodWeek is an ISO 8601 week, which starts on Monday.
+	PeriodWeek
+
+	// PeriodMonth is a calendar month.
+	PeriodMonth
+)
+
+// Event is a notification over the limit.
+type Event struct {
+	// Recipient and Template are those of the notification.
+	Recipient string
+	Template  string
+
+	// Period is the calendar period it was counted in, like "2020-01-31",
+	// "2020-W05", or "2020-01", and Limit the limit of the template.
+	Period string
+	Limit  uint64
+
+	// DryRun is set if the notification was allowed anyway, because the
+	// limiter is in dry-run mode.
+	DryRun bool
+}
+
+// StoreFunc creates the store of a limit. It is called once for each distinct
+// limit, with an interval longer than the period, so the keys of a period are
+// never refilled before it ends. When the stores share a backend, like Redis,
+// give each its own key prefix.
+type StoreFunc func(tokens uint64, interval time.Duration) (limiter.Store, error)
+
+// Config is used as input to New.
+type Config struct {
+	// Limit is the number of notifications of a template each recipient may
+	// get per period. The default value is 3.
+	Limit uint64
+
+	// Templates overrides the limit of some templates, like higher limits for
+	// security notices.
+	Templates map[string]uint64
+
+	// Period is the calendar period. The default value is PeriodDay.
+	Period Period
+
+	// Location is the time zone of the calendar. The default value is UTC.
+	Location *time.Location
+
+	// DryRun allows notifications over the limit, and only reports them to
+	// Audit, so limits can be tried on production traffic before they are
+	// enforced.
+	DryRun bool
+
+	// Audit, if set, is called for each notification over the limit. It is
+	// called from Allow, so it should return quickly.
+	Audit func(e *Event)
+}
+
+// Limiter throttles notifications.
+type Limiter struct {
+	limit     uint64
+	templates map[string]uint64
+	stores    map[uint64]limiter.Store
+	period    Period
+	location  *time.Location
+	dryRun    bool
+	audit     func(e *Event)
+
+	// now returns the current time. Tests replace it.
+	now func() time.Time
+}
+
+// New creates the stores of the limits with f.
+func New(f StoreFunc, c *Config) (*Limiter, error) {
+	if f == nil {
+		return nil, fmt.Errorf("store function cannot be nil")
+	}
+	if c == nil {
+		c = new(Config)
+	}
+
+	limit := uint64(3)
+	if c.Limit > 0 {
+		limit = c.Limit
+	}
+
+	location := time.UTC
+	if c.Location != nil {
+		location = c.Location
+	}
+
+	var interval time.Duration
+	switch c.Period {
+	case PeriodDay:
+		interval = 25 * time.Hour
+	case PeriodWeek:
+		interval = 7*24*time.Hour + time.Hour
+	case PeriodMonth:
+		interval = 31*24*time.Hour + time.Hour
+	default:
+		return nil, fmt.Errorf("unknown period %d", c.Period)
+	}
+
+	l := &Limiter{
+		limit:     limit,
+		templates: make(map[string]uint64, len(c.Templates)),
+		stores:    make(map[uint64]limiter.Store),
+		period:    c.Period,
+		location:  location,
+		dryRun:    c.DryRun,
+		audit:     c.Audit,
+		now:       time.Now,
+	}
+
+	limits := []uint64{limit}
+	for template, n := range c.Templates {
+		if n == 0 {
+			return nil, fmt.Errorf("template %q: limit must be positive", template)
+		}
+		l.templates[template] = n
+		limits = append(limits, n)
+	}
+	for _, n := range limits {
+		if _, ok := l.stores[n]; ok {
+			continue
+		}
+		s, err := f(n, interval)
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("failed to create store for limit %d: %w", n, err)
+		}
+		l.stores[n] = s
+	}
+	return l, nil
+}
+
+// Allow counts a notification of the template to the recipient, like an email
+// address or phone number in a normalized form, and reports whether to send
+// it. In dry-run mode, it is always allowed. If the store fails, Allow returns
+// the error, and whether the store failed open.
+func (l *Limiter) Allow(ctx context.Context, recipient, template string) (bool, error) {
+	limit, ok := l.templates[template]
+	if !ok {
+		limit = l.limit
+	}
+
+	period := l.periodOf(l.now())
+	key := period + ":" + strconv.Itoa(len(template)) + ":" + template + ":" + recipient
+	res, err := l.stores[limit].Take(ctx, key)
+	if err != nil {
+		return res.Allowed, fmt.Errorf("failed to take: %w", err)
+	}
+	if res.Allowed {
+		return true, nil
+	}
+
+	if l.audit != nil {
+		l.audit(&Event{
+			Recipient: recipient,
+			Template:  template,
+			Period:    period,
+			Limit:     limit,
+			DryRun:    l.dryRun,
+		})
+	}
+	return l.dryRun, nil
+}
+
+// Close closes the stores.
+func (l *Limiter) Close() error {
+	var err error
+	for _, s := range l.stores {
+		if cerr := s.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// periodOf returns the name of the calendar period of t.
+func (l *Limiter) periodOf(t time.Time) string {
+	t = t.In(l.location)
+	switch l.period {
+	case PeriodWeek:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	case PeriodMonth:
+		return t.Format("2006-01")
+	default:
+		return t.Format("2006-01-02")
+	}
+}
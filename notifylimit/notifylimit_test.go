@@ -0,0 +1,174 @@
+package notifylimit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/limittest"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		dryRun  bool
+		allowed []bool
+	}{
+		{
+			name:    "enforced",
+			allowed: []bool{true, true, false},
+		},
+		{
+			name:    "dry_run",
+			dryRun:  true,
+			allowed: []bool{true, true, true},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var events []*Event
+			l, err := New(func(tokens uint64, interval time.Duration) (limiter.Store, error) {
+				return limittest.NewStore(tokens, interval), nil
+			}, &Config{
+				Limit:     2,
+				Templates: map[string]uint64{"security": 3},
+				DryRun:    tc.dryRun,
+				Audit:     func(e *Event) { events = append(events, e) },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			now := time.Date(2020, time.January, 31, 12, 0, 0, 0, time.UTC)
+			l.now = func() time.Time { return now }
+
+			ctx := context.Background()
+			allow := func(recipient, template string) bool {
+				ok, err := l.Allow(ctx, recipient, template)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return ok
+			}
+
+			for i, want := range tc.allowed {
+				if got := allow("alice@example.com", "digest"); got != want {
+					t.Errorf("send %d: expected %t to be %t", i, got, want)
+				}
+			}
+
+			// Other templates and recipients have their own limits.
+			for i := 0; i < 3; i++ {
+				if !allow("alice@example.com", "security") {
+					t.Errorf("security send %d: expected to be allowed", i)
+				}
+			}
+			if !allow("bob@example.com", "digest") {
+				t.Error("expected bob to be allowed")
+			}
+
+			if got, want := len(events), 1; got != want {
+				t.Fatalf("expected %d events to be %d", got, want)
+			}
+			want := &Event{
+				Recipient: "alice@example.com",
+				Template:  "digest",
+				Period:    "2020-01-31",
+				Limit:     2,
+				DryRun:    tc.dryRun,
+			}
+			if got := events[0]; *got != *want {
+				t.Errorf("expected %#v to be %#v", got, want)
+			}
+
+			// The next calendar day has a fresh allowance.
+			now = time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)
+			if !allow("alice@example.com", "digest") {
+				t.Error("expected the next day to be allowed")
+			}
+		})
+	}
+}
+
+func TestLimiter_periodOf(t *testing.T) {
+	t.Parallel()
+
+	tz := time.FixedZone("UTC+2", 2*60*60)
+	at := time.Date(2020, time.January, 31, 23, 30, 0, 0, time.UTC)
+
+	cases := []struct {
+		name     string
+		period   Period
+		location *time.Location
+		want     string
+	}{
+		{"day", PeriodDay, nil, "2020-01-31"},
+		{"day_location", PeriodDay, tz, "2020-02-01"},
+		{"week", PeriodWeek, nil, "2020-W05"},
+		{"month", PeriodMonth, nil, "2020-01"},
+		{"month_location", PeriodMonth, tz, "2020-02"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			l, err := New(func(tokens uint64, interval time.Duration) (limiter.Store, error) {
+				return limittest.NewStore(tokens, interval), nil
+			}, &Config{Period: tc.period, Location: tc.location})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			if got, want := l.periodOf(at), tc.want; got != want {
+				t.Errorf("expected %q to be %q", got, want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	f := func(tokens uint64, interval time.Duration) (limiter.Store, error) {
+		return limittest.NewStore(tokens, interval), nil
+	}
+
+	cases := []struct {
+		name string
+		f    StoreFunc
+		c    *Config
+	}{
+		{"nil_store_func", nil, nil},
+		{"zero_template", f, &Config{Templates: map[string]uint64{"digest": 0}}},
+		{"unknown_period", f, &Config{Period: Period(7)}},
+		{"store_error", func(uint64, time.Duration) (limiter.Store, error) {
+			return nil, fmt.Errorf("failed")
+		}, nil},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := New(tc.f, tc.c); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+// Package otelmetrics provides a limiter.MetricsSink that records limiter
+// metrics as OpenTelemetry instruments, for teams that have standardized on the
+// OpenTelemetry SDK.
+//
+// It is a separate module so that the limiter itself does not depend on
+// OpenTelemetry.
+package otelmetrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var _ limiter.MetricsSink = (*Sink)(nil)
+
+// instrumentationName is the name of the meter.
+const instrumentationName = "github.com/sethvargo/go-limiter/otelmetrics"
+
+// Sink records counts as Int64Counters and timings as Float64Histograms in
+// seconds. Instruments are created the first time each name is seen. Tags of
+// the form "name:value" become string attributes; tags without a colon become
+// attributes with an empty value.
+type Sink struct {
+	meter metric.Meter
+
+	lock       sync.Mutex
+	counters   map[string]metric.Int64Counter
+	histograms map[string]metric.Float64Histogram
+
+	registration metric.Registration
+}
+
+// Config is used as input to New.
+type Config struct {
+	// MeterProvider is the provider used to create the meter. The default value
+	// is the global provider.
+	MeterProvider metric.MeterProvider
+
+	// Stores are reported as up-down counters of their open and idle
+	// connections, with a "store" attribute set to the map key. Only stores
+	// that implement limiter.Debugger and report pool usage, like redisstore,
+	// are observed.
+	Stores map[string]limiter.Store
+}
+
+// New creates a sink that records metrics with a meter from the configured
+// provider.
+func New(c *Config) (*Sink, error) {
+	if c == nil {
+		c = new(Config)
+	}
+
+	provider := c.MeterProvider
+	if provider == nil {
+		provider = otel.GetMeterProvider()
+	}
+
+	s := &Sink{
+		meter:      provider.Meter(instrumentationName),
+		counters:   make(map[string]metric.Int64Counter),
+		histograms: make(map[string]metric.Float64Histogram),
+	}
+
+	if len(c.Stores) > 0 {
+		if err := s.observePools(c.Stores); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Count adds value to the named counter.
+func (s *Sink) Count(name string, value int64, tags []string) {
+	s.lock.Lock()
+	counter, ok := s.counters[name]
+	if !ok {
+		var err error
+		counter, err = s.meter.Int64Counter(name)
+		if err != nil {
+			s.lock.Unlock()
+			otel.Handle(err)
+			return
+		}
+		s.counters[name] = counter
+	}
+	s.lock.Unlock()
+
+	counter.Add(context.Background(), value, metric.WithAttributes(attributes(tags)...))
+}
+
+// Timing records the duration, in seconds, in the named histogram.
+func (s *Sink) Timing(name string, d time.Duration, tags []string) {
+	s.lock.Lock()
+	histogram, ok := s.histograms[name]
+	if !ok {
+		var err error
+		histogram, err = s.meter.Float64Histogram(name, metric.WithUnit("s"))
+		if err != nil {
+			s.lock.Unlock()
+			otel.Handle(err)
+			return
+		}
+		s.histograms[name] = histogram
+	}
+	s.lock.Unlock()
+
+	histogram.Record(context.Background(), d.Seconds(), metric.WithAttributes(attributes(tags)...))
+}
+
+// Close unregisters the pool observers.
+func (s *Sink) Close() error {
+	if s.registration == nil {
+		return nil
+	}
+	return s.registration.Unregister()
+}
+
+// observePools registers up-down counters for the connection pools of the
+// stores.
+func (s *Sink) observePools(stores map[string]limiter.Store) error {
+	open, err := s.meter.Int64ObservableUpDownCounter("limiter.pool.open",
+		metric.WithDescription("The number of open connections to the backend."))
+	if err != nil {
+		return fmt.Errorf("failed to create pool counter: %w", err)
+	}
+	idle, err := s.meter.Int64ObservableUpDownCounter("limiter.pool.idle",
+		metric.WithDescription("The number of idle connections to the backend."))
+	if err != nil {
+		return fmt.Errorf("failed to create pool counter: %w", err)
+	}
+
+	s.registration, err = s.meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for name, store := range stores {
+			d, ok := store.(limiter.Debugger)
+			if !ok {
+				continue
+			}
+
+			vars := d.DebugVars()
+			attrs := metric.WithAttributes(attribute.String("store", name))
+			if v, ok := vars["pool_open"].(int); ok {
+				o.ObserveInt64(open, int64(v), attrs)
+			}
+			if v, ok := vars["pool_idle"].(int); ok {
+				o.ObserveInt64(idle, int64(v), attrs)
+			}
+		}
+		return nil
+	}, open, idle)
+	if err != nil {
+		return fmt.Errorf("failed to register pool observer: %w", err)
+	}
+	return nil
+}
+
+// attributes converts "name:value" tags into attributes.
+func attributes(tags []string) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(tags))
+	for _, tag := range tags {
+		k, v := tag, ""
+		if i := strings.IndexByte(tag, ':'); i >= 0 {
+			k, v = tag[:i], tag[i+1:]
+		}
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	return attrs
+}
@@ -0,0 +1,86 @@
+package otelmetrics_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/memorystore"
+	"github.com/sethvargo/go-limiter/otelmetrics"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestSink(t *testing.T) {
+	t.Parallel()
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	sink, err := otelmetrics.New(&otelmetrics.Config{
+		MeterProvider: provider,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	ms, err := memorystore.New(&memorystore.Config{
+		Tokens:   1,
+		Interval: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := limiter.WithMetrics(ms, sink)
+	defer s.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := s.Take(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+
+	sum, ok := metrics[limiter.MetricTake].(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("expected %s to be an int64 sum, got %T", limiter.MetricTake, metrics[limiter.MetricTake])
+	}
+	counts := make(map[string]int64)
+	for _, dp := range sum.DataPoints {
+		v, _ := dp.Attributes.Value(attribute.Key("result"))
+		counts[v.AsString()] = dp.Value
+	}
+	if got, want := counts["allowed"], int64(1); got != want {
+		t.Errorf("allowed: expected %d to be %d", got, want)
+	}
+	if got, want := counts["denied"], int64(2); got != want {
+		t.Errorf("denied: expected %d to be %d", got, want)
+	}
+
+	hist, ok := metrics[limiter.MetricTakeDuration].(metricdata.Histogram[float64])
+	if !ok {
+		t.Fatalf("expected %s to be a float64 histogram, got %T", limiter.MetricTakeDuration, metrics[limiter.MetricTakeDuration])
+	}
+	var total uint64
+	for _, dp := range hist.DataPoints {
+		total += dp.Count
+	}
+	if got, want := total, uint64(3); got != want {
+		t.Errorf("durations: expected %d to be %d", got, want)
+	}
+}
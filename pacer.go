@@ -0,0 +1,73 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Pacer paces a pipeline, like a crawler, with the decisions of a store:
+// instead of admitting or rejecting work, Take blocks until the caller should
+// proceed. Calls are spread evenly across the rest of each interval, so the
+// tokens are not spent in a burst at its start. Spacing is tracked per Pacer,
+// so share one Pacer between the goroutines of a pipeline.
+type Pacer struct {
+	store Store
+	key   string
+
+	lock sync.Mutex
+	last time.Time
+}
+
+// NewPacer creates a pacer for the key of the store.
+func NewPacer(s Store, key string) *Pacer {
+	return &Pacer{
+		store: s,
+		key:   key,
+	}
+}
+
+// Take blocks until the caller should proceed, and returns the time it should
+// proceed at. If the store returns an error, Take proceeds immediately, so a
+// failing store does not stall the pipeline; use TakeContext to handle errors.
+func (p *Pacer) Take() time.Time {
+	t, _ := p.TakeContext(context.Background())
+	return t
+}
+
+// TakeContext is like Take, but returns early with an error if ctx is done.
+// If the store fails, it returns the error, and the current time if the store
+// failed open.
+func (p *Pacer) TakeContext(ctx context.Context) (time.Time, error) {
+	res, err := Wait(ctx, p.store, p.key)
+	if err != nil {
+		return time.Now(), err
+	}
+
+	// Spread the remaining tokens, including the one just taken, over the
+	// rest of the interval.
+	now := time.Now()
+	spacing := res.ResetAt.Sub(now) / time.Duration(res.Remaining+1)
+	if spacing < 0 {
+		spacing = 0
+	}
+
+	p.lock.Lock()
+	at := p.last.Add(spacing)
+	if at.Before(now) {
+		at = now
+	}
+	p.last = at
+	p.lock.Unlock()
+
+	if d := at.Sub(now); d > 0 {
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return at, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return at, nil
+}
@@ -0,0 +1,59 @@
+package limiter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/limittest"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestPacer(t *testing.T) {
+	t.Parallel()
+
+	s, err := memorystore.New(&memorystore.Config{
+		Tokens:   10,
+		Interval: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	p := limiter.NewPacer(s, "key")
+
+	start := time.Now()
+	var last time.Time
+	for i := 0; i < 5; i++ {
+		at := p.Take()
+		if i > 0 && !at.After(last) {
+			t.Errorf("take %d: expected %s to be after %s", i, at, last)
+		}
+		last = at
+	}
+
+	// The takes are spaced across the interval instead of in a burst.
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected takes to be paced, took %s", elapsed)
+	}
+}
+
+func TestPacer_Error(t *testing.T) {
+	t.Parallel()
+
+	s := limittest.NewStore(1, time.Minute)
+	s.Fail(errors.New("boom"), false)
+
+	p := limiter.NewPacer(s, "key")
+	if _, err := p.TakeContext(context.Background()); err == nil {
+		t.Error("expected error")
+	}
+
+	// Take proceeds anyway.
+	if at := p.Take(); at.IsZero() {
+		t.Error("expected a time")
+	}
+}
@@ -0,0 +1,29 @@
+package limiter
+
+import "context"
+
+// Peeker is implemented by stores that can report the state of a key without
+// taking from it, so clients can check their quota instead of probing until
+// they are rejected. It is an optional interface; use a type assertion to
+// check whether a store supports it.
+type Peeker interface {
+	// Peek returns the key's bucket as the next take would see it: the limit,
+	// the remaining tokens, and the reset time. Allowed reports whether any
+	// tokens remain, and RetryAfter is set if none do. Priorities, borrowing,
+	// and any global bucket are not considered. A key that does not exist has
+	// a full bucket.
+	Peek(ctx context.Context, key string) (Result, error)
+}
+
+// Annotator is implemented by stores that can keep small opaque metadata with
+// a key, like the plan of its customer or when it was first seen, so admin
+// tooling has context about a key without a second datastore. It is an
+// optional interface; use a type assertion to check whether a store supports
+// it.
+type Annotator interface {
+	// SetMetadata stores the metadata with the key, replacing any earlier
+	// metadata. It is kept alongside the key's bucket, expires with it, and is
+	// returned by Peek in Result.Metadata. Setting the metadata of a key that
+	// does not exist creates it with a full bucket.
+	SetMetadata(ctx context.Context, key, metadata string) error
+}
@@ -0,0 +1,1013 @@
+// Code generated by "go run gen_preserve.go"; DO NOT EDIT.
+
+package limiter
+
+// The bits of the optional capabilities of a store, for preserved.
+const (
+	capRefunder uint = 1 << iota
+	capInspector
+	capDebugger
+	capPeeker
+	capCharger
+	capAnnotator
+	capDeleter
+
+	// numCapabilities is the number of optional capabilities.
+	numCapabilities = 7
+)
+
+// capabilities returns the bits of the optional capabilities s implements.
+func capabilities(s Store) uint {
+	var caps uint
+	if _, ok := s.(Refunder); ok {
+		caps |= capRefunder
+	}
+	if _, ok := s.(Inspector); ok {
+		caps |= capInspector
+	}
+	if _, ok := s.(Debugger); ok {
+		caps |= capDebugger
+	}
+	if _, ok := s.(Peeker); ok {
+		caps |= capPeeker
+	}
+	if _, ok := s.(Charger); ok {
+		caps |= capCharger
+	}
+	if _, ok := s.(Annotator); ok {
+		caps |= capAnnotator
+	}
+	if _, ok := s.(Deleter); ok {
+		caps |= capDeleter
+	}
+	return caps
+}
+
+// preserved returns d as a store that implements only the optional
+// capabilities in caps.
+func preserved(caps uint, d Decorator) Store {
+	switch caps {
+	case 0:
+		return struct {
+			Store
+		}{d}
+	case capRefunder:
+		return struct {
+			Store
+			Refunder
+		}{d, d}
+	case capInspector:
+		return struct {
+			Store
+			Inspector
+		}{d, d}
+	case capRefunder | capInspector:
+		return struct {
+			Store
+			Refunder
+			Inspector
+		}{d, d, d}
+	case capDebugger:
+		return struct {
+			Store
+			Debugger
+		}{d, d}
+	case capRefunder | capDebugger:
+		return struct {
+			Store
+			Refunder
+			Debugger
+		}{d, d, d}
+	case capInspector | capDebugger:
+		return struct {
+			Store
+			Inspector
+			Debugger
+		}{d, d, d}
+	case capRefunder | capInspector | capDebugger:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+		}{d, d, d, d}
+	case capPeeker:
+		return struct {
+			Store
+			Peeker
+		}{d, d}
+	case capRefunder | capPeeker:
+		return struct {
+			Store
+			Refunder
+			Peeker
+		}{d, d, d}
+	case capInspector | capPeeker:
+		return struct {
+			Store
+			Inspector
+			Peeker
+		}{d, d, d}
+	case capRefunder | capInspector | capPeeker:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Peeker
+		}{d, d, d, d}
+	case capDebugger | capPeeker:
+		return struct {
+			Store
+			Debugger
+			Peeker
+		}{d, d, d}
+	case capRefunder | capDebugger | capPeeker:
+		return struct {
+			Store
+			Refunder
+			Debugger
+			Peeker
+		}{d, d, d, d}
+	case capInspector | capDebugger | capPeeker:
+		return struct {
+			Store
+			Inspector
+			Debugger
+			Peeker
+		}{d, d, d, d}
+	case capRefunder | capInspector | capDebugger | capPeeker:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+			Peeker
+		}{d, d, d, d, d}
+	case capCharger:
+		return struct {
+			Store
+			Charger
+		}{d, d}
+	case capRefunder | capCharger:
+		return struct {
+			Store
+			Refunder
+			Charger
+		}{d, d, d}
+	case capInspector | capCharger:
+		return struct {
+			Store
+			Inspector
+			Charger
+		}{d, d, d}
+	case capRefunder | capInspector | capCharger:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Charger
+		}{d, d, d, d}
+	case capDebugger | capCharger:
+		return struct {
+			Store
+			Debugger
+			Charger
+		}{d, d, d}
+	case capRefunder | capDebugger | capCharger:
+		return struct {
+			Store
+			Refunder
+			Debugger
+			Charger
+		}{d, d, d, d}
+	case capInspector | capDebugger | capCharger:
+		return struct {
+			Store
+			Inspector
+			Debugger
+			Charger
+		}{d, d, d, d}
+	case capRefunder | capInspector | capDebugger | capCharger:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+			Charger
+		}{d, d, d, d, d}
+	case capPeeker | capCharger:
+		return struct {
+			Store
+			Peeker
+			Charger
+		}{d, d, d}
+	case capRefunder | capPeeker | capCharger:
+		return struct {
+			Store
+			Refunder
+			Peeker
+			Charger
+		}{d, d, d, d}
+	case capInspector | capPeeker | capCharger:
+		return struct {
+			Store
+			Inspector
+			Peeker
+			Charger
+		}{d, d, d, d}
+	case capRefunder | capInspector | capPeeker | capCharger:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Peeker
+			Charger
+		}{d, d, d, d, d}
+	case capDebugger | capPeeker | capCharger:
+		return struct {
+			Store
+			Debugger
+			Peeker
+			Charger
+		}{d, d, d, d}
+	case capRefunder | capDebugger | capPeeker | capCharger:
+		return struct {
+			Store
+			Refunder
+			Debugger
+			Peeker
+			Charger
+		}{d, d, d, d, d}
+	case capInspector | capDebugger | capPeeker | capCharger:
+		return struct {
+			Store
+			Inspector
+			Debugger
+			Peeker
+			Charger
+		}{d, d, d, d, d}
+	case capRefunder | capInspector | capDebugger | capPeeker | capCharger:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+			Peeker
+			Charger
+		}{d, d, d, d, d, d}
+	case capAnnotator:
+		return struct {
+			Store
+			Annotator
+		}{d, d}
+	case capRefunder | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Annotator
+		}{d, d, d}
+	case capInspector | capAnnotator:
+		return struct {
+			Store
+			Inspector
+			Annotator
+		}{d, d, d}
+	case capRefunder | capInspector | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Annotator
+		}{d, d, d, d}
+	case capDebugger | capAnnotator:
+		return struct {
+			Store
+			Debugger
+			Annotator
+		}{d, d, d}
+	case capRefunder | capDebugger | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Debugger
+			Annotator
+		}{d, d, d, d}
+	case capInspector | capDebugger | capAnnotator:
+		return struct {
+			Store
+			Inspector
+			Debugger
+			Annotator
+		}{d, d, d, d}
+	case capRefunder | capInspector | capDebugger | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+			Annotator
+		}{d, d, d, d, d}
+	case capPeeker | capAnnotator:
+		return struct {
+			Store
+			Peeker
+			Annotator
+		}{d, d, d}
+	case capRefunder | capPeeker | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Peeker
+			Annotator
+		}{d, d, d, d}
+	case capInspector | capPeeker | capAnnotator:
+		return struct {
+			Store
+			Inspector
+			Peeker
+			Annotator
+		}{d, d, d, d}
+	case capRefunder | capInspector | capPeeker | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Peeker
+			Annotator
+		}{d, d, d, d, d}
+	case capDebugger | capPeeker | capAnnotator:
+		return struct {
+			Store
+			Debugger
+			Peeker
+			Annotator
+		}{d, d, d, d}
+	case capRefunder | capDebugger | capPeeker | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Debugger
+			Peeker
+			Annotator
+		}{d, d, d, d, d}
+	case capInspector | capDebugger | capPeeker | capAnnotator:
+		return struct {
+			Store
+			Inspector
+			Debugger
+			Peeker
+			Annotator
+		}{d, d, d, d, d}
+	case capRefunder | capInspector | capDebugger | capPeeker | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+			Peeker
+			Annotator
+		}{d, d, d, d, d, d}
+	case capCharger | capAnnotator:
+		return struct {
+			Store
+			Charger
+			Annotator
+		}{d, d, d}
+	case capRefunder | capCharger | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Charger
+			Annotator
+		}{d, d, d, d}
+	case capInspector | capCharger | capAnnotator:
+		return struct {
+			Store
+			Inspector
+			Charger
+			Annotator
+		}{d, d, d, d}
+	case capRefunder | capInspector | capCharger | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Charger
+			Annotator
+		}{d, d, d, d, d}
+	case capDebugger | capCharger | capAnnotator:
+		return struct {
+			Store
+			Debugger
+			Charger
+			Annotator
+		}{d, d, d, d}
+	case capRefunder | capDebugger | capCharger | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Debugger
+			Charger
+			Annotator
+		}{d, d, d, d, d}
+	case capInspector | capDebugger | capCharger | capAnnotator:
+		return struct {
+			Store
+			Inspector
+			Debugger
+			Charger
+			Annotator
+		}{d, d, d, d, d}
+	case capRefunder | capInspector | capDebugger | capCharger | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+			Charger
+			Annotator
+		}{d, d, d, d, d, d}
+	case capPeeker | capCharger | capAnnotator:
+		return struct {
+			Store
+			Peeker
+			Charger
+			Annotator
+		}{d, d, d, d}
+	case capRefunder | capPeeker | capCharger | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Peeker
+			Charger
+			Annotator
+		}{d, d, d, d, d}
+	case capInspector | capPeeker | capCharger | capAnnotator:
+		return struct {
+			Store
+			Inspector
+			Peeker
+			Charger
+			Annotator
+		}{d, d, d, d, d}
+	case capRefunder | capInspector | capPeeker | capCharger | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Peeker
+			Charger
+			Annotator
+		}{d, d, d, d, d, d}
+	case capDebugger | capPeeker | capCharger | capAnnotator:
+		return struct {
+			Store
+			Debugger
+			Peeker
+			Charger
+			Annotator
+		}{d, d, d, d, d}
+	case capRefunder | capDebugger | capPeeker | capCharger | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Debugger
+			Peeker
+			Charger
+			Annotator
+		}{d, d, d, d, d, d}
+	case capInspector | capDebugger | capPeeker | capCharger | capAnnotator:
+		return struct {
+			Store
+			Inspector
+			Debugger
+			Peeker
+			Charger
+			Annotator
+		}{d, d, d, d, d, d}
+	case capRefunder | capInspector | capDebugger | capPeeker | capCharger | capAnnotator:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+			Peeker
+			Charger
+			Annotator
+		}{d, d, d, d, d, d, d}
+	case capDeleter:
+		return struct {
+			Store
+			Deleter
+		}{d, d}
+	case capRefunder | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Deleter
+		}{d, d, d}
+	case capInspector | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Deleter
+		}{d, d, d}
+	case capRefunder | capInspector | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Deleter
+		}{d, d, d, d}
+	case capDebugger | capDeleter:
+		return struct {
+			Store
+			Debugger
+			Deleter
+		}{d, d, d}
+	case capRefunder | capDebugger | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Debugger
+			Deleter
+		}{d, d, d, d}
+	case capInspector | capDebugger | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Debugger
+			Deleter
+		}{d, d, d, d}
+	case capRefunder | capInspector | capDebugger | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+			Deleter
+		}{d, d, d, d, d}
+	case capPeeker | capDeleter:
+		return struct {
+			Store
+			Peeker
+			Deleter
+		}{d, d, d}
+	case capRefunder | capPeeker | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Peeker
+			Deleter
+		}{d, d, d, d}
+	case capInspector | capPeeker | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Peeker
+			Deleter
+		}{d, d, d, d}
+	case capRefunder | capInspector | capPeeker | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Peeker
+			Deleter
+		}{d, d, d, d, d}
+	case capDebugger | capPeeker | capDeleter:
+		return struct {
+			Store
+			Debugger
+			Peeker
+			Deleter
+		}{d, d, d, d}
+	case capRefunder | capDebugger | capPeeker | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Debugger
+			Peeker
+			Deleter
+		}{d, d, d, d, d}
+	case capInspector | capDebugger | capPeeker | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Debugger
+			Peeker
+			Deleter
+		}{d, d, d, d, d}
+	case capRefunder | capInspector | capDebugger | capPeeker | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+			Peeker
+			Deleter
+		}{d, d, d, d, d, d}
+	case capCharger | capDeleter:
+		return struct {
+			Store
+			Charger
+			Deleter
+		}{d, d, d}
+	case capRefunder | capCharger | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Charger
+			Deleter
+		}{d, d, d, d}
+	case capInspector | capCharger | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Charger
+			Deleter
+		}{d, d, d, d}
+	case capRefunder | capInspector | capCharger | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Charger
+			Deleter
+		}{d, d, d, d, d}
+	case capDebugger | capCharger | capDeleter:
+		return struct {
+			Store
+			Debugger
+			Charger
+			Deleter
+		}{d, d, d, d}
+	case capRefunder | capDebugger | capCharger | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Debugger
+			Charger
+			Deleter
+		}{d, d, d, d, d}
+	case capInspector | capDebugger | capCharger | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Debugger
+			Charger
+			Deleter
+		}{d, d, d, d, d}
+	case capRefunder | capInspector | capDebugger | capCharger | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+			Charger
+			Deleter
+		}{d, d, d, d, d, d}
+	case capPeeker | capCharger | capDeleter:
+		return struct {
+			Store
+			Peeker
+			Charger
+			Deleter
+		}{d, d, d, d}
+	case capRefunder | capPeeker | capCharger | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Peeker
+			Charger
+			Deleter
+		}{d, d, d, d, d}
+	case capInspector | capPeeker | capCharger | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Peeker
+			Charger
+			Deleter
+		}{d, d, d, d, d}
+	case capRefunder | capInspector | capPeeker | capCharger | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Peeker
+			Charger
+			Deleter
+		}{d, d, d, d, d, d}
+	case capDebugger | capPeeker | capCharger | capDeleter:
+		return struct {
+			Store
+			Debugger
+			Peeker
+			Charger
+			Deleter
+		}{d, d, d, d, d}
+	case capRefunder | capDebugger | capPeeker | capCharger | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Debugger
+			Peeker
+			Charger
+			Deleter
+		}{d, d, d, d, d, d}
+	case capInspector | capDebugger | capPeeker | capCharger | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Debugger
+			Peeker
+			Charger
+			Deleter
+		}{d, d, d, d, d, d}
+	case capRefunder | capInspector | capDebugger | capPeeker | capCharger | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+			Peeker
+			Charger
+			Deleter
+		}{d, d, d, d, d, d, d}
+	case capAnnotator | capDeleter:
+		return struct {
+			Store
+			Annotator
+			Deleter
+		}{d, d, d}
+	case capRefunder | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Annotator
+			Deleter
+		}{d, d, d, d}
+	case capInspector | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Annotator
+			Deleter
+		}{d, d, d, d}
+	case capRefunder | capInspector | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Annotator
+			Deleter
+		}{d, d, d, d, d}
+	case capDebugger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Debugger
+			Annotator
+			Deleter
+		}{d, d, d, d}
+	case capRefunder | capDebugger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Debugger
+			Annotator
+			Deleter
+		}{d, d, d, d, d}
+	case capInspector | capDebugger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Debugger
+			Annotator
+			Deleter
+		}{d, d, d, d, d}
+	case capRefunder | capInspector | capDebugger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d}
+	case capPeeker | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Peeker
+			Annotator
+			Deleter
+		}{d, d, d, d}
+	case capRefunder | capPeeker | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Peeker
+			Annotator
+			Deleter
+		}{d, d, d, d, d}
+	case capInspector | capPeeker | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Peeker
+			Annotator
+			Deleter
+		}{d, d, d, d, d}
+	case capRefunder | capInspector | capPeeker | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Peeker
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d}
+	case capDebugger | capPeeker | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Debugger
+			Peeker
+			Annotator
+			Deleter
+		}{d, d, d, d, d}
+	case capRefunder | capDebugger | capPeeker | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Debugger
+			Peeker
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d}
+	case capInspector | capDebugger | capPeeker | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Debugger
+			Peeker
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d}
+	case capRefunder | capInspector | capDebugger | capPeeker | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+			Peeker
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d, d}
+	case capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d}
+	case capRefunder | capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d, d}
+	case capInspector | capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d, d}
+	case capRefunder | capInspector | capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d}
+	case capDebugger | capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Debugger
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d, d}
+	case capRefunder | capDebugger | capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Debugger
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d}
+	case capInspector | capDebugger | capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Debugger
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d}
+	case capRefunder | capInspector | capDebugger | capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d, d}
+	case capPeeker | capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Peeker
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d, d}
+	case capRefunder | capPeeker | capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Peeker
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d}
+	case capInspector | capPeeker | capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Peeker
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d}
+	case capRefunder | capInspector | capPeeker | capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Peeker
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d, d}
+	case capDebugger | capPeeker | capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Debugger
+			Peeker
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d}
+	case capRefunder | capDebugger | capPeeker | capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Debugger
+			Peeker
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d, d}
+	case capInspector | capDebugger | capPeeker | capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Inspector
+			Debugger
+			Peeker
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d, d}
+	case capRefunder | capInspector | capDebugger | capPeeker | capCharger | capAnnotator | capDeleter:
+		return struct {
+			Store
+			Refunder
+			Inspector
+			Debugger
+			Peeker
+			Charger
+			Annotator
+			Deleter
+		}{d, d, d, d, d, d, d, d}
+	default:
+		panic("limiter: unknown capabilities")
+	}
+}
@@ -0,0 +1,59 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+)
+
+// fullStore implements every optional capability.
+type fullStore struct{}
+
+func (fullStore) Take(context.Context, string) (Result, error) { return Result{}, nil }
+func (fullStore) Close() error                                 { return nil }
+func (fullStore) Refund(context.Context, string, uint64) error { return nil }
+func (fullStore) Keys(context.Context, string, uint64) ([]string, uint64, error) {
+	return nil, 0, nil
+}
+func (fullStore) Stats(context.Context, int) (Stats, error)         { return Stats{}, nil }
+func (fullStore) DebugVars() map[string]interface{}                 { return nil }
+func (fullStore) Peek(context.Context, string) (Result, error)      { return Result{}, nil }
+func (fullStore) Charge(context.Context, string, uint64) error      { return nil }
+func (fullStore) SetMetadata(context.Context, string, string) error { return nil }
+func (fullStore) Delete(context.Context, string) error              { return nil }
+
+func TestPreserve_subsets(t *testing.T) {
+	t.Parallel()
+
+	// The assertions are written out, rather than using capabilities, so the
+	// generated code is checked against them.
+	assertions := map[uint]func(s Store) bool{
+		capRefunder:  func(s Store) bool { _, ok := s.(Refunder); return ok },
+		capInspector: func(s Store) bool { _, ok := s.(Inspector); return ok },
+		capDebugger:  func(s Store) bool { _, ok := s.(Debugger); return ok },
+		capPeeker:    func(s Store) bool { _, ok := s.(Peeker); return ok },
+		capCharger:   func(s Store) bool { _, ok := s.(Charger); return ok },
+		capAnnotator: func(s Store) bool { _, ok := s.(Annotator); return ok },
+		capDeleter:   func(s Store) bool { _, ok := s.(Deleter); return ok },
+	}
+	if got, want := len(assertions), numCapabilities; got != want {
+		t.Fatalf("expected %d assertions to be %d", got, want)
+	}
+
+	check := func(tb testing.TB, s Store, caps uint) {
+		tb.Helper()
+
+		for bit, implements := range assertions {
+			if got, want := implements(s), caps&bit != 0; got != want {
+				tb.Errorf("capabilities %07b: bit %07b: expected %t to be %t", caps, bit, got, want)
+			}
+		}
+	}
+
+	for caps := uint(0); caps < 1<<numCapabilities; caps++ {
+		// s implements exactly the capabilities in caps, and a decorator of it
+		// must too.
+		s := preserved(caps, Decorated{fullStore{}})
+		check(t, s, caps)
+		check(t, Preserve(s, Decorated{s}), caps)
+	}
+}
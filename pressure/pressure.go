@@ -0,0 +1,160 @@
+// Package pressure measures how hard the limits of a limiter.Store are being
+// hit, as the fraction of recent takes that were denied, so upstream
+// components, like load balancer weights or queue consumers, can shed or slow
+// down load before every client hits its limit.
+//
+// Takes are weighted by an exponential decay, so recent takes count the most
+// and memory does not grow with traffic. Tracking happens in process, so each
+// instance of an application reports its own traffic.
+package pressure
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+var _ limiter.Store = (*Store)(nil)
+
+// Store wraps a limiter.Store and records the result of every take.
+type Store struct {
+	store limiter.Store
+
+	halfLife      time.Duration
+	trendHalfLife time.Duration
+
+	// lock guards the averages and the time they were last decayed.
+	lock        sync.Mutex
+	last        time.Time
+	short, long average
+}
+
+// average is an exponentially decayed count of takes and denials.
+type average struct {
+	takes, denials float64
+}
+
+// Config is used as input to New.
+type Config struct {
+	// HalfLife is the age at which a take counts half as much toward the
+	// pressure as a new one. Shorter values react faster, but are noisier. The
+	// default value is 10 seconds.
+	HalfLife time.Duration
+
+	// TrendHalfLife is the half-life of the longer average the pressure is
+	// compared to for its trend. It must be longer than HalfLife. The default
+	// value is 6 times HalfLife.
+	TrendHalfLife time.Duration
+}
+
+// Pressure is a snapshot of the recent denials of a store.
+type Pressure struct {
+	// Denied is the fraction of recent takes that were denied, in [0, 1].
+	// Weights of less than one take count as one, so the pressure fades when
+	// traffic stops instead of staying at its last value.
+	Denied float64
+
+	// Trend is Denied minus the fraction of takes denied over the longer
+	// TrendHalfLife. It is positive while denials are rising and negative
+	// while they are falling.
+	Trend float64
+}
+
+// New wraps the store to measure its pressure.
+func New(s limiter.Store, c *Config) (*Store, error) {
+	if s == nil {
+		return nil, fmt.Errorf("missing store")
+	}
+	if c == nil {
+		c = new(Config)
+	}
+
+	halfLife := 10 * time.Second
+	if c.HalfLife > 0 {
+		halfLife = c.HalfLife
+	}
+
+	trendHalfLife := 6 * halfLife
+	if c.TrendHalfLife > 0 {
+		trendHalfLife = c.TrendHalfLife
+	}
+	if trendHalfLife <= halfLife {
+		return nil, fmt.Errorf("trend half-life must be longer than half-life")
+	}
+
+	return &Store{
+		store:         s,
+		halfLife:      halfLife,
+		trendHalfLife: trendHalfLife,
+		last:          time.Now(),
+	}, nil
+}
+
+// Take takes from the underlying store and records the result. Takes that
+// return an error are not recorded, since they say nothing about the limits.
+func (s *Store) Take(ctx context.Context, key string) (limiter.Result, error) {
+	res, err := s.store.Take(ctx, key)
+	if err != nil {
+		return res, err
+	}
+
+	var denied float64
+	if !res.Allowed {
+		denied = 1
+	}
+
+	s.lock.Lock()
+	s.decay(time.Now())
+	s.short.takes++
+	s.short.denials += denied
+	s.long.takes++
+	s.long.denials += denied
+	s.lock.Unlock()
+
+	return res, nil
+}
+
+// Pressure returns the current pressure.
+func (s *Store) Pressure() Pressure {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.decay(time.Now())
+	denied := s.short.fraction()
+	return Pressure{
+		Denied: denied,
+		Trend:  denied - s.long.fraction(),
+	}
+}
+
+// Close closes the underlying store.
+func (s *Store) Close() error {
+	return s.store.Close()
+}
+
+// decay ages the averages to now. It must be called with the lock held.
+func (s *Store) decay(now time.Time) {
+	elapsed := now.Sub(s.last)
+	if elapsed <= 0 {
+		return
+	}
+	s.last = now
+
+	s.short.scale(math.Exp2(-float64(elapsed) / float64(s.halfLife)))
+	s.long.scale(math.Exp2(-float64(elapsed) / float64(s.trendHalfLife)))
+}
+
+func (a *average) scale(f float64) {
+	a.takes *= f
+	a.denials *= f
+}
+
+// fraction returns the fraction of takes that were denied, counting weights of
+// less than one take as one.
+func (a *average) fraction() float64 {
+	return a.denials / math.Max(a.takes, 1)
+}
@@ -0,0 +1,82 @@
+package pressure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestStore_Pressure(t *testing.T) {
+	t.Parallel()
+
+	ms, err := memorystore.New(&memorystore.Config{
+		Tokens:   20,
+		Interval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(ms, &Config{
+		HalfLife:      20 * time.Millisecond,
+		TrendHalfLife: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if got, want := s.Pressure(), (Pressure{}); got != want {
+		t.Errorf("expected %v to be %v", got, want)
+	}
+
+	ctx := context.Background()
+	take := func(n int) {
+		for i := 0; i < n; i++ {
+			if _, err := s.Take(ctx, "key"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// The allowed takes are old by the time of the denied ones.
+	take(20)
+	if p := s.Pressure(); p.Denied != 0 {
+		t.Errorf("expected no pressure, got %v", p)
+	}
+	time.Sleep(100 * time.Millisecond)
+	take(20)
+
+	p := s.Pressure()
+	if p.Denied < 0.9 {
+		t.Errorf("expected recent takes to be denied, got %v", p)
+	}
+	if p.Trend < 0.3 {
+		t.Errorf("expected rising pressure, got %v", p)
+	}
+
+	// Without traffic, the pressure fades.
+	time.Sleep(200 * time.Millisecond)
+	if p := s.Pressure(); p.Denied > 0.1 {
+		t.Errorf("expected pressure to fade, got %v", p)
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	ms, err := memorystore.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ms.Close()
+
+	if _, err := New(nil, nil); err == nil {
+		t.Error("expected error for missing store")
+	}
+	if _, err := New(ms, &Config{HalfLife: time.Minute, TrendHalfLife: time.Second}); err == nil {
+		t.Error("expected error for short trend half-life")
+	}
+}
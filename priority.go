@@ -0,0 +1,36 @@
+package limiter
+
+import "context"
+
+// Priority is the priority class of a take. Stores that support priorities
+// reserve part of each bucket for high-priority takes, so low-priority takes
+// are rejected first when the bucket runs low.
+type Priority int
+
+const (
+	// PriorityHigh is the default priority. High-priority takes may use all of the
+	// tokens in a bucket, including the reserved ones.
+	PriorityHigh Priority = iota
+
+	// PriorityLow is for work that can be deferred, like batch jobs. Low-priority
+	// takes are rejected once the remaining tokens fall to the reserve.
+	PriorityLow
+)
+
+// priorityKey is the context key for the priority.
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx that carries the given priority. Stores
+// read it in Take.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the priority carried by ctx, or PriorityHigh if
+// there is none.
+func PriorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityHigh
+}
@@ -0,0 +1,22 @@
+package limiter_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+func TestPriorityFromContext(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	if got, want := limiter.PriorityFromContext(ctx), limiter.PriorityHigh; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	ctx = limiter.WithPriority(ctx, limiter.PriorityLow)
+	if got, want := limiter.PriorityFromContext(ctx), limiter.PriorityLow; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
@@ -0,0 +1,98 @@
+// Package proxylimit lets reverse proxies enforce limits backed by a store, by
+// asking it whether to allow each request before they forward it.
+//
+// AuthHandler answers the subrequests of the NGINX auth_request module, which
+// only distinguishes allowed and forbidden responses, so denied requests are
+// answered with a 403 that NGINX can turn into a 429:
+//
+//	location / {
+//	    auth_request /limit;
+//	    auth_request_set $retry_after $upstream_http_retry_after;
+//	    error_page 403 = @limited;
+//	    proxy_pass http://app;
+//	}
+//
+//	location = /limit {
+//	    internal;
+//	    proxy_pass http://limiter:8081;
+//	    proxy_pass_request_body off;
+//	    proxy_set_header Content-Length "";
+//	    proxy_set_header X-Real-IP $remote_addr;
+//	}
+//
+//	location @limited {
+//	    add_header Retry-After $retry_after always;
+//	    return 429;
+//	}
+//
+// Agent is an HAProxy stream processing offload agent, which takes from the
+// key sent in the argument named "key" of any message, and sets the variables
+// "allowed", "remaining", "limit", and "retry_after" in the transaction scope:
+//
+//	[limiter]
+//	spoe-agent limiter
+//	    messages limiter-take
+//	    option var-prefix limiter
+//	    timeout hello 2s
+//	    timeout idle 2m
+//	    timeout processing 100ms
+//	    use-backend limiter-agents
+//
+//	spoe-message limiter-take
+//	    args key=src
+//	    event on-frontend-http-request
+//
+// Then enable it in the frontend, and deny the requests it did not allow:
+//
+//	filter spoe engine limiter config /etc/haproxy/limiter.conf
+//	http-request deny deny_status 429 if { var(txn.limiter.allowed) -m int eq 0 }
+//
+// If the store cannot decide, both fail like the store: requests are allowed
+// if it failed open, and answered with an error otherwise, which NGINX and
+// HAProxy treat as they are configured to.
+package proxylimit
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/httplimit"
+)
+
+// AuthHandler returns a handler for the subrequests of NGINX auth_request,
+// keyed by f, which sees the headers of the original request that NGINX
+// passes on. Allowed requests are answered with a 204, and denied ones with a
+// 403. Both carry the rate limit headers of httplimit, and denied ones a
+// Retry-After, for NGINX to copy to its response with auth_request_set. If the
+// KeyFunc or a store that failed closed return an error, the request is
+// answered with a 500.
+func AuthHandler(s limiter.Store, f httplimit.KeyFunc) (http.Handler, error) {
+	if s == nil {
+		return nil, fmt.Errorf("store cannot be nil")
+	}
+	if f == nil {
+		return nil, fmt.Errorf("key function cannot be nil")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := f(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		res, err := s.Take(r.Context(), key)
+		if err != nil && !res.Allowed {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		httplimit.SetHeaders(w.Header(), res, err)
+		if !res.Allowed {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}), nil
+}
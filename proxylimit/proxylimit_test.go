@@ -0,0 +1,66 @@
+package proxylimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/memorystore"
+	"github.com/sethvargo/go-limiter/proxylimit"
+)
+
+func TestAuthHandler(t *testing.T) {
+	t.Parallel()
+
+	store, err := memorystore.New(&memorystore.Config{
+		Tokens:   1,
+		Interval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	h, err := proxylimit.AuthHandler(store, httplimit.IPKeyFunc("X-Real-IP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/limit", nil)
+		r.Header.Set("X-Real-IP", ip)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("192.0.2.1")
+	if got, want := w.Code, http.StatusNoContent; got != want {
+		t.Fatalf("expected %d to be %d", got, want)
+	}
+	if got, want := w.Header().Get(httplimit.HeaderRateLimitRemaining), "0"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+
+	// NGINX only accepts 401 and 403 as denials.
+	w = serve("192.0.2.1")
+	if got, want := w.Code, http.StatusForbidden; got != want {
+		t.Fatalf("expected %d to be %d", got, want)
+	}
+	if got := w.Header().Get(httplimit.HeaderRetryAfter); got == "" {
+		t.Error("expected retry after")
+	}
+
+	if got, want := serve("192.0.2.2").Code, http.StatusNoContent; got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	if _, err := proxylimit.AuthHandler(nil, httplimit.IPKeyFunc()); err == nil {
+		t.Error("expected error for nil store")
+	}
+	if _, err := proxylimit.AuthHandler(store, nil); err == nil {
+		t.Error("expected error for nil key function")
+	}
+}
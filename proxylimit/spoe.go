@@ -0,0 +1,564 @@
+package proxylimit
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/httplimit"
+)
+
+// Frame types of the stream processing offload protocol.
+const (
+	frameHAProxyHello      = 1
+	frameHAProxyDisconnect = 2
+	frameNotify            = 3
+	frameAgentHello        = 101
+	frameAgentDisconnect   = 102
+	frameAck               = 103
+)
+
+// Types of typed data.
+const (
+	typeNull   = 0
+	typeBool   = 1
+	typeInt32  = 2
+	typeUint32 = 3
+	typeInt64  = 4
+	typeUint64 = 5
+	typeIPv4   = 6
+	typeIPv6   = 7
+	typeString = 8
+	typeBinary = 9
+)
+
+const (
+	// flagFin marks the last fragment of a frame. The agent does not support
+	// fragmentation, so every frame has it.
+	flagFin = 1
+
+	// actionSetVar sets a variable, and scopeTransaction is the scope of the
+	// variables the agent sets.
+	actionSetVar     = 1
+	scopeTransaction = 2
+
+	// maxFrameSize is the largest frame the agent accepts, unless HAProxy
+	// supports less.
+	maxFrameSize = 16380
+
+	// spopVersion is the version of the protocol the agent speaks.
+	spopVersion = "2.0"
+)
+
+// Status codes of disconnect frames.
+const (
+	statusNormal       = 0
+	statusTooBig       = 3
+	statusInvalidFrame = 4
+	statusBadVersion   = 5
+)
+
+var (
+	// errInvalidFrame is returned for frames that cannot be decoded.
+	errInvalidFrame = errors.New("invalid frame")
+
+	// errFrameTooBig is returned for frames larger than the frame size.
+	errFrameTooBig = errors.New("frame is too big")
+)
+
+// Agent serves takes to HAProxy over the stream processing offload protocol.
+type Agent struct {
+	store limiter.Store
+
+	lock      sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// NewAgent creates an agent that takes from the store. The store is not closed
+// with the agent.
+func NewAgent(s limiter.Store) (*Agent, error) {
+	if s == nil {
+		return nil, fmt.Errorf("store cannot be nil")
+	}
+	return &Agent{
+		store:     s,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Serve accepts connections from HAProxy on the listener and serves each in
+// its own goroutine. It returns nil once the agent is closed, and closes the
+// listener.
+func (a *Agent) Serve(l net.Listener) error {
+	a.lock.Lock()
+	if a.closed {
+		a.lock.Unlock()
+		l.Close()
+		return nil
+	}
+	a.listeners[l] = struct{}{}
+	a.lock.Unlock()
+
+	defer func() {
+		a.lock.Lock()
+		delete(a.listeners, l)
+		a.lock.Unlock()
+		l.Close()
+	}()
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			a.lock.Lock()
+			closed := a.closed
+			a.lock.Unlock()
+			if closed {
+				return nil
+			}
+
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				time.Sleep(delay)
+				continue
+			}
+			return fmt.Errorf("failed to accept: %w", err)
+		}
+		delay = 0
+
+		a.lock.Lock()
+		if a.closed {
+			a.lock.Unlock()
+			conn.Close()
+			return nil
+		}
+		a.conns[conn] = struct{}{}
+		a.wg.Add(1)
+		a.lock.Unlock()
+
+		go a.serveConn(conn)
+	}
+}
+
+// Close stops the listeners and closes the connections, and waits for the
+// frames in flight to be answered.
+func (a *Agent) Close() error {
+	a.lock.Lock()
+	a.closed = true
+	for l := range a.listeners {
+		l.Close()
+	}
+	for conn := range a.conns {
+		conn.Close()
+	}
+	a.lock.Unlock()
+
+	a.wg.Wait()
+	return nil
+}
+
+// frame is a decoded frame.
+type frame struct {
+	typ      byte
+	flags    uint32
+	streamID uint64
+	frameID  uint64
+	payload  []byte
+}
+
+// serveConn answers the hello of HAProxy, and then its notifications in order,
+// until it disconnects or sends a frame the agent cannot decode.
+func (a *Agent) serveConn(conn net.Conn) {
+	defer func() {
+		a.lock.Lock()
+		delete(a.conns, conn)
+		a.lock.Unlock()
+		conn.Close()
+		a.wg.Done()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	hello, err := readFrame(r, maxFrameSize)
+	if err != nil || hello.typ != frameHAProxyHello {
+		return
+	}
+	frameSize, healthcheck, status := negotiate(hello.payload)
+	if status != statusNormal {
+		writeDisconnect(w, status)
+		return
+	}
+	if err := writeFrame(w, frameAgentHello, 0, 0, helloPayload(frameSize)); err != nil {
+		return
+	}
+	if healthcheck {
+		return
+	}
+
+	for {
+		f, err := readFrame(r, frameSize)
+		if err == errFrameTooBig {
+			writeDisconnect(w, statusTooBig)
+			return
+		}
+		if err != nil {
+			if err == errInvalidFrame {
+				writeDisconnect(w, statusInvalidFrame)
+			}
+			return
+		}
+
+		switch f.typ {
+		case frameNotify:
+			actions, err := a.notify(f.payload)
+			if err != nil {
+				writeDisconnect(w, statusInvalidFrame)
+				return
+			}
+			if err := writeFrame(w, frameAck, f.streamID, f.frameID, actions); err != nil {
+				return
+			}
+		case frameHAProxyDisconnect:
+			writeDisconnect(w, statusNormal)
+			return
+		default:
+			writeDisconnect(w, statusInvalidFrame)
+			return
+		}
+	}
+}
+
+// notify takes from the key of the first message of the notification with a
+// "key" argument, and returns the actions setting the variables. A
+// notification without a key, or a take the store could not decide while
+// failing closed, sets no variables.
+func (a *Agent) notify(payload []byte) ([]byte, error) {
+	d := &decoder{b: payload}
+	var key string
+	var found bool
+	for len(d.b) > 0 {
+		if _, err := d.string(); err != nil {
+			return nil, err
+		}
+		n, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < int(n); i++ {
+			name, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			if name == "key" && !found {
+				key, found = value, true
+			}
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	res, err := a.store.Take(context.Background(), key)
+	if err != nil && !res.Allowed {
+		return nil, nil
+	}
+
+	b := setVar(nil, "allowed", res.Allowed)
+	if err != nil {
+		return b, nil
+	}
+	b = setVar(b, "remaining", res.Remaining)
+	b = setVar(b, "limit", res.Limit)
+	b = setVar(b, "retry_after", uint64(httplimit.RetryAfterSeconds(res.RetryAfter)))
+	return b, nil
+}
+
+// negotiate reads the hello of HAProxy, and returns the frame size to use and
+// whether the connection is a health check, or the status to disconnect with
+// if the agent cannot serve it.
+func negotiate(payload []byte) (uint32, bool, int) {
+	frameSize := uint32(maxFrameSize)
+	var healthcheck, versionOK bool
+
+	d := &decoder{b: payload}
+	for len(d.b) > 0 {
+		name, err := d.string()
+		if err != nil {
+			return 0, false, statusInvalidFrame
+		}
+		value, err := d.value()
+		if err != nil {
+			return 0, false, statusInvalidFrame
+		}
+
+		switch name {
+		case "supported-versions":
+			for _, v := range strings.Split(value, ",") {
+				if strings.TrimSpace(v) == spopVersion {
+					versionOK = true
+				}
+			}
+		case "max-frame-size":
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return 0, false, statusInvalidFrame
+			}
+			if uint32(n) < frameSize {
+				frameSize = uint32(n)
+			}
+		case "healthcheck":
+			healthcheck = value == "true"
+		}
+	}
+	if !versionOK {
+		return 0, false, statusBadVersion
+	}
+	return frameSize, healthcheck, statusNormal
+}
+
+// helloPayload returns the payload of the agent's hello.
+func helloPayload(frameSize uint32) []byte {
+	var b []byte
+	b = appendString(b, "version")
+	b = appendTypedString(b, spopVersion)
+	b = appendString(b, "max-frame-size")
+	b = append(b, typeUint32)
+	b = appendVarint(b, uint64(frameSize))
+	b = appendString(b, "capabilities")
+	b = appendTypedString(b, "pipelining")
+	return b
+}
+
+// writeDisconnect sends the agent's disconnect with the status.
+func writeDisconnect(w *bufio.Writer, status int) {
+	var b []byte
+	b = appendString(b, "status-code")
+	b = append(b, typeUint32)
+	b = appendVarint(b, uint64(status))
+	b = appendString(b, "message")
+	b = appendTypedString(b, disconnectMessage(status))
+	_ = writeFrame(w, frameAgentDisconnect, 0, 0, b)
+}
+
+// disconnectMessage describes the status of a disconnect.
+func disconnectMessage(status int) string {
+	switch status {
+	case statusNormal:
+		return "normal"
+	case statusTooBig:
+		return "frame is too big"
+	case statusBadVersion:
+		return "version value not found"
+	default:
+		return "invalid frame received"
+	}
+}
+
+// readFrame reads a frame of up to max bytes.
+func readFrame(r *bufio.Reader, max uint32) (*frame, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > max {
+		return nil, errFrameTooBig
+	}
+	if n < 5 {
+		return nil, errInvalidFrame
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	f := &frame{typ: b[0], flags: binary.BigEndian.Uint32(b[1:5])}
+	d := &decoder{b: b[5:]}
+	var err error
+	if f.streamID, err = d.varint(); err != nil {
+		return nil, err
+	}
+	if f.frameID, err = d.varint(); err != nil {
+		return nil, err
+	}
+	if f.flags&flagFin == 0 {
+		return nil, errInvalidFrame
+	}
+	f.payload = d.b
+	return f, nil
+}
+
+// writeFrame writes a frame and flushes it.
+func writeFrame(w *bufio.Writer, typ byte, streamID, frameID uint64, payload []byte) error {
+	b := make([]byte, 4, 4+5+20+len(payload))
+	b = append(b, typ, 0, 0, 0, flagFin)
+	b = appendVarint(b, streamID)
+	b = appendVarint(b, frameID)
+	b = append(b, payload...)
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// setVar appends an action setting the variable in the transaction scope.
+func setVar(b []byte, name string, value interface{}) []byte {
+	b = append(b, actionSetVar, 3, scopeTransaction)
+	b = appendString(b, name)
+	switch v := value.(type) {
+	case bool:
+		t := byte(typeBool)
+		if v {
+			t |= 0x10
+		}
+		b = append(b, t)
+	case uint64:
+		b = append(b, typeUint64)
+		b = appendVarint(b, v)
+	}
+	return b
+}
+
+// appendVarint appends the variable-length encoding of the protocol, which
+// stores values below 240 in a single byte.
+func appendVarint(b []byte, v uint64) []byte {
+	if v < 240 {
+		return append(b, byte(v))
+	}
+	b = append(b, byte(v)|240)
+	v = (v - 240) >> 4
+	for v >= 128 {
+		b = append(b, byte(v)|128)
+		v = (v - 128) >> 7
+	}
+	return append(b, byte(v))
+}
+
+// appendString appends a length-prefixed string, like the names of lists.
+func appendString(b []byte, s string) []byte {
+	b = appendVarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendTypedString appends a string as typed data.
+func appendTypedString(b []byte, s string) []byte {
+	return appendString(append(b, typeString), s)
+}
+
+// decoder decodes the fields of a payload.
+type decoder struct {
+	b []byte
+}
+
+func (d *decoder) byte() (byte, error) {
+	if len(d.b) == 0 {
+		return 0, errInvalidFrame
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c, nil
+}
+
+func (d *decoder) varint() (uint64, error) {
+	c, err := d.byte()
+	if err != nil {
+		return 0, err
+	}
+	v := uint64(c)
+	if v < 240 {
+		return v, nil
+	}
+	for shift := uint(4); ; shift += 7 {
+		if shift > 63 {
+			return 0, errInvalidFrame
+		}
+		c, err := d.byte()
+		if err != nil {
+			return 0, err
+		}
+		v += uint64(c) << shift
+		if c < 128 {
+			return v, nil
+		}
+	}
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.b)) {
+		return nil, errInvalidFrame
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b, nil
+}
+
+func (d *decoder) string() (string, error) {
+	b, err := d.bytes()
+	return string(b), err
+}
+
+// value decodes typed data as a string: numbers in decimal, booleans as "true"
+// or "false", and addresses in their usual notation.
+func (d *decoder) value() (string, error) {
+	t, err := d.byte()
+	if err != nil {
+		return "", err
+	}
+
+	switch t & 0x0f {
+	case typeNull:
+		return "", nil
+	case typeBool:
+		return strconv.FormatBool(t&0x10 != 0), nil
+	case typeInt32, typeInt64:
+		v, err := d.varint()
+		return strconv.FormatInt(int64(v), 10), err
+	case typeUint32, typeUint64:
+		v, err := d.varint()
+		return strconv.FormatUint(v, 10), err
+	case typeIPv4, typeIPv6:
+		n := net.IPv4len
+		if t&0x0f == typeIPv6 {
+			n = net.IPv6len
+		}
+		if len(d.b) < n {
+			return "", errInvalidFrame
+		}
+		ip := net.IP(d.b[:n])
+		d.b = d.b[n:]
+		return ip.String(), nil
+	case typeString, typeBinary:
+		return d.string()
+	default:
+		return "", errInvalidFrame
+	}
+}
@@ -0,0 +1,212 @@
+package proxylimit_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/memorystore"
+	"github.com/sethvargo/go-limiter/proxylimit"
+)
+
+// haproxy encodes and decodes the frames HAProxy exchanges with an agent.
+type haproxy struct {
+	tb   testing.TB
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	if v < 240 {
+		return append(b, byte(v))
+	}
+	b = append(b, byte(v)|240)
+	v = (v - 240) >> 4
+	for v >= 128 {
+		b = append(b, byte(v)|128)
+		v = (v - 128) >> 7
+	}
+	return append(b, byte(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendVarint(b, uint64(len(s))), s...)
+}
+
+func (h *haproxy) send(typ byte, streamID, frameID uint64, payload []byte) {
+	h.tb.Helper()
+
+	b := make([]byte, 4)
+	b = append(b, typ, 0, 0, 0, 1)
+	b = appendVarint(b, streamID)
+	b = appendVarint(b, frameID)
+	b = append(b, payload...)
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	if _, err := h.conn.Write(b); err != nil {
+		h.tb.Fatal(err)
+	}
+}
+
+func (h *haproxy) hello(versions string, healthcheck bool) {
+	h.tb.Helper()
+
+	var b []byte
+	b = appendString(b, "supported-versions")
+	b = appendString(append(b, 8), versions)
+	b = appendString(b, "max-frame-size")
+	b = appendVarint(append(b, 3), 16380)
+	b = appendString(b, "capabilities")
+	b = appendString(append(b, 8), "pipelining")
+	if healthcheck {
+		b = appendString(b, "healthcheck")
+		b = append(b, 1|0x10)
+	}
+	h.send(1, 0, 0, b)
+}
+
+// recv returns the type, stream and frame ids, and payload of a frame.
+func (h *haproxy) recv() (byte, uint64, uint64, []byte) {
+	h.tb.Helper()
+
+	h.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var size [4]byte
+	if _, err := io.ReadFull(h.r, size[:]); err != nil {
+		h.tb.Fatal(err)
+	}
+	b := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(h.r, b); err != nil {
+		h.tb.Fatal(err)
+	}
+	typ, rest := b[0], b[5:]
+	streamID, rest := readVarint(rest)
+	frameID, rest := readVarint(rest)
+	return typ, streamID, frameID, rest
+}
+
+func readVarint(b []byte) (uint64, []byte) {
+	v := uint64(b[0])
+	if v < 240 {
+		return v, b[1:]
+	}
+	shift := uint(4)
+	for i := 1; ; i++ {
+		v += uint64(b[i]) << shift
+		shift += 7
+		if b[i] < 128 {
+			return v, b[i+1:]
+		}
+	}
+}
+
+// vars decodes the set-var actions of an ack.
+func vars(tb testing.TB, b []byte) map[string]interface{} {
+	tb.Helper()
+
+	vars := make(map[string]interface{})
+	for len(b) > 0 {
+		if b[0] != 1 || b[1] != 3 || b[2] != 2 {
+			tb.Fatalf("unexpected action %x", b[:3])
+		}
+		n, rest := readVarint(b[3:])
+		name := string(rest[:n])
+		typ, rest := rest[n], rest[n+1:]
+		switch typ & 0x0f {
+		case 1:
+			vars[name] = typ&0x10 != 0
+		case 5:
+			vars[name], rest = readVarint(rest)
+		default:
+			tb.Fatalf("unexpected type %d", typ)
+		}
+		b = rest
+	}
+	return vars
+}
+
+func TestAgent(t *testing.T) {
+	t.Parallel()
+
+	store, err := memorystore.New(&memorystore.Config{
+		Tokens:   1,
+		Interval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	agent, err := proxylimit.NewAgent(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go agent.Serve(l)
+
+	dial := func() *haproxy {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return &haproxy{tb: t, conn: conn, r: bufio.NewReader(conn)}
+	}
+
+	h := dial()
+	h.hello("2.0", false)
+	if typ, _, _, _ := h.recv(); typ != 101 {
+		t.Fatalf("expected agent hello, got frame type %d", typ)
+	}
+
+	// A message with the client's address as the key, like "args key=src".
+	var notify []byte
+	notify = appendString(notify, "limiter-take")
+	notify = append(notify, 1)
+	notify = appendString(notify, "key")
+	notify = append(notify, 6, 192, 0, 2, 1)
+
+	cases := []map[string]interface{}{
+		{"allowed": true, "remaining": uint64(0), "limit": uint64(1), "retry_after": uint64(0)},
+		{"allowed": false, "remaining": uint64(0), "limit": uint64(1), "retry_after": uint64(3600)},
+	}
+	for i, want := range cases {
+		h.send(3, 7, uint64(1000+i), notify)
+		typ, streamID, frameID, payload := h.recv()
+		if typ != 103 {
+			t.Fatalf("expected ack, got frame type %d", typ)
+		}
+		if streamID != 7 || frameID != uint64(1000+i) {
+			t.Errorf("expected ack of 7/%d, got %d/%d", 1000+i, streamID, frameID)
+		}
+		if got := vars(t, payload); !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v to be %v", got, want)
+		}
+	}
+
+	h.send(2, 0, 0, nil)
+	if typ, _, _, _ := h.recv(); typ != 102 {
+		t.Errorf("expected agent disconnect, got frame type %d", typ)
+	}
+
+	// Health checks are answered with a hello, and versions the agent does not
+	// speak with a disconnect.
+	h = dial()
+	h.hello("2.0", true)
+	if typ, _, _, _ := h.recv(); typ != 101 {
+		t.Errorf("expected agent hello, got frame type %d", typ)
+	}
+
+	h = dial()
+	h.hello("1.0", false)
+	if typ, _, _, _ := h.recv(); typ != 102 {
+		t.Errorf("expected agent disconnect, got frame type %d", typ)
+	}
+}
@@ -0,0 +1,157 @@
+package redisstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// AuditConfig is used as input to AuditDenials.
+type AuditConfig struct {
+	// Stream is the name of the Redis stream. It is used as given, without the
+	// store's KeyPrefix. The default value is "limiter:denials".
+	Stream string
+
+	// MaxLen is about the most entries the stream keeps. Older entries are
+	// trimmed as new ones are added. The default value is 10000.
+	MaxLen uint64
+
+	// Buffer is the number of denials waiting to be added to the stream. When
+	// it is full, for example during a flood of denials while Redis is slow,
+	// further denials are not recorded. The default value is 1000.
+	Buffer int
+}
+
+// denial is a denial waiting to be added to the audit stream.
+type denial struct {
+	key   string
+	route string
+	at    time.Time
+}
+
+// AuditDenials returns middleware that appends every denied take of the store
+// it wraps to a capped Redis stream, so abuse patterns can be analyzed after
+// the fact, using the connections of ls. Each entry has the fields "key" with
+// the hex-encoded SHA-256 of the key, so keys like IP addresses are not stored
+// in plaintext, "time" in unix nanoseconds, and "route" if the take's context
+// has one; see limiter.WithRoute.
+//
+// Entries are added in the background, so denials are not slowed down, and
+// are dropped if the buffer is full. Close waits for the buffered entries to
+// be added before closing the wrapped store. The optional capabilities of the
+// wrapped store are preserved. ls must have been created by this package, and
+// is usually the store that is wrapped.
+func AuditDenials(ls limiter.Store, c *AuditConfig) (limiter.StoreMiddleware, error) {
+	s, ok := ls.(*store)
+	if !ok {
+		return nil, fmt.Errorf("store was not created by redisstore")
+	}
+	if c == nil {
+		c = new(AuditConfig)
+	}
+
+	stream := "limiter:denials"
+	if c.Stream != "" {
+		stream = c.Stream
+	}
+
+	maxLen := uint64(10000)
+	if c.MaxLen > 0 {
+		maxLen = c.MaxLen
+	}
+
+	buffer := 1000
+	if c.Buffer > 0 {
+		buffer = c.Buffer
+	}
+
+	return func(next limiter.Store) limiter.Store {
+		a := &auditStore{
+			Decorated: limiter.Decorated{Store: next},
+			conns:     s.conns,
+			stream:    stream,
+			maxLen:    strconv.FormatUint(maxLen, 10),
+			denials:   make(chan denial, buffer),
+			doneCh:    make(chan struct{}),
+		}
+		go a.run()
+		return limiter.Preserve(next, a)
+	}, nil
+}
+
+type auditStore struct {
+	limiter.Decorated
+
+	conns  doer
+	stream string
+	maxLen string
+
+	// denials is closed by Close, after which run adds the remaining entries
+	// and closes doneCh.
+	lock    sync.RWMutex
+	closed  bool
+	denials chan denial
+	doneCh  chan struct{}
+}
+
+// Take takes from the wrapped store and records the denial, if it is one.
+func (a *auditStore) Take(ctx context.Context, key string) (limiter.Result, error) {
+	res, err := a.Store.Take(ctx, key)
+	if err != nil || res.Allowed {
+		return res, err
+	}
+
+	route, _ := limiter.RouteFromContext(ctx)
+	d := denial{key: key, route: route, at: time.Now()}
+
+	a.lock.RLock()
+	if !a.closed {
+		select {
+		case a.denials <- d:
+		default:
+		}
+	}
+	a.lock.RUnlock()
+
+	return res, nil
+}
+
+// Close adds the buffered denials to the stream and closes the wrapped store.
+func (a *auditStore) Close() error {
+	a.lock.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.denials)
+	}
+	a.lock.Unlock()
+
+	<-a.doneCh
+	return a.Store.Close()
+}
+
+// run adds denials to the stream until the channel is closed. Entries that
+// fail to be added are dropped, since the audit log is best effort.
+func (a *auditStore) run() {
+	defer close(a.doneCh)
+
+	for d := range a.denials {
+		sum := sha256.Sum256([]byte(d.key))
+		args := []string{"XADD", a.stream, "MAXLEN", "~", a.maxLen, "*",
+			"key", hex.EncodeToString(sum[:]),
+			"time", strconv.FormatInt(d.at.UnixNano(), 10),
+		}
+		if d.route != "" {
+			args = append(args, "route", d.route)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, _ = a.conns.do(ctx, args...)
+		cancel()
+	}
+}
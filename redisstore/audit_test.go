@@ -0,0 +1,78 @@
+package redisstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+func TestAuditDenials(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:   1,
+		Interval: time.Minute,
+		DialFunc: f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mw, err := AuditDenials(s, &AuditConfig{MaxLen: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	audited := mw(s)
+
+	ctx := limiter.WithRoute(context.Background(), "GET /users")
+	for i := 0; i < 4; i++ {
+		if _, err := audited.Take(ctx, "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := audited.Take(context.Background(), "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close waits for the denials to be added.
+	if err := audited.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f.lock.Lock()
+	entries := f.streams["limiter:denials"]
+	f.lock.Unlock()
+
+	// The first take was allowed, and the stream is trimmed to the last two
+	// denials.
+	if got, want := len(entries), 2; got != want {
+		t.Fatalf("expected %d to be %d", got, want)
+	}
+
+	sum := sha256.Sum256([]byte("192.0.2.1"))
+	fields := func(entry []string) map[string]string {
+		m := make(map[string]string)
+		for i := 0; i+1 < len(entry); i += 2 {
+			m[entry[i]] = entry[i+1]
+		}
+		return m
+	}
+	first, last := fields(entries[0]), fields(entries[1])
+	if got, want := first["key"], hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("key: expected %q to be %q", got, want)
+	}
+	if got, want := first["route"], "GET /users"; got != want {
+		t.Errorf("route: expected %q to be %q", got, want)
+	}
+	if _, ok := last["route"]; ok {
+		t.Errorf("expected no route without one in the context")
+	}
+	if first["time"] == "" {
+		t.Errorf("expected a time")
+	}
+}
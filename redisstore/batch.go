@@ -0,0 +1,169 @@
+package redisstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// minBatchSweep is the number of entries the batcher holds before it first
+// sweeps expired ones.
+const minBatchSweep = 1024
+
+// batcher takes tokens from Redis in batches and hands them out locally. When
+// a key's local tokens fall to the threshold, the next batch is fetched in the
+// background.
+type batcher struct {
+	fn        takeFunc
+	size      uint64
+	threshold uint64
+	timeout   time.Duration
+
+	// ctx is canceled when the batcher is closed, which stops any prefetches.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	lock      sync.Mutex
+	entries   map[groupKey]*allocation
+	nextSweep int
+}
+
+// allocation is the tokens held locally for a key. They may only be handed
+// out until resetAt, the end of the interval they were taken in.
+type allocation struct {
+	tokens    uint64
+	remaining uint64
+	resetAt   time.Time
+	fetching  bool
+}
+
+func newBatcher(fn takeFunc, size, threshold uint64, timeout time.Duration) *batcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &batcher{
+		fn:        fn,
+		size:      size,
+		threshold: threshold,
+		timeout:   timeout,
+		ctx:       ctx,
+		cancel:    cancel,
+		entries:   make(map[groupKey]*allocation),
+		nextSweep: minBatchSweep,
+	}
+}
+
+// take takes a token from the key's local tokens, fetching a batch from Redis
+// if there are none.
+func (b *batcher) take(ctx context.Context, key string) (uint64, time.Duration, bool, error) {
+	gk := groupKey{key: key, low: limiter.PriorityFromContext(ctx) == limiter.PriorityLow}
+	now := time.Now()
+
+	b.lock.Lock()
+	a := b.entries[gk]
+	if a != nil && !now.Before(a.resetAt) {
+		// The tokens belong to an interval that has ended.
+		a.tokens = 0
+	}
+	if a != nil && a.tokens > 0 {
+		a.tokens--
+		remaining, resetAfter := a.tokens+a.remaining, a.resetAt.Sub(now)
+		if a.tokens <= b.threshold && !a.fetching {
+			a.fetching = true
+			b.prefetch(gk)
+		}
+		b.lock.Unlock()
+		return remaining, resetAfter, true, nil
+	}
+	b.lock.Unlock()
+
+	remaining, resetAfter, granted, err := b.fn(ctx, key, b.size)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if granted == 0 {
+		return remaining, resetAfter, false, nil
+	}
+
+	b.lock.Lock()
+	a = b.add(gk, time.Now(), remaining, resetAfter, granted-1)
+	remaining = a.tokens + a.remaining
+	b.lock.Unlock()
+	return remaining, resetAfter, true, nil
+}
+
+// prefetch fetches the next batch for the key in the background. It must be
+// called with the lock held.
+func (b *batcher) prefetch(gk groupKey) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		ctx, cancel := context.WithTimeout(b.ctx, b.timeout)
+		defer cancel()
+		if gk.low {
+			ctx = limiter.WithPriority(ctx, limiter.PriorityLow)
+		}
+
+		// Failures are not retried here. The next take that finds no local
+		// tokens fetches them itself and sees any error.
+		remaining, resetAfter, granted, err := b.fn(ctx, gk.key, b.size)
+
+		b.lock.Lock()
+		defer b.lock.Unlock()
+
+		if a := b.entries[gk]; a != nil {
+			a.fetching = false
+		}
+		if err == nil {
+			b.add(gk, time.Now(), remaining, resetAfter, granted)
+		}
+	}()
+}
+
+// add adds fetched tokens to the key's allocation and returns it. Tokens left
+// from an interval that has ended are dropped. It must be called with the lock
+// held.
+func (b *batcher) add(gk groupKey, now time.Time, remaining uint64, resetAfter time.Duration, tokens uint64) *allocation {
+	a := b.entries[gk]
+	if a == nil {
+		b.sweep(now)
+		a = new(allocation)
+		b.entries[gk] = a
+	}
+	if !now.Before(a.resetAt) {
+		a.tokens = 0
+	}
+
+	a.tokens += tokens
+	a.remaining = remaining
+	a.resetAt = now.Add(resetAfter)
+	return a
+}
+
+// sweep removes expired allocations once the number of entries reaches the
+// next sweep size, which is then doubled from the number left. It must be
+// called with the lock held.
+func (b *batcher) sweep(now time.Time) {
+	if len(b.entries) < b.nextSweep {
+		return
+	}
+
+	for gk, a := range b.entries {
+		if !a.fetching && !now.Before(a.resetAt) {
+			delete(b.entries, gk)
+		}
+	}
+
+	b.nextSweep = 2 * len(b.entries)
+	if b.nextSweep < minBatchSweep {
+		b.nextSweep = minBatchSweep
+	}
+}
+
+// close stops any prefetches and waits for them to return.
+func (b *batcher) close() {
+	b.cancel()
+	b.wg.Wait()
+}
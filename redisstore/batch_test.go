@@ -0,0 +1,121 @@
+package redisstore
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStore_LocalBatch(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+
+	var evals int64
+	f.inject(func(args []string) *fakeFault {
+		if args[0] == "EVALSHA" || args[0] == "EVAL" {
+			atomic.AddInt64(&evals, 1)
+		}
+		return nil
+	})
+
+	s, err := New(&Config{
+		Tokens:     100,
+		Interval:   time.Minute,
+		LocalBatch: 10,
+		DialFunc:   f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	key := testKey(t)
+	b := s.(*store).batcher
+
+	var allowed int
+	take := func(n int) {
+		for i := 0; i < n; i++ {
+			res, err := s.Take(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Allowed {
+				allowed++
+			}
+		}
+	}
+
+	// waitForPrefetch waits for any prefetch to finish.
+	waitForPrefetch := func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			b.lock.Lock()
+			fetching := b.entries[groupKey{key: key}].fetching
+			b.lock.Unlock()
+			if !fetching {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("expected the prefetch to finish")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// The first take fetches a batch, and the take that leaves the threshold
+	// of 2 prefetches the next one, so the takes never wait again.
+	take(8)
+	waitForPrefetch()
+	if got, want := atomic.LoadInt64(&evals), int64(2); got != want {
+		t.Errorf("evals: expected %d to be %d", got, want)
+	}
+
+	// Later takes are all served locally, so Redis is only called once per
+	// batch.
+	for i := 0; i < 11; i++ {
+		take(8)
+		waitForPrefetch()
+	}
+	if got, want := atomic.LoadInt64(&evals), int64(10); got != want {
+		t.Errorf("evals: expected %d to be %d", got, want)
+	}
+
+	// Every token in Redis was handed out exactly once.
+	take(10)
+	if got, want := allowed, 100; got != want {
+		t.Errorf("allowed: expected %d to be %d", got, want)
+	}
+}
+
+func TestBatcher_expire(t *testing.T) {
+	t.Parallel()
+
+	var calls int
+	b := newBatcher(func(_ context.Context, _ string, n uint64) (uint64, time.Duration, uint64, error) {
+		calls++
+		return 0, 20 * time.Millisecond, n, nil
+	}, 5, 0, time.Second)
+	defer b.close()
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, _, ok, err := b.take(ctx, "a"); !ok || err != nil {
+			t.Fatalf("expected take to succeed, got %t, %v", ok, err)
+		}
+	}
+	if got, want := calls, 1; got != want {
+		t.Errorf("calls: expected %d to be %d", got, want)
+	}
+
+	// Local tokens are dropped at the end of their interval.
+	time.Sleep(30 * time.Millisecond)
+	if _, _, ok, err := b.take(ctx, "a"); !ok || err != nil {
+		t.Fatalf("expected take to succeed, got %t, %v", ok, err)
+	}
+	if got, want := calls, 2; got != want {
+		t.Errorf("calls: expected %d to be %d", got, want)
+	}
+}
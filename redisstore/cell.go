@@ -0,0 +1,62 @@
+package redisstore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// cellCompatible reports whether CL.THROTTLE can enforce the configuration.
+// It has no global bucket, priorities, debt, or partial grants, its TTL always
+// slides, and its period is a whole number of seconds.
+func cellCompatible(c *Config, interval time.Duration) bool {
+	return c.GlobalTokens == 0 &&
+		c.ReservedFraction == 0 &&
+		c.DebtLimit == 0 &&
+		!c.StaggerResets &&
+		c.TTLMode == limiter.TTLSliding &&
+		c.LocalBatch == 0 &&
+		!c.Coalesce &&
+		interval%time.Second == 0
+}
+
+// detectCell reports whether the server has the CL.THROTTLE command of the
+// redis-cell module. Errors, for example from an ACL that denies COMMAND, are
+// treated as the module being absent.
+func detectCell(ctx context.Context, conns doer) bool {
+	resp, err := conns.do(ctx, "COMMAND", "INFO", "CL.THROTTLE")
+	if err != nil {
+		return false
+	}
+	a := resp.array()
+	return len(a) == 1 && a[0].typ == typeArray
+}
+
+// takeCell takes n tokens from the key with CL.THROTTLE. Unlike the script, it
+// takes all n tokens or none.
+func (s *store) takeCell(ctx context.Context, key string, n uint64) (remaining uint64, resetAfter time.Duration, granted uint64, err error) {
+	resp, err := s.conns.do(ctx, "CL.THROTTLE", s.keyPrefix+key,
+		strconv.FormatUint(s.tokens-1, 10),
+		strconv.FormatUint(s.tokens, 10),
+		strconv.FormatInt(int64(s.interval/time.Second), 10),
+		strconv.FormatUint(n, 10))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to run CL.THROTTLE: %w", err)
+	}
+
+	// The reply is whether the take was limited, the limit, the remaining
+	// tokens, the seconds until a retry would succeed (or -1 if allowed), and
+	// the seconds until the bucket is full again.
+	a := resp.array()
+	if len(a) != 5 {
+		return 0, 0, 0, fmt.Errorf("invalid CL.THROTTLE reply: expected 5 values, got %d", len(a))
+	}
+
+	if a[0].int64() != 0 {
+		return a[2].uint64(), time.Duration(a[3].int64()) * time.Second, 0, nil
+	}
+	return a[2].uint64(), time.Duration(a[4].int64()) * time.Second, n, nil
+}
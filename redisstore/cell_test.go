@@ -0,0 +1,149 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+func TestStore_RedisCell(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		module bool
+		config *Config
+		want   bool
+	}{
+		{
+			name:   "installed",
+			module: true,
+			config: &Config{},
+			want:   true,
+		},
+		{
+			name:   "missing",
+			module: false,
+			config: &Config{},
+			want:   false,
+		},
+		{
+			name:   "incompatible",
+			module: true,
+			config: &Config{DebtLimit: 1},
+			want:   false,
+		},
+		{
+			name:   "fractional_interval",
+			module: true,
+			config: &Config{Interval: 1500 * time.Millisecond},
+			want:   false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := newFakeRedis(t)
+			if tc.module {
+				f.enableCell()
+			}
+
+			c := tc.config
+			c.Tokens = 3
+			if c.Interval == 0 {
+				c.Interval = time.Minute
+			}
+			c.RedisCell = true
+			c.DialFunc = f.dial
+
+			s, err := New(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			if got, want := s.(*store).DebugVars()["redis_cell"], tc.want; got != want {
+				t.Fatalf("redis_cell: expected %v to be %v", got, want)
+			}
+
+			ctx := context.Background()
+			key := testKey(t)
+			for i := 0; i < 3; i++ {
+				res, err := s.Take(ctx, key)
+				if !res.Allowed || err != nil {
+					t.Fatalf("take %d: expected to succeed, got %t, %v", i, res.Allowed, err)
+				}
+				if got, want := res.Remaining, uint64(2-i); got != want {
+					t.Errorf("take %d: remaining: expected %d to be %d", i, got, want)
+				}
+			}
+
+			// The debt limit allows one more take when redis-cell is not used.
+			allowed := tc.config.DebtLimit > 0
+			res, err := s.Take(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := res.Allowed, allowed; got != want {
+				t.Errorf("expected %t to be %t", got, want)
+			}
+			if !res.Allowed && res.RetryAfter <= 0 {
+				t.Errorf("expected a retry after, got %s", res.RetryAfter)
+			}
+
+			err = s.(*store).Refund(ctx, key, 1)
+			if got, want := errors.Is(err, limiter.ErrNotSupported), tc.want; got != want {
+				t.Errorf("refund: expected %v to be not supported: %t", err, want)
+			}
+		})
+	}
+}
+
+func TestStore_RedisCell_redis(t *testing.T) {
+	t.Parallel()
+
+	c := testRedisConfig(t)
+	c.Tokens = 3
+	c.Interval = time.Minute
+	c.RedisCell = true
+
+	s, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if cell, _ := s.(*store).DebugVars()["redis_cell"].(bool); !cell {
+		t.Skipf("skipping (server does not have redis-cell)")
+	}
+
+	ctx := context.Background()
+	key := testKey(t)
+	for i := 0; i < 3; i++ {
+		res, err := s.Take(ctx, key)
+		if !res.Allowed || err != nil {
+			t.Fatalf("take %d: expected to succeed, got %t, %v", i, res.Allowed, err)
+		}
+		if got, want := res.Limit, uint64(3); got != want {
+			t.Errorf("take %d: limit: expected %d to be %d", i, got, want)
+		}
+	}
+
+	res, err := s.Take(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed {
+		t.Error("expected take to be denied")
+	}
+	if res.RetryAfter <= 0 || res.RetryAfter > time.Minute {
+		t.Errorf("expected a retry after within the interval, got %s", res.RetryAfter)
+	}
+}
@@ -5,10 +5,12 @@ package redisstore
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"strconv"
+	"time"
 )
 
 const (
@@ -33,6 +35,14 @@ const (
 	typeString              // String
 )
 
+// replyError is an error reply sent by the server. Unlike other errors, it
+// leaves the connection in a usable state.
+type replyError string
+
+func (e replyError) Error() string {
+	return string(e)
+}
+
 type response struct {
 	typ responseType
 	a   []*response
@@ -44,34 +54,58 @@ func (r *response) array() []*response {
 	return r.a
 }
 
+func (r *response) int64() int64 {
+	return r.i
+}
+
 func (r *response) uint64() uint64 {
 	return uint64(r.i)
 }
 
+// Invoker sends a command to Redis and returns the raw RESP-encoded reply.
+// Error replies from the server are returned as part of the reply, not as an
+// error.
+type Invoker func(args []string) ([]byte, error)
+
+// Interceptor is called for every command sent to Redis, including the
+// commands used to authenticate and prime new connections. It receives the
+// command arguments and an Invoker that sends them on the underlying
+// connection. Implementations can delay, fail, or rewrite commands and
+// replies, which makes it possible to inject latency, dropped connections, and
+// malformed replies in tests and chaos experiments. Returning an error fails
+// the command. Returned replies must be RESP-encoded.
+type Interceptor func(args []string, invoke Invoker) ([]byte, error)
+
 // client is an individual connection to a redis instance.
 type client struct {
-	conn net.Conn
+	conn        net.Conn
+	br          *bufio.Reader
+	interceptor Interceptor
 }
 
-func newClient(conn net.Conn, username, password string) (*client, error) {
-	c := &client{conn: conn}
+func newClient(ctx context.Context, conn net.Conn, username, password string, interceptor Interceptor) (*client, error) {
+	c := &client{
+		conn:        conn,
+		br:          bufio.NewReader(conn),
+		interceptor: interceptor,
+	}
 
 	// auth
 	if password != "" {
-		if err := c.auth(username, password); err != nil {
+		if err := c.auth(ctx, username, password); err != nil {
 			return nil, err
 		}
 	}
 
 	// ping
-	if err := c.ping(); err != nil {
+	if err := c.ping(ctx); err != nil {
 		return nil, err
 	}
 
 	return c, nil
 }
 
-func (c *client) auth(username, password string) error {
+func (c *client) auth(ctx context.Context, username, password string) error {
 	if username == "" && password == "" {
 		return fmt.Errorf("cannot auth with empty credentials")
 	}
@@ -84,45 +118,123 @@ func (c *client) auth(username, password string) error {
 	}
 	args = append(args, password)
 
-	if _, err := c.do(args...); err != nil {
+	if _, err := c.doContext(ctx, args...); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (c *client) ping() error {
-	if _, err := c.do("PING"); err != nil {
+func (c *client) ping(ctx context.Context) error {
+	if _, err := c.doContext(ctx, "PING"); err != nil {
 		return err
 	}
 	return nil
 }
 
+// doContext is like do, but applies the context deadline, if any, to the
+// underlying connection for the duration of the command.
+func (c *client) doContext(ctx context.Context, args ...string) (*response, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	return c.do(args...)
+}
+
 func (c *client) do(args ...string) (*response, error) {
-	r := c.buildRequest(args...)
+	if c.interceptor != nil {
+		raw, err := c.interceptor(args, c.invoke)
+		if err != nil {
+			return nil, err
+		}
+		return parseResponse(bufio.NewReader(bytes.NewReader(raw)))
+	}
+
+	r := buildRequest(args...)
 	if _, err := c.conn.Write(r); err != nil {
 		return nil, err
 	}
 
-	resp, err := c.parseResponse(c.conn)
+	resp, err := parseResponse(c.br)
 	if err != nil {
 		return nil, err
 	}
 	return resp, nil
 }
 
-func (c *client) release(p *pool) error {
+// invoke is the Invoker given to interceptors. It sends the command and reads
+// the reply without parsing it.
+func (c *client) invoke(args []string) ([]byte, error) {
+	r := buildRequest(args...)
+	if _, err := c.conn.Write(r); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if err := readRawResponse(c.br, &b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// release returns the client to the pool. If err means the connection is no
+// longer usable (for example, a partially-read reply or a READONLY reply from
+// a demoted master), it is discarded instead.
+func (c *client) release(p *pool, err error) error {
+	if !isConnUsable(err) {
+		return p.discard(c)
+	}
 	return p.put(c)
 }
 
-func (c *client) parseResponse(r io.Reader) (*response, error) {
-	br := bufio.NewReader(r)
+// readRawResponse reads a single, complete RESP value from br and writes it
+// to w as-is.
+func readRawResponse(br *bufio.Reader, w *bytes.Buffer) error {
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if len(line) < 3 {
+		return fmt.Errorf("response is invalid: %v", line)
+	}
+	w.Write(line)
+
+	switch line[0] {
+	case dollar, star:
+		count, err := strconv.ParseInt(string(line[1:len(line)-2]), 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse count: %w", err)
+		}
+
+		if line[0] == dollar {
+			if count < 0 {
+				return nil
+			}
+			if _, err := io.CopyN(w, br, count+2); err != nil {
+				return fmt.Errorf("failed to read bulk response: %w", err)
+			}
+			return nil
+		}
+
+		for i := int64(0); i < count; i++ {
+			if err := readRawResponse(br, w); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func parseResponse(br *bufio.Reader) (*response, error) {
 	line, err := br.ReadBytes('\n')
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	// sanity check
-	if len(line) < 1 {
+	if len(line) < 3 {
 		return nil, fmt.Errorf("response is invalid: %v", line)
 	}
 
@@ -157,7 +269,7 @@ func (c *client) parseResponse(r io.Reader) (*response, error) {
 		}
 		return &response{typ: typeBulk, s: string(buf[:count])}, nil
 	case minus:
-		return nil, fmt.Errorf(string(content))
+		return nil, replyError(content)
 	case plus:
 		return &response{typ: typeString, s: string(content)}, nil
 	case star:
@@ -171,7 +283,7 @@ func (c *client) parseResponse(r io.Reader) (*response, error) {
 
 		responses := make([]*response, count)
 		for i := int64(0); i < count; i++ {
-			resp, err := c.parseResponse(br)
+			resp, err := parseResponse(br)
 			if err != nil {
 				return nil, err
 			}
@@ -184,7 +296,7 @@ func (c *client) parseResponse(r io.Reader) (*response, error) {
 	return nil, fmt.Errorf("unknown response type: %v", string(line))
 }
 
-func (c *client) buildRequest(args ...string) []byte {
+func buildRequest(args ...string) []byte {
 	l := len(args)
 
 	b := bytes.NewBuffer(make([]byte, 0, len(args)*8))
@@ -0,0 +1,92 @@
+package redisstore
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadRawResponse(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		raw  string
+		err  bool
+	}{
+		{
+			name: "int",
+			raw:  ":42\r\n",
+		},
+		{
+			name: "string",
+			raw:  "+OK\r\n",
+		},
+		{
+			name: "error",
+			raw:  "-ERR nope\r\n",
+		},
+		{
+			name: "bulk",
+			raw:  "$5\r\nhello\r\n",
+		},
+		{
+			name: "null",
+			raw:  "$-1\r\n",
+		},
+		{
+			name: "array",
+			raw:  "*3\r\n:1\r\n$3\r\nfoo\r\n$-1\r\n",
+		},
+		{
+			name: "nested_array",
+			raw:  "*2\r\n*1\r\n:1\r\n+OK\r\n",
+		},
+		{
+			name: "truncated_bulk",
+			raw:  "$5\r\nhel",
+			err:  true,
+		},
+		{
+			name: "truncated_array",
+			raw:  "*2\r\n:1\r\n",
+			err:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Append a trailing value to make sure only one value is consumed.
+			br := bufio.NewReader(strings.NewReader(tc.raw + ":7\r\n"))
+			if tc.err {
+				br = bufio.NewReader(strings.NewReader(tc.raw))
+			}
+
+			var b bytes.Buffer
+			err := readRawResponse(br, &b)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error to be %t, got %v", tc.err, err)
+			}
+			if tc.err {
+				return
+			}
+
+			if got, want := b.String(), tc.raw; got != want {
+				t.Errorf("expected %q to be %q", got, want)
+			}
+
+			resp, err := parseResponse(br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := resp.i, int64(7); got != want {
+				t.Errorf("expected trailing value %d to be %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package redisstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// takeFunc takes n tokens from the key and returns the remaining tokens, the
+// time until the next refill, and the number of tokens granted.
+type takeFunc func(ctx context.Context, key string, n uint64) (uint64, time.Duration, uint64, error)
+
+// coalescer batches concurrent takes on the same key. At most one call per key
+// is in flight. Takes that arrive while it is join a pending batch, which is
+// sent for all of them once the call returns.
+type coalescer struct {
+	fn takeFunc
+
+	lock   sync.Mutex
+	groups map[groupKey]*group
+}
+
+// groupKey identifies takes that can be batched together.
+type groupKey struct {
+	key string
+	low bool
+}
+
+// group is the in-flight and pending batches for a key.
+type group struct {
+	inflight *batch
+	pending  *batch
+}
+
+// batch is a single call for n tokens. The result fields must only be read
+// after done is closed.
+type batch struct {
+	n    uint64
+	done chan struct{}
+
+	remaining  uint64
+	resetAfter time.Duration
+	granted    uint64
+	err        error
+}
+
+func newCoalescer(take takeFunc) *coalescer {
+	return &coalescer{
+		fn:     take,
+		groups: make(map[groupKey]*group),
+	}
+}
+
+// take takes a token from the key, batched with any concurrent takes. The
+// first take in a batch makes the call with its own context, so if that
+// context is canceled, the whole batch fails. Other takes stop waiting when
+// their context is done, but their tokens may still be taken.
+func (c *coalescer) take(ctx context.Context, key string) (uint64, time.Duration, bool, error) {
+	gk := groupKey{key: key, low: limiter.PriorityFromContext(ctx) == limiter.PriorityLow}
+
+	c.lock.Lock()
+	g, ok := c.groups[gk]
+	if !ok {
+		g = new(group)
+		c.groups[gk] = g
+	}
+
+	// Join the pending batch, which another take will send.
+	if b := g.pending; b != nil {
+		i := b.n
+		b.n++
+		c.lock.Unlock()
+
+		select {
+		case <-b.done:
+			return b.result(i)
+		case <-ctx.Done():
+			return 0, 0, false, ctx.Err()
+		}
+	}
+
+	b := &batch{n: 1, done: make(chan struct{})}
+	prev := g.inflight
+	if prev == nil {
+		g.inflight = b
+	} else {
+		g.pending = b
+	}
+	c.lock.Unlock()
+
+	// Wait for the in-flight call before sending this batch. Once it becomes
+	// the in-flight batch, no more takes can join it.
+	if prev != nil {
+		<-prev.done
+
+		c.lock.Lock()
+		g.pending = nil
+		g.inflight = b
+		c.lock.Unlock()
+	}
+
+	b.remaining, b.resetAfter, b.granted, b.err = c.fn(ctx, key, b.n)
+
+	c.lock.Lock()
+	g.inflight = nil
+	if g.pending == nil {
+		delete(c.groups, gk)
+	}
+	c.lock.Unlock()
+	close(b.done)
+
+	return b.result(0)
+}
+
+// result returns the result for the i-th take in the batch. Tokens are granted
+// in the order the takes joined.
+func (b *batch) result(i uint64) (uint64, time.Duration, bool, error) {
+	if b.err != nil {
+		return 0, 0, false, b.err
+	}
+	return b.remaining, b.resetAfter, i < b.granted, nil
+}
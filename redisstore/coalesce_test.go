@@ -0,0 +1,104 @@
+package redisstore
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStore_Coalesce(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+
+	var evals int64
+	f.inject(func(args []string) *fakeFault {
+		if args[0] == "EVALSHA" || args[0] == "EVAL" {
+			atomic.AddInt64(&evals, 1)
+			return &fakeFault{delay: 20 * time.Millisecond}
+		}
+		return nil
+	})
+
+	s, err := New(&Config{
+		Tokens:   10,
+		Interval: time.Minute,
+		Coalesce: true,
+		DialFunc: f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	key := testKey(t)
+
+	const takes = 50
+	var allowed int64
+	var wg sync.WaitGroup
+	for i := 0; i < takes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			res, err := s.Take(ctx, key)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if res.Allowed {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, want := atomic.LoadInt64(&allowed), int64(10); got != want {
+		t.Errorf("allowed: expected %d to be %d", got, want)
+	}
+	if got := atomic.LoadInt64(&evals); got >= takes {
+		t.Errorf("expected fewer than %d script calls, got %d", takes, got)
+	}
+}
+
+func TestStore_takeN(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:   5,
+		Interval: time.Minute,
+		DialFunc: f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	key := testKey(t)
+
+	cases := []struct {
+		n, remaining, granted uint64
+	}{
+		{n: 3, remaining: 2, granted: 3},
+		{n: 3, remaining: 0, granted: 2},
+		{n: 1, remaining: 0, granted: 0},
+	}
+
+	for i, tc := range cases {
+		remaining, _, granted, err := s.(*store).take(ctx, key, tc.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := remaining, tc.remaining; got != want {
+			t.Errorf("take %d: remaining: expected %d to be %d", i, got, want)
+		}
+		if got, want := granted, tc.granted; got != want {
+			t.Errorf("take %d: granted: expected %d to be %d", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package redisstore
+
+import (
+	"sync/atomic"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+var _ limiter.Debugger = (*store)(nil)
+
+// DebugVars returns the number of takes, denials, failures, denials served
+// from the deny cache, and takes decided by the fallback, whether redis-cell is
+// in use, and statistics about the connection pool or multiplexed connection.
+func (s *store) DebugVars() map[string]interface{} {
+	vars := s.conns.debugVars()
+	vars["takes"] = atomic.LoadUint64(&s.takes)
+	vars["denials"] = atomic.LoadUint64(&s.denials)
+	vars["failures"] = atomic.LoadUint64(&s.failures)
+	vars["deny_cache_hits"] = atomic.LoadUint64(&s.denyCacheHits)
+	vars["fallbacks"] = atomic.LoadUint64(&s.fallbacks)
+	vars["redis_cell"] = s.cell
+	return vars
+}
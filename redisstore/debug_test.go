@@ -0,0 +1,68 @@
+package redisstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStore_DebugVars(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		multiplex bool
+	}{
+		{
+			name:      "pool",
+			multiplex: false,
+		},
+		{
+			name:      "multiplex",
+			multiplex: true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := newFakeRedis(t)
+			s, err := New(&Config{
+				Tokens:          1,
+				Interval:        time.Minute,
+				InitialPoolSize: 2,
+				DialFunc:        f.dial,
+				Multiplex:       tc.multiplex,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			ctx := context.Background()
+			key := testKey(t)
+			for i := 0; i < 3; i++ {
+				if _, err := s.Take(ctx, key); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			vars := s.(*store).DebugVars()
+			if got, want := vars["takes"], uint64(3); got != want {
+				t.Errorf("takes: expected %v to be %v", got, want)
+			}
+			if got, want := vars["denials"], uint64(2); got != want {
+				t.Errorf("denials: expected %v to be %v", got, want)
+			}
+			if got, want := vars["failures"], uint64(0); got != want {
+				t.Errorf("failures: expected %v to be %v", got, want)
+			}
+			if got, want := vars["dials"], uint64(f.dialCount()); got != want {
+				t.Errorf("dials: expected %v to be %v", got, want)
+			}
+		})
+	}
+}
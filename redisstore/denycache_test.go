@@ -0,0 +1,65 @@
+package redisstore
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStore_DenyCache(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+
+	var evals int64
+	f.inject(func(args []string) *fakeFault {
+		if args[0] == "EVALSHA" || args[0] == "EVAL" {
+			atomic.AddInt64(&evals, 1)
+		}
+		return nil
+	})
+
+	s, err := New(&Config{
+		Tokens:    1,
+		Interval:  time.Minute,
+		DenyCache: true,
+		DialFunc:  f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	key := testKey(t)
+
+	if res, err := s.Take(ctx, key); !res.Allowed || err != nil {
+		t.Fatalf("expected take to succeed, got %t, %v", res.Allowed, err)
+	}
+	for i := 0; i < 5; i++ {
+		res, err := s.Take(ctx, key)
+		if res.Allowed || err != nil {
+			t.Fatalf("take %d: expected to be rejected, got %t, %v", i, res.Allowed, err)
+		}
+		if res.RetryAfter <= 0 {
+			t.Errorf("take %d: expected a retry after, got %s", i, res.RetryAfter)
+		}
+	}
+
+	// Only the first denial reached Redis.
+	if got, want := atomic.LoadInt64(&evals), int64(2); got != want {
+		t.Errorf("evals: expected %d to be %d", got, want)
+	}
+	if got, want := s.(*store).DebugVars()["deny_cache_hits"], uint64(4); got != want {
+		t.Errorf("deny_cache_hits: expected %v to be %v", got, want)
+	}
+
+	// A refund clears the cached denial.
+	if err := s.(*store).Refund(ctx, key, 1); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := s.Take(ctx, key); !res.Allowed || err != nil {
+		t.Fatalf("expected take after refund to succeed, got %t, %v", res.Allowed, err)
+	}
+}
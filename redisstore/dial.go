@@ -0,0 +1,187 @@
+package redisstore
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// DialFunc is a function that creates a connection to the Redis server. The
+// context is canceled when the dial should be abandoned, either because the
+// caller's deadline passed or because the store was closed.
+type DialFunc func(ctx context.Context) (net.Conn, error)
+
+// AdaptDialFunc adapts a dial function that does not accept a context into a
+// DialFunc. The returned function stops waiting when the context is done, but
+// since the underlying function cannot be interrupted, it continues in the
+// background and any connection it eventually returns is closed.
+func AdaptDialFunc(f func() (net.Conn, error)) DialFunc {
+	return func(ctx context.Context) (net.Conn, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		type result struct {
+			conn net.Conn
+			err  error
+		}
+
+		resultCh := make(chan *result, 1)
+		go func() {
+			conn, err := f()
+			resultCh <- &result{conn, err}
+		}()
+
+		select {
+		case r := <-resultCh:
+			return r.conn, r.err
+		case <-ctx.Done():
+			go func() {
+				if r := <-resultCh; r.conn != nil {
+					r.conn.Close()
+				}
+			}()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// TCPDialFunc returns a DialFunc that connects to the given TCP address using
+// a net.Dialer, which respects the context deadline and cancellation.
+func TCPDialFunc(addr string) DialFunc {
+	var d net.Dialer
+	return func(ctx context.Context) (net.Conn, error) {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+}
+
+// dialConfig is the configuration for dialing and preparing new clients.
+type dialConfig struct {
+	dialFunc DialFunc
+
+	// username and password are for auth.
+	username string
+	password string
+
+	// interceptor is an optional interceptor for all commands.
+	interceptor Interceptor
+
+	// database is the logical database to select, if not 0.
+	database int
+
+	// scripts are loaded on every new connection, so that a connection to a
+	// server that lost its script cache (for example, after a failover) does
+	// not start with a NOSCRIPT error.
+	scripts []string
+
+	// library, if set, is a Redis Function library loaded on every new
+	// connection instead of the scripts.
+	library string
+}
+
+// dialClient dials a new connection and prepares a client on it. The dial is
+// canceled if ctx is done or stopCh is closed while it is in flight.
+func (c *dialConfig) dialClient(ctx context.Context, stopCh <-chan struct{}) (*client, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	conn, err := c.dialFunc(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newClient(ctx, conn, c.username, c.password, c.interceptor)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if c.database != 0 {
+		if _, err := client.doContext(ctx, "SELECT", strconv.Itoa(c.database)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to select database: %w", err)
+		}
+	}
+
+	if c.library != "" {
+		if _, err := client.doContext(ctx, "FUNCTION", "LOAD", "REPLACE", c.library); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to load functions: %w", err)
+		}
+		return client, nil
+	}
+
+	for _, script := range c.scripts {
+		if _, err := client.doContext(ctx, "SCRIPT", "LOAD", script); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to prime script: %w", err)
+		}
+	}
+	return client, nil
+}
+
+// TLSDialFunc returns a DialFunc that connects to the given TCP address and
+// performs a TLS handshake using the given configuration. If config does not
+// specify a ServerName, the host portion of addr is used. The context
+// deadline applies to both the dial and the handshake.
+func TLSDialFunc(addr string, config *tls.Config) DialFunc {
+	if config == nil {
+		config = new(tls.Config)
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			config.ServerName = host
+		} else {
+			config.ServerName = addr
+		}
+	}
+
+	dial := TCPDialFunc(addr)
+	return func(ctx context.Context) (net.Conn, error) {
+		conn, err := dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConn := tls.Client(conn, config)
+		if deadline, ok := ctx.Deadline(); ok {
+			if err := tlsConn.SetDeadline(deadline); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			defer tlsConn.SetDeadline(time.Time{})
+		}
+
+		// Close the connection if the context is canceled before the handshake
+		// finishes, since the handshake does not accept a context.
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+			case <-done:
+			}
+		}()
+
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, fmt.Errorf("tls handshake failed: %w", err)
+		}
+		return tlsConn, nil
+	}
+}
@@ -0,0 +1,152 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptDialFunc(t *testing.T) {
+	t.Parallel()
+
+	t.Run("dials", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		dial := AdaptDialFunc(func() (net.Conn, error) {
+			return net.Dial("tcp", f.addr())
+		})
+
+		conn, err := dial(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		release := make(chan struct{})
+		dialed := make(chan net.Conn, 1)
+		dial := AdaptDialFunc(func() (net.Conn, error) {
+			<-release
+			conn, err := net.Dial("tcp", f.addr())
+			dialed <- conn
+			return conn, err
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+		defer cancel()
+
+		if _, err := dial(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected %v to be %v", err, context.DeadlineExceeded)
+		}
+
+		// The late connection must be closed.
+		close(release)
+		conn := <-dialed
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Error("expected late connection to be closed")
+		}
+	})
+}
+
+func TestStore_DialContext(t *testing.T) {
+	t.Parallel()
+
+	// newBlockingStore returns a store whose only connection is discarded on
+	// first use and whose subsequent dials block until their context is done.
+	newBlockingStore := func(tb testing.TB) (*store, chan error) {
+		f := newFakeRedis(tb)
+
+		var lock sync.Mutex
+		dials := 0
+		dialErrCh := make(chan error, 1)
+
+		s, err := New(&Config{
+			InitialPoolSize: 1,
+			MaxPoolSize:     1,
+			DialFunc: func(ctx context.Context) (net.Conn, error) {
+				lock.Lock()
+				dials++
+				n := dials
+				lock.Unlock()
+
+				if n == 1 {
+					return f.dial(ctx)
+				}
+
+				<-ctx.Done()
+				dialErrCh <- ctx.Err()
+				return nil, ctx.Err()
+			},
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		var once sync.Once
+		f.inject(func(args []string) *fakeFault {
+			var ft *fakeFault
+			once.Do(func() {
+				ft = &fakeFault{drop: true}
+			})
+			return ft
+		})
+		if _, err := s.Take(context.Background(), testKey(tb)); err == nil {
+			tb.Fatal("expected dropped connection to fail")
+		}
+		return s.(*store), dialErrCh
+	}
+
+	t.Run("deadline", func(t *testing.T) {
+		t.Parallel()
+
+		s, dialErrCh := newBlockingStore(t)
+		defer s.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		if _, err := s.Take(ctx, testKey(t)); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected %v to be %v", err, context.DeadlineExceeded)
+		}
+		if err := <-dialErrCh; !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("dial: expected %v to be %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		t.Parallel()
+
+		s, dialErrCh := newBlockingStore(t)
+
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := s.Take(context.Background(), testKey(t))
+			errCh <- err
+		}()
+
+		time.Sleep(25 * time.Millisecond)
+		s.Close()
+
+		select {
+		case err := <-dialErrCh:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("dial: expected %v to be %v", err, context.Canceled)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected close to cancel the in-flight dial")
+		}
+
+		if err := <-errCh; err == nil {
+			t.Error("expected take to fail")
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package redisstore
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/sethvargo/go-limiter/bucketstate"
+)
+
+// These errors classify error replies from Redis. Use errors.Is to check for
+// them on errors returned by New and Take. The scripts also reply with an
+// error wrapping bucketstate.ErrNewerVersion for buckets written by a newer
+// release, which they leave untouched.
+var (
+	// ErrAuth indicates the credentials were rejected or the user lacks
+	// permission to run the limiter commands (NOAUTH, WRONGPASS, or NOPERM).
+	ErrAuth = errors.New("redis: authentication failed")
+
+	// ErrReadOnly indicates the server is a read-only replica (READONLY), for
+	// example because it was demoted during a failover.
+	ErrReadOnly = errors.New("redis: server is read-only")
+
+	// ErrMoved indicates the key is served by another node of a Redis Cluster
+	// (MOVED or ASK).
+	ErrMoved = errors.New("redis: key moved to another node")
+
+	// ErrOOM indicates the server is out of memory (OOM).
+	ErrOOM = errors.New("redis: out of memory")
+
+	// ErrBusy indicates the server is busy running another script (BUSY).
+	ErrBusy = errors.New("redis: server is busy")
+
+	// ErrLoading indicates the server is still loading its dataset (LOADING).
+	ErrLoading = errors.New("redis: server is loading")
+
+	// errNoScript indicates the script is not in the server's cache (NOSCRIPT).
+	errNoScript = errors.New("redis: script not found")
+)
+
+// replyErrorKinds maps the error code at the start of an error reply to its
+// classification.
+var replyErrorKinds = map[string]error{
+	"NOAUTH":    ErrAuth,
+	"WRONGPASS": ErrAuth,
+	"NOPERM":    ErrAuth,
+	"READONLY":  ErrReadOnly,
+	"MOVED":     ErrMoved,
+	"ASK":       ErrMoved,
+	"OOM":       ErrOOM,
+	"BUSY":      ErrBusy,
+	"LOADING":   ErrLoading,
+	"NOSCRIPT":  errNoScript,
+
+	"NEWERVERSION": bucketstate.ErrNewerVersion,
+}
+
+// code returns the error code of the reply, which is the first word by
+// convention.
+func (e replyError) code() string {
+	s := string(e)
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+// Is reports whether the reply is classified as target.
+func (e replyError) Is(target error) bool {
+	kind, ok := replyErrorKinds[e.code()]
+	return ok && kind == target
+}
+
+// isNoScript returns true if err is a NOSCRIPT error reply, which means the
+// server does not have the requested script cached.
+func isNoScript(err error) bool {
+	return errors.Is(err, errNoScript)
+}
+
+// isNoFunction returns true if err is the error reply to an FCALL of a
+// function that is not loaded. Redis reports it as a generic ERR, so it is
+// matched by message.
+func isNoFunction(err error) bool {
+	var rerr replyError
+	return errors.As(err, &rerr) && strings.Contains(string(rerr), "Function not found")
+}
+
+// isConnUsable returns true if the connection that produced err can be used
+// for further commands. Errors other than server replies may leave the
+// connection in an unknown state. READONLY and MOVED replies mean the
+// connection points at the wrong node, so a new connection is dialed in the
+// hope that the DialFunc resolves the current master.
+func isConnUsable(err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var rerr replyError
+	if !errors.As(err, &rerr) {
+		return false
+	}
+	return !errors.Is(rerr, ErrReadOnly) && !errors.Is(rerr, ErrMoved)
+}
@@ -0,0 +1,101 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplyError_Is(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		reply  string
+		target error
+		usable bool
+	}{
+		{"NOAUTH Authentication required.", ErrAuth, true},
+		{"WRONGPASS invalid username-password pair", ErrAuth, true},
+		{"NOPERM this user has no permissions to run the 'evalsha' command", ErrAuth, true},
+		{"READONLY You can't write against a read only replica.", ErrReadOnly, false},
+		{"MOVED 3999 127.0.0.1:6381", ErrMoved, false},
+		{"ASK 3999 127.0.0.1:6381", ErrMoved, false},
+		{"OOM command not allowed when used memory > 'maxmemory'.", ErrOOM, true},
+		{"BUSY Redis is busy running a script.", ErrBusy, true},
+		{"LOADING Redis is loading the dataset in memory", ErrLoading, true},
+		{"NOSCRIPT No matching script. Please use EVAL.", errNoScript, true},
+		{"ERR something went wrong", nil, true},
+		{"READONLYISH not a real code", nil, true},
+	}
+
+	all := []error{ErrAuth, ErrReadOnly, ErrMoved, ErrOOM, ErrBusy, ErrLoading, errNoScript}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.reply, func(t *testing.T) {
+			t.Parallel()
+
+			err := fmt.Errorf("wrapped: %w", replyError(tc.reply))
+			for _, target := range all {
+				if got, want := errors.Is(err, target), target == tc.target; got != want {
+					t.Errorf("is %v: expected %t to be %t", target, got, want)
+				}
+			}
+			if got, want := isConnUsable(err), tc.usable; got != want {
+				t.Errorf("usable: expected %t to be %t", got, want)
+			}
+		})
+	}
+}
+
+func TestStore_RedialsAfterReadOnly(t *testing.T) {
+	t.Parallel()
+
+	for _, multiplex := range []bool{false, true} {
+		multiplex := multiplex
+
+		t.Run(fmt.Sprintf("multiplex=%t", multiplex), func(t *testing.T) {
+			t.Parallel()
+
+			f := newFakeRedis(t)
+			s, err := New(&Config{
+				Tokens:          5,
+				Interval:        time.Minute,
+				InitialPoolSize: 1,
+				MaxPoolSize:     1,
+				DialFunc:        f.dial,
+				Multiplex:       multiplex,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			var once sync.Once
+			f.inject(func(args []string) *fakeFault {
+				var ft *fakeFault
+				if args[0] == "EVALSHA" {
+					once.Do(func() {
+						ft = &fakeFault{reply: "-READONLY You can't write against a read only replica.\r\n"}
+					})
+				}
+				return ft
+			})
+
+			ctx := context.Background()
+			if _, err := s.Take(ctx, testKey(t)); !errors.Is(err, ErrReadOnly) {
+				t.Fatalf("expected %v to be %v", err, ErrReadOnly)
+			}
+			if res, err := s.Take(ctx, testKey(t)); !res.Allowed || err != nil {
+				t.Fatalf("expected second take to succeed, got %t, %v", res.Allowed, err)
+			}
+			if got, want := f.dialCount(), 2; got != want {
+				t.Errorf("dials: expected %d to be %d", got, want)
+			}
+		})
+	}
+}
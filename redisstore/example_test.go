@@ -1,8 +1,10 @@
 package redisstore_test
 
 import (
+	"context"
 	"log"
 	"net"
+	"os"
 	"time"
 
 	"github.com/sethvargo/go-limiter/redisstore"
@@ -15,8 +17,9 @@ func ExampleNew() {
 		InitialPoolSize: 32,
 		MaxPoolSize:     128,
 		AuthPassword:    "my-password",
-		DialFunc: func() (net.Conn, error) {
-			conn, err := net.Dial("tcp", "127.0.0.1:6379")
+		DialFunc: func(ctx context.Context) (net.Conn, error) {
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", "127.0.0.1:6379")
 			if err != nil {
 				return nil, err
 			}
@@ -28,6 +31,69 @@ func ExampleNew() {
 	}
 	defer store.Close()
 
-	limit, remaining, reset, ok := store.Take("my-key")
-	_, _, _, _ = limit, remaining, reset, ok
+	ctx := context.Background()
+	res, err := store.Take(ctx, "my-key")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, _, _, _ = res.Limit, res.Remaining, res.ResetAt, res.Allowed
+}
+
+func ExampleNewFromURL() {
+	// For example, REDIS_URL=rediss://:my-password@redis.example.com:6380/0
+	store, err := redisstore.NewFromURL(os.Getenv("REDIS_URL"), &redisstore.Config{
+		Tokens:   15,
+		Interval: time.Minute,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	res, err := store.Take(ctx, "my-key")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, _, _, _ = res.Limit, res.Remaining, res.ResetAt, res.Allowed
+}
+
+func ExampleInterceptor() {
+	// This interceptor adds latency to every limiter script call, which is
+	// useful for validating the FailureMode under a slow Redis.
+	chaos := redisstore.Interceptor(func(args []string, invoke redisstore.Invoker) ([]byte, error) {
+		if args[0] == "EVALSHA" {
+			time.Sleep(250 * time.Millisecond)
+		}
+		return invoke(args)
+	})
+
+	store, err := redisstore.New(&redisstore.Config{
+		Tokens:      15,
+		Interval:    time.Minute,
+		FailureMode: redisstore.FailOpen,
+		Interceptor: chaos,
+		DialFunc:    redisstore.TCPDialFunc("127.0.0.1:6379"),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+}
+
+func ExampleAdaptDialFunc() {
+	// Existing dial functions that do not accept a context can be adapted.
+	dial := func() (net.Conn, error) {
+		return net.Dial("tcp", "127.0.0.1:6379")
+	}
+
+	store, err := redisstore.New(&redisstore.Config{
+		Tokens:   15,
+		Interval: time.Minute,
+		DialFunc: redisstore.AdaptDialFunc(dial),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
 }
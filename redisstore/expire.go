@@ -0,0 +1,129 @@
+package redisstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// minExpireBackoff and maxExpireBackoff bound the delay between attempts to
+	// resubscribe after the subscription connection fails.
+	minExpireBackoff = 100 * time.Millisecond
+	maxExpireBackoff = 10 * time.Second
+)
+
+// expireSubscriber listens for expired key events on a dedicated connection
+// and calls fn for each expired key with the prefix, after removing the
+// prefix. It resubscribes with backoff until it is closed.
+type expireSubscriber struct {
+	dialConfig *dialConfig
+	channel    string
+	prefix     string
+	fn         func(key string)
+
+	// ignore is a key that is reported by Redis but not to fn, like the global
+	// key.
+	ignore string
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	doneCh   chan struct{}
+}
+
+// newExpireSubscriber starts a subscriber for the expired events of the
+// configured database. The subscriber only needs the connection settings, so
+// the scripts are not loaded on its connections.
+func newExpireSubscriber(c dialConfig, prefix, ignore string, fn func(key string)) *expireSubscriber {
+	c.scripts = nil
+	c.library = ""
+
+	s := &expireSubscriber{
+		dialConfig: &c,
+		channel:    fmt.Sprintf("__keyevent@%d__:expired", c.database),
+		prefix:     prefix,
+		fn:         fn,
+		ignore:     ignore,
+		stopCh:     make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// run subscribes until the subscriber is closed.
+func (s *expireSubscriber) run() {
+	defer close(s.doneCh)
+
+	backoff := minExpireBackoff
+	for {
+		if s.subscribe() {
+			backoff = minExpireBackoff
+		}
+
+		select {
+		case <-s.stopCh:
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxExpireBackoff {
+			backoff = maxExpireBackoff
+		}
+	}
+}
+
+// subscribe dials a new connection, subscribes to the channel, and delivers
+// events until the connection fails or the subscriber is closed. It returns
+// true if the subscription was established.
+func (s *expireSubscriber) subscribe() bool {
+	client, err := s.dialConfig.dialClient(context.Background(), s.stopCh)
+	if err != nil {
+		return false
+	}
+
+	// The reads below block indefinitely, so the connection is closed to
+	// unblock them when the subscriber is closed.
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go func() {
+		select {
+		case <-s.stopCh:
+		case <-doneCh:
+		}
+		client.conn.Close()
+	}()
+
+	if _, err := client.do("SUBSCRIBE", s.channel); err != nil {
+		return false
+	}
+
+	for {
+		resp, err := parseResponse(client.br)
+		if err != nil {
+			return true
+		}
+
+		// Messages are of the form ["message", channel, key].
+		msg := resp.array()
+		if len(msg) != 3 || !strings.EqualFold(msg[0].s, "message") {
+			continue
+		}
+		key := msg[2].s
+		if key == s.ignore || !strings.HasPrefix(key, s.prefix) {
+			continue
+		}
+		s.fn(strings.TrimPrefix(key, s.prefix))
+	}
+}
+
+// close stops the subscriber and waits for it to exit.
+func (s *expireSubscriber) close() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+	<-s.doneCh
+}
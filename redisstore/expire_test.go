@@ -0,0 +1,120 @@
+package redisstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitForSubscribers waits until the fake has n subscribed connections.
+func waitForSubscribers(tb testing.TB, f *fakeRedis, n int) {
+	tb.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for f.subscriberCount() != n {
+		if time.Now().After(deadline) {
+			tb.Fatalf("expected %d subscribers, got %d", n, f.subscriberCount())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStore_OnExpire(t *testing.T) {
+	t.Parallel()
+
+	newStore := func(tb testing.TB, f *fakeRedis, c *Config) (*store, chan string) {
+		tb.Helper()
+
+		expired := make(chan string, 10)
+		c.DialFunc = f.dial
+		c.OnExpire = func(key string) {
+			expired <- key
+		}
+
+		s, err := New(c)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { s.Close() })
+
+		waitForSubscribers(tb, f, 1)
+		return s.(*store), expired
+	}
+
+	receive := func(tb testing.TB, expired chan string) string {
+		tb.Helper()
+
+		select {
+		case key := <-expired:
+			return key
+		case <-time.After(2 * time.Second):
+			tb.Fatal("expected an expired key")
+			return ""
+		}
+	}
+
+	t.Run("delivers", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		s, expired := newStore(t, f, &Config{
+			Database:     3,
+			GlobalTokens: 10,
+		})
+
+		f.lock.Lock()
+		for _, channel := range f.subscribers {
+			if got, want := channel, "__keyevent@3__:expired"; got != want {
+				t.Errorf("channel: expected %q to be %q", got, want)
+			}
+		}
+		f.lock.Unlock()
+
+		key := testKey(t)
+		if _, err := s.Take(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+
+		// The global key is not reported.
+		f.expire(s.globalKey)
+		f.expire(key)
+
+		if got, want := receive(t, expired), key; got != want {
+			t.Errorf("expected %q to be %q", got, want)
+		}
+	})
+
+	t.Run("resubscribes", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		_, expired := newStore(t, f, &Config{})
+
+		f.lock.Lock()
+		for conn := range f.subscribers {
+			conn.Close()
+		}
+		f.lock.Unlock()
+
+		waitForSubscribers(t, f, 0)
+		waitForSubscribers(t, f, 1)
+
+		key := testKey(t)
+		f.expire(key)
+		if got, want := receive(t, expired), key; got != want {
+			t.Errorf("expected %q to be %q", got, want)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		s, _ := newStore(t, f, &Config{})
+
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		waitForSubscribers(t, f, 0)
+	})
+}
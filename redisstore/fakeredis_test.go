@@ -0,0 +1,1060 @@
+package redisstore
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process server that speaks enough of the RESP protocol to
+// exercise the store without a live Redis. It does not embed a Lua
+// interpreter; instead it recognizes the limiter script and runs an equivalent
+// Go implementation against its in-memory hashes.
+type fakeRedis struct {
+	listener net.Listener
+
+	// password, if set, must be provided via AUTH before other commands.
+	password string
+
+	lock    sync.Mutex
+	data    map[string]map[string]string
+	expires map[string]time.Time
+	scripts map[string]string
+	fault   func(args []string) *fakeFault
+	dials   int
+	conns   map[net.Conn]struct{}
+
+	// functions maps the names of loaded functions to their bodies, and
+	// libraries maps the names of loaded libraries to the names of their
+	// functions.
+	functions map[string]string
+	libraries map[string][]string
+
+	// cell is set if the fake has the CL.THROTTLE command of redis-cell.
+	cell bool
+
+	// subscribers are the channels each subscribed connection listens on.
+	subscribers map[net.Conn]string
+
+	// clockOffset is added to the local time to produce the server time.
+	clockOffset time.Duration
+
+	// streams holds the field-value pairs of each entry added with XADD.
+	streams map[string][][]string
+
+	// cursors maps each SCAN cursor handed out to the last key of its page.
+	cursors map[int]string
+
+	wg sync.WaitGroup
+}
+
+// fakeFault describes a fault to inject in response to a command.
+type fakeFault struct {
+	// delay is the amount of time to wait before replying.
+	delay time.Duration
+
+	// drop closes the connection instead of replying.
+	drop bool
+
+	// reply is a raw RESP reply to send instead of executing the command.
+	reply string
+}
+
+// newFakeRedis starts a new fake server on a random local port. The server is
+// shut down when the test finishes.
+func newFakeRedis(tb testing.TB) *fakeRedis {
+	tb.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("failed to listen: %v", err)
+	}
+	return newFakeRedisListener(tb, l)
+}
+
+// newFakeRedisTLS is like newFakeRedis, but the server requires TLS. It
+// returns a client configuration that trusts the server's certificate.
+func newFakeRedisTLS(tb testing.TB) (*fakeRedis, *tls.Config) {
+	tb.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatalf("failed to generate key: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fakeredis"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		tb.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+		}},
+	})
+	if err != nil {
+		tb.Fatalf("failed to listen: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return newFakeRedisListener(tb, l), &tls.Config{RootCAs: roots}
+}
+
+// newFakeRedisListener starts a new fake server on the given listener.
+func newFakeRedisListener(tb testing.TB, l net.Listener) *fakeRedis {
+	tb.Helper()
+
+	f := &fakeRedis{
+		listener: l,
+		data:     make(map[string]map[string]string),
+		expires:  make(map[string]time.Time),
+		scripts:  make(map[string]string),
+		conns:    make(map[net.Conn]struct{}),
+
+		functions: make(map[string]string),
+		libraries: make(map[string][]string),
+
+		subscribers: make(map[net.Conn]string),
+
+		streams: make(map[string][][]string),
+		cursors: make(map[int]string),
+	}
+
+	f.wg.Add(1)
+	go f.serve()
+
+	tb.Cleanup(f.close)
+	return f
+}
+
+// addr returns the address the server is listening on.
+func (f *fakeRedis) addr() string {
+	return f.listener.Addr().String()
+}
+
+// dial is a DialFunc that connects to the fake server.
+func (f *fakeRedis) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", f.addr())
+}
+
+// inject sets the fault function, which is consulted for each command. A nil
+// return value executes the command normally.
+func (f *fakeRedis) inject(fn func(args []string) *fakeFault) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.fault = fn
+}
+
+// setPassword requires clients to AUTH with the given password.
+func (f *fakeRedis) setPassword(password string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.password = password
+}
+
+// setClockOffset skews the server clock relative to the local clock.
+func (f *fakeRedis) setClockOffset(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.clockOffset = d
+}
+
+// expire deletes the key as if its TTL passed and publishes an expired event
+// to all subscribers.
+func (f *fakeRedis) expire(key string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	delete(f.data, key)
+	delete(f.expires, key)
+	for conn, channel := range f.subscribers {
+		io.WriteString(conn, "*3\r\n"+bulk("message")+bulk(channel)+bulk(key))
+	}
+}
+
+// subscriberCount returns the number of subscribed connections.
+func (f *fakeRedis) subscriberCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.subscribers)
+}
+
+// dialCount returns the number of connections that have been accepted.
+func (f *fakeRedis) dialCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.dials
+}
+
+func (f *fakeRedis) close() {
+	f.listener.Close()
+
+	f.lock.Lock()
+	for conn := range f.conns {
+		conn.Close()
+	}
+	f.lock.Unlock()
+
+	f.wg.Wait()
+}
+
+func (f *fakeRedis) serve() {
+	defer f.wg.Done()
+
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		f.lock.Lock()
+		f.dials++
+		f.conns[conn] = struct{}{}
+		f.lock.Unlock()
+
+		f.wg.Add(1)
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer f.wg.Done()
+	defer func() {
+		f.lock.Lock()
+		delete(f.conns, conn)
+		delete(f.subscribers, conn)
+		f.lock.Unlock()
+		conn.Close()
+	}()
+
+	f.lock.Lock()
+	password := f.password
+	f.lock.Unlock()
+
+	authed := password == ""
+	br := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(br)
+		if err != nil {
+			return
+		}
+
+		f.lock.Lock()
+		fault := f.fault
+		f.lock.Unlock()
+
+		if fault != nil {
+			if ft := fault(args); ft != nil {
+				if ft.delay > 0 {
+					time.Sleep(ft.delay)
+				}
+				if ft.drop {
+					return
+				}
+				if ft.reply != "" {
+					if _, err := io.WriteString(conn, ft.reply); err != nil {
+						return
+					}
+					continue
+				}
+			}
+		}
+
+		var reply string
+		switch {
+		case strings.EqualFold(args[0], "AUTH"):
+			if args[len(args)-1] == password {
+				authed = true
+				reply = "+OK\r\n"
+			} else {
+				reply = "-WRONGPASS invalid username-password pair\r\n"
+			}
+		case !authed:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case strings.EqualFold(args[0], "SUBSCRIBE") && len(args) == 2:
+			f.lock.Lock()
+			f.subscribers[conn] = args[1]
+			f.lock.Unlock()
+			reply = "*3\r\n" + bulk("subscribe") + bulk(args[1]) + ":1\r\n"
+		default:
+			reply = f.exec(args)
+		}
+
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// exec executes the given command and returns the raw RESP reply.
+func (f *fakeRedis) exec(args []string) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	cmd := strings.ToUpper(args[0])
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+	case "SCRIPT":
+		if len(args) < 2 {
+			return "-ERR wrong number of arguments\r\n"
+		}
+		switch strings.ToUpper(args[1]) {
+		case "LOAD":
+			if len(args) != 3 {
+				return "-ERR wrong number of arguments\r\n"
+			}
+			sha := fmt.Sprintf("%x", sha1.Sum([]byte(args[2])))
+			f.scripts[sha] = args[2]
+			return bulk(sha)
+		case "FLUSH":
+			f.scripts = make(map[string]string)
+			return "+OK\r\n"
+		}
+		return "-ERR unknown subcommand\r\n"
+	case "FUNCTION":
+		if len(args) < 2 {
+			return "-ERR wrong number of arguments\r\n"
+		}
+		switch strings.ToUpper(args[1]) {
+		case "LOAD":
+			// Like Redis, a library is only loaded again with REPLACE, and its
+			// functions cannot share names with those of other libraries.
+			code := args[len(args)-1]
+			replace := len(args) > 3 && strings.ToUpper(args[2]) == "REPLACE"
+			lib := fakeLibraryNameRe.FindStringSubmatch(code)
+			if lib == nil {
+				return "-ERR Missing library metadata\r\n"
+			}
+			old, loaded := f.libraries[lib[1]]
+			if loaded && !replace {
+				return fmt.Sprintf("-ERR Library '%s' already exists\r\n", lib[1])
+			}
+
+			// Each function's body is the text before its registration, which
+			// is how the store lays out its library.
+			funcs := make(map[string]string)
+			rest := code
+			for _, m := range fakeRegisterRe.FindAllStringSubmatchIndex(code, -1) {
+				start := len(code) - len(rest)
+				funcs[code[m[2]:m[3]]] = code[start:m[0]]
+				rest = code[m[1]:]
+			}
+			for name := range funcs {
+				if _, ok := f.functions[name]; ok && !containsString(old, name) {
+					return fmt.Sprintf("-ERR Function %s already exists\r\n", name)
+				}
+			}
+
+			for _, name := range old {
+				delete(f.functions, name)
+			}
+			names := make([]string, 0, len(funcs))
+			for name, body := range funcs {
+				f.functions[name] = body
+				names = append(names, name)
+			}
+			f.libraries[lib[1]] = names
+			return bulk(lib[1])
+		case "FLUSH":
+			f.functions = make(map[string]string)
+			f.libraries = make(map[string][]string)
+			return "+OK\r\n"
+		}
+		return "-ERR unknown subcommand\r\n"
+	case "COMMAND":
+		if len(args) != 3 || strings.ToUpper(args[1]) != "INFO" {
+			return "-ERR unknown subcommand\r\n"
+		}
+		if f.cell && strings.ToUpper(args[2]) == "CL.THROTTLE" {
+			return "*1\r\n*2\r\n" + bulk("cl.throttle") + ":-5\r\n"
+		}
+		return "*1\r\n*-1\r\n"
+	case "CL.THROTTLE":
+		if !f.cell {
+			return "-ERR unknown command 'CL.THROTTLE'\r\n"
+		}
+		return f.throttle(args[1:])
+	case "FCALL":
+		if len(args) < 3 {
+			return "-ERR wrong number of arguments\r\n"
+		}
+		script, ok := f.functions[args[1]]
+		if !ok {
+			return "-ERR Function not found\r\n"
+		}
+
+		numKeys, err := strconv.Atoi(args[2])
+		if err != nil || numKeys < 0 || len(args) < 3+numKeys {
+			return "-ERR invalid number of keys\r\n"
+		}
+		keys, argv := args[3:3+numKeys], args[3+numKeys:]
+		if strings.Contains(script, "local refund") {
+			return f.evalRefund(script, keys, argv)
+		}
+		return f.evalLimiter(script, keys, argv)
+	case "EVAL", "EVALSHA":
+		if len(args) < 3 {
+			return "-ERR wrong number of arguments\r\n"
+		}
+
+		script := args[1]
+		if cmd == "EVALSHA" {
+			s, ok := f.scripts[strings.ToLower(script)]
+			if !ok {
+				return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+			}
+			script = s
+		} else {
+			// Like Redis, EVAL caches the script for later EVALSHA calls.
+			f.scripts[fmt.Sprintf("%x", sha1.Sum([]byte(script)))] = script
+		}
+
+		numKeys, err := strconv.Atoi(args[2])
+		if err != nil || numKeys < 0 || len(args) < 3+numKeys {
+			return "-ERR invalid number of keys\r\n"
+		}
+		keys, argv := args[3:3+numKeys], args[3+numKeys:]
+		if strings.Contains(script, "local refund") {
+			return f.evalRefund(script, keys, argv)
+		}
+		return f.evalLimiter(script, keys, argv)
+	case "SELECT":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments\r\n"
+		}
+		if _, err := strconv.Atoi(args[1]); err != nil {
+			return "-ERR value is not an integer or out of range\r\n"
+		}
+		return "+OK\r\n"
+	case "TIME":
+		now := int64(f.now())
+		return "*2\r\n" + bulk(strconv.FormatInt(now/1e9, 10)) +
+			bulk(strconv.FormatInt(now%1e9/1e3, 10))
+	case "HMGET":
+		if len(args) < 3 {
+			return "-ERR wrong number of arguments\r\n"
+		}
+		h := f.data[args[1]]
+		var b strings.Builder
+		b.WriteString("*" + strconv.Itoa(len(args)-2) + "\r\n")
+		for _, field := range args[2:] {
+			if v, ok := h[field]; ok {
+				b.WriteString(bulk(v))
+			} else {
+				b.WriteString("$-1\r\n")
+			}
+		}
+		return b.String()
+	case "SCAN":
+		// The keys are walked in sorted order, and each cursor continues after
+		// the last key of its page, so keys deleted during a scan do not cause
+		// others to be skipped, as in Redis. MATCH is applied with path.Match,
+		// which is close enough to the Redis glob syntax.
+		cursor, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "-ERR invalid cursor\r\n"
+		}
+		pattern, count := "*", 10
+		for i := 2; i+1 < len(args); i += 2 {
+			switch strings.ToUpper(args[i]) {
+			case "MATCH":
+				pattern = args[i+1]
+			case "COUNT":
+				count, _ = strconv.Atoi(args[i+1])
+			}
+		}
+
+		all := make([]string, 0, len(f.data))
+		for k := range f.data {
+			all = append(all, k)
+		}
+		sort.Strings(all)
+
+		start := 0
+		if cursor != 0 {
+			last, ok := f.cursors[cursor]
+			if !ok {
+				return "-ERR invalid cursor\r\n"
+			}
+			start = sort.SearchStrings(all, last)
+			if start < len(all) && all[start] == last {
+				start++
+			}
+		}
+
+		end := start + count
+		if end >= len(all) {
+			end = len(all)
+		}
+		var keys []string
+		for _, k := range all[start:end] {
+			if ok, _ := path.Match(pattern, k); ok {
+				keys = append(keys, k)
+			}
+		}
+		next := 0
+		if end < len(all) {
+			next = len(f.cursors) + 1
+			f.cursors[next] = all[end-1]
+		}
+
+		var b strings.Builder
+		b.WriteString("*2\r\n" + bulk(strconv.Itoa(next)))
+		b.WriteString("*" + strconv.Itoa(len(keys)) + "\r\n")
+		for _, k := range keys {
+			b.WriteString(bulk(k))
+		}
+		return b.String()
+	case "HGETALL":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments\r\n"
+		}
+		h := f.data[args[1]]
+		fields := make([]string, 0, len(h))
+		for field := range h {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		var b strings.Builder
+		b.WriteString("*" + strconv.Itoa(2*len(fields)) + "\r\n")
+		for _, field := range fields {
+			b.WriteString(bulk(field) + bulk(h[field]))
+		}
+		return b.String()
+	case "HSET":
+		if len(args) < 4 || len(args)%2 != 0 {
+			return "-ERR wrong number of arguments\r\n"
+		}
+		h, ok := f.data[args[1]]
+		if !ok {
+			h = make(map[string]string)
+			f.data[args[1]] = h
+		}
+		n := 0
+		for i := 2; i+1 < len(args); i += 2 {
+			if _, ok := h[args[i]]; !ok {
+				n++
+			}
+			h[args[i]] = args[i+1]
+		}
+		return ":" + strconv.Itoa(n) + "\r\n"
+	case "EXISTS":
+		n := 0
+		for _, k := range args[1:] {
+			if _, ok := f.data[k]; ok {
+				n++
+			}
+		}
+		return ":" + strconv.Itoa(n) + "\r\n"
+	case "TTL":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments\r\n"
+		}
+		if _, ok := f.data[args[1]]; !ok {
+			return ":-2\r\n"
+		}
+		exp, ok := f.expires[args[1]]
+		if !ok {
+			return ":-1\r\n"
+		}
+		return ":" + strconv.FormatInt(int64(time.Until(exp).Seconds()), 10) + "\r\n"
+	case "EXPIRE":
+		if len(args) != 3 {
+			return "-ERR wrong number of arguments\r\n"
+		}
+		secs, err := strconv.Atoi(args[2])
+		if err != nil {
+			return "-ERR value is not an integer or out of range\r\n"
+		}
+		if _, ok := f.data[args[1]]; !ok {
+			return ":0\r\n"
+		}
+		f.expires[args[1]] = time.Now().Add(time.Duration(secs) * time.Second)
+		return ":1\r\n"
+	case "XADD":
+		// Only "XADD stream [MAXLEN ~ n] * field value ..." is supported.
+		if len(args) < 5 {
+			return "-ERR wrong number of arguments\r\n"
+		}
+		stream, rest := args[1], args[2:]
+		maxLen := -1
+		if strings.EqualFold(rest[0], "MAXLEN") && len(rest) > 3 && rest[1] == "~" {
+			n, err := strconv.Atoi(rest[2])
+			if err != nil {
+				return "-ERR value is not an integer or out of range\r\n"
+			}
+			maxLen, rest = n, rest[3:]
+		}
+		if rest[0] != "*" || len(rest)%2 != 1 {
+			return "-ERR syntax error\r\n"
+		}
+		entries := append(f.streams[stream], rest[1:])
+		if maxLen >= 0 && len(entries) > maxLen {
+			entries = entries[len(entries)-maxLen:]
+		}
+		f.streams[stream] = entries
+		id := strconv.FormatInt(time.Now().UnixNano()/1e6, 10) + "-" + strconv.Itoa(len(entries))
+		return bulk(id)
+	case "DEL", "UNLINK":
+		n := 0
+		for _, k := range args[1:] {
+			if _, ok := f.data[k]; ok {
+				n++
+			}
+			delete(f.data, k)
+			delete(f.expires, k)
+		}
+		return ":" + strconv.Itoa(n) + "\r\n"
+	}
+
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+var (
+	fakeVersionRe = regexp.MustCompile(`local version\s*=\s*(\d+)`)
+
+	fakeLibraryNameRe = regexp.MustCompile(`^#!lua name=(\w+)`)
+	fakeRegisterRe    = regexp.MustCompile(`redis\.register_function\('(\w+)', \w+\)`)
+)
+
+// fakeScriptParams are the version rendered into the limiter scripts and the
+// configuration passed before the arguments of each call.
+type fakeScriptParams struct {
+	version                                  float64
+	maxTokens, interval, rate, ttl           float64
+	globalTokens, globalInterval, globalRate float64
+	reserveFraction, debtLimit               float64
+	fixedTTL                                 bool
+}
+
+// parseScriptParams parses the version out of the rendered script body and
+// the configuration out of the first luaParams arguments. It returns the
+// arguments of the call that follow them.
+func parseScriptParams(script string, argv []string) (*fakeScriptParams, []string, bool) {
+	m := fakeVersionRe.FindStringSubmatch(script)
+	if m == nil || len(argv) < luaParams {
+		return nil, nil, false
+	}
+
+	ok := true
+	parse := func(v string) float64 {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			ok = false
+		}
+		return f
+	}
+
+	p := &fakeScriptParams{
+		version:        parse(m[1]),
+		maxTokens:      parse(argv[0]),
+		interval:       parse(argv[1]),
+		rate:           parse(argv[2]),
+		ttl:            parse(argv[3]),
+		globalTokens:   parse(argv[4]),
+		globalInterval: parse(argv[5]),
+		globalRate:     parse(argv[6]),
+
+		reserveFraction: parse(argv[7]),
+		debtLimit:       parse(argv[8]),
+		fixedTTL:        argv[9] == "1",
+	}
+	return p, argv[luaParams:], ok
+}
+
+// fakeBucket is a Go port of the bucket table used by the scripts.
+type fakeBucket struct {
+	key                       string
+	start, tick, tokens, next float64
+	debt                      float64
+	exists                    bool
+}
+
+// load is a Go port of the script's load function. The phase is the offset of
+// a new bucket's intervals, or empty to start them now. It must be called with
+// the lock held.
+func (f *fakeRedis) load(key string, now, maxTokens, interval, rate float64, phase string) *fakeBucket {
+	if exp, ok := f.expires[key]; ok && time.Now().After(exp) {
+		delete(f.data, key)
+		delete(f.expires, key)
+	}
+
+	h, ok := f.data[key]
+	if !ok {
+		start := now
+		if p, err := strconv.ParseFloat(phase, 64); err == nil {
+			start = now - math.Mod(now-p, interval)
+		}
+		return &fakeBucket{key: key, start: start, tokens: maxTokens, next: start + interval}
+	}
+
+	b := &fakeBucket{key: key, exists: true}
+	b.start, _ = strconv.ParseFloat(h["s"], 64)
+	b.tick, _ = strconv.ParseFloat(h["t"], 64)
+	b.tokens, _ = strconv.ParseFloat(h["k"], 64)
+	if d, ok := h["d"]; ok {
+		b.debt, _ = strconv.ParseFloat(d, 64)
+	}
+
+	currTick := math.Floor((now - b.start) / interval)
+	b.next = b.start + ((currTick + 1) * interval)
+	if b.tick < currTick {
+		if b.debt > 0 {
+			budget := (currTick - b.tick) * maxTokens
+			if budget <= b.debt {
+				b.debt -= budget
+				b.tokens = 0
+			} else {
+				b.tokens = math.Min(budget-b.debt, maxTokens)
+				b.debt = 0
+			}
+		} else {
+			b.tokens = math.Min((currTick-b.tick)*rate, maxTokens)
+		}
+		b.tick = currTick
+	}
+	return b
+}
+
+// newer reports whether the bucket at key was written by a newer version than
+// the script's, like the check of the script's load function. It must be
+// called with the lock held.
+func (f *fakeRedis) newer(key string, version float64) bool {
+	v, ok := f.data[key]["v"]
+	if !ok {
+		return false
+	}
+	n, _ := strconv.ParseFloat(v, 64)
+	return n > version
+}
+
+// fakeNewerReply is the error reply of the scripts for newer buckets.
+const fakeNewerReply = "-NEWERVERSION bucket was written by a newer version of the limiter\r\n"
+
+// save is a Go port of the script's save function. Like HSET, it keeps the
+// other fields of the hash. It must be called with the lock held.
+func (f *fakeRedis) save(b *fakeBucket, ttl float64, fixed bool) {
+	h := make(map[string]string)
+	for k, v := range f.data[b.key] {
+		h[k] = v
+	}
+	h["s"] = formatFloat(b.start)
+	h["t"] = formatFloat(b.tick)
+	h["k"] = formatFloat(b.tokens)
+	h["d"] = formatFloat(b.debt)
+	f.data[b.key] = h
+	if !fixed || !b.exists {
+		f.expires[b.key] = time.Now().Add(time.Duration(ttl) * time.Second)
+	}
+}
+
+// now returns the server time the scripts read via TIME.
+func (f *fakeRedis) now() float64 {
+	return float64(time.Now().Add(f.clockOffset).UnixNano())
+}
+
+// enableCell makes the fake support CL.THROTTLE.
+func (f *fakeRedis) enableCell() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.cell = true
+}
+
+// throttle runs a Go port of the GCRA of redis-cell's CL.THROTTLE, storing the
+// theoretical arrival time in the "tat" field. It must be called with the lock
+// held.
+func (f *fakeRedis) throttle(args []string) string {
+	if len(args) != 4 && len(args) != 5 {
+		return "-ERR wrong number of arguments\r\n"
+	}
+	var params [4]float64
+	params[3] = 1
+	for i, arg := range args[1:] {
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return "-ERR invalid argument\r\n"
+		}
+		params[i] = v
+	}
+	key, burst, count, period, quantity := args[0], params[0], params[1], params[2], params[3]
+
+	now := f.now()
+	emission := period * 1e9 / count
+	tolerance := emission * (burst + 1)
+
+	tat := now
+	if v, ok := f.data[key]["tat"]; ok {
+		tat, _ = strconv.ParseFloat(v, 64)
+		tat = math.Max(tat, now)
+	}
+
+	newTAT := tat + emission*quantity
+	diff := now - (newTAT - tolerance)
+	limited, retryAfter := 0, -1.0
+	if diff < 0 {
+		limited, retryAfter = 1, math.Ceil(-diff/1e9)
+		newTAT = tat
+	} else {
+		f.data[key] = map[string]string{"tat": formatFloat(newTAT)}
+	}
+
+	remaining := math.Max(math.Floor((tolerance-(newTAT-now))/emission), 0)
+	resetAfter := math.Ceil((newTAT - now) / 1e9)
+	return fmt.Sprintf("*5\r\n:%d\r\n:%d\r\n:%d\r\n:%d\r\n:%d\r\n",
+		limited, int64(burst+1), int64(remaining), int64(retryAfter), int64(resetAfter))
+}
+
+// evalLimiter runs a Go port of the limiter script. It must be called with the
+// lock held.
+func (f *fakeRedis) evalLimiter(script string, keys, argv []string) string {
+	p, argv, ok := parseScriptParams(script, argv)
+	if !ok || len(keys) < 1 || len(keys) > 2 {
+		return "-ERR fake: unrecognized script\r\n"
+	}
+
+	low := len(argv) > 0 && argv[0] == "low"
+
+	var reserve, globalReserve float64
+	if low {
+		reserve = math.Floor(p.maxTokens * p.reserveFraction)
+		globalReserve = math.Floor(p.globalTokens * p.reserveFraction)
+	}
+
+	var phase string
+	if len(argv) > 2 {
+		phase = argv[2]
+	}
+
+	for _, key := range keys {
+		if f.newer(key, p.version) {
+			return fakeNewerReply
+		}
+	}
+
+	now := f.now()
+	b := f.load(keys[0], now, p.maxTokens, p.interval, p.rate, phase)
+	var g *fakeBucket
+	if len(keys) == 2 {
+		g = f.load(keys[1], now, p.globalTokens, p.globalInterval, p.globalRate, "")
+	}
+
+	want := 1.0
+	if len(argv) > 1 {
+		want, _ = strconv.ParseFloat(argv[1], 64)
+	}
+
+	granted := math.Min(want, math.Max(math.Ceil(b.tokens-reserve), 0))
+	var borrowed float64
+	if !low && granted < want && b.tokens-granted <= 0 {
+		borrowed = math.Min(want-granted, math.Max(p.debtLimit-b.debt, 0))
+	}
+	if g != nil {
+		available := math.Max(math.Ceil(g.tokens-globalReserve), 0)
+		if granted+borrowed > available {
+			borrowed = math.Max(available-granted, 0)
+			granted = math.Min(granted, available)
+		}
+	}
+	total := granted + borrowed
+
+	if total > 0 {
+		b.tokens -= granted
+		b.debt += borrowed
+		f.save(b, p.ttl, p.fixedTTL)
+
+		remaining := b.tokens
+		if g != nil {
+			g.tokens -= total
+			f.save(g, p.ttl, p.fixedTTL)
+			remaining = math.Min(remaining, g.tokens)
+		}
+		return okReply(remaining, b.next, now, true, total)
+	}
+
+	keyOK := b.tokens > reserve || (!low && b.debt < p.debtLimit)
+
+	f.save(b, p.ttl, p.fixedTTL)
+	next := b.next
+	if g != nil {
+		f.save(g, p.ttl, p.fixedTTL)
+		if keyOK {
+			next = g.next
+		}
+	}
+	return okReply(0, next, now, false, 0)
+}
+
+// evalRefund runs a Go port of the refund script. It must be called with the
+// lock held.
+func (f *fakeRedis) evalRefund(script string, keys, argv []string) string {
+	p, argv, ok := parseScriptParams(script, argv)
+	if !ok || len(keys) < 1 || len(keys) > 2 || len(argv) < 1 || len(argv) > 3 {
+		return "-ERR fake: unrecognized script\r\n"
+	}
+	charge := len(argv) > 1 && argv[1] == "charge"
+	var phase string
+	if len(argv) > 2 {
+		phase = argv[2]
+	}
+
+	now := f.now()
+	if len(argv) > 1 && argv[1] == "metadata" {
+		if f.newer(keys[0], p.version) {
+			return fakeNewerReply
+		}
+		b := f.load(keys[0], now, p.maxTokens, p.interval, p.rate, phase)
+		f.save(b, p.ttl, p.fixedTTL)
+		f.data[b.key]["m"] = argv[0]
+		return ":0\r\n"
+	}
+
+	refund, err := strconv.ParseFloat(argv[0], 64)
+	if err != nil {
+		return "-ERR fake: invalid refund\r\n"
+	}
+	for _, key := range keys {
+		if f.newer(key, p.version) {
+			return fakeNewerReply
+		}
+	}
+
+	refundBucket := func(key string, maxTokens, interval, rate float64, phase string) {
+		b := f.load(key, now, maxTokens, interval, rate, phase)
+		if charge {
+			b.tokens = math.Min(b.tokens, math.Max(b.tokens-refund, 0))
+			f.save(b, p.ttl, p.fixedTTL)
+			return
+		}
+		if !b.exists {
+			return
+		}
+		n := refund
+		if n <= b.debt {
+			b.debt -= n
+			n = 0
+		} else {
+			n -= b.debt
+			b.debt = 0
+		}
+		b.tokens = math.Min(b.tokens+n, maxTokens)
+		f.save(b, p.ttl, p.fixedTTL)
+	}
+
+	refundBucket(keys[0], p.maxTokens, p.interval, p.rate, phase)
+	if len(keys) == 2 {
+		refundBucket(keys[1], p.globalTokens, p.globalInterval, p.globalRate, "")
+	}
+	return ":0\r\n"
+}
+
+// okReply renders the script's {tokens, next, ok, next - now, granted} return
+// value, converting Lua types the same way Redis does (numbers are truncated
+// to integers and false becomes a null bulk string).
+func okReply(tokens, next, now float64, ok bool, granted float64) string {
+	var b strings.Builder
+	b.WriteString("*5\r\n")
+	b.WriteString(":" + strconv.FormatInt(int64(tokens), 10) + "\r\n")
+	b.WriteString(":" + strconv.FormatInt(int64(next), 10) + "\r\n")
+	if ok {
+		b.WriteString(":1\r\n")
+	} else {
+		b.WriteString("$-1\r\n")
+	}
+	b.WriteString(":" + strconv.FormatInt(int64(next-now), 10) + "\r\n")
+	b.WriteString(":" + strconv.FormatInt(int64(granted), 10) + "\r\n")
+	return b.String()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func bulk(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+// readCommand reads a single RESP array of bulk strings.
+func readCommand(br *bufio.Reader) ([]string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[0] != star {
+		return nil, fmt.Errorf("expected array, got %q", line)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid array length %q", line)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 3 || line[0] != dollar {
+			return nil, fmt.Errorf("expected bulk string, got %q", line)
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// containsString returns whether s is one of ss.
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
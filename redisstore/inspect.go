@@ -0,0 +1,318 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/bucketstate"
+	"github.com/sethvargo/go-limiter/internal/phase"
+)
+
+var _ limiter.Inspector = (*store)(nil)
+var _ limiter.Peeker = (*store)(nil)
+
+// scanCount is the COUNT hint given to SCAN, which is roughly the number of
+// keys Redis examines per call.
+const scanCount = "100"
+
+// Keys returns a page of the keys that match the glob-style pattern, without
+// the KeyPrefix, using SCAN. The global key is never returned.
+func (s *store) Keys(ctx context.Context, pattern string, cursor uint64) ([]string, uint64, error) {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return nil, 0, limiter.ErrStopped
+	}
+
+	keys, next, err := s.scan(ctx, escapePattern(s.keyPrefix)+pattern, cursor)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, s.keyPrefix)
+	}
+	return keys, next, nil
+}
+
+// Stats summarizes all keys with the KeyPrefix. Remaining tokens are computed
+// against the server clock, as if each key were taken from now. Keys that are
+// not limiter buckets, or were written by a newer version, are skipped. It
+// walks every key with one command per key, so it is only intended for admin
+// tools.
+func (s *store) Stats(ctx context.Context, n int) (limiter.Stats, error) {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.Stats{}, limiter.ErrStopped
+	}
+
+	now, err := s.serverTime(ctx)
+	if err != nil {
+		return limiter.Stats{}, err
+	}
+
+	var stats limiter.Stats
+	var all []limiter.KeyStats
+
+	pattern := escapePattern(s.keyPrefix) + "*"
+	var cursor uint64
+	for {
+		keys, next, err := s.scan(ctx, pattern, cursor)
+		if err != nil {
+			return limiter.Stats{}, err
+		}
+
+		for _, key := range keys {
+			remaining, _, ok, err := s.remaining(ctx, key, now)
+			if errors.Is(err, bucketstate.ErrNewerVersion) {
+				continue
+			}
+			if err != nil {
+				return limiter.Stats{}, err
+			}
+			if !ok {
+				continue
+			}
+
+			stats.Keys++
+			all = append(all, limiter.KeyStats{
+				Key:       strings.TrimPrefix(key, s.keyPrefix),
+				Remaining: remaining,
+			})
+		}
+
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Remaining != all[j].Remaining {
+			return all[i].Remaining < all[j].Remaining
+		}
+		return all[i].Key < all[j].Key
+	})
+	if n < 0 {
+		n = 0
+	}
+	if n < len(all) {
+		all = all[:n]
+	}
+	stats.Top = all
+	return stats, nil
+}
+
+// Peek returns the tokens remaining for the key, computed like Stats, without
+// taking any. It returns false if the key does not exist or is not a limiter
+// bucket, in which case the next take starts with full tokens. The store must
+// have been created by this package.
+//
+// Deprecated: Use the Peek method of limiter.Peeker, which the store
+// implements, and which also works through decorators and reports the limit
+// and reset time.
+func Peek(ctx context.Context, ls limiter.Store, key string) (uint64, bool, error) {
+	s, ok := ls.(*store)
+	if !ok {
+		return 0, false, fmt.Errorf("store was not created by redisstore")
+	}
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return 0, false, limiter.ErrStopped
+	}
+	if s.cell {
+		return 0, false, fmt.Errorf("cannot peek buckets written by redis-cell: %w", limiter.ErrNotSupported)
+	}
+
+	now, err := s.serverTime(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	if key, err = s.checkKey(key); err != nil {
+		return 0, false, err
+	}
+	remaining, _, ok, err := s.remaining(ctx, s.keyPrefix+key, now)
+	return remaining, ok, err
+}
+
+// Peek implements limiter.Peeker. It is not supported with redis-cell.
+func (s *store) Peek(ctx context.Context, key string) (limiter.Result, error) {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.Result{}, limiter.ErrStopped
+	}
+	if s.cell {
+		return limiter.Result{}, fmt.Errorf("cannot peek buckets written by redis-cell: %w", limiter.ErrNotSupported)
+	}
+
+	now, err := s.serverTime(ctx)
+	if err != nil {
+		return limiter.Result{}, err
+	}
+	if key, err = s.checkKey(key); err != nil {
+		return limiter.Result{}, err
+	}
+	b, ok, err := s.readBucket(ctx, s.keyPrefix+key, now)
+	if err != nil {
+		return limiter.Result{}, err
+	}
+	if !ok {
+		start := now
+		if s.stagger {
+			start = float64(phase.Start(key, uint64(now), s.interval))
+		}
+		b = bucketView{remaining: s.tokens, next: start + float64(s.interval)}
+	}
+	remaining, next := b.remaining, b.next
+
+	res := limiter.Result{
+		Limit:     s.tokens,
+		Remaining: remaining,
+		ResetAt:   time.Unix(0, int64(next)),
+		Allowed:   remaining > 0,
+		Metadata:  b.metadata,
+	}
+	if !res.Allowed {
+		res.RetryAfter = time.Duration(next - now)
+	}
+	return res, nil
+}
+
+// scan runs a single SCAN with the given pattern and skips the global key.
+func (s *store) scan(ctx context.Context, pattern string, cursor uint64) ([]string, uint64, error) {
+	resp, err := s.conns.do(ctx, "SCAN", strconv.FormatUint(cursor, 10),
+		"MATCH", pattern, "COUNT", scanCount)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to scan keys: %w", err)
+	}
+
+	a := resp.array()
+	if len(a) != 2 {
+		return nil, 0, fmt.Errorf("invalid scan reply: expected 2 values, got %d", len(a))
+	}
+	next, err := strconv.ParseUint(a[0].s, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid scan cursor: %w", err)
+	}
+
+	keys := make([]string, 0, len(a[1].array()))
+	for _, r := range a[1].array() {
+		if r.s != s.globalKey {
+			keys = append(keys, r.s)
+		}
+	}
+	return keys, next, nil
+}
+
+// serverTime returns the server time in unix nanoseconds.
+func (s *store) serverTime(ctx context.Context) (float64, error) {
+	resp, err := s.conns.do(ctx, "TIME")
+	if err != nil {
+		return 0, fmt.Errorf("failed to get server time: %w", err)
+	}
+
+	a := resp.array()
+	if len(a) != 2 {
+		return 0, fmt.Errorf("invalid time reply: expected 2 values, got %d", len(a))
+	}
+	secs, err := strconv.ParseInt(a[0].s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time reply: %w", err)
+	}
+	micros, err := strconv.ParseInt(a[1].s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time reply: %w", err)
+	}
+	return float64(secs)*1e9 + float64(micros)*1e3, nil
+}
+
+// remaining returns the tokens remaining in the bucket at key at the given
+// server time, applying the same refill as the limiter script, and the time of
+// its next refill. It returns false if the key is not a bucket.
+func (s *store) remaining(ctx context.Context, key string, now float64) (uint64, float64, bool, error) {
+	b, ok, err := s.readBucket(ctx, key, now)
+	return b.remaining, b.next, ok, err
+}
+
+// bucketView is a bucket as of a server time.
+type bucketView struct {
+	remaining uint64
+	next      float64
+	metadata  string
+}
+
+// readBucket returns the bucket at key like remaining, with its metadata. A
+// bucket of a newer version is an error wrapping bucketstate.ErrNewerVersion.
+func (s *store) readBucket(ctx context.Context, key string, now float64) (bucketView, bool, error) {
+	resp, err := s.conns.do(ctx, "HMGET", key, "s", "t", "k", "d", "m", "v")
+	var rerr replyError
+	if errors.As(err, &rerr) {
+		// For example, WRONGTYPE for a key that is not a hash.
+		return bucketView{}, false, nil
+	}
+	if err != nil {
+		return bucketView{}, false, fmt.Errorf("failed to get key: %w", err)
+	}
+
+	a := resp.array()
+	if len(a) != 6 {
+		return bucketView{}, false, fmt.Errorf("invalid hmget reply: expected 6 values, got %d", len(a))
+	}
+	if a[5].typ == typeBulk {
+		if v, err := strconv.ParseFloat(a[5].s, 64); err == nil && v > bucketstate.Version {
+			return bucketView{}, false, fmt.Errorf("version %g: %w", v, bucketstate.ErrNewerVersion)
+		}
+	}
+
+	var fields [4]float64
+	for i, r := range a[:3] {
+		if r.typ != typeBulk {
+			return bucketView{}, false, nil
+		}
+		v, err := strconv.ParseFloat(r.s, 64)
+		if err != nil {
+			return bucketView{}, false, nil
+		}
+		fields[i] = v
+	}
+	if a[3].typ == typeBulk {
+		fields[3], _ = strconv.ParseFloat(a[3].s, 64)
+	}
+	start, lastTick, tokens, debt := fields[0], fields[1], fields[2], fields[3]
+
+	interval := float64(s.interval)
+	maxTokens := float64(s.tokens)
+	currTick := math.Floor((now - start) / interval)
+	if lastTick < currTick {
+		if debt > 0 {
+			tokens = math.Max((currTick-lastTick)*maxTokens-debt, 0)
+		} else {
+			tokens = (currTick - lastTick) * s.rate
+		}
+		tokens = math.Min(tokens, maxTokens)
+	}
+	b := bucketView{
+		remaining: uint64(tokens),
+		next:      start + (currTick+1)*interval,
+	}
+	if a[4].typ == typeBulk {
+		b.metadata = a[4].s
+	}
+	return b, true, nil
+}
+
+// escapePattern escapes the glob characters in s, so it only matches itself in
+// a SCAN pattern.
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
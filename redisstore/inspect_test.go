@@ -0,0 +1,107 @@
+package redisstore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStore_KeyPrefix(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:       5,
+		Interval:     time.Minute,
+		KeyPrefix:    "rl*:",
+		GlobalTokens: 100,
+		DialFunc:     f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "b"} {
+		if _, err := s.Take(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A key without the prefix, which the prefix pattern must not match even
+	// though the prefix contains a glob character.
+	f.lock.Lock()
+	f.data["rlx:c"] = map[string]string{"s": "0", "t": "0", "k": "0"}
+	_, prefixed := f.data["rl*:a"]
+	f.lock.Unlock()
+	if !prefixed {
+		t.Errorf("expected key to be stored with the prefix")
+	}
+
+	keys, next, err := s.(*store).Keys(ctx, "*", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := keys, []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys: expected %q to be %q", got, want)
+	}
+	if got, want := next, uint64(0); got != want {
+		t.Errorf("next: expected %d to be %d", got, want)
+	}
+
+	stats, err := s.(*store).Stats(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stats.Keys, uint64(2); got != want {
+		t.Errorf("stats keys: expected %d to be %d", got, want)
+	}
+	if got, want := stats.Top[0].Key, "b"; got != want {
+		t.Errorf("stats top: expected %q to be %q", got, want)
+	}
+	if got, want := stats.Top[0].Remaining, uint64(3); got != want {
+		t.Errorf("stats top: remaining: expected %d to be %d", got, want)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:    5,
+		Interval:  time.Minute,
+		KeyPrefix: "rl:",
+		DialFunc:  f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	if _, ok, err := Peek(ctx, s, "a"); err != nil || ok {
+		t.Errorf("expected missing key, got %t, %v", ok, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.Take(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		remaining, ok, err := Peek(ctx, s, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatal("expected key to exist")
+		}
+		if got, want := remaining, uint64(3); got != want {
+			t.Errorf("expected %d to be %d", got, want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package redisstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrKeyTooLong is the error returned for keys longer than MaxKeyLength when
+// HashLongKeys is not set.
+var ErrKeyTooLong = errors.New("redis: key is too long")
+
+// hashedKeyPrefix is prepended to hashed keys, so they cannot collide with
+// keys that happen to be 64 hex characters.
+const hashedKeyPrefix = "sha256:"
+
+// hashedKeyLength is the length of a hashed key.
+const hashedKeyLength = len(hashedKeyPrefix) + 2*sha256.Size
+
+// checkKey returns the key to send to Redis for the given key, hashing it if
+// it is too long and HashLongKeys is set.
+func (s *store) checkKey(key string) (string, error) {
+	if s.maxKeyLength == 0 || len(key) <= s.maxKeyLength {
+		return key, nil
+	}
+	if !s.hashLongKeys {
+		return "", fmt.Errorf("%w: %d bytes is more than %d", ErrKeyTooLong, len(key), s.maxKeyLength)
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return hashedKeyPrefix + hex.EncodeToString(sum[:]), nil
+}
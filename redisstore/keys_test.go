@@ -0,0 +1,111 @@
+package redisstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStore_MaxKeyLength(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("a", 101)
+
+	cases := []struct {
+		name    string
+		hash    bool
+		wantErr error
+		wantKey string
+	}{
+		{
+			name:    "reject",
+			wantErr: ErrKeyTooLong,
+		},
+		{
+			name: "hash",
+			hash: true,
+			wantKey: func() string {
+				sum := sha256.Sum256([]byte(long))
+				return "rl:sha256:" + hex.EncodeToString(sum[:])
+			}(),
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := newFakeRedis(t)
+			s, err := New(&Config{
+				Tokens:       2,
+				Interval:     time.Minute,
+				KeyPrefix:    "rl:",
+				MaxKeyLength: 100,
+				HashLongKeys: tc.hash,
+				DialFunc:     f.dial,
+				FailureMode:  FailOpen,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			ctx := context.Background()
+
+			// Keys up to the limit are sent as given.
+			if _, err := s.Take(ctx, long[:100]); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := s.Take(ctx, long)
+			if got, want := err, tc.wantErr; !errors.Is(got, want) {
+				t.Fatalf("expected %v to be %v", got, want)
+			}
+			if tc.wantErr != nil {
+				// The key is rejected even though the store fails open.
+				if res.Allowed {
+					t.Errorf("expected take to not be allowed")
+				}
+				if err := s.(*store).Refund(ctx, long, 1); !errors.Is(err, ErrKeyTooLong) {
+					t.Errorf("refund: expected %v to be %v", err, ErrKeyTooLong)
+				}
+				return
+			}
+
+			f.lock.Lock()
+			_, ok := f.data[tc.wantKey]
+			_, unhashed := f.data["rl:"+long]
+			f.lock.Unlock()
+			if !ok || unhashed {
+				t.Errorf("expected key to be stored as %q", tc.wantKey)
+			}
+
+			// Peeks hash the key the same way.
+			peek, err := s.(*store).Peek(ctx, long)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := peek.Remaining, res.Remaining; got != want {
+				t.Errorf("peek: expected %d to be %d", got, want)
+			}
+		})
+	}
+}
+
+func TestNew_MaxKeyLength(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	if _, err := New(&Config{MaxKeyLength: 70, HashLongKeys: true, DialFunc: f.dial}); err == nil {
+		t.Errorf("expected error for a limit shorter than a hashed key")
+	}
+	if _, err := New(&Config{MaxKeyLength: -1, DialFunc: f.dial}); err == nil {
+		t.Errorf("expected error for a negative limit")
+	}
+}
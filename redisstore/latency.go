@@ -0,0 +1,74 @@
+package redisstore
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// decision is the result of a take against Redis.
+type decision struct {
+	remaining  uint64
+	resetAfter time.Duration
+	ok         bool
+	err        error
+}
+
+// takeWithin makes the decision against Redis, but returns the fallback's
+// result if Redis does not answer within the latency budget. The call to Redis
+// continues in the background and is reconciled when it returns.
+func (s *store) takeWithin(ctx context.Context, key string) (decision, *limiter.Result, error) {
+	// The call must outlive the take if the budget is exceeded, so it keeps the
+	// context's values, like the priority, but not its cancellation.
+	bctx, cancel := context.WithTimeout(detachedContext{ctx}, s.interval)
+
+	done := make(chan decision, 1)
+	go func() {
+		defer cancel()
+		done <- s.decide(bctx, key)
+	}()
+
+	timer := time.NewTimer(s.latencyBudget)
+	defer timer.Stop()
+
+	select {
+	case d := <-done:
+		return d, nil, nil
+	case <-ctx.Done():
+		go s.reconcile(done, key, false)
+		return decision{err: ctx.Err()}, nil, nil
+	case <-timer.C:
+	}
+
+	atomic.AddUint64(&s.fallbacks, 1)
+	res, err := s.fallback.Take(ctx, key)
+	go s.reconcile(done, key, err == nil && res.Allowed)
+	return decision{}, &res, err
+}
+
+// reconcile waits for a decision that exceeded the latency budget. If Redis
+// granted a token that the caller was not allowed to use, the token is
+// refunded. A token Redis denied after the fallback allowed it cannot be taken
+// back, but it was still counted against the key.
+func (s *store) reconcile(done <-chan decision, key string, allowed bool) {
+	d := <-done
+	if d.err != nil || !d.ok || allowed {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+	defer cancel()
+	_ = s.Refund(ctx, key, 1)
+}
+
+// detachedContext carries the values of its parent, but is never canceled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
@@ -0,0 +1,99 @@
+package redisstore
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestStore_LatencyBudget(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+
+	var slow uint32 = 1
+	var sha atomic.Value
+	sha.Store("")
+	f.inject(func(args []string) *fakeFault {
+		// Only the limiter script is slow, so refunds reconcile quickly.
+		if atomic.LoadUint32(&slow) == 1 && args[0] == "EVALSHA" && args[1] == sha.Load().(string) {
+			return &fakeFault{delay: 200 * time.Millisecond}
+		}
+		return nil
+	})
+
+	// The fallback has fewer tokens than Redis, which shows the takes it
+	// decided.
+	fallback, err := memorystore.New(&memorystore.Config{
+		Tokens:   1,
+		Interval: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fallback.Close()
+
+	s, err := New(&Config{
+		Tokens:        5,
+		Interval:      time.Minute,
+		LatencyBudget: 20 * time.Millisecond,
+		Fallback:      fallback,
+		DialFunc:      f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	sha.Store(s.(*store).luaScriptSHA)
+
+	ctx := context.Background()
+	key := testKey(t)
+
+	for i, want := range []bool{true, false} {
+		start := time.Now()
+		res, err := s.Take(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Allowed; got != want {
+			t.Errorf("take %d: expected %t to be %t", i, got, want)
+		}
+		if d := time.Since(start); d > 150*time.Millisecond {
+			t.Errorf("take %d: expected to be decided within the budget, took %s", i, d)
+		}
+	}
+
+	if got, want := s.(*store).DebugVars()["fallbacks"], uint64(2); got != want {
+		t.Errorf("fallbacks: expected %v to be %v", got, want)
+	}
+
+	// Both takes were counted in Redis, but the second one was refunded since
+	// the fallback denied it.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		remaining, _, ok, err := s.(*store).remaining(ctx, key, f.now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok && remaining == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 4 tokens remaining after reconciling, got %d", remaining)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Once Redis is fast again, it decides.
+	atomic.StoreUint32(&slow, 0)
+	res, err := s.Take(ctx, key)
+	if !res.Allowed || err != nil {
+		t.Fatalf("expected take to succeed, got %t, %v", res.Allowed, err)
+	}
+	if got, want := res.Remaining, uint64(3); got != want {
+		t.Errorf("remaining: expected %d to be %d", got, want)
+	}
+}
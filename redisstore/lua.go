@@ -1,22 +1,78 @@
 package redisstore
 
-const luaTemplate = `
+import (
+	"crypto/sha1"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// luaHeader is shared by the limiter scripts. It reads the server clock and
+// the configuration, and defines helper functions. The configuration is passed
+// as the first luaParams arguments of each call, rather than rendered into the
+// script, so one script serves every configuration and a change of limits
+// does not need a new script on the server. The arguments of the call follow
+// it, and the scripts read them from args.
+const luaHeader = `
 local C_EXPIRE  = 'EXPIRE'
 local C_HGETALL = 'HGETALL'
 local C_HSET    = 'HSET'
+local C_TIME    = 'TIME'
 local F_START   = 's'
 local F_TICK    = 't'
 local F_TOKENS  = 'k'
+local F_DEBT    = 'd'
+local F_META    = 'm'
+local F_VERSION = 'v'
+local E_NEWER   = 'NEWERVERSION bucket was written by a newer version of the limiter'
+
+-- version is the newest bucket format the script understands. Buckets without
+-- a version are version 1.
+local version = %d
 
 -- speed up access to next
 local next = next
 
+-- TIME is non-deterministic, so older versions of Redis must replicate the
+-- effects of the script instead of the script itself.
+if redis.replicate_commands ~= nil then
+  redis.replicate_commands()
+end
+
+-- now is the current unix time in nanoseconds according to the server, so all
+-- clients agree on the clock regardless of skew between them.
+local servertime = redis.call(C_TIME)
+
 local key       = KEYS[1]
-local now       = tonumber(ARGV[1]) -- current unix time in nanoseconds
-local maxtokens = %d
-local interval  = %d
-local rate      = %f
-local ttl       = %d
+local now       = tonumber(servertime[1]) * 1e9 + tonumber(servertime[2]) * 1e3
+local maxtokens = tonumber(ARGV[1])
+local interval  = tonumber(ARGV[2])
+local rate      = tonumber(ARGV[3])
+local ttl       = tonumber(ARGV[4])
+
+-- the global bucket is only used when a second key is given.
+local globalkey      = KEYS[2]
+local globaltokens   = tonumber(ARGV[5])
+local globalinterval = tonumber(ARGV[6])
+local globalrate     = tonumber(ARGV[7])
+
+-- reservefraction is the fraction of each bucket reserved for high-priority
+-- takes.
+local reservefraction = tonumber(ARGV[8])
+
+-- debtlimit is the number of tokens a per-key bucket may borrow once empty.
+local debtlimit = tonumber(ARGV[9])
+
+-- fixedttl only sets the TTL when a key is first written, instead of on every
+-- save.
+local fixedttl = ARGV[10] == '1'
+
+-- args are the arguments of the call, after the configuration.
+local args = {}
+for i = 11, #ARGV do
+  args[i - 10] = ARGV[i]
+end
 
 -- hgetall gets all the fields as a lua table.
 local hgetall = function (key)
@@ -45,48 +101,317 @@ local tick = function (start, curr, interval)
   return math.floor((curr - start) / interval)
 end
 
+-- load returns the bucket stored at key, with any refill that is due applied.
+-- A bucket that does not exist yet starts out full. If phase is given, its
+-- intervals start at that offset from the epoch instead of now. A bucket of a
+-- newer version is only marked as newer, and must not be saved.
+local load = function (key, maxtokens, interval, rate, phase)
+  local data = hgetall(key)
+  if tonumber(data[F_VERSION] or 1) > version then
+    return {key = key, newer = true}
+  end
+  if next(data) == nil then
+    local start = now
+    if phase ~= nil then
+      start = now - ((now - phase) %% interval)
+    end
+    return {key = key, start = start, tick = 0, tokens = maxtokens, debt = 0,
+      nexttime = start + interval, exists = false, dirty = true}
+  end
+
+  local b = {key = key, start = tonumber(data[F_START]),
+    tick = tonumber(data[F_TICK]), tokens = tonumber(data[F_TOKENS]),
+    debt = tonumber(data[F_DEBT] or 0), exists = true, dirty = false}
+
+  local currtick = tick(b.start, now, interval)
+  b.nexttime = b.start + ((currtick+1) * interval)
+
+  if b.tick < currtick then
+    if b.debt > 0 then
+      -- debt is paid down first, by up to maxtokens per elapsed interval.
+      local budget = (currtick - b.tick) * maxtokens
+      if budget <= b.debt then
+        b.debt = b.debt - budget
+        b.tokens = 0
+      else
+        b.tokens = budget - b.debt
+        if b.tokens > maxtokens then
+          b.tokens = maxtokens
+        end
+        b.debt = 0
+      end
+    else
+      b.tokens = availabletokens(b.tick, currtick, maxtokens, rate)
+    end
+    b.tick = currtick
+    b.dirty = true
+  end
+  return b
+end
+
+-- save writes the bucket if it changed and resets the TTL, since we saw it.
+-- With a fixed TTL, the TTL is only set when the bucket is created. The
+-- metadata is only written if it was set.
+local save = function (b)
+  if b.dirty then
+    redis.call(C_HSET, b.key, F_START, b.start, F_TICK, b.tick, F_TOKENS, b.tokens, F_DEBT, b.debt)
+  end
+  if b.metadata ~= nil then
+    redis.call(C_HSET, b.key, F_META, b.metadata)
+  end
+  if not fixedttl or not b.exists then
+    redis.call(C_EXPIRE, b.key, ttl)
+  end
+end
+
+`
 
+// luaTemplate is the limiter script. It returns the remaining tokens, the
+// server time of the next refill in unix nanoseconds, whether the take was
+// successful, the number of nanoseconds until the next refill, measured
+// against the same server clock used to make the decision, and the number of
+// tokens granted.
+//
+// args[1] is the priority of the take. Low-priority takes fail if they would
+// leave fewer than the reserved tokens. High-priority takes on an empty
+// per-key bucket borrow against future refills, up to the debt limit.
+//
+// args[2] is the number of tokens wanted, which defaults to 1. As many as are
+// available are granted, and the take succeeds if any are.
+//
+// args[3], if given, is the offset in nanoseconds of the key's intervals from
+// the epoch, which is used if the key does not exist yet.
+//
+// If a global key is given, tokens must be available in both buckets and are
+// taken from both. The remaining tokens are the lower of the two. If the
+// per-key bucket is exhausted, the refill time is its own; otherwise it is the
+// global bucket's.
+//
+// If either bucket was written by a newer version of the format, the script
+// returns a NEWERVERSION error without writing anything.
+const luaTemplate = luaHeader + `
 --
 -- begin exec
 --
 
--- reset TTL, we saw the key
-redis.call(C_EXPIRE, key, ttl)
+-- low-priority takes leave the reserved tokens in each bucket.
+local reserve, globalreserve = 0, 0
+if args[1] == 'low' then
+  reserve = math.floor(maxtokens * reservefraction)
+  globalreserve = math.floor(globaltokens * reservefraction)
+end
+
+local b = load(key, maxtokens, interval, rate, tonumber(args[3]))
+local g = nil
+if globalkey ~= nil then
+  g = load(globalkey, globaltokens, globalinterval, globalrate)
+end
+if b.newer or (g ~= nil and g.newer) then
+  return redis.error_reply(E_NEWER)
+end
+
+-- args[2] is the number of tokens wanted. Fewer may be granted.
+local want = tonumber(args[2] or '1')
+
+-- take what is available above the reserve, then borrow the rest against
+-- future refills. Low-priority takes never borrow.
+local granted = math.min(want, math.max(math.ceil(b.tokens - reserve), 0))
+local borrowed = 0
+if args[1] ~= 'low' and granted < want and b.tokens - granted <= 0 then
+  borrowed = math.min(want - granted, math.max(debtlimit - b.debt, 0))
+end
+
+-- the global bucket caps the total; borrowed tokens are given up first.
+if g ~= nil then
+  local available = math.max(math.ceil(g.tokens - globalreserve), 0)
+  if granted + borrowed > available then
+    borrowed = math.max(available - granted, 0)
+    granted = math.min(granted, available)
+  end
+end
+local total = granted + borrowed
+
+if total > 0 then
+  b.tokens = b.tokens - granted
+  b.debt = b.debt + borrowed
+  b.dirty = true
+  save(b)
 
-local data = hgetall(key)
-local start, lasttick, tokens
-if next(data) == nil then
-  start    = now
-  lasttick = 0
-  tokens   = maxtokens-1
-  redis.call(C_HSET, key, F_START, start, F_TICK, lasttick, F_TOKENS, tokens)
-  redis.call(C_EXPIRE, key, ttl)
+  local remaining = b.tokens
+  if g ~= nil then
+    g.tokens = g.tokens - total
+    g.dirty = true
+    save(g)
 
-  local nexttime = start + interval
-  return {tokens, nexttime, true}
-else
-  start    = tonumber(data[F_START])
-  lasttick = tonumber(data[F_TICK])
-  tokens   = tonumber(data[F_TOKENS])
+    if g.tokens < remaining then
+      remaining = g.tokens
+    end
+  end
+
+  return {remaining, b.nexttime, true, b.nexttime - now, total}
+end
+
+-- the key could have taken, so the global bucket denied it.
+local keyok = b.tokens > reserve or (args[1] ~= 'low' and b.debt < debtlimit)
+
+save(b)
+local nexttime = b.nexttime
+if g ~= nil then
+  save(g)
+  if keyok then
+    nexttime = g.nexttime
+  end
+end
+
+return {0, nexttime, false, nexttime - now, 0}
+`
+
+// luaRefundTemplate is the refund script. It returns args[1] tokens to the key
+// and, if given, the global key, up to their maximums, paying down any debt
+// first. Any refill that is due is applied first, so tokens from an earlier
+// interval are not returned on top of it. Buckets that do not exist are left
+// alone.
+//
+// If args[2] is "charge", the script takes args[1] tokens instead, down to
+// zero and without touching any debt, creating buckets that do not exist. If
+// args[2] is "metadata", the script stores args[1] as the metadata of the key,
+// which is created if it does not exist, and leaves the global key alone.
+// args[3], if given, is the offset of a created key's intervals, like for the
+// limiter script. Like the limiter script, it writes nothing if either bucket
+// was written by a newer version of the format.
+const luaRefundTemplate = luaHeader + `
+--
+-- begin exec
+--
+
+if args[2] == 'metadata' then
+  local b = load(key, maxtokens, interval, rate, tonumber(args[3]))
+  if b.newer then
+    return redis.error_reply(E_NEWER)
+  end
+  b.metadata = args[1]
+  save(b)
+  return 0
 end
 
-local currtick = tick(start, now, interval)
-local nexttime = start + ((currtick+1) * interval)
+local refund = tonumber(args[1])
+local charge = args[2] == 'charge'
 
-if lasttick < currtick then
-  tokens = availabletokens(lasttick, currtick, maxtokens, rate)
-  lasttick = currtick
-  redis.call(C_HSET, key, F_TICK, lasttick, F_TOKENS, tokens)
-  redis.call(C_EXPIRE, key, ttl)
+-- both buckets are loaded before either is saved, so neither is changed if the
+-- other is newer.
+local b = load(key, maxtokens, interval, rate, tonumber(args[3]))
+local g = nil
+if globalkey ~= nil then
+  g = load(globalkey, globaltokens, globalinterval, globalrate)
+end
+if b.newer or (g ~= nil and g.newer) then
+  return redis.error_reply(E_NEWER)
 end
 
-if tokens > 0 then
-  tokens = tokens-1
-  redis.call(C_HSET, key, F_TOKENS, tokens)
-  redis.call(C_EXPIRE, key, ttl)
+local refundbucket = function (b, maxtokens)
+  if charge then
+    b.tokens = math.min(b.tokens, math.max(b.tokens - refund, 0))
+    b.dirty = true
+    save(b)
+    return
+  end
+  if not b.exists then
+    return
+  end
+
+  -- debt is paid down before tokens are returned.
+  local n = refund
+  if n <= b.debt then
+    b.debt = b.debt - n
+    n = 0
+  else
+    n = n - b.debt
+    b.debt = 0
+  end
 
-  return {tokens, nexttime, true}
+  b.tokens = b.tokens + n
+  if b.tokens > maxtokens then
+    b.tokens = maxtokens
+  end
+  b.dirty = true
+  save(b)
+end
+
+refundbucket(b, maxtokens)
+if g ~= nil then
+  refundbucket(g, globaltokens)
 end
 
-return {0, nexttime, false}
+return 0
 `
+
+// luaParams is the number of configuration arguments before the arguments of
+// each call to the scripts.
+const luaParams = 10
+
+// luaConfig is the configuration of the scripts.
+type luaConfig struct {
+	tokens         uint64
+	interval       time.Duration
+	rate           float64
+	ttl            uint64
+	globalTokens   uint64
+	globalInterval time.Duration
+	globalRate     float64
+
+	reservedFraction float64
+	debtLimit        uint64
+	fixedTTL         bool
+}
+
+// args returns the configuration as the first luaParams arguments of a call.
+func (c *luaConfig) args() []string {
+	fixedTTL := "0"
+	if c.fixedTTL {
+		fixedTTL = "1"
+	}
+
+	return []string{
+		strconv.FormatUint(c.tokens, 10),
+		strconv.FormatInt(int64(c.interval), 10),
+		strconv.FormatFloat(c.rate, 'f', -1, 64),
+		strconv.FormatUint(c.ttl, 10),
+		strconv.FormatUint(c.globalTokens, 10),
+		strconv.FormatInt(int64(c.globalInterval), 10),
+		strconv.FormatFloat(c.globalRate, 'f', -1, 64),
+		strconv.FormatFloat(c.reservedFraction, 'f', -1, 64),
+		strconv.FormatUint(c.debtLimit, 10),
+		fixedTTL,
+	}
+}
+
+// luaLibrary returns a Redis Function library that registers each script as a
+// function named by functionName of the library and the script's SHA. The
+// library is named by libraryName of the SHAs, so loading it again with REPLACE
+// only replaces it with the same code, and the libraries of other versions are
+// left alone for the stores that use them.
+func luaLibrary(scripts, shas []string) string {
+	lib := libraryName(shas)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#!lua name=%s\n", lib)
+	for i, script := range scripts {
+		fmt.Fprintf(&b, "\nlocal function f%d(KEYS, ARGV)\n%s\nend\n", i, script)
+		fmt.Fprintf(&b, "redis.register_function('%s', f%d)\n", functionName(lib, shas[i]), i)
+	}
+	return b.String()
+}
+
+// libraryName returns the name of the library of the scripts with the given
+// SHAs. It changes if any of the scripts do.
+func libraryName(shas []string) string {
+	return fmt.Sprintf("limiter_%x", sha1.Sum([]byte(strings.Join(shas, ","))))
+}
+
+// functionName returns the name of the function for the script with the given
+// SHA in the library. Function names are unique across the libraries of a
+// server, so they include the library's name, and a script that did not change
+// between versions is registered by the library of each.
+func functionName(lib, sha string) string {
+	return lib + "_" + sha
+}
@@ -0,0 +1,294 @@
+package redisstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxMuxPending is the maximum number of commands that can be awaiting a reply
+// on a multiplexed connection. Callers beyond the limit block until a reply is
+// received.
+const maxMuxPending = 1024
+
+var errMuxClosed = fmt.Errorf("multiplexed connection is closed")
+
+// mux sends all commands over a single, pipelined connection. If the
+// connection breaks, a new one is dialed on the next command.
+type mux struct {
+	// dials and dialFailures count connection attempts. They are first so they
+	// are 64-bit aligned for atomic access.
+	dials        uint64
+	dialFailures uint64
+
+	dialConfig *dialConfig
+
+	// sem guards conn. It is a channel rather than a mutex so that callers
+	// waiting for a dial can give up when their context is done.
+	sem  chan struct{}
+	conn *muxConn
+
+	// stopCh is used to signal the mux is stopped.
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// muxConn is a single pipelined connection. Commands are written in the order
+// their waiters are queued on pending, and the reader delivers each reply to
+// the next waiter.
+type muxConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	// writeLock serializes queueing a waiter and writing its command, so the
+	// order on the wire matches the order of pending.
+	writeLock sync.Mutex
+	pending   chan chan []byte
+
+	// doneCh is closed when the connection fails. err is the reason, and must
+	// only be read after doneCh is closed.
+	doneCh   chan struct{}
+	err      error
+	failOnce sync.Once
+}
+
+// newMux creates a new multiplexed connection. The connection is dialed
+// immediately so that configuration errors are returned early.
+func newMux(ctx context.Context, c *dialConfig) (*mux, error) {
+	m := &mux{
+		dialConfig: c,
+		sem:        make(chan struct{}, 1),
+		stopCh:     make(chan struct{}),
+	}
+
+	if _, err := m.get(ctx); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// do runs the command on the shared connection.
+func (m *mux) do(ctx context.Context, args ...string) (*response, error) {
+	invoke := func(args []string) ([]byte, error) {
+		return m.roundTrip(ctx, args)
+	}
+
+	var raw []byte
+	var err error
+	if interceptor := m.dialConfig.interceptor; interceptor != nil {
+		raw, err = interceptor(args, invoke)
+	} else {
+		raw, err = invoke(args)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseResponse(bufio.NewReader(bytes.NewReader(raw)))
+}
+
+// roundTrip sends the command and returns the raw reply.
+func (m *mux) roundTrip(ctx context.Context, args []string) ([]byte, error) {
+	mc, err := m.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mc.roundTrip(ctx, args)
+}
+
+// get returns the current connection, dialing a new one if there is none or
+// the previous one failed.
+func (m *mux) get(ctx context.Context) (*muxConn, error) {
+	select {
+	case m.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-m.stopCh:
+		return nil, errMuxClosed
+	}
+	defer func() { <-m.sem }()
+
+	select {
+	case <-m.stopCh:
+		return nil, errMuxClosed
+	default:
+	}
+
+	if m.conn != nil && !m.conn.failed() {
+		return m.conn, nil
+	}
+
+	// The handshake runs on the client before the reader starts, so it uses the
+	// regular request/response path.
+	atomic.AddUint64(&m.dials, 1)
+	client, err := m.dialConfig.dialClient(ctx, m.stopCh)
+	if err != nil {
+		atomic.AddUint64(&m.dialFailures, 1)
+		return nil, err
+	}
+
+	mc := &muxConn{
+		conn:    client.conn,
+		br:      client.br,
+		pending: make(chan chan []byte, maxMuxPending),
+		doneCh:  make(chan struct{}),
+	}
+	go mc.read()
+
+	m.conn = mc
+	return mc, nil
+}
+
+// debugVars returns the number of dials and, unless a dial is in progress, the
+// number of commands awaiting a reply.
+func (m *mux) debugVars() map[string]interface{} {
+	vars := map[string]interface{}{
+		"dials":         atomic.LoadUint64(&m.dials),
+		"dial_failures": atomic.LoadUint64(&m.dialFailures),
+	}
+
+	select {
+	case m.sem <- struct{}{}:
+		if m.conn != nil && !m.conn.failed() {
+			vars["pending"] = len(m.conn.pending)
+		}
+		<-m.sem
+	default:
+	}
+	return vars
+}
+
+// close stops the mux and closes the connection. Commands awaiting a reply
+// fail with errMuxClosed.
+func (m *mux) close() error {
+	m.stopOnce.Do(func() {
+		close(m.stopCh)
+	})
+
+	// Wait for any in-flight dial, which is canceled by stopCh, so that no new
+	// connection is left open.
+	m.sem <- struct{}{}
+	defer func() { <-m.sem }()
+
+	if m.conn != nil {
+		m.conn.fail(errMuxClosed)
+	}
+	return nil
+}
+
+// roundTrip queues a waiter, writes the command, and waits for its reply.
+func (mc *muxConn) roundTrip(ctx context.Context, args []string) ([]byte, error) {
+	reply := make(chan []byte, 1)
+
+	if err := mc.write(ctx, reply, buildRequest(args...)); err != nil {
+		return nil, err
+	}
+
+	select {
+	case raw := <-reply:
+		mc.checkReply(raw)
+		return raw, nil
+	case <-ctx.Done():
+		// The reply channel is buffered, so the reader drops the reply when it
+		// arrives.
+		return nil, ctx.Err()
+	case <-mc.doneCh:
+		// The reply may have been delivered just before the connection failed.
+		select {
+		case raw := <-reply:
+			return raw, nil
+		default:
+			return nil, mc.err
+		}
+	}
+}
+
+// checkReply fails the connection if the reply is an error that means the
+// connection is no longer usable, so the next command dials a new one.
+func (mc *muxConn) checkReply(raw []byte) {
+	if len(raw) < 3 || raw[0] != minus {
+		return
+	}
+
+	rerr := replyError(bytes.TrimRight(raw[1:], cr))
+	if !isConnUsable(rerr) {
+		mc.fail(rerr)
+	}
+}
+
+// write queues the waiter and writes the request. If the write fails, the
+// connection is failed since a partial request may have been sent.
+func (mc *muxConn) write(ctx context.Context, reply chan []byte, req []byte) error {
+	mc.writeLock.Lock()
+	defer mc.writeLock.Unlock()
+
+	if mc.failed() {
+		return mc.err
+	}
+
+	select {
+	case mc.pending <- reply:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-mc.doneCh:
+		return mc.err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := mc.conn.SetWriteDeadline(deadline); err != nil {
+			mc.fail(err)
+			return err
+		}
+		defer mc.conn.SetWriteDeadline(time.Time{})
+	}
+
+	if _, err := mc.conn.Write(req); err != nil {
+		mc.fail(err)
+		return err
+	}
+	return nil
+}
+
+// read delivers each reply to the waiter at the head of pending until the
+// connection fails.
+func (mc *muxConn) read() {
+	for {
+		var b bytes.Buffer
+		if err := readRawResponse(mc.br, &b); err != nil {
+			mc.fail(err)
+			return
+		}
+
+		select {
+		case reply := <-mc.pending:
+			reply <- b.Bytes()
+		default:
+			mc.fail(fmt.Errorf("received reply with no pending command"))
+			return
+		}
+	}
+}
+
+// fail marks the connection as failed with the given error and closes it. Only
+// the first error is kept.
+func (mc *muxConn) fail(err error) {
+	mc.failOnce.Do(func() {
+		mc.err = err
+		close(mc.doneCh)
+		mc.conn.Close()
+	})
+}
+
+// failed returns true if the connection has failed.
+func (mc *muxConn) failed() bool {
+	select {
+	case <-mc.doneCh:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,269 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/storetest"
+)
+
+func TestStore_Multiplex(t *testing.T) {
+	t.Parallel()
+
+	t.Run("conformance", func(t *testing.T) {
+		t.Parallel()
+
+		storetest.TestStore(t, func(tb testing.TB, c *storetest.Config) limiter.Store {
+			tb.Helper()
+
+			f := newFakeRedis(tb)
+
+			ttl := uint64(c.TTL.Seconds())
+			if ttl == 0 {
+				ttl = 1
+			}
+
+			s, err := New(&Config{
+				Tokens:    c.Tokens,
+				Interval:  c.Interval,
+				TTL:       ttl,
+				DialFunc:  f.dial,
+				Multiplex: true,
+			})
+			if err != nil {
+				tb.Fatal(err)
+			}
+			return s
+		})
+	})
+
+	t.Run("single_connection", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		s, err := New(&Config{
+			Tokens:    100,
+			Interval:  time.Minute,
+			DialFunc:  f.dial,
+			Multiplex: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		key := testKey(t)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if res, err := s.Take(context.Background(), key); !res.Allowed || err != nil {
+					t.Errorf("expected take to succeed, got %t, %v", res.Allowed, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if got, want := f.dialCount(), 1; got != want {
+			t.Errorf("dials: expected %d to be %d", got, want)
+		}
+
+		res, err := s.Take(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := res.Remaining, uint64(49); got != want {
+			t.Errorf("remaining: expected %d to be %d", got, want)
+		}
+	})
+
+	t.Run("redials_after_dropped_connection", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		s, err := New(&Config{
+			DialFunc:  f.dial,
+			Multiplex: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		var once sync.Once
+		f.inject(func(args []string) *fakeFault {
+			var ft *fakeFault
+			once.Do(func() {
+				ft = &fakeFault{drop: true}
+			})
+			return ft
+		})
+
+		ctx := context.Background()
+		if res, err := s.Take(ctx, testKey(t)); res.Allowed || err == nil {
+			t.Fatalf("expected first take to fail, got %t, %v", res.Allowed, err)
+		}
+		if res, err := s.Take(ctx, testKey(t)); !res.Allowed || err != nil {
+			t.Fatalf("expected second take to succeed, got %t, %v", res.Allowed, err)
+		}
+		if got, want := f.dialCount(), 2; got != want {
+			t.Errorf("dials: expected %d to be %d", got, want)
+		}
+	})
+
+	t.Run("keeps_connection_after_error_reply", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		s, err := New(&Config{
+			DialFunc:  f.dial,
+			Multiplex: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		var once sync.Once
+		f.inject(func(args []string) *fakeFault {
+			var ft *fakeFault
+			once.Do(func() {
+				ft = &fakeFault{reply: "-ERR oops\r\n"}
+			})
+			return ft
+		})
+
+		ctx := context.Background()
+		if _, err := s.Take(ctx, testKey(t)); err == nil {
+			t.Fatal("expected first take to fail")
+		}
+		if res, err := s.Take(ctx, testKey(t)); !res.Allowed || err != nil {
+			t.Fatalf("expected second take to succeed, got %t, %v", res.Allowed, err)
+		}
+		if got, want := f.dialCount(), 1; got != want {
+			t.Errorf("dials: expected %d to be %d", got, want)
+		}
+	})
+
+	t.Run("timeout_keeps_replies_in_order", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		s, err := New(&Config{
+			Tokens:    5,
+			Interval:  time.Minute,
+			DialFunc:  f.dial,
+			Multiplex: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		var once sync.Once
+		f.inject(func(args []string) *fakeFault {
+			var ft *fakeFault
+			once.Do(func() {
+				ft = &fakeFault{delay: 200 * time.Millisecond}
+			})
+			return ft
+		})
+
+		key := testKey(t)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if _, err := s.Take(ctx, key); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected %v to be %v", err, context.DeadlineExceeded)
+		}
+
+		// The abandoned reply must not be delivered to the next caller.
+		res, err := s.Take(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := res.Remaining, uint64(3); got != want {
+			t.Errorf("remaining: expected %d to be %d", got, want)
+		}
+		if got, want := f.dialCount(), 1; got != want {
+			t.Errorf("dials: expected %d to be %d", got, want)
+		}
+	})
+
+	t.Run("close_fails_pending", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		s, err := New(&Config{
+			DialFunc:  f.dial,
+			Multiplex: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f.inject(func(args []string) *fakeFault {
+			return &fakeFault{delay: time.Second}
+		})
+
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := s.Take(context.Background(), testKey(t))
+			errCh <- err
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case err := <-errCh:
+			if !errors.Is(err, errMuxClosed) {
+				t.Errorf("expected %v to be %v", err, errMuxClosed)
+			}
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("expected pending take to fail after close")
+		}
+	})
+
+	t.Run("interceptor", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+
+		var lock sync.Mutex
+		var cmds []string
+		s, err := New(&Config{
+			DialFunc:  f.dial,
+			Multiplex: true,
+			Interceptor: func(args []string, invoke Invoker) ([]byte, error) {
+				lock.Lock()
+				cmds = append(cmds, args[0])
+				lock.Unlock()
+				return invoke(args)
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		if res, err := s.Take(context.Background(), testKey(t)); !res.Allowed || err != nil {
+			t.Fatalf("expected take to succeed, got %t, %v", res.Allowed, err)
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		if got, want := strings.Join(cmds, ","), "PING,SCRIPT,SCRIPT,EVALSHA"; got != want {
+			t.Errorf("expected %q to be %q", got, want)
+		}
+	})
+}
@@ -1,13 +1,35 @@
 package redisstore
 
 import (
+	"context"
 	"fmt"
-	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// autosizeWait is the average wait for a client above which an autosized pool
+// grows.
+const autosizeWait = time.Millisecond
+
 // pool is a pooled block of clients.
 type pool struct {
+	// dials and dialFailures count connection attempts. They are first so they
+	// are 64-bit aligned for atomic access.
+	dials        uint64
+	dialFailures uint64
+
+	// waits and waitNanos count the gets that waited for a client since the
+	// last resize, and how long they waited. minIdle is the fewest idle clients
+	// a get left since then, and reserved is the number of slots of available
+	// that hold no client, which autosizing uses to shrink the pool below its
+	// max.
+	waits     uint64
+	waitNanos uint64
+	minIdle   uint64
+	reserved  uint64
+
 	// clients is a buffered channel of the available clients.
 	clients chan *client
 
@@ -15,26 +37,55 @@ type pool struct {
 	// clients.
 	available chan struct{}
 
+	// min is the smallest size of an autosized pool, and autosizing reports
+	// whether the pool is autosized.
+	min        uint64
+	autosizing bool
+
 	// clientFunc is the function that connects to Redis and configures a new
 	// client.
-	clientFunc func() (*client, error)
+	clientFunc func(ctx context.Context) (*client, error)
 
 	// stopCh is used to signal the pool is stopped.
 	stopCh chan struct{}
+
+	// closeLock guards closed. Clients are only returned to the pool while
+	// holding the lock, so close can drain them without racing.
+	closeLock sync.Mutex
+	closed    bool
 }
 
 type poolConfig struct {
 	initial, max uint64
-	dialFunc     func() (net.Conn, error)
 
-	// username and password are for auth.
-	username string
-	password string
+	// autosize, if set, resizes the pool every interval between initial and
+	// max.
+	autosize         bool
+	autosizeInterval time.Duration
+
+	dialConfig
 }
 
 var errPoolClosed = fmt.Errorf("pool is closed")
 
-func newPool(c *poolConfig) (*pool, error) {
+// autosizeMaxPoolSize returns the default max size of an autosized pool: a
+// quarter of the open files the process may have, up to 1024, or 100 if the
+// platform does not limit them, and at least the initial size.
+func autosizeMaxPoolSize(initial uint64) uint64 {
+	max := uint64(100)
+	if files, ok := maxOpenFiles(); ok {
+		max = files / 4
+		if max > 1024 {
+			max = 1024
+		}
+	}
+	if max < initial {
+		max = initial
+	}
+	return max
+}
+
+func newPool(ctx context.Context, c *poolConfig) (*pool, error) {
 	if c.initial > c.max {
 		return nil, fmt.Errorf("initial cannot be greater than max")
 	}
@@ -44,26 +95,22 @@ func newPool(c *poolConfig) (*pool, error) {
 		available: make(chan struct{}, c.max),
 		stopCh:    make(chan struct{}),
 
-		clientFunc: func() func() (*client, error) {
-			dialFunc := c.dialFunc
-			return func() (*client, error) {
-				conn, err := dialFunc()
-				if err != nil {
-					return nil, err
-				}
-
-				client, err := newClient(conn, c.username, c.password)
-				if err != nil {
-					return nil, err
-				}
-				return client, nil
-			}
-		}(),
+		min:        c.initial,
+		autosizing: c.autosize,
+	}
+
+	p.clientFunc = func(ctx context.Context) (*client, error) {
+		atomic.AddUint64(&p.dials, 1)
+		client, err := c.dialClient(ctx, p.stopCh)
+		if err != nil {
+			atomic.AddUint64(&p.dialFailures, 1)
+		}
+		return client, err
 	}
 
 	// Create initial connections.
 	for i := uint64(0); i < c.initial; i++ {
-		client, err := p.clientFunc()
+		client, err := p.clientFunc(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -72,10 +119,63 @@ func newPool(c *poolConfig) (*pool, error) {
 		p.available <- struct{}{}
 	}
 
+	// An autosized pool starts at its initial size, with the rest of the
+	// slots reserved.
+	if c.autosize {
+		for i := c.initial; i < c.max; i++ {
+			p.available <- struct{}{}
+			p.reserved++
+		}
+
+		interval := time.Second
+		if c.autosizeInterval > 0 {
+			interval = c.autosizeInterval
+		}
+		go p.autosize(interval)
+	}
+
 	return p, nil
 }
 
-func (p *pool) get() (*client, error) {
+// do runs the command on a client from the pool. The client is returned to
+// the pool afterwards, or discarded if the connection is no longer usable.
+func (p *pool) do(ctx context.Context, args ...string) (_ *response, retErr error) {
+	c, err := p.get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client: %w", err)
+	}
+	defer func() {
+		if err := c.release(p, retErr); err != nil && retErr == nil {
+			retErr = fmt.Errorf("failed to release client: %w", err)
+		}
+	}()
+
+	return c.doContext(ctx, args...)
+}
+
+// debugVars returns the number of open and idle connections, the size of the
+// pool, and the number of dials.
+func (p *pool) debugVars() map[string]interface{} {
+	reserved := atomic.LoadUint64(&p.reserved)
+	return map[string]interface{}{
+		"pool_open":     len(p.available) - int(reserved),
+		"pool_idle":     len(p.clients),
+		"pool_size":     cap(p.available) - int(reserved),
+		"pool_max":      cap(p.available),
+		"dials":         atomic.LoadUint64(&p.dials),
+		"dial_failures": atomic.LoadUint64(&p.dialFailures),
+	}
+}
+
+func (p *pool) get(ctx context.Context) (*client, error) {
+	client, err := p.getClient(ctx)
+	if err == nil && p.autosizing {
+		p.observeIdle()
+	}
+	return client, err
+}
+
+func (p *pool) getClient(ctx context.Context) (*client, error) {
 	select {
 	case <-p.stopCh:
 		return nil, errPoolClosed
@@ -87,32 +187,136 @@ func (p *pool) get() (*client, error) {
 	default:
 	}
 
+	// If no slot is free either, the pool is at its size, and the wait for a
+	// client counts towards growing it.
+	select {
+	case p.available <- struct{}{}:
+		return p.dial(ctx)
+	default:
+	}
+	start := time.Now()
+
 	for {
 		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		case <-p.stopCh:
 			return nil, errPoolClosed
 		case client, ok := <-p.clients:
 			if !ok {
 				return nil, errPoolClosed
 			}
+			p.observeWait(start)
 			return client, nil
 		case p.available <- struct{}{}:
-			client, err := p.clientFunc()
-			if err != nil {
-				<-p.available
-				return nil, err
-			}
+			p.observeWait(start)
+			return p.dial(ctx)
+		}
+	}
+}
+
+// dial dials a client for a slot the caller reserved, so the new client is
+// theirs. It joins the idle clients when it is released.
+func (p *pool) dial(ctx context.Context) (*client, error) {
+	client, err := p.clientFunc(ctx)
+	if err != nil {
+		<-p.available
+		return nil, err
+	}
+	return client, nil
+}
+
+// observeWait records a get that waited for a client since start.
+func (p *pool) observeWait(start time.Time) {
+	if !p.autosizing {
+		return
+	}
+	atomic.AddUint64(&p.waits, 1)
+	atomic.AddUint64(&p.waitNanos, uint64(time.Since(start)))
+}
+
+// observeIdle records the number of idle clients a get left.
+func (p *pool) observeIdle() {
+	idle := uint64(len(p.clients))
+	for {
+		min := atomic.LoadUint64(&p.minIdle)
+		if idle >= min || atomic.CompareAndSwapUint64(&p.minIdle, min, idle) {
+			return
+		}
+	}
+}
+
+// autosize resizes the pool every interval until it is closed.
+func (p *pool) autosize(interval time.Duration) {
+	atomic.StoreUint64(&p.minIdle, uint64(len(p.clients)))
 
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+		}
+		p.resize()
+	}
+}
+
+// resize doubles the size of the pool, up to its max, if gets waited for a
+// client for autosizeWait on average since the last resize. Otherwise, it
+// shrinks the pool by half of its spare slots, down to its min: the slots
+// that were never dialed, and the clients that stayed idle.
+func (p *pool) resize() {
+	waits := atomic.SwapUint64(&p.waits, 0)
+	waitNanos := atomic.SwapUint64(&p.waitNanos, 0)
+	minIdle := atomic.SwapUint64(&p.minIdle, uint64(len(p.clients)))
+
+	reserved := atomic.LoadUint64(&p.reserved)
+	size := uint64(cap(p.available)) - reserved
+
+	if waits > 0 && time.Duration(waitNanos/waits) >= autosizeWait {
+		grow := size
+		if grow > reserved {
+			grow = reserved
+		}
+		for i := uint64(0); i < grow; i++ {
 			select {
-			case p.clients <- client:
-				return client, nil
+			case <-p.available:
+				atomic.AddUint64(&p.reserved, ^uint64(0))
 			default:
-				<-p.available
-				if err := client.conn.Close(); err != nil {
-					return nil, err
-				}
+				return
 			}
 		}
+		return
+	}
+
+	var undialed uint64
+	if open := uint64(len(p.available)) - reserved; open < size {
+		undialed = size - open
+	}
+	shrink := (undialed + minIdle + 1) / 2
+	if shrink > size-p.min {
+		shrink = size - p.min
+	}
+
+	for ; shrink > 0 && undialed > 0; shrink, undialed = shrink-1, undialed-1 {
+		select {
+		case p.available <- struct{}{}:
+			atomic.AddUint64(&p.reserved, 1)
+		default:
+			return
+		}
+	}
+	for ; shrink > 0; shrink-- {
+		select {
+		case client := <-p.clients:
+			// The client's slot is kept, as a reserved one.
+			atomic.AddUint64(&p.reserved, 1)
+			client.conn.Close()
+		default:
+			return
+		}
 	}
 }
 
@@ -121,21 +325,17 @@ func (p *pool) put(client *client) error {
 		return nil
 	}
 
-	select {
-	case <-p.stopCh:
+	p.closeLock.Lock()
+	defer p.closeLock.Unlock()
+
+	if p.closed {
 		if err := client.conn.Close(); err != nil {
 			return err
 		}
 		return errPoolClosed
-	default:
 	}
 
 	select {
-	case <-p.stopCh:
-		if err := client.conn.Close(); err != nil {
-			return err
-		}
-		return errPoolClosed
 	case p.clients <- client:
 		return nil
 	default:
@@ -148,13 +348,42 @@ func (p *pool) put(client *client) error {
 	}
 }
 
+// discard closes the client's connection and frees its slot in the pool, so a
+// new connection can be dialed in its place. It should be used instead of put
+// when the connection is in an unknown state.
+func (p *pool) discard(client *client) error {
+	if client == nil {
+		return nil
+	}
+
+	select {
+	case <-p.available:
+	default:
+	}
+	return client.conn.Close()
+}
+
 func (p *pool) close() error {
+	p.closeLock.Lock()
+	defer p.closeLock.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
 	close(p.stopCh)
-	close(p.clients)
-	close(p.available)
 
 	var errs []error
-	for client := range p.clients {
+	for {
+		var client *client
+		select {
+		case client = <-p.clients:
+		default:
+		}
+		if client == nil {
+			break
+		}
+
 		if err := client.conn.Close(); err != nil {
 			errs = append(errs, err)
 		}
@@ -0,0 +1,159 @@
+package redisstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPool_Autosize(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	ctx := context.Background()
+
+	// The interval is long, so the pool is only resized when the test calls
+	// resize.
+	p, err := newPool(ctx, &poolConfig{
+		initial:          1,
+		max:              8,
+		autosize:         true,
+		autosizeInterval: time.Hour,
+		dialConfig:       dialConfig{dialFunc: f.dial},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.close()
+
+	assertSize := func(tb testing.TB, size, open int) {
+		tb.Helper()
+
+		vars := p.debugVars()
+		if got, want := vars["pool_size"], size; got != want {
+			tb.Errorf("pool_size: expected %v to be %v", got, want)
+		}
+		if got, want := vars["pool_open"], open; got != want {
+			tb.Errorf("pool_open: expected %v to be %v", got, want)
+		}
+	}
+
+	get := func(tb testing.TB) *client {
+		tb.Helper()
+
+		c, err := p.get(ctx)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return c
+	}
+
+	// pressure gets the size of the pool, then one more client, which waits
+	// until one is put back, and puts them all back.
+	pressure := func(tb testing.TB, size int) {
+		tb.Helper()
+
+		clients := make([]*client, size)
+		for i := range clients {
+			clients[i] = get(tb)
+		}
+
+		waited := make(chan *client)
+		go func() {
+			c, err := p.get(ctx)
+			if err != nil {
+				tb.Error(err)
+			}
+			waited <- c
+		}()
+
+		time.Sleep(5 * time.Millisecond)
+		if err := p.put(clients[0]); err != nil {
+			tb.Fatal(err)
+		}
+		clients[0] = <-waited
+
+		for _, c := range clients {
+			if err := p.put(c); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+
+	assertSize(t, 1, 1)
+
+	// Gets that wait double the size of the pool.
+	pressure(t, 1)
+	p.resize()
+	assertSize(t, 2, 1)
+
+	pressure(t, 2)
+	p.resize()
+	assertSize(t, 4, 2)
+
+	// Without waits, the pool shrinks by half of its spare slots: first the
+	// ones never dialed, then the clients that stayed idle since the last
+	// resize.
+	p.resize()
+	assertSize(t, 2, 2)
+
+	p.resize()
+	assertSize(t, 1, 1)
+
+	// It does not shrink below its initial size.
+	p.resize()
+	assertSize(t, 1, 1)
+
+	// The remaining client still works.
+	c := get(t)
+	if _, err := c.doContext(ctx, "PING"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.put(c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAutosizeMaxPoolSize(t *testing.T) {
+	t.Parallel()
+
+	max := autosizeMaxPoolSize(5)
+	if max < 5 || max > 1024 {
+		t.Errorf("expected %d to be in [5, 1024]", max)
+	}
+	if files, ok := maxOpenFiles(); ok && files >= 20 && max > files/4 {
+		t.Errorf("expected %d to be at most a quarter of %d", max, files)
+	}
+
+	if got, want := autosizeMaxPoolSize(4096), uint64(4096); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+func TestStore_AutosizePool(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:       5,
+		Interval:     time.Minute,
+		AutosizePool: true,
+		DialFunc:     f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.Take(context.Background(), testKey(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	vars := s.(*store).DebugVars()
+	if got, want := vars["pool_size"], 5; got != want {
+		t.Errorf("pool_size: expected %v to be %v", got, want)
+	}
+	if got, want := vars["pool_max"], int(autosizeMaxPoolSize(5)); got != want {
+		t.Errorf("pool_max: expected %v to be %v", got, want)
+	}
+}
@@ -0,0 +1,85 @@
+package redisstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+var _ limiter.Deleter = (*store)(nil)
+
+// Delete deletes the named key with UNLINK, so its next take starts with a
+// full bucket, whatever debt it had, and clears it from the deny cache. The
+// global key is not deleted, and tokens LocalBatch already handed out are not
+// returned. It works with redis-cell too. Like Refund, errors are returned
+// regardless of the configured FailureMode.
+func (s *store) Delete(ctx context.Context, key string) error {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.ErrStopped
+	}
+
+	key, err := s.checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.conns.do(ctx, "UNLINK", s.keyPrefix+key); err != nil {
+		return fmt.Errorf("failed to delete key: %w", err)
+	}
+	if s.denyCache != nil {
+		s.denyCache.Remove(key)
+	}
+	return nil
+}
+
+// ResetPattern deletes the keys that match the glob-style pattern, without the
+// KeyPrefix, so their next takes start with full buckets, like for unblocking
+// all of a customer's sub-keys after an incident. It walks the keyspace with
+// SCAN and deletes each page with UNLINK, which frees the memory in the
+// background, so it does not block Redis. The global key is never deleted,
+// and tokens LocalBatch already handed out are not returned. It returns the
+// number of keys deleted, including on error. The store must have been
+// created by this package, with a KeyPrefix: without one, a pattern like "*"
+// would delete the keys of other applications in the database too.
+func ResetPattern(ctx context.Context, ls limiter.Store, pattern string) (uint64, error) {
+	s, ok := ls.(*store)
+	if !ok {
+		return 0, fmt.Errorf("store was not created by redisstore")
+	}
+	if s.keyPrefix == "" {
+		return 0, fmt.Errorf("cannot reset keys without a KeyPrefix")
+	}
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return 0, limiter.ErrStopped
+	}
+
+	var deleted, cursor uint64
+	for {
+		keys, next, err := s.scan(ctx, escapePattern(s.keyPrefix)+pattern, cursor)
+		if err != nil {
+			return deleted, err
+		}
+
+		if len(keys) > 0 {
+			resp, err := s.conns.do(ctx, append([]string{"UNLINK"}, keys...)...)
+			if err != nil {
+				return deleted, fmt.Errorf("failed to delete keys: %w", err)
+			}
+			deleted += resp.uint64()
+
+			if s.denyCache != nil {
+				for _, key := range keys {
+					s.denyCache.Remove(strings.TrimPrefix(key, s.keyPrefix))
+				}
+			}
+		}
+
+		if next == 0 {
+			return deleted, nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,142 @@
+package redisstore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+func TestResetPattern(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:       1,
+		Interval:     time.Hour,
+		KeyPrefix:    "rl:",
+		GlobalTokens: 1000,
+		DenyCache:    true,
+		DialFunc:     f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// More keys than a single SCAN page, so the cursor is followed.
+	ctx := context.Background()
+	var keys []string
+	for i := 0; i < 250; i++ {
+		keys = append(keys, fmt.Sprintf("customer1:%d", i))
+	}
+	keys = append(keys, "customer2:0")
+	for _, key := range keys {
+		for i := 0; i < 2; i++ {
+			if _, err := s.Take(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	deleted, err := ResetPattern(ctx, s, "customer1:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := deleted, uint64(250); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	// The deleted keys are no longer denied, even from the deny cache.
+	for _, key := range []string{"customer1:0", "customer1:249"} {
+		if res, err := s.Take(ctx, key); !res.Allowed || err != nil {
+			t.Errorf("%s: expected take to be allowed, got %t, %v", key, res.Allowed, err)
+		}
+	}
+	if res, err := s.Take(ctx, "customer2:0"); res.Allowed || err != nil {
+		t.Errorf("expected other key to stay denied, got %t, %v", res.Allowed, err)
+	}
+
+	remaining, _, err := s.(*store).Keys(ctx, "customer2:*", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := remaining, []string{"customer2:0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
+
+func TestResetPattern_keyPrefix(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:   1,
+		Interval: time.Hour,
+		DialFunc: f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Without a prefix, the keys of other applications could match.
+	f.lock.Lock()
+	f.data["session:1"] = map[string]string{"user": "alice"}
+	f.lock.Unlock()
+
+	if _, err := ResetPattern(context.Background(), s, "*"); err == nil {
+		t.Fatal("expected error without a key prefix")
+	}
+
+	f.lock.Lock()
+	_, ok := f.data["session:1"]
+	f.lock.Unlock()
+	if !ok {
+		t.Error("expected other keys to be kept")
+	}
+}
+
+func TestStore_Delete(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:       1,
+		Interval:     time.Hour,
+		KeyPrefix:    "rl:",
+		GlobalTokens: 1000,
+		DenyCache:    true,
+		DialFunc:     f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := s.Take(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.(limiter.Deleter).Delete(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key is allowed again, even from the deny cache, and the global
+	// bucket is kept.
+	if res, err := s.Take(ctx, "key"); !res.Allowed || err != nil {
+		t.Errorf("expected take to be allowed, got %t, %v", res.Allowed, err)
+	}
+	f.lock.Lock()
+	_, ok := f.data["limiter:global"]
+	f.lock.Unlock()
+	if !ok {
+		t.Error("expected the global key to be kept")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package redisstore
+
+// maxOpenFiles returns false, since the platform has no limit of open files
+// like RLIMIT_NOFILE.
+func maxOpenFiles() (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,13 @@
+// Package scripttest runs the Lua scripts of redisstore on the Lua interpreter
+// of miniredis, so changes to the scripts are tested without a Redis server.
+//
+// The tests of redisstore run against a fake server that implements the
+// scripts in Go, and the real server only in the Redis job of CI. This module
+// has its own go.mod, so the dependency on miniredis does not reach users of
+// the limiter:
+//
+//	cd redisstore/scripttest && go test ./...
+//
+// miniredis does not implement Redis Functions or redis-cell, so those are
+// only tested against a real server.
+package scripttest
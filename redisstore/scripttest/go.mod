@@ -0,0 +1,12 @@
+module github.com/sethvargo/go-limiter/redisstore/scripttest
+
+go 1.17
+
+require (
+	github.com/alicebob/miniredis/v2 v2.39.0
+	github.com/sethvargo/go-limiter v0.1.0
+)
+
+require github.com/yuin/gopher-lua v1.1.1 // indirect
+
+replace github.com/sethvargo/go-limiter => ../../
@@ -0,0 +1,412 @@
+package scripttest
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/bucketstate"
+	"github.com/sethvargo/go-limiter/memorystore"
+	"github.com/sethvargo/go-limiter/redisstore"
+	"github.com/sethvargo/go-limiter/storetest"
+)
+
+// newStore creates a store with the configuration on a new miniredis server.
+// The store is closed, and the server stopped, when the test finishes.
+func newStore(tb testing.TB, c *redisstore.Config) (limiter.Store, *miniredis.Miniredis) {
+	tb.Helper()
+
+	m := miniredis.RunT(tb)
+	return newStoreOn(tb, m, c), m
+}
+
+// newStoreOn creates a store with the configuration on the server m.
+func newStoreOn(tb testing.TB, m *miniredis.Miniredis, c *redisstore.Config) limiter.Store {
+	tb.Helper()
+
+	c.DialFunc = redisstore.TCPDialFunc(m.Addr())
+	s, err := redisstore.New(c)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.TestStore(t, func(tb testing.TB, c *storetest.Config) limiter.Store {
+		tb.Helper()
+
+		ttl := uint64(c.TTL.Seconds())
+		if ttl == 0 {
+			ttl = 1
+		}
+
+		s, _ := newStore(tb, &redisstore.Config{
+			Tokens:   c.Tokens,
+			Interval: c.Interval,
+			TTL:      ttl,
+		})
+		return s
+	})
+}
+
+func TestSharedScripts(t *testing.T) {
+	t.Parallel()
+
+	m := miniredis.RunT(t)
+	ctx := context.Background()
+	key := storetest.Key(t)
+
+	// Stores of different configurations share the scripts and pass their own
+	// configuration with each call, so each applies its own limit to the
+	// tokens left in the bucket.
+	for i, tc := range []struct {
+		tokens    uint64
+		allowed   bool
+		remaining uint64
+	}{
+		{tokens: 2, allowed: true, remaining: 1},
+		{tokens: 5, allowed: true, remaining: 0},
+		{tokens: 2, allowed: false, remaining: 0},
+	} {
+		s := newStoreOn(t, m, &redisstore.Config{
+			Tokens:   tc.tokens,
+			Interval: time.Hour,
+		})
+
+		res, err := s.Take(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := res.Allowed, tc.allowed; got != want {
+			t.Errorf("take %d: allowed: expected %t to be %t", i, got, want)
+		}
+		if got, want := res.Limit, tc.tokens; got != want {
+			t.Errorf("take %d: limit: expected %d to be %d", i, got, want)
+		}
+		if got, want := res.Remaining, tc.remaining; got != want {
+			t.Errorf("take %d: remaining: expected %d to be %d", i, got, want)
+		}
+	}
+}
+
+func TestGlobal(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newStore(t, &redisstore.Config{
+		Tokens:       3,
+		Interval:     time.Minute,
+		GlobalTokens: 4,
+	})
+
+	ctx := context.Background()
+	key1, key2 := storetest.Key(t), storetest.Key(t)
+
+	cases := []struct {
+		key       string
+		allowed   bool
+		remaining uint64
+	}{
+		{key: key1, allowed: true, remaining: 2},
+		{key: key1, allowed: true, remaining: 1},
+		{key: key1, allowed: true, remaining: 0},
+		{key: key2, allowed: true, remaining: 0},
+		{key: key2, allowed: false, remaining: 0},
+		{key: key1, allowed: false, remaining: 0},
+	}
+
+	for i, tc := range cases {
+		res, err := s.Take(ctx, tc.key)
+		if err != nil {
+			t.Fatalf("take %d: %v", i, err)
+		}
+		if got, want := res.Allowed, tc.allowed; got != want {
+			t.Errorf("take %d: allowed: expected %t to be %t", i, got, want)
+		}
+		if got, want := res.Remaining, tc.remaining; got != want {
+			t.Errorf("take %d: remaining: expected %d to be %d", i, got, want)
+		}
+	}
+
+	// Rejecting the take on the global bucket must not spend the key's token,
+	// and refunds return tokens to both buckets.
+	if err := s.(limiter.Refunder).Refund(ctx, key1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := s.Take(ctx, key2); !res.Allowed || err != nil {
+		t.Errorf("expected take after global refund to succeed, got %t, %v", res.Allowed, err)
+	}
+	if res, err := s.Take(ctx, key2); res.Allowed || err != nil {
+		t.Errorf("expected take to be denied by global bucket, got %t, %v", res.Allowed, err)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newStore(t, &redisstore.Config{
+		Tokens:           10,
+		Interval:         time.Minute,
+		ReservedFraction: 0.3,
+	})
+
+	ctx := context.Background()
+	low := limiter.WithPriority(ctx, limiter.PriorityLow)
+	key := storetest.Key(t)
+
+	for i := 0; i < 7; i++ {
+		if res, err := s.Take(low, key); !res.Allowed || err != nil {
+			t.Fatalf("low take %d: expected to succeed, got %t, %v", i, res.Allowed, err)
+		}
+	}
+	if res, err := s.Take(low, key); res.Allowed || err != nil {
+		t.Fatalf("expected low take to be rejected at the reserve, got %t, %v", res.Allowed, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if res, err := s.Take(ctx, key); !res.Allowed || err != nil {
+			t.Fatalf("high take %d: expected to succeed, got %t, %v", i, res.Allowed, err)
+		}
+	}
+	if res, err := s.Take(ctx, key); res.Allowed || err != nil {
+		t.Fatalf("expected high take to be rejected when empty, got %t, %v", res.Allowed, err)
+	}
+}
+
+func TestDebt(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newStore(t, &redisstore.Config{
+		Tokens:    2,
+		Interval:  time.Minute,
+		DebtLimit: 2,
+	})
+
+	ctx := context.Background()
+	key := storetest.Key(t)
+
+	for i := 0; i < 4; i++ {
+		if res, err := s.Take(ctx, key); !res.Allowed || err != nil {
+			t.Fatalf("take %d: expected to succeed, got %t, %v", i, res.Allowed, err)
+		}
+	}
+	if res, err := s.Take(ctx, key); res.Allowed || err != nil {
+		t.Fatalf("expected take to be rejected at the debt limit, got %t, %v", res.Allowed, err)
+	}
+
+	// Refunds pay down the debt before returning tokens.
+	if err := s.(limiter.Refunder).Refund(ctx, key, 1); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := s.Take(ctx, key); !res.Allowed || err != nil {
+		t.Fatalf("expected take to borrow after refund, got %t, %v", res.Allowed, err)
+	}
+	if res, err := s.Take(ctx, key); res.Allowed || err != nil {
+		t.Fatalf("expected take to be rejected at the debt limit, got %t, %v", res.Allowed, err)
+	}
+
+	// Charges take tokens down to zero without borrowing, so takes can still
+	// borrow after them.
+	chargeKey := storetest.Key(t)
+	if err := s.(limiter.Charger).Charge(ctx, chargeKey, 4); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		res, err := s.Take(ctx, chargeKey)
+		if !res.Allowed || err != nil {
+			t.Fatalf("take %d after charge: expected to borrow, got %t, %v", i, res.Allowed, err)
+		}
+		if got, want := res.Remaining, uint64(0); got != want {
+			t.Errorf("take %d after charge: remaining: expected %d to be %d", i, got, want)
+		}
+	}
+	if res, err := s.Take(ctx, chargeKey); res.Allowed || err != nil {
+		t.Fatalf("expected take to be rejected at the debt limit, got %t, %v", res.Allowed, err)
+	}
+}
+
+func TestTTLMode(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		mode      limiter.TTLMode
+		refreshed bool
+	}{
+		{
+			name:      "sliding",
+			mode:      limiter.TTLSliding,
+			refreshed: true,
+		},
+		{
+			name:      "fixed",
+			mode:      limiter.TTLFixed,
+			refreshed: false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, m := newStore(t, &redisstore.Config{
+				Tokens:    10,
+				Interval:  time.Minute,
+				TTL:       60,
+				KeyPrefix: "rl:",
+				TTLMode:   tc.mode,
+			})
+
+			ctx := context.Background()
+			if _, err := s.Take(ctx, "key"); err != nil {
+				t.Fatal(err)
+			}
+			first := m.TTL("rl:key")
+
+			m.FastForward(10 * time.Second)
+			if _, err := s.Take(ctx, "key"); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := m.TTL("rl:key") == first, tc.refreshed; got != want {
+				t.Errorf("refreshed: expected %t to be %t (%s, then %s)", got, want, first, m.TTL("rl:key"))
+			}
+		})
+	}
+}
+
+func TestStaggerResets(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newStore(t, &redisstore.Config{
+		Tokens:        10,
+		Interval:      time.Hour,
+		StaggerResets: true,
+	})
+
+	local, err := memorystore.New(&memorystore.Config{
+		Tokens:        10,
+		Interval:      time.Hour,
+		StaggerResets: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+
+	ctx := context.Background()
+	resets := make(map[int64]struct{})
+	for i := 0; i < 10; i++ {
+		key := storetest.Key(t)
+
+		res, err := s.Take(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Until(res.ResetAt); d <= 0 || d > time.Hour {
+			t.Errorf("expected reset within an interval, got %s", d)
+		}
+
+		// The key resets at the same time as in memorystore.
+		other, err := local.Take(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := other.ResetAt.Sub(res.ResetAt); d < -time.Second || d > time.Second {
+			t.Errorf("expected %s to be %s", other.ResetAt, res.ResetAt)
+		}
+		resets[res.ResetAt.Unix()/60] = struct{}{}
+	}
+
+	if len(resets) < 2 {
+		t.Errorf("expected keys to reset at different times")
+	}
+}
+
+func TestSetMetadata(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newStore(t, &redisstore.Config{
+		Tokens:   10,
+		Interval: time.Minute,
+	})
+
+	ctx := context.Background()
+	key := storetest.Key(t)
+
+	// Setting the metadata creates the key, and takes keep it.
+	if err := s.(limiter.Annotator).SetMetadata(ctx, key, "plan=free"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Take(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.(limiter.Peeker).Peek(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Metadata, "plan=free"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if got, want := res.Remaining, uint64(9); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+}
+
+func TestMixedVersions(t *testing.T) {
+	t.Parallel()
+
+	s, m := newStore(t, &redisstore.Config{
+		Tokens:       5,
+		Interval:     time.Hour,
+		KeyPrefix:    "rl:",
+		GlobalTokens: 100,
+	})
+
+	ctx := context.Background()
+	start := strconv.FormatInt(time.Now().UnixNano(), 10)
+	newer := strconv.Itoa(bucketstate.Version + 1)
+
+	// A bucket of an earlier release, before debt and versions, and one of a
+	// later release with a newer format and a field this release does not know.
+	m.HSet("rl:older", "s", start, "t", "0", "k", "3")
+	m.HSet("rl:newer", "s", start, "t", "0", "k", "3", "d", "0", "v", newer, "x", "1")
+
+	res, err := s.Take(ctx, "older")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Remaining, uint64(2); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	// Every script refuses the newer bucket, and leaves it as it was.
+	if _, err := s.Take(ctx, "newer"); !errors.Is(err, bucketstate.ErrNewerVersion) {
+		t.Errorf("take: expected %v to be %v", err, bucketstate.ErrNewerVersion)
+	}
+	if err := s.(limiter.Refunder).Refund(ctx, "newer", 1); !errors.Is(err, bucketstate.ErrNewerVersion) {
+		t.Errorf("refund: expected %v to be %v", err, bucketstate.ErrNewerVersion)
+	}
+	if err := s.(limiter.Charger).Charge(ctx, "newer", 1); !errors.Is(err, bucketstate.ErrNewerVersion) {
+		t.Errorf("charge: expected %v to be %v", err, bucketstate.ErrNewerVersion)
+	}
+	if err := s.(limiter.Annotator).SetMetadata(ctx, "newer", "plan=free"); !errors.Is(err, bucketstate.ErrNewerVersion) {
+		t.Errorf("set metadata: expected %v to be %v", err, bucketstate.ErrNewerVersion)
+	}
+	if got, want := m.HGet("rl:newer", "k"), "3"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if m.HGet("rl:newer", "m") != "" {
+		t.Error("expected the newer bucket to be left as it was")
+	}
+}
@@ -0,0 +1,94 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/bucketstate"
+)
+
+// ReadState returns the stored state of the key's bucket, for migrating it to
+// another backend. It returns false if the key does not exist, and an error
+// wrapping bucketstate.ErrNewerVersion if it was written by a newer version.
+// The store must have been created by this package, and must not be using
+// redis-cell.
+func ReadState(ctx context.Context, ls limiter.Store, key string) (bucketstate.State, bool, error) {
+	s, err := stateStore(ls)
+	if err != nil {
+		return bucketstate.State{}, false, err
+	}
+
+	resp, err := s.conns.do(ctx, "HGETALL", s.keyPrefix+key)
+	var rerr replyError
+	if errors.As(err, &rerr) {
+		return bucketstate.State{}, false, fmt.Errorf("key is not a bucket: %w", err)
+	}
+	if err != nil {
+		return bucketstate.State{}, false, fmt.Errorf("failed to get key: %w", err)
+	}
+
+	a := resp.array()
+	if len(a) == 0 {
+		return bucketstate.State{}, false, nil
+	}
+	fields := make(map[string]string, len(a)/2)
+	for i := 0; i+1 < len(a); i += 2 {
+		fields[a[i].s] = a[i+1].s
+	}
+
+	st, err := bucketstate.FromFields(fields)
+	if errors.Is(err, bucketstate.ErrNewerVersion) {
+		return bucketstate.State{}, false, err
+	}
+	if err != nil {
+		return bucketstate.State{}, false, fmt.Errorf("key is not a bucket: %w", err)
+	}
+	return st, true, nil
+}
+
+// WriteState replaces the state of the key's bucket, and gives it the store's
+// TTL, for migrating it from another backend. The state is written as given,
+// so it must come from a bucket with the same limits. The store must have been
+// created by this package, and must not be using redis-cell.
+func WriteState(ctx context.Context, ls limiter.Store, key string, st bucketstate.State) error {
+	s, err := stateStore(ls)
+	if err != nil {
+		return err
+	}
+	if err := st.Validate(); err != nil {
+		return err
+	}
+
+	k := s.keyPrefix + key
+	fields := st.Fields()
+	args := []string{"HSET", k}
+	for _, field := range []string{bucketstate.FieldStart, bucketstate.FieldTick, bucketstate.FieldTokens, bucketstate.FieldDebt} {
+		args = append(args, field, fields[field])
+	}
+	if _, err := s.conns.do(ctx, args...); err != nil {
+		return fmt.Errorf("failed to set key: %w", err)
+	}
+	if _, err := s.conns.do(ctx, "EXPIRE", k, strconv.FormatUint(s.ttl, 10)); err != nil {
+		return fmt.Errorf("failed to set ttl: %w", err)
+	}
+	return nil
+}
+
+// stateStore returns the store, if its state can be read and written.
+func stateStore(ls limiter.Store) (*store, error) {
+	s, ok := ls.(*store)
+	if !ok {
+		return nil, fmt.Errorf("store was not created by redisstore")
+	}
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return nil, limiter.ErrStopped
+	}
+	if s.cell {
+		return nil, fmt.Errorf("cannot read buckets written by redis-cell: %w", limiter.ErrNotSupported)
+	}
+	return s, nil
+}
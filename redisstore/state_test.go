@@ -0,0 +1,68 @@
+package redisstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/bucketstate"
+)
+
+func TestState(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:    5,
+		Interval:  time.Minute,
+		KeyPrefix: "rl:",
+		DialFunc:  f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	if _, ok, err := ReadState(ctx, s, "a"); err != nil || ok {
+		t.Errorf("expected missing key, got %t, %v", ok, err)
+	}
+
+	if _, err := s.Take(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	st, ok, err := ReadState(ctx, s, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected key to exist")
+	}
+	if got, want := st.Tokens, float64(4); got != want {
+		t.Errorf("tokens: expected %g to be %g", got, want)
+	}
+
+	// A state written to another key is used by its next take.
+	st.Tokens = 1
+	if err := WriteState(ctx, s, "b", st); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Take(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Remaining, uint64(0); got != want {
+		t.Errorf("remaining: expected %d to be %d", got, want)
+	}
+
+	f.lock.Lock()
+	_, ttl := f.expires["rl:b"]
+	f.lock.Unlock()
+	if !ttl {
+		t.Errorf("expected written key to have a ttl")
+	}
+
+	if err := WriteState(ctx, s, "c", bucketstate.State{Tokens: -2}); err == nil {
+		t.Errorf("expected invalid state to be rejected")
+	}
+}
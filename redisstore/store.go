@@ -2,30 +2,108 @@
 package redisstore
 
 import (
+	"context"
 	"crypto/sha1"
+	"crypto/tls"
 	"fmt"
-	"net"
+	"hash/fnv"
 	"strconv"
 	"sync/atomic"
 	"time"
 
 	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/bucketstate"
+	"github.com/sethvargo/go-limiter/internal/denycache"
+	"github.com/sethvargo/go-limiter/internal/phase"
+	"github.com/sethvargo/go-limiter/memorystore"
 )
 
 var _ limiter.Store = (*store)(nil)
+var _ limiter.Refunder = (*store)(nil)
+var _ limiter.Charger = (*store)(nil)
+var _ limiter.Annotator = (*store)(nil)
+var _ limiter.Overrider = (*store)(nil)
 
 type store struct {
+	// takes, denials, and failures count the results of Take, denyCacheHits
+	// counts the denials served from the deny cache, and fallbacks counts the
+	// takes decided by the fallback. They are first so they are 64-bit aligned
+	// for atomic access.
+	takes         uint64
+	denials       uint64
+	failures      uint64
+	denyCacheHits uint64
+	fallbacks     uint64
+
 	tokens   uint64
 	interval time.Duration
 	rate     float64
 	ttl      uint64
-	pool     *pool
+	conns    doer
+
+	// keyPrefix is prepended to every key.
+	keyPrefix string
+
+	// maxKeyLength is the longest key allowed, or 0 for no limit. Longer keys
+	// are hashed if hashLongKeys is set.
+	maxKeyLength int
+	hashLongKeys bool
+
+	// globalKey is the key of the global bucket, or empty if it is disabled.
+	globalKey    string
+	globalTokens uint64
+
+	debtLimit uint64
+
+	// failureMode is the FailureMode, which SetFailureMode may change while
+	// the store is in use, so it is accessed atomically. failOpenKeys is the
+	// number of keys out of every failHashRange that FailPartial allows.
+	failureMode  int32
+	failOpenKeys uint64
+
+	// stagger offsets the start of each key's intervals by a hash of the key.
+	stagger bool
+
+	// expirer delivers expired key events, or is nil if OnExpire is not set.
+	expirer *expireSubscriber
+
+	// coalescer batches concurrent takes on the same key, or is nil if
+	// Coalesce is not set.
+	coalescer *coalescer
+
+	// batcher hands out tokens taken from Redis in batches, or is nil if
+	// LocalBatch is not set.
+	batcher *batcher
+
+	// denyCache caches denied keys, or is nil if DenyCache is not set.
+	denyCache *denycache.Cache
 
-	failureMode FailureMode
+	// latencyBudget is how long a take waits for Redis before the fallback
+	// decides it, or 0 to always wait. closeFallback is set if the store
+	// created the fallback, so it must close it.
+	latencyBudget time.Duration
+	fallback      limiter.Store
+	closeFallback bool
 
 	luaScript    string
 	luaScriptSHA string
 
+	luaRefundScript    string
+	luaRefundScriptSHA string
+
+	// luaConfig is the configuration of the scripts, and luaArgs are its
+	// arguments, which precede the arguments of each call to the scripts.
+	luaConfig luaConfig
+	luaArgs   []string
+
+	// luaLibrary is the Redis Function library of the scripts, or empty if
+	// Functions is not set, and luaLibraryName is its name.
+	luaLibrary     string
+	luaLibraryName string
+
+	// cell is set if takes use CL.THROTTLE instead of the limiter script.
+	cell bool
+
 	stopped uint32
 }
 
@@ -38,9 +116,15 @@ const (
 	//
 	// FailOpen indicates the system should allow reqeusts if it cannot connect to
 	// the redis backend.
+	//
+	// FailPartial indicates the system should allow the requests of a fraction of
+	// the keys, Config.FailOpenFraction, if it cannot connect to the redis
+	// backend. Keys are chosen by a hash, so each key is either always allowed
+	// or always rejected during an outage.
 	_ FailureMode = iota
 	FailClosed
 	FailOpen
+	FailPartial
 )
 
 // Config is used as input to New. It defines the behavior of the storage
@@ -50,6 +134,38 @@ type Config struct {
 	// 1.
 	Tokens uint64
 
+	// GlobalTokens, if set, enables a global bucket shared by all keys, like an
+	// overall service capacity. Each take also takes a token from the global
+	// bucket, atomically with the per-key bucket, and is rejected if either is
+	// exhausted. GlobalInterval is the global bucket's interval. The default is
+	// Interval. GlobalKey is the Redis key of the global bucket. The default is
+	// "limiter:global"; it must not collide with any per-key keys.
+	GlobalTokens   uint64
+	GlobalInterval time.Duration
+	GlobalKey      string
+
+	// ReservedFraction is the fraction of each bucket's tokens reserved for
+	// high-priority takes. Low-priority takes (see limiter.WithPriority) are
+	// rejected once the remaining tokens fall to the reserve, while
+	// high-priority takes may use all of them. It must be in [0, 1). The
+	// default value is 0, which treats all priorities the same.
+	ReservedFraction float64
+
+	// DebtLimit is the number of tokens a key may borrow once its bucket is
+	// empty. Borrowed takes are allowed, and later refills pay down the debt
+	// before new tokens become available, which smooths enforcement for
+	// clients that slightly exceed their rate. Low-priority takes and the
+	// global bucket never borrow. The default value is 0, which disables
+	// borrowing.
+	DebtLimit uint64
+
+	// StaggerResets, if true, aligns the intervals of each key to an offset
+	// derived from a hash of the key, like memorystore.Config.StaggerResets,
+	// so keys created together do not all refill at the same instant. A key
+	// refills at the same times in memorystore and redisstore, given the same
+	// Interval.
+	StaggerResets bool
+
 	// Interval is the time interval upon which to enforce rate limiting. The
 	// default value is 1 second.
 	Interval time.Duration
@@ -58,22 +174,174 @@ type Config struct {
 	// purging. The default is 10 x interval.
 	TTL uint64
 
+	// KeyPrefix is prepended to every key in Redis, which keeps the limiter's
+	// keys apart from others in the same database. It is not prepended to
+	// GlobalKey. The default value is empty.
+	KeyPrefix string
+
+	// MaxKeyLength, if set, is the longest key in bytes, not counting the
+	// KeyPrefix, that is sent to Redis. Takes, refunds, and peeks on longer
+	// keys fail with ErrKeyTooLong regardless of the FailureMode, which
+	// catches applications that accidentally use entire URLs or tokens as
+	// keys. If HashLongKeys is set, longer keys are replaced by their SHA-256
+	// instead, which is 71 bytes, so MaxKeyLength must be at least that. The
+	// default value is 0, which allows keys of any length.
+	MaxKeyLength int
+	HashLongKeys bool
+
+	// TTLMode controls whether the TTL is refreshed on every take or measured
+	// from when the key was first written. The default is limiter.TTLSliding.
+	TTLMode limiter.TTLMode
+
 	// InitialPoolSize and MaxPoolSize determine the initial and maximum number of
 	// pool connections. The default values are 5 and 100 respectively.
 	InitialPoolSize uint64
 	MaxPoolSize     uint64
 
-	// DialFunc is a function that creates a connection to the Redis
-	// server.
-	DialFunc func() (net.Conn, error)
+	// AutosizePool resizes the pool to its load instead of keeping every
+	// connection it once needed. Every second, the pool doubles in size, up to
+	// MaxPoolSize, if takes waited for a connection for over a millisecond on
+	// average, and otherwise closes half of its connections that stayed idle,
+	// down to InitialPoolSize. With AutosizePool, the default MaxPoolSize is a
+	// quarter of the process's RLIMIT_NOFILE soft limit, up to 1024, so the
+	// pool leaves file descriptors for the rest of the process. It is ignored
+	// when Multiplex is set.
+	AutosizePool bool
+
+	// DialFunc is a function that creates a connection to the Redis server. The
+	// context passed to it carries the deadline of the Take that triggered the
+	// dial and is canceled when the store is closed. Use AdaptDialFunc to
+	// adapt a function that does not accept a context.
+	DialFunc DialFunc
 
 	// AuthUsername and AuthPassword are optional authentication information.
 	AuthUsername string
 	AuthPassword string
 
+	// Database is the logical database to select on every new connection. The
+	// default value is 0.
+	Database int
+
+	// TLSConfig is the TLS configuration used by NewFromURL for rediss:// URLs.
+	// It is ignored by New, since the DialFunc is responsible for TLS. If nil,
+	// a default configuration is used.
+	TLSConfig *tls.Config
+
 	// FailureMode indicates how the system should fail if it cannot connect to
-	// the redis backend.
+	// the redis backend. It can be changed later with SetFailureMode. The
+	// default value is FailClosed.
 	FailureMode FailureMode
+
+	// FailOpenFraction is the fraction of keys whose takes are allowed with
+	// FailPartial. It must be in [0, 1]. The default value is 0, which rejects
+	// every key like FailClosed.
+	FailOpenFraction float64
+
+	// Interceptor is an optional function that wraps every command sent to
+	// Redis. It is primarily useful for injecting faults in tests and chaos
+	// experiments.
+	Interceptor Interceptor
+
+	// Multiplex sends all commands over a single connection instead of a pool.
+	// Commands from concurrent callers are pipelined and replies are matched to
+	// callers in the order they were sent. This keeps the number of connections
+	// to Redis at one per store, at the cost of slow commands delaying the
+	// commands queued behind them. InitialPoolSize and MaxPoolSize are ignored
+	// when Multiplex is set.
+	Multiplex bool
+
+	// Coalesce batches concurrent takes on the same key from this process into
+	// a single call to Redis for as many tokens, and fans out the result. While
+	// a call for a key is in flight, takes on the key wait and are sent
+	// together in the next call. This trades a little latency for far fewer
+	// calls on hot keys. Batched takes are granted in arrival order and share
+	// the Remaining and ResetAt of their call. Takes are only batched with
+	// takes of the same priority.
+	Coalesce bool
+
+	// LocalBatch, if set, takes up to this many tokens at a time from Redis for
+	// each key and hands them out locally, so most takes do not wait on Redis.
+	// When a key's local tokens fall to PrefetchThreshold, the next batch is
+	// fetched in the background. Local tokens expire at the end of the interval
+	// they were taken in and are lost when the store is closed, so other
+	// processes may be denied tokens this one never uses, but a key never
+	// exceeds its limit, since tokens are taken from Redis before they are
+	// handed out. Remaining is the
+	// local tokens plus those left in Redis at the last fetch. Coalesce is
+	// ignored when LocalBatch is set. The default PrefetchThreshold is a quarter
+	// of LocalBatch.
+	LocalBatch        uint64
+	PrefetchThreshold uint64
+
+	// LatencyBudget, if set, bounds how long a take waits for Redis. If Redis
+	// does not answer in time, Fallback decides the take instead, and the call
+	// to Redis continues in the background so the key is still counted. If
+	// Redis granted a token the fallback denied, the token is refunded. This
+	// keeps latency bounded when Redis is slow, at the cost of enforcing the
+	// fallback's limits, which are local to the process, until it recovers.
+	// The default Fallback is a memorystore with the same Tokens and Interval,
+	// which is closed with the store; a Fallback that is set must be closed by
+	// the caller.
+	LatencyBudget time.Duration
+	Fallback      limiter.Store
+
+	// DenyCache caches denials locally, so further takes on a denied key are
+	// denied without a call to Redis until shortly before its reset time. This
+	// keeps attack traffic on a few keys from reaching Redis, but tokens
+	// refunded by other processes are not seen until the cached denial
+	// expires. Refunds through this store clear the key's cached denials.
+	// DenyCacheMargin is how long before the reset time a cached denial
+	// expires, which allows for clock drift. The default is 50 milliseconds.
+	// DenyCacheSize is the maximum number of keys cached. The default is
+	// 10000.
+	DenyCache       bool
+	DenyCacheMargin time.Duration
+	DenyCacheSize   int
+
+	// Functions loads the limiter scripts as a Redis Function library, which
+	// requires Redis 7 or later, and runs them with FCALL instead of EVALSHA.
+	// Unlike the script cache, functions are persisted and replicated by the
+	// server, and they show up in FUNCTION LIST. The configuration is passed
+	// with each call, so stores of every configuration share one library,
+	// named "limiter_" followed by a SHA1 of the scripts; stores of other
+	// versions of the limiter load their own library alongside it. The library
+	// is loaded with REPLACE on every new connection, and again if a call finds
+	// it missing, which only ever replaces it with the same code. Stores
+	// without Functions use the script cache and are not affected by it.
+	Functions bool
+
+	// RedisCell uses the CL.THROTTLE command of the redis-cell module, if the
+	// server has it, instead of the limiter script. redis-cell implements the
+	// generic cell rate algorithm natively, which is faster than a script and
+	// refills tokens continuously instead of at the end of each interval, and
+	// reports reset and retry times in whole seconds. It is only used when
+	// Interval is a whole number of seconds and none of GlobalTokens,
+	// ReservedFraction, DebtLimit, StaggerResets, TTLMode, LocalBatch, or
+	// Coalesce are set, since it cannot express them; otherwise, or if the
+	// module is not installed, the script is used. Refund returns
+	// limiter.ErrNotSupported while redis-cell is in use. Keys written by the
+	// two are not compatible.
+	RedisCell bool
+
+	// OnExpire, if set, is called with each key that expires, so applications
+	// can clean up state correlated with the key. It subscribes to keyspace
+	// notifications on a dedicated connection, which requires the server to be
+	// configured with notify-keyspace-events including "Ex". Every key with the
+	// KeyPrefix that expires in the database is reported, without the prefix,
+	// so without a prefix the database should be dedicated to the limiter.
+	// Redis sends the event when it deletes the key, which may
+	// be later than its TTL, and events sent while the subscription is
+	// reconnecting are lost. OnExpire is called from a single goroutine and
+	// should not block.
+	OnExpire func(key string)
+}
+
+// doer executes commands against Redis. It is implemented by the connection
+// pool and by the multiplexed connection.
+type doer interface {
+	do(ctx context.Context, args ...string) (*response, error)
+	debugVars() map[string]interface{}
+	close() error
 }
 
 // New uses a Redis instance to back a rate limiter that to limit the number of
@@ -103,55 +371,159 @@ func New(c *Config) (limiter.Store, error) {
 		return nil, fmt.Errorf("ttl cannot be 0")
 	}
 
+	if c.TTLMode != limiter.TTLSliding && c.TTLMode != limiter.TTLFixed {
+		return nil, fmt.Errorf("unknown ttl mode %d", c.TTLMode)
+	}
+	fixedTTL := c.TTLMode == limiter.TTLFixed
+
 	initialPoolSize := uint64(5)
 	if c.InitialPoolSize > 0 {
 		initialPoolSize = c.InitialPoolSize
 	}
 
-	maxPoolSize := uint64(5)
-	if c.InitialPoolSize > 0 {
+	maxPoolSize := uint64(100)
+	if c.MaxPoolSize > 0 {
 		maxPoolSize = c.MaxPoolSize
+	} else if c.AutosizePool {
+		maxPoolSize = autosizeMaxPoolSize(initialPoolSize)
+	}
+
+	globalInterval := interval
+	if c.GlobalInterval > 0 {
+		globalInterval = c.GlobalInterval
+	}
+
+	var globalRate float64
+	if c.GlobalTokens > 0 {
+		globalRate = float64(globalInterval) / float64(c.GlobalTokens)
+	}
+
+	var globalKey string
+	if c.GlobalTokens > 0 {
+		globalKey = "limiter:global"
+		if c.GlobalKey != "" {
+			globalKey = c.GlobalKey
+		}
+	}
+
+	if c.ReservedFraction < 0 || c.ReservedFraction >= 1 {
+		return nil, fmt.Errorf("reserved fraction must be in [0, 1)")
 	}
 
 	failureMode := FailClosed
 	if c.FailureMode != 0 {
 		failureMode = c.FailureMode
 	}
+	if !validFailureMode(failureMode) {
+		return nil, fmt.Errorf("unknown failure mode %d", failureMode)
+	}
+	if c.FailOpenFraction < 0 || c.FailOpenFraction > 1 {
+		return nil, fmt.Errorf("fail open fraction must be in [0, 1]")
+	}
+
+	if c.Database < 0 {
+		return nil, fmt.Errorf("database cannot be negative")
+	}
+
+	denyCacheMargin := 50 * time.Millisecond
+	if c.DenyCacheMargin > 0 {
+		denyCacheMargin = c.DenyCacheMargin
+	}
+
+	denyCacheSize := 10000
+	if c.DenyCacheSize > 0 {
+		denyCacheSize = c.DenyCacheSize
+	}
+
+	if c.MaxKeyLength < 0 {
+		return nil, fmt.Errorf("max key length cannot be negative")
+	}
+	if c.HashLongKeys && c.MaxKeyLength < hashedKeyLength {
+		return nil, fmt.Errorf("max key length must be at least %d to hash long keys", hashedKeyLength)
+	}
+
+	prefetchThreshold := c.LocalBatch / 4
+	if c.PrefetchThreshold > 0 {
+		prefetchThreshold = c.PrefetchThreshold
+	}
+	if c.LocalBatch > 0 && prefetchThreshold >= c.LocalBatch {
+		return nil, fmt.Errorf("prefetch threshold must be less than the local batch")
+	}
+
+	if c.LatencyBudget < 0 {
+		return nil, fmt.Errorf("latency budget cannot be negative")
+	}
 
 	dialFunc := c.DialFunc
 	if dialFunc == nil {
 		return nil, fmt.Errorf("missing DialFunc")
 	}
 
-	luaScript := fmt.Sprintf(string(luaTemplate),
-		tokens, interval, rate, ttl)
+	// The scripts only depend on the version of the bucket format, so stores
+	// of any configuration share them, and the configuration is passed with
+	// each call.
+	luaScript := fmt.Sprintf(string(luaTemplate), bucketstate.Version)
 	luaScriptSHA := fmt.Sprintf("%x", sha1.Sum([]byte(luaScript)))
 
-	pool, err := newPool(&poolConfig{
-		initial:  initialPoolSize,
-		max:      maxPoolSize,
+	luaRefundScript := fmt.Sprintf(string(luaRefundTemplate), bucketstate.Version)
+	luaRefundScriptSHA := fmt.Sprintf("%x", sha1.Sum([]byte(luaRefundScript)))
+
+	luaConfig := luaConfig{
+		tokens:           tokens,
+		interval:         interval,
+		rate:             rate,
+		ttl:              ttl,
+		globalTokens:     c.GlobalTokens,
+		globalInterval:   globalInterval,
+		globalRate:       globalRate,
+		reservedFraction: c.ReservedFraction,
+		debtLimit:        c.DebtLimit,
+		fixedTTL:         fixedTTL,
+	}
+
+	// The scripts are loaded on every new connection, including the initial ones,
+	// so they're primed on the server before the first take.
+	dc := dialConfig{
 		dialFunc: dialFunc,
 		username: c.AuthUsername,
 		password: c.AuthPassword,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to setup connection pool: %w", err)
+
+		interceptor: c.Interceptor,
+		database:    c.Database,
+		scripts:     []string{luaScript, luaRefundScript},
 	}
 
-	client, err := pool.get()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get client to configure lua: %w", err)
+	var luaLib, luaLibName string
+	if c.Functions {
+		shas := []string{luaScriptSHA, luaRefundScriptSHA}
+		luaLib = luaLibrary([]string{luaScript, luaRefundScript}, shas)
+		luaLibName = libraryName(shas)
+		dc.library = luaLib
 	}
 
-	if _, err := client.do("SCRIPT", "LOAD", luaScript); err != nil {
-		if closeErr := client.release(pool); err != nil {
-			return nil, fmt.Errorf("failed to prime script: %v, but then failed to close client: %w", err, closeErr)
+	var conns doer
+	if c.Multiplex {
+		m, err := newMux(context.Background(), &dc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup multiplexed connection: %w", err)
+		}
+		conns = m
+	} else {
+		p, err := newPool(context.Background(), &poolConfig{
+			initial:    initialPoolSize,
+			max:        maxPoolSize,
+			autosize:   c.AutosizePool,
+			dialConfig: dc,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup connection pool: %w", err)
 		}
-		return nil, fmt.Errorf("failed to prime script: %v", err)
+		conns = p
 	}
 
-	if err := client.release(pool); err != nil {
-		return nil, fmt.Errorf("failed to close client: %w", err)
+	var expirer *expireSubscriber
+	if c.OnExpire != nil {
+		expirer = newExpireSubscriber(dc, c.KeyPrefix, globalKey, c.OnExpire)
 	}
 
 	s := &store{
@@ -159,64 +531,431 @@ func New(c *Config) (limiter.Store, error) {
 		interval: interval,
 		rate:     rate,
 		ttl:      ttl,
-		pool:     pool,
+		conns:    conns,
+
+		keyPrefix:    c.KeyPrefix,
+		maxKeyLength: c.MaxKeyLength,
+		hashLongKeys: c.HashLongKeys,
+		globalKey:    globalKey,
+		globalTokens: c.GlobalTokens,
+
+		debtLimit: c.DebtLimit,
+		stagger:   c.StaggerResets,
+
+		failureMode:  int32(failureMode),
+		failOpenKeys: uint64(c.FailOpenFraction * failHashRange),
 
-		failureMode: failureMode,
+		expirer: expirer,
 
 		luaScript:    luaScript,
 		luaScriptSHA: luaScriptSHA,
+
+		luaRefundScript:    luaRefundScript,
+		luaRefundScriptSHA: luaRefundScriptSHA,
+
+		luaConfig:      luaConfig,
+		luaArgs:        luaConfig.args(),
+		luaLibrary:     luaLib,
+		luaLibraryName: luaLibName,
+	}
+	if c.RedisCell && cellCompatible(c, interval) {
+		s.cell = detectCell(context.Background(), conns)
+	}
+	if c.LocalBatch > 0 {
+		s.batcher = newBatcher(s.take, c.LocalBatch, prefetchThreshold, interval)
+	} else if c.Coalesce {
+		s.coalescer = newCoalescer(s.take)
+	}
+	if c.DenyCache {
+		s.denyCache = denycache.New(denyCacheMargin, denyCacheSize)
+	}
+	if c.LatencyBudget > 0 {
+		s.latencyBudget = c.LatencyBudget
+		s.fallback = c.Fallback
+		if s.fallback == nil {
+			fallback, err := memorystore.New(&memorystore.Config{
+				Tokens:   tokens,
+				Interval: interval,
+			})
+			if err != nil {
+				conns.close()
+				if expirer != nil {
+					expirer.close()
+				}
+				return nil, fmt.Errorf("failed to setup fallback: %w", err)
+			}
+			s.fallback = fallback
+			s.closeFallback = true
+		}
 	}
 	return s, nil
 }
 
+// SetFailureMode changes how the store fails if it cannot connect to Redis,
+// so operators can choose between availability and protection during an
+// incident without restarting, for example from an admin endpoint or when a
+// configuration file changes. It applies to takes that fail after it returns.
+// The store must have been created by this package.
+func SetFailureMode(ls limiter.Store, m FailureMode) error {
+	s, ok := ls.(*store)
+	if !ok {
+		return fmt.Errorf("store was not created by redisstore")
+	}
+	if !validFailureMode(m) {
+		return fmt.Errorf("unknown failure mode %d", m)
+	}
+	atomic.StoreInt32(&s.failureMode, int32(m))
+	return nil
+}
+
+// failHashRange is the number of buckets keys are hashed into for
+// FailPartial.
+const failHashRange = 10000
+
+// validFailureMode reports whether m is a known FailureMode.
+func validFailureMode(m FailureMode) bool {
+	return m == FailClosed || m == FailOpen || m == FailPartial
+}
+
+// failAllowed reports whether the failure mode allows a take of the key that
+// failed to reach Redis.
+func (s *store) failAllowed(key string) bool {
+	switch FailureMode(atomic.LoadInt32(&s.failureMode)) {
+	case FailOpen:
+		return true
+	case FailPartial:
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		return h.Sum64()%failHashRange < s.failOpenKeys
+	default:
+		return false
+	}
+}
+
 // Take attempts to remove a token from the named key. If the take is
 // successful, it returns true, otherwise false. It also returns the configured
 // limit, remaining tokens, and reset time, if one was found. Any errors
-// connecting to the store or parsing the return value are considered failures
-// and fail the take.
-func (s *store) Take(key string) (uint64, uint64, uint64, bool) {
+// connecting to the store or parsing the return value are returned alongside
+// the result of the configured FailureMode.
+func (s *store) Take(ctx context.Context, key string) (limiter.Result, error) {
 	// If the store is stopped, all requests are rejected.
 	if atomic.LoadUint32(&s.stopped) == 1 {
-		return 0, 0, 0, false
+		return limiter.Result{}, limiter.ErrStopped
 	}
 
-	// Get a client from the pool.
-	c, err := s.pool.get()
+	key, err := s.checkKey(key)
 	if err != nil {
-		switch s.failureMode {
-		case FailClosed:
-			return 0, 0, 0, false
-		case FailOpen:
-			return 0, 0, 0, true
+		return limiter.Result{}, err
+	}
+
+	atomic.AddUint64(&s.takes, 1)
+
+	low := limiter.PriorityFromContext(ctx) == limiter.PriorityLow
+	if s.denyCache != nil {
+		now := time.Now()
+		if resetAt, ok := s.denyCache.Get(key, low, now); ok {
+			atomic.AddUint64(&s.denials, 1)
+			atomic.AddUint64(&s.denyCacheHits, 1)
+			return limiter.Result{
+				Limit:      s.tokens,
+				ResetAt:    resetAt,
+				RetryAfter: resetAt.Sub(now),
+			}, nil
 		}
 	}
-	defer c.release(s.pool)
 
-	now := uint64(time.Now().UTC().UnixNano())
-	nowStr := strconv.FormatUint(now, 10)
+	var d decision
+	if s.latencyBudget > 0 {
+		var res *limiter.Result
+		var err error
+		d, res, err = s.takeWithin(ctx, key)
+		if res != nil {
+			// The fallback decided the take.
+			if err != nil {
+				atomic.AddUint64(&s.failures, 1)
+			} else if !res.Allowed {
+				atomic.AddUint64(&s.denials, 1)
+			}
+			return *res, err
+		}
+	} else {
+		d = s.decide(ctx, key)
+	}
+	if d.err != nil {
+		atomic.AddUint64(&s.failures, 1)
+		return limiter.Result{Allowed: s.failAllowed(key)}, d.err
+	}
+	if !d.ok {
+		atomic.AddUint64(&s.denials, 1)
+	}
 
-	resp, err := c.do("EVAL", s.luaScript, "1", key, nowStr)
-	if err != nil {
-		switch s.failureMode {
-		case FailClosed:
-			return 0, 0, 0, false
-		case FailOpen:
-			return 0, 0, 0, true
+	// The reset time is computed from the server-side duration until the next
+	// refill rather than the server's absolute timestamp, so it's correct with
+	// respect to the local clock even if the clocks are skewed.
+	r := limiter.Result{
+		Limit:     s.tokens,
+		Remaining: d.remaining,
+		ResetAt:   time.Now().Add(d.resetAfter),
+		Allowed:   d.ok,
+	}
+	if !d.ok {
+		r.RetryAfter = d.resetAfter
+		if s.denyCache != nil {
+			s.denyCache.Add(key, low, time.Now(), r.ResetAt)
 		}
 	}
+	return r, nil
+}
+
+// TakeWith takes a token from the named key like Take, but allows tokens per
+// interval instead of the configured limit, which are passed to the script in
+// place of the store's. The global bucket, reserved fraction, and debt limit
+// still apply, and the TTL is raised to 10 intervals if it is shorter. Since
+// LocalBatch, Coalesce, LatencyBudget, and the deny cache are sized for the
+// configured limit, the take skips them and goes to Redis. It returns
+// limiter.ErrNotSupported while redis-cell is in use.
+func (s *store) TakeWith(ctx context.Context, key string, tokens uint64, interval time.Duration) (limiter.Result, error) {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.Result{}, limiter.ErrStopped
+	}
+	if s.cell {
+		return limiter.Result{}, limiter.ErrNotSupported
+	}
+	if tokens == 0 || interval <= 0 {
+		return limiter.Result{}, fmt.Errorf("tokens and interval must be positive")
+	}
+
+	key, err := s.checkKey(key)
+	if err != nil {
+		return limiter.Result{}, err
+	}
+
+	atomic.AddUint64(&s.takes, 1)
+
+	c := s.luaConfig
+	c.tokens = tokens
+	c.interval = interval
+	c.rate = float64(interval) / float64(tokens)
+	if ttl := 10 * uint64(interval.Seconds()); c.ttl < ttl {
+		c.ttl = ttl
+	}
+
+	remaining, resetAfter, granted, err := s.takeScript(ctx, c.args(), interval, key, 1)
+	if err != nil {
+		atomic.AddUint64(&s.failures, 1)
+		return limiter.Result{Allowed: s.failAllowed(key)}, err
+	}
+
+	r := limiter.Result{
+		Limit:     tokens,
+		Remaining: remaining,
+		ResetAt:   time.Now().Add(resetAfter),
+		Allowed:   granted > 0,
+	}
+	if !r.Allowed {
+		atomic.AddUint64(&s.denials, 1)
+		r.RetryAfter = resetAfter
+	}
+	return r, nil
+}
+
+// decide takes a token from the key, from the local batch, as part of a
+// coalesced call, or directly, depending on the configuration.
+func (s *store) decide(ctx context.Context, key string) decision {
+	var d decision
+	switch {
+	case s.batcher != nil:
+		d.remaining, d.resetAfter, d.ok, d.err = s.batcher.take(ctx, key)
+	case s.coalescer != nil:
+		d.remaining, d.resetAfter, d.ok, d.err = s.coalescer.take(ctx, key)
+	default:
+		var granted uint64
+		d.remaining, d.resetAfter, granted, d.err = s.take(ctx, key, 1)
+		d.ok = granted > 0
+	}
+	return d
+}
+
+// take runs the limiter script for n tokens from the given key. It returns the
+// remaining tokens, the time until the next refill, and the number of tokens
+// granted, which may be fewer than n. It returns an error if a client could
+// not be acquired, the command failed, or the reply was invalid.
+func (s *store) take(ctx context.Context, key string, n uint64) (remaining uint64, resetAfter time.Duration, granted uint64, err error) {
+	if s.cell {
+		return s.takeCell(ctx, key, n)
+	}
+	return s.takeScript(ctx, s.luaArgs, s.interval, key, n)
+}
+
+// takeScript runs the limiter script like take, with the given configuration
+// arguments and interval of the key's bucket.
+func (s *store) takeScript(ctx context.Context, config []string, interval time.Duration, key string, n uint64) (remaining uint64, resetAfter time.Duration, granted uint64, err error) {
+	priority := "high"
+	if limiter.PriorityFromContext(ctx) == limiter.PriorityLow {
+		priority = "low"
+	}
+
+	args := []string{priority, strconv.FormatUint(n, 10)}
+	if s.stagger {
+		args = append(args, strconv.FormatInt(int64(phase.Offset(key, interval)), 10))
+	}
+	resp, err := s.evalWith(ctx, config, s.luaScript, s.luaScriptSHA, key, args...)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to run script: %w", err)
+	}
 
 	a := resp.array()
-	if len(a) < 3 {
-		switch s.failureMode {
-		case FailClosed:
-			return 0, 0, 0, false
-		case FailOpen:
-			return 0, 0, 0, true
+	if len(a) < 4 {
+		return 0, 0, 0, fmt.Errorf("invalid script reply: expected at least 4 values, got %d", len(a))
+	}
+
+	resetAfter = time.Duration(a[3].int64())
+	if resetAfter < 0 {
+		resetAfter = 0
+	}
+
+	// A reply without the granted count is for a single token.
+	if len(a) > 4 {
+		granted = a[4].uint64()
+	} else if a[2].uint64() == 1 {
+		granted = 1
+	}
+	return a[0].uint64(), resetAfter, granted, nil
+}
+
+// Refund returns tokens to the named key, and the global bucket if enabled, up
+// to the configured limits. Tokens are only returned to the current interval.
+// Refunding a key that does not exist is a no-op. Unlike Take, errors are
+// returned regardless of the configured FailureMode. It returns
+// limiter.ErrNotSupported while redis-cell is in use.
+func (s *store) Refund(ctx context.Context, key string, tokens uint64) error {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.ErrStopped
+	}
+	if s.cell {
+		return limiter.ErrNotSupported
+	}
+
+	key, err := s.checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.eval(ctx, s.luaRefundScript, s.luaRefundScriptSHA, key,
+		strconv.FormatUint(tokens, 10)); err != nil {
+		return fmt.Errorf("failed to run refund script: %w", err)
+	}
+	if s.denyCache != nil {
+		s.denyCache.Remove(key)
+	}
+	return nil
+}
+
+// Charge takes tokens from the named key, and the global bucket if enabled,
+// down to zero, without checking the limits. Charging a key that does not
+// exist creates it. Like Refund, errors are returned regardless of the
+// configured FailureMode, and it returns limiter.ErrNotSupported while
+// redis-cell is in use.
+func (s *store) Charge(ctx context.Context, key string, tokens uint64) error {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.ErrStopped
+	}
+	if s.cell {
+		return limiter.ErrNotSupported
+	}
+
+	key, err := s.checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	args := []string{strconv.FormatUint(tokens, 10), "charge"}
+	if s.stagger {
+		args = append(args, s.phase(key))
+	}
+	if _, err := s.eval(ctx, s.luaRefundScript, s.luaRefundScriptSHA, key, args...); err != nil {
+		return fmt.Errorf("failed to run charge script: %w", err)
+	}
+	return nil
+}
+
+// SetMetadata stores the metadata in the key's hash, creating the key if it
+// does not exist, so it expires with the bucket. Like Refund, errors are
+// returned regardless of the configured FailureMode, and it returns
+// limiter.ErrNotSupported while redis-cell is in use.
+func (s *store) SetMetadata(ctx context.Context, key, metadata string) error {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.ErrStopped
+	}
+	if s.cell {
+		return limiter.ErrNotSupported
+	}
+
+	key, err := s.checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	args := []string{metadata, "metadata"}
+	if s.stagger {
+		args = append(args, s.phase(key))
+	}
+	if _, err := s.eval(ctx, s.luaRefundScript, s.luaRefundScriptSHA, key, args...); err != nil {
+		return fmt.Errorf("failed to run metadata script: %w", err)
+	}
+	return nil
+}
+
+// phase returns the offset of the key's intervals for the scripts.
+func (s *store) phase(key string) string {
+	return strconv.FormatInt(int64(phase.Offset(key, s.interval)), 10)
+}
+
+// eval runs the script for the given key, and the global key if enabled, with
+// the store's configuration followed by args. It uses EVALSHA, falling back to
+// EVAL if the script is not cached. If Functions is set, it runs the script's
+// function with FCALL instead, loading the library again if the function is
+// missing.
+func (s *store) eval(ctx context.Context, script, sha, key string, args ...string) (*response, error) {
+	return s.evalWith(ctx, s.luaArgs, script, sha, key, args...)
+}
+
+// evalWith runs the script like eval, with the given configuration arguments
+// instead of the store's.
+func (s *store) evalWith(ctx context.Context, config []string, script, sha, key string, args ...string) (*response, error) {
+	key = s.keyPrefix + key
+	cmd := make([]string, 0, 5+len(config)+len(args))
+	cmd = append(cmd, "EVALSHA", sha, "1", key)
+	if s.globalKey != "" {
+		cmd[2] = "2"
+		cmd = append(cmd, s.globalKey)
+	}
+	cmd = append(cmd, config...)
+	cmd = append(cmd, args...)
+
+	if s.luaLibrary != "" {
+		cmd[0], cmd[1] = "FCALL", functionName(s.luaLibraryName, sha)
+		resp, err := s.conns.do(ctx, cmd...)
+		if isNoFunction(err) {
+			// The functions were flushed or the server was replaced without
+			// them since the connection was primed.
+			if _, err := s.conns.do(ctx, "FUNCTION", "LOAD", "REPLACE", s.luaLibrary); err != nil {
+				return nil, fmt.Errorf("failed to load functions: %w", err)
+			}
+			resp, err = s.conns.do(ctx, cmd...)
 		}
+		return resp, err
 	}
 
-	tokens, next, ok := a[0].uint64(), a[1].uint64(), a[2].uint64()
-	return s.tokens, tokens, next, ok == 1
+	resp, err := s.conns.do(ctx, cmd...)
+	if isNoScript(err) {
+		// The script cache was flushed or the server was replaced since the
+		// connection was primed. EVAL runs the script and caches it again, so
+		// later calls can use EVALSHA.
+		cmd[0], cmd[1] = "EVAL", script
+		resp, err = s.conns.do(ctx, cmd...)
+	}
+	return resp, err
 }
 
 // Close stops the memory limiter and cleans up any outstanding sessions. You
@@ -227,7 +966,20 @@ func (s *store) Close() error {
 		return nil
 	}
 
-	// Close the connection pool.
-	s.pool.close()
+	// Stop any prefetches before closing the connections they use.
+	if s.batcher != nil {
+		s.batcher.close()
+	}
+
+	// Close the connection pool or multiplexed connection.
+	s.conns.close()
+
+	if s.expirer != nil {
+		s.expirer.close()
+	}
+
+	if s.closeFallback {
+		return s.fallback.Close()
+	}
 	return nil
 }
@@ -1,14 +1,27 @@
 package redisstore
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha1"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"reflect"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/bucketstate"
+	"github.com/sethvargo/go-limiter/memorystore"
+	"github.com/sethvargo/go-limiter/storetest"
 )
 
 func testKey(tb testing.TB) string {
@@ -25,13 +38,15 @@ func testKey(tb testing.TB) string {
 func TestStore_Take(t *testing.T) {
 	t.Parallel()
 
+	ctx := context.Background()
+
 	if testing.Short() {
 		t.Skipf("skipping (short)")
 	}
 
 	host := os.Getenv("REDIS_HOST")
 	if host == "" {
-		t.Fatal("missing REDIS_HOST")
+		t.Skipf("skipping (missing REDIS_HOST)")
 	}
 
 	port := os.Getenv("REDIS_PORT")
@@ -67,13 +82,7 @@ func TestStore_Take(t *testing.T) {
 				InitialPoolSize: 32,
 				MaxPoolSize:     32,
 				AuthPassword:    pass,
-				DialFunc: func() (net.Conn, error) {
-					conn, err := net.Dial("tcp", host+":"+port)
-					if err != nil {
-						return nil, err
-					}
-					return conn, nil
-				},
+				DialFunc:        TCPDialFunc(host + ":" + port),
 			})
 			if err != nil {
 				t.Fatal(err)
@@ -90,8 +99,11 @@ func TestStore_Take(t *testing.T) {
 			takeCh := make(chan *result, 2*tc.tokens)
 			for i := uint64(1); i <= 2*tc.tokens; i++ {
 				go func() {
-					limit, remaining, reset, ok := s.Take(key)
-					takeCh <- &result{limit, remaining, time.Until(time.Unix(0, int64(reset))), ok}
+					res, err := s.Take(ctx, key)
+					if err != nil {
+						t.Error(err)
+					}
+					takeCh <- &result{res.Limit, res.Remaining, time.Until(res.ResetAt), res.Allowed}
 				}()
 			}
 
@@ -142,9 +154,1600 @@ func TestStore_Take(t *testing.T) {
 			time.Sleep(tc.interval)
 
 			// Verify we can take once more
-			if _, _, _, ok := s.Take(key); !ok {
-				t.Errorf("expected %t to be %t", ok, true)
+			if res, _ := s.Take(ctx, key); !res.Allowed {
+				t.Errorf("expected %t to be %t", res.Allowed, true)
+			}
+		})
+	}
+}
+
+// testRedisConfig returns the configuration of a store on the Redis server of
+// REDIS_HOST, or skips the test if there is none.
+func testRedisConfig(tb testing.TB) *Config {
+	tb.Helper()
+
+	if testing.Short() {
+		tb.Skipf("skipping (short)")
+	}
+
+	host := os.Getenv("REDIS_HOST")
+	if host == "" {
+		tb.Skipf("skipping (missing REDIS_HOST)")
+	}
+
+	port := os.Getenv("REDIS_PORT")
+	if port == "" {
+		port = "6379"
+	}
+
+	return &Config{
+		InitialPoolSize: 32,
+		MaxPoolSize:     32,
+		AuthPassword:    os.Getenv("REDIS_PASS"),
+		DialFunc:        TCPDialFunc(host + ":" + port),
+	}
+}
+
+func testStoreFactory(tb testing.TB, c *storetest.Config) limiter.Store {
+	tb.Helper()
+
+	ttl := uint64(c.TTL.Seconds())
+	if ttl == 0 {
+		ttl = 1
+	}
+
+	config := testRedisConfig(tb)
+	config.Tokens = c.Tokens
+	config.Interval = c.Interval
+	config.TTL = ttl
+
+	s, err := New(config)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+func TestStore_Conformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.TestStore(t, testStoreFactory)
+}
+
+func BenchmarkStore(b *testing.B) {
+	storetest.BenchmarkStore(b, testStoreFactory)
+}
+
+func TestStore_Hermetic_Conformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.TestStore(t, func(tb testing.TB, c *storetest.Config) limiter.Store {
+		tb.Helper()
+
+		f := newFakeRedis(tb)
+
+		ttl := uint64(c.TTL.Seconds())
+		if ttl == 0 {
+			ttl = 1
+		}
+
+		s, err := New(&Config{
+			Tokens:          c.Tokens,
+			Interval:        c.Interval,
+			TTL:             ttl,
+			InitialPoolSize: 4,
+			MaxPoolSize:     16,
+			DialFunc:        f.dial,
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestStore_Hermetic_Auth(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		password string
+		err      bool
+	}{
+		{
+			name:     "correct",
+			password: "testing123",
+		},
+		{
+			name:     "incorrect",
+			password: "nope",
+			err:      true,
+		},
+		{
+			name: "missing",
+			err:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := newFakeRedis(t)
+			f.setPassword("testing123")
+
+			s, err := New(&Config{
+				AuthPassword: tc.password,
+				DialFunc:     f.dial,
+			})
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error to be %t, got %v", tc.err, err)
+			}
+			if err != nil && !errors.Is(err, ErrAuth) {
+				t.Errorf("expected %v to be %v", err, ErrAuth)
+			}
+			if s != nil {
+				s.Close()
+			}
+		})
+	}
+}
+
+func TestStore_Hermetic_Pool(t *testing.T) {
+	t.Parallel()
+
+	t.Run("initial", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		s, err := New(&Config{
+			InitialPoolSize: 3,
+			MaxPoolSize:     10,
+			DialFunc:        f.dial,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		if got, want := f.dialCount(), 3; got != want {
+			t.Errorf("expected %d to be %d", got, want)
+		}
+	})
+
+	t.Run("default_max", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		s, err := New(&Config{
+			InitialPoolSize: 3,
+			DialFunc:        f.dial,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+	})
+
+	t.Run("max", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		s, err := New(&Config{
+			Tokens:          100,
+			Interval:        time.Minute,
+			InitialPoolSize: 1,
+			MaxPoolSize:     2,
+			DialFunc:        f.dial,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		// Slow down replies so concurrent takes contend for connections.
+		f.inject(func(args []string) *fakeFault {
+			if args[0] == "EVALSHA" {
+				return &fakeFault{delay: 10 * time.Millisecond}
 			}
+			return nil
+		})
+
+		key := testKey(t)
+		done := make(chan struct{}, 20)
+		for i := 0; i < cap(done); i++ {
+			go func() {
+				s.Take(context.Background(), key)
+				done <- struct{}{}
+			}()
+		}
+		for i := 0; i < cap(done); i++ {
+			<-done
+		}
+
+		if got, want := f.dialCount(), 2; got > want {
+			t.Errorf("expected %d to be at most %d", got, want)
+		}
+	})
+
+	t.Run("dial_error", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := New(&Config{
+			DialFunc: func(ctx context.Context) (net.Conn, error) {
+				return nil, fmt.Errorf("connection refused")
+			},
+		}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+// deadlineConn sets a read and write deadline before each operation, like a
+// DialFunc configured with a per-command timeout.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+func TestStore_Hermetic_Faults(t *testing.T) {
+	t.Parallel()
+
+	isEval := func(args []string) bool {
+		return args[0] == "EVAL" || args[0] == "EVALSHA"
+	}
+
+	cases := []struct {
+		name  string
+		fault *fakeFault
+	}{
+		{
+			name:  "error_reply",
+			fault: &fakeFault{reply: "-ERR something went wrong\r\n"},
+		},
+		{
+			name:  "noscript",
+			fault: &fakeFault{reply: "-NOSCRIPT No matching script. Please use EVAL.\r\n"},
+		},
+		{
+			name:  "malformed_reply",
+			fault: &fakeFault{reply: "+OK\r\n"},
+		},
+		{
+			name:  "short_array",
+			fault: &fakeFault{reply: "*1\r\n:1\r\n"},
+		},
+		{
+			name:  "unknown_type",
+			fault: &fakeFault{reply: "~nope\r\n"},
+		},
+		{
+			name:  "dropped_connection",
+			fault: &fakeFault{drop: true},
+		},
+		{
+			name:  "timeout",
+			fault: &fakeFault{delay: 500 * time.Millisecond},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		for _, mode := range []FailureMode{FailClosed, FailOpen} {
+			mode := mode
+
+			t.Run(fmt.Sprintf("%s/%d", tc.name, mode), func(t *testing.T) {
+				t.Parallel()
+
+				f := newFakeRedis(t)
+				s, err := New(&Config{
+					Tokens:          5,
+					Interval:        time.Minute,
+					InitialPoolSize: 1,
+					MaxPoolSize:     1,
+					FailureMode:     mode,
+					DialFunc: func(ctx context.Context) (net.Conn, error) {
+						conn, err := f.dial(ctx)
+						if err != nil {
+							return nil, err
+						}
+						return &deadlineConn{Conn: conn, timeout: 100 * time.Millisecond}, nil
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer s.Close()
+
+				f.inject(func(args []string) *fakeFault {
+					if isEval(args) {
+						return tc.fault
+					}
+					return nil
+				})
+
+				start := time.Now()
+				res, err := s.Take(context.Background(), testKey(t))
+				if err == nil {
+					t.Errorf("expected error")
+				}
+				if got, want := res.Allowed, mode == FailOpen; got != want {
+					t.Errorf("ok: expected %t to be %t", got, want)
+				}
+				if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+					t.Errorf("expected take to fail fast, took %s", elapsed)
+				}
+			})
+		}
+	}
+}
+
+func TestSetFailureMode(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:   5,
+		Interval: time.Minute,
+		DialFunc: f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	f.inject(func(args []string) *fakeFault {
+		if args[0] == "EVAL" || args[0] == "EVALSHA" {
+			return &fakeFault{reply: "-ERR something went wrong\r\n"}
+		}
+		return nil
+	})
+
+	ctx := context.Background()
+	for _, mode := range []FailureMode{FailOpen, FailClosed, FailOpen} {
+		if err := SetFailureMode(s, mode); err != nil {
+			t.Fatal(err)
+		}
+		res, err := s.Take(ctx, testKey(t))
+		if err == nil {
+			t.Errorf("expected error")
+		}
+		if got, want := res.Allowed, mode == FailOpen; got != want {
+			t.Errorf("mode %d: expected %t to be %t", mode, got, want)
+		}
+	}
+
+	if err := SetFailureMode(s, 0); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+	if err := SetFailureMode(limiter.Store(nil), FailOpen); err == nil {
+		t.Error("expected error for other stores")
+	}
+	if _, err := New(&Config{DialFunc: f.dial, FailureMode: 5}); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestStore_FailPartial(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:           5,
+		Interval:         time.Minute,
+		FailureMode:      FailPartial,
+		FailOpenFraction: 0.25,
+		DialFunc:         f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	f.inject(func(args []string) *fakeFault {
+		if args[0] == "EVAL" || args[0] == "EVALSHA" {
+			return &fakeFault{reply: "-ERR something went wrong\r\n"}
+		}
+		return nil
+	})
+
+	ctx := context.Background()
+	var allowed int
+	for i := 0; i < 400; i++ {
+		key := fmt.Sprintf("key%d", i)
+
+		res, err := s.Take(ctx, key)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if res.Allowed {
+			allowed++
+		}
+
+		// The same keys are allowed on every take.
+		again, _ := s.Take(ctx, key)
+		if got, want := again.Allowed, res.Allowed; got != want {
+			t.Errorf("%s: expected %t to be %t", key, got, want)
+		}
+	}
+	if allowed < 60 || allowed > 140 {
+		t.Errorf("expected about 100 of 400 keys to be allowed, got %d", allowed)
+	}
+
+	if _, err := New(&Config{DialFunc: f.dial, FailOpenFraction: 1.5}); err == nil {
+		t.Error("expected error for invalid fraction")
+	}
+}
+
+func TestStore_Interceptor(t *testing.T) {
+	t.Parallel()
+
+	t.Run("sees_all_commands", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+
+		var lock sync.Mutex
+		var cmds []string
+		s, err := New(&Config{
+			InitialPoolSize: 1,
+			MaxPoolSize:     1,
+			DialFunc:        f.dial,
+			Interceptor: func(args []string, invoke Invoker) ([]byte, error) {
+				lock.Lock()
+				cmds = append(cmds, args[0])
+				lock.Unlock()
+				return invoke(args)
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		if res, err := s.Take(context.Background(), testKey(t)); !res.Allowed || err != nil {
+			t.Errorf("expected take to succeed")
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		if got, want := strings.Join(cmds, ","), "PING,SCRIPT,SCRIPT,EVALSHA"; got != want {
+			t.Errorf("expected %q to be %q", got, want)
+		}
+	})
+
+	cases := []struct {
+		name        string
+		interceptor Interceptor
+		ok          func(mode FailureMode) bool
+		minDuration time.Duration
+	}{
+		{
+			name: "latency",
+			interceptor: func(args []string, invoke Invoker) ([]byte, error) {
+				if args[0] == "EVALSHA" {
+					time.Sleep(50 * time.Millisecond)
+				}
+				return invoke(args)
+			},
+			ok:          func(FailureMode) bool { return true },
+			minDuration: 50 * time.Millisecond,
+		},
+		{
+			name: "dropped_connection",
+			interceptor: func(args []string, invoke Invoker) ([]byte, error) {
+				if args[0] == "EVALSHA" {
+					return nil, io.ErrUnexpectedEOF
+				}
+				return invoke(args)
+			},
+			ok: func(mode FailureMode) bool { return mode == FailOpen },
+		},
+		{
+			name: "error_reply",
+			interceptor: func(args []string, invoke Invoker) ([]byte, error) {
+				if args[0] == "EVALSHA" {
+					return []byte("-BUSY Redis is busy running a script\r\n"), nil
+				}
+				return invoke(args)
+			},
+			ok: func(mode FailureMode) bool { return mode == FailOpen },
+		},
+		{
+			name: "malformed_reply",
+			interceptor: func(args []string, invoke Invoker) ([]byte, error) {
+				if args[0] == "EVALSHA" {
+					return []byte("*3\r\n:1\r\n"), nil
+				}
+				return invoke(args)
+			},
+			ok: func(mode FailureMode) bool { return mode == FailOpen },
+		},
+		{
+			name: "rewritten_reply",
+			interceptor: func(args []string, invoke Invoker) ([]byte, error) {
+				if args[0] == "EVALSHA" {
+					if _, err := invoke(args); err != nil {
+						return nil, err
+					}
+					return []byte("*4\r\n:0\r\n:0\r\n$-1\r\n:1000\r\n"), nil
+				}
+				return invoke(args)
+			},
+			ok: func(FailureMode) bool { return false },
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		for _, mode := range []FailureMode{FailClosed, FailOpen} {
+			mode := mode
+
+			t.Run(fmt.Sprintf("%s/%d", tc.name, mode), func(t *testing.T) {
+				t.Parallel()
+
+				f := newFakeRedis(t)
+				s, err := New(&Config{
+					Tokens:          5,
+					Interval:        time.Minute,
+					InitialPoolSize: 1,
+					MaxPoolSize:     1,
+					FailureMode:     mode,
+					DialFunc:        f.dial,
+					Interceptor:     tc.interceptor,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer s.Close()
+
+				start := time.Now()
+				res, _ := s.Take(context.Background(), testKey(t))
+				if got, want := res.Allowed, tc.ok(mode); got != want {
+					t.Errorf("ok: expected %t to be %t", got, want)
+				}
+				if elapsed := time.Since(start); elapsed < tc.minDuration {
+					t.Errorf("expected take to take at least %s, took %s", tc.minDuration, elapsed)
+				}
+			})
+		}
+	}
+}
+
+func TestStore_ErrorPaths(t *testing.T) {
+	t.Parallel()
+
+	t.Run("pool_error", func(t *testing.T) {
+		t.Parallel()
+
+		for _, mode := range []FailureMode{FailClosed, FailOpen} {
+			mode := mode
+
+			t.Run(fmt.Sprintf("%d", mode), func(t *testing.T) {
+				t.Parallel()
+
+				f := newFakeRedis(t)
+
+				var lock sync.Mutex
+				dialErr := error(nil)
+				s, err := New(&Config{
+					InitialPoolSize: 1,
+					MaxPoolSize:     1,
+					FailureMode:     mode,
+					DialFunc: func(ctx context.Context) (net.Conn, error) {
+						lock.Lock()
+						defer lock.Unlock()
+						if dialErr != nil {
+							return nil, dialErr
+						}
+						return f.dial(ctx)
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer s.Close()
+
+				// Drop the only connection so it's discarded, then make future dials
+				// fail so the pool cannot hand out a client.
+				lock.Lock()
+				dialErr = fmt.Errorf("connection refused")
+				lock.Unlock()
+				f.inject(func(args []string) *fakeFault {
+					return &fakeFault{drop: true}
+				})
+
+				ctx := context.Background()
+				for i := 0; i < 2; i++ {
+					res, err := s.Take(ctx, testKey(t))
+					if err == nil {
+						t.Errorf("take %d: expected error", i)
+					}
+					if got, want := res.Allowed, mode == FailOpen; got != want {
+						t.Errorf("take %d: ok: expected %t to be %t", i, got, want)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("redials_after_dropped_connection", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		s, err := New(&Config{
+			InitialPoolSize: 1,
+			MaxPoolSize:     1,
+			DialFunc:        f.dial,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		var once sync.Once
+		f.inject(func(args []string) *fakeFault {
+			var ft *fakeFault
+			once.Do(func() {
+				ft = &fakeFault{drop: true}
+			})
+			return ft
+		})
+
+		ctx := context.Background()
+		if res, err := s.Take(ctx, testKey(t)); res.Allowed || err == nil {
+			t.Fatalf("expected first take to fail, got %t, %v", res.Allowed, err)
+		}
+		if res, err := s.Take(ctx, testKey(t)); !res.Allowed || err != nil {
+			t.Fatalf("expected second take to succeed, got %t, %v", res.Allowed, err)
+		}
+		if got, want := f.dialCount(), 2; got != want {
+			t.Errorf("dials: expected %d to be %d", got, want)
+		}
+	})
+
+	t.Run("keeps_connection_after_error_reply", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		s, err := New(&Config{
+			InitialPoolSize: 1,
+			MaxPoolSize:     1,
+			DialFunc:        f.dial,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		var once sync.Once
+		f.inject(func(args []string) *fakeFault {
+			var ft *fakeFault
+			once.Do(func() {
+				ft = &fakeFault{reply: "-ERR oops\r\n"}
+			})
+			return ft
+		})
+
+		ctx := context.Background()
+		if _, err := s.Take(ctx, testKey(t)); err == nil {
+			t.Fatal("expected first take to fail")
+		}
+		if res, err := s.Take(ctx, testKey(t)); !res.Allowed || err != nil {
+			t.Fatalf("expected second take to succeed, got %t, %v", res.Allowed, err)
+		}
+		if got, want := f.dialCount(), 1; got != want {
+			t.Errorf("dials: expected %d to be %d", got, want)
+		}
+	})
+
+	t.Run("context_canceled_waiting_for_client", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		s, err := New(&Config{
+			InitialPoolSize: 1,
+			MaxPoolSize:     1,
+			DialFunc:        f.dial,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		f.inject(func(args []string) *fakeFault {
+			return &fakeFault{delay: 300 * time.Millisecond}
+		})
+
+		// Occupy the only client.
+		go s.Take(context.Background(), testKey(t))
+		time.Sleep(50 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		res, err := s.Take(ctx, testKey(t))
+		if res.Allowed {
+			t.Error("expected take to fail")
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected %v to be %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		s, err := New(&Config{
+			FailureMode: FailOpen,
+			DialFunc:    f.dial,
 		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+
+		res, err := s.Take(context.Background(), testKey(t))
+		if res.Allowed {
+			t.Error("expected take on stopped store to fail, even when failing open")
+		}
+		if !errors.Is(err, limiter.ErrStopped) {
+			t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+		}
+	})
+}
+
+func TestStore_ClockSkew(t *testing.T) {
+	t.Parallel()
+
+	for _, offset := range []time.Duration{-time.Hour, time.Hour} {
+		offset := offset
+
+		t.Run(offset.String(), func(t *testing.T) {
+			t.Parallel()
+
+			f := newFakeRedis(t)
+			f.setClockOffset(offset)
+
+			interval := 10 * time.Second
+			s, err := New(&Config{
+				Tokens:   1,
+				Interval: interval,
+				DialFunc: f.dial,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			ctx := context.Background()
+			key := testKey(t)
+			if _, err := s.Take(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := s.Take(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Allowed {
+				t.Fatal("expected take to be denied")
+			}
+			if until := time.Until(res.ResetAt); until <= 0 || until > interval {
+				t.Errorf("reset: expected %s to be in (0, %s]", until, interval)
+			}
+			if res.RetryAfter <= 0 || res.RetryAfter > interval {
+				t.Errorf("retry after: expected %s to be in (0, %s]", res.RetryAfter, interval)
+			}
+		})
+	}
+}
+
+func TestStore_ScriptPriming(t *testing.T) {
+	t.Parallel()
+
+	for _, multiplex := range []bool{false, true} {
+		multiplex := multiplex
+
+		t.Run(fmt.Sprintf("flush/multiplex=%t", multiplex), func(t *testing.T) {
+			t.Parallel()
+
+			f := newFakeRedis(t)
+			s, err := New(&Config{
+				Tokens:          5,
+				Interval:        time.Minute,
+				InitialPoolSize: 1,
+				MaxPoolSize:     1,
+				DialFunc:        f.dial,
+				Multiplex:       multiplex,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			ctx := context.Background()
+			key := testKey(t)
+			if _, err := s.Take(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+
+			f.exec([]string{"SCRIPT", "FLUSH"})
+
+			for i := 0; i < 2; i++ {
+				res, err := s.Take(ctx, key)
+				if err != nil {
+					t.Fatalf("take %d: %v", i, err)
+				}
+				if got, want := res.Remaining, uint64(3-i); got != want {
+					t.Errorf("take %d: remaining: expected %d to be %d", i, got, want)
+				}
+			}
+			if got, want := f.dialCount(), 1; got != want {
+				t.Errorf("dials: expected %d to be %d", got, want)
+			}
+		})
+
+		t.Run(fmt.Sprintf("failover/multiplex=%t", multiplex), func(t *testing.T) {
+			t.Parallel()
+
+			primary, replica := newFakeRedis(t), newFakeRedis(t)
+
+			var lock sync.Mutex
+			current := primary
+			s, err := New(&Config{
+				InitialPoolSize: 1,
+				MaxPoolSize:     1,
+				DialFunc: func(ctx context.Context) (net.Conn, error) {
+					lock.Lock()
+					f := current
+					lock.Unlock()
+					return f.dial(ctx)
+				},
+				Multiplex: multiplex,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			var cmds []string
+			replica.inject(func(args []string) *fakeFault {
+				lock.Lock()
+				defer lock.Unlock()
+				cmds = append(cmds, args[0])
+				return nil
+			})
+
+			lock.Lock()
+			current = replica
+			lock.Unlock()
+			primary.close()
+
+			ctx := context.Background()
+			if _, err := s.Take(ctx, testKey(t)); err == nil {
+				t.Fatal("expected take on failed primary to fail")
+			}
+			if res, err := s.Take(ctx, testKey(t)); !res.Allowed || err != nil {
+				t.Fatalf("expected take on replica to succeed, got %t, %v", res.Allowed, err)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			if got, want := strings.Join(cmds, ","), "PING,SCRIPT,SCRIPT,EVALSHA"; got != want {
+				t.Errorf("expected %q to be %q", got, want)
+			}
+		})
+	}
+}
+
+func TestStore_SharedScripts(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	newStore := func(tb testing.TB, tokens uint64, interval time.Duration) *store {
+		tb.Helper()
+
+		s, err := New(&Config{
+			Tokens:          tokens,
+			Interval:        interval,
+			InitialPoolSize: 1,
+			DialFunc:        f.dial,
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { s.Close() })
+		return s.(*store)
+	}
+
+	// Stores of different limits run the same scripts with their own
+	// configuration, so changing the limits does not load new scripts.
+	a := newStore(t, 2, time.Minute)
+	b := newStore(t, 5, time.Hour)
+	if got, want := a.luaScriptSHA, b.luaScriptSHA; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if got, want := a.luaRefundScriptSHA, b.luaRefundScriptSHA; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+
+	f.lock.Lock()
+	scripts := len(f.scripts)
+	f.lock.Unlock()
+	if got, want := scripts, 2; got != want {
+		t.Errorf("expected %d scripts to be %d", got, want)
+	}
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		store *store
+		limit uint64
+	}{
+		{store: a, limit: 2},
+		{store: b, limit: 5},
+	} {
+		res, err := tc.store.Take(ctx, testKey(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := res.Limit, tc.limit; got != want {
+			t.Errorf("limit: expected %d to be %d", got, want)
+		}
+		if got, want := res.Remaining, tc.limit-1; got != want {
+			t.Errorf("remaining: expected %d to be %d", got, want)
+		}
+	}
+}
+
+func TestStore_TakeWith(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:          10,
+		Interval:        time.Minute,
+		InitialPoolSize: 1,
+		DialFunc:        f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	key := testKey(t)
+	o := s.(limiter.Overrider)
+
+	// The overridden limit applies to the key without changing the store's.
+	for i, want := range []bool{true, true, false} {
+		res, err := o.TakeWith(ctx, key, 2, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Allowed; got != want {
+			t.Errorf("take %d: expected %t to be %t", i, got, want)
+		}
+		if got, want := res.Limit, uint64(2); got != want {
+			t.Errorf("take %d: expected %d to be %d", i, got, want)
+		}
+		if !res.Allowed && res.RetryAfter <= time.Minute {
+			t.Errorf("take %d: expected retry after %s to exceed a minute", i, res.RetryAfter)
+		}
+	}
+
+	res, err := s.Take(ctx, key+"-other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Remaining, uint64(9); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	if _, err := o.TakeWith(ctx, key, 0, time.Minute); err == nil {
+		t.Error("expected error for zero tokens")
+	}
+}
+
+func TestStore_Functions(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+
+	var lock sync.Mutex
+	var cmds []string
+	f.inject(func(args []string) *fakeFault {
+		lock.Lock()
+		defer lock.Unlock()
+		cmds = append(cmds, args[0])
+		return nil
+	})
+
+	s, err := New(&Config{
+		Tokens:          5,
+		Interval:        time.Minute,
+		InitialPoolSize: 1,
+		MaxPoolSize:     1,
+		Functions:       true,
+		DialFunc:        f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	key := testKey(t)
+	if _, err := s.Take(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// The library is loaded again if the functions are flushed.
+	f.exec([]string{"FUNCTION", "FLUSH"})
+	res, err := s.Take(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Remaining, uint64(3); got != want {
+		t.Errorf("remaining: expected %d to be %d", got, want)
+	}
+
+	if err := s.(*store).Refund(ctx, key, 1); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = s.Take(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Remaining, uint64(3); got != want {
+		t.Errorf("remaining after refund: expected %d to be %d", got, want)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if got, want := strings.Join(cmds, ","), "PING,FUNCTION,FCALL,FCALL,FUNCTION,FCALL,FCALL,FCALL"; got != want {
+		t.Errorf("commands: expected %s to be %s", got, want)
+	}
+}
+
+func TestStore_Functions_versions(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	ctx := context.Background()
+	key := testKey(t)
+
+	newStore := func(tb testing.TB, functions bool) limiter.Store {
+		tb.Helper()
+
+		s, err := New(&Config{
+			Tokens:    5,
+			Interval:  time.Minute,
+			Functions: functions,
+			DialFunc:  f.dial,
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { s.Close() })
+		return s
+	}
+
+	s := newStore(t, true).(*store)
+	if _, err := s.Take(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another version that only changed the limiter script loads its own
+	// library, with its own name for the unchanged refund script, and does not
+	// replace the store's.
+	script := s.luaScript + "\n-- another version\n"
+	shas := []string{fmt.Sprintf("%x", sha1.Sum([]byte(script))), s.luaRefundScriptSHA}
+	if got, want := f.exec([]string{"FUNCTION", "LOAD", "REPLACE", luaLibrary([]string{script, s.luaRefundScript}, shas)}), bulk(libraryName(shas)); got != want {
+		t.Fatalf("expected %q to be %q", got, want)
+	}
+
+	// Stores of either mode keep sharing the bucket.
+	for i, store := range []limiter.Store{s, newStore(t, true), newStore(t, false)} {
+		res, err := store.Take(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := res.Remaining, uint64(3-i); got != want {
+			t.Errorf("store %d: expected %d to be %d", i, got, want)
+		}
+	}
+	if err := s.Refund(ctx, key, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if got, want := len(f.libraries), 2; got != want {
+		t.Errorf("libraries: expected %d to be %d", got, want)
+	}
+}
+
+func TestStore_Functions_redis(t *testing.T) {
+	t.Parallel()
+
+	// The fake only approximates how a server loads and calls functions, so
+	// the suite runs against a real one too.
+	storetest.TestStore(t, func(tb testing.TB, c *storetest.Config) limiter.Store {
+		tb.Helper()
+
+		ttl := uint64(c.TTL.Seconds())
+		if ttl == 0 {
+			ttl = 1
+		}
+
+		config := testRedisConfig(tb)
+		config.Tokens = c.Tokens
+		config.Interval = c.Interval
+		config.TTL = ttl
+		config.Functions = true
+
+		s, err := New(config)
+		if err != nil {
+			if strings.Contains(err.Error(), "unknown command") {
+				tb.Skipf("skipping (server does not support functions)")
+			}
+			tb.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestStore_Global(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:       3,
+		Interval:     time.Minute,
+		GlobalTokens: 4,
+		DialFunc:     f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	key1, key2 := testKey(t), testKey(t)
+
+	cases := []struct {
+		key       string
+		allowed   bool
+		remaining uint64
+	}{
+		{key: key1, allowed: true, remaining: 2},
+		{key: key1, allowed: true, remaining: 1},
+		{key: key1, allowed: true, remaining: 0},
+		{key: key2, allowed: true, remaining: 0},
+		{key: key2, allowed: false, remaining: 0},
+		{key: key1, allowed: false, remaining: 0},
+	}
+
+	for i, tc := range cases {
+		res, err := s.Take(ctx, tc.key)
+		if err != nil {
+			t.Fatalf("take %d: %v", i, err)
+		}
+		if got, want := res.Allowed, tc.allowed; got != want {
+			t.Errorf("take %d: allowed: expected %t to be %t", i, got, want)
+		}
+		if got, want := res.Remaining, tc.remaining; got != want {
+			t.Errorf("take %d: remaining: expected %d to be %d", i, got, want)
+		}
+		if got, want := res.Limit, uint64(3); got != want {
+			t.Errorf("take %d: limit: expected %d to be %d", i, got, want)
+		}
+	}
+
+	// Rejecting the take on the global bucket must not spend the key's token.
+	if err := s.(limiter.Refunder).Refund(ctx, key1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := s.Take(ctx, key2); !res.Allowed || err != nil {
+		t.Errorf("expected take after global refund to succeed, got %t, %v", res.Allowed, err)
+	}
+	if res, err := s.Take(ctx, key2); res.Allowed || err != nil {
+		t.Errorf("expected take to be denied by global bucket, got %t, %v", res.Allowed, err)
+	}
+}
+
+func TestStore_Priority(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:           10,
+		Interval:         time.Minute,
+		ReservedFraction: 0.3,
+		DialFunc:         f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	low := limiter.WithPriority(ctx, limiter.PriorityLow)
+	key := testKey(t)
+
+	for i := 0; i < 7; i++ {
+		if res, err := s.Take(low, key); !res.Allowed || err != nil {
+			t.Fatalf("low take %d: expected to succeed, got %t, %v", i, res.Allowed, err)
+		}
+	}
+	if res, err := s.Take(low, key); res.Allowed || err != nil {
+		t.Fatalf("expected low take to be rejected at the reserve, got %t, %v", res.Allowed, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if res, err := s.Take(ctx, key); !res.Allowed || err != nil {
+			t.Fatalf("high take %d: expected to succeed, got %t, %v", i, res.Allowed, err)
+		}
+	}
+	if res, err := s.Take(ctx, key); res.Allowed || err != nil {
+		t.Fatalf("expected high take to be rejected when empty, got %t, %v", res.Allowed, err)
+	}
+}
+
+func TestStore_Debt(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:    2,
+		Interval:  time.Minute,
+		DebtLimit: 2,
+		DialFunc:  f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	key := testKey(t)
+
+	for i := 0; i < 4; i++ {
+		res, err := s.Take(ctx, key)
+		if !res.Allowed || err != nil {
+			t.Fatalf("take %d: expected to succeed, got %t, %v", i, res.Allowed, err)
+		}
+		if i >= 2 {
+			if got, want := res.Remaining, uint64(0); got != want {
+				t.Errorf("take %d: remaining: expected %d to be %d", i, got, want)
+			}
+		}
+	}
+	if res, err := s.Take(ctx, key); res.Allowed || err != nil {
+		t.Fatalf("expected take to be rejected at the debt limit, got %t, %v", res.Allowed, err)
+	}
+
+	// Refunds pay down the debt before returning tokens.
+	if err := s.(limiter.Refunder).Refund(ctx, key, 1); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := s.Take(ctx, key); !res.Allowed || err != nil {
+		t.Fatalf("expected take to borrow after refund, got %t, %v", res.Allowed, err)
+	}
+	if res, err := s.Take(ctx, key); res.Allowed || err != nil {
+		t.Fatalf("expected take to be rejected at the debt limit, got %t, %v", res.Allowed, err)
+	}
+
+	// Low-priority takes never borrow.
+	low := limiter.WithPriority(ctx, limiter.PriorityLow)
+	lowKey := testKey(t) + "-low"
+	for i := 0; i < 2; i++ {
+		if res, err := s.Take(low, lowKey); !res.Allowed || err != nil {
+			t.Fatalf("low take %d: expected to succeed, got %t, %v", i, res.Allowed, err)
+		}
+	}
+	if res, err := s.Take(low, lowKey); res.Allowed || err != nil {
+		t.Fatalf("expected low take to be rejected when empty, got %t, %v", res.Allowed, err)
+	}
+}
+
+func TestStore_StaggerResets(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:        10,
+		Interval:      time.Hour,
+		StaggerResets: true,
+		DialFunc:      f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	local, err := memorystore.New(&memorystore.Config{
+		Tokens:        10,
+		Interval:      time.Hour,
+		StaggerResets: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+
+	ctx := context.Background()
+	resets := make(map[int64]struct{})
+	for i := 0; i < 10; i++ {
+		key := testKey(t)
+
+		peek, err := s.(limiter.Peeker).Peek(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := s.Take(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Until(res.ResetAt); d <= 0 || d > time.Hour {
+			t.Errorf("expected reset within an interval, got %s", d)
+		}
+		if d := peek.ResetAt.Sub(res.ResetAt); d < -time.Second || d > time.Second {
+			t.Errorf("expected peek %s to be %s", peek.ResetAt, res.ResetAt)
+		}
+
+		// The key resets at the same time as in memorystore.
+		other, err := local.Take(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := other.ResetAt.Sub(res.ResetAt); d < -time.Second || d > time.Second {
+			t.Errorf("expected %s to be %s", other.ResetAt, res.ResetAt)
+		}
+		resets[res.ResetAt.Unix()/60] = struct{}{}
+	}
+
+	if len(resets) < 2 {
+		t.Errorf("expected keys to reset at different times")
+	}
+}
+
+func TestStore_SetMetadata(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:   10,
+		Interval: time.Minute,
+		DialFunc: f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	key := testKey(t)
+
+	// Setting the metadata creates the key, and takes keep it.
+	if err := s.(limiter.Annotator).SetMetadata(ctx, key, "plan=free"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Take(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.(limiter.Peeker).Peek(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Metadata, "plan=free"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if got, want := res.Remaining, uint64(9); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	if _, ok, err := ReadState(ctx, s, key); !ok || err != nil {
+		t.Errorf("expected state of key with metadata, got %t, %v", ok, err)
+	}
+	report, err := Verify(ctx, s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Anomalies) > 0 {
+		t.Errorf("expected no problems, got %v", report.Anomalies)
+	}
+}
+
+func TestStore_TTLMode(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		mode      limiter.TTLMode
+		refreshed bool
+	}{
+		{
+			name:      "sliding",
+			mode:      limiter.TTLSliding,
+			refreshed: true,
+		},
+		{
+			name:      "fixed",
+			mode:      limiter.TTLFixed,
+			refreshed: false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := newFakeRedis(t)
+			s, err := New(&Config{
+				Tokens:   10,
+				Interval: time.Minute,
+				TTLMode:  tc.mode,
+				DialFunc: f.dial,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			ctx := context.Background()
+			key := testKey(t)
+
+			expiry := func() time.Time {
+				f.lock.Lock()
+				defer f.lock.Unlock()
+				return f.expires[key]
+			}
+
+			if _, err := s.Take(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+			first := expiry()
+
+			time.Sleep(10 * time.Millisecond)
+			if _, err := s.Take(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := expiry().After(first), tc.refreshed; got != want {
+				t.Errorf("refreshed: expected %t to be %t", got, want)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFakeRedis(t)
+		if _, err := New(&Config{
+			TTLMode:  limiter.TTLMode(99),
+			DialFunc: f.dial,
+		}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestStore_MixedVersions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:       5,
+		Interval:     time.Hour,
+		KeyPrefix:    "rl:",
+		GlobalTokens: 100,
+		DialFunc:     f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	start := strconv.FormatInt(int64(f.now()), 10)
+	newer := strconv.Itoa(bucketstate.Version + 1)
+
+	// A bucket of an earlier release, before debt and versions, and one of a
+	// later release with a newer format and a field this release does not know.
+	f.lock.Lock()
+	f.data["rl:older"] = map[string]string{"s": start, "t": "0", "k": "3"}
+	f.data["rl:newer"] = map[string]string{"s": start, "t": "0", "k": "3", "d": "0", "v": newer, "x": "1"}
+	f.lock.Unlock()
+
+	snapshot := func() map[string]string {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+
+		h := make(map[string]string)
+		for k, v := range f.data["rl:newer"] {
+			h[k] = v
+		}
+		return h
+	}
+	want := snapshot()
+
+	res, err := s.Take(ctx, "older")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Remaining, uint64(2); got != want {
+		t.Errorf("expected %d to be %d", got, want)
+	}
+
+	// Every operation refuses the newer bucket, and leaves it as it was.
+	if _, err := s.Take(ctx, "newer"); !errors.Is(err, bucketstate.ErrNewerVersion) {
+		t.Errorf("take: expected %v to be %v", err, bucketstate.ErrNewerVersion)
+	}
+	if err := s.(limiter.Refunder).Refund(ctx, "newer", 1); !errors.Is(err, bucketstate.ErrNewerVersion) {
+		t.Errorf("refund: expected %v to be %v", err, bucketstate.ErrNewerVersion)
+	}
+	if err := s.(limiter.Charger).Charge(ctx, "newer", 1); !errors.Is(err, bucketstate.ErrNewerVersion) {
+		t.Errorf("charge: expected %v to be %v", err, bucketstate.ErrNewerVersion)
+	}
+	if err := s.(limiter.Annotator).SetMetadata(ctx, "newer", "plan=free"); !errors.Is(err, bucketstate.ErrNewerVersion) {
+		t.Errorf("set metadata: expected %v to be %v", err, bucketstate.ErrNewerVersion)
+	}
+	if _, err := s.(limiter.Peeker).Peek(ctx, "newer"); !errors.Is(err, bucketstate.ErrNewerVersion) {
+		t.Errorf("peek: expected %v to be %v", err, bucketstate.ErrNewerVersion)
+	}
+	if _, _, err := ReadState(ctx, s, "newer"); !errors.Is(err, bucketstate.ErrNewerVersion) {
+		t.Errorf("read state: expected %v to be %v", err, bucketstate.ErrNewerVersion)
+	}
+
+	report, err := Verify(ctx, s, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := report.Anomalies, []Anomaly{{Key: "newer", Problem: "bucket was written by a newer version"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v to be %v", got, want)
+	}
+
+	if got := snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v to be %v", got, want)
+	}
+
+	// A newer global bucket fails the takes of every key, without taking from
+	// them.
+	f.lock.Lock()
+	f.data["limiter:global"]["v"] = newer
+	f.lock.Unlock()
+	if _, err := s.Take(ctx, "older"); !errors.Is(err, bucketstate.ErrNewerVersion) {
+		t.Errorf("expected %v to be %v", err, bucketstate.ErrNewerVersion)
+	}
+	res, err = s.(limiter.Peeker).Peek(ctx, "older")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Remaining, uint64(2); got != want {
+		t.Errorf("expected %d to be %d", got, want)
 	}
 }
@@ -0,0 +1,76 @@
+package redisstore
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// defaultPort is the port used when a URL does not specify one.
+const defaultPort = "6379"
+
+// NewFromURL is like New, but takes the connection information from a Redis
+// URL of the form:
+//
+//	redis://[[username]:password@]host[:port][/database]
+//
+// The rediss:// scheme connects using TLS, configured by c.TLSConfig. The
+// DialFunc, AuthUsername, AuthPassword, and Database values from the URL take
+// precedence over c, which may be nil. All other values come from c.
+func NewFromURL(rawurl string, c *Config) (limiter.Store, error) {
+	var cfg Config
+	if c != nil {
+		cfg = *c
+	}
+
+	if err := parseURL(rawurl, &cfg); err != nil {
+		return nil, err
+	}
+	return New(&cfg)
+}
+
+// parseURL parses the Redis URL and sets the connection information on c.
+func parseURL(rawurl string, c *Config) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	host, port := u.Hostname(), u.Port()
+	if host == "" {
+		return fmt.Errorf("url is missing a host")
+	}
+	if port == "" {
+		port = defaultPort
+	}
+	addr := net.JoinHostPort(host, port)
+
+	switch u.Scheme {
+	case "redis":
+		c.DialFunc = TCPDialFunc(addr)
+	case "rediss":
+		c.DialFunc = TLSDialFunc(addr, c.TLSConfig)
+	default:
+		return fmt.Errorf("invalid url scheme %q, expected redis or rediss", u.Scheme)
+	}
+
+	c.AuthUsername, c.AuthPassword = "", ""
+	if u.User != nil {
+		c.AuthUsername = u.User.Username()
+		c.AuthPassword, _ = u.User.Password()
+	}
+
+	c.Database = 0
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid database %q", db)
+		}
+		c.Database = n
+	}
+	return nil
+}
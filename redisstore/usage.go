@@ -0,0 +1,59 @@
+package redisstore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/usage"
+)
+
+// UsageSink returns a usage.Sink that adds each record to a Redis stream with
+// XADD, using the connections of the store, so billing jobs can read usage
+// with XREAD or consumer groups. Each entry has the fields "key", "start" and
+// "end" in unix nanoseconds, "allowed", "denied", "errors", "refunded", and
+// "charged".
+//
+// The stream name is used as given, without the store's KeyPrefix. If maxLen
+// is not 0, the stream is trimmed to about that many entries. Records are
+// added one at a time, so if an XADD fails, the records added before it are
+// added again with the next write; deduplicate on key and start if that
+// matters. The store must have been created by this package.
+func UsageSink(ls limiter.Store, stream string, maxLen uint64) (usage.Sink, error) {
+	s, ok := ls.(*store)
+	if !ok {
+		return nil, fmt.Errorf("store was not created by redisstore")
+	}
+	if stream == "" {
+		return nil, fmt.Errorf("missing stream")
+	}
+
+	return usage.SinkFunc(func(ctx context.Context, records []usage.Record) error {
+		if atomic.LoadUint32(&s.stopped) == 1 {
+			return limiter.ErrStopped
+		}
+
+		for _, r := range records {
+			args := []string{"XADD", stream}
+			if maxLen > 0 {
+				args = append(args, "MAXLEN", "~", strconv.FormatUint(maxLen, 10))
+			}
+			args = append(args, "*",
+				"key", r.Key,
+				"start", strconv.FormatInt(r.Start.UnixNano(), 10),
+				"end", strconv.FormatInt(r.End.UnixNano(), 10),
+				"allowed", strconv.FormatUint(r.Allowed, 10),
+				"denied", strconv.FormatUint(r.Denied, 10),
+				"errors", strconv.FormatUint(r.Errors, 10),
+				"refunded", strconv.FormatUint(r.Refunded, 10),
+				"charged", strconv.FormatUint(r.Charged, 10),
+			)
+			if _, err := s.conns.do(ctx, args...); err != nil {
+				return fmt.Errorf("failed to add usage to stream: %w", err)
+			}
+		}
+		return nil
+	}), nil
+}
@@ -0,0 +1,59 @@
+package redisstore
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/usage"
+)
+
+func TestUsageSink(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:   5,
+		Interval: time.Minute,
+		DialFunc: f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	sink, err := UsageSink(s, "usage", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	end := start.Add(time.Minute)
+	if err := sink.Write(context.Background(), []usage.Record{
+		{Key: "a", Start: start, End: end, Allowed: 1},
+		{Key: "b", Start: start, End: end, Allowed: 2, Denied: 3, Errors: 4, Refunded: 5, Charged: 6},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	f.lock.Lock()
+	entries := f.streams["usage"]
+	f.lock.Unlock()
+
+	// The stream is trimmed to the last entry.
+	want := [][]string{{
+		"key", "b",
+		"start", strconv.FormatInt(start.UnixNano(), 10),
+		"end", strconv.FormatInt(end.UnixNano(), 10),
+		"allowed", "2",
+		"denied", "3",
+		"errors", "4",
+		"refunded", "5",
+		"charged", "6",
+	}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("expected %q to be %q", entries, want)
+	}
+}
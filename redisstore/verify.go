@@ -0,0 +1,245 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/bucketstate"
+)
+
+// bucketFields are the hash fields of a bucket, and whether each is required.
+// The debt field was added later, so buckets written by older versions do not
+// have it, and the version field is only set after version 1.
+var bucketFields = map[string]bool{
+	bucketstate.FieldStart:   true,
+	bucketstate.FieldTick:    true,
+	bucketstate.FieldTokens:  true,
+	bucketstate.FieldDebt:    false,
+	bucketstate.FieldVersion: false,
+}
+
+// VerifyReport is the result of Verify.
+type VerifyReport struct {
+	// Keys is the number of keys checked.
+	Keys uint64
+
+	// Anomalies are the problems found, ordered by key.
+	Anomalies []Anomaly
+}
+
+// Anomaly is a problem with a stored bucket.
+type Anomaly struct {
+	// Key is the key, without the KeyPrefix.
+	Key string
+
+	// Problem describes what is wrong.
+	Problem string
+
+	// Fixed is whether the problem was fixed.
+	Fixed bool
+}
+
+// Verify checks that every key with the store's KeyPrefix, and the global key,
+// is a bucket consistent with the store's configuration: a hash with only the
+// expected fields, holding numbers within the configured limits, and with a
+// TTL. It is intended to be run after upgrades or configuration changes.
+//
+// If fix is set, keys that are not valid buckets are deleted, so they start
+// again with full buckets, and keys without a TTL are given one. Buckets
+// written by a newer version are reported, but never fixed, so running Verify
+// during a rolling upgrade does not delete them. Like Stats,
+// it walks every key, so it is only intended for admin tools. The store must
+// have been created by this package, and must not be using redis-cell.
+func Verify(ctx context.Context, ls limiter.Store, fix bool) (*VerifyReport, error) {
+	s, ok := ls.(*store)
+	if !ok {
+		return nil, fmt.Errorf("store was not created by redisstore")
+	}
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return nil, limiter.ErrStopped
+	}
+	if s.cell {
+		return nil, fmt.Errorf("cannot verify buckets written by redis-cell: %w", limiter.ErrNotSupported)
+	}
+
+	now, err := s.serverTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := new(VerifyReport)
+	check := func(key string, global bool) error {
+		report.Keys++
+		anomalies, err := s.verifyKey(ctx, key, global, now, fix)
+		if err != nil {
+			return err
+		}
+		report.Anomalies = append(report.Anomalies, anomalies...)
+		return nil
+	}
+
+	pattern := escapePattern(s.keyPrefix) + "*"
+	var cursor uint64
+	for {
+		keys, next, err := s.scan(ctx, pattern, cursor)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if err := check(key, false); err != nil {
+				return nil, err
+			}
+		}
+
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	if s.globalKey != "" {
+		resp, err := s.conns.do(ctx, "EXISTS", s.globalKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check global key: %w", err)
+		}
+		if resp.int64() == 1 {
+			if err := check(s.globalKey, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.SliceStable(report.Anomalies, func(i, j int) bool {
+		return report.Anomalies[i].Key < report.Anomalies[j].Key
+	})
+	return report, nil
+}
+
+// verifyKey checks a single key, fixing its problems if fix is set.
+func (s *store) verifyKey(ctx context.Context, key string, global bool, now float64, fix bool) ([]Anomaly, error) {
+	name := key
+	if !global {
+		name = strings.TrimPrefix(key, s.keyPrefix)
+	}
+
+	problems, err := s.bucketProblems(ctx, key, global, now)
+	if errors.Is(err, bucketstate.ErrNewerVersion) {
+		return []Anomaly{{Key: name, Problem: "bucket was written by a newer version"}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		var fixed bool
+		if fix {
+			if _, err := s.conns.do(ctx, "DEL", key); err != nil {
+				return nil, fmt.Errorf("failed to delete key: %w", err)
+			}
+			fixed = true
+		}
+
+		anomalies := make([]Anomaly, 0, len(problems))
+		for _, p := range problems {
+			anomalies = append(anomalies, Anomaly{Key: name, Problem: p, Fixed: fixed})
+		}
+		return anomalies, nil
+	}
+
+	resp, err := s.conns.do(ctx, "TTL", key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ttl: %w", err)
+	}
+	if resp.int64() != -1 {
+		return nil, nil
+	}
+
+	a := Anomaly{Key: name, Problem: "key has no ttl"}
+	if fix {
+		if _, err := s.conns.do(ctx, "EXPIRE", key, strconv.FormatUint(s.ttl, 10)); err != nil {
+			return nil, fmt.Errorf("failed to set ttl: %w", err)
+		}
+		a.Fixed = true
+	}
+	return []Anomaly{a}, nil
+}
+
+// bucketProblems returns what is wrong with the bucket at key, if anything.
+func (s *store) bucketProblems(ctx context.Context, key string, global bool, now float64) ([]string, error) {
+	resp, err := s.conns.do(ctx, "HGETALL", key)
+	var rerr replyError
+	if errors.As(err, &rerr) {
+		// For example, WRONGTYPE for a key that is not a hash.
+		return []string{"key is not a hash"}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key: %w", err)
+	}
+
+	a := resp.array()
+
+	// Buckets of a newer version may have fields this version does not know,
+	// so they are not checked.
+	for i := 0; i+1 < len(a); i += 2 {
+		if a[i].s != bucketstate.FieldVersion {
+			continue
+		}
+		if v, err := strconv.ParseFloat(a[i+1].s, 64); err == nil && v > bucketstate.Version {
+			return nil, fmt.Errorf("version %g: %w", v, bucketstate.ErrNewerVersion)
+		}
+	}
+
+	fields := make(map[string]float64, len(a)/2)
+	present := make(map[string]bool, len(a)/2)
+	var problems []string
+	for i := 0; i+1 < len(a); i += 2 {
+		field, value := a[i].s, a[i+1].s
+		present[field] = true
+		if field == bucketstate.FieldMetadata {
+			continue
+		}
+		if _, ok := bucketFields[field]; !ok {
+			problems = append(problems, fmt.Sprintf("unknown field %q", field))
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("field %q is not a number: %q", field, value))
+			continue
+		}
+		fields[field] = v
+	}
+	for _, field := range []string{"s", "t", "k", "d"} {
+		if !present[field] && bucketFields[field] {
+			problems = append(problems, fmt.Sprintf("missing field %q", field))
+		}
+	}
+	if len(problems) > 0 {
+		return problems, nil
+	}
+
+	maxTokens, debtLimit := float64(s.tokens), float64(s.debtLimit)
+	if global {
+		maxTokens, debtLimit = float64(s.globalTokens), 0
+	}
+	if start := fields["s"]; start > now {
+		problems = append(problems, "start time is in the future")
+	}
+	if tick := fields["t"]; tick < 0 {
+		problems = append(problems, fmt.Sprintf("tick %g is negative", tick))
+	}
+	// A take grants whole tokens, so it can leave a fractional bucket up to a
+	// token below zero.
+	if tokens := fields["k"]; tokens <= -1 || tokens > maxTokens {
+		problems = append(problems, fmt.Sprintf("tokens %g are outside (-1, %g]", tokens, maxTokens))
+	}
+	if debt := fields["d"]; debt < 0 || debt > debtLimit {
+		problems = append(problems, fmt.Sprintf("debt %g is outside [0, %g]", debt, debtLimit))
+	}
+	return problems, nil
+}
@@ -0,0 +1,92 @@
+package redisstore
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeRedis(t)
+	s, err := New(&Config{
+		Tokens:       5,
+		Interval:     time.Minute,
+		KeyPrefix:    "rl:",
+		GlobalTokens: 100,
+		DialFunc:     f.dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	for _, key := range []string{"ok", "nottl"} {
+		if _, err := s.Take(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	future := strconv.FormatInt(time.Now().Add(time.Hour).UnixNano(), 10)
+	f.lock.Lock()
+	delete(f.expires, "rl:nottl")
+	f.data["rl:missing"] = map[string]string{"s": "0", "k": "1"}
+	f.data["rl:garbage"] = map[string]string{"s": "0", "t": "x", "k": "1", "z": "1"}
+	f.data["rl:range"] = map[string]string{"s": future, "t": "0", "k": "6", "d": "1"}
+	f.data["other"] = map[string]string{"k": "x"}
+	f.lock.Unlock()
+
+	report, err := Verify(ctx, s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := report.Keys, uint64(6); got != want {
+		t.Errorf("keys: expected %d to be %d", got, want)
+	}
+
+	want := []Anomaly{
+		{Key: "garbage", Problem: `field "t" is not a number: "x"`},
+		{Key: "garbage", Problem: `unknown field "z"`},
+		{Key: "missing", Problem: `missing field "t"`},
+		{Key: "nottl", Problem: "key has no ttl"},
+		{Key: "range", Problem: "start time is in the future"},
+		{Key: "range", Problem: "tokens 6 are outside (-1, 5]"},
+		{Key: "range", Problem: "debt 1 is outside [0, 0]"},
+	}
+	if got := report.Anomalies; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %#v to be %#v", got, want)
+	}
+
+	report, err = Verify(ctx, s, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range want {
+		want[i].Fixed = true
+	}
+	if got := report.Anomalies; !reflect.DeepEqual(got, want) {
+		t.Errorf("fix: expected %#v to be %#v", got, want)
+	}
+
+	report, err = Verify(ctx, s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := report.Keys, uint64(3); got != want {
+		t.Errorf("after fix: keys: expected %d to be %d", got, want)
+	}
+	if got := report.Anomalies; len(got) != 0 {
+		t.Errorf("after fix: expected no anomalies, got %#v", got)
+	}
+
+	f.lock.Lock()
+	_, other := f.data["other"]
+	f.lock.Unlock()
+	if !other {
+		t.Errorf("expected keys without the prefix to be left alone")
+	}
+}
@@ -0,0 +1,115 @@
+// Package remotestore defines a store that takes from a limiterd sidecar, or
+// any other unixstore server, with the retries and failure modes of
+// redisstore, so applications can move from a direct Redis connection to the
+// sidecar by changing their configuration.
+//
+// Connections to the sidecar are pooled by the underlying unixstore client.
+// Takes that fail to reach the sidecar are retried with jittered exponential
+// backoff; errors the sidecar's store returned are not, since the sidecar
+// already decided them. Once the retries are exhausted, the FailureMode
+// decides the take.
+package remotestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/retrystore"
+	"github.com/sethvargo/go-limiter/unixstore"
+)
+
+// FailureMode specifies the failure mode.
+type FailureMode int
+
+const (
+	// FailClosed indicates the system should disallow requests if it cannot
+	// reach the sidecar. This is the default behavior.
+	FailClosed FailureMode = iota + 1
+
+	// FailOpen indicates the system should allow requests if it cannot reach the
+	// sidecar.
+	FailOpen
+)
+
+// Config is used as input to New.
+type Config struct {
+	// Path is the path of the sidecar's Unix socket. It is required.
+	Path string
+
+	// MaxIdleConns is the number of connections to the sidecar kept open
+	// between calls. The default value is 4.
+	MaxIdleConns int
+
+	// DialTimeout is the most time spent connecting to the sidecar. The default
+	// value is 1 second.
+	DialTimeout time.Duration
+
+	// Token authenticates the connections to a sidecar started with
+	// -socket-tokens.
+	Token string
+
+	// Retry bounds the retries of takes that fail to reach the sidecar. The
+	// defaults are those of retrystore.
+	Retry retrystore.Backoff
+
+	// FailureMode indicates how the system should fail if it cannot reach the
+	// sidecar. The default value is FailClosed.
+	FailureMode FailureMode
+}
+
+type store struct {
+	limiter.Decorated
+
+	failureMode FailureMode
+}
+
+// New creates a store that takes from the sidecar at c.Path. It does not
+// connect until the first call, so the sidecar may start later.
+func New(c *Config) (limiter.Store, error) {
+	if c == nil || c.Path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+
+	failureMode := FailClosed
+	if c.FailureMode != 0 {
+		failureMode = c.FailureMode
+	}
+
+	client, err := unixstore.New(&unixstore.Config{
+		Path:         c.Path,
+		MaxIdleConns: c.MaxIdleConns,
+		DialTimeout:  c.DialTimeout,
+		Token:        c.Token,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	retried := retrystore.Wrap(client, &retrystore.Policy{
+		Classes: []retrystore.Class{
+			{
+				Match:   retrystore.Is(unixstore.ErrServer),
+				Backoff: retrystore.Backoff{MaxAttempts: 1},
+			},
+		},
+		Backoff: c.Retry,
+	})
+
+	return limiter.Preserve(retried, &store{
+		Decorated:   limiter.Decorated{Store: retried},
+		failureMode: failureMode,
+	}), nil
+}
+
+// Take takes a token from the key in the sidecar. If that fails, the result is
+// that of the configured FailureMode.
+func (s *store) Take(ctx context.Context, key string) (limiter.Result, error) {
+	res, err := s.Store.Take(ctx, key)
+	if err != nil && !errors.Is(err, limiter.ErrStopped) {
+		return limiter.Result{Allowed: s.failureMode == FailOpen}, err
+	}
+	return res, err
+}
@@ -0,0 +1,187 @@
+package remotestore_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/memorystore"
+	"github.com/sethvargo/go-limiter/remotestore"
+	"github.com/sethvargo/go-limiter/retrystore"
+	"github.com/sethvargo/go-limiter/storetest"
+	"github.com/sethvargo/go-limiter/unixstore"
+)
+
+// served is a store that also stops its server and the served store when it
+// is closed.
+type served struct {
+	limiter.Decorated
+	cleanup func()
+}
+
+func (s *served) Close() error {
+	err := s.Store.Close()
+	s.cleanup()
+	return err
+}
+
+// serve serves the store on a new socket and returns its path and a cleanup
+// function.
+func serve(tb testing.TB, ls limiter.Store) (string, func()) {
+	tb.Helper()
+
+	dir, err := ioutil.TempDir("", "remotestore")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	path := filepath.Join(dir, "limiter.sock")
+
+	l, err := unixstore.Listen(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	srv := unixstore.NewServer(ls)
+	go srv.Serve(l)
+
+	return path, func() {
+		srv.Close()
+		ls.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// failingStore fails every take.
+type failingStore struct {
+	limiter.Store
+	takes uint64
+}
+
+func (s *failingStore) Take(ctx context.Context, key string) (limiter.Result, error) {
+	atomic.AddUint64(&s.takes, 1)
+	return limiter.Result{}, fmt.Errorf("backend is down")
+}
+
+func TestStore_Conformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.TestStore(t, func(tb testing.TB, c *storetest.Config) limiter.Store {
+		ms, err := memorystore.New(&memorystore.Config{
+			Tokens:        c.Tokens,
+			Interval:      c.Interval,
+			SweepInterval: c.TTL,
+			SweepMinTTL:   c.TTL,
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		path, cleanup := serve(tb, ms)
+		rs, err := remotestore.New(&remotestore.Config{Path: path})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		s := &served{Decorated: limiter.Decorated{Store: rs}, cleanup: cleanup}
+		return limiter.Preserve(rs, s)
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	if _, err := remotestore.New(nil); err == nil {
+		t.Error("expected error for nil config")
+	}
+}
+
+func TestStore_FailureMode(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "remotestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name string
+		mode remotestore.FailureMode
+		exp  bool
+	}{
+		{
+			name: "default",
+			exp:  false,
+		},
+		{
+			name: "closed",
+			mode: remotestore.FailClosed,
+			exp:  false,
+		},
+		{
+			name: "open",
+			mode: remotestore.FailOpen,
+			exp:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Nothing serves the socket.
+			s, err := remotestore.New(&remotestore.Config{
+				Path:        filepath.Join(dir, "missing.sock"),
+				Retry:       retrystore.Backoff{BaseDelay: time.Millisecond},
+				FailureMode: tc.mode,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			res, err := s.Take(context.Background(), "key")
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if got, want := res.Allowed, tc.exp; got != want {
+				t.Errorf("expected %t to be %t", got, want)
+			}
+		})
+	}
+}
+
+func TestStore_ServerErrors(t *testing.T) {
+	t.Parallel()
+
+	backend := new(failingStore)
+	ms, err := memorystore.New(&memorystore.Config{Tokens: 1, Interval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.Store = ms
+
+	path, cleanup := serve(t, backend)
+	defer cleanup()
+
+	s, err := remotestore.New(&remotestore.Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.Take(context.Background(), "key"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// The sidecar's store decided the take, so it is not retried.
+	if got, want := atomic.LoadUint64(&backend.takes), uint64(1); got != want {
+		t.Errorf("expected %d takes to be %d", got, want)
+	}
+}
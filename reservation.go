@@ -0,0 +1,133 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrReservationDone is the error returned when committing or canceling a
+	// reservation that was already committed or canceled.
+	ErrReservationDone = fmt.Errorf("reservation is already done")
+
+	// ErrReservationExpired is the error returned when canceling a reservation
+	// after its TTL. The reserved token remains spent.
+	ErrReservationExpired = fmt.Errorf("reservation is expired")
+)
+
+// reservationState is the state of a reservation.
+type reservationState int
+
+const (
+	reservationNone reservationState = iota
+	reservationPending
+	reservationCommitted
+	reservationCanceled
+)
+
+// Reservation is a token taken from a store that can be returned if the work
+// it was taken for is aborted. Use Reserve to create one.
+type Reservation struct {
+	store     Refunder
+	key       string
+	result    Result
+	expiresAt time.Time
+
+	lock  sync.Mutex
+	state reservationState
+}
+
+// Reserve takes a token from the key and returns a reservation for it, which is
+// useful for long-running operations that may be aborted before doing any
+// work. Call Commit once the work is done, or Cancel to return the token to
+// the store. The token is spent until then, so concurrent callers see the
+// reservation in the remaining count.
+//
+// Cancel only returns the token if it is called within ttl. A ttl of 0 means
+// until the reset time of the take. After that, the reservation is considered
+// committed, because the key may have been refilled since.
+//
+// The store must implement Refunder, otherwise ErrNotSupported is returned.
+// Like Take, Reserve returns the result alongside any error from the store, so
+// callers can honor a store that failed open. When the take was not allowed or
+// returned an error, there is no token to return and Commit and Cancel are
+// no-ops.
+func Reserve(ctx context.Context, s Store, key string, ttl time.Duration) (*Reservation, error) {
+	refunder, ok := s.(Refunder)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	res, err := s.Take(ctx, key)
+
+	r := &Reservation{
+		store:     refunder,
+		key:       key,
+		result:    res,
+		expiresAt: res.ResetAt,
+	}
+	if ttl > 0 {
+		r.expiresAt = time.Now().Add(ttl)
+	}
+	if err == nil && res.Allowed {
+		r.state = reservationPending
+	}
+	return r, err
+}
+
+// Result returns the result of the take that created the reservation.
+func (r *Reservation) Result() Result {
+	return r.result
+}
+
+// ExpiresAt returns the time after which Cancel no longer returns the token.
+func (r *Reservation) ExpiresAt() time.Time {
+	return r.expiresAt
+}
+
+// Commit marks the reserved token as spent. It returns ErrReservationDone if
+// the reservation was already committed or canceled.
+func (r *Reservation) Commit() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	switch r.state {
+	case reservationNone:
+		return nil
+	case reservationPending:
+		r.state = reservationCommitted
+		return nil
+	default:
+		return ErrReservationDone
+	}
+}
+
+// Cancel returns the reserved token to the store. It returns
+// ErrReservationExpired if the reservation's TTL has passed, in which case the
+// token remains spent, and ErrReservationDone if the reservation was already
+// committed or canceled.
+func (r *Reservation) Cancel(ctx context.Context) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	switch r.state {
+	case reservationNone:
+		return nil
+	case reservationPending:
+	default:
+		return ErrReservationDone
+	}
+
+	if time.Now().After(r.expiresAt) {
+		r.state = reservationCommitted
+		return ErrReservationExpired
+	}
+
+	r.state = reservationCanceled
+	if err := r.store.Refund(ctx, r.key, 1); err != nil {
+		return fmt.Errorf("failed to refund: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package limiter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+// takeOnlyStore hides the optional interfaces of the wrapped store.
+type takeOnlyStore struct {
+	limiter.Store
+}
+
+func TestReserve(t *testing.T) {
+	t.Parallel()
+
+	newStore := func(tb testing.TB) limiter.Store {
+		s, err := memorystore.New(&memorystore.Config{
+			Tokens:   1,
+			Interval: time.Minute,
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { s.Close() })
+		return s
+	}
+
+	ctx := context.Background()
+
+	t.Run("commit", func(t *testing.T) {
+		t.Parallel()
+
+		s := newStore(t)
+		r, err := limiter.Reserve(ctx, s, "key", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.Result().Allowed {
+			t.Fatal("expected reservation to be allowed")
+		}
+		if err := r.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Cancel(ctx); !errors.Is(err, limiter.ErrReservationDone) {
+			t.Errorf("expected %v to be %v", err, limiter.ErrReservationDone)
+		}
+
+		if res, _ := s.Take(ctx, "key"); res.Allowed {
+			t.Error("expected committed token to remain spent")
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		t.Parallel()
+
+		s := newStore(t)
+		r, err := limiter.Reserve(ctx, s, "key", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := r.ExpiresAt(), r.Result().ResetAt; !got.Equal(want) {
+			t.Errorf("expires: expected %s to be %s", got, want)
+		}
+
+		// The token is spent while the reservation is pending.
+		if res, _ := s.Take(ctx, "key"); res.Allowed {
+			t.Fatal("expected reserved token to be spent")
+		}
+
+		if err := r.Cancel(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Commit(); !errors.Is(err, limiter.ErrReservationDone) {
+			t.Errorf("expected %v to be %v", err, limiter.ErrReservationDone)
+		}
+
+		if res, _ := s.Take(ctx, "key"); !res.Allowed {
+			t.Error("expected canceled token to be returned")
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		t.Parallel()
+
+		s := newStore(t)
+		r, err := limiter.Reserve(ctx, s, "key", time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		if err := r.Cancel(ctx); !errors.Is(err, limiter.ErrReservationExpired) {
+			t.Errorf("expected %v to be %v", err, limiter.ErrReservationExpired)
+		}
+		if res, _ := s.Take(ctx, "key"); res.Allowed {
+			t.Error("expected expired token to remain spent")
+		}
+	})
+
+	t.Run("not_allowed", func(t *testing.T) {
+		t.Parallel()
+
+		s := newStore(t)
+		if _, err := s.Take(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := limiter.Reserve(ctx, s, "key", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Result().Allowed {
+			t.Fatal("expected reservation to be denied")
+		}
+		if err := r.Cancel(ctx); err != nil {
+			t.Errorf("expected cancel to be a no-op, got %v", err)
+		}
+		if res, _ := s.Take(ctx, "key"); res.Allowed {
+			t.Error("expected cancel of denied reservation not to refund")
+		}
+	})
+
+	t.Run("not_supported", func(t *testing.T) {
+		t.Parallel()
+
+		s := newStore(t)
+		if _, err := limiter.Reserve(ctx, &takeOnlyStore{s}, "key", 0); !errors.Is(err, limiter.ErrNotSupported) {
+			t.Errorf("expected %v to be %v", err, limiter.ErrNotSupported)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// Result is the result of taking a token from a store.
+type Result struct {
+	// Limit is the configured limit size.
+	Limit uint64
+
+	// Remaining is the number of remaining tokens in the interval.
+	Remaining uint64
+
+	// ResetAt is the time when new tokens will be available.
+	ResetAt time.Time
+
+	// Allowed indicates whether the take was successful. If Allowed is false,
+	// the caller should NOT service the request.
+	Allowed bool
+
+	// RetryAfter is the amount of time the caller should wait before trying
+	// again. It is always zero when the take was allowed.
+	RetryAfter time.Duration
+
+	// Warning indicates that the take was allowed, but the key has used more of
+	// its limit than the soft threshold set with SoftLimit. Stores never set it
+	// themselves.
+	Warning bool
+
+	// Metadata is the metadata stored with the key by an Annotator. Only Peek
+	// sets it.
+	Metadata string
+}
+
+// TakeValues calls Take on the store and returns the result as positional
+// values: the limit, the remaining tokens, the reset time in unix nanoseconds,
+// and whether the take was allowed. It exists for compatibility with code
+// written against earlier versions of the Store interface; new code should use
+// the Result directly.
+func TakeValues(ctx context.Context, s Store, key string) (limit, remaining, reset uint64, ok bool, err error) {
+	res, err := s.Take(ctx, key)
+
+	if !res.ResetAt.IsZero() {
+		reset = uint64(res.ResetAt.UnixNano())
+	}
+	return res.Limit, res.Remaining, reset, res.Allowed, err
+}
@@ -0,0 +1,61 @@
+package limiter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestTakeValues(t *testing.T) {
+	t.Parallel()
+
+	s, err := memorystore.New(&memorystore.Config{
+		Tokens:   2,
+		Interval: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	cases := []struct {
+		remaining uint64
+		ok        bool
+	}{
+		{remaining: 1, ok: true},
+		{remaining: 0, ok: true},
+		{remaining: 0, ok: false},
+	}
+
+	for i, tc := range cases {
+		limit, remaining, reset, ok, err := limiter.TakeValues(ctx, s, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := ok, tc.ok; got != want {
+			t.Errorf("take %d: ok: expected %t to be %t", i, got, want)
+		}
+		if got, want := limit, uint64(2); got != want {
+			t.Errorf("take %d: limit: expected %d to be %d", i, got, want)
+		}
+		if got, want := remaining, tc.remaining; got != want {
+			t.Errorf("take %d: remaining: expected %d to be %d", i, got, want)
+		}
+		if until := time.Until(time.Unix(0, int64(reset))); until <= 0 || until > time.Minute {
+			t.Errorf("take %d: reset: expected %s to be in (0, 1m]", i, until)
+		}
+	}
+
+	s.Close()
+	limit, remaining, reset, ok, err := limiter.TakeValues(ctx, s, "key")
+	if err != limiter.ErrStopped {
+		t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+	if limit != 0 || remaining != 0 || reset != 0 || ok {
+		t.Errorf("expected zero values, got (%d, %d, %d, %t)", limit, remaining, reset, ok)
+	}
+}
@@ -0,0 +1,141 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ThrottledError is returned by operations that a dependency throttled, like a
+// call answered with a 429. Retry retries them after RetryAfter, if it is set.
+type ThrottledError struct {
+	// RetryAfter is how long the dependency asked the caller to wait, or 0 if
+	// it did not say.
+	RetryAfter time.Duration
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *ThrottledError) Error() string {
+	if e.Err == nil {
+		return "throttled"
+	}
+	return "throttled: " + e.Err.Error()
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return e.Err
+}
+
+// RetryConfig is used as input to Retry.
+type RetryConfig struct {
+	// MaxAttempts is the total number of attempts, including the first. The
+	// default value is 3.
+	MaxAttempts int
+
+	// MaxDelay is the longest Retry waits before a retry. If the store or the
+	// dependency ask for a longer wait, Retry gives up instead, since the
+	// caller is better served by an error. The default value is 30 seconds.
+	MaxDelay time.Duration
+
+	// Budget, if set, bounds the retries of each key per interval across all
+	// callers that share it, like 10% of the key's limit. Each retry takes a
+	// token of the key from Budget, and once it is empty, failed attempts are
+	// not retried, so retries cannot amplify the load on a dependency that
+	// throttles everyone.
+	Budget Store
+
+	// Retryable reports whether to retry after the error, and the least delay
+	// before the retry. The default retries ThrottledErrors after their
+	// RetryAfter, and nothing else.
+	Retryable func(err error) (time.Duration, bool)
+}
+
+// Retry calls f after taking a token from the key, waiting for one like Wait,
+// and retries it while it returns a retryable error, such as a
+// ThrottledError. Before each retry, Retry waits for the later of the delay
+// the error asked for and the key's reset time, if the key has no tokens left
+// and the store implements Peeker, and takes a token of the retry budget. It
+// gives up and returns the last error of f when the attempts, the budget, or
+// the context run out, or when the wait would exceed MaxDelay.
+func Retry(ctx context.Context, s Store, key string, c *RetryConfig, f func(ctx context.Context) error) error {
+	if c == nil {
+		c = new(RetryConfig)
+	}
+
+	maxAttempts := 3
+	if c.MaxAttempts > 0 {
+		maxAttempts = c.MaxAttempts
+	}
+
+	maxDelay := 30 * time.Second
+	if c.MaxDelay > 0 {
+		maxDelay = c.MaxDelay
+	}
+
+	retryable := retryThrottled
+	if c.Retryable != nil {
+		retryable = c.Retryable
+	}
+
+	for attempt := 1; ; attempt++ {
+		if _, err := Wait(ctx, s, key); err != nil {
+			return fmt.Errorf("failed to take: %w", err)
+		}
+
+		err := f(ctx)
+		if err == nil {
+			return nil
+		}
+
+		d, ok := retryable(err)
+		if !ok || attempt >= maxAttempts {
+			return err
+		}
+
+		// Wait for the key's reset if it has no tokens left, rather than
+		// spending a retry on a take that would only wait anyway.
+		if p, ok := s.(Peeker); ok {
+			if res, perr := p.Peek(ctx, key); perr == nil && res.Remaining == 0 {
+				if until := time.Until(res.ResetAt); until > d {
+					d = until
+				}
+			}
+		}
+		if d > maxDelay {
+			return err
+		}
+
+		if c.Budget != nil {
+			res, berr := c.Budget.Take(ctx, key)
+			if berr != nil || !res.Allowed {
+				return fmt.Errorf("retry budget exhausted: %w", err)
+			}
+		}
+
+		d += time.Duration(rand.Int63n(int64(d)/10 + 1))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+			return err
+		}
+
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryThrottled retries ThrottledErrors.
+func retryThrottled(err error) (time.Duration, bool) {
+	var te *ThrottledError
+	if errors.As(err, &te) {
+		return te.RetryAfter, true
+	}
+	return 0, false
+}
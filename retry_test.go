@@ -0,0 +1,130 @@
+package limiter_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	newStore := func(tb testing.TB, tokens uint64) limiter.Store {
+		s, err := memorystore.New(&memorystore.Config{
+			Tokens:   tokens,
+			Interval: time.Hour,
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { s.Close() })
+		return s
+	}
+
+	throttled := &limiter.ThrottledError{RetryAfter: time.Millisecond, Err: fmt.Errorf("429")}
+
+	cases := []struct {
+		name     string
+		config   func(tb testing.TB) *limiter.RetryConfig
+		failures int
+		err      error
+		calls    int
+	}{
+		{
+			name:     "recovers",
+			failures: 2,
+			calls:    3,
+		},
+		{
+			name:     "max_attempts",
+			failures: 5,
+			err:      throttled,
+			calls:    3,
+		},
+		{
+			name: "budget",
+			config: func(tb testing.TB) *limiter.RetryConfig {
+				return &limiter.RetryConfig{Budget: newStore(tb, 1)}
+			},
+			failures: 5,
+			err:      throttled,
+			calls:    2,
+		},
+		{
+			name: "max_delay",
+			config: func(tb testing.TB) *limiter.RetryConfig {
+				return &limiter.RetryConfig{MaxDelay: time.Nanosecond}
+			},
+			failures: 5,
+			err:      throttled,
+			calls:    1,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var c *limiter.RetryConfig
+			if tc.config != nil {
+				c = tc.config(t)
+			}
+
+			var calls int
+			err := limiter.Retry(context.Background(), newStore(t, 10), "key", c, func(context.Context) error {
+				calls++
+				if calls <= tc.failures {
+					return throttled
+				}
+				return nil
+			})
+			if !errors.Is(err, tc.err) {
+				t.Errorf("expected %v to be %v", err, tc.err)
+			}
+			if got, want := calls, tc.calls; got != want {
+				t.Errorf("expected %d calls to be %d", got, want)
+			}
+		})
+	}
+
+	t.Run("not_retryable", func(t *testing.T) {
+		t.Parallel()
+
+		want := fmt.Errorf("bad request")
+		var calls int
+		err := limiter.Retry(context.Background(), newStore(t, 10), "key", nil, func(context.Context) error {
+			calls++
+			return want
+		})
+		if !errors.Is(err, want) {
+			t.Errorf("expected %v to be %v", err, want)
+		}
+		if calls != 1 {
+			t.Errorf("expected 1 call, got %d", calls)
+		}
+	})
+
+	t.Run("waits_for_reset", func(t *testing.T) {
+		t.Parallel()
+
+		// The key is empty until its reset in an hour, which is past MaxDelay.
+		var calls int
+		err := limiter.Retry(context.Background(), newStore(t, 1), "key", nil, func(context.Context) error {
+			calls++
+			return throttled
+		})
+		if !errors.Is(err, throttled) {
+			t.Errorf("expected %v to be %v", err, throttled)
+		}
+		if calls != 1 {
+			t.Errorf("expected 1 call, got %d", calls)
+		}
+	})
+}
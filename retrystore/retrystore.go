@@ -0,0 +1,199 @@
+// Package retrystore defines a limiter.Store decorator that retries takes that
+// fail with transient errors.
+//
+// Stores apply their failure mode to every failed take, so without retries a
+// single dropped connection can deny (or allow) a request. Wrapping the store
+// retries the take a bounded number of times, with jittered exponential
+// backoff, and only returns the failure once the retries are exhausted.
+//
+// By default, only takes that failed to connect to the store are retried,
+// since they never reached it. A take that fails later may have been counted
+// even though it failed: if its reply was lost to a read timeout or a dropped
+// connection, retrying it charges the key twice. Other errors are retried only
+// if a Class of the policy matches them, such as Timeout.
+package retrystore
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// Policy decides which failed takes are retried, and how.
+type Policy struct {
+	// Classes are checked in order, and the first that matches an error decides
+	// how it is retried. Errors that match no class are retried with the
+	// policy's Backoff if they are Dial errors, and not retried otherwise.
+	Classes []Class
+
+	// Backoff is how Dial errors that match no class are retried.
+	Backoff
+}
+
+// Class is a class of errors that are retried the same way.
+type Class struct {
+	// Match reports whether the error is in the class. See Is.
+	Match func(err error) bool
+
+	// Backoff is how errors in the class are retried.
+	Backoff
+}
+
+// Backoff bounds the retries of a take. Before each retry, the take waits for
+// a random duration of up to BaseDelay, doubled for each earlier retry, and
+// capped at MaxDelay.
+type Backoff struct {
+	// MaxAttempts is the total number of attempts, including the first. Set it
+	// to 1 to never retry. The default value is 3.
+	MaxAttempts int
+
+	// BaseDelay is the maximum delay before the first retry. The default value
+	// is 10 milliseconds.
+	BaseDelay time.Duration
+
+	// MaxDelay is the maximum delay before any retry. The default value is 1
+	// second.
+	MaxDelay time.Duration
+}
+
+// Is returns a Match function for errors that are target, as reported by
+// errors.Is.
+func Is(target error) func(err error) bool {
+	return func(err error) bool {
+		return errors.Is(err, target)
+	}
+}
+
+// Dial reports whether the error is a failure to connect, which is safe to
+// retry because the take never reached the store. It is the class of errors
+// that are retried by default.
+func Dial(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// Timeout reports whether the error is a timeout, such as a read timeout
+// waiting for the reply of a take. The store may have counted the take before
+// it timed out, so retrying it may charge the key twice. Add it to the
+// policy's Classes to retry timeouts anyway.
+func Timeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Wrap returns a store that retries takes on s according to the policy. If
+// the policy is nil, Dial errors are retried with the default Backoff.
+//
+// Some errors are never retried, even if a class matches them:
+// limiter.ErrStopped, and errors from a context that is canceled or past its
+// deadline. Retries stop early if the context is done while waiting.
+func Wrap(s limiter.Store, p *Policy) limiter.Store {
+	if p == nil {
+		p = new(Policy)
+	}
+
+	classes := make([]Class, len(p.Classes))
+	for i, c := range p.Classes {
+		classes[i] = Class{Match: c.Match, Backoff: c.Backoff.withDefaults()}
+	}
+
+	return limiter.Preserve(s, &store{
+		Decorated: limiter.Decorated{Store: s},
+		classes:   classes,
+		backoff:   p.Backoff.withDefaults(),
+	})
+}
+
+// Middleware returns middleware that wraps stores with Wrap.
+func Middleware(p *Policy) limiter.StoreMiddleware {
+	return func(s limiter.Store) limiter.Store {
+		return Wrap(s, p)
+	}
+}
+
+type store struct {
+	limiter.Decorated
+
+	classes []Class
+	backoff Backoff
+}
+
+// Take takes from the underlying store, retrying if it fails. If every
+// attempt fails, the result and error of the last attempt are returned, with
+// the underlying store's failure mode applied.
+func (s *store) Take(ctx context.Context, key string) (limiter.Result, error) {
+	res, err := s.Store.Take(ctx, key)
+	for attempt := 1; err != nil; attempt++ {
+		if !retryable(ctx, err) {
+			break
+		}
+
+		b, ok := s.backoffFor(err)
+		if !ok || attempt >= b.MaxAttempts {
+			break
+		}
+
+		timer := time.NewTimer(b.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, err
+		case <-timer.C:
+		}
+
+		res, err = s.Store.Take(ctx, key)
+	}
+	return res, err
+}
+
+// backoffFor returns the backoff of the first class that matches err, or the
+// policy's backoff if it is a Dial error. It returns false if err is not
+// retried.
+func (s *store) backoffFor(err error) (Backoff, bool) {
+	for _, c := range s.classes {
+		if c.Match != nil && c.Match(err) {
+			return c.Backoff, true
+		}
+	}
+	return s.backoff, Dial(err)
+}
+
+// retryable returns false for errors that retrying cannot fix.
+func retryable(ctx context.Context, err error) bool {
+	if errors.Is(err, limiter.ErrStopped) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return ctx.Err() == nil
+}
+
+// withDefaults returns the backoff with defaults for any unset fields.
+func (b Backoff) withDefaults() Backoff {
+	if b.MaxAttempts <= 0 {
+		b.MaxAttempts = 3
+	}
+	if b.BaseDelay <= 0 {
+		b.BaseDelay = 10 * time.Millisecond
+	}
+	if b.MaxDelay <= 0 {
+		b.MaxDelay = 1 * time.Second
+	}
+	return b
+}
+
+// delay returns a random delay before the given retry, starting at 1.
+func (b Backoff) delay(retry int) time.Duration {
+	d := b.MaxDelay
+	if shift := uint(retry - 1); shift < 32 {
+		if exp := b.BaseDelay << shift; exp > 0 && exp < d {
+			d = exp
+		}
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
@@ -0,0 +1,197 @@
+package retrystore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+var (
+	errTransient = errors.New("transient")
+	errDial      = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	errTimeout   = &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}
+)
+
+// timeoutError is a net.Error that timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// flakyStore fails the first failures takes with err.
+type flakyStore struct {
+	limiter.Store
+
+	failures int
+	err      error
+	takes    int
+}
+
+func (s *flakyStore) Take(_ context.Context, _ string) (limiter.Result, error) {
+	s.takes++
+	if s.takes <= s.failures {
+		return limiter.Result{}, s.err
+	}
+	return limiter.Result{Allowed: true}, nil
+}
+
+func TestStore_Take(t *testing.T) {
+	t.Parallel()
+
+	permanent := errors.New("permanent")
+	policy := &Policy{
+		Classes: []Class{
+			{Match: Is(permanent), Backoff: Backoff{MaxAttempts: 1}},
+			{Match: Is(errTransient), Backoff: Backoff{MaxAttempts: 5, BaseDelay: time.Millisecond}},
+		},
+		Backoff: Backoff{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	}
+
+	cases := []struct {
+		name     string
+		failures int
+		err      error
+		takes    int
+		allowed  bool
+	}{
+		{
+			name:     "succeeds",
+			failures: 0,
+			takes:    1,
+			allowed:  true,
+		},
+		{
+			name:     "retries_class",
+			failures: 4,
+			err:      errTransient,
+			takes:    5,
+			allowed:  true,
+		},
+		{
+			name:     "exhausts_class",
+			failures: 5,
+			err:      errTransient,
+			takes:    5,
+		},
+		{
+			name:     "never_retries",
+			failures: 1,
+			err:      permanent,
+			takes:    1,
+		},
+		{
+			name:     "dial",
+			failures: 2,
+			err:      errDial,
+			takes:    2,
+		},
+		{
+			name:     "other",
+			failures: 1,
+			err:      errors.New("other"),
+			takes:    1,
+		},
+		{
+			name:     "timeout",
+			failures: 1,
+			err:      errTimeout,
+			takes:    1,
+		},
+		{
+			name:     "stopped",
+			failures: 1,
+			err:      limiter.ErrStopped,
+			takes:    1,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fs := &flakyStore{failures: tc.failures, err: tc.err}
+			res, err := Wrap(fs, policy).Take(context.Background(), "key")
+			if got, want := fs.takes, tc.takes; got != want {
+				t.Errorf("takes: expected %d to be %d", got, want)
+			}
+			if got, want := res.Allowed, tc.allowed; got != want {
+				t.Errorf("allowed: expected %t to be %t", got, want)
+			}
+			if tc.allowed && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			if !tc.allowed && !errors.Is(err, tc.err) {
+				t.Errorf("expected %v to be %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestStore_Take_contextDone(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	fs := &flakyStore{failures: 100, err: errDial}
+	s := Wrap(fs, &Policy{
+		Backoff: Backoff{MaxAttempts: 100, BaseDelay: time.Second, MaxDelay: time.Second},
+	})
+
+	start := time.Now()
+	if _, err := s.Take(ctx, "key"); !errors.Is(err, errDial) {
+		t.Errorf("expected %v to be %v", err, errDial)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("expected retries to stop with the context, took %s", d)
+	}
+}
+
+func TestStore_Take_timeout(t *testing.T) {
+	t.Parallel()
+
+	fs := &flakyStore{failures: 1, err: fmt.Errorf("failed to run script: %w", errTimeout)}
+	s := Wrap(fs, &Policy{
+		Classes: []Class{
+			{Match: Timeout, Backoff: Backoff{MaxAttempts: 2, BaseDelay: time.Millisecond}},
+		},
+	})
+
+	res, err := s.Take(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed {
+		t.Error("expected allowed")
+	}
+	if got, want := fs.takes, 2; got != want {
+		t.Errorf("takes: expected %d to be %d", got, want)
+	}
+}
+
+func TestBackoff_delay(t *testing.T) {
+	t.Parallel()
+
+	b := Backoff{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	for retry, max := range map[int]time.Duration{
+		1:  10 * time.Millisecond,
+		2:  20 * time.Millisecond,
+		3:  40 * time.Millisecond,
+		4:  50 * time.Millisecond,
+		40: 50 * time.Millisecond,
+	} {
+		for i := 0; i < 100; i++ {
+			if d := b.delay(retry); d < 0 || d > max {
+				t.Fatalf("retry %d: expected delay in [0, %s], got %s", retry, max, d)
+			}
+		}
+	}
+}
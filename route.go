@@ -0,0 +1,20 @@
+package limiter
+
+import "context"
+
+// routeKey is the context key for the route.
+type routeKey struct{}
+
+// WithRoute returns a copy of ctx that carries the route of the request being
+// limited, like "GET /users/{id}". Stores and middleware that record takes,
+// like redisstore.AuditDenials, read it in Take.
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// RouteFromContext returns the route carried by ctx, or false if there is
+// none.
+func RouteFromContext(ctx context.Context) (string, bool) {
+	route, ok := ctx.Value(routeKey{}).(string)
+	return route, ok
+}
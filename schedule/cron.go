@@ -0,0 +1,145 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField is the set of values a field of a cron expression matches, as a
+// bit set.
+type cronField uint64
+
+// cronBounds are the names and ranges of the fields of a cron expression, in
+// order.
+var cronBounds = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+// cron is a parsed cron expression.
+type cron struct {
+	minute, hour, dom, month, dow cronField
+
+	// domAny and dowAny are set if the day fields are "*". Like cron, if both
+	// day fields are restricted, a time matches if either does.
+	domAny, dowAny bool
+}
+
+// parseCron parses a five-field cron expression: minute, hour, day of month,
+// month, and day of week, where Sunday is 0 or 7. Each field is "*", a number,
+// a range like "1-5", any of them with a step like "*/15" or "0-30/10", or a
+// comma-separated list of those.
+func parseCron(spec string) (*cron, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != len(cronBounds) {
+		return nil, fmt.Errorf("expected %d fields, got %d", len(cronBounds), len(parts))
+	}
+
+	fields := make([]cronField, len(parts))
+	for i, part := range parts {
+		b := cronBounds[i]
+		max := b.max
+		if i == 4 {
+			// Allow 7 for Sunday.
+			max = 7
+		}
+
+		f, err := parseCronField(part, b.min, max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", b.name, part, err)
+		}
+		fields[i] = f
+	}
+
+	// Sunday is 0.
+	if fields[4]&(1<<7) != 0 {
+		fields[4] = fields[4]&^(1<<7) | 1
+	}
+
+	return &cron{
+		minute: fields[0],
+		hour:   fields[1],
+		dom:    fields[2],
+		month:  fields[3],
+		dow:    fields[4],
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}, nil
+}
+
+// parseCronField parses a single field with values in [min, max].
+func parseCronField(s string, min, max int) (cronField, error) {
+	var f cronField
+	for _, item := range strings.Split(s, ",") {
+		rng, step := item, 1
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", item[i+1:])
+			}
+			rng, step = item[:i], n
+		}
+
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			i := strings.IndexByte(rng, '-')
+			var err error
+			if lo, err = strconv.Atoi(rng[:i]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", rng[:i])
+			}
+			if hi, err = strconv.Atoi(rng[i+1:]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", rng[i+1:])
+			}
+		default:
+			n, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", rng)
+			}
+			lo, hi = n, n
+			if step > 1 {
+				// Like cron, "n/step" means from n to the maximum.
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("range %d-%d is outside %d-%d", lo, hi, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			f |= 1 << uint(v)
+		}
+	}
+	return f, nil
+}
+
+// matches reports whether the minute of t matches the expression.
+func (c *cron) matches(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 ||
+		c.hour&(1<<uint(t.Hour())) == 0 ||
+		c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
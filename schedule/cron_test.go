@@ -0,0 +1,115 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	t.Parallel()
+
+	// 2020-01-06 is a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2020, time.January, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		name  string
+		spec  string
+		times map[time.Time]bool
+	}{
+		{
+			name: "any",
+			spec: "* * * * *",
+			times: map[time.Time]bool{
+				at(6, 0, 0):    true,
+				at(12, 23, 59): true,
+			},
+		},
+		{
+			name: "working_hours",
+			spec: "* 9-17 * * 1-5",
+			times: map[time.Time]bool{
+				at(6, 9, 0):   true,
+				at(6, 17, 59): true,
+				at(6, 18, 0):  false,
+				at(5, 12, 0):  false,
+			},
+		},
+		{
+			name: "steps_and_lists",
+			spec: "*/15,7 0 * * *",
+			times: map[time.Time]bool{
+				at(6, 0, 0):  true,
+				at(6, 0, 7):  true,
+				at(6, 0, 45): true,
+				at(6, 0, 46): false,
+			},
+		},
+		{
+			name: "start_step",
+			spec: "30/10 * * * *",
+			times: map[time.Time]bool{
+				at(6, 0, 20): false,
+				at(6, 0, 30): true,
+				at(6, 0, 50): true,
+			},
+		},
+		{
+			name: "sunday_seven",
+			spec: "* * * * 7",
+			times: map[time.Time]bool{
+				at(5, 0, 0): true,
+				at(6, 0, 0): false,
+			},
+		},
+		{
+			name: "either_day",
+			spec: "* * 1 * 1",
+			times: map[time.Time]bool{
+				at(1, 0, 0): true,
+				at(6, 0, 0): true,
+				at(7, 0, 0): false,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c, err := parseCron(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for at, want := range tc.times {
+				if got := c.matches(at); got != want {
+					t.Errorf("%s: expected %t to be %t", at, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	t.Parallel()
+
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Package schedule varies limits by the time of day, like higher limits
+// off-peak or near-zero limits during maintenance freezes, using cron-like
+// expressions.
+//
+// A Schedule resolves the effective limit when it is asked, so it plugs into
+// anything that resolves limits per request, like a tiered HTTP middleware:
+//
+//	sched, err := schedule.New(&schedule.Config{
+//		Rules: []schedule.Rule{
+//			// Freeze on Sundays from 02:00 to 03:59.
+//			{Spec: "* 2-3 * * 0", Tokens: 1, Interval: time.Hour},
+//			// Double the limits at night.
+//			{Spec: "* 0-6,22-23 * * *", Factor: 2},
+//		},
+//	})
+//	middleware, err := httplimit.NewTieredMiddleware(keyFunc,
+//		sched.LimitFunc(baseLimitFunc), storeFunc)
+package schedule
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/sethvargo/go-limiter/httplimit"
+)
+
+// Rule is a limit that applies at the times its expression matches.
+type Rule struct {
+	// Spec is the cron expression of the minutes the rule applies in, with the
+	// fields minute, hour, day of month, month, and day of week, where Sunday
+	// is 0 or 7. For example, "* 9-17 * * 1-5" is working hours on weekdays.
+	// It is required.
+	Spec string
+
+	// Tokens and Interval are the limit while the rule applies. If Tokens is
+	// 0, Factor is used instead. The default Interval is the interval of the
+	// base limit.
+	Tokens   uint64
+	Interval time.Duration
+
+	// Factor scales the tokens of the base limit while the rule applies, like 2
+	// to double them. The result is rounded down, but is at least 1 token, so a
+	// small factor never makes requests unlimited. It is used only if Tokens is
+	// 0.
+	Factor float64
+}
+
+// Config is used as input to New.
+type Config struct {
+	// Rules are the scheduled limits. The first rule that applies at a time
+	// wins. If none applies, the base limit is used.
+	Rules []Rule
+
+	// Location is the time zone the expressions are evaluated in. The default
+	// value is UTC.
+	Location *time.Location
+}
+
+// Schedule resolves the limit that applies at a time.
+type Schedule struct {
+	rules    []rule
+	location *time.Location
+}
+
+type rule struct {
+	Rule
+	cron *cron
+}
+
+// New parses the rules of the schedule.
+func New(c *Config) (*Schedule, error) {
+	if c == nil {
+		c = new(Config)
+	}
+
+	location := time.UTC
+	if c.Location != nil {
+		location = c.Location
+	}
+
+	rules := make([]rule, 0, len(c.Rules))
+	for i, r := range c.Rules {
+		cron, err := parseCron(r.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		if r.Tokens == 0 && r.Factor <= 0 {
+			return nil, fmt.Errorf("rule %d: tokens or a positive factor is required", i)
+		}
+		rules = append(rules, rule{Rule: r, cron: cron})
+	}
+
+	return &Schedule{
+		rules:    rules,
+		location: location,
+	}, nil
+}
+
+// Limit returns the limit that applies at t, given the base limit that
+// applies otherwise. A base limit of 0 tokens, which means unlimited, is only
+// replaced by rules with Tokens.
+func (s *Schedule) Limit(t time.Time, tokens uint64, interval time.Duration) (uint64, time.Duration) {
+	t = t.In(s.location)
+	for _, r := range s.rules {
+		if !r.cron.matches(t) {
+			continue
+		}
+
+		if r.Tokens > 0 {
+			if r.Interval > 0 {
+				return r.Tokens, r.Interval
+			}
+			return r.Tokens, interval
+		}
+		if tokens == 0 {
+			return 0, interval
+		}
+		return uint64(math.Max(math.Floor(float64(tokens)*r.Factor), 1)), interval
+	}
+	return tokens, interval
+}
+
+// LimitFunc returns a function that resolves the base limit of each request
+// with base and applies the schedule at the current time.
+func (s *Schedule) LimitFunc(base httplimit.LimitFunc) httplimit.LimitFunc {
+	return func(key string, r *http.Request) (uint64, time.Duration) {
+		tokens, interval := base(key, r)
+		return s.Limit(time.Now(), tokens, interval)
+	}
+}
+
+// StaticLimitFunc returns a LimitFunc with the same base limit for every
+// request, for schedules that are the only source of variation.
+func StaticLimitFunc(tokens uint64, interval time.Duration) httplimit.LimitFunc {
+	return func(string, *http.Request) (uint64, time.Duration) {
+		return tokens, interval
+	}
+}
@@ -0,0 +1,136 @@
+package schedule_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/schedule"
+)
+
+func TestSchedule_Limit(t *testing.T) {
+	t.Parallel()
+
+	sched, err := schedule.New(&schedule.Config{
+		Rules: []schedule.Rule{
+			{Spec: "* 2-3 * * 0", Tokens: 1, Interval: time.Hour},
+			{Spec: "* 0-6,22-23 * * *", Factor: 2},
+			{Spec: "* 12 * * *", Factor: 0.01},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2020-01-05 is a Sunday.
+	at := func(day, hour int) time.Time {
+		return time.Date(2020, time.January, day, hour, 30, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		name         string
+		at           time.Time
+		tokens       uint64
+		wantTokens   uint64
+		wantInterval time.Duration
+	}{
+		{
+			name:         "freeze",
+			at:           at(5, 2),
+			tokens:       100,
+			wantTokens:   1,
+			wantInterval: time.Hour,
+		},
+		{
+			name:         "off_peak",
+			at:           at(6, 2),
+			tokens:       100,
+			wantTokens:   200,
+			wantInterval: time.Minute,
+		},
+		{
+			name:         "peak",
+			at:           at(6, 10),
+			tokens:       100,
+			wantTokens:   100,
+			wantInterval: time.Minute,
+		},
+		{
+			name:         "at_least_one",
+			at:           at(6, 12),
+			tokens:       50,
+			wantTokens:   1,
+			wantInterval: time.Minute,
+		},
+		{
+			name:         "unlimited",
+			at:           at(6, 2),
+			tokens:       0,
+			wantTokens:   0,
+			wantInterval: time.Minute,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tokens, interval := sched.Limit(tc.at, tc.tokens, time.Minute)
+			if got, want := tokens, tc.wantTokens; got != want {
+				t.Errorf("tokens: expected %d to be %d", got, want)
+			}
+			if got, want := interval, tc.wantInterval; got != want {
+				t.Errorf("interval: expected %s to be %s", got, want)
+			}
+		})
+	}
+}
+
+func TestSchedule_Location(t *testing.T) {
+	t.Parallel()
+
+	sched, err := schedule.New(&schedule.Config{
+		Rules:    []schedule.Rule{{Spec: "* 9 * * *", Tokens: 5}},
+		Location: time.FixedZone("UTC+2", 2*60*60),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 07:00 UTC is 09:00 in the schedule's time zone.
+	if got, _ := sched.Limit(time.Date(2020, time.January, 6, 7, 0, 0, 0, time.UTC), 1, time.Second); got != 5 {
+		t.Errorf("expected %d to be %d", got, 5)
+	}
+}
+
+func TestSchedule_LimitFunc(t *testing.T) {
+	t.Parallel()
+
+	sched, err := schedule.New(&schedule.Config{
+		Rules: []schedule.Rule{{Spec: "* * * * *", Factor: 3}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := sched.LimitFunc(schedule.StaticLimitFunc(2, time.Second))
+	tokens, interval := f("key", httptest.NewRequest("GET", "/", nil))
+	if tokens != 6 || interval != time.Second {
+		t.Errorf("expected %d, %s to be 6, 1s", tokens, interval)
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	for _, r := range []schedule.Rule{
+		{Spec: "* * *", Tokens: 1},
+		{Spec: "* * * * *"},
+	} {
+		if _, err := schedule.New(&schedule.Config{Rules: []schedule.Rule{r}}); err == nil {
+			t.Errorf("%#v: expected error", r)
+		}
+	}
+}
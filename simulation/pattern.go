@@ -0,0 +1,121 @@
+package simulation
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Pattern generates the arrival times of requests.
+type Pattern interface {
+	// Arrivals returns the times of the requests in [0, d), as offsets from the
+	// start of the simulation, in ascending order. Requests may arrive at the
+	// same time.
+	Arrivals(r *rand.Rand, d time.Duration) []time.Duration
+}
+
+// Poisson is steady random traffic, with exponentially distributed gaps
+// between requests.
+type Poisson struct {
+	// Rate is the mean number of requests per second.
+	Rate float64
+}
+
+// Arrivals implements Pattern.
+func (p Poisson) Arrivals(r *rand.Rand, d time.Duration) []time.Duration {
+	if p.Rate <= 0 {
+		return nil
+	}
+
+	var arrivals []time.Duration
+	var t time.Duration
+	for {
+		t += time.Duration(r.ExpFloat64() / p.Rate * float64(time.Second))
+		if t >= d {
+			return arrivals
+		}
+		arrivals = append(arrivals, t)
+	}
+}
+
+// Bursty is Poisson traffic with periodic bursts of requests on top, like
+// clients that retry together or batch jobs that start on the hour.
+type Bursty struct {
+	// Rate is the mean number of requests per second between bursts.
+	Rate float64
+
+	// Burst is the number of requests in each burst.
+	Burst int
+
+	// Every is the time between bursts. The first burst is at the start.
+	Every time.Duration
+
+	// Spread is how long each burst lasts. The requests of a burst arrive
+	// uniformly at random within it. The default is 0, so they all arrive at
+	// once.
+	Spread time.Duration
+}
+
+// Arrivals implements Pattern.
+func (p Bursty) Arrivals(r *rand.Rand, d time.Duration) []time.Duration {
+	arrivals := Poisson{Rate: p.Rate}.Arrivals(r, d)
+	if p.Burst <= 0 || p.Every <= 0 {
+		return arrivals
+	}
+
+	for start := time.Duration(0); start < d; start += p.Every {
+		for i := 0; i < p.Burst; i++ {
+			t := start
+			if p.Spread > 0 {
+				t += time.Duration(r.Int63n(int64(p.Spread)))
+			}
+			if t < d {
+				arrivals = append(arrivals, t)
+			}
+		}
+	}
+
+	sort.Slice(arrivals, func(i, j int) bool { return arrivals[i] < arrivals[j] })
+	return arrivals
+}
+
+// Adversarial is a client that knows the limiter's interval and sends a burst
+// just before and just after each interval boundary, to get up to twice the
+// limit through in a short time from limiters that refill all at once.
+//
+// It sends a single request at the start, since the built-in stores start the
+// intervals of a new key at its first take, and then takes the boundaries to
+// be multiples of Interval from the start.
+type Adversarial struct {
+	// Interval is the limiter's interval.
+	Interval time.Duration
+
+	// Burst is the number of requests sent on each side of a boundary.
+	Burst int
+
+	// Lead is how far before each boundary the first burst is sent. The second
+	// is sent at the boundary. The default is a tenth of Interval.
+	Lead time.Duration
+}
+
+// Arrivals implements Pattern.
+func (p Adversarial) Arrivals(_ *rand.Rand, d time.Duration) []time.Duration {
+	if p.Interval <= 0 || p.Burst <= 0 {
+		return nil
+	}
+
+	lead := p.Interval / 10
+	if p.Lead > 0 && p.Lead < p.Interval {
+		lead = p.Lead
+	}
+
+	arrivals := []time.Duration{0}
+	for boundary := p.Interval; boundary < d; boundary += p.Interval {
+		for _, t := range []time.Duration{boundary - lead, boundary} {
+			for i := 0; i < p.Burst; i++ {
+				arrivals = append(arrivals, t)
+			}
+		}
+	}
+	return arrivals
+}
@@ -0,0 +1,107 @@
+package simulation
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPatterns(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		pattern Pattern
+		d       time.Duration
+		min     int
+		max     int
+	}{
+		{
+			name:    "poisson",
+			pattern: Poisson{Rate: 1000},
+			d:       10 * time.Second,
+			min:     9500,
+			max:     10500,
+		},
+		{
+			name:    "poisson_zero",
+			pattern: Poisson{},
+			d:       time.Second,
+		},
+		{
+			name:    "bursty",
+			pattern: Bursty{Burst: 5, Every: 100 * time.Millisecond, Spread: 10 * time.Millisecond},
+			d:       time.Second,
+			min:     50,
+			max:     50,
+		},
+		{
+			name:    "adversarial",
+			pattern: Adversarial{Interval: 100 * time.Millisecond, Burst: 3},
+			d:       time.Second,
+			min:     55,
+			max:     55,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			arrivals := tc.pattern.Arrivals(rand.New(rand.NewSource(1)), tc.d)
+			if got := len(arrivals); got < tc.min || got > tc.max {
+				t.Errorf("expected %d arrivals to be in [%d, %d]", got, tc.min, tc.max)
+			}
+			if !sort.SliceIsSorted(arrivals, func(i, j int) bool { return arrivals[i] < arrivals[j] }) {
+				t.Errorf("expected arrivals to be sorted")
+			}
+			for _, a := range arrivals {
+				if a < 0 || a >= tc.d {
+					t.Errorf("expected arrival %s to be in [0, %s)", a, tc.d)
+				}
+			}
+
+			again := tc.pattern.Arrivals(rand.New(rand.NewSource(1)), tc.d)
+			if !reflect.DeepEqual(arrivals, again) {
+				t.Errorf("expected the same seed to give the same arrivals")
+			}
+		})
+	}
+}
+
+func TestAdversarial_Boundaries(t *testing.T) {
+	t.Parallel()
+
+	arrivals := Adversarial{Interval: 100 * time.Millisecond, Burst: 1}.
+		Arrivals(nil, 250*time.Millisecond)
+	want := []time.Duration{
+		0,
+		90 * time.Millisecond, 100 * time.Millisecond,
+		190 * time.Millisecond, 200 * time.Millisecond,
+	}
+	if got := arrivals; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v to be %v", got, want)
+	}
+}
+
+func TestPeak(t *testing.T) {
+	t.Parallel()
+
+	ms := time.Millisecond
+	offsets := []time.Duration{0, 10 * ms, 95 * ms, 100 * ms, 105 * ms, 300 * ms}
+	n, at := peak(offsets, 100*ms)
+	if got, want := n, uint64(4); got != want {
+		t.Errorf("peak: expected %d to be %d", got, want)
+	}
+	if got, want := at, 10*ms; got != want {
+		t.Errorf("peak at: expected %s to be %s", got, want)
+	}
+
+	if n, _ := peak(nil, time.Second); n != 0 {
+		t.Errorf("expected empty peak to be 0, got %d", n)
+	}
+}
@@ -0,0 +1,215 @@
+// Package simulation replays synthetic traffic against a limiter.Store and
+// reports how it was limited, to help choose a store and its parameters before
+// production.
+//
+// Simulations run in real time against a live store, so a simulation of a
+// minute takes a minute. Scale the store's interval and the pattern down
+// together to simulate longer periods quickly:
+//
+//	store, _ := memorystore.New(&memorystore.Config{
+//		Tokens:   10,
+//		Interval: 100 * time.Millisecond,
+//	})
+//	report, err := simulation.Run(ctx, &simulation.Config{
+//		Store:    store,
+//		Pattern:  simulation.Adversarial{Interval: 100 * time.Millisecond, Burst: 10},
+//		Duration: time.Second,
+//		Window:   100 * time.Millisecond,
+//	})
+package simulation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// Config is used as input to Run. It defines the simulation.
+type Config struct {
+	// Store is the store to simulate. It is not closed by Run. It is required.
+	Store limiter.Store
+
+	// Key is the key all requests take from. Use a new key for each simulation
+	// against a shared store. The default value is "simulation".
+	Key string
+
+	// Pattern generates the requests. It is required.
+	Pattern Pattern
+
+	// Duration is how long to simulate. The default value is 10 seconds.
+	Duration time.Duration
+
+	// Resolution is the width of each point of the report's curve. The default
+	// is a hundredth of Duration.
+	Resolution time.Duration
+
+	// Window is the length of the sliding window the report's peak is measured
+	// over. Set it to the store's interval to see how far boundary bursts can
+	// exceed the limit. The default value is 1 second.
+	Window time.Duration
+
+	// Seed seeds the pattern's randomness, so simulations can be repeated. The
+	// default value is 0.
+	Seed int64
+}
+
+// Report is the result of a simulation.
+type Report struct {
+	// Requests is the number of requests made, and Allowed, Denied, and Errors
+	// the number that were allowed, denied without an error, and failed with an
+	// error.
+	Requests uint64
+	Allowed  uint64
+	Denied   uint64
+	Errors   uint64
+
+	// Curve is the requests in each period of Resolution, in order.
+	Curve []Point
+
+	// Peak is the most requests allowed in any sliding window of Window, and
+	// PeakAt is the start of the first window with that many, as an offset from
+	// the start of the simulation. For a store that allows Tokens per interval,
+	// a peak above Tokens with Window set to the interval shows a boundary
+	// burst.
+	Peak   uint64
+	PeakAt time.Duration
+
+	// Lag is the most a request was made after its arrival time, because the
+	// store was slower than the pattern. Large values make the report less
+	// accurate.
+	Lag time.Duration
+}
+
+// Point is the requests in one period of a simulation.
+type Point struct {
+	// Offset is the start of the period, from the start of the simulation.
+	Offset time.Duration
+
+	// Allowed, Denied, and Errors are the outcomes of the requests made in the
+	// period.
+	Allowed uint64
+	Denied  uint64
+	Errors  uint64
+}
+
+// Run replays the pattern against the store, one request at a time, and
+// reports the outcomes. It stops early with the context's error if the
+// context is done, or with limiter.ErrStopped if the store is closed.
+func Run(ctx context.Context, c *Config) (*Report, error) {
+	if c == nil {
+		c = new(Config)
+	}
+
+	if c.Store == nil {
+		return nil, fmt.Errorf("missing store")
+	}
+	if c.Pattern == nil {
+		return nil, fmt.Errorf("missing pattern")
+	}
+
+	key := "simulation"
+	if c.Key != "" {
+		key = c.Key
+	}
+
+	duration := 10 * time.Second
+	if c.Duration > 0 {
+		duration = c.Duration
+	}
+
+	resolution := duration / 100
+	if c.Resolution > 0 {
+		resolution = c.Resolution
+	}
+	if resolution <= 0 {
+		resolution = duration
+	}
+
+	window := 1 * time.Second
+	if c.Window > 0 {
+		window = c.Window
+	}
+
+	arrivals := c.Pattern.Arrivals(rand.New(rand.NewSource(c.Seed)), duration)
+
+	report := &Report{
+		Curve: make([]Point, (duration+resolution-1)/resolution),
+	}
+	for i := range report.Curve {
+		report.Curve[i].Offset = time.Duration(i) * resolution
+	}
+
+	// allowed holds the offsets of the allowed requests, for the peak.
+	allowed := make([]time.Duration, 0, len(arrivals))
+
+	start := time.Now()
+	for _, arrival := range arrivals {
+		if wait := arrival - time.Since(start); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		offset := time.Since(start)
+		if lag := offset - arrival; lag > report.Lag {
+			report.Lag = lag
+		}
+
+		res, err := c.Store.Take(ctx, key)
+		if errors.Is(err, limiter.ErrStopped) {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		// Requests that lag past the end are counted in the last point.
+		i := int(offset / resolution)
+		if i >= len(report.Curve) {
+			i = len(report.Curve) - 1
+		}
+		p := &report.Curve[i]
+
+		report.Requests++
+		switch {
+		case err != nil:
+			report.Errors++
+			p.Errors++
+		case res.Allowed:
+			report.Allowed++
+			p.Allowed++
+			allowed = append(allowed, offset)
+		default:
+			report.Denied++
+			p.Denied++
+		}
+	}
+
+	report.Peak, report.PeakAt = peak(allowed, window)
+	return report, nil
+}
+
+// peak returns the most offsets in any window of the given length, and the
+// first offset of the first such window. The offsets must be sorted.
+func peak(offsets []time.Duration, window time.Duration) (uint64, time.Duration) {
+	var best uint64
+	var at time.Duration
+	first := 0
+	for last := range offsets {
+		for offsets[last]-offsets[first] >= window {
+			first++
+		}
+		if n := uint64(last - first + 1); n > best {
+			best, at = n, offsets[first]
+		}
+	}
+	return best, at
+}
@@ -0,0 +1,123 @@
+package simulation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	const tokens = 10
+	interval := 100 * time.Millisecond
+
+	cases := []struct {
+		name    string
+		pattern Pattern
+		// minPeak is the lowest expected peak, which is above tokens for
+		// patterns that burst across interval boundaries.
+		minPeak uint64
+	}{
+		{
+			name:    "poisson",
+			pattern: Poisson{Rate: 500},
+			minPeak: tokens,
+		},
+		{
+			name:    "adversarial",
+			pattern: Adversarial{Interval: interval, Burst: tokens},
+			minPeak: 2*tokens - 1,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := memorystore.New(&memorystore.Config{
+				Tokens:   tokens,
+				Interval: interval,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			report, err := Run(context.Background(), &Config{
+				Store:      s,
+				Pattern:    tc.pattern,
+				Duration:   500 * time.Millisecond,
+				Resolution: 50 * time.Millisecond,
+				Window:     interval,
+				Seed:       1,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := report.Allowed+report.Denied+report.Errors, report.Requests; got != want {
+				t.Errorf("outcomes: expected %d to be %d", got, want)
+			}
+			if got, want := report.Errors, uint64(0); got != want {
+				t.Errorf("errors: expected %d to be %d", got, want)
+			}
+			if got, want := len(report.Curve), 10; got != want {
+				t.Errorf("curve: expected %d points to be %d", got, want)
+			}
+
+			var allowed uint64
+			for _, p := range report.Curve {
+				allowed += p.Allowed
+			}
+			if got, want := allowed, report.Allowed; got != want {
+				t.Errorf("curve: expected %d allowed to be %d", got, want)
+			}
+
+			// At most tokens per interval, once for each interval started.
+			if max := uint64(tokens * 6); report.Allowed > max {
+				t.Errorf("allowed: expected %d to be at most %d", report.Allowed, max)
+			}
+			if report.Peak < tc.minPeak || report.Peak > 2*tokens {
+				t.Errorf("peak: expected %d to be in [%d, %d]", report.Peak, tc.minPeak, 2*tokens)
+			}
+		})
+	}
+}
+
+func TestRun_Validation(t *testing.T) {
+	t.Parallel()
+
+	if _, err := Run(context.Background(), &Config{Pattern: Poisson{Rate: 1}}); err == nil {
+		t.Errorf("expected error for missing store")
+	}
+	if _, err := Run(context.Background(), nil); err == nil {
+		t.Errorf("expected error for missing config")
+	}
+}
+
+func TestRun_Canceled(t *testing.T) {
+	t.Parallel()
+
+	s, err := memorystore.New(&memorystore.Config{Tokens: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = Run(ctx, &Config{
+		Store:    s,
+		Pattern:  Poisson{Rate: 100},
+		Duration: time.Minute,
+	})
+	if got, want := err, context.DeadlineExceeded; got != want {
+		t.Errorf("expected %v to be %v", got, want)
+	}
+}
@@ -0,0 +1,222 @@
+// Package slowclient limits clients whose requests are consistently expensive
+// more aggressively than the rest, which guards shared capacity from
+// pathological query patterns that a request rate alone does not catch.
+//
+// The cost of each request, like its latency or the rows it scanned, is
+// observed after it is served. A key whose recent requests mostly cost more
+// than a percentile of all recent requests is a slow client, and its takes
+// must also pass a stricter store. Costs are tracked in process, so each
+// instance of an application judges its own traffic.
+package slowclient
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+var _ limiter.Store = (*Store)(nil)
+
+// Store wraps a limiter.Store and takes from the stricter store as well for
+// slow clients.
+type Store struct {
+	store limiter.Store
+	slow  limiter.Store
+
+	percentile   float64
+	slowFraction float64
+	minRequests  int
+	alpha        float64
+	idleTTL      time.Duration
+
+	// lock guards the samples, the threshold, and the keys. samples is a ring
+	// of the most recent costs, and threshold is their percentile as of the
+	// last recompute.
+	lock      sync.Mutex
+	samples   []float64
+	next      int
+	filled    bool
+	observed  int
+	threshold float64
+	keys      map[string]*client
+}
+
+// client is the recent history of a key.
+type client struct {
+	// score is the exponentially weighted fraction of the key's requests that
+	// cost more than the threshold, and requests is how many were observed.
+	score    float64
+	requests int
+	lastSeen time.Time
+}
+
+// Config is used as input to New.
+type Config struct {
+	// Percentile is the percentile of recent costs above which a request is
+	// slow. It must be in (0, 1). The default value is 0.95.
+	Percentile float64
+
+	// Samples is the number of most recent costs, over all keys, the
+	// percentile is computed from. The default value is 1000.
+	Samples int
+
+	// SlowFraction is the fraction of a key's recent requests that must be
+	// slow for it to be a slow client. It must be in (0, 1]. The default value
+	// is 0.5.
+	SlowFraction float64
+
+	// MinRequests is the number of requests of a key that must be observed
+	// before it can be a slow client, and roughly how many of its most recent
+	// requests count toward its fraction. The default value is 10.
+	MinRequests int
+
+	// IdleTTL is how long a key is remembered after its last observed
+	// request. The default value is 10 minutes.
+	IdleTTL time.Duration
+}
+
+// New wraps the store so slow clients also take from the slow store, which
+// should allow fewer tokens than s, like a tenth of them.
+func New(s, slow limiter.Store, c *Config) (*Store, error) {
+	if s == nil || slow == nil {
+		return nil, fmt.Errorf("missing store")
+	}
+	if c == nil {
+		c = new(Config)
+	}
+
+	percentile := 0.95
+	if c.Percentile != 0 {
+		percentile = c.Percentile
+	}
+	if percentile <= 0 || percentile >= 1 {
+		return nil, fmt.Errorf("percentile must be in (0, 1)")
+	}
+
+	samples := 1000
+	if c.Samples > 0 {
+		samples = c.Samples
+	}
+
+	slowFraction := 0.5
+	if c.SlowFraction != 0 {
+		slowFraction = c.SlowFraction
+	}
+	if slowFraction <= 0 || slowFraction > 1 {
+		return nil, fmt.Errorf("slow fraction must be in (0, 1]")
+	}
+
+	minRequests := 10
+	if c.MinRequests > 0 {
+		minRequests = c.MinRequests
+	}
+
+	idleTTL := 10 * time.Minute
+	if c.IdleTTL > 0 {
+		idleTTL = c.IdleTTL
+	}
+
+	return &Store{
+		store:        s,
+		slow:         slow,
+		percentile:   percentile,
+		slowFraction: slowFraction,
+		minRequests:  minRequests,
+		alpha:        2 / float64(minRequests+1),
+		idleTTL:      idleTTL,
+		samples:      make([]float64, samples),
+		threshold:    math.Inf(1),
+		keys:         make(map[string]*client),
+	}, nil
+}
+
+// Take takes from the underlying store and, if the key is a slow client, from
+// the slow store too. The result of a slow client is that of the slow store,
+// unless the underlying store rejected the take.
+func (s *Store) Take(ctx context.Context, key string) (limiter.Result, error) {
+	res, err := s.store.Take(ctx, key)
+	if err != nil || !res.Allowed || !s.Slow(key) {
+		return res, err
+	}
+	return s.slow.Take(ctx, key)
+}
+
+// Observe records the cost of a served request of the key, in any unit, as
+// long as it is the same for every request.
+func (s *Store) Observe(key string, cost float64) {
+	now := time.Now()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.samples[s.next] = cost
+	s.next++
+	if s.next == len(s.samples) {
+		s.next, s.filled = 0, true
+	}
+
+	// The percentile is recomputed every tenth of the samples, which bounds
+	// the cost of sorting them.
+	s.observed++
+	if s.observed >= len(s.samples)/10 {
+		s.observed = 0
+		s.recompute(now)
+	}
+
+	c, ok := s.keys[key]
+	if !ok {
+		c = new(client)
+		s.keys[key] = c
+	}
+	var slow float64
+	if cost > s.threshold {
+		slow = 1
+	}
+	c.score += s.alpha * (slow - c.score)
+	c.requests++
+	c.lastSeen = now
+}
+
+// Slow reports whether the key is a slow client.
+func (s *Store) Slow(key string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	c, ok := s.keys[key]
+	return ok && c.requests >= s.minRequests && c.score >= s.slowFraction
+}
+
+// Close closes the underlying store and the slow store.
+func (s *Store) Close() error {
+	err := s.store.Close()
+	if serr := s.slow.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// recompute updates the threshold from the samples and forgets idle keys. It
+// must be called with the lock held.
+func (s *Store) recompute(now time.Time) {
+	n := s.next
+	if s.filled {
+		n = len(s.samples)
+	}
+	if n >= s.minRequests {
+		sorted := make([]float64, n)
+		copy(sorted, s.samples[:n])
+		sort.Float64s(sorted)
+		s.threshold = sorted[int(s.percentile*float64(n-1))]
+	}
+
+	for key, c := range s.keys {
+		if now.Sub(c.lastSeen) > s.idleTTL {
+			delete(s.keys, key)
+		}
+	}
+}
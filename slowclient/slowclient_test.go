@@ -0,0 +1,94 @@
+package slowclient
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/limittest"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestStore_Slow(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(limittest.NewStore(100, time.Hour), limittest.NewStore(1, time.Hour), &Config{Samples: 400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 200; i++ {
+		s.Observe(fmt.Sprintf("fast%d", i%20), 1)
+	}
+	for i := 0; i < 200; i++ {
+		if i%20 == 0 {
+			s.Observe("heavy", 100)
+		}
+		s.Observe(fmt.Sprintf("fast%d", i%20), 1)
+	}
+
+	if !s.Slow("heavy") {
+		t.Error("expected heavy to be slow")
+	}
+	if s.Slow("fast0") {
+		t.Error("expected fast0 not to be slow")
+	}
+	if s.Slow("unknown") {
+		t.Error("expected unknown not to be slow")
+	}
+
+	// Slow clients are held to the slow store's single token.
+	ctx := context.Background()
+	for i, want := range []bool{true, false} {
+		res, err := s.Take(ctx, "heavy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Allowed; got != want {
+			t.Errorf("heavy take %d: expected %t to be %t", i, got, want)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		res, err := s.Take(ctx, "fast0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			t.Errorf("fast take %d: expected to be allowed", i)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	ms, err := memorystore.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ms.Close()
+
+	cases := []struct {
+		name string
+		c    *Config
+	}{
+		{"percentile", &Config{Percentile: 1}},
+		{"slow_fraction", &Config{SlowFraction: 1.5}},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := New(ms, ms, tc.c); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	if _, err := New(ms, nil, nil); err == nil {
+		t.Error("expected error for missing store")
+	}
+}
@@ -0,0 +1,325 @@
+package sqllimit
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+)
+
+var (
+	_ driver.Driver             = (*wrappedDriver)(nil)
+	_ driver.DriverContext      = (*wrappedDriver)(nil)
+	_ driver.Connector          = (*wrappedConnector)(nil)
+	_ driver.Conn               = (*wrappedConn)(nil)
+	_ driver.ConnPrepareContext = (*wrappedConn)(nil)
+	_ driver.ConnBeginTx        = (*wrappedConn)(nil)
+	_ driver.ExecerContext      = (*wrappedConn)(nil)
+	_ driver.QueryerContext     = (*wrappedConn)(nil)
+	_ driver.Pinger             = (*wrappedConn)(nil)
+	_ driver.SessionResetter    = (*wrappedConn)(nil)
+	_ driver.NamedValueChecker  = (*wrappedConn)(nil)
+	_ driver.Stmt               = (*wrappedStmt)(nil)
+	_ driver.StmtExecContext    = (*wrappedStmt)(nil)
+	_ driver.StmtQueryContext   = (*wrappedStmt)(nil)
+	_ driver.NamedValueChecker  = (*wrappedStmt)(nil)
+	_ io.Closer                 = (*wrappedConnector)(nil)
+)
+
+// wrappedDriver opens limited connections.
+type wrappedDriver struct {
+	driver driver.Driver
+	limit  *limit
+}
+
+// Open opens a connection of the driver and wraps it.
+func (d *wrappedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedConn{conn: conn, limit: d.limit}, nil
+}
+
+// OpenConnector returns the driver's connector for the name, or one that
+// calls Open if the driver has none, and wraps it.
+func (d *wrappedDriver) OpenConnector(name string) (driver.Connector, error) {
+	var connector driver.Connector = &dsnConnector{name: name, driver: d.driver}
+	if dc, ok := d.driver.(driver.DriverContext); ok {
+		var err error
+		if connector, err = dc.OpenConnector(name); err != nil {
+			return nil, err
+		}
+	}
+	return &wrappedConnector{connector: connector, driver: d, limit: d.limit}, nil
+}
+
+// dsnConnector is the connector of drivers without one, like database/sql
+// uses for them.
+type dsnConnector struct {
+	name   string
+	driver driver.Driver
+}
+
+func (c *dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.name)
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// wrappedConnector connects limited connections.
+type wrappedConnector struct {
+	connector driver.Connector
+	driver    *wrappedDriver
+	limit     *limit
+}
+
+// Connect connects a connection of the connector and wraps it.
+func (c *wrappedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedConn{conn: conn, limit: c.limit}, nil
+}
+
+// Driver returns the wrapped driver.
+func (c *wrappedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close closes the connector, if it implements io.Closer.
+func (c *wrappedConnector) Close() error {
+	if closer, ok := c.connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// wrappedConn limits the queries of a connection. It implements the optional
+// interfaces of database/sql whether or not the connection does, and falls
+// back to what database/sql would do without them.
+type wrappedConn struct {
+	conn  driver.Conn
+	limit *limit
+}
+
+func (c *wrappedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext prepares a statement whose executions are limited. Preparing
+// it does not take a token.
+func (c *wrappedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		stmt, err = c.conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedStmt{stmt: stmt, conn: c.conn, query: query, limit: c.limit}, nil
+}
+
+func (c *wrappedConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *wrappedConn) Begin() (driver.Tx, error) {
+	return c.conn.Begin()
+}
+
+func (c *wrappedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != 0 {
+		return nil, errors.New("sqllimit: driver does not support non-default isolation level")
+	}
+	if opts.ReadOnly {
+		return nil, errors.New("sqllimit: driver does not support read-only transactions")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.conn.Begin()
+}
+
+// ExecContext takes a token of the query's key and executes it. If the
+// connection cannot execute queries directly, it returns driver.ErrSkip
+// without taking, and the prepared statement takes instead.
+func (c *wrappedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execerContext, hasExecerContext := c.conn.(driver.ExecerContext)
+	execer, hasExecer := c.conn.(driver.Execer)
+	if !hasExecerContext && !hasExecer {
+		return nil, driver.ErrSkip
+	}
+
+	key, err := c.limit.take(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var res driver.Result
+	if hasExecerContext {
+		res, err = execerContext.ExecContext(ctx, query, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			res, err = execer.Exec(query, values)
+		}
+	}
+	if err == driver.ErrSkip {
+		c.limit.refund(ctx, key)
+	}
+	return res, err
+}
+
+// QueryContext is like ExecContext, for queries.
+func (c *wrappedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryerContext, hasQueryerContext := c.conn.(driver.QueryerContext)
+	queryer, hasQueryer := c.conn.(driver.Queryer)
+	if !hasQueryerContext && !hasQueryer {
+		return nil, driver.ErrSkip
+	}
+
+	key, err := c.limit.take(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows driver.Rows
+	if hasQueryerContext {
+		rows, err = queryerContext.QueryContext(ctx, query, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			rows, err = queryer.Query(query, values)
+		}
+	}
+	if err == driver.ErrSkip {
+		c.limit.refund(ctx, key)
+	}
+	return rows, err
+}
+
+func (c *wrappedConn) Ping(ctx context.Context) error {
+	if p, ok := c.conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *wrappedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *wrappedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// wrappedStmt limits the executions of a prepared statement. The deprecated
+// driver.ColumnConverter of statements is not used.
+type wrappedStmt struct {
+	stmt  driver.Stmt
+	conn  driver.Conn
+	query string
+	limit *limit
+}
+
+func (s *wrappedStmt) Close() error {
+	return s.stmt.Close()
+}
+
+func (s *wrappedStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+func (s *wrappedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if _, err := s.limit.take(context.Background(), s.query); err != nil {
+		return nil, err
+	}
+	return s.stmt.Exec(args)
+}
+
+func (s *wrappedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if _, err := s.limit.take(ctx, s.query); err != nil {
+		return nil, err
+	}
+	if e, ok := s.stmt.(driver.StmtExecContext); ok {
+		return e.ExecContext(ctx, args)
+	}
+
+	values, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.stmt.Exec(values)
+}
+
+func (s *wrappedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if _, err := s.limit.take(context.Background(), s.query); err != nil {
+		return nil, err
+	}
+	return s.stmt.Query(args)
+}
+
+func (s *wrappedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if _, err := s.limit.take(ctx, s.query); err != nil {
+		return nil, err
+	}
+	if q, ok := s.stmt.(driver.StmtQueryContext); ok {
+		return q.QueryContext(ctx, args)
+	}
+
+	values, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.stmt.Query(values)
+}
+
+// CheckNamedValue uses the checker of the statement, then of its connection,
+// like database/sql does.
+func (s *wrappedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	if checker, ok := s.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// namedValues returns the values of the arguments, for drivers without
+// context methods, which do not support named arguments.
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("sqllimit: driver does not support the use of Named Parameters")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
@@ -0,0 +1,183 @@
+// Package sqllimit limits the queries of database/sql clients, so a runaway
+// tenant or a hot code path cannot overload a shared database.
+//
+// It wraps the driver, so every query and statement of the pool is limited,
+// with a key from the query's context or from the query itself:
+//
+//	db := sql.OpenDB(sqllimit.WrapConnector(connector, store, nil))
+//
+//	ctx = sqllimit.WithUser(ctx, tenantID)
+//	rows, err := db.QueryContext(ctx, "SELECT * FROM orders")
+//
+// Drivers that are only registered by name can be wrapped with Wrap and
+// registered again under a name of their own. Queries without a key, like
+// those of contexts without a user, are not limited.
+package sqllimit
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// ErrLimited is returned by queries that exceed their key's limit.
+var ErrLimited = errors.New("query rate limit exceeded")
+
+// KeyFunc returns the key a query is limited by, or "" if it is not limited.
+type KeyFunc func(ctx context.Context, query string) string
+
+// Config is used as input to Wrap and WrapConnector.
+type Config struct {
+	// KeyFunc returns the key of each query. The default is UserKey.
+	KeyFunc KeyFunc
+
+	// Wait, if true, makes queries over the limit wait for a token, like
+	// limiter.Wait, instead of failing with ErrLimited.
+	Wait bool
+}
+
+type userKey struct{}
+
+// WithUser returns a context whose queries are limited by the user, the
+// logical owner of the queries like a tenant, with UserKey.
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext returns the user set with WithUser, if any.
+func UserFromContext(ctx context.Context) (string, bool) {
+	user, ok := ctx.Value(userKey{}).(string)
+	return user, ok
+}
+
+// UserKey keys queries by the user of their context, so each user has a limit
+// of their own.
+func UserKey(ctx context.Context, query string) string {
+	user, _ := UserFromContext(ctx)
+	return user
+}
+
+// ClassKey keys queries by their StatementClass, so for example writes can be
+// limited more strictly than reads.
+func ClassKey(ctx context.Context, query string) string {
+	return StatementClass(query)
+}
+
+// StatementClass returns the leading keyword of the query in lowercase, like
+// "select", "insert", or "with", skipping whitespace, comments, and opening
+// parentheses. It returns "" if the query does not start with a keyword.
+func StatementClass(query string) string {
+	for {
+		query = strings.TrimLeftFunc(query, func(r rune) bool {
+			return unicode.IsSpace(r) || r == '('
+		})
+		switch {
+		case strings.HasPrefix(query, "--"):
+			i := strings.IndexByte(query, '\n')
+			if i < 0 {
+				return ""
+			}
+			query = query[i+1:]
+		case strings.HasPrefix(query, "/*"):
+			i := strings.Index(query, "*/")
+			if i < 0 {
+				return ""
+			}
+			query = query[i+2:]
+		default:
+			end := strings.IndexFunc(query, func(r rune) bool {
+				return !unicode.IsLetter(r)
+			})
+			if end < 0 {
+				end = len(query)
+			}
+			return strings.ToLower(query[:end])
+		}
+	}
+}
+
+// limit holds the configuration shared by the wrappers of a driver.
+type limit struct {
+	store   limiter.Store
+	keyFunc KeyFunc
+	wait    bool
+}
+
+func newLimit(s limiter.Store, c *Config) *limit {
+	if c == nil {
+		c = new(Config)
+	}
+
+	keyFunc := UserKey
+	if c.KeyFunc != nil {
+		keyFunc = c.KeyFunc
+	}
+
+	return &limit{
+		store:   s,
+		keyFunc: keyFunc,
+		wait:    c.Wait,
+	}
+}
+
+// take takes a token of the query's key. It returns the key, or "" if the
+// query is not limited.
+func (l *limit) take(ctx context.Context, query string) (string, error) {
+	key := l.keyFunc(ctx, query)
+	if key == "" {
+		return "", nil
+	}
+
+	var res limiter.Result
+	var err error
+	if l.wait {
+		res, err = limiter.Wait(ctx, l.store, key)
+	} else {
+		res, err = l.store.Take(ctx, key)
+	}
+	if err != nil && !res.Allowed {
+		return "", fmt.Errorf("failed to take: %w", err)
+	}
+	if !res.Allowed {
+		return "", ErrLimited
+	}
+	return key, nil
+}
+
+// refund returns the token of a query that the driver did not run, if the
+// store implements limiter.Refunder.
+func (l *limit) refund(ctx context.Context, key string) {
+	if key == "" {
+		return
+	}
+	if r, ok := l.store.(limiter.Refunder); ok {
+		_ = r.Refund(ctx, key, 1)
+	}
+}
+
+// Wrap returns a driver whose connections limit their queries with s.
+func Wrap(d driver.Driver, s limiter.Store, c *Config) driver.Driver {
+	return &wrappedDriver{
+		driver: d,
+		limit:  newLimit(s, c),
+	}
+}
+
+// WrapConnector returns a connector whose connections limit their queries with
+// s, for sql.OpenDB.
+func WrapConnector(conn driver.Connector, s limiter.Store, c *Config) driver.Connector {
+	l := newLimit(s, c)
+	return &wrappedConnector{
+		connector: conn,
+		driver: &wrappedDriver{
+			driver: conn.Driver(),
+			limit:  l,
+		},
+		limit: l,
+	}
+}
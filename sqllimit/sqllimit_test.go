@@ -0,0 +1,234 @@
+package sqllimit_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/memorystore"
+	"github.com/sethvargo/go-limiter/sqllimit"
+)
+
+// fakeDriver counts the queries that reach it. Its connections only prepare
+// statements, unless direct is set, so both paths of database/sql are tested.
+type fakeDriver struct {
+	direct  bool
+	queries uint64
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) {
+	if d.direct {
+		return &fakeDirectConn{fakeConn{d}}, nil
+	}
+	return &fakeConn{d}, nil
+}
+
+func (d *fakeDriver) Connect(context.Context) (driver.Conn, error) {
+	return d.Open("")
+}
+
+func (d *fakeDriver) Driver() driver.Driver {
+	return d
+}
+
+type fakeConn struct {
+	driver *fakeDriver
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return &fakeStmt{c.driver}, nil }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+type fakeDirectConn struct {
+	fakeConn
+}
+
+func (c *fakeDirectConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	atomic.AddUint64(&c.driver.queries, 1)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeDirectConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	atomic.AddUint64(&c.driver.queries, 1)
+	return fakeRows{}, nil
+}
+
+type fakeStmt struct {
+	driver *fakeDriver
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	atomic.AddUint64(&s.driver.queries, 1)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	atomic.AddUint64(&s.driver.queries, 1)
+	return fakeRows{}, nil
+}
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string         { return nil }
+func (fakeRows) Close() error              { return nil }
+func (fakeRows) Next([]driver.Value) error { return io.EOF }
+
+func TestWrapConnector(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		direct  bool
+		keyFunc sqllimit.KeyFunc
+		queries []string
+		users   []string
+		errs    []error
+	}{
+		{
+			name:    "user_prepared",
+			queries: []string{"SELECT 1", "SELECT 1", "SELECT 1", "SELECT 1"},
+			users:   []string{"alice", "alice", "alice", "bob"},
+			errs:    []error{nil, nil, sqllimit.ErrLimited, nil},
+		},
+		{
+			name:    "user_direct",
+			direct:  true,
+			queries: []string{"SELECT 1", "SELECT 1", "SELECT 1", "SELECT 1"},
+			users:   []string{"alice", "alice", "alice", "bob"},
+			errs:    []error{nil, nil, sqllimit.ErrLimited, nil},
+		},
+		{
+			name:    "no_user",
+			queries: []string{"SELECT 1", "SELECT 1", "SELECT 1"},
+			users:   []string{"", "", ""},
+			errs:    []error{nil, nil, nil},
+		},
+		{
+			name:    "class",
+			direct:  true,
+			keyFunc: sqllimit.ClassKey,
+			queries: []string{"INSERT INTO t VALUES (1)", "insert into t values (2)", "SELECT 1", "INSERT INTO t VALUES (3)"},
+			users:   []string{"", "", "", ""},
+			errs:    []error{nil, nil, nil, sqllimit.ErrLimited},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			store, err := memorystore.New(&memorystore.Config{
+				Tokens:   2,
+				Interval: time.Hour,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			d := &fakeDriver{direct: tc.direct}
+			db := sql.OpenDB(sqllimit.WrapConnector(d, store, &sqllimit.Config{
+				KeyFunc: tc.keyFunc,
+			}))
+			defer db.Close()
+
+			var served uint64
+			for i, query := range tc.queries {
+				ctx := context.Background()
+				if tc.users[i] != "" {
+					ctx = sqllimit.WithUser(ctx, tc.users[i])
+				}
+
+				// Alternate between execs and queries, which share the limit.
+				if i%2 == 0 {
+					_, err = db.ExecContext(ctx, query)
+				} else {
+					var rows *sql.Rows
+					if rows, err = db.QueryContext(ctx, query); err == nil {
+						rows.Close()
+					}
+				}
+				if !errors.Is(err, tc.errs[i]) {
+					t.Errorf("query %d: expected %v to be %v", i, err, tc.errs[i])
+				}
+				if err == nil {
+					served++
+				}
+			}
+
+			if got, want := atomic.LoadUint64(&d.queries), served; got != want {
+				t.Errorf("expected %d queries to be %d", got, want)
+			}
+		})
+	}
+}
+
+func TestWrapConnector_Wait(t *testing.T) {
+	t.Parallel()
+
+	store, err := memorystore.New(&memorystore.Config{
+		Tokens:   1,
+		Interval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	d := &fakeDriver{direct: true}
+	db := sql.OpenDB(sqllimit.WrapConnector(d, store, &sqllimit.Config{Wait: true}))
+	defer db.Close()
+
+	ctx := sqllimit.WithUser(context.Background(), "alice")
+	if _, err := db.ExecContext(ctx, "SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key has no tokens until its reset in an hour, past the deadline.
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "SELECT 1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v to be %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestStatementClass(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		query string
+		exp   string
+	}{
+		{name: "select", query: "SELECT * FROM t", exp: "select"},
+		{name: "whitespace", query: "\n\t  Update t SET x = 1", exp: "update"},
+		{name: "line_comment", query: "-- find users\nDELETE FROM users", exp: "delete"},
+		{name: "block_comment", query: "/* app:api */ INSERT INTO t VALUES (1)", exp: "insert"},
+		{name: "parentheses", query: "(SELECT 1) UNION (SELECT 2)", exp: "select"},
+		{name: "keyword_only", query: "begin", exp: "begin"},
+		{name: "unterminated_comment", query: "/* SELECT", exp: ""},
+		{name: "empty", query: "", exp: ""},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got, want := sqllimit.StatementClass(tc.query), tc.exp; got != want {
+				t.Errorf("expected %q to be %q", got, want)
+			}
+		})
+	}
+}
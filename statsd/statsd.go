@@ -0,0 +1,107 @@
+// Package statsd provides a limiter.MetricsSink that sends metrics to a StatsD
+// server over UDP. Tags are sent in the DogStatsD format, so it works with the
+// Datadog agent out of the box.
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+var _ limiter.MetricsSink = (*Sink)(nil)
+
+// Sink sends metrics to a StatsD server. Each metric is sent as its own
+// datagram, and send errors are dropped, since metrics are best effort.
+type Sink struct {
+	conn   net.Conn
+	prefix string
+	tags   []string
+
+	disableTags bool
+}
+
+// Config is used as input to New.
+type Config struct {
+	// Addr is the UDP address of the StatsD server. The default value is
+	// "127.0.0.1:8125".
+	Addr string
+
+	// Prefix is prepended to every metric name, followed by a dot. The default
+	// value is empty.
+	Prefix string
+
+	// Tags are added to every metric.
+	Tags []string
+
+	// DisableTags omits tags, for StatsD servers that do not support the
+	// DogStatsD format.
+	DisableTags bool
+}
+
+// New creates a sink that sends metrics to the configured server.
+func New(c *Config) (*Sink, error) {
+	if c == nil {
+		c = new(Config)
+	}
+
+	addr := "127.0.0.1:8125"
+	if c.Addr != "" {
+		addr = c.Addr
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial statsd: %w", err)
+	}
+
+	prefix := c.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+
+	return &Sink{
+		conn:        conn,
+		prefix:      prefix,
+		tags:        c.Tags,
+		disableTags: c.DisableTags,
+	}, nil
+}
+
+// Count sends a counter.
+func (s *Sink) Count(name string, value int64, tags []string) {
+	s.send(name, strconv.FormatInt(value, 10), "c", tags)
+}
+
+// Timing sends a timer in milliseconds.
+func (s *Sink) Timing(name string, d time.Duration, tags []string) {
+	ms := float64(d) / float64(time.Millisecond)
+	s.send(name, strconv.FormatFloat(ms, 'f', -1, 64), "ms", tags)
+}
+
+// Close closes the connection to the server.
+func (s *Sink) Close() error {
+	return s.conn.Close()
+}
+
+// send formats and sends a single metric, like "name:1|c|#tag:value".
+func (s *Sink) send(name, value, typ string, tags []string) {
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(typ)
+
+	if !s.disableTags && len(s.tags)+len(tags) > 0 {
+		b.WriteString("|#")
+		b.WriteString(strings.Join(append(s.tags[:len(s.tags):len(s.tags)], tags...), ","))
+	}
+
+	s.conn.Write([]byte(b.String()))
+}
@@ -0,0 +1,80 @@
+package statsd_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/statsd"
+)
+
+func TestSink(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		c    *statsd.Config
+		send func(s *statsd.Sink)
+		exp  string
+	}{
+		{
+			name: "count",
+			c:    &statsd.Config{},
+			send: func(s *statsd.Sink) { s.Count("limiter.take", 1, nil) },
+			exp:  "limiter.take:1|c",
+		},
+		{
+			name: "timing",
+			c:    &statsd.Config{},
+			send: func(s *statsd.Sink) { s.Timing("limiter.take.duration", 1500*time.Microsecond, nil) },
+			exp:  "limiter.take.duration:1.5|ms",
+		},
+		{
+			name: "prefix_and_tags",
+			c:    &statsd.Config{Prefix: "api", Tags: []string{"env:prod"}},
+			send: func(s *statsd.Sink) { s.Count("limiter.take", 2, []string{"result:denied"}) },
+			exp:  "api.limiter.take:2|c|#env:prod,result:denied",
+		},
+		{
+			name: "disable_tags",
+			c:    &statsd.Config{Tags: []string{"env:prod"}, DisableTags: true},
+			send: func(s *statsd.Sink) { s.Count("limiter.take", 1, []string{"result:denied"}) },
+			exp:  "limiter.take:1|c",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pc.Close()
+
+			tc.c.Addr = pc.LocalAddr().String()
+			s, err := statsd.New(tc.c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			tc.send(s)
+
+			if err := pc.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 1024)
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(buf[:n]), tc.exp; got != want {
+				t.Errorf("expected %q to be %q", got, want)
+			}
+		})
+	}
+}
@@ -1,6 +1,19 @@
 package limiter
 
-import "io"
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrStopped is the error returned when the store is stopped. All stores
+// should return this error from Take after Close has been called.
+var ErrStopped = fmt.Errorf("store is stopped")
+
+// ErrNotSupported is the error returned when an operation requires an optional
+// capability, like Refunder, that the store does not implement.
+var ErrNotSupported = fmt.Errorf("operation not supported by store")
 
 // Store is an interface for limiter storage backends.
 //
@@ -13,21 +26,75 @@ import "io"
 // the value, you must use homomorphic encryption to ensure the value always
 // encrypts to the same ciphertext.
 type Store interface {
-	// Take takes a token from the given key if available, returning:
+	// Take takes a token from the given key if available. The returned Result
+	// includes the configured limit size, the number of remaining tokens in the
+	// interval, the time when new tokens will be available, and whether the
+	// take was successful.
 	//
-	// - the configured limit size
-	// - the number of remaining tokens in the interval
-	// - the server time when new tokens will be available
-	// - whether the take was successful
-	//
-	// If "ok" is false, the take was unsuccessful and the caller should NOT
+	// If Allowed is false, the take was unsuccessful and the caller should NOT
 	// service the request.
 	//
+	// A non-nil error means the store could not make a decision, for example
+	// because the backend is unreachable. Stores that support a failure mode
+	// may still return Allowed as true alongside the error (failing open). In
+	// all other cases, Allowed is false when an error is returned.
+	//
+	// Stores may honor a Priority carried by ctx; see WithPriority.
+	//
 	// See the note about keys on the interface documentation.
-	Take(key string) (limit, remaining, reset uint64, ok bool)
+	Take(ctx context.Context, key string) (Result, error)
 
 	// Close terminates the store and cleans up any data structures or connections
 	// that may remain open. After a store is stopped, Take() should always return
-	// zero values.
+	// a zero Result and ErrStopped.
 	io.Closer
 }
+
+// Refunder is implemented by stores that can return tokens to a key. It is an
+// optional interface; use a type assertion to check whether a store supports
+// it.
+type Refunder interface {
+	// Refund returns the given number of tokens to the key, up to the configured
+	// limit. Tokens are only returned to the key's current interval: once the
+	// key has been refilled, a refund for a take in an earlier interval has no
+	// effect. Refunding a key that does not exist is a no-op.
+	Refund(ctx context.Context, key string, tokens uint64) error
+}
+
+// Charger is implemented by stores that can take more than one token from a
+// key at once, for example to charge the actual cost of a request after it was
+// served. It is an optional interface; use a type assertion to check whether a
+// store supports it.
+type Charger interface {
+	// Charge takes the given number of tokens from the key's current interval
+	// without checking the limit, since the work was already done. If fewer
+	// tokens remain, the key is left with none. Unlike Take, charging a key
+	// that does not exist creates it.
+	Charge(ctx context.Context, key string, tokens uint64) error
+}
+
+// Deleter is implemented by stores that can delete a key, which resets it: its
+// next take starts with full tokens, whatever it owed, and without metadata.
+// Unlike a refund, which is capped at the limit and returns tokens to any
+// global bucket too, it only touches the key. It is an optional interface; use
+// a type assertion to check whether a store supports it.
+type Deleter interface {
+	// Delete deletes the key. Buckets that all keys share, like a global
+	// limit, are not reset. Deleting a key that does not exist is a no-op.
+	Delete(ctx context.Context, key string) error
+}
+
+// Overrider is implemented by stores that can take from a key with a limit
+// other than their own, so one store can enforce different limits per key,
+// like free and paid plans. It is an optional interface; use a type assertion
+// to check whether a store supports it. Decorators do not forward it, since
+// the takes would bypass their Take, so the capability is lost once a store is
+// wrapped.
+type Overrider interface {
+	// TakeWith takes a token from the key like Take, but allows tokens per
+	// interval instead of the store's configured limit. The key's bucket is
+	// shared with Take, so a key should be taken from with one limit at a
+	// time. Other operations on the key, like Refund and Peek, still apply
+	// the store's own limit.
+	TakeWith(ctx context.Context, key string, tokens uint64, interval time.Duration) (Result, error)
+}
@@ -0,0 +1,81 @@
+package storetest
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// numBenchKeys is the number of unique keys the benchmarks rotate through.
+const numBenchKeys = 1000
+
+// BenchmarkStore runs the standard set of benchmarks against the stores
+// produced by f. Each benchmark runs both serially and in parallel, once with
+// a limit that is never reached and once with a small limit that is exhausted
+// and refilled constantly.
+func BenchmarkStore(b *testing.B, f Factory) {
+	b.Helper()
+
+	keys := make([]string, numBenchKeys)
+	for i := range keys {
+		keys[i] = "storetest-bench-" + strconv.Itoa(i)
+	}
+
+	cases := []struct {
+		name   string
+		config *Config
+	}{
+		{
+			name: "unlimited",
+			config: &Config{
+				Tokens:   math.MaxUint64,
+				Interval: time.Duration(math.MaxInt64),
+				TTL:      time.Hour,
+			},
+		},
+		{
+			name: "limited",
+			config: &Config{
+				Tokens:   5,
+				Interval: 500 * time.Millisecond,
+				TTL:      time.Hour,
+			},
+		},
+	}
+
+	ctx := context.Background()
+
+	for _, tc := range cases {
+		tc := tc
+
+		b.Run(tc.name, func(b *testing.B) {
+			b.Run("serial", func(b *testing.B) {
+				s := f(b, tc.config)
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					s.Take(ctx, keys[i%numBenchKeys])
+				}
+				b.StopTimer()
+				s.Close()
+			})
+
+			b.Run("parallel", func(b *testing.B) {
+				s := f(b, tc.config)
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				b.RunParallel(func(pb *testing.PB) {
+					for i := 0; pb.Next(); i++ {
+						s.Take(ctx, keys[i%numBenchKeys])
+					}
+				})
+				b.StopTimer()
+				s.Close()
+			})
+		})
+	}
+}
@@ -0,0 +1,753 @@
+// Package storetest provides a conformance test suite and standard benchmarks
+// for limiter.Store implementations.
+//
+// Store authors (built-in or third-party) can run the suite against their own
+// implementation from a regular Go test:
+//
+//	func TestStore_Conformance(t *testing.T) {
+//	  storetest.TestStore(t, func(tb testing.TB, c *storetest.Config) limiter.Store {
+//	    s, err := mystore.New(c.Tokens, c.Interval)
+//	    if err != nil {
+//	      tb.Fatal(err)
+//	    }
+//	    return s
+//	  })
+//	}
+package storetest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// resetJitter is the tolerance when comparing reset times across takes.
+const resetJitter = 10 * time.Millisecond
+
+// Config is the configuration the suite requests from a Factory.
+type Config struct {
+	// Tokens is the number of tokens to allow per interval.
+	Tokens uint64
+
+	// Interval is the time interval upon which to enforce rate limiting.
+	Interval time.Duration
+
+	// TTL is the amount of time after which an untouched key should be purged
+	// from the store. Stores that purge on a schedule should run that schedule
+	// at least this often.
+	TTL time.Duration
+}
+
+// Factory creates a new store under test from the given configuration. The
+// suite calls Close on the returned store when it is finished.
+type Factory func(tb testing.TB, c *Config) limiter.Store
+
+// TestStore runs the full conformance suite against the stores produced by f.
+// Each test runs as a parallel subtest with its own store and keys.
+func TestStore(t *testing.T, f Factory) {
+	t.Helper()
+
+	t.Run("concurrent", func(t *testing.T) {
+		t.Parallel()
+		testConcurrent(t, f)
+	})
+
+	t.Run("independent_keys", func(t *testing.T) {
+		t.Parallel()
+		testIndependentKeys(t, f)
+	})
+
+	t.Run("binary_keys", func(t *testing.T) {
+		t.Parallel()
+		testBinaryKeys(t, f)
+	})
+
+	t.Run("boundary_refill", func(t *testing.T) {
+		t.Parallel()
+		testBoundaryRefill(t, f)
+	})
+
+	t.Run("ttl_expiry", func(t *testing.T) {
+		t.Parallel()
+		testTTLExpiry(t, f)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		t.Parallel()
+		testClose(t, f)
+	})
+
+	t.Run("refund", func(t *testing.T) {
+		t.Parallel()
+		testRefund(t, f)
+	})
+
+	t.Run("charge", func(t *testing.T) {
+		t.Parallel()
+		testCharge(t, f)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		t.Parallel()
+		testDelete(t, f)
+	})
+
+	t.Run("take_with", func(t *testing.T) {
+		t.Parallel()
+		testTakeWith(t, f)
+	})
+
+	t.Run("inspect", func(t *testing.T) {
+		t.Parallel()
+		testInspect(t, f)
+	})
+
+	t.Run("peek", func(t *testing.T) {
+		t.Parallel()
+		testPeek(t, f)
+	})
+}
+
+// testConcurrent takes twice the number of available tokens concurrently and
+// verifies that exactly the configured number of takes succeed.
+func testConcurrent(t *testing.T, f Factory) {
+	const tokens = 25
+
+	s := f(t, &Config{
+		Tokens:   tokens,
+		Interval: time.Minute,
+		TTL:      time.Hour,
+	})
+	defer s.Close()
+
+	key := Key(t)
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var allowed, denied int
+	for i := 0; i < 2*tokens; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			res := take(t, s, key)
+			if got, want := res.Limit, uint64(tokens); got != want {
+				t.Errorf("limit: expected %d to be %d", got, want)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			if res.Allowed {
+				allowed++
+			} else {
+				denied++
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, want := allowed, tokens; got != want {
+		t.Errorf("allowed: expected %d to be %d", got, want)
+	}
+	if got, want := denied, tokens; got != want {
+		t.Errorf("denied: expected %d to be %d", got, want)
+	}
+}
+
+// testIndependentKeys verifies that exhausting one key does not affect
+// another key.
+func testIndependentKeys(t *testing.T, f Factory) {
+	s := f(t, &Config{
+		Tokens:   1,
+		Interval: time.Minute,
+		TTL:      time.Hour,
+	})
+	defer s.Close()
+
+	key1, key2 := Key(t), Key(t)
+
+	if res := take(t, s, key1); !res.Allowed {
+		t.Fatalf("expected first take on %q to succeed", key1)
+	}
+	if res := take(t, s, key1); res.Allowed {
+		t.Fatalf("expected second take on %q to fail", key1)
+	}
+	if res := take(t, s, key2); !res.Allowed {
+		t.Fatalf("expected first take on %q to succeed", key2)
+	}
+}
+
+// testBoundaryRefill exhausts a key and verifies that takes fail until the
+// returned reset time, and succeed after it.
+func testBoundaryRefill(t *testing.T, f Factory) {
+	const tokens = 3
+	interval := 500 * time.Millisecond
+
+	s := f(t, &Config{
+		Tokens:   tokens,
+		Interval: interval,
+		TTL:      time.Hour,
+	})
+	defer s.Close()
+
+	key := Key(t)
+
+	var resetAt time.Time
+	for i := uint64(0); i < tokens; i++ {
+		res := take(t, s, key)
+		if !res.Allowed {
+			t.Fatalf("take %d: expected to succeed", i)
+		}
+		if got, want := res.Remaining, tokens-i-1; got != want {
+			t.Errorf("take %d: remaining: expected %d to be %d", i, got, want)
+		}
+		if got, want := res.RetryAfter, time.Duration(0); got != want {
+			t.Errorf("take %d: retry after: expected %s to be %s", i, got, want)
+		}
+		resetAt = res.ResetAt
+	}
+
+	res := take(t, s, key)
+	if res.Allowed {
+		t.Fatal("expected take on exhausted key to fail")
+	}
+	if got, want := res.Remaining, uint64(0); got != want {
+		t.Errorf("remaining: expected %d to be %d", got, want)
+	}
+	// Stores may derive the reset time from a server-side duration, so allow
+	// for a small amount of jitter between takes in the same interval.
+	if res.ResetAt.Before(resetAt.Add(-resetJitter)) {
+		t.Errorf("reset: expected %s to be at least %s", res.ResetAt, resetAt)
+	}
+	if until := time.Until(res.ResetAt); until > interval {
+		t.Errorf("reset: expected %s to be less than %s", until, interval)
+	}
+	if res.RetryAfter <= 0 || res.RetryAfter > interval {
+		t.Errorf("retry after: expected %s to be in (0, %s]", res.RetryAfter, interval)
+	}
+
+	// Sleep until just past the reset time; the bucket must be refilled.
+	time.Sleep(time.Until(res.ResetAt) + 25*time.Millisecond)
+
+	res = take(t, s, key)
+	if !res.Allowed {
+		t.Fatal("expected take after reset to succeed")
+	}
+	if got, want := res.Remaining, uint64(tokens-1); got != want {
+		t.Errorf("remaining: expected %d to be %d", got, want)
+	}
+}
+
+// testTTLExpiry verifies that a key which was untouched for longer than the
+// TTL behaves as a brand new key.
+func testTTLExpiry(t *testing.T, f Factory) {
+	const tokens = 2
+	ttl := 1 * time.Second
+
+	s := f(t, &Config{
+		Tokens:   tokens,
+		Interval: ttl / 4,
+		TTL:      ttl,
+	})
+	defer s.Close()
+
+	key := Key(t)
+
+	for i := 0; i < tokens; i++ {
+		if res := take(t, s, key); !res.Allowed {
+			t.Fatalf("take %d: expected to succeed", i)
+		}
+	}
+
+	time.Sleep(2 * ttl)
+
+	res := take(t, s, key)
+	if !res.Allowed {
+		t.Fatal("expected take after expiry to succeed")
+	}
+	if got, want := res.Remaining, uint64(tokens-1); got != want {
+		t.Errorf("remaining: expected %d to be %d", got, want)
+	}
+}
+
+// testClose verifies that Close is idempotent and that a closed store returns
+// a zero result and limiter.ErrStopped for all keys.
+func testClose(t *testing.T, f Factory) {
+	s := f(t, &Config{
+		Tokens:   10,
+		Interval: time.Minute,
+		TTL:      time.Hour,
+	})
+
+	key := Key(t)
+	if res := take(t, s, key); !res.Allowed {
+		t.Fatal("expected take before close to succeed")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+
+	res, err := s.Take(context.Background(), key)
+	if !errors.Is(err, limiter.ErrStopped) {
+		t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+	if res != (limiter.Result{}) {
+		t.Errorf("expected zero result after close, got %#v", res)
+	}
+}
+
+// testRefund verifies that refunded tokens can be taken again and that refunds
+// never exceed the limit. It is skipped for stores that do not implement
+// limiter.Refunder.
+func testRefund(t *testing.T, f Factory) {
+	const tokens = 3
+
+	s := f(t, &Config{
+		Tokens:   tokens,
+		Interval: time.Minute,
+		TTL:      time.Hour,
+	})
+	defer s.Close()
+
+	r, ok := s.(limiter.Refunder)
+	if !ok {
+		t.Skip("store does not implement limiter.Refunder")
+	}
+
+	ctx := context.Background()
+	key := Key(t)
+
+	if err := r.Refund(ctx, Key(t), 1); err != nil {
+		t.Fatalf("refund of unknown key: %v", err)
+	}
+
+	for i := 0; i < tokens; i++ {
+		if res := take(t, s, key); !res.Allowed {
+			t.Fatalf("take %d: expected to succeed", i)
+		}
+	}
+	if res := take(t, s, key); res.Allowed {
+		t.Fatal("expected take on exhausted key to fail")
+	}
+
+	if err := r.Refund(ctx, key, 1); err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	res := take(t, s, key)
+	if !res.Allowed {
+		t.Fatal("expected take after refund to succeed")
+	}
+	if got, want := res.Remaining, uint64(0); got != want {
+		t.Errorf("remaining: expected %d to be %d", got, want)
+	}
+
+	// Refunds are capped at the limit.
+	if err := r.Refund(ctx, key, 100*tokens); err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	for i := 0; i < tokens; i++ {
+		if res := take(t, s, key); !res.Allowed {
+			t.Fatalf("take %d after refund: expected to succeed", i)
+		}
+	}
+	if res := take(t, s, key); res.Allowed {
+		t.Fatal("expected refund to be capped at the limit")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := r.Refund(ctx, key, 1); !errors.Is(err, limiter.ErrStopped) {
+		t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+}
+
+// testCharge verifies that charged tokens cannot be taken, that charges beyond
+// the remaining tokens empty the key, and that charging an unknown key creates
+// it. It is skipped for stores that do not implement limiter.Charger.
+func testCharge(t *testing.T, f Factory) {
+	const tokens = 5
+
+	s := f(t, &Config{
+		Tokens:   tokens,
+		Interval: time.Minute,
+		TTL:      time.Hour,
+	})
+	defer s.Close()
+
+	c, ok := s.(limiter.Charger)
+	if !ok {
+		t.Skip("store does not implement limiter.Charger")
+	}
+
+	ctx := context.Background()
+
+	// Charging an unknown key creates it with the tokens taken.
+	key := Key(t)
+	if err := c.Charge(ctx, key, 3); err != nil {
+		t.Fatalf("charge of unknown key: %v", err)
+	}
+	res := take(t, s, key)
+	if !res.Allowed {
+		t.Fatal("expected take after charge to succeed")
+	}
+	if got, want := res.Remaining, uint64(tokens-4); got != want {
+		t.Errorf("remaining: expected %d to be %d", got, want)
+	}
+
+	// Charges beyond the remaining tokens empty the key.
+	if err := c.Charge(ctx, key, 100*tokens); err != nil {
+		t.Fatalf("charge: %v", err)
+	}
+	if res := take(t, s, key); res.Allowed {
+		t.Fatal("expected take after overcharge to fail")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := c.Charge(ctx, key, 1); !errors.Is(err, limiter.ErrStopped) {
+		t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+}
+
+// testDelete verifies that a deleted key starts over with full tokens, and
+// that other keys are left alone. It is skipped for stores that do not
+// implement limiter.Deleter.
+func testDelete(t *testing.T, f Factory) {
+	const tokens = 2
+
+	s := f(t, &Config{
+		Tokens:   tokens,
+		Interval: time.Minute,
+		TTL:      time.Hour,
+	})
+	defer s.Close()
+
+	d, ok := s.(limiter.Deleter)
+	if !ok {
+		t.Skip("store does not implement limiter.Deleter")
+	}
+
+	ctx := context.Background()
+	if err := d.Delete(ctx, Key(t)); err != nil {
+		t.Fatalf("delete of unknown key: %v", err)
+	}
+
+	key, other := Key(t), Key(t)
+	for _, k := range []string{key, other} {
+		for i := 0; i < tokens; i++ {
+			if res := take(t, s, k); !res.Allowed {
+				t.Fatalf("take %d: expected to succeed", i)
+			}
+		}
+	}
+
+	if err := d.Delete(ctx, key); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	res := take(t, s, key)
+	if !res.Allowed {
+		t.Fatal("expected take after delete to succeed")
+	}
+	if got, want := res.Remaining, uint64(tokens-1); got != want {
+		t.Errorf("remaining: expected %d to be %d", got, want)
+	}
+	if res := take(t, s, other); res.Allowed {
+		t.Error("expected other key to stay exhausted")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := d.Delete(ctx, key); !errors.Is(err, limiter.ErrStopped) {
+		t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+}
+
+// testTakeWith verifies that TakeWith enforces the limit it is given instead of
+// the store's. It is skipped for stores that do not implement
+// limiter.Overrider.
+func testTakeWith(t *testing.T, f Factory) {
+	const tokens, override = 5, 2
+
+	s := f(t, &Config{
+		Tokens:   tokens,
+		Interval: time.Minute,
+		TTL:      time.Hour,
+	})
+	defer s.Close()
+
+	o, ok := s.(limiter.Overrider)
+	if !ok {
+		t.Skip("store does not implement limiter.Overrider")
+	}
+
+	ctx := context.Background()
+	key := Key(t)
+	for i := 0; i <= override; i++ {
+		res, err := o.TakeWith(ctx, key, override, time.Minute)
+		if err != nil {
+			t.Fatalf("take %d: %v", i, err)
+		}
+		if got, want := res.Allowed, i < override; got != want {
+			t.Errorf("take %d: expected %t to be %t", i, got, want)
+		}
+		if got, want := res.Limit, uint64(override); got != want {
+			t.Errorf("take %d: limit: expected %d to be %d", i, got, want)
+		}
+	}
+
+	// Other keys keep the store's limit.
+	if got, want := take(t, s, Key(t)).Remaining, uint64(tokens-1); got != want {
+		t.Errorf("remaining: expected %d to be %d", got, want)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := o.TakeWith(ctx, key, override, time.Minute); !errors.Is(err, limiter.ErrStopped) {
+		t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+}
+
+// testInspect verifies that an Inspector lists keys by pattern across pages
+// and reports the heaviest consumers first. Stores that do not implement
+// limiter.Inspector are skipped.
+func testInspect(t *testing.T, f Factory) {
+	const tokens = 5
+
+	s := f(t, &Config{
+		Tokens:   tokens,
+		Interval: time.Minute,
+		TTL:      time.Hour,
+	})
+	defer s.Close()
+
+	in, ok := s.(limiter.Inspector)
+	if !ok {
+		t.Skip("store does not implement limiter.Inspector")
+	}
+
+	ctx := context.Background()
+	prefix := Key(t)
+
+	// Key i is taken i%tokens+1 times, so every tokens-th key is empty, and
+	// enough keys are created to span pages.
+	var want []string
+	for i := 0; i < 150; i++ {
+		key := fmt.Sprintf("%s-a%03d", prefix, i)
+		want = append(want, key)
+		for j := 0; j <= i%tokens; j++ {
+			take(t, s, key)
+		}
+	}
+	take(t, s, prefix+"-b")
+
+	seen := make(map[string]struct{})
+	var cursor uint64
+	for {
+		keys, next, err := in.Keys(ctx, prefix+"-a*", cursor)
+		if err != nil {
+			t.Fatalf("keys: %v", err)
+		}
+		for _, k := range keys {
+			seen[k] = struct{}{}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	got := make([]string, 0, len(seen))
+	for k := range seen {
+		got = append(got, k)
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("keys: expected %d keys to be %d keys", len(got), len(want))
+	}
+
+	stats, err := in.Stats(ctx, 3)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if got, want := stats.Keys, uint64(151); got != want {
+		t.Errorf("stats keys: expected %d to be %d", got, want)
+	}
+	if got, want := len(stats.Top), 3; got != want {
+		t.Fatalf("stats top: expected %d to be %d", got, want)
+	}
+	for i, ks := range stats.Top {
+		if got, want := ks.Remaining, uint64(0); got != want {
+			t.Errorf("stats top %d: remaining: expected %d to be %d", i, got, want)
+		}
+	}
+	if got, want := stats.Top[0].Key, want[tokens-1]; got != want {
+		t.Errorf("stats top: expected %q to be %q", got, want)
+	}
+
+	// A negative n asks for no keys, like zero.
+	stats, err = in.Stats(ctx, -1)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if got, want := stats.Keys, uint64(151); got != want {
+		t.Errorf("stats keys: expected %d to be %d", got, want)
+	}
+	if got, want := len(stats.Top), 0; got != want {
+		t.Errorf("stats top: expected %d to be %d", got, want)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, _, err := in.Keys(ctx, "*", 0); !errors.Is(err, limiter.ErrStopped) {
+		t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+}
+
+// testPeek verifies that peeking reports the next take without consuming
+// tokens. Stores that do not implement limiter.Peeker, or do not support it in
+// their configuration, are skipped.
+func testPeek(t *testing.T, f Factory) {
+	const tokens = 2
+
+	s := f(t, &Config{
+		Tokens:   tokens,
+		Interval: time.Minute,
+		TTL:      time.Hour,
+	})
+	defer s.Close()
+
+	p, ok := s.(limiter.Peeker)
+	if !ok {
+		t.Skip("store does not implement limiter.Peeker")
+	}
+
+	ctx := context.Background()
+	key := Key(t)
+
+	res, err := p.Peek(ctx, key)
+	if errors.Is(err, limiter.ErrNotSupported) {
+		t.Skip("store does not support peeking")
+	}
+	if err != nil {
+		t.Fatalf("peek: %v", err)
+	}
+	if got, want := res.Remaining, uint64(tokens); !res.Allowed || got != want {
+		t.Errorf("peek of unknown key: expected %t, %d to be true, %d", res.Allowed, got, want)
+	}
+
+	for i := 0; i < tokens; i++ {
+		take(t, s, key)
+
+		for j := 0; j < 2; j++ {
+			res, err := p.Peek(ctx, key)
+			if err != nil {
+				t.Fatalf("peek: %v", err)
+			}
+			if got, want := res.Remaining, uint64(tokens-i-1); got != want {
+				t.Errorf("take %d: remaining: expected %d to be %d", i, got, want)
+			}
+			if got, want := res.Limit, uint64(tokens); got != want {
+				t.Errorf("take %d: limit: expected %d to be %d", i, got, want)
+			}
+		}
+	}
+
+	res, err = p.Peek(ctx, key)
+	if err != nil {
+		t.Fatalf("peek: %v", err)
+	}
+	if res.Allowed {
+		t.Error("expected peek of exhausted key to not be allowed")
+	}
+	if res.RetryAfter <= 0 || res.RetryAfter > time.Minute {
+		t.Errorf("expected retry after %s to be in (0, 1m]", res.RetryAfter)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := p.Peek(ctx, key); !errors.Is(err, limiter.ErrStopped) {
+		t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+}
+
+// testBinaryKeys verifies that keys are compared byte for byte: keys that
+// differ only in Unicode normalization, case, white space, or bytes that are
+// not valid UTF-8 are independent, and each is stored as given.
+func testBinaryKeys(t *testing.T, f Factory) {
+	s := f(t, &Config{
+		Tokens:   1,
+		Interval: time.Minute,
+		TTL:      time.Hour,
+	})
+	defer s.Close()
+
+	prefix := Key(t)
+	suffixes := []string{
+		"",
+		"caf\u00e9",  // NFC
+		"cafe\u0301", // NFD
+		"CAF\u00c9",
+		" ",
+		"\x00",
+		"\x00\x00",
+		"\xff\xfe",
+		"\r\n",
+		"*?[]\\",
+		"\U0001f600",
+	}
+
+	for i, suffix := range suffixes {
+		key := prefix + suffix
+		if res := take(t, s, key); !res.Allowed {
+			t.Errorf("key %d (%q): expected first take to be allowed", i, key)
+		}
+	}
+	for i, suffix := range suffixes {
+		key := prefix + suffix
+		if res := take(t, s, key); res.Allowed {
+			t.Errorf("key %d (%q): expected second take to be denied", i, key)
+		}
+	}
+}
+
+// take calls Take on the store and fails the test if it returns an error.
+func take(tb testing.TB, s limiter.Store, key string) limiter.Result {
+	tb.Helper()
+
+	res, err := s.Take(context.Background(), key)
+	if err != nil {
+		tb.Errorf("take %q: %v", key, err)
+	}
+	return res
+}
+
+// Key returns a random key suitable for use in tests.
+func Key(tb testing.TB) string {
+	tb.Helper()
+
+	var b [512]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		tb.Fatalf("failed to generate random string: %v", err)
+	}
+	digest := fmt.Sprintf("%x", sha256.Sum256(b[:]))
+	return digest[:32]
+}
@@ -0,0 +1,7 @@
+module github.com/sethvargo/go-limiter/traefiklimit
+
+go 1.20
+
+require github.com/sethvargo/go-limiter v0.1.0
+
+replace github.com/sethvargo/go-limiter => ../
@@ -0,0 +1,121 @@
+// Package traefiklimit is a Traefik middleware plugin that limits requests
+// with a memory or Redis store, so reverse proxies can enforce limits shared
+// across instances without Go glue.
+//
+// Traefik loads plugins from source with Yaegi, so vendor the dependencies
+// with "go mod vendor" before loading it as a local plugin. Then enable it in
+// the static configuration:
+//
+//	experimental:
+//	  localPlugins:
+//	    limiter:
+//	      moduleName: github.com/sethvargo/go-limiter/traefiklimit
+//
+// and configure it as a middleware in the dynamic configuration:
+//
+//	http:
+//	  middlewares:
+//	    api-limit:
+//	      plugin:
+//	        limiter:
+//	          redisURL: redis://localhost:6379/0
+//	          keyPrefix: "rl:"
+//	          sourceHeader: X-API-Key
+//	          tokens: 100
+//	          interval: 1m
+//
+// Requests are limited with httplimit, so they get the same headers and 429
+// responses.
+package traefiklimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/memorystore"
+	"github.com/sethvargo/go-limiter/redisstore"
+)
+
+// Config is the plugin configuration.
+type Config struct {
+	// RedisURL is the URL of the Redis server the limits are kept in, in the
+	// format of redisstore.NewFromURL. If empty, limits are kept in memory, so
+	// they are per Traefik instance.
+	RedisURL string `json:"redisURL,omitempty"`
+
+	// KeyPrefix is prepended to every key in Redis.
+	KeyPrefix string `json:"keyPrefix,omitempty"`
+
+	// SourceHeader, if set, keys requests by the value of the header, like an
+	// API key, which is hashed before it reaches the store. Requests without
+	// the header, and all requests if it is not set, are keyed by client IP.
+	SourceHeader string `json:"sourceHeader,omitempty"`
+
+	// IPHeaders are checked for the client IP before the remote address, like
+	// "X-Forwarded-For", for Traefik instances behind another proxy.
+	IPHeaders []string `json:"ipHeaders,omitempty"`
+
+	// Tokens is the number of requests to allow per interval. The default
+	// value is 1.
+	Tokens uint64 `json:"tokens,omitempty"`
+
+	// Interval is the time interval upon which to enforce rate limiting, as a
+	// duration like "1m". The default value is 1 second.
+	Interval string `json:"interval,omitempty"`
+}
+
+// CreateConfig creates the default plugin configuration.
+func CreateConfig() *Config {
+	return &Config{
+		Tokens:   1,
+		Interval: "1s",
+	}
+}
+
+// New creates the middleware.
+func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
+	interval, err := time.ParseDuration(config.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid interval %q: %w", name, config.Interval, err)
+	}
+
+	var store limiter.Store
+	if config.RedisURL != "" {
+		store, err = redisstore.NewFromURL(config.RedisURL, &redisstore.Config{
+			Tokens:    config.Tokens,
+			Interval:  interval,
+			KeyPrefix: config.KeyPrefix,
+		})
+	} else {
+		store, err = memorystore.New(&memorystore.Config{
+			Tokens:   config.Tokens,
+			Interval: interval,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to create store: %w", name, err)
+	}
+
+	// Plugins have no Close method, so the store is closed when the context
+	// it was created with is done.
+	go func() {
+		<-ctx.Done()
+		store.Close()
+	}()
+
+	keyFunc := httplimit.IPKeyFunc(config.IPHeaders...)
+	if config.SourceHeader != "" {
+		keyFunc = httplimit.FirstKeyFunc(httplimit.HeaderKeyFunc(config.SourceHeader), keyFunc)
+	}
+
+	middleware, err := httplimit.NewMiddleware(store, keyFunc)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return middleware.Handle(next), nil
+}
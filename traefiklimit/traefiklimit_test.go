@@ -0,0 +1,64 @@
+package traefiklimit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sethvargo/go-limiter/traefiklimit"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := traefiklimit.CreateConfig()
+	config.Tokens = 2
+	config.Interval = "1m"
+	config.SourceHeader = "X-API-Key"
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h, err := traefiklimit.New(ctx, next, config, "limiter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(apiKey string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, tc := range []struct {
+		apiKey string
+		code   int
+	}{
+		{apiKey: "a", code: http.StatusOK},
+		{apiKey: "a", code: http.StatusOK},
+		{apiKey: "a", code: http.StatusTooManyRequests},
+		{apiKey: "b", code: http.StatusOK},
+		{apiKey: "", code: http.StatusOK},
+	} {
+		if got, want := request(tc.apiKey), tc.code; got != want {
+			t.Errorf("key %q: expected %d to be %d", tc.apiKey, got, want)
+		}
+	}
+}
+
+func TestNew_InvalidInterval(t *testing.T) {
+	t.Parallel()
+
+	config := traefiklimit.CreateConfig()
+	config.Interval = "soon"
+
+	if _, err := traefiklimit.New(context.Background(), http.NotFoundHandler(), config, "limiter"); err == nil {
+		t.Errorf("expected error for invalid interval")
+	}
+}
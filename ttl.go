@@ -0,0 +1,16 @@
+package limiter
+
+// TTLMode controls when a store expires a key it is no longer using.
+type TTLMode int
+
+const (
+	// TTLSliding is the default mode. The TTL is refreshed on every take, so a
+	// key expires once it has been idle for the TTL. This suits abuse keys,
+	// which should be remembered for as long as the client keeps trying.
+	TTLSliding TTLMode = iota
+
+	// TTLFixed expires a key relative to when it was first written, regardless
+	// of later takes. This suits calendar quotas, which should reset on a fixed
+	// schedule.
+	TTLFixed
+)
@@ -0,0 +1,193 @@
+package unixstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// Operations of a request.
+const (
+	opTake byte = iota + 1
+	opRefund
+	opCharge
+	opPeek
+	opAuth
+)
+
+// Statuses of a response.
+const (
+	statusOK byte = iota
+	statusError
+	statusStopped
+	statusNotSupported
+	statusUnauthorized
+)
+
+// maxKeySize is the largest key a request may have, so a corrupt length does
+// not allocate unbounded memory.
+const maxKeySize = 1 << 16
+
+// request is a call to the server's store. It is encoded as the operation, the
+// priority as a varint, the key length as a uvarint followed by the key, and
+// the tokens as a uvarint. An opAuth request carries the client's token as its
+// key.
+type request struct {
+	op       byte
+	priority limiter.Priority
+	key      string
+	tokens   uint64
+}
+
+func (r *request) write(w *bufio.Writer) error {
+	var buf [3*binary.MaxVarintLen64 + 1]byte
+	b := append(buf[:0], r.op)
+	b = appendVarint(b, int64(r.priority))
+	b = appendUvarint(b, uint64(len(r.key)))
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	if _, err := w.WriteString(r.key); err != nil {
+		return err
+	}
+	_, err := w.Write(appendUvarint(buf[:0], r.tokens))
+	return err
+}
+
+func readRequest(r *bufio.Reader) (*request, error) {
+	op, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	priority, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	key, err := readString(r)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	return &request{
+		op:       op,
+		priority: limiter.Priority(priority),
+		key:      key,
+		tokens:   tokens,
+	}, nil
+}
+
+// response is the answer to a request. It is encoded as the status, then for
+// statusOK the result, and for statusError the message as a string. Results
+// are encoded as the limit and remaining tokens as uvarints, the reset time in
+// unix nanoseconds as a varint, where 0 is the zero time, whether the take was
+// allowed as a byte, and the retry after as a varint.
+type response struct {
+	status  byte
+	result  limiter.Result
+	message string
+}
+
+func (r *response) write(w *bufio.Writer) error {
+	var buf [4*binary.MaxVarintLen64 + 2]byte
+	b := append(buf[:0], r.status)
+	switch r.status {
+	case statusOK:
+		var reset int64
+		if !r.result.ResetAt.IsZero() {
+			reset = r.result.ResetAt.UnixNano()
+		}
+		var allowed byte
+		if r.result.Allowed {
+			allowed = 1
+		}
+		b = appendUvarint(b, r.result.Limit)
+		b = appendUvarint(b, r.result.Remaining)
+		b = appendVarint(b, reset)
+		b = append(b, allowed)
+		b = appendVarint(b, int64(r.result.RetryAfter))
+	case statusError:
+		b = appendUvarint(b, uint64(len(r.message)))
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		_, err := w.WriteString(r.message)
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readResponse(r *bufio.Reader) (*response, error) {
+	status, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &response{status: status}
+	switch status {
+	case statusOK:
+		if resp.result.Limit, err = binary.ReadUvarint(r); err != nil {
+			return nil, err
+		}
+		if resp.result.Remaining, err = binary.ReadUvarint(r); err != nil {
+			return nil, err
+		}
+		reset, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if reset != 0 {
+			resp.result.ResetAt = time.Unix(0, reset)
+		}
+		allowed, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		resp.result.Allowed = allowed == 1
+		retryAfter, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		resp.result.RetryAfter = time.Duration(retryAfter)
+	case statusError:
+		if resp.message, err = readString(r); err != nil {
+			return nil, err
+		}
+	case statusStopped, statusNotSupported, statusUnauthorized:
+	default:
+		return nil, fmt.Errorf("unknown status %d", status)
+	}
+	return resp, nil
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > maxKeySize {
+		return "", fmt.Errorf("length %d exceeds %d", n, maxKeySize)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
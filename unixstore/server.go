@@ -0,0 +1,279 @@
+package unixstore
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// Ops is a set of the operations a client may call.
+type Ops uint8
+
+// Operations a client may be allowed.
+const (
+	OpTake Ops = 1 << iota
+	OpRefund
+	OpCharge
+	OpPeek
+
+	// AllOps allows every operation.
+	AllOps = OpTake | OpRefund | OpCharge | OpPeek
+)
+
+// opsOf maps the operations of requests to their sets.
+var opsOf = map[byte]Ops{
+	opTake:   OpTake,
+	opRefund: OpRefund,
+	opCharge: OpCharge,
+	opPeek:   OpPeek,
+}
+
+// Server serves a store to the processes that connect to it.
+//
+// By default, any process that can connect to the socket may call every
+// operation, so the socket's permissions are the only boundary. With Tokens,
+// each connection must first authenticate with a token, and may only call the
+// operations allowed to it.
+type Server struct {
+	// Tokens, if not nil, maps the tokens clients authenticate with to the
+	// operations they may call. Connections that have not authenticated may
+	// call nothing. It must be set before Serve is called.
+	Tokens map[string]Ops
+
+	store limiter.Store
+
+	lock      sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// NewServer creates a server for the store. The store is not closed with the
+// server.
+func NewServer(s limiter.Store) *Server {
+	return &Server{
+		store:     s,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Listen listens on the Unix socket at path. If a socket file is left at path
+// by a server that is no longer running, it is removed first.
+func Listen(path string) (net.Listener, error) {
+	l, err := net.Listen("unix", path)
+	if err == nil {
+		return l, nil
+	}
+
+	// Only remove the file if nothing answers on it.
+	conn, dialErr := net.DialTimeout("unix", path, time.Second)
+	if dialErr == nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	l, err = net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	return l, nil
+}
+
+// Serve accepts connections on the listener and serves each in its own
+// goroutine. It returns nil once the server is closed, and closes the listener.
+func (srv *Server) Serve(l net.Listener) error {
+	srv.lock.Lock()
+	if srv.closed {
+		srv.lock.Unlock()
+		l.Close()
+		return nil
+	}
+	srv.listeners[l] = struct{}{}
+	srv.lock.Unlock()
+
+	defer func() {
+		srv.lock.Lock()
+		delete(srv.listeners, l)
+		srv.lock.Unlock()
+		l.Close()
+	}()
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			srv.lock.Lock()
+			closed := srv.closed
+			srv.lock.Unlock()
+			if closed {
+				return nil
+			}
+
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				time.Sleep(delay)
+				continue
+			}
+			return fmt.Errorf("failed to accept: %w", err)
+		}
+		delay = 0
+
+		srv.lock.Lock()
+		if srv.closed {
+			srv.lock.Unlock()
+			conn.Close()
+			return nil
+		}
+		srv.conns[conn] = struct{}{}
+		srv.wg.Add(1)
+		srv.lock.Unlock()
+
+		go srv.serveConn(conn)
+	}
+}
+
+// Close stops the listeners and closes the connections, and waits for the
+// requests in flight to finish.
+func (srv *Server) Close() error {
+	srv.lock.Lock()
+	srv.closed = true
+	for l := range srv.listeners {
+		l.Close()
+	}
+	for conn := range srv.conns {
+		conn.Close()
+	}
+	srv.lock.Unlock()
+
+	srv.wg.Wait()
+	return nil
+}
+
+// serveConn answers the requests on the connection in order until it is
+// closed or a request is malformed.
+func (srv *Server) serveConn(conn net.Conn) {
+	defer func() {
+		srv.lock.Lock()
+		delete(srv.conns, conn)
+		srv.lock.Unlock()
+		conn.Close()
+		srv.wg.Done()
+	}()
+
+	allowed := AllOps
+	if srv.Tokens != nil {
+		allowed = 0
+	}
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		req, err := readRequest(r)
+		if err != nil {
+			return
+		}
+
+		var resp *response
+		if req.op == opAuth {
+			allowed = srv.authenticate(req.key)
+			resp = &response{status: statusOK}
+			if allowed == 0 {
+				resp.status = statusUnauthorized
+			}
+		} else if ops, ok := opsOf[req.op]; ok && allowed&ops == 0 {
+			resp = &response{status: statusUnauthorized}
+		} else {
+			resp = srv.handle(req)
+		}
+
+		if err := resp.write(w); err != nil {
+			return
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+		if resp.status == statusUnauthorized && req.op == opAuth {
+			return
+		}
+	}
+}
+
+// authenticate returns the operations allowed to the token, which are none if
+// it is not one of the server's tokens. Without tokens, every operation is
+// allowed.
+func (srv *Server) authenticate(token string) Ops {
+	if srv.Tokens == nil {
+		return AllOps
+	}
+
+	// Comparing hashes keeps the comparisons constant-time without leaking the
+	// tokens' lengths.
+	sum := sha256.Sum256([]byte(token))
+	var found Ops
+	for t, ops := range srv.Tokens {
+		other := sha256.Sum256([]byte(t))
+		if subtle.ConstantTimeCompare(sum[:], other[:]) == 1 {
+			found = ops
+		}
+	}
+	return found
+}
+
+// handle calls the store for the request.
+func (srv *Server) handle(req *request) *response {
+	ctx := limiter.WithPriority(context.Background(), req.priority)
+
+	var res limiter.Result
+	var err error
+	switch req.op {
+	case opTake:
+		res, err = srv.store.Take(ctx, req.key)
+	case opRefund:
+		err = limiter.ErrNotSupported
+		if r, ok := srv.store.(limiter.Refunder); ok {
+			err = r.Refund(ctx, req.key, req.tokens)
+		}
+	case opCharge:
+		err = limiter.ErrNotSupported
+		if c, ok := srv.store.(limiter.Charger); ok {
+			err = c.Charge(ctx, req.key, req.tokens)
+		}
+	case opPeek:
+		err = limiter.ErrNotSupported
+		if p, ok := srv.store.(limiter.Peeker); ok {
+			res, err = p.Peek(ctx, req.key)
+		}
+	default:
+		err = fmt.Errorf("unknown operation %d", req.op)
+	}
+
+	switch {
+	case err == nil:
+		return &response{status: statusOK, result: res}
+	case errors.Is(err, limiter.ErrStopped):
+		return &response{status: statusStopped}
+	case errors.Is(err, limiter.ErrNotSupported):
+		return &response{status: statusNotSupported}
+	default:
+		return &response{status: statusError, message: err.Error()}
+	}
+}
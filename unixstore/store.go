@@ -0,0 +1,301 @@
+// Package unixstore shares a single store between the processes on one host,
+// like prefork workers, over a Unix domain socket, so they enforce one set of
+// limits without an external service like Redis.
+//
+// One process serves its store, usually a memorystore, with a Server:
+//
+//	l, err := unixstore.Listen("/run/myapp/limiter.sock")
+//	srv := unixstore.NewServer(memStore)
+//	go srv.Serve(l)
+//
+// and every process, including that one, takes from it with a store created by
+// New:
+//
+//	store, err := unixstore.New(&unixstore.Config{
+//		Path: "/run/myapp/limiter.sock",
+//	})
+//
+// Each call is one round trip over the socket. The store forwards the optional
+// capabilities Refunder, Charger, and Peeker, which return
+// limiter.ErrNotSupported if the served store does not implement them.
+//
+// Any process that can connect to the socket may call the server, so the
+// socket's permissions decide who may take, and also who may refund or charge
+// any key. To tell the clients apart, set the server's Tokens, and each
+// client's Config.Token:
+//
+//	srv.Tokens = map[string]unixstore.Ops{
+//		"web-token":    unixstore.OpTake | unixstore.OpPeek,
+//		"worker-token": unixstore.AllOps,
+//	}
+//
+// Calls the client's token does not allow return ErrUnauthorized.
+package unixstore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+// ErrServer is wrapped by the errors the served store returned, as opposed to
+// errors reaching the server. The served store already decided those calls, so
+// they should not be retried.
+var ErrServer = errors.New("server error")
+
+// ErrUnauthorized is returned when the server rejected the client's token, or
+// the token does not allow the call.
+var ErrUnauthorized = errors.New("unauthorized")
+
+var _ limiter.Store = (*store)(nil)
+var _ limiter.Refunder = (*store)(nil)
+var _ limiter.Charger = (*store)(nil)
+var _ limiter.Peeker = (*store)(nil)
+
+// Config is used as input to New.
+type Config struct {
+	// Path is the path of the server's Unix socket. It is required.
+	Path string
+
+	// MaxIdleConns is the number of connections to the server kept open
+	// between calls. Concurrent calls beyond it open further connections,
+	// which are closed after use. The default value is 4.
+	MaxIdleConns int
+
+	// DialTimeout is the most time spent connecting to the server. The default
+	// value is 1 second.
+	DialTimeout time.Duration
+
+	// Token authenticates each connection to a server with Tokens. It is not
+	// sent if it is empty.
+	Token string
+}
+
+type store struct {
+	path        string
+	dialTimeout time.Duration
+	token       string
+
+	idle    chan *conn
+	stopped uint32
+}
+
+// conn is a connection to the server. Calls on it are made one at a time.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// New creates a store that forwards calls to the server at c.Path. It does
+// not connect until the first call, so the server may start later.
+func New(c *Config) (limiter.Store, error) {
+	if c == nil || c.Path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+
+	maxIdleConns := 4
+	if c.MaxIdleConns > 0 {
+		maxIdleConns = c.MaxIdleConns
+	}
+
+	dialTimeout := 1 * time.Second
+	if c.DialTimeout > 0 {
+		dialTimeout = c.DialTimeout
+	}
+
+	return &store{
+		path:        c.Path,
+		dialTimeout: dialTimeout,
+		token:       c.Token,
+		idle:        make(chan *conn, maxIdleConns),
+	}, nil
+}
+
+// Take takes a token from the key in the served store.
+func (s *store) Take(ctx context.Context, key string) (limiter.Result, error) {
+	res, err := s.call(ctx, &request{op: opTake, key: key})
+	if err != nil {
+		return limiter.Result{}, fmt.Errorf("failed to take: %w", err)
+	}
+	return res, nil
+}
+
+// Refund returns tokens to the key in the served store.
+func (s *store) Refund(ctx context.Context, key string, tokens uint64) error {
+	if _, err := s.call(ctx, &request{op: opRefund, key: key, tokens: tokens}); err != nil {
+		return fmt.Errorf("failed to refund: %w", err)
+	}
+	return nil
+}
+
+// Charge takes tokens from the key in the served store.
+func (s *store) Charge(ctx context.Context, key string, tokens uint64) error {
+	if _, err := s.call(ctx, &request{op: opCharge, key: key, tokens: tokens}); err != nil {
+		return fmt.Errorf("failed to charge: %w", err)
+	}
+	return nil
+}
+
+// Peek returns the state of the key in the served store.
+func (s *store) Peek(ctx context.Context, key string) (limiter.Result, error) {
+	res, err := s.call(ctx, &request{op: opPeek, key: key})
+	if err != nil {
+		return limiter.Result{}, fmt.Errorf("failed to peek: %w", err)
+	}
+	return res, nil
+}
+
+// Close closes the connections to the server. It does not stop the server or
+// its store.
+func (s *store) Close() error {
+	if atomic.CompareAndSwapUint32(&s.stopped, 0, 1) {
+		s.drain()
+	}
+	return nil
+}
+
+// drain closes the idle connections.
+func (s *store) drain() {
+	for {
+		select {
+		case c := <-s.idle:
+			c.Close()
+		default:
+			return
+		}
+	}
+}
+
+// call makes the request on an idle or new connection. A connection that
+// fails is closed instead of reused, since the response may be out of step
+// with the requests.
+func (s *store) call(ctx context.Context, req *request) (limiter.Result, error) {
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		return limiter.Result{}, limiter.ErrStopped
+	}
+	if err := ctx.Err(); err != nil {
+		return limiter.Result{}, err
+	}
+	req.priority = limiter.PriorityFromContext(ctx)
+
+	c, err := s.get(ctx)
+	if err != nil {
+		return limiter.Result{}, err
+	}
+
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		c.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return limiter.Result{}, ctxErr
+		}
+		return limiter.Result{}, err
+	}
+	s.put(c)
+
+	switch resp.status {
+	case statusStopped:
+		return limiter.Result{}, limiter.ErrStopped
+	case statusNotSupported:
+		return limiter.Result{}, limiter.ErrNotSupported
+	case statusUnauthorized:
+		return limiter.Result{}, ErrUnauthorized
+	case statusError:
+		return limiter.Result{}, fmt.Errorf("%w: %s", ErrServer, resp.message)
+	}
+	return resp.result, nil
+}
+
+// get returns an idle connection, or dials a new one and authenticates it.
+func (s *store) get(ctx context.Context) (*conn, error) {
+	select {
+	case c := <-s.idle:
+		return c, nil
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.dialTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "unix", s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	c := &conn{
+		Conn: nc,
+		r:    bufio.NewReader(nc),
+		w:    bufio.NewWriter(nc),
+	}
+
+	if s.token != "" {
+		resp, err := c.do(ctx, &request{op: opAuth, key: s.token})
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("failed to authenticate: %w", err)
+		}
+		if resp.status != statusOK {
+			c.Close()
+			return nil, ErrUnauthorized
+		}
+	}
+	return c, nil
+}
+
+// put returns the connection to the idle pool, or closes it if the pool is
+// full or the store was closed.
+func (s *store) put(c *conn) {
+	if atomic.LoadUint32(&s.stopped) == 0 {
+		select {
+		case s.idle <- c:
+			// Close may have drained the pool before the connection was added.
+			if atomic.LoadUint32(&s.stopped) == 1 {
+				s.drain()
+			}
+			return
+		default:
+		}
+	}
+	c.Close()
+}
+
+// do writes the request and reads the response, interrupting both if the
+// context is done.
+func (c *conn) do(ctx context.Context, req *request) (*response, error) {
+	deadline, _ := ctx.Deadline()
+	if err := c.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if done := ctx.Done(); done != nil {
+		stopCh := make(chan struct{})
+		exitCh := make(chan struct{})
+		defer func() {
+			close(stopCh)
+			<-exitCh
+		}()
+		go func() {
+			defer close(exitCh)
+			select {
+			case <-done:
+				c.SetDeadline(time.Unix(1, 0))
+			case <-stopCh:
+			}
+		}()
+	}
+
+	if err := req.write(c.w); err != nil {
+		return nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readResponse(c.r)
+}
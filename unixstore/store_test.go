@@ -0,0 +1,285 @@
+package unixstore_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/memorystore"
+	"github.com/sethvargo/go-limiter/storetest"
+	"github.com/sethvargo/go-limiter/unixstore"
+)
+
+// served is a client store that also stops its server and the served store
+// when it is closed.
+type served struct {
+	limiter.Decorated
+	closers []func() error
+}
+
+func (s *served) Close() error {
+	err := s.Store.Close()
+	for _, f := range s.closers {
+		f()
+	}
+	return err
+}
+
+// serve serves the store on a new socket and returns its path and a cleanup
+// function.
+func serve(tb testing.TB, ls limiter.Store) (string, func() error) {
+	tb.Helper()
+
+	dir, err := ioutil.TempDir("", "unixstore")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	path := filepath.Join(dir, "limiter.sock")
+
+	l, err := unixstore.Listen(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	srv := unixstore.NewServer(ls)
+	go srv.Serve(l)
+
+	return path, func() error {
+		srv.Close()
+		ls.Close()
+		return os.RemoveAll(dir)
+	}
+}
+
+func newClient(tb testing.TB, path string) limiter.Store {
+	tb.Helper()
+
+	s, err := unixstore.New(&unixstore.Config{Path: path})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+func TestStore_Conformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.TestStore(t, func(tb testing.TB, c *storetest.Config) limiter.Store {
+		ms, err := memorystore.New(&memorystore.Config{
+			Tokens:        c.Tokens,
+			Interval:      c.Interval,
+			SweepInterval: c.TTL,
+			SweepMinTTL:   c.TTL,
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		path, cleanup := serve(tb, ms)
+		s := &served{
+			Decorated: limiter.Decorated{Store: newClient(tb, path)},
+			closers:   []func() error{cleanup},
+		}
+		return limiter.Preserve(s.Store, s)
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	if _, err := unixstore.New(nil); err == nil {
+		t.Error("expected error for nil config")
+	}
+	if _, err := unixstore.New(&unixstore.Config{}); err == nil {
+		t.Error("expected error for missing path")
+	}
+}
+
+func TestStore_Shared(t *testing.T) {
+	t.Parallel()
+
+	ms, err := memorystore.New(&memorystore.Config{
+		Tokens:   10,
+		Interval: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, cleanup := serve(t, ms)
+	defer cleanup()
+
+	// Two processes share the served store.
+	a, b := newClient(t, path), newClient(t, path)
+	defer a.Close()
+	defer b.Close()
+
+	ctx := context.Background()
+	var allowed int
+	for i := 0; i < 10; i++ {
+		for _, s := range []limiter.Store{a, b} {
+			res, err := s.Take(ctx, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Allowed {
+				allowed++
+			}
+		}
+	}
+
+	if got, want := allowed, 10; got != want {
+		t.Errorf("expected %d allowed takes to be %d", got, want)
+	}
+}
+
+func TestStore_Errors(t *testing.T) {
+	t.Parallel()
+
+	type takeOnly struct{ limiter.Store }
+
+	ms, err := memorystore.New(&memorystore.Config{
+		Tokens:   10,
+		Interval: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, cleanup := serve(t, takeOnly{ms})
+	defer cleanup()
+
+	s := newClient(t, path)
+	ctx := context.Background()
+
+	if err := s.(limiter.Refunder).Refund(ctx, "key", 1); !errors.Is(err, limiter.ErrNotSupported) {
+		t.Errorf("expected %v to be %v", err, limiter.ErrNotSupported)
+	}
+
+	// The served store was stopped.
+	if err := ms.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Take(ctx, "key"); !errors.Is(err, limiter.ErrStopped) {
+		t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+
+	// The client was stopped.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Take(ctx, "key"); !errors.Is(err, limiter.ErrStopped) {
+		t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+}
+
+func TestServer_Tokens(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "unixstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "limiter.sock")
+
+	ms, err := memorystore.New(&memorystore.Config{
+		Tokens:   10,
+		Interval: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ms.Close()
+
+	l, err := unixstore.Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := unixstore.NewServer(ms)
+	srv.Tokens = map[string]unixstore.Ops{
+		"web":    unixstore.OpTake | unixstore.OpPeek,
+		"worker": unixstore.AllOps,
+	}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	cases := []struct {
+		name      string
+		token     string
+		takeErr   error
+		refundErr error
+	}{
+		{
+			name:      "none",
+			takeErr:   unixstore.ErrUnauthorized,
+			refundErr: unixstore.ErrUnauthorized,
+		},
+		{
+			name:      "unknown",
+			token:     "guess",
+			takeErr:   unixstore.ErrUnauthorized,
+			refundErr: unixstore.ErrUnauthorized,
+		},
+		{
+			name:      "take_only",
+			token:     "web",
+			refundErr: unixstore.ErrUnauthorized,
+		},
+		{
+			name:  "all",
+			token: "worker",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := unixstore.New(&unixstore.Config{Path: path, Token: tc.token})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			ctx := context.Background()
+			if _, err := s.Take(ctx, tc.name); !errors.Is(err, tc.takeErr) {
+				t.Errorf("expected %v to be %v", err, tc.takeErr)
+			}
+			if err := s.(limiter.Refunder).Refund(ctx, tc.name, 1); !errors.Is(err, tc.refundErr) {
+				t.Errorf("expected %v to be %v", err, tc.refundErr)
+			}
+		})
+	}
+}
+
+func TestListen(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "unixstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "limiter.sock")
+
+	// A socket file left by a server that exited is replaced.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := unixstore.Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// A running server is not replaced.
+	if _, err := unixstore.Listen(path); err == nil {
+		t.Error("expected error for socket in use")
+	}
+}
@@ -0,0 +1,287 @@
+// Package usage aggregates the consumption of each key of a limiter.Store over
+// fixed periods and writes it to a Sink, so billing and reporting can be
+// derived from the limiter's traffic instead of a separate metering system.
+//
+// Counts are kept in process and flushed at the end of each period, so every
+// instance of an application writes its own records. Sum the records of a key
+// and period across instances for its total usage.
+package usage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+var _ limiter.Store = (*Store)(nil)
+
+// Record is the usage of a key over one period.
+type Record struct {
+	// Key is the key.
+	Key string
+
+	// Start and End are the bounds of the period.
+	Start time.Time
+	End   time.Time
+
+	// Allowed, Denied, and Errors are the number of takes that were allowed,
+	// denied without an error, and failed with an error.
+	Allowed uint64
+	Denied  uint64
+	Errors  uint64
+
+	// Refunded is the number of tokens refunded through the store.
+	Refunded uint64
+
+	// Charged is the number of tokens charged through the store, on top of
+	// the token of each take, like the weighted costs of
+	// httplimit.HandleWithCost or the bytes of bandwidth.
+	Charged uint64
+}
+
+// Sink receives the records of each period.
+type Sink interface {
+	// Write writes the records. If it returns an error, the records are
+	// written again with the next period's.
+	Write(ctx context.Context, records []Record) error
+}
+
+// SinkFunc is a function that implements Sink.
+type SinkFunc func(ctx context.Context, records []Record) error
+
+// Write calls f.
+func (f SinkFunc) Write(ctx context.Context, records []Record) error {
+	return f(ctx, records)
+}
+
+// Config is used as input to New.
+type Config struct {
+	// Sink receives the records. It is required.
+	Sink Sink
+
+	// Period is the length of each period. The default value is 1 minute.
+	Period time.Duration
+
+	// MaxPending is the most records kept for writing again while the sink is
+	// failing. Once it is reached, the oldest records are dropped. The default
+	// value is 100000.
+	MaxPending int
+
+	// ErrorFunc is called with the errors returned by the sink. The default
+	// discards them.
+	ErrorFunc func(err error)
+}
+
+// counts is the usage of a key in the current period.
+type counts struct {
+	allowed, denied, errors, refunded, charged uint64
+}
+
+// Store wraps a limiter.Store and records the usage of every key. Its other
+// capabilities, like limiter.Refunder, are forwarded to the underlying store.
+type Store struct {
+	limiter.Decorated
+
+	sink       Sink
+	period     time.Duration
+	maxPending int
+	errorFunc  func(err error)
+
+	// lock guards the current period's counts and the pending records.
+	lock    sync.Mutex
+	start   time.Time
+	counts  map[string]*counts
+	pending []Record
+
+	// flushLock serializes writes to the sink.
+	flushLock sync.Mutex
+
+	stopCh    chan struct{}
+	doneCh    chan struct{}
+	closeOnce sync.Once
+}
+
+// New wraps the store to record its usage. Records are written to the sink at
+// the end of each period on a background goroutine, and once more by Close.
+func New(s limiter.Store, c *Config) (*Store, error) {
+	if s == nil {
+		return nil, fmt.Errorf("missing store")
+	}
+	if c == nil {
+		c = new(Config)
+	}
+	if c.Sink == nil {
+		return nil, fmt.Errorf("missing sink")
+	}
+
+	period := 1 * time.Minute
+	if c.Period > 0 {
+		period = c.Period
+	}
+
+	maxPending := 100000
+	if c.MaxPending > 0 {
+		maxPending = c.MaxPending
+	}
+
+	errorFunc := func(error) {}
+	if c.ErrorFunc != nil {
+		errorFunc = c.ErrorFunc
+	}
+
+	u := &Store{
+		Decorated:  limiter.Decorated{Store: s},
+		sink:       c.Sink,
+		period:     period,
+		maxPending: maxPending,
+		errorFunc:  errorFunc,
+		start:      time.Now(),
+		counts:     make(map[string]*counts),
+		stopCh:     make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+	go u.flushLoop()
+	return u, nil
+}
+
+// Take takes from the underlying store and counts the result.
+func (s *Store) Take(ctx context.Context, key string) (limiter.Result, error) {
+	res, err := s.Store.Take(ctx, key)
+
+	s.lock.Lock()
+	c := s.countsFor(key)
+	switch {
+	case err != nil:
+		c.errors++
+	case res.Allowed:
+		c.allowed++
+	default:
+		c.denied++
+	}
+	s.lock.Unlock()
+
+	return res, err
+}
+
+// Refund refunds the underlying store and counts the refunded tokens.
+func (s *Store) Refund(ctx context.Context, key string, tokens uint64) error {
+	if err := s.Decorated.Refund(ctx, key, tokens); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.countsFor(key).refunded += tokens
+	s.lock.Unlock()
+	return nil
+}
+
+// Charge charges the underlying store and counts the charged tokens.
+func (s *Store) Charge(ctx context.Context, key string, tokens uint64) error {
+	if err := s.Decorated.Charge(ctx, key, tokens); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.countsFor(key).charged += tokens
+	s.lock.Unlock()
+	return nil
+}
+
+// Flush ends the current period early and writes its records, and any that
+// failed to write before, to the sink.
+func (s *Store) Flush(ctx context.Context) error {
+	s.flushLock.Lock()
+	defer s.flushLock.Unlock()
+
+	now := time.Now()
+
+	s.lock.Lock()
+	records := s.pending
+	for key, c := range s.counts {
+		records = append(records, Record{
+			Key:      key,
+			Start:    s.start,
+			End:      now,
+			Allowed:  c.allowed,
+			Denied:   c.denied,
+			Errors:   c.errors,
+			Refunded: c.refunded,
+			Charged:  c.charged,
+		})
+	}
+	s.start = now
+	s.counts = make(map[string]*counts)
+	s.pending = nil
+	s.lock.Unlock()
+
+	if len(records) == 0 {
+		return nil
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if !records[i].Start.Equal(records[j].Start) {
+			return records[i].Start.Before(records[j].Start)
+		}
+		return records[i].Key < records[j].Key
+	})
+
+	if err := s.sink.Write(ctx, records); err != nil {
+		s.lock.Lock()
+		s.pending = append(records, s.pending...)
+		if over := len(s.pending) - s.maxPending; over > 0 {
+			s.pending = s.pending[over:]
+		}
+		s.lock.Unlock()
+		return fmt.Errorf("failed to write usage: %w", err)
+	}
+	return nil
+}
+
+// Close stops the background flushes, writes the last records, and closes the
+// underlying store. It returns the error from the last write, if any.
+func (s *Store) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stopCh)
+		<-s.doneCh
+
+		err = s.Flush(context.Background())
+		if cerr := s.Store.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
+
+// flushLoop flushes at the end of every period until the store is closed.
+func (s *Store) flushLoop() {
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			if err := s.Flush(context.Background()); err != nil {
+				s.errorFunc(err)
+			}
+		}
+	}
+}
+
+// countsFor returns the key's counts for the current period. It must be
+// called with the lock held.
+func (s *Store) countsFor(key string) *counts {
+	c, ok := s.counts[key]
+	if !ok {
+		c = new(counts)
+		s.counts[key] = c
+	}
+	return c
+}
@@ -0,0 +1,176 @@
+package usage_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/limittest"
+	"github.com/sethvargo/go-limiter/usage"
+)
+
+// recordingSink records the records written to it, and fails while err is
+// set.
+type recordingSink struct {
+	lock    sync.Mutex
+	err     error
+	records []usage.Record
+}
+
+func (s *recordingSink) Write(_ context.Context, records []usage.Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func (s *recordingSink) fail(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.err = err
+}
+
+// totals returns the records without their period bounds.
+func (s *recordingSink) totals() []usage.Record {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	records := make([]usage.Record, len(s.records))
+	for i, r := range s.records {
+		r.Start, r.End = time.Time{}, time.Time{}
+		records[i] = r
+	}
+	return records
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	if _, err := usage.New(nil, &usage.Config{Sink: new(recordingSink)}); err == nil {
+		t.Errorf("expected error for missing store")
+	}
+	if _, err := usage.New(limittest.NewStore(1, 0), nil); err == nil {
+		t.Errorf("expected error for missing sink")
+	}
+}
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := limittest.NewStore(2, time.Minute)
+	sink := new(recordingSink)
+	s, err := usage.New(store, &usage.Config{
+		Sink:   sink,
+		Period: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.Take(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Refund(ctx, "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Charge(ctx, "a", 3); err != nil {
+		t.Fatal(err)
+	}
+	store.Fail(errors.New("down"), true)
+	if _, err := s.Take(ctx, "b"); err == nil {
+		t.Fatal("expected error")
+	}
+	store.Fail(nil, false)
+
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := []usage.Record{
+		{Key: "a", Allowed: 2, Denied: 1, Refunded: 1, Charged: 3},
+		{Key: "b", Errors: 1},
+	}
+	if got := sink.totals(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %#v to be %#v", got, want)
+	}
+
+	sink.lock.Lock()
+	first := sink.records[0]
+	sink.lock.Unlock()
+	if !first.End.After(first.Start) {
+		t.Errorf("expected end %s to be after start %s", first.End, first.Start)
+	}
+
+	// Records that fail to write are written with the next period's.
+	sink.fail(errors.New("down"))
+	if _, err := s.Take(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+	sink.fail(nil)
+	if _, err := s.Take(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want,
+		usage.Record{Key: "c", Allowed: 1},
+		usage.Record{Key: "c", Allowed: 1},
+	)
+	if got := sink.totals(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after close: expected %#v to be %#v", got, want)
+	}
+
+	// Closing closes the underlying store.
+	if _, err := store.Take(ctx, "a"); !errors.Is(err, limiter.ErrStopped) {
+		t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+}
+
+func TestStore_Period(t *testing.T) {
+	t.Parallel()
+
+	written := make(chan []usage.Record, 1)
+	s, err := usage.New(limittest.NewStore(1, time.Minute), &usage.Config{
+		Sink: usage.SinkFunc(func(_ context.Context, records []usage.Record) error {
+			written <- records
+			return nil
+		}),
+		Period: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.Take(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case records := <-written:
+		if got, want := len(records), 1; got != want {
+			t.Fatalf("expected %d to be %d", got, want)
+		}
+		if got, want := records[0].Allowed, uint64(1); got != want {
+			t.Errorf("expected %d to be %d", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected records to be written at the end of the period")
+	}
+}
@@ -0,0 +1,55 @@
+package limiter
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// minWait is the shortest time Wait sleeps between takes, so that a store
+// which reports a reset time in the past does not cause a busy loop.
+const minWait = time.Millisecond
+
+// Wait takes a token from the given key, blocking until one is available or
+// the context is done. It is intended for background workers and job queues
+// that pace calls to external systems using a shared store, where delaying
+// the work is preferable to rejecting it.
+//
+// After an unsuccessful take, Wait sleeps until the returned reset time plus a
+// random jitter of up to 10% of the wait, so that many workers waiting on the
+// same key do not all retry at the same instant. If the context has a deadline
+// that would pass before the next attempt, Wait returns
+// context.DeadlineExceeded immediately instead of sleeping.
+//
+// Wait returns the result of the successful take. If the store returns an
+// error, Wait returns it along with the result immediately, even if the store
+// failed open.
+func Wait(ctx context.Context, s Store, key string) (Result, error) {
+	for {
+		res, err := s.Take(ctx, key)
+		if err != nil || res.Allowed {
+			return res, err
+		}
+
+		d := res.RetryAfter
+		if d <= 0 {
+			d = time.Until(res.ResetAt)
+		}
+		if d < minWait {
+			d = minWait
+		}
+		d += time.Duration(rand.Int63n(int64(d)/10 + 1))
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+			return res, context.DeadlineExceeded
+		}
+
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package limiter_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/memorystore"
+)
+
+func TestWait(t *testing.T) {
+	t.Parallel()
+
+	newStore := func(tb testing.TB) limiter.Store {
+		s, err := memorystore.New(&memorystore.Config{
+			Tokens:   2,
+			Interval: 200 * time.Millisecond,
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { s.Close() })
+		return s
+	}
+
+	t.Run("waits_for_reset", func(t *testing.T) {
+		t.Parallel()
+
+		s := newStore(t)
+		ctx := context.Background()
+
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			res, err := limiter.Wait(ctx, s, "key")
+			if err != nil {
+				t.Fatalf("wait %d: %v", i, err)
+			}
+			if !res.Allowed {
+				t.Fatalf("wait %d: expected to be allowed", i)
+			}
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("expected third wait to block until reset, took %s", elapsed)
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		t.Parallel()
+
+		s := newStore(t)
+		ctx := context.Background()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if res, err := limiter.Wait(ctx, s, "key"); !res.Allowed || err != nil {
+					t.Errorf("expected wait to succeed, got %t, %v", res.Allowed, err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		t.Parallel()
+
+		s := newStore(t)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		for i := 0; i < 2; i++ {
+			if _, err := limiter.Wait(ctx, s, "key"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		time.AfterFunc(20*time.Millisecond, cancel)
+		res, err := limiter.Wait(ctx, s, "key")
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %v to be %v", err, context.Canceled)
+		}
+		if res.Allowed {
+			t.Errorf("expected not to be allowed")
+		}
+	})
+
+	t.Run("deadline_before_reset", func(t *testing.T) {
+		t.Parallel()
+
+		s := newStore(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		for i := 0; i < 2; i++ {
+			if _, err := limiter.Wait(ctx, s, "key"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		start := time.Now()
+		if _, err := limiter.Wait(ctx, s, "key"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected %v to be %v", err, context.DeadlineExceeded)
+		}
+		if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+			t.Errorf("expected wait to return immediately, took %s", elapsed)
+		}
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		t.Parallel()
+
+		s := newStore(t)
+		s.Close()
+
+		if _, err := limiter.Wait(context.Background(), s, "key"); !errors.Is(err, limiter.ErrStopped) {
+			t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+		}
+	})
+}
@@ -0,0 +1,179 @@
+// Package webhook paces outbound webhook deliveries per destination endpoint,
+// so a sender with many subscribers neither overwhelms a slow receiver nor
+// lets one receiver's backlog hold up the others.
+//
+// A Sender is an http.RoundTripper. Each delivery waits for a token of its
+// endpoint, and when a receiver answers with a 429 or 503, the endpoint is
+// paused until the receiver's Retry-After, or with exponential backoff if it
+// did not send one:
+//
+//	sender, err := webhook.New(&webhook.Config{
+//		Store: store,
+//		Endpoints: map[string]limiter.Store{
+//			"https://hooks.example.com/ingest": fastStore,
+//		},
+//	})
+//	client := &http.Client{Transport: sender}
+package webhook
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/httplimit"
+)
+
+var _ http.RoundTripper = (*Sender)(nil)
+
+// Metric names reported to Config.Metrics.
+const (
+	// MetricDelivery counts deliveries, tagged with "result:delivered",
+	// "result:throttled" for 429s and 503s, "result:failed" for other error
+	// statuses, or "result:error" if there was no response.
+	MetricDelivery = "webhook.delivery"
+
+	// MetricDeliveryDuration is the time each delivery took, including the
+	// wait for its endpoint, tagged like MetricDelivery.
+	MetricDeliveryDuration = "webhook.delivery.duration"
+)
+
+// Config is used as input to New.
+type Config struct {
+	// Store paces the deliveries to each endpoint without an override. It is
+	// required.
+	Store limiter.Store
+
+	// Endpoints overrides the store of some endpoints, like receivers that
+	// asked for a higher or lower rate, by their Endpoint.
+	Endpoints map[string]limiter.Store
+
+	// Base sends the deliveries. The default value is http.DefaultTransport.
+	Base http.RoundTripper
+
+	// MinBackoff and MaxBackoff bound the pauses of endpoints that throttled
+	// a delivery without a Retry-After. The first pause is MinBackoff, and
+	// each consecutive one twice as long, up to MaxBackoff. The default values
+	// are 1 second and 5 minutes.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Metrics, if set, receives MetricDelivery and MetricDeliveryDuration,
+	// with Tags added to each. Endpoints are not tagged, since there may be
+	// too many of them.
+	Metrics limiter.MetricsSink
+	Tags    []string
+}
+
+// Sender paces webhook deliveries per endpoint.
+type Sender struct {
+	transport *httplimit.Transport
+	endpoints map[string]*httplimit.Transport
+	metrics   limiter.MetricsSink
+	tags      []string
+}
+
+// New creates a sender. It returns an error if the store is nil.
+func New(c *Config) (*Sender, error) {
+	if c == nil || c.Store == nil {
+		return nil, fmt.Errorf("store cannot be nil")
+	}
+
+	minBackoff := 1 * time.Second
+	if c.MinBackoff > 0 {
+		minBackoff = c.MinBackoff
+	}
+
+	newTransport := func(s limiter.Store) (*httplimit.Transport, error) {
+		return httplimit.NewTransport(s, &httplimit.TransportConfig{
+			Base: c.Base,
+			KeyFunc: func(r *http.Request) string {
+				return Endpoint(r.URL)
+			},
+			MinBackoff: minBackoff,
+			MaxBackoff: c.MaxBackoff,
+		})
+	}
+
+	transport, err := newTransport(c.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make(map[string]*httplimit.Transport, len(c.Endpoints))
+	for endpoint, s := range c.Endpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %q: %w", endpoint, err)
+		}
+		if s == nil {
+			return nil, fmt.Errorf("endpoint %q: store cannot be nil", endpoint)
+		}
+		t, err := newTransport(s)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %q: %w", endpoint, err)
+		}
+		endpoints[Endpoint(u)] = t
+	}
+
+	return &Sender{
+		transport: transport,
+		endpoints: endpoints,
+		metrics:   c.Metrics,
+		tags:      c.Tags,
+	}, nil
+}
+
+// Endpoint returns the endpoint of a delivery URL, its scheme, host, and path,
+// which deliveries are paced by. The query is not part of it, since senders
+// often put signatures or event IDs there.
+func Endpoint(u *url.URL) string {
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host) + u.EscapedPath()
+}
+
+// RoundTrip waits for a token of the request's endpoint and sends the
+// delivery. The response is returned as is; the delivery is not retried.
+func (s *Sender) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := s.transportFor(Endpoint(r.URL)).RoundTrip(r)
+
+	if s.metrics != nil {
+		result := "result:delivered"
+		switch {
+		case err != nil:
+			result = "result:error"
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+			result = "result:throttled"
+		case resp.StatusCode >= 400:
+			result = "result:failed"
+		}
+
+		tags := make([]string, 0, len(s.tags)+1)
+		tags = append(tags, s.tags...)
+		tags = append(tags, result)
+
+		s.metrics.Count(MetricDelivery, 1, tags)
+		s.metrics.Timing(MetricDeliveryDuration, time.Since(start), tags)
+	}
+	return resp, err
+}
+
+// Paused returns the time until which the endpoint is paused, or the zero time
+// if it is not.
+func (s *Sender) Paused(endpoint string) time.Time {
+	if u, err := url.Parse(endpoint); err == nil {
+		endpoint = Endpoint(u)
+	}
+	return s.transportFor(endpoint).Paused(endpoint)
+}
+
+// transportFor returns the transport of the endpoint.
+func (s *Sender) transportFor(endpoint string) *httplimit.Transport {
+	if t, ok := s.endpoints[endpoint]; ok {
+		return t
+	}
+	return s.transport
+}
@@ -0,0 +1,151 @@
+package webhook_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/limittest"
+	"github.com/sethvargo/go-limiter/memorystore"
+	"github.com/sethvargo/go-limiter/webhook"
+)
+
+// recordingSink records the counts it receives as "name|tags".
+type recordingSink struct {
+	lock    sync.Mutex
+	metrics []string
+}
+
+func (s *recordingSink) Count(name string, value int64, tags []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.metrics = append(s.metrics, name+"|"+strings.Join(tags, ","))
+}
+
+func (s *recordingSink) Timing(name string, d time.Duration, tags []string) {}
+
+func TestSender(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/busy":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	sink := new(recordingSink)
+	sender, err := webhook.New(&webhook.Config{
+		Store: limittest.NewStore(100, time.Hour),
+		Endpoints: map[string]limiter.Store{
+			srv.URL + "/slow": limittest.NewStore(1, time.Hour),
+		},
+		MinBackoff: time.Minute,
+		Metrics:    sink,
+		Tags:       []string{"service:hooks"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: sender}
+
+	deliver := func(path string) (*http.Response, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(r)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	// The override allows a single delivery, whatever the query, while other
+	// endpoints have the default store's tokens.
+	for _, path := range []string{"/slow?event=1", "/fast", "/fast"} {
+		if _, err := deliver(path); err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+	}
+	if _, err := deliver("/slow?event=2"); err == nil {
+		t.Error("expected the override to pace the endpoint")
+	}
+
+	// A 429 without a Retry-After pauses only its endpoint.
+	if _, err := deliver("/busy"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(sender.Paused(srv.URL + "/busy")); d <= 0 || d > time.Minute {
+		t.Errorf("expected a pause of up to a minute, got %s", d)
+	}
+	if !sender.Paused(srv.URL + "/fast").IsZero() {
+		t.Error("expected other endpoints not to be paused")
+	}
+	if _, err := deliver("/busy"); err == nil {
+		t.Error("expected the paused endpoint to be held back")
+	}
+
+	if _, err := deliver("/broken"); err != nil {
+		t.Fatal(err)
+	}
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	want := []string{
+		"webhook.delivery|service:hooks,result:delivered",
+		"webhook.delivery|service:hooks,result:delivered",
+		"webhook.delivery|service:hooks,result:delivered",
+		"webhook.delivery|service:hooks,result:error",
+		"webhook.delivery|service:hooks,result:throttled",
+		"webhook.delivery|service:hooks,result:error",
+		"webhook.delivery|service:hooks,result:failed",
+	}
+	if got := sink.metrics; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	t.Parallel()
+
+	u, err := url.Parse("HTTPS://Hooks.Example.com/a%2Fb/ingest?sig=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := webhook.Endpoint(u), "https://hooks.example.com/a%2Fb/ingest"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	store, err := memorystore.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := webhook.New(nil); err == nil {
+		t.Error("expected error for missing store")
+	}
+	if _, err := webhook.New(&webhook.Config{
+		Store:     store,
+		Endpoints: map[string]limiter.Store{"https://example.com/": nil},
+	}); err == nil {
+		t.Error("expected error for missing endpoint store")
+	}
+}
@@ -0,0 +1,94 @@
+// Package wslimit limits the rate of inbound WebSocket messages.
+//
+// It does not depend on a WebSocket library. ReadLoop reads with any Reader,
+// which *websocket.Conn from github.com/gorilla/websocket already is, and
+// closes the connection with a CloseFunc:
+//
+//	err := wslimit.ReadLoop(ctx, store, userID, conn,
+//		func(code int, reason string) error {
+//			msg := websocket.FormatCloseMessage(code, reason)
+//			return conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+//		},
+//		func(messageType int, data []byte) error {
+//			return handle(data)
+//		})
+//
+// Key the store by connection to limit each connection, or by user to share
+// the limit across all of a user's connections.
+package wslimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sethvargo/go-limiter"
+)
+
+const (
+	// ClosePolicyViolation is the close code sent when a connection exceeds
+	// its message rate.
+	ClosePolicyViolation = 1008
+
+	// CloseInternalError is the close code sent when the store fails closed.
+	CloseInternalError = 1011
+)
+
+// ErrLimited is returned by ReadLoop when the connection exceeds its message
+// rate.
+var ErrLimited = errors.New("message rate limit exceeded")
+
+// Reader reads WebSocket messages.
+type Reader interface {
+	// ReadMessage blocks until the next message, and returns its type and
+	// data. It returns an error once the connection is closed.
+	ReadMessage() (messageType int, data []byte, err error)
+}
+
+// ReaderFunc adapts a function to a Reader, for libraries with a different
+// read method.
+type ReaderFunc func() (messageType int, data []byte, err error)
+
+// ReadMessage calls f.
+func (f ReaderFunc) ReadMessage() (int, []byte, error) {
+	return f()
+}
+
+// CloseFunc sends a close frame with the code and reason, and closes the
+// connection.
+type CloseFunc func(code int, reason string) error
+
+// ReadLoop reads messages from r and passes them to handle, taking a token
+// from the key for each. When a take is denied, it closes the connection with
+// ClosePolicyViolation and returns ErrLimited. If the store fails closed, it
+// closes the connection with CloseInternalError and returns the store's error;
+// if it fails open, the message is handled.
+//
+// ReadLoop returns when reading or handling a message fails, with that error,
+// or when the context is done, with the context's error once the next read
+// returns.
+func ReadLoop(ctx context.Context, s limiter.Store, key string, r Reader, closeFn CloseFunc, handle func(messageType int, data []byte) error) error {
+	for {
+		messageType, data, err := r.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		res, err := s.Take(ctx, key)
+		if err != nil && !res.Allowed {
+			closeFn(CloseInternalError, "rate limiter unavailable")
+			return fmt.Errorf("failed to take: %w", err)
+		}
+		if !res.Allowed {
+			closeFn(ClosePolicyViolation, "message rate limit exceeded")
+			return ErrLimited
+		}
+
+		if err := handle(messageType, data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package wslimit_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/limittest"
+	"github.com/sethvargo/go-limiter/wslimit"
+)
+
+// messages returns a reader of n text messages, then io.EOF.
+func messages(n int) wslimit.Reader {
+	return wslimit.ReaderFunc(func() (int, []byte, error) {
+		if n == 0 {
+			return 0, nil, io.EOF
+		}
+		n--
+		return 1, []byte("hello"), nil
+	})
+}
+
+func TestReadLoop(t *testing.T) {
+	t.Parallel()
+
+	down := errors.New("down")
+
+	cases := []struct {
+		name     string
+		messages int
+		fail     error
+		failOpen bool
+		err      error
+		handled  int
+		code     int
+	}{
+		{
+			name:     "under_limit",
+			messages: 3,
+			err:      io.EOF,
+			handled:  3,
+		},
+		{
+			name:     "over_limit",
+			messages: 5,
+			err:      wslimit.ErrLimited,
+			handled:  3,
+			code:     wslimit.ClosePolicyViolation,
+		},
+		{
+			name:     "fail_closed",
+			messages: 1,
+			fail:     down,
+			err:      down,
+			code:     wslimit.CloseInternalError,
+		},
+		{
+			name:     "fail_open",
+			messages: 5,
+			fail:     down,
+			failOpen: true,
+			err:      io.EOF,
+			handled:  5,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := limittest.NewStore(3, time.Minute)
+			if tc.fail != nil {
+				store.Fail(tc.fail, tc.failOpen)
+			}
+
+			var code, handled int
+			err := wslimit.ReadLoop(context.Background(), store, "conn", messages(tc.messages),
+				func(c int, reason string) error {
+					code = c
+					return nil
+				},
+				func(messageType int, data []byte) error {
+					handled++
+					return nil
+				})
+			if !errors.Is(err, tc.err) {
+				t.Errorf("expected %v to be %v", err, tc.err)
+			}
+			if got, want := handled, tc.handled; got != want {
+				t.Errorf("handled: expected %d to be %d", got, want)
+			}
+			if got, want := code, tc.code; got != want {
+				t.Errorf("close code: expected %d to be %d", got, want)
+			}
+		})
+	}
+}
+
+func TestReadLoop_Stopped(t *testing.T) {
+	t.Parallel()
+
+	store := limittest.NewStore(3, time.Minute)
+	store.Close()
+
+	err := wslimit.ReadLoop(context.Background(), store, "conn", messages(1),
+		func(int, string) error { return nil },
+		func(int, []byte) error { return nil })
+	if !errors.Is(err, limiter.ErrStopped) {
+		t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+}
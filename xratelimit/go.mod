@@ -0,0 +1,10 @@
+module github.com/sethvargo/go-limiter/xratelimit
+
+go 1.20
+
+require (
+	github.com/sethvargo/go-limiter v0.1.0
+	golang.org/x/time v0.5.0
+)
+
+replace github.com/sethvargo/go-limiter => ../
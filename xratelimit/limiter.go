@@ -0,0 +1,147 @@
+// Package xratelimit adapts between limiter.Store and the Limiter of
+// golang.org/x/time/rate, so code written against either API can use the
+// other. It is a separate module, so the core module keeps no dependencies.
+//
+// NewLimiter exposes a store, which may be distributed, through the methods of
+// *rate.Limiter, so code that accepts a RateLimiter can switch from a
+// per-process limiter to a store without rewrites:
+//
+//	var lim xratelimit.RateLimiter = rate.NewLimiter(rate.Every(time.Second), 10)
+//	lim = xratelimit.NewLimiter(redisStore, "github-api", 10, 10*time.Second)
+//
+// NewStore does the opposite, and implements limiter.Store with a
+// *rate.Limiter per key.
+package xratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter is the subset of the methods of *rate.Limiter that Limiter
+// implements. Accept it instead of *rate.Limiter to allow either.
+type RateLimiter interface {
+	Allow() bool
+	AllowN(t time.Time, n int) bool
+	Wait(ctx context.Context) error
+	WaitN(ctx context.Context, n int) error
+	Limit() rate.Limit
+	Burst() int
+}
+
+var _ RateLimiter = (*rate.Limiter)(nil)
+var _ RateLimiter = (*Limiter)(nil)
+
+// Limiter limits a single key of a store with the methods of *rate.Limiter.
+// Reservations are not supported.
+type Limiter struct {
+	store    limiter.Store
+	key      string
+	tokens   uint64
+	interval time.Duration
+}
+
+// NewLimiter creates a limiter for the key of the store. tokens and interval
+// must be the limit the store was configured with. They are only reported by
+// Limit and Burst, and bound WaitN, since the store enforces its own limit.
+func NewLimiter(s limiter.Store, key string, tokens uint64, interval time.Duration) *Limiter {
+	return &Limiter{
+		store:    s,
+		key:      key,
+		tokens:   tokens,
+		interval: interval,
+	}
+}
+
+// Limit returns the average rate of the store's limit.
+func (l *Limiter) Limit() rate.Limit {
+	if l.tokens == 0 || l.interval <= 0 {
+		return 0
+	}
+	return rate.Limit(float64(l.tokens) / l.interval.Seconds())
+}
+
+// Burst returns the tokens per interval of the store's limit.
+func (l *Limiter) Burst() int {
+	return int(l.tokens)
+}
+
+// Allow reports whether a token could be taken from the key.
+func (l *Limiter) Allow() bool {
+	return l.AllowN(time.Now(), 1)
+}
+
+// AllowN reports whether n tokens could be taken from the key, and takes them
+// if so. The store uses its own clock, so t is ignored. For n greater than 1,
+// the store must implement limiter.Charger and limiter.Refunder, otherwise
+// AllowN returns false. Errors from the store deny the take, unless the store
+// failed open.
+func (l *Limiter) AllowN(_ time.Time, n int) bool {
+	if n <= 0 {
+		return true
+	}
+
+	ctx := context.Background()
+	res, err := l.store.Take(ctx, l.key)
+	if err != nil || !res.Allowed || n == 1 {
+		return res.Allowed
+	}
+
+	charger, ok := l.store.(limiter.Charger)
+	if ok && res.Remaining+1 >= uint64(n) {
+		return charger.Charge(ctx, l.key, uint64(n-1)) == nil
+	}
+
+	// There are not enough tokens, so return the one that was taken.
+	if r, ok := l.store.(limiter.Refunder); ok {
+		_ = r.Refund(ctx, l.key, 1)
+	}
+	return false
+}
+
+// Wait blocks until a token is taken from the key or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n tokens are taken from the key or ctx is done. Tokens
+// are taken as they become available, so they are not returned if ctx is done
+// first. It returns an error if n exceeds the burst. For n greater than 1, the
+// store must implement limiter.Charger.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if n > l.Burst() && l.Limit() != rate.Inf {
+		return fmt.Errorf("xratelimit: Wait(n=%d) exceeds limiter's burst %d", n, l.Burst())
+	}
+
+	charger, ok := l.store.(limiter.Charger)
+	if n > 1 && !ok {
+		return limiter.ErrNotSupported
+	}
+
+	for need := uint64(n); need > 0; {
+		res, err := limiter.Wait(ctx, l.store, l.key)
+		if err != nil {
+			if res.Allowed {
+				// The store failed open.
+				return nil
+			}
+			return err
+		}
+
+		got := res.Remaining + 1
+		if got > need {
+			got = need
+		}
+		if got > 1 {
+			if err := charger.Charge(ctx, l.key, got-1); err != nil {
+				return err
+			}
+		}
+		need -= got
+	}
+	return nil
+}
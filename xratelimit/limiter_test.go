@@ -0,0 +1,72 @@
+package xratelimit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter/limittest"
+	"github.com/sethvargo/go-limiter/xratelimit"
+	"golang.org/x/time/rate"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	t.Parallel()
+
+	store := limittest.NewStore(5, time.Minute)
+	lim := xratelimit.NewLimiter(store, "key", 5, time.Minute)
+
+	if got, want := lim.Burst(), 5; got != want {
+		t.Errorf("burst: expected %d to be %d", got, want)
+	}
+	if got, want := lim.Limit(), rate.Every(12*time.Second); got != want {
+		t.Errorf("limit: expected %v to be %v", got, want)
+	}
+
+	if !lim.AllowN(time.Now(), 3) {
+		t.Fatal("expected 3 tokens to be allowed")
+	}
+	if lim.AllowN(time.Now(), 3) {
+		t.Fatal("expected 3 more tokens to be denied")
+	}
+
+	// The denied take returned its token.
+	if !lim.Allow() || !lim.Allow() {
+		t.Fatal("expected the remaining tokens to be allowed")
+	}
+	if lim.Allow() {
+		t.Fatal("expected the key to be exhausted")
+	}
+}
+
+func TestLimiter_WaitN(t *testing.T) {
+	t.Parallel()
+
+	store := limittest.NewStore(5, time.Minute)
+	lim := xratelimit.NewLimiter(store, "key", 5, time.Minute)
+
+	ctx := context.Background()
+	if err := lim.WaitN(ctx, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := lim.WaitN(ctx, 6); err == nil {
+		t.Error("expected error for n over the burst")
+	}
+
+	// Only 1 token remains, so the wait blocks until the deadline.
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := lim.WaitN(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v to be %v", err, context.DeadlineExceeded)
+	}
+
+	// After the refill, the wait takes the remaining token.
+	store.Advance(time.Minute)
+	if err := lim.WaitN(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := store.Takes("key"), uint64(4); got != want {
+		t.Errorf("takes: expected %d to be %d", got, want)
+	}
+}
@@ -0,0 +1,147 @@
+package xratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"golang.org/x/time/rate"
+)
+
+var _ limiter.Store = (*store)(nil)
+
+// Config is used as input to NewStore.
+type Config struct {
+	// Limit is the rate at which each key's tokens are refilled. It is
+	// required; use rate.Inf to allow every take.
+	Limit rate.Limit
+
+	// Burst is the most tokens each key can hold. The default value is 1.
+	Burst int
+
+	// SweepInterval is how often keys with full buckets are forgotten, which
+	// does not change their limits. The default value is 1 minute.
+	SweepInterval time.Duration
+}
+
+type store struct {
+	limit rate.Limit
+	burst int
+
+	lock     sync.Mutex
+	limiters map[string]*rate.Limiter
+	stopped  bool
+	stopCh   chan struct{}
+}
+
+// NewStore creates a store that limits each key with a *rate.Limiter, which
+// refills tokens continuously rather than once per interval. The store is in
+// memory, so the limits are per process.
+func NewStore(c *Config) (limiter.Store, error) {
+	if c == nil {
+		c = new(Config)
+	}
+	if c.Limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive")
+	}
+	if c.Burst < 0 {
+		return nil, fmt.Errorf("burst cannot be negative")
+	}
+
+	burst := 1
+	if c.Burst > 0 {
+		burst = c.Burst
+	}
+
+	sweepInterval := time.Minute
+	if c.SweepInterval > 0 {
+		sweepInterval = c.SweepInterval
+	}
+
+	s := &store{
+		limit:    c.Limit,
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+		stopCh:   make(chan struct{}),
+	}
+	go s.sweep(sweepInterval)
+	return s, nil
+}
+
+// Take takes a token from the key's limiter. The reset time is when the key
+// next gains a whole token.
+func (s *store) Take(_ context.Context, key string) (limiter.Result, error) {
+	s.lock.Lock()
+	if s.stopped {
+		s.lock.Unlock()
+		return limiter.Result{}, limiter.ErrStopped
+	}
+	lim, ok := s.limiters[key]
+	if !ok {
+		lim = rate.NewLimiter(s.limit, s.burst)
+		s.limiters[key] = lim
+	}
+	s.lock.Unlock()
+
+	now := time.Now()
+	allowed := lim.AllowN(now, 1)
+	tokens := math.Max(lim.TokensAt(now), 0)
+
+	res := limiter.Result{
+		Limit:     uint64(s.burst),
+		Remaining: uint64(tokens),
+		ResetAt:   now,
+		Allowed:   allowed,
+	}
+	if s.limit == rate.Inf {
+		return res, nil
+	}
+	if tokens < float64(s.burst) {
+		next := 1 - (tokens - math.Floor(tokens))
+		res.ResetAt = now.Add(time.Duration(next / float64(s.limit) * float64(time.Second)))
+	}
+	if !allowed {
+		res.RetryAfter = res.ResetAt.Sub(now)
+	}
+	return res, nil
+}
+
+// Close stops the store. Later takes return limiter.ErrStopped.
+func (s *store) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.stopped {
+		s.stopped = true
+		close(s.stopCh)
+		s.limiters = nil
+	}
+	return nil
+}
+
+// sweep forgets the keys whose buckets are full, since a new limiter for them
+// would be the same.
+func (s *store) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		s.lock.Lock()
+		for key, lim := range s.limiters {
+			if lim.TokensAt(now) >= float64(s.burst) {
+				delete(s.limiters, key)
+			}
+		}
+		s.lock.Unlock()
+	}
+}
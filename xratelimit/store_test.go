@@ -0,0 +1,76 @@
+package xratelimit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/xratelimit"
+	"golang.org/x/time/rate"
+)
+
+func TestNewStore(t *testing.T) {
+	t.Parallel()
+
+	if _, err := xratelimit.NewStore(nil); err == nil {
+		t.Error("expected error for missing limit")
+	}
+	if _, err := xratelimit.NewStore(&xratelimit.Config{Limit: 1, Burst: -1}); err == nil {
+		t.Error("expected error for negative burst")
+	}
+}
+
+func TestStore_Take(t *testing.T) {
+	t.Parallel()
+
+	s, err := xratelimit.NewStore(&xratelimit.Config{
+		Limit: rate.Every(time.Minute),
+		Burst: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		res, err := s.Take(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			t.Fatalf("take %d: expected to be allowed", i)
+		}
+		if got, want := res.Remaining, uint64(2-i); got != want {
+			t.Errorf("take %d: expected %d to be %d", i, got, want)
+		}
+		if got, want := res.Limit, uint64(3); got != want {
+			t.Errorf("take %d: expected %d to be %d", i, got, want)
+		}
+	}
+
+	res, err := s.Take(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed {
+		t.Fatal("expected take to be denied")
+	}
+	if res.RetryAfter <= 0 || res.RetryAfter > time.Minute {
+		t.Errorf("expected retry after %s to be within a minute", res.RetryAfter)
+	}
+
+	// Other keys have their own limiter.
+	if res, err := s.Take(ctx, "other"); err != nil || !res.Allowed {
+		t.Errorf("expected other key to be allowed: %v", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Take(ctx, "key"); !errors.Is(err, limiter.ErrStopped) {
+		t.Errorf("expected %v to be %v", err, limiter.ErrStopped)
+	}
+}